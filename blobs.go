@@ -212,6 +212,9 @@ type CreateOptions struct {
 		// Blob access check will be skipped if set.
 		Stat *Descriptor
 	}
+	// Algorithm is the digest algorithm the blob is hashed with as it is
+	// written, and stored under. The canonical algorithm is used if empty.
+	Algorithm digest.Algorithm
 }
 
 // BlobWriter provides a handle for inserting data into a blob store.
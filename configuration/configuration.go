@@ -26,6 +26,19 @@ type Configuration struct {
 		AccessLog struct {
 			// Disabled disables access logging.
 			Disabled bool `yaml:"disabled,omitempty"`
+
+			// Output configures the destination of the access log. Defaults
+			// to stdout.
+			Output LogOutput `yaml:"output,omitempty"`
+
+			// Formatter selects the format of the access log. Options are
+			// "combined", the default Apache combined log format, and
+			// "json".
+			Formatter string `yaml:"formatter,omitempty"`
+
+			// Fields lists static fields added to every entry of JSON
+			// access logs.
+			Fields map[string]interface{} `yaml:"fields,omitempty"`
 		} `yaml:"accesslog,omitempty"`
 
 		// Level is the granularity at which registry operations are logged.
@@ -45,6 +58,10 @@ type Configuration struct {
 
 		// ReportCaller allows user to configure the log to report the caller
 		ReportCaller bool `yaml:"reportcaller,omitempty"`
+
+		// Output configures the destination of the application log.
+		// Defaults to stderr.
+		Output LogOutput `yaml:"output,omitempty"`
 	}
 
 	// Loglevel is the level at which registry operations are logged.
@@ -87,6 +104,13 @@ type Configuration struct {
 		// Location headers
 		RelativeURLs bool `yaml:"relativeurls,omitempty"`
 
+		// ErrorDetail controls the detail included in error responses:
+		// "full" (the default) includes the detail of all errors, "minimal"
+		// omits the detail of server errors, such as storage driver errors,
+		// and "none" omits the detail of all errors. Details are logged in
+		// full regardless.
+		ErrorDetail string `yaml:"errordetail,omitempty"`
+
 		// Amount of time to wait for connection to drain before shutting down when registry
 		// receives a stop signal
 		DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
@@ -116,6 +140,11 @@ type Configuration struct {
 			// Specifies a list of cipher suites allowed
 			CipherSuites []string `yaml:"ciphersuites,omitempty"`
 
+			// ReloadInterval is how often the certificate and key files
+			// are checked for changes. The certificate is loaded again
+			// for new connections when they are replaced.
+			ReloadInterval time.Duration `yaml:"reloadinterval,omitempty"`
+
 			// LetsEncrypt is used to configuration setting up TLS through
 			// Let's Encrypt instead of manually specifying certificate and
 			// key. If a TLS certificate is specified, the Let's Encrypt
@@ -144,6 +173,11 @@ type Configuration struct {
 		// the values are the associated header payloads.
 		Headers http.Header `yaml:"headers,omitempty"`
 
+		// RouteHeaders is a list of header sets to include in HTTP responses
+		// of specific routes, in addition to Headers. A common use case for
+		// this would be a long lived Cache-Control header on blob downloads.
+		RouteHeaders []RouteHeaders `yaml:"routeheaders,omitempty"`
+
 		// Debug configures the http debug interface, if specified. This can
 		// include services such as pprof, expvar and other data that should
 		// not be exposed externally. Left disabled by default.
@@ -155,8 +189,122 @@ type Configuration struct {
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			// Auth requires clients of the debug server to authenticate.
+			Auth DebugAuth `yaml:"auth,omitempty"`
+			// TLS serves the debug server over TLS, optionally requiring
+			// client certificates.
+			TLS DebugTLS `yaml:"tls,omitempty"`
+			// RateLimit limits the rate of requests to the debug server.
+			RateLimit DebugRateLimit `yaml:"ratelimit,omitempty"`
 		} `yaml:"debug,omitempty"`
 
+		// CORS configures cross-origin resource sharing, allowing browser
+		// based clients served from other origins to call the API. Left
+		// disabled by default.
+		CORS struct {
+			// AllowedOrigins lists the origins allowed to make cross-origin
+			// requests. CORS is enabled when this list is not empty. "*"
+			// allows any origin.
+			AllowedOrigins []string `yaml:"allowedorigins,omitempty"`
+			// AllowedMethods lists the methods allowed in cross-origin
+			// requests. Defaults to all methods used by the API.
+			AllowedMethods []string `yaml:"allowedmethods,omitempty"`
+			// AllowedHeaders lists the request headers allowed in
+			// cross-origin requests, in addition to Accept,
+			// Accept-Language and Content-Language.
+			AllowedHeaders []string `yaml:"allowedheaders,omitempty"`
+			// ExposedHeaders lists the response headers which browsers may
+			// expose to clients.
+			ExposedHeaders []string `yaml:"exposedheaders,omitempty"`
+			// AllowCredentials allows cross-origin requests to include
+			// credentials.
+			AllowCredentials bool `yaml:"allowcredentials,omitempty"`
+			// MaxAge is the duration for which the result of a preflight
+			// request may be cached, up to 10 minutes.
+			MaxAge time.Duration `yaml:"maxage,omitempty"`
+		} `yaml:"cors,omitempty"`
+
+		// ProxyProtocol configures parsing of the PROXY protocol header sent
+		// by load balancers, which carries the address of the client.
+		ProxyProtocol struct {
+			// Enabled expects a PROXY protocol v1 or v2 header on accepted
+			// connections. If TrustedProxies is not empty, the header is
+			// only expected on connections from trusted proxies.
+			Enabled bool `yaml:"enabled,omitempty"`
+			// Timeout is the amount of time allowed to read the header.
+			Timeout time.Duration `yaml:"timeout,omitempty"`
+		} `yaml:"proxyprotocol,omitempty"`
+
+		// TrustedProxies is a list of CIDRs or IP addresses of proxies
+		// trusted to set the X-Forwarded-For and X-Real-Ip headers. If
+		// empty, these headers are honored on all requests.
+		TrustedProxies []string `yaml:"trustedproxies,omitempty"`
+
+		// Timeouts configures the timeouts of the http server. The read and
+		// write timeouts may be configured separately for blob routes, which
+		// may need to transfer large payloads, and for all other routes.
+		Timeouts struct {
+			// ReadHeader is the amount of time allowed to read request
+			// headers.
+			ReadHeader time.Duration `yaml:"readheader,omitempty"`
+			// Idle is the maximum amount of time to wait for the next request
+			// when keep-alives are enabled.
+			Idle time.Duration `yaml:"idle,omitempty"`
+			// Default configures the timeouts of routes other than blob
+			// routes.
+			Default RouteTimeouts `yaml:"default,omitempty"`
+			// Blobs configures the timeouts of blob download and upload
+			// routes.
+			Blobs RouteTimeouts `yaml:"blobs,omitempty"`
+		} `yaml:"timeouts,omitempty"`
+
+		// BodyLimits configures the maximum size of request bodies and of
+		// pushed blobs.
+		BodyLimits struct {
+			// Manifests is the maximum size in bytes of manifest bodies.
+			// Defaults to 4MiB.
+			Manifests int64 `yaml:"manifests,omitempty"`
+			// Blobs is the maximum size in bytes of pushed blobs, whether
+			// uploaded at once or in chunks. A value of zero disables the
+			// limit.
+			Blobs int64 `yaml:"blobs,omitempty"`
+			// Default is the maximum size in bytes of request bodies on all
+			// other non-blob upload routes. A value of zero disables the
+			// limit.
+			Default int64 `yaml:"default,omitempty"`
+		} `yaml:"bodylimits,omitempty"`
+
+		// ConcurrencyLimit configures a cap on the number of requests that
+		// are served concurrently. Requests in excess of the cap are shed
+		// with a 503 response. Left disabled by default.
+		ConcurrencyLimit struct {
+			// MaxRequests is the maximum number of requests in flight. A
+			// value of zero disables the global limit.
+			MaxRequests int `yaml:"maxrequests,omitempty"`
+			// MaxReads is the maximum number of GET and HEAD requests in
+			// flight. A value of zero disables the read limit.
+			MaxReads int `yaml:"maxreads,omitempty"`
+			// MaxWrites is the maximum number of requests with any other
+			// method in flight. A value of zero disables the write limit.
+			MaxWrites int `yaml:"maxwrites,omitempty"`
+			// RetryAfter is the duration suggested to clients in the
+			// Retry-After header of shed requests.
+			RetryAfter time.Duration `yaml:"retryafter,omitempty"`
+		} `yaml:"concurrencylimit,omitempty"`
+
+		// BandwidthClasses throttle the blob downloads of the clients they
+		// match. A download is throttled by the first matching class.
+		BandwidthClasses []BandwidthClass `yaml:"bandwidthclasses,omitempty"`
+
+		// DownloadLimits cap the blob downloads of the repositories they
+		// match. The downloads of a repository are limited by the first
+		// matching entry.
+		DownloadLimits []DownloadLimit `yaml:"downloadlimits,omitempty"`
+
+		// RateLimit limits the rate of requests of each client, user and
+		// repository.
+		RateLimit RateLimit `yaml:"ratelimit,omitempty"`
+
 		// HTTP2 configuration options
 		HTTP2 struct {
 			// Specifies whether the registry should disallow clients attempting
@@ -169,11 +317,80 @@ type Configuration struct {
 	// registry events are dispatched.
 	Notifications Notifications `yaml:"notifications,omitempty"`
 
+	// Tracing configures OpenTelemetry distributed tracing.
+	Tracing Tracing `yaml:"tracing,omitempty"`
+
+	// Metrics configures the export of metrics to external collectors.
+	Metrics Metrics `yaml:"metrics,omitempty"`
+
+	// Admin configures the administrative API served under /admin/.
+	Admin Admin `yaml:"admin,omitempty"`
+
+	// UI configures the read-only web UI served on the main listener.
+	UI UI `yaml:"ui,omitempty"`
+
+	// Maintenance configures the maintenance mode of the registry.
+	Maintenance Maintenance `yaml:"maintenance,omitempty"`
+
+	// Tenants maps repository namespace prefixes to storage of their own.
+	Tenants []Tenant `yaml:"tenants,omitempty"`
+
+	// Federation lists the repository namespaces served by other registries.
+	Federation []FederatedNamespace `yaml:"federation,omitempty"`
+
+	// Replication configures the replication of pushes and deletes to other
+	// registries.
+	Replication Replication `yaml:"replication,omitempty"`
+
+	// Scrub configures the background verification of the blobs in storage.
+	Scrub Scrub `yaml:"scrub,omitempty"`
+
+	// GC configures online garbage collection.
+	GC GC `yaml:"gc,omitempty"`
+
+	// Uploads configures the lifetime of blob upload sessions.
+	Uploads Uploads `yaml:"uploads,omitempty"`
+
+	// Schedule configures the maintenance tasks run at the times given by
+	// cron expressions.
+	Schedule Schedule `yaml:"schedule,omitempty"`
+
+	// Digests configures the digest algorithms blobs may be addressed by.
+	Digests Digests `yaml:"digests,omitempty"`
+
+	// Mirrors lists the alternative endpoints clients may pull from,
+	// advertised by the mirror discovery endpoint.
+	Mirrors []Mirror `yaml:"mirrors,omitempty"`
+
+	// Transparency configures the submission of pushed manifests to a
+	// transparency log.
+	Transparency Transparency `yaml:"transparency,omitempty"`
+
+	// Quotas limits the storage used by repositories and namespaces.
+	Quotas Quotas `yaml:"quotas,omitempty"`
+
+	// Usage configures the accounting of the storage used by repositories.
+	Usage Usage `yaml:"usage,omitempty"`
+
+	// TagProtection makes the tags matching its rules immutable.
+	TagProtection TagProtection `yaml:"tagprotection,omitempty"`
+
+	// Retention configures the removal of the old tags of repositories.
+	Retention Retention `yaml:"retention,omitempty"`
+
 	// Redis configures the redis pool available to the registry webapp.
 	Redis struct {
 		// Addr specifies the the redis instance available to the application.
 		Addr string `yaml:"addr,omitempty"`
 
+		// Cluster configures a Redis Cluster to use instead of a single
+		// instance.
+		Cluster RedisCluster `yaml:"cluster,omitempty"`
+
+		// Sentinel configures the Redis Sentinel deployment the master to
+		// use is looked up from, instead of a single instance.
+		Sentinel RedisSentinel `yaml:"sentinel,omitempty"`
+
 		// Usernames can be used as a finer-grained permission control since the introduction of the redis 6.0.
 		Username string `yaml:"username,omitempty"`
 
@@ -210,6 +427,33 @@ type Configuration struct {
 	Health  Health  `yaml:"health,omitempty"`
 	Catalog Catalog `yaml:"catalog,omitempty"`
 
+	// AnnotationIndex configures the manifest annotations and image
+	// configuration labels indexed when manifests are pushed.
+	AnnotationIndex AnnotationIndex `yaml:"annotationindex,omitempty"`
+
+	// SBOMIndex configures the indexing of the packages listed by the SBOMs
+	// attached to images.
+	SBOMIndex SBOMIndex `yaml:"sbomindex,omitempty"`
+
+	// MetadataIndex configures the embedded database indexing the
+	// repositories, tags and manifests of the storage.
+	MetadataIndex MetadataIndex `yaml:"metadataindex,omitempty"`
+
+	// Vulnerabilities configures the storage of the vulnerability reports
+	// attached to images.
+	Vulnerabilities Vulnerabilities `yaml:"vulnerabilities,omitempty"`
+
+	// Files configures the listing of the files of images, computed from the
+	// contents of their layers.
+	Files Files `yaml:"files,omitempty"`
+
+	// GraphQL configures the read-only GraphQL API over the metadata of the
+	// repositories.
+	GraphQL GraphQL `yaml:"graphql,omitempty"`
+
+	// GRPC configures the gRPC API, served on a separate listener.
+	GRPC GRPC `yaml:"grpc,omitempty"`
+
 	Proxy Proxy `yaml:"proxy,omitempty"`
 
 	// Compatibility is used for configurations of working with older or deprecated features.
@@ -254,6 +498,26 @@ type Configuration struct {
 				// that URLs in pushed manifests must not match.
 				Deny []string `yaml:"deny,omitempty"`
 			} `yaml:"urls,omitempty"`
+			// Strict configures strict parsing of pushed manifests, bounding
+			// the resources spent on them.
+			Strict struct {
+				// Enabled enables strict parsing.
+				Enabled bool `yaml:"enabled,omitempty"`
+				// MaxSize is the maximum size of a manifest in bytes.
+				MaxSize int64 `yaml:"maxsize,omitempty"`
+				// MaxDepth is the maximum nesting depth of JSON objects and
+				// arrays.
+				MaxDepth int `yaml:"maxdepth,omitempty"`
+				// MaxReferences is the maximum number of descriptors of a
+				// manifest.
+				MaxReferences int `yaml:"maxreferences,omitempty"`
+				// MaxAnnotations is the maximum number of annotations of a
+				// manifest or descriptor.
+				MaxAnnotations int `yaml:"maxannotations,omitempty"`
+				// MaxAnnotationSize is the maximum size in bytes of the key
+				// and value of an annotation.
+				MaxAnnotationSize int `yaml:"maxannotationsize,omitempty"`
+			} `yaml:"strict,omitempty"`
 		} `yaml:"manifests,omitempty"`
 	} `yaml:"validation,omitempty"`
 
@@ -267,7 +531,25 @@ type Configuration struct {
 			// manifests. When non-empty, the registry will enforce
 			// the class in authorized resources.
 			Classes []string `yaml:"classes"`
+
+			// Names configures the policy new repository names must
+			// comply with.
+			Names RepositoryNamePolicy `yaml:"names,omitempty"`
+
+			// Redirects configures the redirects to the storage of
+			// the blob downloads of repositories, overriding the
+			// redirect storage option. A repository follows the first
+			// matching rule.
+			Redirects []RedirectPolicy `yaml:"redirects,omitempty"`
 		} `yaml:"repository,omitempty"`
+
+		// Vulnerabilities configures the policy blocking the pull of
+		// vulnerable images.
+		Vulnerabilities VulnerabilityPolicy `yaml:"vulnerabilities,omitempty"`
+
+		// Signatures configures the policy accepting the images pushed by
+		// tag to protected repositories only if they are signed.
+		Signatures SignaturePolicy `yaml:"signatures,omitempty"`
 	} `yaml:"policy,omitempty"`
 }
 
@@ -279,6 +561,108 @@ type Catalog struct {
 	// to the catalog endpoint will return at most MaxEntries entries.
 	// An empty or a negative value will set a default of 1000 maximum entries by default.
 	MaxEntries int `yaml:"maxentries,omitempty"`
+
+	// Index keeps an index of the repositories in memory, from which the
+	// catalog is served and can be filtered.
+	Index CatalogIndex `yaml:"index,omitempty"`
+}
+
+// CatalogIndex configures the in-memory index of the repositories, which is
+// built from the storage in the background and kept up to date as manifests
+// are pushed.
+type CatalogIndex struct {
+	// Enabled turns on the index.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// RebuildInterval is the interval at which the index is rebuilt from
+	// the storage, to include the changes made by other registry instances.
+	// Defaults to one hour.
+	RebuildInterval time.Duration `yaml:"rebuildinterval,omitempty"`
+}
+
+// AnnotationIndex configures the indexing of manifests by annotation, so that
+// the manifests of a repository can be queried by their metadata.
+type AnnotationIndex struct {
+	// Keys lists the OCI manifest annotation and image configuration label
+	// keys which are indexed. Manifests can only be queried by indexed keys.
+	Keys []string `yaml:"keys,omitempty"`
+}
+
+// SBOMIndex configures the indexing of the packages listed by the SPDX and
+// CycloneDX SBOMs pushed as artifacts referring to images, so that the images
+// of a repository can be queried by the packages they contain.
+type SBOMIndex struct {
+	// Enabled indexes the SBOMs when they are pushed.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MaxSize is the size in bytes of the largest SBOM document indexed.
+	// Defaults to 16MiB.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+}
+
+// MetadataIndex configures an index of the repositories, their tags and the
+// blobs referenced by their manifests, kept in an embedded database and
+// updated as they are written, so that the catalog, the tags, the referrers
+// and the blobs to keep on garbage collection are read without walking the
+// storage.
+type MetadataIndex struct {
+	// Enabled turns on the index.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Path is the path of the database file, on a local filesystem.
+	Path string `yaml:"path,omitempty"`
+}
+
+// Vulnerabilities configures the storage of the vulnerability reports of
+// scanners, such as Trivy or Grype, pushed as artifacts referring to images.
+// Each report is summarized by severity, so that the status of the tags of a
+// repository can be queried.
+type Vulnerabilities struct {
+	// Enabled summarizes the reports when they are pushed.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MaxSize is the size in bytes of the largest report summarized.
+	// Defaults to 16MiB.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+}
+
+// Files configures the API listing the files of an image, merged from the
+// tar archives of its layers. The listing of each layer is computed when first
+// requested and cached in the storage.
+type Files struct {
+	// Enabled serves the listing of the files of images.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MaxLayerSize is the size in bytes of the largest layer scanned. Larger
+	// layers are skipped. Defaults to 1GiB.
+	MaxLayerSize int64 `yaml:"maxlayersize,omitempty"`
+}
+
+// GraphQL configures the read-only GraphQL API served at /v2/_graphql, which
+// fetches nested metadata of repositories, tags, manifests and referrers in a
+// single request.
+type GraphQL struct {
+	// Enabled serves the GraphQL API.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MaxDepth is the deepest nesting of fields a query may select.
+	// Defaults to 12.
+	MaxDepth int `yaml:"maxdepth,omitempty"`
+}
+
+// GRPC configures the gRPC API, which mirrors read operations of the HTTP
+// API, such as resolving tags and streaming blobs.
+type GRPC struct {
+	// Addr is the TCP address the gRPC API listens on. The API is only
+	// served if it is set.
+	Addr string `yaml:"addr,omitempty"`
+
+	// TLS configures the certificate of the gRPC listener. If unset, the
+	// API is served in plaintext.
+	TLS struct {
+		// Certificate specifies the path to an x509 certificate file to
+		// be used for TLS
+		Certificate string `yaml:"certificate,omitempty"`
+
+		// Key specifies the path to the x509 key file, which should
+		// contain the private portion for the file specified in
+		// Certificate
+		Key string `yaml:"key,omitempty"`
+	} `yaml:"tls,omitempty"`
 }
 
 // LogHook is composed of hook Level and Type.
@@ -348,6 +732,9 @@ type HTTPChecker struct {
 	// Threshold is the number of times a check must fail to trigger an
 	// unhealthy state
 	Threshold int `yaml:"threshold,omitempty"`
+	// RecoveryThreshold is the number of times a check must succeed to
+	// recover from an unhealthy state
+	RecoveryThreshold int `yaml:"recoverythreshold,omitempty"`
 }
 
 // TCPChecker is a type of entry in the health section for checking TCP servers.
@@ -361,6 +748,679 @@ type TCPChecker struct {
 	// Threshold is the number of times a check must fail to trigger an
 	// unhealthy state
 	Threshold int `yaml:"threshold,omitempty"`
+	// RecoveryThreshold is the number of times a check must succeed to
+	// recover from an unhealthy state
+	RecoveryThreshold int `yaml:"recoverythreshold,omitempty"`
+}
+
+// GRPCChecker is a type of entry in the health section for checking gRPC
+// servers with the gRPC health checking protocol.
+type GRPCChecker struct {
+	// Timeout is the duration to wait before timing out the health check
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Interval is the duration in between checks
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Addr is the address of the gRPC server to check
+	Addr string `yaml:"addr,omitempty"`
+	// Service is the name of the service to check. The overall health of
+	// the server is checked if empty.
+	Service string `yaml:"service,omitempty"`
+	// TLS enables TLS for the connection to the server
+	TLS bool `yaml:"tls,omitempty"`
+	// Threshold is the number of times a check must fail to trigger an
+	// unhealthy state
+	Threshold int `yaml:"threshold,omitempty"`
+	// RecoveryThreshold is the number of times a check must succeed to
+	// recover from an unhealthy state
+	RecoveryThreshold int `yaml:"recoverythreshold,omitempty"`
+}
+
+// CommandChecker is a type of entry in the health section for checking the
+// exit status of a command.
+type CommandChecker struct {
+	// Timeout is the duration to wait before killing the command
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Interval is the duration in between checks
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Command is the command to run and its arguments
+	Command []string `yaml:"command,omitempty"`
+	// Threshold is the number of times a check must fail to trigger an
+	// unhealthy state
+	Threshold int `yaml:"threshold,omitempty"`
+	// RecoveryThreshold is the number of times a check must succeed to
+	// recover from an unhealthy state
+	RecoveryThreshold int `yaml:"recoverythreshold,omitempty"`
+}
+
+// RouteHeaders is a set of headers to include in the successful and redirect
+// HTTP responses of the matching routes.
+type RouteHeaders struct {
+	// Routes lists the names of the API routes the headers apply to, such
+	// as "blob" or "manifest". All routes match if empty.
+	Routes []string `yaml:"routes,omitempty"`
+	// Methods lists the request methods the headers apply to. All methods
+	// match if empty.
+	Methods []string `yaml:"methods,omitempty"`
+	// Headers lists the headers to include in matching responses.
+	Headers http.Header `yaml:"headers,omitempty"`
+}
+
+// RouteTimeouts configures the read and write timeouts of a class of routes.
+// A zero value disables the timeout.
+type RouteTimeouts struct {
+	// Read is the maximum duration for reading the entire request,
+	// including the body.
+	Read time.Duration `yaml:"read,omitempty"`
+	// Write is the maximum duration before timing out writes of the
+	// response.
+	Write time.Duration `yaml:"write,omitempty"`
+}
+
+// BandwidthClass limits the bandwidth of the blob downloads of each client
+// it matches, as a token bucket refilled at Rate bytes per second and holding
+// up to Burst bytes. A class matches the downloads allowed by one of Scopes,
+// of one of Repositories or from one of Networks. A class without any of
+// them matches every download.
+type BandwidthClass struct {
+	// Name identifies the class in logs.
+	Name string `yaml:"name,omitempty"`
+	// Rate is the bandwidth of each client in bytes per second.
+	Rate int64 `yaml:"rate,omitempty"`
+	// Burst is the number of bytes a client may download at a higher rate
+	// after being idle. Defaults to Rate.
+	Burst int64 `yaml:"burst,omitempty"`
+	// Scopes lists resources, in the form type:name, granted by the tokens
+	// of the clients. Names may be glob patterns in the syntax of
+	// path.Match.
+	Scopes []string `yaml:"scopes,omitempty"`
+	// Repositories lists glob patterns, in the syntax of path.Match, of the
+	// repositories downloaded from.
+	Repositories []string `yaml:"repositories,omitempty"`
+	// Networks lists the IP addresses and CIDR ranges of the clients.
+	Networks []string `yaml:"networks,omitempty"`
+}
+
+// DownloadLimit limits the blob downloads of each repository it matches, so
+// that a single hot repository can't overload the storage shared with the
+// others.
+type DownloadLimit struct {
+	// Repositories lists glob patterns, in the syntax of path.Match, of the
+	// repositories limited. If empty, every repository is limited.
+	Repositories []string `yaml:"repositories,omitempty"`
+	// MaxConcurrent is the maximum number of downloads in flight from a
+	// repository. A value of zero disables the limit.
+	MaxConcurrent int `yaml:"maxconcurrent,omitempty"`
+	// Rate is the aggregate egress of the downloads from a repository, in
+	// bytes per second. A value of zero disables the limit.
+	Rate int64 `yaml:"rate,omitempty"`
+	// Burst is the number of bytes downloaded from a repository at a higher
+	// rate after being idle. Defaults to Rate.
+	Burst int64 `yaml:"burst,omitempty"`
+	// RetryAfter is the duration suggested to clients in the Retry-After
+	// header of rejected downloads.
+	RetryAfter time.Duration `yaml:"retryafter,omitempty"`
+}
+
+// RateLimit limits the rate of requests to the registry with token buckets.
+// Requests in excess of any limit are rejected with 429 Too Many Requests.
+type RateLimit struct {
+	// Client limits the requests of each remote IP address.
+	Client RequestRate `yaml:"client,omitempty"`
+	// User limits the requests of each authenticated user.
+	User RequestRate `yaml:"user,omitempty"`
+	// Repository limits the requests to each repository.
+	Repository RequestRate `yaml:"repository,omitempty"`
+	// ExemptNetworks lists the networks, in CIDR notation, of clients which
+	// are not limited.
+	ExemptNetworks []string `yaml:"exemptnetworks,omitempty"`
+}
+
+// RequestRate is a token bucket refilled at Rate requests per second and
+// holding up to Burst requests. A Rate of zero disables the limit.
+type RequestRate struct {
+	Rate  float64 `yaml:"rate,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
+}
+
+// DebugAuth configures HTTP basic authentication for the debug server.
+type DebugAuth struct {
+	// Username and Password are the credentials required from clients.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// DebugTLS configures TLS for the debug server.
+type DebugTLS struct {
+	// Certificate is the path to the x509 certificate file to be used for
+	// TLS.
+	Certificate string `yaml:"certificate,omitempty"`
+	// Key is the path to the x509 private key file to be used for TLS.
+	Key string `yaml:"key,omitempty"`
+	// ClientCAs lists the paths of CA certificates, from which client
+	// certificates are required.
+	ClientCAs []string `yaml:"clientcas,omitempty"`
+}
+
+// DebugRateLimit limits the rate of requests to the debug server, as a token
+// bucket refilled at Rate requests per second and holding up to Burst
+// requests.
+type DebugRateLimit struct {
+	Rate  float64 `yaml:"rate,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
+}
+
+// Tracing configures the export of OpenTelemetry traces to a collector using
+// OTLP over HTTP.
+type Tracing struct {
+	// Enabled turns on tracing.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Endpoint is the host and port of the OTLP collector. If empty, the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable or localhost:4318 is
+	// used.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Insecure disables TLS when connecting to the collector.
+	Insecure bool `yaml:"insecure,omitempty"`
+	// Headers lists headers to send with each export request, such as
+	// credentials for the collector.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// ServiceName is the name of the service reported in traces. Defaults to
+	// "registry".
+	ServiceName string `yaml:"servicename,omitempty"`
+	// SamplingRatio is the ratio of new traces which are sampled, between 0
+	// and 1. Traces started by a sampled parent span are always sampled.
+	// Defaults to 1.
+	SamplingRatio *float64 `yaml:"samplingratio,omitempty"`
+}
+
+// Metrics configures the export of registry metrics, in addition to the
+// Prometheus endpoint of the debug server.
+type Metrics struct {
+	// Namespace is the namespace of the HTTP request metrics of the
+	// registry. Defaults to "registry".
+	Namespace string `yaml:"namespace,omitempty"`
+
+	// OTLP configures the export of metrics to an OpenTelemetry collector.
+	OTLP OTLPMetrics `yaml:"otlp,omitempty"`
+
+	// Repositories configures request metrics labeled by repository.
+	Repositories RepositoryMetrics `yaml:"repositories,omitempty"`
+
+	// HTTP configures serving Prometheus metrics on the main listener.
+	HTTP MetricsHTTP `yaml:"http,omitempty"`
+}
+
+// MetricsHTTP configures serving Prometheus metrics on a path of the main
+// listener, as an alternative to the debug server.
+type MetricsHTTP struct {
+	// Enabled turns on serving metrics on the main listener.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Path is the path metrics are served on. Defaults to "/metrics".
+	Path string `yaml:"path,omitempty"`
+	// Username and Password, if set, require scrapers to authenticate with
+	// HTTP basic authentication.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// BearerToken, if set, requires scrapers to authenticate with the token
+	// as a bearer token.
+	BearerToken string `yaml:"bearertoken,omitempty"`
+}
+
+// RepositoryMetrics configures the collection of request metrics labeled by
+// repository name. As every repository adds a time series, the labeled
+// repositories can be restricted with an allowlist and a limit; requests to
+// other repositories are reported with the "other" repository label.
+type RepositoryMetrics struct {
+	// Enabled turns on the collection of per-repository metrics.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Allowed lists regular expressions matching the repositories which are
+	// labeled by name. If empty, all repositories are labeled.
+	Allowed []string `yaml:"allowed,omitempty"`
+	// Limit is the maximum number of distinct repositories labeled by name,
+	// in the order they are first requested. Zero means no limit.
+	Limit int `yaml:"limit,omitempty"`
+}
+
+// OTLPMetrics configures the periodic export of metrics to a collector using
+// OTLP over HTTP.
+type OTLPMetrics struct {
+	// Enabled turns on the export of metrics.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Endpoint is the host and port of the OTLP collector. If empty, the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable or localhost:4318 is
+	// used.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Insecure disables TLS when connecting to the collector.
+	Insecure bool `yaml:"insecure,omitempty"`
+	// Headers lists headers to send with each export request, such as
+	// credentials for the collector.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Interval is the time between two exports. Defaults to 1 minute.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// Admin configures the administrative API, which exposes operational actions
+// such as toggling the read-only mode. It is authenticated separately from
+// the registry API, so at least one kind of credentials must be configured.
+type Admin struct {
+	// Enabled turns on the admin API.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Username and Password, if set, allow administrators to authenticate
+	// with HTTP basic authentication.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// BearerToken, if set, allows administrators to authenticate with the
+	// token as a bearer token.
+	BearerToken string `yaml:"bearertoken,omitempty"`
+}
+
+// UI configures the read-only web UI, which lets users browse the
+// repositories of the registry from a browser. Pages are authorized with the
+// access controller of the registry, like the registry API.
+type UI struct {
+	// Enabled turns on the web UI.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Path is the path prefix the web UI is served under. It defaults to
+	// /ui/.
+	Path string `yaml:"path,omitempty"`
+}
+
+// RepositoryNamePolicy configures the names repositories can be pushed to,
+// so that multi-tenant registries can enforce a layout of repositories.
+// Repositories which do not comply can still be pulled from.
+type RepositoryNamePolicy struct {
+	// Prefixes lists the namespace prefixes, such as "team-a/", one of which
+	// repository names must start with.
+	Prefixes []string `yaml:"prefixes,omitempty"`
+	// Forbidden lists repository names which cannot be pushed to.
+	Forbidden []string `yaml:"forbidden,omitempty"`
+	// MaxDepth is the maximum number of path components of repository
+	// names. Zero places no limit.
+	MaxDepth int `yaml:"maxdepth,omitempty"`
+	// Allow specifies regular expressions (https://godoc.org/regexp/syntax),
+	// one of which repository names must match.
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+// RedirectPolicy configures the redirects of the blob downloads of the
+// repositories it matches.
+type RedirectPolicy struct {
+	// Repositories lists glob patterns, in the syntax of path.Match, of the
+	// repositories the policy applies to.
+	Repositories []string `yaml:"repositories"`
+	// Disable serves the blobs through the registry instead of redirecting
+	// clients to the storage.
+	Disable bool `yaml:"disable,omitempty"`
+	// Expiry is the lifetime of the pre-signed redirect URLs. The storage
+	// driver default is used if zero.
+	Expiry time.Duration `yaml:"expiry,omitempty"`
+}
+
+// VulnerabilityPolicy configures the pull of images with vulnerabilities
+// reported by a scanner.
+type VulnerabilityPolicy struct {
+	// BlockSeverity is the severity, one of critical, high, medium or low,
+	// from which the pull of an image with a vulnerability of that severity
+	// or above is denied. Pulls are not blocked if empty.
+	BlockSeverity string `yaml:"blockseverity,omitempty"`
+}
+
+// SignaturePolicy rejects the push of a tag to a protected repository unless
+// the manifest it points to has a cosign signature, or an in-toto attestation,
+// stored in the repository and signed by a trusted key.
+type SignaturePolicy struct {
+	// Repositories lists glob patterns, in the syntax of path.Match, of the
+	// protected repositories. All repositories are protected if empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+	// PublicKeys lists the paths of the PEM encoded public keys trusted to
+	// sign images.
+	PublicKeys []string `yaml:"publickeys,omitempty"`
+	// FulcioRoots lists the paths of the PEM encoded root certificates of
+	// the Fulcio certificate authorities trusted to issue the certificates
+	// of keyless signatures.
+	FulcioRoots []string `yaml:"fulcioroots,omitempty"`
+	// Identities lists regular expressions, one of which an email address
+	// or URI of the certificate of a keyless signature must match in full.
+	// It is required with FulcioRoots.
+	Identities []string `yaml:"identities,omitempty"`
+	// RekorKeys lists the paths of the PEM encoded public keys of the Rekor
+	// transparency logs trusted to prove when keyless signatures were made,
+	// during the lifetime of their certificates. It is required with
+	// FulcioRoots.
+	RekorKeys []string `yaml:"rekorkeys,omitempty"`
+}
+
+// Tenant stores the repositories whose name starts with a prefix in a
+// storage driver of their own, such as a separate bucket or account, so that
+// one registry can serve isolated tenants.
+type Tenant struct {
+	// Prefix is the namespace prefix of the repositories of the tenant, such
+	// as "team-a/".
+	Prefix string `yaml:"prefix"`
+	// Storage configures the storage driver of the tenant, in the same way
+	// as the storage of the registry. Only the driver parameters are used.
+	Storage Storage `yaml:"storage"`
+}
+
+// Federation modes of a namespace served by another registry.
+const (
+	// FederationProxy forwards the requests to the namespace to the other
+	// registry.
+	FederationProxy = "proxy"
+	// FederationRedirect redirects clients to the other registry.
+	FederationRedirect = "redirect"
+)
+
+// FederatedNamespace serves the repositories whose name starts with a prefix
+// from another registry, so that one registry endpoint can front several.
+type FederatedNamespace struct {
+	// Prefix is the namespace prefix of the repositories served by the
+	// other registry, such as "team-a/".
+	Prefix string `yaml:"prefix"`
+	// URL is the base URL of the other registry. Repositories keep their
+	// name on the other registry.
+	URL string `yaml:"url"`
+	// Mode is either "proxy", the default, to forward requests to the other
+	// registry, or "redirect", to redirect clients to it.
+	Mode string `yaml:"mode,omitempty"`
+	// Username and Password authenticate the forwarded requests with the
+	// other registry. If unset, the credentials of the client are forwarded.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+// Replication configures the replication of pushes and deletes to other
+// registries.
+type Replication struct {
+	// Peers lists the registries which replicate pushes and deletes with
+	// this one, each accepting writes of its own.
+	Peers []ReplicationPeer `yaml:"peers,omitempty"`
+
+	// Rules lists the registries pushes are replicated to, one way, by
+	// repository.
+	Rules []ReplicationRule `yaml:"rules,omitempty"`
+
+	// Identities lists the users the other registries authenticate as to
+	// replicate their changes to this one. The changes they make are not
+	// replicated further. Required with peers.
+	Identities []string `yaml:"identities,omitempty"`
+}
+
+// ReplicationRule configures the replication of pushes to repositories
+// matching any of its patterns to a downstream registry.
+type ReplicationRule struct {
+	// Name identifies the rule in logs, metrics and status reports.
+	Name string `yaml:"name"`
+	// Repositories lists glob patterns, in the syntax of path.Match, of the
+	// repositories whose pushes are replicated.
+	Repositories []string `yaml:"repositories"`
+	// URL is the base URL of the downstream registry.
+	URL string `yaml:"url"`
+	// Username and Password authenticate with the downstream registry.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Timeout is the time allowed to replicate a push. Defaults to five
+	// minutes.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Threshold is the number of failures before backing off. Defaults to 10.
+	Threshold int `yaml:"threshold,omitempty"`
+	// Backoff is the time waited before retrying after the threshold is
+	// reached. Defaults to one second.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+}
+
+// ReplicationPeer configures a peer registry pushes and deletes are
+// replicated to.
+type ReplicationPeer struct {
+	// Name identifies the peer in logs and metrics.
+	Name string `yaml:"name"`
+	// URL is the base URL of the peer registry.
+	URL string `yaml:"url"`
+	// Username and Password authenticate with the peer registry.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Conflict is the resolution of conflicting writes to a tag: either
+	// "overwrite", the default, in which the replicated write wins, or
+	// "keep", in which the write to the peer wins.
+	Conflict string `yaml:"conflict,omitempty"`
+	// Timeout is the time allowed to replicate a change. Defaults to five
+	// minutes.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Threshold is the number of failures before backing off. Defaults to 10.
+	Threshold int `yaml:"threshold,omitempty"`
+	// Backoff is the time waited before retrying after the threshold is
+	// reached. Defaults to one second.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+}
+
+// Transparency configures the submission of an entry to a Rekor
+// transparency log for each manifest pushed, recording its digest,
+// repository and the identity of the pusher. The entry and its inclusion
+// proof are stored in the repository as an artifact referring to the
+// manifest.
+type Transparency struct {
+	// Rekor is the base URL of the Rekor transparency log, such as
+	// https://rekor.sigstore.dev. Entries are submitted only if it is set.
+	Rekor string `yaml:"rekor,omitempty"`
+	// SigningKey is the path of the PEM encoded ECDSA private key signing
+	// the entries.
+	SigningKey string `yaml:"signingkey,omitempty"`
+	// Repositories lists glob patterns, in the syntax of path.Match, of the
+	// repositories whose pushes are logged. All pushes are logged if empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+	// Timeout is the time allowed to log a push. Defaults to one minute.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Threshold is the number of failures before backing off. Defaults to 10.
+	Threshold int `yaml:"threshold,omitempty"`
+	// Backoff is the time waited before retrying after the threshold is
+	// reached. Defaults to one second.
+	Backoff time.Duration `yaml:"backoff,omitempty"`
+}
+
+// Scrub configures a scrubber verifying in the background that the blobs in
+// storage match their digest.
+type Scrub struct {
+	// Enabled turns on the scrubber.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Interval is the time waited between two passes over the blobs.
+	// Defaults to one hour.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Sample is the fraction of the blobs verified in each pass, picked at
+	// random. Defaults to 0.1.
+	Sample float64 `yaml:"sample,omitempty"`
+	// Rate is the number of bytes read per second. Defaults to 10MiB.
+	Rate int64 `yaml:"rate,omitempty"`
+	// Quarantine moves corrupted blobs which are not repaired out of the
+	// blob store, so that they are no longer served.
+	Quarantine bool `yaml:"quarantine,omitempty"`
+	// Repair replaces corrupted blobs with the copy of a registry pushes
+	// are replicated to, if any.
+	Repair bool `yaml:"repair,omitempty"`
+}
+
+// RedisCluster configures a Redis Cluster deployment.
+type RedisCluster struct {
+	// Addrs are the addresses of cluster nodes the slots of the cluster
+	// are discovered from.
+	Addrs []string `yaml:"addrs,omitempty"`
+}
+
+// RedisSentinel configures a Redis deployment monitored by Redis Sentinel,
+// which connections follow across failovers.
+type RedisSentinel struct {
+	// Master is the name of the master monitored by the sentinels.
+	Master string `yaml:"master,omitempty"`
+	// Addrs are the addresses of the sentinels.
+	Addrs []string `yaml:"addrs,omitempty"`
+	// Password authenticates to the sentinels, if they require it.
+	Password string `yaml:"password,omitempty"`
+}
+
+// GC configures garbage collection running while the registry serves
+// requests.
+type GC struct {
+	// Enabled records when blobs are referenced, which online garbage
+	// collection relies on, and allows garbage collections to run without
+	// the registry being read-only.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Interval is the time waited between two garbage collections run in
+	// the background. Zero disables background garbage collection.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// GracePeriod is how long blobs and manifests are kept after they were
+	// last referenced, so that pushes in flight can complete. Defaults to
+	// one hour.
+	GracePeriod time.Duration `yaml:"graceperiod,omitempty"`
+	// RemoveUntagged removes the manifests which are not tagged.
+	RemoveUntagged bool `yaml:"removeuntagged,omitempty"`
+	// DryRun only reports what would be removed.
+	DryRun bool `yaml:"dryrun,omitempty"`
+}
+
+// Uploads configures the lifetime of blob upload sessions.
+type Uploads struct {
+	// TTL is the maximum lifetime of an upload session. Sessions older
+	// than the TTL are cancelled and their data is removed by a background
+	// reaper, which replaces the upload purging of the storage maintenance
+	// section. Zero disables the TTL.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+	// ReapInterval is the time waited between two passes of the reaper.
+	// Defaults to ten minutes.
+	ReapInterval time.Duration `yaml:"reapinterval,omitempty"`
+}
+
+// Schedule configures the maintenance tasks run by the registry at the
+// times given by cron expressions, such as "0 3 * * *" or "@daily".
+type Schedule struct {
+	// GC is the cron expression of the online garbage collections, which
+	// require gc to be enabled. They follow the removeuntagged and dryrun
+	// options of the gc section.
+	GC string `yaml:"gc,omitempty"`
+	// Retention is the cron expression of the removals of old tags by the
+	// retention rules.
+	Retention string `yaml:"retention,omitempty"`
+	// UploadPurging is the cron expression of the purges of stale upload
+	// sessions.
+	UploadPurging string `yaml:"uploadpurging,omitempty"`
+	// UploadPurgeAge is the age beyond which upload sessions are purged.
+	// Defaults to the upload TTL if set, or one week.
+	UploadPurgeAge time.Duration `yaml:"uploadpurgeage,omitempty"`
+}
+
+// Digests configures the digest algorithms blobs may be addressed by, in
+// addition to the SHA-2 family.
+type Digests struct {
+	// BLAKE3 accepts blobs addressed by their blake3 digest, and uploads
+	// hashed with blake3 instead of sha256 when clients request it.
+	BLAKE3 bool `yaml:"blake3,omitempty"`
+}
+
+// Mirror is an alternative endpoint serving the content of the registry,
+// such as a regional mirror or a CDN host.
+type Mirror struct {
+	// URL is the base URL of the endpoint, such as
+	// https://eu.mirror.example.com.
+	URL string `yaml:"url"`
+	// Priority orders the endpoints, clients preferring the endpoints with
+	// the lowest priority.
+	Priority int `yaml:"priority,omitempty"`
+	// Region is the region served by the endpoint, if any.
+	Region string `yaml:"region,omitempty"`
+}
+
+// Quotas limits the bytes of blobs and manifests stored in repositories.
+// Pushes which would exceed a quota are denied.
+type Quotas struct {
+	// Repositories lists quotas applying to each repository whose name
+	// matches their pattern on its own.
+	Repositories []StorageQuota `yaml:"repositories,omitempty"`
+	// Namespaces lists quotas shared by all repositories under their
+	// namespace.
+	Namespaces []StorageQuota `yaml:"namespaces,omitempty"`
+}
+
+// StorageQuota is the maximum number of bytes stored in a scope.
+type StorageQuota struct {
+	// Name is a path.Match pattern of repository names for repository
+	// quotas, such as "library/*", and a namespace such as "team-a" for
+	// namespace quotas.
+	Name string `yaml:"name"`
+	// Limit is the maximum number of bytes stored.
+	Limit int64 `yaml:"limit"`
+}
+
+// Usage configures the accounting of the bytes stored by each repository,
+// served by the /v2/_usage endpoint and exported as metrics. The accounting
+// is kept up to date as content is pushed and deleted, and reconciled with
+// the storage periodically.
+type Usage struct {
+	// Enabled turns on the accounting.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// ReconcileInterval is the interval at which the usage is recomputed
+	// from the storage. Defaults to 24 hours.
+	ReconcileInterval time.Duration `yaml:"reconcileinterval,omitempty"`
+}
+
+// TagProtection configures the tags which cannot be moved to another
+// manifest or deleted once pushed, such as release tags.
+type TagProtection struct {
+	// Rules lists the protected tags. A tag is protected if any rule
+	// matches it.
+	Rules []TagProtectionRule `yaml:"rules,omitempty"`
+}
+
+// TagProtectionRule protects the tags matching one of its patterns in the
+// repositories it applies to.
+type TagProtectionRule struct {
+	// Repositories lists glob patterns, in the syntax of path.Match, of the
+	// repositories the rule applies to. The rule applies to every
+	// repository if empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+	// Tags lists glob patterns, in the syntax of path.Match, of the
+	// protected tags, such as "v*" or "release-*".
+	Tags []string `yaml:"tags"`
+}
+
+// Retention configures the removal of the old tags of repositories, run at
+// the times of the retention schedule.
+type Retention struct {
+	// Rules lists the retention rules. The first rule matching a repository
+	// applies to it, and the tags of repositories no rule matches are kept.
+	Rules []RetentionRule `yaml:"rules,omitempty"`
+	// DeleteManifests deletes the manifests of the removed tags which no
+	// remaining tag refers to, directly or through an index.
+	DeleteManifests bool `yaml:"deletemanifests,omitempty"`
+	// DryRun only logs the tags and manifests which would be removed.
+	DryRun bool `yaml:"dryrun,omitempty"`
+}
+
+// RetentionRule removes the tags of the repositories it applies to which
+// none of its conditions keeps. Protected tags are always kept.
+type RetentionRule struct {
+	// Repositories lists glob patterns, in the syntax of path.Match, of the
+	// repositories the rule applies to. The rule applies to every
+	// repository if empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+	// KeepLast keeps the given number of most recently pushed tags.
+	KeepLast int `yaml:"keeplast,omitempty"`
+	// Keep lists regular expressions of tags kept regardless of their age,
+	// such as "^v[0-9]+".
+	Keep []string `yaml:"keep,omitempty"`
+	// MaxAge keeps the tags pushed within the given duration.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
+}
+
+// Maintenance configures a maintenance mode in which requests are rejected
+// with 503 Service Unavailable, such as during backend migrations. The mode
+// can also be toggled at runtime through the admin API.
+type Maintenance struct {
+	// Enabled turns on the maintenance mode, rejecting writes.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Reads also rejects reads during maintenance.
+	Reads bool `yaml:"reads,omitempty"`
+	// RetryAfter is the duration suggested to clients in the Retry-After
+	// header of rejected requests. Defaults to one minute.
+	RetryAfter time.Duration `yaml:"retryafter,omitempty"`
+	// Message is returned to clients as the message of the error.
+	Message string `yaml:"message,omitempty"`
 }
 
 // Health provides the configuration section for health checks.
@@ -371,17 +1431,37 @@ type Health struct {
 	HTTPCheckers []HTTPChecker `yaml:"http,omitempty"`
 	// TCPCheckers is a list of URIs to check
 	TCPCheckers []TCPChecker `yaml:"tcp,omitempty"`
+	// GRPCCheckers is a list of gRPC servers to check
+	GRPCCheckers []GRPCChecker `yaml:"grpc,omitempty"`
+	// CommandCheckers is a list of commands to run
+	CommandCheckers []CommandChecker `yaml:"command,omitempty"`
 	// StorageDriver configures a health check on the configured storage
 	// driver
-	StorageDriver struct {
-		// Enabled turns on the health check for the storage driver
-		Enabled bool `yaml:"enabled,omitempty"`
-		// Interval is the duration in between checks
-		Interval time.Duration `yaml:"interval,omitempty"`
-		// Threshold is the number of times a check must fail to trigger an
-		// unhealthy state
-		Threshold int `yaml:"threshold,omitempty"`
-	} `yaml:"storagedriver,omitempty"`
+	StorageDriver DependencyChecker `yaml:"storagedriver,omitempty"`
+	// Redis configures a health check on the configured redis instance
+	Redis DependencyChecker `yaml:"redis,omitempty"`
+	// TokenRealm configures a health check on the realm of the configured
+	// token auth service
+	TokenRealm DependencyChecker `yaml:"tokenrealm,omitempty"`
+}
+
+// DependencyChecker is a type of entry in the health section for checking a
+// dependency of the registry, such as the storage driver or redis, which is
+// derived from the rest of the configuration.
+type DependencyChecker struct {
+	// Enabled turns on the health check for the dependency
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Interval is the duration in between checks
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Timeout is the duration to wait before timing out a check. It is not
+	// used by the storage driver check.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Threshold is the number of times a check must fail to trigger an
+	// unhealthy state
+	Threshold int `yaml:"threshold,omitempty"`
+	// RecoveryThreshold is the number of times a check must succeed to
+	// recover from an unhealthy state
+	RecoveryThreshold int `yaml:"recoverythreshold,omitempty"`
 }
 
 // v0_1Configuration is a Version 0.1 Configuration struct
@@ -413,6 +1493,25 @@ func (version *Version) UnmarshalYAML(unmarshal func(interface{}) error) error {
 // CurrentVersion is the most recent Version that can be parsed
 var CurrentVersion = MajorMinorVersion(0, 1)
 
+// LogOutput configures a log file, which is rotated once it reaches a
+// maximum size.
+type LogOutput struct {
+	// Path is the path of the log file. If empty, the log is written to the
+	// standard output or error.
+	Path string `yaml:"path,omitempty"`
+	// MaxSize is the size in megabytes at which the log file is rotated.
+	// Defaults to 100 megabytes.
+	MaxSize int `yaml:"maxsize,omitempty"`
+	// MaxAge is how long rotated log files are retained, rounded up to
+	// whole days. Zero retains log files regardless of their age.
+	MaxAge time.Duration `yaml:"maxage,omitempty"`
+	// MaxBackups is the number of rotated log files which are retained.
+	// Zero retains all log files, subject to MaxAge.
+	MaxBackups int `yaml:"maxbackups,omitempty"`
+	// Compress compresses rotated log files with gzip.
+	Compress bool `yaml:"compress,omitempty"`
+}
+
 // Loglevel is the level at which operations are logged
 // This can be error, warn, info, or debug
 type Loglevel string
@@ -596,26 +1695,87 @@ func (auth Auth) MarshalYAML() (interface{}, error) {
 type Notifications struct {
 	// EventConfig is the configuration for the event format that is sent to each Endpoint.
 	EventConfig Events `yaml:"events,omitempty"`
-	// Endpoints is a list of http configurations for endpoints that
-	// respond to webhook notifications. In the future, we may allow other
-	// kinds of endpoints, such as external queues.
+	// Endpoints is a list of configurations for endpoints that respond to
+	// webhook notifications or Kafka topics events are published to.
 	Endpoints []Endpoint `yaml:"endpoints,omitempty"`
 }
 
-// Endpoint describes the configuration of an http webhook notification
-// endpoint.
+// Endpoint describes the configuration of a notification endpoint, which is
+// an http webhook unless its type is kafka.
 type Endpoint struct {
 	Name              string        `yaml:"name"`              // identifies the endpoint in the registry instance.
 	Disabled          bool          `yaml:"disabled"`          // disables the endpoint
+	Type              string        `yaml:"type"`              // http or kafka, defaults to http
 	URL               string        `yaml:"url"`               // post url for the endpoint.
+	Kafka             Kafka         `yaml:"kafka,omitempty"`   // topic events are published to by kafka endpoints
 	Headers           http.Header   `yaml:"headers"`           // static headers that should be added to all requests
 	Timeout           time.Duration `yaml:"timeout"`           // HTTP timeout
 	Threshold         int           `yaml:"threshold"`         // circuit breaker threshold before backing off on failure
 	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
+	MaxBackoff        time.Duration `yaml:"maxbackoff"`        // upper bound of the exponential backoff
+	MaxRetries        int           `yaml:"maxretries"`        // retries of an event before giving up on it, unlimited if zero
+	QueueSize         int           `yaml:"queuesize"`         // maximum number of events queued in memory, unbounded if zero
+	DeadLetterDir     string        `yaml:"deadletterdir"`     // directory persisting undelivered events
 	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
 	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
 }
 
+// Kafka configures the Kafka topic a notification endpoint publishes events
+// to.
+type Kafka struct {
+	// Brokers lists the host:port addresses of the brokers to bootstrap
+	// the connection to the cluster from.
+	Brokers []string `yaml:"brokers,omitempty"`
+
+	// Topic is the topic events are published to.
+	Topic string `yaml:"topic,omitempty"`
+
+	// PartitionBy selects how events are spread over the partitions of the
+	// topic: "repository", the default, keys events by repository so that
+	// the events of a repository are kept in order, while "none" spreads
+	// them evenly.
+	PartitionBy string `yaml:"partitionby,omitempty"`
+
+	// SASL configures the authentication to the brokers.
+	SASL KafkaSASL `yaml:"sasl,omitempty"`
+
+	// TLS configures the encryption of the connections to the brokers.
+	TLS KafkaTLS `yaml:"tls,omitempty"`
+}
+
+// KafkaSASL configures the SASL authentication to Kafka brokers.
+type KafkaSASL struct {
+	// Mechanism is the SASL mechanism, one of "plain", "scram-sha-256" and
+	// "scram-sha-512". Connections are not authenticated if it is unset.
+	Mechanism string `yaml:"mechanism,omitempty"`
+
+	// Username is the user to authenticate as.
+	Username string `yaml:"username,omitempty"`
+
+	// Password is the password of the user.
+	Password string `yaml:"password,omitempty"`
+}
+
+// KafkaTLS configures the TLS connections to Kafka brokers.
+type KafkaTLS struct {
+	// Enabled connects to the brokers over TLS.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// CA is the path to a PEM file of the certificate authorities the
+	// certificates of the brokers are verified against, rather than the
+	// ones of the system.
+	CA string `yaml:"ca,omitempty"`
+
+	// Certificate and Key are the paths to the x509 certificate and key
+	// the registry authenticates to the brokers with.
+	Certificate string `yaml:"certificate,omitempty"`
+	Key         string `yaml:"key,omitempty"`
+
+	// InsecureSkipVerify disables the verification of the certificates of
+	// the brokers.
+	InsecureSkipVerify bool `yaml:"insecureskipverify,omitempty"`
+}
+
 // Events configures notification events.
 type Events struct {
 	IncludeReferences bool `yaml:"includereferences"` // include reference data in manifest events
@@ -681,6 +1841,35 @@ type Proxy struct {
 	// if not set, defaults to 7 * 24 hours
 	// If set to zero, will never expire cache
 	TTL *time.Duration `yaml:"ttl,omitempty"`
+
+	// CacheSizeLimit is the total size in bytes of the cached blobs above
+	// which the least recently used ones are evicted. The size of the cache
+	// is unlimited if zero.
+	CacheSizeLimit int64 `yaml:"cachesizelimit,omitempty"`
+
+	// Remotes maps namespaces of repositories to the remote registries they
+	// are pulled from, so that several registries can be mirrored.
+	// Repositories outside of these namespaces are pulled from RemoteURL,
+	// if set.
+	Remotes []ProxyRemote `yaml:"remotes,omitempty"`
+}
+
+// ProxyRemote is a remote registry the repositories of a namespace are pulled
+// from.
+type ProxyRemote struct {
+	// Namespace is the prefix of the names of the repositories pulled from
+	// the remote, such as docker.io. It is removed from their names on the
+	// remote, so that docker.io/library/ubuntu is pulled as library/ubuntu.
+	Namespace string `yaml:"namespace"`
+
+	// RemoteURL is the URL of the remote registry
+	RemoteURL string `yaml:"remoteurl"`
+
+	// Username of the user on the remote registry
+	Username string `yaml:"username,omitempty"`
+
+	// Password of the user on the remote registry
+	Password string `yaml:"password,omitempty"`
 }
 
 // Parse parses an input configuration yaml document into a Configuration struct
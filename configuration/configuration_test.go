@@ -21,13 +21,17 @@ var configStruct = Configuration{
 	Version: "0.1",
 	Log: struct {
 		AccessLog struct {
-			Disabled bool `yaml:"disabled,omitempty"`
+			Disabled  bool                   `yaml:"disabled,omitempty"`
+			Output    LogOutput              `yaml:"output,omitempty"`
+			Formatter string                 `yaml:"formatter,omitempty"`
+			Fields    map[string]interface{} `yaml:"fields,omitempty"`
 		} `yaml:"accesslog,omitempty"`
 		Level        Loglevel               `yaml:"level,omitempty"`
 		Formatter    string                 `yaml:"formatter,omitempty"`
 		Fields       map[string]interface{} `yaml:"fields,omitempty"`
 		Hooks        []LogHook              `yaml:"hooks,omitempty"`
 		ReportCaller bool                   `yaml:"reportcaller,omitempty"`
+		Output       LogOutput              `yaml:"output,omitempty"`
 	}{
 		Level:  "info",
 		Fields: map[string]interface{}{"environment": "test"},
@@ -81,39 +85,79 @@ var configStruct = Configuration{
 		Prefix       string        `yaml:"prefix,omitempty"`
 		Secret       string        `yaml:"secret,omitempty"`
 		RelativeURLs bool          `yaml:"relativeurls,omitempty"`
+		ErrorDetail  string        `yaml:"errordetail,omitempty"`
 		DrainTimeout time.Duration `yaml:"draintimeout,omitempty"`
 		TLS          struct {
-			Certificate  string   `yaml:"certificate,omitempty"`
-			Key          string   `yaml:"key,omitempty"`
-			ClientCAs    []string `yaml:"clientcas,omitempty"`
-			MinimumTLS   string   `yaml:"minimumtls,omitempty"`
-			CipherSuites []string `yaml:"ciphersuites,omitempty"`
-			LetsEncrypt  struct {
+			Certificate    string        `yaml:"certificate,omitempty"`
+			Key            string        `yaml:"key,omitempty"`
+			ClientCAs      []string      `yaml:"clientcas,omitempty"`
+			MinimumTLS     string        `yaml:"minimumtls,omitempty"`
+			CipherSuites   []string      `yaml:"ciphersuites,omitempty"`
+			ReloadInterval time.Duration `yaml:"reloadinterval,omitempty"`
+			LetsEncrypt    struct {
 				CacheFile    string   `yaml:"cachefile,omitempty"`
 				Email        string   `yaml:"email,omitempty"`
 				Hosts        []string `yaml:"hosts,omitempty"`
 				DirectoryURL string   `yaml:"directoryurl,omitempty"`
 			} `yaml:"letsencrypt,omitempty"`
 		} `yaml:"tls,omitempty"`
-		Headers http.Header `yaml:"headers,omitempty"`
-		Debug   struct {
+		Headers      http.Header    `yaml:"headers,omitempty"`
+		RouteHeaders []RouteHeaders `yaml:"routeheaders,omitempty"`
+		Debug        struct {
 			Addr       string `yaml:"addr,omitempty"`
 			Prometheus struct {
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			Auth      DebugAuth      `yaml:"auth,omitempty"`
+			TLS       DebugTLS       `yaml:"tls,omitempty"`
+			RateLimit DebugRateLimit `yaml:"ratelimit,omitempty"`
 		} `yaml:"debug,omitempty"`
-		HTTP2 struct {
+		CORS struct {
+			AllowedOrigins   []string      `yaml:"allowedorigins,omitempty"`
+			AllowedMethods   []string      `yaml:"allowedmethods,omitempty"`
+			AllowedHeaders   []string      `yaml:"allowedheaders,omitempty"`
+			ExposedHeaders   []string      `yaml:"exposedheaders,omitempty"`
+			AllowCredentials bool          `yaml:"allowcredentials,omitempty"`
+			MaxAge           time.Duration `yaml:"maxage,omitempty"`
+		} `yaml:"cors,omitempty"`
+		ProxyProtocol struct {
+			Enabled bool          `yaml:"enabled,omitempty"`
+			Timeout time.Duration `yaml:"timeout,omitempty"`
+		} `yaml:"proxyprotocol,omitempty"`
+		TrustedProxies []string `yaml:"trustedproxies,omitempty"`
+		Timeouts       struct {
+			ReadHeader time.Duration `yaml:"readheader,omitempty"`
+			Idle       time.Duration `yaml:"idle,omitempty"`
+			Default    RouteTimeouts `yaml:"default,omitempty"`
+			Blobs      RouteTimeouts `yaml:"blobs,omitempty"`
+		} `yaml:"timeouts,omitempty"`
+		BodyLimits struct {
+			Manifests int64 `yaml:"manifests,omitempty"`
+			Blobs     int64 `yaml:"blobs,omitempty"`
+			Default   int64 `yaml:"default,omitempty"`
+		} `yaml:"bodylimits,omitempty"`
+		ConcurrencyLimit struct {
+			MaxRequests int           `yaml:"maxrequests,omitempty"`
+			MaxReads    int           `yaml:"maxreads,omitempty"`
+			MaxWrites   int           `yaml:"maxwrites,omitempty"`
+			RetryAfter  time.Duration `yaml:"retryafter,omitempty"`
+		} `yaml:"concurrencylimit,omitempty"`
+		BandwidthClasses []BandwidthClass `yaml:"bandwidthclasses,omitempty"`
+		DownloadLimits   []DownloadLimit  `yaml:"downloadlimits,omitempty"`
+		RateLimit        RateLimit        `yaml:"ratelimit,omitempty"`
+		HTTP2            struct {
 			Disabled bool `yaml:"disabled,omitempty"`
 		} `yaml:"http2,omitempty"`
 	}{
 		TLS: struct {
-			Certificate  string   `yaml:"certificate,omitempty"`
-			Key          string   `yaml:"key,omitempty"`
-			ClientCAs    []string `yaml:"clientcas,omitempty"`
-			MinimumTLS   string   `yaml:"minimumtls,omitempty"`
-			CipherSuites []string `yaml:"ciphersuites,omitempty"`
-			LetsEncrypt  struct {
+			Certificate    string        `yaml:"certificate,omitempty"`
+			Key            string        `yaml:"key,omitempty"`
+			ClientCAs      []string      `yaml:"clientcas,omitempty"`
+			MinimumTLS     string        `yaml:"minimumtls,omitempty"`
+			CipherSuites   []string      `yaml:"ciphersuites,omitempty"`
+			ReloadInterval time.Duration `yaml:"reloadinterval,omitempty"`
+			LetsEncrypt    struct {
 				CacheFile    string   `yaml:"cachefile,omitempty"`
 				Email        string   `yaml:"email,omitempty"`
 				Hosts        []string `yaml:"hosts,omitempty"`
@@ -132,10 +176,12 @@ var configStruct = Configuration{
 		},
 	},
 	Redis: struct {
-		Addr     string `yaml:"addr,omitempty"`
-		Username string `yaml:"username,omitempty"`
-		Password string `yaml:"password,omitempty"`
-		DB       int    `yaml:"db,omitempty"`
+		Addr     string        `yaml:"addr,omitempty"`
+		Cluster  RedisCluster  `yaml:"cluster,omitempty"`
+		Sentinel RedisSentinel `yaml:"sentinel,omitempty"`
+		Username string        `yaml:"username,omitempty"`
+		Password string        `yaml:"password,omitempty"`
+		DB       int           `yaml:"db,omitempty"`
 		TLS      struct {
 			Enabled bool `yaml:"enabled,omitempty"`
 		} `yaml:"tls,omitempty"`
@@ -289,10 +335,12 @@ func (suite *ConfigSuite) TestParseInmemory(c *check.C) {
 	suite.expectedConfig.Reporting = Reporting{}
 	suite.expectedConfig.Log.Fields = nil
 	suite.expectedConfig.Redis = struct {
-		Addr     string `yaml:"addr,omitempty"`
-		Username string `yaml:"username,omitempty"`
-		Password string `yaml:"password,omitempty"`
-		DB       int    `yaml:"db,omitempty"`
+		Addr     string        `yaml:"addr,omitempty"`
+		Cluster  RedisCluster  `yaml:"cluster,omitempty"`
+		Sentinel RedisSentinel `yaml:"sentinel,omitempty"`
+		Username string        `yaml:"username,omitempty"`
+		Password string        `yaml:"password,omitempty"`
+		DB       int           `yaml:"db,omitempty"`
 		TLS      struct {
 			Enabled bool `yaml:"enabled,omitempty"`
 		} `yaml:"tls,omitempty"`
@@ -326,10 +374,12 @@ func (suite *ConfigSuite) TestParseIncomplete(c *check.C) {
 	suite.expectedConfig.Notifications = Notifications{}
 	suite.expectedConfig.HTTP.Headers = nil
 	suite.expectedConfig.Redis = struct {
-		Addr     string `yaml:"addr,omitempty"`
-		Username string `yaml:"username,omitempty"`
-		Password string `yaml:"password,omitempty"`
-		DB       int    `yaml:"db,omitempty"`
+		Addr     string        `yaml:"addr,omitempty"`
+		Cluster  RedisCluster  `yaml:"cluster,omitempty"`
+		Sentinel RedisSentinel `yaml:"sentinel,omitempty"`
+		Username string        `yaml:"username,omitempty"`
+		Password string        `yaml:"password,omitempty"`
+		DB       int           `yaml:"db,omitempty"`
 		TLS      struct {
 			Enabled bool `yaml:"enabled,omitempty"`
 		} `yaml:"tls,omitempty"`
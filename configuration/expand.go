@@ -0,0 +1,148 @@
+package configuration
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// expandEnv replaces references to environment variables in the string
+// values of the YAML document in. References take the form ${NAME}, or
+// ${NAME:-default} to fall back to a default value if the variable is unset
+// or empty, and "$${" is replaced by a literal "${". A value consisting of a
+// single reference takes the type of the expanded value if the value is
+// written in its canonical form, such as 8080 or true, so that numbers and
+// booleans can be injected as well. Other values, such as 0123 or 0x1F, are
+// kept as strings rather than read as octal or hexadecimal numbers. The
+// document is returned unchanged if it does not contain any reference.
+func (p *Parser) expandEnv(in []byte) ([]byte, error) {
+	if !strings.Contains(string(in), "${") {
+		return in, nil
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		return nil, err
+	}
+
+	expanded, err := p.expandValue(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(expanded)
+}
+
+func (p *Parser) expandValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			expanded, err := p.expandValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", key, err)
+			}
+			v[key] = expanded
+		}
+		return v, nil
+	case []interface{}:
+		for i, value := range v {
+			expanded, err := p.expandValue(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = expanded
+		}
+		return v, nil
+	case string:
+		return p.expandString(v)
+	default:
+		return v, nil
+	}
+}
+
+// expandString expands the references within s.
+func (p *Parser) expandString(s string) (interface{}, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var (
+		b         strings.Builder
+		rest      = s
+		reference bool
+	)
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			b.WriteString(rest)
+			break
+		}
+		if i > 0 && rest[i-1] == '$' {
+			// escaped reference
+			b.WriteString(rest[:i-1])
+			b.WriteString("${")
+			rest = rest[i+2:]
+			continue
+		}
+
+		end := strings.Index(rest[i:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated environment variable reference in %q", s)
+		}
+		name := rest[i+2 : i+end]
+		var (
+			fallback    string
+			hasFallback bool
+		)
+		if j := strings.Index(name, ":-"); j >= 0 {
+			name, fallback, hasFallback = name[:j], name[j+2:], true
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty environment variable reference in %q", s)
+		}
+
+		value, ok := p.lookupEnv(name)
+		switch {
+		case hasFallback && value == "":
+			value = fallback
+		case !ok:
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+
+		b.WriteString(rest[:i])
+		b.WriteString(value)
+		reference = i == 0 && i+end+1 == len(rest) && rest == s
+		rest = rest[i+end+1:]
+	}
+
+	expanded := b.String()
+	if reference {
+		// a value consisting of a single reference takes the type of the
+		// expanded value, as long as it is written in canonical form: YAML
+		// reads 0123 and 0x1F as numbers, which would corrupt strings such
+		// as secrets, whereas string fields still decode 8080 or true as
+		// their text.
+		var typed interface{}
+		if err := yaml.Unmarshal([]byte(expanded), &typed); err == nil {
+			switch typed.(type) {
+			case int, int64, uint64, float64, bool:
+				if canonical, err := yaml.Marshal(typed); err == nil && strings.TrimSpace(string(canonical)) == expanded {
+					return typed, nil
+				}
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// lookupEnv returns the value of the named environment variable, as captured
+// when the parser was created.
+func (p *Parser) lookupEnv(name string) (string, bool) {
+	for _, env := range p.env {
+		if env.name == name {
+			return env.value, true
+		}
+	}
+	return "", false
+}
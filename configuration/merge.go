@@ -0,0 +1,43 @@
+package configuration
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Merge deep-merges the YAML configuration documents in order, so that
+// options of later documents override those of earlier ones. Mappings are
+// merged recursively, while all other values, including lists, are replaced.
+// An option can be removed by setting it to null in a later document.
+func Merge(documents ...[]byte) ([]byte, error) {
+	var merged map[interface{}]interface{}
+	for i, document := range documents {
+		var doc map[interface{}]interface{}
+		if err := yaml.Unmarshal(document, &doc); err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+		merged = mergeMaps(merged, doc)
+	}
+	return yaml.Marshal(merged)
+}
+
+func mergeMaps(dst, src map[interface{}]interface{}) map[interface{}]interface{} {
+	if dst == nil {
+		dst = make(map[interface{}]interface{}, len(src))
+	}
+	for key, value := range src {
+		if value == nil {
+			delete(dst, key)
+			continue
+		}
+		srcMap, srcIsMap := value.(map[interface{}]interface{})
+		dstMap, dstIsMap := dst[key].(map[interface{}]interface{})
+		if srcIsMap && dstIsMap {
+			dst[key] = mergeMaps(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+	return dst
+}
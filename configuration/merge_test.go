@@ -0,0 +1,68 @@
+package configuration
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := []byte(`version: 0.1
+log:
+  level: info
+  fields:
+    service: registry
+storage:
+  filesystem:
+    rootdirectory: /var/lib/registry
+  delete:
+    enabled: true
+http:
+  addr: :5000
+  headers:
+    X-Content-Type-Options: [nosniff]
+`)
+	site := []byte(`log:
+  level: debug
+storage:
+  filesystem:
+    rootdirectory: /mnt/registry
+  delete: ~
+http:
+  headers:
+    X-Frame-Options: [DENY]
+`)
+
+	merged, err := Merge(base, site)
+	if err != nil {
+		t.Fatalf("unexpected error merging configurations: %v", err)
+	}
+
+	config, err := Parse(bytes.NewReader(merged))
+	if err != nil {
+		t.Fatalf("unexpected error parsing merged configuration: %v", err)
+	}
+
+	if config.Log.Level != "debug" {
+		t.Errorf("unexpected log level: %q", config.Log.Level)
+	}
+	if config.Log.Fields["service"] != "registry" {
+		t.Errorf("unexpected log fields: %v", config.Log.Fields)
+	}
+	if root := config.Storage.Parameters()["rootdirectory"]; root != "/mnt/registry" {
+		t.Errorf("unexpected root directory: %v", root)
+	}
+	if _, ok := config.Storage["delete"]; ok {
+		t.Errorf("expected delete section to be removed")
+	}
+	if config.HTTP.Addr != ":5000" {
+		t.Errorf("unexpected address: %q", config.HTTP.Addr)
+	}
+	expectedHeaders := map[string][]string{
+		"X-Content-Type-Options": {"nosniff"},
+		"X-Frame-Options":        {"DENY"},
+	}
+	if !reflect.DeepEqual(map[string][]string(config.HTTP.Headers), expectedHeaders) {
+		t.Errorf("unexpected headers: %v", config.HTTP.Headers)
+	}
+}
@@ -111,6 +111,9 @@ func NewParser(prefix string, parseInfos []VersionedParseInfo) *Parser {
 // than version, following the scheme below:
 // v.Abc may be replaced by the value of PREFIX_ABC,
 // v.Abc.Xyz may be replaced by the value of PREFIX_ABC_XYZ, and so forth
+//
+// Environment variables may also be referenced within string values, as
+// ${NAME} or ${NAME:-default}, see expandEnv.
 func (p *Parser) Parse(in []byte, v interface{}) error {
 	var versionedStruct struct {
 		Version Version
@@ -125,8 +128,13 @@ func (p *Parser) Parse(in []byte, v interface{}) error {
 		return fmt.Errorf("unsupported version: %q", versionedStruct.Version)
 	}
 
+	in, err := p.expandEnv(in)
+	if err != nil {
+		return fmt.Errorf("expanding environment variables: %v", err)
+	}
+
 	parseAs := reflect.New(parseInfo.ParseAs)
-	err := yaml.Unmarshal(in, parseAs.Interface())
+	err = yaml.Unmarshal(in, parseAs.Interface())
 	if err != nil {
 		return err
 	}
@@ -3,6 +3,7 @@ package configuration
 import (
 	"os"
 	"reflect"
+	"strings"
 
 	"gopkg.in/check.v1"
 )
@@ -101,3 +102,54 @@ func (suite *ParserSuite) TestParseOverwriteUnininitializedPoiner(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(config, check.DeepEquals, expectedConfig)
 }
+
+func (suite *ParserSuite) TestParseExpandEnv(c *check.C) {
+	os.Setenv("TEST_REGISTRY_HOST", "registry.example.com")
+	defer os.Unsetenv("TEST_REGISTRY_HOST")
+	os.Setenv("TEST_REGISTRY_REDIS_DB", "3")
+	defer os.Unsetenv("TEST_REGISTRY_REDIS_DB")
+
+	config, err := Parse(strings.NewReader(`version: "0.1"
+storage:
+  s3:
+    bucket: ${TEST_REGISTRY_BUCKET:-registry-data}
+    secretkey: abc$${NOT_A_REFERENCE}
+http:
+  host: https://${TEST_REGISTRY_HOST}:5000
+redis:
+  db: ${TEST_REGISTRY_REDIS_DB}
+`))
+	c.Assert(err, check.IsNil)
+	c.Assert(config.Storage.Parameters()["bucket"], check.Equals, "registry-data")
+	c.Assert(config.Storage.Parameters()["secretkey"], check.Equals, "abc${NOT_A_REFERENCE}")
+	c.Assert(config.HTTP.Host, check.Equals, "https://registry.example.com:5000")
+	c.Assert(config.Redis.DB, check.Equals, 3)
+}
+
+func (suite *ParserSuite) TestParseExpandEnvNonCanonical(c *check.C) {
+	for _, value := range []string{"0123", "0x1F", "1e3", "yes", "+1"} {
+		os.Setenv("TEST_REGISTRY_SECRET", value)
+
+		config, err := Parse(strings.NewReader(`version: "0.1"
+storage:
+  s3:
+    secretkey: ${TEST_REGISTRY_SECRET}
+http:
+  secret: ${TEST_REGISTRY_SECRET}
+`))
+		c.Assert(err, check.IsNil)
+		c.Assert(config.HTTP.Secret, check.Equals, value)
+		c.Assert(config.Storage.Parameters()["secretkey"], check.Equals, value)
+	}
+	os.Unsetenv("TEST_REGISTRY_SECRET")
+}
+
+func (suite *ParserSuite) TestParseExpandEnvUnset(c *check.C) {
+	_, err := Parse(strings.NewReader(`version: "0.1"
+storage:
+  inmemory: {}
+http:
+  host: https://${TEST_REGISTRY_UNSET_HOST}
+`))
+	c.Assert(err, check.ErrorMatches, ".*environment variable TEST_REGISTRY_UNSET_HOST is not set")
+}
@@ -0,0 +1,86 @@
+package configuration
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces the values of sensitive configuration options.
+const redacted = "<redacted>"
+
+// sensitiveKeys lists configuration options, in lower case and without
+// underscores or dashes, whose values are redacted. Options ending in
+// "password", "secret" or "token" are redacted as well.
+var sensitiveKeys = map[string]bool{
+	"accesskey":     true,
+	"accountkey":    true,
+	"apikey":        true,
+	"authorization": true,
+	"licensekey":    true,
+	"privatekey":    true,
+	"privatekeyid":  true,
+	"secretkey":     true,
+}
+
+// MarshalRedacted marshals the configuration to YAML, replacing the values of
+// options which hold credentials, such as passwords, secrets and keys, so
+// that the configuration can be shared for troubleshooting.
+func MarshalRedacted(config *Configuration) ([]byte, error) {
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(out, &doc); err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(redact(doc))
+}
+
+// redact replaces the values of sensitive keys within v.
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for i, item := range v {
+			if key, ok := item.Key.(string); ok && isSensitive(key) && item.Value != nil {
+				v[i].Value = redactValue(item.Value)
+				continue
+			}
+			v[i].Value = redact(item.Value)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// redactValue replaces a sensitive value, keeping lists as lists so that the
+// redacted configuration can still be parsed.
+func redactValue(v interface{}) interface{} {
+	if values, ok := v.([]interface{}); ok {
+		for i := range values {
+			values[i] = redacted
+		}
+		return values
+	}
+	return redacted
+}
+
+// isSensitive returns true if the option named key holds credentials. Keys
+// are matched regardless of case, underscores and dashes, so that snake case
+// options such as the private_key of inline gcs credentials match too.
+func isSensitive(key string) bool {
+	key = strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	return sensitiveKeys[key] ||
+		strings.HasSuffix(key, "password") ||
+		strings.HasSuffix(key, "secret") ||
+		strings.HasSuffix(key, "token")
+}
@@ -2,12 +2,20 @@ package context
 
 import (
 	"context"
+	"fmt"
 	"runtime"
 	"time"
 
 	"github.com/docker/distribution/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName is the name of the OpenTelemetry tracer used for spans started
+// by WithTrace.
+const tracerName = "github.com/docker/distribution"
+
 // WithTrace allocates a traced timing span in a new context. This allows a
 // caller to track the time between calling WithTrace and the returned done
 // function. When the done function is called, a log message is emitted with a
@@ -37,6 +45,11 @@ import (
 //
 // Notice that the function name is automatically resolved, along with the
 // package and a trace id is emitted that can be linked with parent ids.
+//
+// WithTrace also starts an OpenTelemetry span named after the calling
+// function, which is ended by the done function with the log message as its
+// "trace.message" attribute. The span is only recorded if a tracer provider
+// is installed.
 func WithTrace(ctx context.Context) (context.Context, func(format string, a ...interface{})) {
 	if ctx == nil {
 		ctx = Background()
@@ -44,6 +57,13 @@ func WithTrace(ctx context.Context) (context.Context, func(format string, a ...i
 
 	pc, file, line, _ := runtime.Caller(1)
 	f := runtime.FuncForPC(pc)
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, f.Name(), trace.WithAttributes(
+		attribute.String("code.function", f.Name()),
+		attribute.String("code.filepath", file),
+		attribute.Int("code.lineno", line),
+	))
+
 	ctx = &traced{
 		Context: ctx,
 		id:      uuid.Generate().String(),
@@ -55,6 +75,11 @@ func WithTrace(ctx context.Context) (context.Context, func(format string, a ...i
 	}
 
 	return ctx, func(format string, a ...interface{}) {
+		if span.IsRecording() {
+			span.SetAttributes(attribute.String("trace.message", fmt.Sprintf(format, a...)))
+		}
+		span.End()
+
 		GetLogger(ctx,
 			"trace.duration",
 			"trace.id",
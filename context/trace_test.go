@@ -4,6 +4,11 @@ import (
 	"runtime"
 	"testing"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // TestWithTrace ensures that tracing has the expected values in the context.
@@ -101,3 +106,36 @@ type valueTestCase struct {
 	expected      interface{}
 	notnilorempty bool // just check not empty/not nil
 }
+
+// TestWithTraceSpan ensures that WithTrace records an OpenTelemetry span
+// when a tracer provider is installed.
+func TestWithTraceSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	_, done := WithTrace(Background())
+	done("traced %s", "operation")
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("unexpected number of spans: %d != 1", len(spans))
+	}
+
+	span := spans[0]
+	if expected := "github.com/docker/distribution/context.TestWithTraceSpan"; span.Name() != expected {
+		t.Fatalf("unexpected span name: %q != %q", span.Name(), expected)
+	}
+
+	var message string
+	for _, attr := range span.Attributes() {
+		if attr.Key == attribute.Key("trace.message") {
+			message = attr.Value.AsString()
+		}
+	}
+	if message != "traced operation" {
+		t.Fatalf("unexpected trace message: %q", message)
+	}
+}
@@ -1,18 +1,35 @@
 package checks
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/distribution/health"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// defaultTimeout is the timeout of the gRPC and command checks if none is
+// given.
+const defaultTimeout = 10 * time.Second
+
+// maxCommandOutput is the maximum length of the output of a failed command
+// included in the error of the check.
+const maxCommandOutput = 512
+
 // FileChecker checks the existence of a file and returns an error
 // if the file exists.
 func FileChecker(f string) health.Checker {
@@ -71,3 +88,75 @@ func TCPChecker(addr string, timeout time.Duration) health.Checker {
 		return nil
 	})
 }
+
+// GRPCChecker queries the gRPC health checking protocol of the server at addr
+// and verifies that the service is serving. An empty service checks the
+// overall health of the server.
+func GRPCChecker(addr, service string, useTLS bool, timeout time.Duration) health.Checker {
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+
+	return health.CheckFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return fmt.Errorf("connection to %s failed: %v", addr, err)
+		}
+		defer conn.Close()
+
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return fmt.Errorf("health check of %s failed: %v", addr, err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return errors.New("downstream service returned unexpected status: " + resp.Status.String())
+		}
+		return nil
+	})
+}
+
+// CommandChecker runs a command and verifies that it exits successfully
+// within the timeout. The output of a failed command is included in the
+// error.
+func CommandChecker(command []string, timeout time.Duration) health.Checker {
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	return health.CheckFunc(func() error {
+		if len(command) == 0 {
+			return errors.New("no command to run")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var output bytes.Buffer
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+
+		err := cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("command %s timed out after %v", command[0], timeout)
+		}
+		if err != nil {
+			out := strings.TrimSpace(output.String())
+			if len(out) > maxCommandOutput {
+				out = out[:maxCommandOutput] + "..."
+			}
+			if out == "" {
+				return fmt.Errorf("command %s failed: %v", command[0], err)
+			}
+			return fmt.Errorf("command %s failed: %v: %s", command[0], err, out)
+		}
+		return nil
+	})
+}
@@ -1,7 +1,15 @@
 package checks
 
 import (
+	"net"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func TestFileChecker(t *testing.T) {
@@ -23,3 +31,48 @@ func TestHTTPChecker(t *testing.T) {
 		t.Errorf("Google at Portugal was expected as exists, error:%v", err)
 	}
 }
+
+func TestGRPCChecker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(l)
+	defer server.Stop()
+
+	healthServer.SetServingStatus("registry", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("broken", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	if err := GRPCChecker(l.Addr().String(), "registry", false, time.Second).Check(); err != nil {
+		t.Errorf("registry service was expected as serving, error:%v", err)
+	}
+	if err := GRPCChecker(l.Addr().String(), "broken", false, time.Second).Check(); err == nil {
+		t.Errorf("broken service was expected as not serving")
+	}
+	if err := GRPCChecker(l.Addr().String(), "unknown", false, time.Second).Check(); err == nil {
+		t.Errorf("unknown service was expected as not found")
+	}
+}
+
+func TestCommandChecker(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command checks require a POSIX shell")
+	}
+
+	if err := CommandChecker([]string{"sh", "-c", "exit 0"}, 0).Check(); err != nil {
+		t.Errorf("successful command was expected as healthy, error:%v", err)
+	}
+
+	err := CommandChecker([]string{"sh", "-c", "echo disk full; exit 1"}, 0).Check()
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("failing command was expected to report its output, error:%v", err)
+	}
+
+	err = CommandChecker([]string{"sleep", "10"}, 10*time.Millisecond).Check()
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("slow command was expected to time out, error:%v", err)
+	}
+}
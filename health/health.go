@@ -56,13 +56,60 @@ type Updater interface {
 	Update(status error)
 }
 
+// historySize is the number of recent results kept for each health check.
+const historySize = 10
+
+// Result records the outcome of a single run of a health check.
+type Result struct {
+	// Timestamp is the time at which the result was recorded.
+	Timestamp time.Time `json:"timestamp"`
+	// Error is the error returned by the check, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Recorder is implemented by checkers which keep a history of their most
+// recent results.
+type Recorder interface {
+	// History returns the most recent results, oldest first.
+	History() []Result
+}
+
+// history is a bounded list of results. It is not safe for concurrent use
+// and must be protected by the lock of its owner.
+type history struct {
+	results []Result
+}
+
+// record appends the status to the history, dropping the oldest result if
+// the history is full.
+func (h *history) record(status error) {
+	result := Result{Timestamp: time.Now()}
+	if status != nil {
+		result.Error = status.Error()
+	}
+
+	if len(h.results) == historySize {
+		copy(h.results, h.results[1:])
+		h.results = h.results[:historySize-1]
+	}
+	h.results = append(h.results, result)
+}
+
+// snapshot returns a copy of the recorded results.
+func (h *history) snapshot() []Result {
+	results := make([]Result, len(h.results))
+	copy(results, h.results)
+	return results
+}
+
 // updater implements Checker and Updater, providing an asynchronous Update
 // method.
 // This allows us to have a Checker that returns the Check() call immediately
 // not blocking on a potentially expensive check.
 type updater struct {
-	mu     sync.Mutex
-	status error
+	mu      sync.Mutex
+	status  error
+	history history
 }
 
 // Check implements the Checker interface
@@ -80,6 +127,15 @@ func (u *updater) Update(status error) {
 	defer u.mu.Unlock()
 
 	u.status = status
+	u.history.record(status)
+}
+
+// History implements the Recorder interface
+func (u *updater) History() []Result {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.history.snapshot()
 }
 
 // NewStatusUpdater returns a new updater
@@ -91,11 +147,19 @@ func NewStatusUpdater() Updater {
 // method.
 // This allows us to have a Checker that returns the Check() call immediately
 // not blocking on a potentially expensive check.
+//
+// The status only turns unhealthy after threshold consecutive failures, and
+// only turns healthy again after recoveryThreshold consecutive successes,
+// which damps flapping caused by transient failures.
 type thresholdUpdater struct {
-	mu        sync.Mutex
-	status    error
-	threshold int
-	count     int
+	mu                sync.Mutex
+	status            error
+	threshold         int
+	recoveryThreshold int
+	count             int
+	successes         int
+	unhealthy         bool
+	history           history
 }
 
 // Check implements the Checker interface
@@ -103,7 +167,7 @@ func (tu *thresholdUpdater) Check() error {
 	tu.mu.Lock()
 	defer tu.mu.Unlock()
 
-	if tu.count >= tu.threshold {
+	if tu.unhealthy {
 		return tu.status
 	}
 
@@ -116,20 +180,49 @@ func (tu *thresholdUpdater) Update(status error) {
 	tu.mu.Lock()
 	defer tu.mu.Unlock()
 
+	tu.history.record(status)
+
 	if status == nil {
 		tu.count = 0
-	} else if tu.count < tu.threshold {
+		tu.successes++
+		if tu.successes >= tu.recoveryThreshold {
+			tu.unhealthy = false
+		}
+		return
+	}
+
+	tu.successes = 0
+	if tu.count < tu.threshold {
 		tu.count++
 	}
+	if tu.count >= tu.threshold {
+		tu.unhealthy = true
+	}
 
+	// keep reporting the most recent failure while recovering
 	tu.status = status
 }
 
+// History implements the Recorder interface
+func (tu *thresholdUpdater) History() []Result {
+	tu.mu.Lock()
+	defer tu.mu.Unlock()
+
+	return tu.history.snapshot()
+}
+
 // NewThresholdStatusUpdater returns a new thresholdUpdater
 func NewThresholdStatusUpdater(t int) Updater {
 	return &thresholdUpdater{threshold: t}
 }
 
+// NewDampedStatusUpdater returns a new thresholdUpdater which requires t
+// consecutive failures to become unhealthy and r consecutive successes to
+// become healthy again.
+func NewDampedStatusUpdater(t, r int) Updater {
+	return &thresholdUpdater{threshold: t, recoveryThreshold: r}
+}
+
 // PeriodicChecker wraps an updater to provide a periodic checker
 func PeriodicChecker(check Checker, period time.Duration) Checker {
 	u := NewStatusUpdater()
@@ -161,6 +254,23 @@ func PeriodicThresholdChecker(check Checker, period time.Duration, threshold int
 	return tu
 }
 
+// PeriodicDampedChecker wraps an updater to provide a periodic checker that
+// requires threshold consecutive failures before it changes to unhealthy and
+// recoveryThreshold consecutive successes before it changes back to healthy.
+func PeriodicDampedChecker(check Checker, period time.Duration, threshold, recoveryThreshold int) Checker {
+	tu := NewDampedStatusUpdater(threshold, recoveryThreshold)
+	go func() {
+		t := time.NewTicker(period)
+		defer t.Stop()
+		for {
+			<-t.C
+			tu.Update(check.Check())
+		}
+	}()
+
+	return tu
+}
+
 // CheckStatus returns a map with all the current health check errors
 func (registry *Registry) CheckStatus() map[string]string { // TODO(stevvooe) this needs a proper type
 	registry.mu.RLock()
@@ -182,6 +292,27 @@ func CheckStatus() map[string]string {
 	return DefaultRegistry.CheckStatus()
 }
 
+// CheckHistory returns a map with the recent results of all registered health
+// checks which record their history.
+func (registry *Registry) CheckHistory() map[string][]Result {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	histories := make(map[string][]Result)
+	for k, v := range registry.registeredChecks {
+		if recorder, ok := v.(Recorder); ok {
+			histories[k] = recorder.History()
+		}
+	}
+
+	return histories
+}
+
+// CheckHistory returns a map with the recent results of all health checks in
+// the default registry which record their history.
+func CheckHistory() map[string][]Result {
+	return DefaultRegistry.CheckHistory()
+}
+
 // Register associates the checker with the provided name.
 func (registry *Registry) Register(name string, check Checker) {
 	if registry == nil {
@@ -241,9 +372,9 @@ func RegisterPeriodicThresholdFunc(name string, period time.Duration, threshold
 // StatusHandler returns a JSON blob with all the currently registered Health Checks
 // and their corresponding status.
 // Returns 503 if any Error status exists, 200 otherwise
-func StatusHandler(w http.ResponseWriter, r *http.Request) {
+func (registry *Registry) StatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		checks := CheckStatus()
+		checks := registry.CheckStatus()
 		status := http.StatusOK
 
 		// If there is an error, return 503
@@ -257,13 +388,37 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// StatusHandler returns a JSON blob with all the Health Checks registered in
+// the default registry and their corresponding status.
+// Returns 503 if any Error status exists, 200 otherwise
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultRegistry.StatusHandler(w, r)
+}
+
+// HistoryHandler returns a JSON blob with the recent results of all the
+// currently registered Health Checks which record their history.
+func (registry *Registry) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		historyResponse(w, r, registry.CheckHistory())
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
+// HistoryHandler returns a JSON blob with the recent results of all the
+// Health Checks registered in the default registry which record their
+// history.
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultRegistry.HistoryHandler(w, r)
+}
+
 // Handler returns a handler that will return 503 response code if the health
 // checks have failed. If everything is okay with the health checks, the
 // handler will pass through to the provided handler. Use this handler to
 // disable a web application when the health checks fail.
-func Handler(handler http.Handler) http.Handler {
+func (registry *Registry) Handler(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		checks := CheckStatus()
+		checks := registry.CheckStatus()
 		if len(checks) != 0 {
 			errcode.ServeJSON(w, errcode.ErrorCodeUnavailable.
 				WithDetail("health check failed: please see /debug/health"))
@@ -274,10 +429,30 @@ func Handler(handler http.Handler) http.Handler {
 	})
 }
 
+// Handler returns a handler that will return 503 response code if the health
+// checks of the default registry have failed. If everything is okay with the
+// health checks, the handler will pass through to the provided handler.
+func Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		DefaultRegistry.Handler(handler).ServeHTTP(w, r)
+	})
+}
+
 // statusResponse completes the request with a response describing the health
 // of the service.
 func statusResponse(w http.ResponseWriter, r *http.Request, status int, checks map[string]string) {
-	p, err := json.Marshal(checks)
+	jsonResponse(w, r, status, checks)
+}
+
+// historyResponse completes the request with a response describing the
+// recent results of the health checks.
+func historyResponse(w http.ResponseWriter, r *http.Request, histories map[string][]Result) {
+	jsonResponse(w, r, http.StatusOK, histories)
+}
+
+// jsonResponse completes the request with the JSON serialization of v.
+func jsonResponse(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	p, err := json.Marshal(v)
 	if err != nil {
 		context.GetLogger(context.Background()).Errorf("error serializing health status: %v", err)
 		p, err = json.Marshal(struct {
@@ -305,4 +480,5 @@ func statusResponse(w http.ResponseWriter, r *http.Request, status int, checks m
 func init() {
 	DefaultRegistry = NewRegistry()
 	http.HandleFunc("/debug/health", StatusHandler)
+	http.HandleFunc("/debug/health/history", HistoryHandler)
 }
@@ -105,3 +105,74 @@ func TestHealthHandler(t *testing.T) {
 	updater.Update(nil)
 	checkUp(t, "when server is back up") // now we should be back up.
 }
+
+// TestDampedStatusUpdater ensures that a damped updater only changes state
+// after the configured number of consecutive failures or successes.
+func TestDampedStatusUpdater(t *testing.T) {
+	updater := NewDampedStatusUpdater(2, 3)
+	failure := errors.New("backend unavailable")
+
+	updater.Update(failure)
+	if err := updater.Check(); err != nil {
+		t.Fatalf("expected healthy status after a single failure, got %v", err)
+	}
+
+	updater.Update(failure)
+	if err := updater.Check(); err != failure {
+		t.Fatalf("expected unhealthy status after two failures, got %v", err)
+	}
+
+	updater.Update(nil)
+	updater.Update(nil)
+	if err := updater.Check(); err != failure {
+		t.Fatalf("expected unhealthy status while recovering, got %v", err)
+	}
+
+	// a failure while recovering resets the consecutive successes
+	updater.Update(failure)
+	updater.Update(nil)
+	updater.Update(nil)
+	if err := updater.Check(); err != failure {
+		t.Fatalf("expected unhealthy status while recovering, got %v", err)
+	}
+
+	updater.Update(nil)
+	if err := updater.Check(); err != nil {
+		t.Fatalf("expected healthy status after three successes, got %v", err)
+	}
+}
+
+// TestCheckHistory ensures that the recent results of a check are recorded
+// and bounded.
+func TestCheckHistory(t *testing.T) {
+	registry := NewRegistry()
+	updater := NewThresholdStatusUpdater(1)
+	registry.Register("test_check", updater)
+	registry.RegisterFunc("no_history", func() error { return nil })
+
+	for i := 0; i < historySize+2; i++ {
+		updater.Update(fmt.Errorf("failure %d", i))
+	}
+	updater.Update(nil)
+
+	histories := registry.CheckHistory()
+	if len(histories) != 1 {
+		t.Fatalf("expected history for 1 check, got %d", len(histories))
+	}
+
+	results := histories["test_check"]
+	if len(results) != historySize {
+		t.Fatalf("expected %d results, got %d", historySize, len(results))
+	}
+	if results[0].Error != "failure 3" {
+		t.Fatalf("unexpected oldest result: %q", results[0].Error)
+	}
+	if results[historySize-1].Error != "" {
+		t.Fatalf("expected most recent result to succeed, got %q", results[historySize-1].Error)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Timestamp.Before(results[i-1].Timestamp) {
+			t.Fatal("results are not ordered oldest first")
+		}
+	}
+}
@@ -42,6 +42,10 @@ func init() {
 type Manifest struct {
 	manifest.Versioned
 
+	// ArtifactType is the type of an artifact, such as an SBOM, when the
+	// manifest describes an artifact rather than an image.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Config references the image configuration as a blob.
 	Config distribution.Descriptor `json:"config"`
 
@@ -49,6 +53,10 @@ type Manifest struct {
 	// configuration.
 	Layers []distribution.Descriptor `json:"layers"`
 
+	// Subject is the manifest this manifest refers to, such as the image
+	// an artifact describes, if any.
+	Subject *distribution.Descriptor `json:"subject,omitempty"`
+
 	// Annotations contains arbitrary metadata for the image manifest.
 	Annotations map[string]string `json:"annotations,omitempty"`
 }
@@ -0,0 +1,16 @@
+package strict
+
+import (
+	"testing"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// FuzzValidate implements a fuzzer
+// that targets Validate
+func FuzzValidate(f *testing.F) {
+	f.Add([]byte(image(layer(20), `"a": "b"`)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_ = Validate(v1.MediaTypeImageManifest, data, DefaultLimits)
+	})
+}
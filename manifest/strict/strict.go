@@ -0,0 +1,343 @@
+// Package strict validates manifest payloads before they are parsed, bounding
+// the resources an adversarial payload can consume and rejecting payloads
+// which JSON parsers may interpret differently.
+//
+// Fields unknown to a schema are ignored, as required by the OCI image
+// specification. Fields which only differ from a known field in case are
+// rejected, since they are silently bound to the known field by the JSON
+// decoder of Go but not by other implementations.
+package strict
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Limits bounds the resources spent on a manifest. A zero limit is not
+// enforced.
+type Limits struct {
+	// MaxSize is the maximum size of the payload in bytes.
+	MaxSize int64
+	// MaxDepth is the maximum nesting depth of JSON objects and arrays.
+	MaxDepth int
+	// MaxReferences is the maximum number of descriptors.
+	MaxReferences int
+	// MaxAnnotations is the maximum number of annotations of the manifest
+	// or of a descriptor.
+	MaxAnnotations int
+	// MaxAnnotationSize is the maximum size in bytes of the key and value of
+	// an annotation.
+	MaxAnnotationSize int
+}
+
+// DefaultLimits are limits which legitimate manifests stay well within.
+var DefaultLimits = Limits{
+	MaxSize:           4 << 20,
+	MaxDepth:          32,
+	MaxReferences:     1000,
+	MaxAnnotations:    256,
+	MaxAnnotationSize: 64 << 10,
+}
+
+// schemas are the types manifests are decoded to, by media type, as
+// registered with distribution.RegisterManifestSchema.
+var schemas = map[string]reflect.Type{
+	v1.MediaTypeImageManifest:          reflect.TypeOf(ocischema.Manifest{}),
+	v1.MediaTypeImageIndex:             reflect.TypeOf(ocischema.ImageIndex{}),
+	schema2.MediaTypeManifest:          reflect.TypeOf(schema2.Manifest{}),
+	manifestlist.MediaTypeManifestList: reflect.TypeOf(manifestlist.ManifestList{}),
+	//nolint:staticcheck // Ignore SA1019: schema1 is deprecated, but still accepted.
+	schema1.MediaTypeSignedManifest: reflect.TypeOf(schema1.Manifest{}),
+	//nolint:staticcheck // Ignore SA1019: schema1 is deprecated, but still accepted.
+	"": reflect.TypeOf(schema1.Manifest{}),
+	//nolint:staticcheck // Ignore SA1019: schema1 is deprecated, but still accepted.
+	"application/json": reflect.TypeOf(schema1.Manifest{}),
+}
+
+var (
+	descriptorType         = reflect.TypeOf(distribution.Descriptor{})
+	manifestDescriptorType = reflect.TypeOf(manifestlist.ManifestDescriptor{})
+)
+
+// Validate checks the payload of a manifest of the media type, given as the
+// Content-Type header of a request, against the limits and returns an error
+// describing the first violation.
+func Validate(mediaType string, p []byte, limits Limits) error {
+	if limits.MaxSize > 0 && int64(len(p)) > limits.MaxSize {
+		return fmt.Errorf("manifest of %d bytes exceeds the maximum size of %d bytes", len(p), limits.MaxSize)
+	}
+	// the JSON decoder replaces invalid UTF-8 instead of rejecting it
+	if !utf8.Valid(p) {
+		return errors.New("manifest is not valid UTF-8")
+	}
+	if err := scan(p, limits.MaxDepth); err != nil {
+		return err
+	}
+
+	if mediaType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(mediaType); err != nil {
+			return err
+		}
+	}
+	schema, ok := schemas[mediaType]
+	if !ok {
+		schema = schemas[""]
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("invalid manifest: %v", err)
+	}
+	w := &walker{limits: limits, fields: make(map[reflect.Type]map[string]reflect.Type), seen: make(map[string]descriptor)}
+	return w.walk(v, schema, "")
+}
+
+// frame is an object or array being scanned.
+type frame struct {
+	object    bool
+	expectKey bool
+	keys      map[string]struct{}
+}
+
+// scan checks the nesting depth of the payload and rejects duplicate keys,
+// which JSON parsers resolve differently, and trailing values.
+func scan(p []byte, maxDepth int) error {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+
+	var stack []*frame
+	var values int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid manifest: %v", err)
+		}
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && top.object && top.expectKey {
+			if delim, ok := tok.(json.Delim); ok && delim == '}' {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			key := tok.(string)
+			if _, ok := top.keys[key]; ok {
+				return fmt.Errorf("duplicate key %q in manifest", key)
+			}
+			top.keys[key] = struct{}{}
+			top.expectKey = false
+			continue
+		}
+
+		if top == nil {
+			if values++; values > 1 {
+				return errors.New("unexpected data after manifest")
+			}
+		} else if top.object {
+			top.expectKey = true
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			if maxDepth > 0 && len(stack) >= maxDepth {
+				return fmt.Errorf("manifest exceeds the maximum depth of %d", maxDepth)
+			}
+			f := &frame{object: tok == json.Delim('{'), expectKey: true}
+			if f.object {
+				f.keys = make(map[string]struct{})
+			}
+			stack = append(stack, f)
+		case json.Delim(']'):
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// descriptor is the identity of a descriptor, which must be the same for
+// every descriptor of a digest.
+type descriptor struct {
+	mediaType string
+	size      string
+	platform  interface{}
+	entry     bool
+}
+
+// walker walks a decoded manifest along the type of its schema.
+type walker struct {
+	limits     Limits
+	fields     map[reflect.Type]map[string]reflect.Type
+	references int
+	seen       map[string]descriptor
+}
+
+func (w *walker) walk(v interface{}, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// mismatching types are left to the decoder of the schema
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := w.fieldsOf(t)
+		for key, value := range obj {
+			ft, ok := fields[key]
+			if !ok {
+				for name := range fields {
+					if strings.EqualFold(key, name) {
+						return fmt.Errorf("field %q of %s only differs in case from %q", key, location(path), name)
+					}
+				}
+				continue
+			}
+			if err := w.walk(value, ft, join(path, key)); err != nil {
+				return err
+			}
+		}
+		if t == descriptorType || t == manifestDescriptorType {
+			return w.descriptor(obj, path)
+		}
+	case reflect.Slice, reflect.Array:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, value := range arr {
+			if err := w.walk(value, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		return w.annotations(m, path)
+	}
+	return nil
+}
+
+// descriptor counts a descriptor and checks that it agrees with the other
+// descriptors of its digest. Entries of an index must differ in digest or
+// platform.
+func (w *walker) descriptor(obj map[string]interface{}, path string) error {
+	w.references++
+	if w.limits.MaxReferences > 0 && w.references > w.limits.MaxReferences {
+		return fmt.Errorf("manifest exceeds the maximum of %d descriptors", w.limits.MaxReferences)
+	}
+
+	dgst, _ := obj["digest"].(string)
+	d := descriptor{
+		mediaType: fmt.Sprint(obj["mediaType"]),
+		size:      fmt.Sprint(obj["size"]),
+		platform:  obj["platform"],
+		entry:     strings.HasPrefix(path, "manifests["),
+	}
+	previous, ok := w.seen[dgst]
+	if !ok {
+		w.seen[dgst] = d
+		return nil
+	}
+	if previous.mediaType != d.mediaType || previous.size != d.size {
+		return fmt.Errorf("descriptor %s of digest %s conflicts with another descriptor of the digest", location(path), dgst)
+	}
+	if previous.entry && d.entry && reflect.DeepEqual(previous.platform, d.platform) {
+		return fmt.Errorf("descriptor %s duplicates another entry of digest %s", location(path), dgst)
+	}
+	return nil
+}
+
+func (w *walker) annotations(m map[string]interface{}, path string) error {
+	if w.limits.MaxAnnotations > 0 && len(m) > w.limits.MaxAnnotations {
+		return fmt.Errorf("%s exceeds the maximum of %d annotations", location(path), w.limits.MaxAnnotations)
+	}
+	if w.limits.MaxAnnotationSize > 0 {
+		for key, value := range m {
+			s, _ := value.(string)
+			if len(key)+len(s) > w.limits.MaxAnnotationSize {
+				return fmt.Errorf("annotation %q of %s exceeds the maximum size of %d bytes", key, location(path), w.limits.MaxAnnotationSize)
+			}
+		}
+	}
+	return nil
+}
+
+// fieldsOf returns the types of the JSON fields of a struct by name,
+// following the rules of the JSON decoder for embedded structs: the least
+// nested field of a name wins.
+func (w *walker) fieldsOf(t reflect.Type) map[string]reflect.Type {
+	if fields, ok := w.fields[t]; ok {
+		return fields
+	}
+
+	fields := make(map[string]reflect.Type)
+	depths := make(map[string]int)
+	var collect func(t reflect.Type, depth int)
+	collect = func(t reflect.Type, depth int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				collect(f.Type, depth+1)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if d, ok := depths[name]; ok && d <= depth {
+				continue
+			}
+			fields[name], depths[name] = f.Type, depth
+		}
+	}
+	collect(t, 0)
+
+	w.fields[t] = fields
+	return fields
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func location(path string) string {
+	if path == "" {
+		return "the manifest"
+	}
+	return path
+}
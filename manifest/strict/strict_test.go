@@ -0,0 +1,174 @@
+package strict
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	configDigest = "sha256:a2d7a9bbd1a1a8c3f5c3f8f8f1dcbf1b3c4f4ad1d0ed1d4f4b62ad33b6a6a1c3"
+	layerDigest  = "sha256:b1c9f8e7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9"
+)
+
+func image(layers string, annotations string) string {
+	return fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": %q, "size": 10},
+		"layers": [%s],
+		"annotations": {%s}
+	}`, configDigest, layers, annotations)
+}
+
+func layer(size int) string {
+	return fmt.Sprintf(`{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": %q, "size": %d}`, layerDigest, size)
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		mediaType string
+		payload   string
+		limits    Limits
+		err       string
+	}{
+		{
+			name:      "valid image",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   image(layer(20)+","+layer(20), `"org.opencontainers.image.title": "x"`),
+		},
+		{
+			name:      "unknown fields are ignored",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2, "config": {"digest": "` + configDigest + `", "data": "e30="}, "layers": [], "future": {"nested": [1]}}`,
+		},
+		{
+			name:      "media type parameters",
+			mediaType: v1.MediaTypeImageManifest + "; charset=utf-8",
+			payload:   image(layer(20), ""),
+		},
+		{
+			name:      "too large",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   image(layer(20), ""),
+			limits:    Limits{MaxSize: 64},
+			err:       "exceeds the maximum size of 64 bytes",
+		},
+		{
+			name:      "too deep",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2, "future": [[[{"a": [1]}]]]}`,
+			limits:    Limits{MaxDepth: 5},
+			err:       "exceeds the maximum depth of 5",
+		},
+		{
+			name:      "invalid UTF-8",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   "{\"schemaVersion\": 2, \"annotations\": {\"a\": \"\xff\"}}",
+			err:       "not valid UTF-8",
+		},
+		{
+			name:      "duplicate keys",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2, "layers": [], "config": {"digest": "` + configDigest + `"}, "layers": [{}]}`,
+			err:       `duplicate key "layers"`,
+		},
+		{
+			name:      "duplicate nested keys",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2, "config": {"size": 1, "digest": "` + configDigest + `", "size": 2}}`,
+			err:       `duplicate key "size"`,
+		},
+		{
+			name:      "trailing data",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2} {"schemaVersion": 1}`,
+			err:       "unexpected data after manifest",
+		},
+		{
+			name:      "invalid JSON",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2,`,
+			err:       "invalid manifest",
+		},
+		{
+			name:      "field differing in case",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2, "Layers": [], "layers": []}`,
+			err:       `field "Layers" of the manifest only differs in case from "layers"`,
+		},
+		{
+			name:      "descriptor field differing in case",
+			mediaType: schema2.MediaTypeManifest,
+			payload:   `{"schemaVersion": 2, "config": {"Digest": "` + configDigest + `"}, "layers": []}`,
+			err:       `field "Digest" of config only differs in case from "digest"`,
+		},
+		{
+			name:      "too many references",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   image(layer(20)+","+layer(20), ""),
+			limits:    Limits{MaxReferences: 2},
+			err:       "exceeds the maximum of 2 descriptors",
+		},
+		{
+			name:      "conflicting descriptors",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   image(layer(20)+","+layer(21), ""),
+			err:       "layers[1] of digest " + layerDigest + " conflicts",
+		},
+		{
+			name:      "too many annotations",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   image(layer(20), `"a": "1", "b": "2"`),
+			limits:    Limits{MaxAnnotations: 1},
+			err:       "annotations exceeds the maximum of 1 annotations",
+		},
+		{
+			name:      "annotation too large",
+			mediaType: v1.MediaTypeImageManifest,
+			payload:   image(`{"digest": "`+layerDigest+`", "annotations": {"key": "`+strings.Repeat("v", 10)+`"}}`, ""),
+			limits:    Limits{MaxAnnotationSize: 12},
+			err:       `annotation "key" of layers[0].annotations exceeds the maximum size of 12 bytes`,
+		},
+		{
+			name:      "duplicate index entries",
+			mediaType: v1.MediaTypeImageIndex,
+			payload: `{"schemaVersion": 2, "manifests": [
+				{"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + layerDigest + `", "size": 5, "platform": {"architecture": "amd64", "os": "linux"}},
+				{"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + layerDigest + `", "size": 5, "platform": {"architecture": "amd64", "os": "linux"}}
+			]}`,
+			err: "manifests[1] duplicates another entry",
+		},
+		{
+			name:      "index entries of several platforms",
+			mediaType: manifestlist.MediaTypeManifestList,
+			payload: `{"schemaVersion": 2, "manifests": [
+				{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "digest": "` + layerDigest + `", "size": 5, "platform": {"architecture": "amd64", "os": "linux"}},
+				{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "digest": "` + layerDigest + `", "size": 5, "platform": {"architecture": "arm64", "os": "linux"}}
+			]}`,
+		},
+		{
+			name:    "default schema",
+			payload: `{"schemaVersion": 1, "name": "foo/bar", "Tag": "latest", "tag": "latest"}`,
+			err:     `field "Tag" of the manifest only differs in case from "tag"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.mediaType, []byte(tc.payload), tc.limits)
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("unexpected error: %v, expected %q", err, tc.err)
+			}
+		})
+	}
+}
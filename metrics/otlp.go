@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// scopeName is the instrumentation scope of metrics converted from
+// Prometheus.
+const scopeName = "github.com/docker/distribution/metrics"
+
+// PrometheusProducer converts the metrics collected by a Prometheus gatherer
+// to OpenTelemetry metrics, so that the metrics registered with go-metrics
+// can be exported by an OpenTelemetry reader.
+//
+// Counters and histograms are reported as cumulative sums and histograms
+// starting at the creation of the producer, gauges and untyped metrics as
+// gauges. Summaries have no OpenTelemetry equivalent and are skipped.
+type PrometheusProducer struct {
+	gatherer prometheus.Gatherer
+	start    time.Time
+}
+
+// NewPrometheusProducer returns a producer converting the metrics of the
+// gatherer. If gatherer is nil, the default Prometheus registry is used.
+func NewPrometheusProducer(gatherer prometheus.Gatherer) *PrometheusProducer {
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	return &PrometheusProducer{
+		gatherer: gatherer,
+		start:    time.Now(),
+	}
+}
+
+// Produce gathers and converts the Prometheus metrics.
+func (p *PrometheusProducer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	scope := metricdata.ScopeMetrics{
+		Scope: instrumentation.Scope{Name: scopeName},
+	}
+	for _, family := range families {
+		m := metricdata.Metrics{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := metricdata.Sum[float64]{
+				Temporality: metricdata.CumulativeTemporality,
+				IsMonotonic: true,
+			}
+			for _, metric := range family.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: attributes(metric),
+					StartTime:  p.start,
+					Time:       now,
+					Value:      metric.GetCounter().GetValue(),
+				})
+			}
+			m.Data = sum
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			var gauge metricdata.Gauge[float64]
+			for _, metric := range family.GetMetric() {
+				value := metric.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = metric.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: attributes(metric),
+					Time:       now,
+					Value:      value,
+				})
+			}
+			m.Data = gauge
+		case dto.MetricType_HISTOGRAM:
+			histogram := metricdata.Histogram{
+				Temporality: metricdata.CumulativeTemporality,
+			}
+			for _, metric := range family.GetMetric() {
+				histogram.DataPoints = append(histogram.DataPoints, histogramDataPoint(metric.GetHistogram(), attributes(metric), p.start, now))
+			}
+			m.Data = histogram
+		default:
+			continue
+		}
+
+		scope.Metrics = append(scope.Metrics, m)
+	}
+
+	return []metricdata.ScopeMetrics{scope}, nil
+}
+
+// histogramDataPoint converts the cumulative buckets of a Prometheus
+// histogram to the per-bucket counts of an OpenTelemetry histogram.
+func histogramDataPoint(h *dto.Histogram, attrs attribute.Set, start, now time.Time) metricdata.HistogramDataPoint {
+	point := metricdata.HistogramDataPoint{
+		Attributes: attrs,
+		StartTime:  start,
+		Time:       now,
+		Count:      h.GetSampleCount(),
+		Sum:        h.GetSampleSum(),
+	}
+
+	var previous uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			// the +Inf bucket is implied by OpenTelemetry histograms
+			break
+		}
+		point.Bounds = append(point.Bounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-previous)
+
+	return point
+}
+
+// attributes converts the labels of a Prometheus metric to attributes.
+func attributes(metric *dto.Metric) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(metric.GetLabel()))
+	for _, label := range metric.GetLabel() {
+		kvs = append(kvs, attribute.String(label.GetName(), label.GetValue()))
+	}
+	return attribute.NewSet(kvs...)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestPrometheusProducer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests", Help: "requests"}, []string{"method"})
+	counter.WithLabelValues("GET").Add(3)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "inflight", Help: "inflight"})
+	gauge.Set(2)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Help: "latency", Buckets: []float64{1, 5}})
+	for _, v := range []float64{0.5, 2, 3, 10} {
+		histogram.Observe(v)
+	}
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "sizes", Help: "sizes"})
+	summary.Observe(1)
+	registry.MustRegister(counter, gauge, histogram, summary)
+
+	scopes, err := NewPrometheusProducer(registry).Produce(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error producing metrics: %v", err)
+	}
+	if len(scopes) != 1 {
+		t.Fatalf("unexpected number of scopes: %d != 1", len(scopes))
+	}
+
+	byName := make(map[string]metricdata.Aggregation)
+	for _, m := range scopes[0].Metrics {
+		byName[m.Name] = m.Data
+	}
+	if _, ok := byName["sizes"]; ok {
+		t.Fatalf("summaries should not be converted")
+	}
+
+	sum, ok := byName["requests"].(metricdata.Sum[float64])
+	if !ok || len(sum.DataPoints) != 1 || !sum.IsMonotonic {
+		t.Fatalf("unexpected counter conversion: %#v", byName["requests"])
+	}
+	if sum.DataPoints[0].Value != 3 {
+		t.Fatalf("unexpected counter value: %v != 3", sum.DataPoints[0].Value)
+	}
+	if method, _ := sum.DataPoints[0].Attributes.Value(attribute.Key("method")); method.AsString() != "GET" {
+		t.Fatalf("unexpected counter attribute: %q", method.AsString())
+	}
+
+	g, ok := byName["inflight"].(metricdata.Gauge[float64])
+	if !ok || len(g.DataPoints) != 1 || g.DataPoints[0].Value != 2 {
+		t.Fatalf("unexpected gauge conversion: %#v", byName["inflight"])
+	}
+
+	h, ok := byName["latency"].(metricdata.Histogram)
+	if !ok || len(h.DataPoints) != 1 {
+		t.Fatalf("unexpected histogram conversion: %#v", byName["latency"])
+	}
+	point := h.DataPoints[0]
+	if point.Count != 4 || point.Sum != 15.5 {
+		t.Fatalf("unexpected histogram count and sum: %d, %v", point.Count, point.Sum)
+	}
+	if !reflect.DeepEqual(point.Bounds, []float64{1, 5}) {
+		t.Fatalf("unexpected histogram bounds: %v", point.Bounds)
+	}
+	if !reflect.DeepEqual(point.BucketCounts, []uint64{1, 2, 1}) {
+		t.Fatalf("unexpected histogram bucket counts: %v", point.BucketCounts)
+	}
+}
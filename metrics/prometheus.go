@@ -13,4 +13,25 @@ var (
 
 	// NotificationsNamespace is the prometheus namespace of notification related metrics
 	NotificationsNamespace = metrics.NewNamespace(NamespacePrefix, "notifications", nil)
+
+	// RepositoryNamespace is the prometheus namespace of per-repository request metrics
+	RepositoryNamespace = metrics.NewNamespace(NamespacePrefix, "repository", nil)
+
+	// ReplicationNamespace is the prometheus namespace of replication related metrics
+	ReplicationNamespace = metrics.NewNamespace(NamespacePrefix, "replication", nil)
+
+	// TransparencyNamespace is the prometheus namespace of transparency log related metrics
+	TransparencyNamespace = metrics.NewNamespace(NamespacePrefix, "transparency", nil)
+
+	// ProxyNamespace is the prometheus namespace of pull through cache related metrics
+	ProxyNamespace = metrics.NewNamespace(NamespacePrefix, "proxy", nil)
+
+	// UsageNamespace is the prometheus namespace of storage usage accounting metrics
+	UsageNamespace = metrics.NewNamespace(NamespacePrefix, "usage", nil)
+
+	// TieredNamespace is the prometheus namespace of tiered storage metrics
+	TieredNamespace = metrics.NewNamespace(NamespacePrefix, "tiered", nil)
+
+	// MaintenanceNamespace is the prometheus namespace of scheduled maintenance metrics
+	MaintenanceNamespace = metrics.NewNamespace(NamespacePrefix, "maintenance", nil)
 )
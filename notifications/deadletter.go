@@ -0,0 +1,111 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// deadLetterQueue persists the events which could not be delivered to an
+// endpoint in a directory, one file per event, so that they survive restarts
+// of the registry and long outages of the endpoint.
+type deadLetterQueue struct {
+	dir string
+
+	mu  sync.Mutex
+	seq uint64
+
+	// replaying serializes replays, so that an event is never replayed
+	// twice.
+	replaying sync.Mutex
+}
+
+// newDeadLetterQueue returns a dead-letter queue persisting events in the
+// directory, which is created if needed.
+func newDeadLetterQueue(dir string) (*deadLetterQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &deadLetterQueue{dir: dir}, nil
+}
+
+// Write persists the event. Events are named after the time they were
+// persisted, so that they are replayed in order.
+func (dlq *deadLetterQueue) Write(event events.Event) error {
+	p, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	dlq.mu.Lock()
+	defer dlq.mu.Unlock()
+
+	dlq.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), dlq.seq%1000000)
+
+	// events are written aside and moved into place, so that partially
+	// written events are never replayed
+	tmp := filepath.Join(dlq.dir, "."+name)
+	if err := os.WriteFile(tmp, p, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dlq.dir, name))
+}
+
+// replay writes the persisted events to the sink in the order they were
+// persisted, removing them from the queue once written. It returns the
+// number of replayed events. Events written to the queue while replaying,
+// such as those the sink cannot accept, are left for the next replay.
+func (dlq *deadLetterQueue) replay(sink events.Sink) (int, error) {
+	dlq.replaying.Lock()
+	defer dlq.replaying.Unlock()
+
+	entries, err := os.ReadDir(dlq.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(dlq.dir, name)
+		p, err := os.ReadFile(path)
+		if err != nil {
+			return replayed, err
+		}
+
+		var event Event
+		if err := json.Unmarshal(p, &event); err != nil {
+			// corrupted events are kept aside for inspection rather than
+			// failing every replay
+			logrus.Errorf("deadletter: invalid event %s: %v", path, err)
+			if err := os.Rename(path, path+".invalid"); err != nil {
+				return replayed, err
+			}
+			continue
+		}
+
+		if err := sink.Write(event); err != nil {
+			return replayed, err
+		}
+		if err := os.Remove(path); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	return replayed, nil
+}
@@ -0,0 +1,237 @@
+package notifications
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+type recordingSink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (rs *recordingSink) Write(event events.Event) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.events = append(rs.events, event.(Event))
+	return nil
+}
+
+func (rs *recordingSink) Close() error {
+	return nil
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "endpoint")
+	dlq, err := newDeadLetterQueue(dir)
+	if err != nil {
+		t.Fatalf("unexpected error creating dead-letter queue: %v", err)
+	}
+
+	var written []Event
+	for _, repo := range []string{"library/a", "library/b", "library/c"} {
+		event := createTestEvent("push", repo, "blob")
+		if err := dlq.Write(event); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+		written = append(written, event)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000000-000000.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var sink recordingSink
+	replayed, err := dlq.replay(&sink)
+	if err != nil {
+		t.Fatalf("unexpected error replaying events: %v", err)
+	}
+	if replayed != len(written) {
+		t.Fatalf("unexpected number of replayed events: %d != %d", replayed, len(written))
+	}
+	for i, event := range sink.events {
+		if event.ID != written[i].ID || event.Target.Repository != written[i].Target.Repository {
+			t.Fatalf("unexpected replayed event %d: %v != %v", i, event, written[i])
+		}
+	}
+
+	// replayed events are removed, and invalid ones kept aside
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "00000000000000000000-000000.json.invalid" {
+		t.Fatalf("unexpected entries left in the queue: %v", entries)
+	}
+	if replayed, err := dlq.replay(&sink); err != nil || replayed != 0 {
+		t.Fatalf("unexpected second replay: %d, %v", replayed, err)
+	}
+}
+
+// TestEndpointDeadLetters ensures that the events an endpoint fails to
+// deliver are persisted, and delivered once the endpoint is created again.
+func TestEndpointDeadLetters(t *testing.T) {
+	// the endpoints created are not reported by the registry metrics of
+	// other tests
+	endpoints.mu.Lock()
+	registered := endpoints.registered
+	endpoints.mu.Unlock()
+	defer func() {
+		endpoints.mu.Lock()
+		endpoints.registered = registered
+		endpoints.mu.Unlock()
+	}()
+
+	var mu sync.Mutex
+	available := false
+	delivered := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered++
+	}))
+	defer server.Close()
+
+	config := EndpointConfig{
+		Threshold:     1,
+		Backoff:       time.Millisecond,
+		MaxBackoff:    10 * time.Millisecond,
+		MaxRetries:    2,
+		DeadLetterDir: t.TempDir(),
+	}
+	endpoint := NewEndpoint("test", server.URL, config)
+	if err := endpoint.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+
+	deadLettered := func() int {
+		var em EndpointMetrics
+		endpoint.ReadMetrics(&em)
+		return em.DeadLetters
+	}
+	for deadline := time.Now().Add(5 * time.Second); deadLettered() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("event was not persisted to the dead-letter queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// events pending on close are persisted as well
+	config.Backoff = time.Hour
+	config.MaxBackoff = 0
+	endpoint = NewEndpoint("test", server.URL, config)
+	if err := endpoint.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	if err := endpoint.Close(); err != nil {
+		t.Fatalf("unexpected error closing endpoint: %v", err)
+	}
+	if deadLettered() != 2 {
+		t.Fatalf("unexpected number of events persisted: %d", deadLettered())
+	}
+
+	mu.Lock()
+	available = true
+	mu.Unlock()
+
+	config.Backoff = time.Millisecond
+	endpoint = NewEndpoint("test", server.URL, config)
+	defer endpoint.Close()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := delivered
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("events of the dead-letter queue were not delivered: %d", n)
+		}
+	}
+	if entries, _ := os.ReadDir(config.DeadLetterDir); len(entries) != 0 {
+		t.Fatalf("unexpected entries left in the queue: %v", entries)
+	}
+}
+
+func TestEndpointReplay(t *testing.T) {
+	endpoints.mu.Lock()
+	registered := endpoints.registered
+	endpoints.mu.Unlock()
+	defer func() {
+		endpoints.mu.Lock()
+		endpoints.registered = registered
+		endpoints.mu.Unlock()
+	}()
+
+	var mu sync.Mutex
+	available := false
+	delivered := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered++
+	}))
+	defer server.Close()
+
+	withoutQueue := NewEndpoint("test", server.URL, EndpointConfig{})
+	defer withoutQueue.Close()
+	if _, err := withoutQueue.Replay(); err != ErrNoDeadLetterQueue {
+		t.Fatalf("unexpected error replaying without a dead-letter queue: %v", err)
+	}
+
+	endpoint := NewEndpoint("test", server.URL, EndpointConfig{
+		Threshold:     1,
+		Backoff:       time.Millisecond,
+		MaxBackoff:    10 * time.Millisecond,
+		MaxRetries:    2,
+		DeadLetterDir: t.TempDir(),
+	})
+	defer endpoint.Close()
+	if err := endpoint.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		var em EndpointMetrics
+		endpoint.ReadMetrics(&em)
+		if em.DeadLetters == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("event was not persisted to the dead-letter queue")
+		}
+	}
+
+	mu.Lock()
+	available = true
+	mu.Unlock()
+
+	replayed, err := endpoint.Replay()
+	if err != nil || replayed != 1 {
+		t.Fatalf("unexpected replay: %d, %v", replayed, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := delivered
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replayed event was not delivered")
+		}
+	}
+}
@@ -1,13 +1,21 @@
 package notifications
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/docker/distribution/configuration"
 	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
 )
 
+// ErrNoDeadLetterQueue is returned when replaying the dead-letter queue of an
+// endpoint configured without one.
+var ErrNoDeadLetterQueue = errors.New("endpoint has no dead-letter queue")
+
 // EndpointConfig covers the optional configuration parameters for an active
 // endpoint.
 type EndpointConfig struct {
@@ -15,9 +23,14 @@ type EndpointConfig struct {
 	Timeout           time.Duration
 	Threshold         int
 	Backoff           time.Duration
+	MaxBackoff        time.Duration
+	MaxRetries        int
+	QueueSize         int
+	DeadLetterDir     string
 	IgnoredMediaTypes []string
 	Transport         *http.Transport `json:"-"`
 	Ignore            configuration.Ignore
+	Kafka             *KafkaConfig `json:",omitempty"`
 }
 
 // defaults set any zero-valued fields to a reasonable default.
@@ -40,8 +53,9 @@ func (ec *EndpointConfig) defaults() {
 }
 
 // Endpoint is a reliable, queued, thread-safe sink that notify external http
-// services when events are written. Writes are non-blocking and always
-// succeed for callers but events may be queued internally.
+// services, or publishes to a Kafka topic, when events are written. Writes
+// are non-blocking and always succeed for callers but events may be queued
+// internally.
 type Endpoint struct {
 	events.Sink
 	url  string
@@ -49,29 +63,151 @@ type Endpoint struct {
 
 	EndpointConfig
 
-	metrics *safeMetrics
+	metrics     *safeMetrics
+	delivery    events.Sink
+	retrying    *events.RetryingSink
+	queue       *eventQueue
+	deadLetters *deadLetterQueue
 }
 
 // NewEndpoint returns a running endpoint, ready to receive events.
 func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
+	endpoint := newEndpoint(name, url, config)
+	endpoint.start(newHTTPSink(
+		endpoint.url, endpoint.Timeout, endpoint.Headers,
+		endpoint.Transport, endpoint.metrics.httpStatusListener()))
+	return endpoint
+}
+
+// NewKafkaEndpoint returns a running endpoint publishing events to the Kafka
+// topic of the configuration, ready to receive events. Events are published
+// within the timeout of the configuration.
+func NewKafkaEndpoint(name string, config EndpointConfig) (*Endpoint, error) {
+	if config.Kafka == nil {
+		return nil, fmt.Errorf("endpoint %s: no kafka topic configured", name)
+	}
+
+	url := fmt.Sprintf("kafka://%s/%s", strings.Join(config.Kafka.Brokers, ","), config.Kafka.Topic)
+	endpoint := newEndpoint(name, url, config)
+	sink, err := newKafkaSink(*endpoint.Kafka, endpoint.Timeout, endpoint.metrics.kafkaListener())
+	if err != nil {
+		return nil, err
+	}
+	endpoint.start(sink)
+	return endpoint, nil
+}
+
+// newEndpoint returns an endpoint with the defaults of the configuration
+// applied, to be started with the sink delivering its events.
+func newEndpoint(name, url string, config EndpointConfig) *Endpoint {
 	var endpoint Endpoint
 	endpoint.name = name
 	endpoint.url = url
 	endpoint.EndpointConfig = config
 	endpoint.defaults()
 	endpoint.metrics = newSafeMetrics(name)
+	return &endpoint
+}
 
-	// Configures the inmemory queue, retry, http pipeline.
-	endpoint.Sink = newHTTPSink(
-		endpoint.url, endpoint.Timeout, endpoint.Headers,
-		endpoint.Transport, endpoint.metrics.httpStatusListener())
-	endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
-	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
-	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
-	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
+// start wraps the sink with the queue, retries and dead-letter queue of the
+// endpoint, replays the events left undelivered by a previous run and
+// registers the endpoint.
+func (e *Endpoint) start(delivery events.Sink) {
+	if e.DeadLetterDir != "" {
+		deadLetters, err := newDeadLetterQueue(e.DeadLetterDir)
+		if err != nil {
+			logrus.Errorf("endpoint %s: undelivered events will be dropped, error creating dead-letter queue: %v", e.name, err)
+		} else {
+			e.deadLetters = deadLetters
+		}
+	}
 
-	register(&endpoint)
-	return &endpoint
+	// Configures the inmemory queue, retry, delivery pipeline.
+	e.delivery = delivery
+	e.retrying = events.NewRetryingSink(e.delivery, &backoffStrategy{
+		threshold:  e.Threshold,
+		backoff:    e.Backoff,
+		maxBackoff: e.MaxBackoff,
+		maxRetries: e.MaxRetries,
+		exhausted:  e.undelivered,
+	})
+	e.queue = newBoundedEventQueue(&undeliveredSink{
+		Sink:        e.retrying,
+		undelivered: e.undelivered,
+	}, e.QueueSize, e.undelivered, e.metrics.eventQueueListener())
+	e.Sink = e.queue
+	mediaTypes := append(e.Ignore.MediaTypes, e.IgnoredMediaTypes...)
+	e.Sink = newIgnoredSink(e.Sink, mediaTypes, e.Ignore.Actions)
+
+	// Events left undelivered by a previous run are queued again before
+	// any new event.
+	if e.deadLetters != nil {
+		replayed, err := e.deadLetters.replay(e.queue)
+		if err != nil {
+			logrus.Errorf("endpoint %s: error replaying dead-letter queue: %v", e.name, err)
+		}
+		if replayed > 0 {
+			logrus.Infof("endpoint %s: replayed %d events from the dead-letter queue", e.name, replayed)
+		}
+	}
+
+	register(e)
+}
+
+// Replay queues the events of the dead-letter queue of the endpoint for
+// delivery again, such as once the endpoint recovered from an outage, and
+// returns the number of replayed events. Events which fail to be delivered
+// again are persisted back to the dead-letter queue.
+func (e *Endpoint) Replay() (int, error) {
+	if e.deadLetters == nil {
+		return 0, ErrNoDeadLetterQueue
+	}
+	return e.deadLetters.replay(e.queue)
+}
+
+// Close stops the delivery of events. The events pending delivery are
+// persisted to the dead-letter queue of the endpoint, if any, and dropped
+// otherwise.
+func (e *Endpoint) Close() error {
+	// closing the retrying sink first makes the queue flush its events to
+	// the dead-letter queue right away, rather than delivering them
+	e.retrying.Close()
+	if err := e.queue.Close(); err != nil {
+		return err
+	}
+	return e.delivery.Close()
+}
+
+// undelivered persists the event, which could not be delivered, to the
+// dead-letter queue of the endpoint, or drops it if it has none.
+func (e *Endpoint) undelivered(event events.Event) {
+	if e.deadLetters == nil {
+		e.metrics.dropped()
+		logrus.Warnf("endpoint %s: dropping undelivered event", e.name)
+		return
+	}
+
+	if err := e.deadLetters.Write(event); err != nil {
+		e.metrics.dropped()
+		logrus.Errorf("endpoint %s: dropping undelivered event, error writing to dead-letter queue: %v", e.name, err)
+		return
+	}
+	e.metrics.deadLettered()
+}
+
+// undeliveredSink hands the events its sink fails to write, such as after
+// it was closed, to the undelivered function.
+type undeliveredSink struct {
+	events.Sink
+	undelivered func(event events.Event)
+}
+
+// Write writes the event to the sink.
+func (us *undeliveredSink) Write(event events.Event) error {
+	if err := us.Sink.Write(event); err != nil {
+		us.undelivered(event)
+	}
+	return nil
 }
 
 // Name returns the name of the endpoint, generally used for debugging.
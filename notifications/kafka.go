@@ -0,0 +1,182 @@
+package notifications
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	events "github.com/docker/go-events"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaConfig configures the Kafka topic an endpoint publishes events to.
+type KafkaConfig struct {
+	Brokers []string
+	Topic   string
+
+	// PartitionByRepository keys events by their repository, so that the
+	// events of a repository are published to the same partition, in
+	// order. Events are spread evenly over the partitions otherwise.
+	PartitionByRepository bool
+
+	// SASLMechanism is one of "plain", "scram-sha-256" and "scram-sha-512",
+	// or empty to connect without authentication.
+	SASLMechanism string
+	Username      string
+	Password      string `json:"-"`
+
+	// TLS configures the connections to the brokers, which are not
+	// encrypted if nil.
+	TLS *tls.Config `json:"-"`
+}
+
+// kafkaWriter publishes messages to a Kafka topic.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaListener is called on the outcomes of publishing events.
+type kafkaListener interface {
+	success(event events.Event)
+	err(err error, event events.Event)
+}
+
+// kafkaSink implements a single-flight sink publishing events to a Kafka
+// topic, one message per event. Like the http sink, it only makes an attempt
+// at publishing, and reliability should be provided by the caller.
+type kafkaSink struct {
+	topic                 string
+	partitionByRepository bool
+	timeout               time.Duration
+
+	mu        sync.Mutex
+	closed    bool
+	writer    kafkaWriter
+	listeners []kafkaListener
+}
+
+// newKafkaSink returns an unreliable, single-flight Kafka sink publishing
+// events within the timeout.
+func newKafkaSink(config KafkaConfig, timeout time.Duration, listeners ...kafkaListener) (*kafkaSink, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka: no broker configured")
+	}
+	if config.Topic == "" {
+		return nil, fmt.Errorf("kafka: no topic configured")
+	}
+
+	mechanism, err := saslMechanism(config.SASLMechanism, config.Username, config.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	var balancer kafka.Balancer = &kafka.RoundRobin{}
+	if config.PartitionByRepository {
+		balancer = &kafka.Hash{}
+	}
+
+	return &kafkaSink{
+		topic:                 config.Topic,
+		partitionByRepository: config.PartitionByRepository,
+		timeout:               timeout,
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(config.Brokers...),
+			Topic:    config.Topic,
+			Balancer: balancer,
+			// each event is acknowledged by all the in-sync replicas
+			// before the next one is published
+			RequiredAcks: kafka.RequireAll,
+			BatchSize:    1,
+			Transport: &kafka.Transport{
+				SASL: mechanism,
+				TLS:  config.TLS,
+			},
+		},
+		listeners: listeners,
+	}, nil
+}
+
+// saslMechanism returns the SASL mechanism to authenticate to the brokers
+// with, nil if none.
+func saslMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("kafka: unsupported SASL mechanism %q", name)
+	}
+}
+
+// Write publishes the event to the topic, in the same envelope as events
+// posted to http endpoints.
+func (ks *kafkaSink) Write(event events.Event) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.closed {
+		return ErrSinkClosed
+	}
+
+	p, err := json.Marshal(Envelope{Events: []events.Event{event}})
+	if err != nil {
+		for _, listener := range ks.listeners {
+			listener.err(err, event)
+		}
+		return fmt.Errorf("%v: error marshaling event envelope: %v", ks, err)
+	}
+
+	msg := kafka.Message{
+		Value: p,
+		Headers: []kafka.Header{
+			{Key: "Content-Type", Value: []byte(EventsMediaType)},
+		},
+	}
+	if e, ok := event.(Event); ok && ks.partitionByRepository {
+		msg.Key = []byte(e.Target.Repository)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ks.timeout)
+	defer cancel()
+	if err := ks.writer.WriteMessages(ctx, msg); err != nil {
+		for _, listener := range ks.listeners {
+			listener.err(err, event)
+		}
+		return fmt.Errorf("%v: error publishing: %v", ks, err)
+	}
+
+	for _, listener := range ks.listeners {
+		listener.success(event)
+	}
+	return nil
+}
+
+// Close closes the connections to the brokers.
+func (ks *kafkaSink) Close() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.closed {
+		return fmt.Errorf("kafkasink: already closed")
+	}
+
+	ks.closed = true
+	return ks.writer.Close()
+}
+
+func (ks *kafkaSink) String() string {
+	return fmt.Sprintf("kafkaSink{%s}", ks.topic)
+}
@@ -0,0 +1,116 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type fakeKafkaWriter struct {
+	messages []kafka.Message
+	err      error
+	closed   bool
+}
+
+func (w *fakeKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("messages published without a timeout")
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeKafkaWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestKafkaSink(t *testing.T) {
+	metrics := newSafeMetrics("")
+	sink, err := newKafkaSink(KafkaConfig{
+		Brokers:               []string{"localhost:9092"},
+		Topic:                 "registry",
+		PartitionByRepository: true,
+		SASLMechanism:         "SCRAM-SHA-512",
+		Username:              "registry",
+		Password:              "secret",
+	}, time.Second, metrics.kafkaListener())
+	if err != nil {
+		t.Fatalf("unexpected error creating sink: %v", err)
+	}
+	writer := &fakeKafkaWriter{}
+	sink.writer = writer
+
+	event := createTestEvent("push", "library/test", "blob")
+	if err := sink.Write(event); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	writer.err = errors.New("broker unavailable")
+	if err := sink.Write(event); err == nil {
+		t.Fatal("expected an error writing to an unavailable broker")
+	}
+
+	if len(writer.messages) != 1 {
+		t.Fatalf("unexpected number of messages: %d", len(writer.messages))
+	}
+	msg := writer.messages[0]
+	if string(msg.Key) != "library/test" {
+		t.Fatalf("unexpected message key: %q", msg.Key)
+	}
+	if len(msg.Headers) != 1 || msg.Headers[0].Key != "Content-Type" || string(msg.Headers[0].Value) != EventsMediaType {
+		t.Fatalf("unexpected message headers: %v", msg.Headers)
+	}
+	var envelope struct {
+		Events []Event
+	}
+	if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+		t.Fatalf("unexpected error unmarshaling message: %v", err)
+	}
+	if len(envelope.Events) != 1 || envelope.Events[0].ID != event.ID {
+		t.Fatalf("unexpected events published: %v", envelope.Events)
+	}
+
+	metrics.Lock()
+	successes, errs := metrics.Successes, metrics.Errors
+	metrics.Unlock()
+	if successes != 1 || errs != 1 {
+		t.Fatalf("unexpected metrics: %d successes, %d errors", successes, errs)
+	}
+
+	if err := sink.Close(); err != nil || !writer.closed {
+		t.Fatalf("unexpected close: %v, %v", err, writer.closed)
+	}
+	if err := sink.Write(event); err != ErrSinkClosed {
+		t.Fatalf("unexpected error writing to a closed sink: %v", err)
+	}
+}
+
+func TestKafkaSinkConfig(t *testing.T) {
+	for _, tc := range []struct {
+		config KafkaConfig
+		valid  bool
+	}{
+		{config: KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "registry"}, valid: true},
+		{config: KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "registry", SASLMechanism: "plain"}, valid: true},
+		{config: KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "registry", SASLMechanism: "gssapi"}},
+		{config: KafkaConfig{Topic: "registry"}},
+		{config: KafkaConfig{Brokers: []string{"localhost:9092"}}},
+	} {
+		sink, err := newKafkaSink(tc.config, time.Second)
+		if tc.valid != (err == nil) {
+			t.Errorf("unexpected error creating sink of %+v: %v", tc.config, err)
+		}
+		if sink != nil {
+			if writer := sink.writer.(*kafka.Writer); writer.Topic != tc.config.Topic {
+				t.Errorf("unexpected topic: %q", writer.Topic)
+			}
+		}
+	}
+}
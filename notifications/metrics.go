@@ -24,12 +24,14 @@ var (
 // number of events. The goal of this to export it via expvar but we may find
 // some other future solution to be better.
 type EndpointMetrics struct {
-	Pending   int            // events pending in queue
-	Events    int            // total events incoming
-	Successes int            // total events written successfully
-	Failures  int            // total events failed
-	Errors    int            // total events errored
-	Statuses  map[string]int // status code histogram, per call event
+	Pending     int            // events pending in queue
+	Events      int            // total events incoming
+	Successes   int            // total events written successfully
+	Failures    int            // total events failed
+	Errors      int            // total events errored
+	Dropped     int            // total events dropped undelivered
+	DeadLetters int            // total events persisted to the dead-letter queue
+	Statuses    map[string]int // status code histogram, per call event
 }
 
 // safeMetrics guards the metrics implementation with a lock and provides a
@@ -48,6 +50,24 @@ func newSafeMetrics(name string) *safeMetrics {
 	return &sm
 }
 
+// dropped counts an event dropped undelivered.
+func (sm *safeMetrics) dropped() {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.Dropped++
+
+	eventsCounter.WithValues("Dropped", sm.EndpointName).Inc(1)
+}
+
+// deadLettered counts an event persisted to the dead-letter queue.
+func (sm *safeMetrics) deadLettered() {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.DeadLetters++
+
+	eventsCounter.WithValues("DeadLetters", sm.EndpointName).Inc(1)
+}
+
 // httpStatusListener returns the listener for the http sink that updates the
 // relevant counters.
 func (sm *safeMetrics) httpStatusListener() httpStatusListener {
@@ -56,6 +76,14 @@ func (sm *safeMetrics) httpStatusListener() httpStatusListener {
 	}
 }
 
+// kafkaListener returns the listener for the kafka sink that updates the
+// relevant counters.
+func (sm *safeMetrics) kafkaListener() kafkaListener {
+	return &endpointMetricsKafkaListener{
+		safeMetrics: sm,
+	}
+}
+
 // eventQueueListener returns a listener that maintains queue related counters.
 func (sm *safeMetrics) eventQueueListener() eventQueueListener {
 	return &endpointMetricsEventQueueListener{
@@ -99,6 +127,30 @@ func (emsl *endpointMetricsHTTPStatusListener) err(err error, event events.Event
 	eventsCounter.WithValues("Errors", emsl.EndpointName).Inc(1)
 }
 
+// endpointMetricsKafkaListener increments counters related to kafka sinks
+// for the relevant events.
+type endpointMetricsKafkaListener struct {
+	*safeMetrics
+}
+
+var _ kafkaListener = &endpointMetricsKafkaListener{}
+
+func (emkl *endpointMetricsKafkaListener) success(event events.Event) {
+	emkl.safeMetrics.Lock()
+	defer emkl.safeMetrics.Unlock()
+	emkl.Successes++
+
+	eventsCounter.WithValues("Successes", emkl.EndpointName).Inc(1)
+}
+
+func (emkl *endpointMetricsKafkaListener) err(err error, event events.Event) {
+	emkl.safeMetrics.Lock()
+	defer emkl.safeMetrics.Unlock()
+	emkl.Errors++
+
+	eventsCounter.WithValues("Errors", emkl.EndpointName).Inc(1)
+}
+
 // endpointMetricsEventQueueListener maintains the incoming events counter and
 // the queues pending count.
 type endpointMetricsEventQueueListener struct {
@@ -0,0 +1,84 @@
+package notifications
+
+import (
+	"sync"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+// backoffStrategy is a retry strategy for endpoints which, like a circuit
+// breaker, backs off once writes failed threshold times in a row. The backoff
+// doubles with each further failure, up to maxBackoff, and is reset by a
+// successful write. Events which still fail after maxRetries retries are
+// handed to the exhausted function and dropped. Events are retried until
+// they succeed if maxRetries is zero.
+type backoffStrategy struct {
+	threshold  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	maxRetries int
+	exhausted  func(event events.Event)
+
+	mu      sync.Mutex
+	recent  int // failures in a row
+	retries int // retries of the event being written
+	last    time.Time
+}
+
+var _ events.RetryStrategy = &backoffStrategy{}
+
+// Proceed returns the time left to back off for after the last failure.
+func (bs *backoffStrategy) Proceed(event events.Event) time.Duration {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.recent < bs.threshold {
+		return 0
+	}
+
+	return bs.last.Add(bs.delay()).Sub(time.Now())
+}
+
+// delay returns the backoff after the recent failures.
+func (bs *backoffStrategy) delay() time.Duration {
+	delay := bs.backoff
+	for i := bs.threshold; i < bs.recent && delay < bs.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > bs.maxBackoff && bs.maxBackoff > bs.backoff {
+		delay = bs.maxBackoff
+	}
+	return delay
+}
+
+// Success resets the failures.
+func (bs *backoffStrategy) Success(event events.Event) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.recent = 0
+	bs.retries = 0
+	bs.last = time.Time{}
+}
+
+// Failure records the failure, returning true to drop the event once its
+// retries are exhausted.
+func (bs *backoffStrategy) Failure(event events.Event, err error) bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.recent++
+	bs.last = time.Now().UTC()
+
+	if bs.maxRetries <= 0 || bs.retries < bs.maxRetries {
+		bs.retries++
+		return false
+	}
+
+	bs.retries = 0
+	if bs.exhausted != nil {
+		bs.exhausted(event)
+	}
+	return true
+}
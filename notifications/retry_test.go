@@ -0,0 +1,69 @@
+package notifications
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	events "github.com/docker/go-events"
+)
+
+func TestBackoffStrategy(t *testing.T) {
+	event := createTestEvent("push", "library/test", "blob")
+	var exhausted []Event
+	bs := &backoffStrategy{
+		threshold:  2,
+		backoff:    time.Second,
+		maxBackoff: 5 * time.Second,
+		maxRetries: 6,
+		exhausted: func(event events.Event) {
+			exhausted = append(exhausted, event.(Event))
+		},
+	}
+
+	// failures below the threshold are retried right away, then the
+	// backoff doubles up to the maximum
+	for i, expected := range []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if bs.recent >= bs.threshold {
+			if delay := bs.delay(); delay != expected {
+				t.Fatalf("unexpected backoff after %d failures: %v != %v", i, delay, expected)
+			}
+		} else if backoff := bs.Proceed(event); backoff != 0 {
+			t.Fatalf("unexpected backoff after %d failures: %v", i, backoff)
+		}
+
+		if dropped := bs.Failure(event, errors.New("failure")); dropped != (i == bs.maxRetries) {
+			t.Fatalf("unexpected drop after %d failures: %v", i+1, dropped)
+		}
+	}
+	if len(exhausted) != 1 || exhausted[0].ID != event.ID {
+		t.Fatalf("expected the event to be exhausted once: %v", exhausted)
+	}
+
+	// the retries of the next event are counted from zero, while the
+	// backoff is kept until an event is written
+	if bs.retries != 0 || bs.Proceed(event) <= 0 {
+		t.Fatalf("unexpected state after exhausting an event: %d retries, backoff %v", bs.retries, bs.Proceed(event))
+	}
+	bs.Success(event)
+	if backoff := bs.Proceed(event); backoff != 0 {
+		t.Fatalf("unexpected backoff after a success: %v", backoff)
+	}
+}
+
+func TestBackoffStrategyConstant(t *testing.T) {
+	event := createTestEvent("push", "library/test", "blob")
+	bs := &backoffStrategy{
+		threshold: 1,
+		backoff:   time.Second,
+	}
+
+	for i := 0; i < 100; i++ {
+		if bs.Failure(event, errors.New("failure")) {
+			t.Fatal("unexpected drop without a maximum of retries")
+		}
+	}
+	if delay := bs.delay(); delay != time.Second {
+		t.Fatalf("unexpected backoff without a maximum backoff: %v", delay)
+	}
+}
@@ -10,12 +10,15 @@ import (
 )
 
 // eventQueue accepts all messages into a queue for asynchronous consumption
-// by a sink. It is unbounded and thread safe but the sink must be reliable or
-// events will be dropped.
+// by a sink. It is thread safe but the sink must be reliable or events will
+// be dropped. The queue is unbounded unless a limit is set, in which case the
+// events written while it is full are handed to the overflow function.
 type eventQueue struct {
 	sink      events.Sink
 	events    *list.List
 	listeners []eventQueueListener
+	limit     int
+	overflow  func(event events.Event)
 	cond      *sync.Cond
 	mu        sync.Mutex
 	closed    bool
@@ -30,10 +33,19 @@ type eventQueueListener interface {
 // newEventQueue returns a queue to the provided sink. If the updater is non-
 // nil, it will be called to update pending metrics on ingress and egress.
 func newEventQueue(sink events.Sink, listeners ...eventQueueListener) *eventQueue {
+	return newBoundedEventQueue(sink, 0, nil, listeners...)
+}
+
+// newBoundedEventQueue returns a queue to the provided sink holding at most
+// limit events, passing the events which do not fit to overflow. Events
+// which do not fit are dropped if overflow is nil.
+func newBoundedEventQueue(sink events.Sink, limit int, overflow func(event events.Event), listeners ...eventQueueListener) *eventQueue {
 	eq := eventQueue{
 		sink:      sink,
 		events:    list.New(),
 		listeners: listeners,
+		limit:     limit,
+		overflow:  overflow,
 	}
 
 	eq.cond = sync.NewCond(&eq.mu)
@@ -51,6 +63,15 @@ func (eq *eventQueue) Write(event events.Event) error {
 		return ErrSinkClosed
 	}
 
+	if eq.limit > 0 && eq.events.Len() >= eq.limit {
+		if eq.overflow != nil {
+			eq.overflow(event)
+		} else {
+			logrus.Warnf("eventqueue: queue of %v is full, dropping event", eq.sink)
+		}
+		return nil
+	}
+
 	for _, listener := range eq.listeners {
 		listener.ingress(event)
 	}
@@ -63,6 +63,43 @@ func TestEventQueue(t *testing.T) {
 	}
 }
 
+func TestBoundedEventQueue(t *testing.T) {
+	var ts testSink
+	block := make(chan struct{})
+	var overflowed []events.Event
+	eq := newBoundedEventQueue(&blockingSink{Sink: &ts, block: block}, 2, func(event events.Event) {
+		overflowed = append(overflowed, event)
+	})
+
+	// the first event is being written to the blocked sink, the next two
+	// are queued and the rest overflow
+	for i := 0; i < 5; i++ {
+		if err := eq.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+			t.Fatalf("error writing event: %v", err)
+		}
+		if i == 0 {
+			for {
+				eq.mu.Lock()
+				n := eq.events.Len()
+				eq.mu.Unlock()
+				if n == 0 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	close(block)
+	checkClose(t, eq)
+
+	if ts.count != 3 {
+		t.Fatalf("unexpected number of events written: %d != 3", ts.count)
+	}
+	if len(overflowed) != 2 {
+		t.Fatalf("unexpected number of events overflowed: %d != 2", len(overflowed))
+	}
+}
+
 func TestIgnoredSink(t *testing.T) {
 	blob := createTestEvent("push", "library/test", "blob")
 	manifest := createTestEvent("pull", "library/test", "manifest")
@@ -145,6 +182,16 @@ func (ts *testSink) Close() error {
 	return nil
 }
 
+type blockingSink struct {
+	events.Sink
+	block chan struct{}
+}
+
+func (bs *blockingSink) Write(event events.Event) error {
+	<-bs.block
+	return bs.Sink.Write(event)
+}
+
 type delayedSink struct {
 	events.Sink
 	delay time.Duration
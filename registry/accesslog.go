@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/handlers"
+	"github.com/felixge/httpsnoop"
+	gorhandlers "github.com/gorilla/handlers"
+)
+
+// configureAccessLog wraps the handler with the configured access logger.
+func configureAccessLog(config *configuration.Configuration, handler http.Handler) (http.Handler, error) {
+	accessLog := config.Log.AccessLog
+	if accessLog.Disabled {
+		return handler, nil
+	}
+
+	out := logOutput(accessLog.Output, os.Stdout)
+	switch accessLog.Formatter {
+	case "", "combined":
+		return gorhandlers.CombinedLoggingHandler(out, handler), nil
+	case "json":
+		return jsonAccessLog(out, accessLog.Fields, handler), nil
+	default:
+		return nil, fmt.Errorf("unsupported access log formatter: %q", accessLog.Formatter)
+	}
+}
+
+// jsonAccessLog logs every request as a JSON object on a single line,
+// including the static fields. Keys match those of the application logs, so
+// that access log entries can be correlated with them.
+func jsonAccessLog(w io.Writer, fields map[string]interface{}, handler http.Handler) http.Handler {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &handlers.AccessLogEntry{}
+		r = r.WithContext(handlers.WithAccessLogEntry(r.Context(), entry))
+
+		m := httpsnoop.CaptureMetrics(handler, rw, r)
+
+		record := make(map[string]interface{}, len(fields)+16)
+		for k, v := range fields {
+			record[k] = v
+		}
+		record["time"] = start.Format(time.RFC3339Nano)
+		record["http.request.remoteaddr"] = remoteHost(r)
+		record["http.request.method"] = r.Method
+		record["http.request.uri"] = r.RequestURI
+		record["http.request.proto"] = r.Proto
+		record["http.request.useragent"] = r.UserAgent()
+		record["http.response.status"] = m.Code
+		record["http.response.written"] = m.Written
+		record["http.response.duration"] = m.Duration.Seconds()
+		if referer := r.Referer(); referer != "" {
+			record["http.request.referer"] = referer
+		}
+		if entry.RequestID != "" {
+			record["http.request.id"] = entry.RequestID
+		}
+		if entry.User != "" {
+			record["auth.user.name"] = entry.User
+		}
+		if entry.Repository != "" {
+			record["vars.name"] = entry.Repository
+			record["action"] = entry.Action
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		_ = encoder.Encode(record)
+	})
+}
+
+// remoteHost returns the host of the remote address of the request.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
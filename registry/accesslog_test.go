@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/handlers"
+)
+
+func TestJSONAccessLog(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+
+	var buf bytes.Buffer
+	handler := jsonAccessLog(&buf, map[string]interface{}{"environment": "test"}, handlers.NewApp(context.Background(), config))
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/foo/bar/manifests/latest", nil)
+	req.Header.Set("User-Agent", "docker/24.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("error decoding access log entry %q: %v", buf.String(), err)
+	}
+
+	for key, expected := range map[string]interface{}{
+		"environment":            "test",
+		"http.request.method":    http.MethodGet,
+		"http.request.uri":       "/v2/foo/bar/manifests/latest",
+		"http.request.useragent": "docker/24.0",
+		"http.response.status":   float64(http.StatusNotFound),
+		"vars.name":              "foo/bar",
+		"action":                 "pull",
+	} {
+		if record[key] != expected {
+			t.Errorf("unexpected value for %q: %v != %v", key, record[key], expected)
+		}
+	}
+	for _, key := range []string{"time", "http.request.id", "http.response.written", "http.response.duration"} {
+		if _, ok := record[key]; !ok {
+			t.Errorf("missing %q in access log entry", key)
+		}
+	}
+}
+
+func TestConfigureAccessLogUnsupportedFormatter(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Log.AccessLog.Formatter = "xml"
+
+	if _, err := configureAccessLog(config, http.NotFoundHandler()); err == nil {
+		t.Fatal("expected error for unsupported access log formatter")
+	}
+}
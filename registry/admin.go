@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/handlers"
+)
+
+// adminPathPrefix is the path prefix of the admin API.
+const adminPathPrefix = "/admin/"
+
+// serveAdmin serves the admin API of app under /admin/ on the main listener,
+// passing all other requests to the handler. Admin requests are
+// authenticated with the credentials of the admin configuration rather than
+// the access controller of the registry.
+func serveAdmin(config *configuration.Configuration, app *handlers.App, handler http.Handler) (http.Handler, error) {
+	if !config.Admin.Enabled {
+		return handler, nil
+	}
+
+	admin := config.Admin
+	if admin.BearerToken == "" && admin.Username == "" && admin.Password == "" {
+		return nil, errors.New("admin: credentials are required to enable the admin API")
+	}
+	adminHandler := app.AdminHandler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if !authorizedRequest(r, admin.Username, admin.Password, admin.BearerToken) {
+			if admin.BearerToken != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		adminHandler.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/handlers"
+)
+
+func TestServeAdmin(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.Admin.Enabled = true
+	config.Admin.Username = "admin"
+	config.Admin.Password = "secret"
+
+	app := handlers.NewApp(context.Background(), config)
+	handler, err := serveAdmin(config, app, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error configuring admin API: %v", err)
+	}
+
+	for _, tc := range []struct {
+		path               string
+		username, password string
+		expected           int
+	}{
+		{path: "/v2/", expected: http.StatusTeapot},
+		{path: "/admin/v1/readonly", expected: http.StatusUnauthorized},
+		{path: "/admin/v1/readonly", username: "admin", password: "wrong", expected: http.StatusUnauthorized},
+		{path: "/admin/v1/readonly", username: "admin", password: "secret", expected: http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.username != "" {
+			req.SetBasicAuth(tc.username, tc.password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tc.expected {
+			t.Errorf("unexpected status for %s as %q: %d != %d", tc.path, tc.username, rec.Code, tc.expected)
+		}
+	}
+}
+
+func TestServeAdminRequiresCredentials(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.Admin.Enabled = true
+
+	app := handlers.NewApp(context.Background(), config)
+	if _, err := serveAdmin(config, app, http.NotFoundHandler()); err == nil {
+		t.Fatal("expected an error enabling the admin API without credentials")
+	}
+}
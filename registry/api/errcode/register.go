@@ -82,6 +82,16 @@ var (
 		service too many times`,
 		HTTPStatusCode: http.StatusTooManyRequests,
 	})
+
+	// ErrorCodeRequestTooLarge is returned if the body of a request exceeds
+	// the maximum size accepted by the endpoint.
+	ErrorCodeRequestTooLarge = Register("errcode", ErrorDescriptor{
+		Value:   "REQUESTTOOLARGE",
+		Message: "request entity too large",
+		Description: `Returned when the body of a request exceeds the
+		maximum size accepted by the endpoint`,
+		HTTPStatusCode: http.StatusRequestEntityTooLarge,
+	})
 )
 
 var (
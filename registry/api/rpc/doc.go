@@ -0,0 +1,5 @@
+// Package rpc defines the gRPC API of the registry, which mirrors read
+// operations of the HTTP API for clients preferring gRPC.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative registry.proto
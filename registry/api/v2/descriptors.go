@@ -112,6 +112,63 @@ var (
 		},
 	}
 
+	catalogLinkHeader = ParameterDescriptor{
+		Name:        "Link",
+		Type:        "link",
+		Description: "RFC5988 compliant rel='next' with URL to next result set, if available",
+		Format:      `<<url>?n=<last n value>&cursor=<opaque cursor>>; rel="next"`,
+	}
+
+	catalogPaginationParameters = []ParameterDescriptor{
+		paginationParameters[0],
+		paginationParameters[1],
+		{
+			Name:        "cursor",
+			Type:        "string",
+			Description: "Opaque cursor from the Link header of the previous response. Result set will include values after the cursor. Takes precedence over last.",
+			Format:      "<cursor>",
+			Required:    false,
+		},
+	}
+
+	catalogFilterParameters = []ParameterDescriptor{
+		{
+			Name:        "prefix",
+			Type:        "string",
+			Description: "Only return the repositories whose name starts with the prefix.",
+			Format:      "<prefix>",
+			Required:    false,
+		},
+		{
+			Name:        "contains",
+			Type:        "string",
+			Description: "Only return the repositories whose name contains the string.",
+			Format:      "<string>",
+			Required:    false,
+		},
+		{
+			Name:        "modifiedsince",
+			Type:        "string",
+			Description: "Only return the repositories tagged at or after the RFC 3339 time.",
+			Format:      "<time>",
+			Required:    false,
+		},
+		{
+			Name:        "modifiedbefore",
+			Type:        "string",
+			Description: "Only return the repositories last tagged before the RFC 3339 time.",
+			Format:      "<time>",
+			Required:    false,
+		},
+		{
+			Name:        "sort",
+			Type:        "string",
+			Description: "The order of the repositories: name, the default, for the lexical order, or modified for the most recently tagged first.",
+			Format:      "name|modified",
+			Required:    false,
+		},
+	}
+
 	unauthorizedResponseDescriptor = ResponseDescriptor{
 		Name:        "Authentication Required",
 		StatusCode:  http.StatusUnauthorized,
@@ -147,6 +204,35 @@ var (
 		},
 	}
 
+	invalidCatalogPaginationResponseDescriptor = ResponseDescriptor{
+		Name:        "Invalid pagination parameters",
+		Description: "The received parameter n or cursor was invalid in some way, as described by the error code. The client should resolve the issue and retry the request.",
+		StatusCode:  http.StatusBadRequest,
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			ErrorCodePaginationNumberInvalid,
+			ErrorCodePaginationCursorInvalid,
+		},
+	}
+
+	invalidCatalogQueryResponseDescriptor = ResponseDescriptor{
+		Name:        "Invalid catalog query",
+		Description: "A filtering or sorting parameter was invalid, as described by the error code. The client should resolve the issue and retry the request.",
+		StatusCode:  http.StatusBadRequest,
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			ErrorCodePaginationNumberInvalid,
+			ErrorCodePaginationCursorInvalid,
+			ErrorCodeCatalogQueryInvalid,
+		},
+	}
+
 	repositoryNotFoundResponseDescriptor = ResponseDescriptor{
 		Name:        "No Such Repository Error",
 		StatusCode:  http.StatusNotFound,
@@ -385,6 +471,59 @@ type ParameterDescriptor struct {
 	Examples []string
 }
 
+var (
+	graphQLResponseDescriptor = ResponseDescriptor{
+		Description: "The result of the query. Errors of the query, such as unknown fields or denied access to a repository, are listed in the \"errors\" field.",
+		StatusCode:  http.StatusOK,
+		Headers: []ParameterDescriptor{
+			{
+				Name:        "Content-Length",
+				Type:        "integer",
+				Description: "Length of the JSON response body.",
+				Format:      "<length>",
+			},
+		},
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format: `{
+    "data": {...},
+    "errors": [
+        {
+            "message": <message>,
+            "path": [<field>, ...]
+        },
+        ...
+    ]
+}`,
+		},
+	}
+
+	graphQLFailureDescriptors = []ResponseDescriptor{
+		{
+			Name:        "Invalid Request",
+			Description: "The query is missing, or the body or variables are not valid JSON.",
+			StatusCode:  http.StatusBadRequest,
+			Body: BodyDescriptor{
+				ContentType: "application/json",
+				Format:      errorsBody,
+			},
+			ErrorCodes: []errcode.ErrorCode{
+				ErrorCodeGraphQLRequestInvalid,
+			},
+		},
+		{
+			Name:        "Not allowed",
+			Description: "The GraphQL API is not enabled.",
+			StatusCode:  http.StatusMethodNotAllowed,
+			ErrorCodes: []errcode.ErrorCode{
+				errcode.ErrorCodeUnsupported,
+			},
+		},
+		unauthorizedResponseDescriptor,
+		tooManyRequestsDescriptor,
+	}
+)
+
 var routeDescriptors = []RouteDescriptor{
 	{
 		Name:        RouteNameBase,
@@ -513,6 +652,530 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameAnnotations,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_annotations",
+		Entity:      "Annotations",
+		Description: "Query manifests by the annotations and image configuration labels indexed by the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the digests of the manifests under the repository identified by `name` with the given annotation or label.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Annotation Query",
+						Description: "Return the digests of the manifests indexed with the annotation `key` set to `value`. Only keys configured to be indexed can be queried.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "key",
+								Type:        "string",
+								Description: "The annotation or label key.",
+								Format:      "<key>",
+								Required:    true,
+							},
+							{
+								Name:        "value",
+								Type:        "string",
+								Description: "The annotation or label value.",
+								Format:      "<value>",
+								Required:    true,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "A list of manifest digests for the named repository.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "key": <key>,
+    "value": <value>,
+    "manifests": [
+        <digest>,
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Query",
+								Description: "The key or value parameter is missing, or the key is not indexed.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeAnnotationQueryInvalid,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameSBOMs,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_sboms",
+		Entity:      "SBOMs",
+		Description: "Query images by the packages listed in the SBOMs attached to them, as indexed by the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the images under the repository identified by `name` whose SBOMs list the given package.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Package Query",
+						Description: "Return the digests of the images with an SPDX or CycloneDX SBOM listing the package, with the digests of the SBOM artifacts and the versions of the package they list. Package names are matched case-insensitively. If `version` is set, only versions equal to it, or starting with it followed by a dot or a dash, match.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "package",
+								Type:        "string",
+								Description: "The name of the package.",
+								Format:      "<package>",
+								Required:    true,
+							},
+							{
+								Name:        "version",
+								Type:        "string",
+								Description: "The version of the package, or a prefix of the version such as `2.14`.",
+								Format:      "<version>",
+								Required:    false,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "A list of images for the named repository.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "package": <package>,
+    "version": <version>,
+    "images": [
+        {
+            "digest": <digest>,
+            "sbom": <digest>,
+            "format": "spdx" | "cyclonedx",
+            "package": <package>,
+            "versions": [<version>, ...]
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Query",
+								Description: "The package parameter is missing.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeSBOMQueryInvalid,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "SBOMs are not indexed by the registry.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameVulnerabilities,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_vulnerabilities",
+		Entity:      "Vulnerabilities",
+		Description: "Retrieve the vulnerability status of the tags of a repository, summarized from the vulnerability reports attached to their images.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the vulnerability status of the tags under the repository identified by `name`.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Tag Status",
+						Description: "Return the vulnerability status of each tag of the repository, from the latest report attached to the image it points to.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The vulnerability status of the tags of the named repository, sorted by tag.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tags": [
+        {
+            "tag": <tag>,
+            "digest": <digest>,
+            "status": "unscanned" | "clean" | "vulnerable" | "blocked",
+            "highest": <severity>,
+            "severities": {
+                "critical": <count>,
+                "high": <count>,
+                "medium": <count>,
+                "low": <count>,
+                "unknown": <count>
+            },
+            "report": <digest>,
+            "scanner": "trivy" | "grype",
+            "created": <time>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Not allowed",
+								Description: "Vulnerability reports are not stored by the registry.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameVulnerability,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_vulnerabilities/{reference:" + reference.TagRegexp.String() + "|" + digest.DigestRegexp.String() + "}",
+		Entity:      "Vulnerability",
+		Description: "Retrieve the vulnerability status of an image, summarized from the vulnerability reports attached to it.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the vulnerability status of the image identified by `name` and `reference` where `reference` can be a tag or digest.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Image Status",
+						Description: "Return the vulnerability status of the image, from the latest report attached to it.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							referenceParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The vulnerability status of the image.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tag": <tag>,
+    "digest": <digest>,
+    "status": "unscanned" | "clean" | "vulnerable" | "blocked",
+    "highest": <severity>,
+    "severities": {
+        "critical": <count>,
+        "high": <count>,
+        "medium": <count>,
+        "low": <count>,
+        "unknown": <count>
+    },
+    "report": <digest>,
+    "scanner": "trivy" | "grype",
+    "created": <time>
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Unknown Manifest",
+								Description: "The image identified by `reference` is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "Vulnerability reports are not stored by the registry.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameReferrers,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/referrers/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Referrers",
+		Description: "Discover the manifests referring to a manifest through their `subject`, such as signatures, SBOMs and attestations of an image, as defined by the OCI distribution specification.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the referrers of the manifest identified by `name` and `digest`.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Referrers",
+						Description: "Return an image index listing the descriptors of the manifests referring to the subject, with their artifact type and annotations. The subject does not need to exist.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							{
+								Name:        "digest",
+								Type:        "path",
+								Required:    true,
+								Format:      digest.DigestRegexp.String(),
+								Description: "Digest of the subject manifest.",
+							},
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "artifactType",
+								Type:        "string",
+								Description: "Only list the referrers of this artifact type.",
+								Format:      "<artifact type>",
+								Required:    false,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The referrers of the subject, sorted by digest.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "OCI-Filters-Applied",
+										Type:        "string",
+										Description: "Set to `artifactType` if the referrers were filtered by artifact type.",
+										Format:      "artifactType",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/vnd.oci.image.index.v1+json",
+									Format: `{
+    "schemaVersion": 2,
+    "mediaType": "application/vnd.oci.image.index.v1+json",
+    "manifests": [
+        {
+            "mediaType": <media type>,
+            "digest": <digest>,
+            "size": <size>,
+            "artifactType": <artifact type>,
+            "annotations": {
+                <key>: <value>,
+                ...
+            }
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Digest",
+								Description: "The `digest` is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameFiles,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_files/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Files",
+		Description: "Retrieve the files of an image, merged from the contents of its layers.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the listing of the files of the image identified by `name` and the `digest` of its manifest.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Image Files",
+						Description: "Return the files of the image, as seen by a container running it. The layers are applied in order, with the files deleted by the whiteouts of a layer omitted. The listing of each layer is cached once computed.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							{
+								Name:        "digest",
+								Type:        "path",
+								Required:    true,
+								Format:      digest.DigestRegexp.String(),
+								Description: "Digest of the manifest of the image.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The files of the image, sorted by path. Layers which are not stored by the registry, or larger than the configured maximum, are listed as skipped.",
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "digest": <digest>,
+    "files": [
+        {
+            "path": <path>,
+            "type": "file" | "dir" | "symlink" | "hardlink" | "other",
+            "size": <size>,
+            "mode": <mode>,
+            "linkname": <target>,
+            "layer": <digest>
+        },
+        ...
+    ],
+    "skipped": [<digest>, ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Unknown Manifest",
+								Description: "The manifest identified by `digest` is unknown to the registry.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Not allowed",
+								Description: "File listings are not enabled, or the manifest is not an image manifest, such as an image index.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:        RouteNameManifest,
 		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/manifests/{reference:" + reference.TagRegexp.String() + "|" + digest.DigestRegexp.String() + "}",
@@ -560,6 +1223,18 @@ var routeDescriptors = []RouteDescriptor{
 							},
 							unauthorizedResponseDescriptor,
 							repositoryNotFoundResponseDescriptor,
+							{
+								Name:        "Vulnerable Manifest",
+								Description: "The pull of the manifest is denied, as its latest vulnerability report lists vulnerabilities of a severity the registry is configured to block.",
+								StatusCode:  http.StatusForbidden,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestVulnerable,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 							deniedResponseDescriptor,
 							tooManyRequestsDescriptor,
 						},
@@ -596,6 +1271,12 @@ var routeDescriptors = []RouteDescriptor{
 									},
 									contentLengthZeroHeader,
 									digestHeader,
+									{
+										Name:        "OCI-Subject",
+										Type:        "digest",
+										Description: "The digest of the `subject` of the manifest, if any, which the referrers of the subject list.",
+										Format:      "<digest>",
+									},
 								},
 							},
 						},
@@ -642,6 +1323,30 @@ var routeDescriptors = []RouteDescriptor{
 }`,
 								},
 							},
+							{
+								Name:        "Tag Immutable",
+								Description: "The tag is protected by the tag protection rules and points to another manifest.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeTagImmutable,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Manifest Unsigned",
+								Description: "The repository is protected by the signature policy and the manifest has no signature or attestation verified by a trusted key.",
+								StatusCode:  http.StatusForbidden,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnsigned,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 							{
 								Name:        "Not allowed",
 								Description: "Manifest put is not allowed because the registry is configured as a pull-through cache or for some other reason",
@@ -703,6 +1408,30 @@ var routeDescriptors = []RouteDescriptor{
 									Format:      errorsBody,
 								},
 							},
+							{
+								Name:        "Manifest Referenced",
+								Description: "The manifest is referenced by a manifest list or image index which is tagged in the repository. The index must be untagged or deleted before the manifest.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestReferenced,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Tag Immutable",
+								Description: "The tag, or a tag pointing to the manifest, is protected by the tag protection rules.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeTagImmutable,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 							{
 								Name:        "Not allowed",
 								Description: "Manifest or tag delete is not allowed because the registry is configured as a pull-through cache or `delete` has been disabled.",
@@ -1039,6 +1768,14 @@ var routeDescriptors = []RouteDescriptor{
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
 						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "digest-algorithm",
+								Type:        "query",
+								Format:      "<algorithm>",
+								Description: `Algorithm the blob is hashed with, such as ` + "`blake3`" + `. The upload must be completed with a digest of this algorithm. The registry lists the algorithms it supports in the ` + "`Docker-Digest-Algorithms`" + ` header.`,
+							},
+						},
 						Successes: []ResponseDescriptor{
 							{
 								Description: "The upload has been created. The `Location` header must be used to complete the upload. The response should be identical to a `GET` request on the contents of the returned `Location` header.",
@@ -1566,7 +2303,7 @@ var routeDescriptors = []RouteDescriptor{
 					{
 						Name:            "Catalog Fetch Paginated",
 						Description:     "Return the specified portion of repositories.",
-						QueryParameters: paginationParameters,
+						QueryParameters: catalogPaginationParameters,
 						Successes: []ResponseDescriptor{
 							{
 								StatusCode: http.StatusOK,
@@ -1577,7 +2314,7 @@ var routeDescriptors = []RouteDescriptor{
 		<name>,
 		...
 	]
-	"next": "<url>?last=<name>&n=<last value of n>"
+	"next": "<url>?cursor=<opaque cursor>&n=<last value of n>"
 }`,
 								},
 								Headers: []ParameterDescriptor{
@@ -1587,13 +2324,315 @@ var routeDescriptors = []RouteDescriptor{
 										Description: "Length of the JSON response body.",
 										Format:      "<length>",
 									},
-									linkHeader,
+									catalogLinkHeader,
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
-							invalidPaginationResponseDescriptor,
+							invalidCatalogPaginationResponseDescriptor,
+						},
+					},
+					{
+						Name:            "Catalog Fetch Filtered",
+						Description:     "Return the repositories matching the filters, in the requested order. Filtering and sorting require the catalog index to be enabled.",
+						QueryParameters: append(append([]ParameterDescriptor{}, catalogPaginationParameters...), catalogFilterParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"repositories": [
+		<name>,
+		...
+	]
+}`,
+								},
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									catalogLinkHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidCatalogQueryResponseDescriptor,
+							{
+								Name:        "Catalog Index Unavailable",
+								Description: "The catalog index is being built for the first time. The client should retry the request later.",
+								StatusCode:  http.StatusServiceUnavailable,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnavailable,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameVersion,
+		Path:        "/v2/_version",
+		Entity:      "Version",
+		Description: "Retrieve the build information of the registry, such as its version, revision and available storage drivers. Access requires the same authorization as the catalog.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the build information of the registry as a json response.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Version Fetch",
+						Successes: []ResponseDescriptor{
+							{
+								Description: "Returns the build information of the registry.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"package": "github.com/docker/distribution",
+	"version": <version>,
+	"revision": <revision>,
+	"goVersion": <go version>,
+	"platform": <os>/<arch>,
+	"buildTags": [<tag>, ...],
+	"storageDrivers": [<driver>, ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameMirrors,
+		Path:        "/v2/_mirrors",
+		Entity:      "Mirrors",
+		Description: "List the alternative endpoints clients may pull from, such as regional mirrors and CDN hosts, so that clients can discover where to pull from. Access requires the same authorization as the base API endpoint.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the configured endpoints as a json response, ordered by priority.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Mirrors Fetch",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "Returns the endpoints, the endpoints with the lowest priority being preferred.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"mirrors": [
+		{
+			"url": <url>,
+			"priority": <priority>,
+			"region": <region>
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "No Mirrors",
+								Description: "No alternative endpoints are configured.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameUsage,
+		Path:        "/v2/_usage",
+		Entity:      "Usage",
+		Description: "Retrieve the number of bytes stored by each repository and namespace, as tracked by the usage accounting of the registry. Access requires the same authorization as the catalog.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the storage usage of the repositories as a json response, sorted by name.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Usage Fetch",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "namespace",
+								Type:        "string",
+								Format:      "<namespace>",
+								Required:    false,
+								Description: "Restrict the usage to the repositories of the namespace, which is the first component of their name.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "Returns the usage of the repositories and namespaces, in bytes. Blobs shared by several repositories are counted in each of them.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"reconciledAt": <time>,
+	"total": <size>,
+	"namespaces": [
+		{
+			"name": <namespace>,
+			"size": <size>
+		},
+		...
+	],
+	"repositories": [
+		{
+			"name": <name>,
+			"size": <size>
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Usage Accounting Disabled",
+								Description: "The usage accounting is not enabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameGraphQL,
+		Path:        "/v2/_graphql",
+		Entity:      "GraphQL",
+		Description: "Query the metadata of repositories, tags, manifests and referrers with GraphQL, fetching nested data in a single request. The API is read-only and only served if enabled in the configuration. Listing repositories requires the same authorization as the catalog, and fields of a repository require pull access to it.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Execute a GraphQL query passed in the query string.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Query",
+						Description: "Execute the query, returning the data and the errors of the query as a GraphQL response.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "query",
+								Type:        "string",
+								Description: "The GraphQL query document.",
+								Format:      "<query>",
+								Required:    true,
+							},
+							{
+								Name:        "operationName",
+								Type:        "string",
+								Description: "The name of the operation to execute if the document has several.",
+								Format:      "<operation>",
+								Required:    false,
+							},
+							{
+								Name:        "variables",
+								Type:        "string",
+								Description: "The variables of the query as a JSON object.",
+								Format:      "<json>",
+								Required:    false,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							graphQLResponseDescriptor,
+						},
+						Failures: graphQLFailureDescriptors,
+					},
+				},
+			},
+			{
+				Method:      http.MethodPost,
+				Description: "Execute a GraphQL query passed in the request body.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Query",
+						Description: "Execute the query, returning the data and the errors of the query as a GraphQL response.",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+    "query": <query>,
+    "operationName": <operation>,
+    "variables": {<name>: <value>, ...}
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							graphQLResponseDescriptor,
 						},
+						Failures: graphQLFailureDescriptors,
 					},
 				},
 			},
@@ -155,4 +155,104 @@ var (
 		the maximum allowed.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
+
+	// ErrorCodePaginationCursorInvalid is returned when the `cursor`
+	// parameter cannot be decoded.
+	ErrorCodePaginationCursorInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PAGINATION_CURSOR_INVALID",
+		Message: "invalid pagination cursor",
+		Description: `Returned when the "cursor" parameter is not a cursor
+		returned in a pagination link by the registry.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeCatalogQueryInvalid is returned when the filtering or sorting
+	// parameters of a catalog request are invalid.
+	ErrorCodeCatalogQueryInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "CATALOG_QUERY_INVALID",
+		Message: "invalid catalog query",
+		Description: `Returned when a filtering or sorting parameter of a
+		catalog request is malformed, is used while the catalog index is
+		disabled, or the "last" parameter is used with another order than
+		the lexical one.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeAnnotationQueryInvalid is returned when the `key` or `value`
+	// parameter of an annotation query is missing.
+	ErrorCodeAnnotationQueryInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "ANNOTATION_QUERY_INVALID",
+		Message: "invalid annotation query",
+		Description: `Returned when the "key" or "value" parameter of an
+		annotation query is missing, or the key is not indexed by the
+		registry.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeSBOMQueryInvalid is returned when the `package` parameter of
+	// an SBOM query is missing.
+	ErrorCodeSBOMQueryInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "SBOM_QUERY_INVALID",
+		Message: "invalid SBOM query",
+		Description: `Returned when the "package" parameter of an SBOM query
+		is missing.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeManifestVulnerable is returned when the pull of a manifest is
+	// denied by the vulnerability policy of the registry.
+	ErrorCodeManifestVulnerable = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "MANIFEST_VULNERABLE",
+		Message: "manifest has vulnerabilities above the allowed severity",
+		Description: `Returned when the latest vulnerability report of a
+		manifest lists vulnerabilities of a severity the registry is
+		configured to deny pulls at.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeManifestUnsigned is returned when the push of a tag is
+	// denied by the signature policy of the registry.
+	ErrorCodeManifestUnsigned = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "MANIFEST_UNSIGNED",
+		Message: "manifest is not signed by a trusted key",
+		Description: `Returned when a tag is pushed to a repository protected
+		by the signature policy of the registry, and the manifest has no
+		signature or attestation in the repository which a trusted key or
+		certificate verifies. The detail includes the digest of the manifest
+		and the reason of the rejection.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeGraphQLRequestInvalid is returned when a GraphQL request
+	// carries no query or cannot be decoded.
+	ErrorCodeGraphQLRequestInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "GRAPHQL_REQUEST_INVALID",
+		Message: "invalid GraphQL request",
+		Description: `Returned when a GraphQL request has no query, or its
+		body or variables are not valid JSON. Errors in the query itself are
+		reported in the "errors" field of the GraphQL response.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeManifestReferenced is returned when deleting a manifest which
+	// a tagged manifest list or image index references.
+	ErrorCodeManifestReferenced = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "MANIFEST_REFERENCED",
+		Message: "manifest is referenced by a tagged index",
+		Description: `Returned when a manifest is deleted by digest while a
+		tagged manifest list or image index references it. The index must be
+		untagged or deleted first.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeTagImmutable is returned when moving or deleting a tag
+	// protected by the tag protection rules.
+	ErrorCodeTagImmutable = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `Returned when a manifest is pushed to a protected tag
+		which points to another manifest, or when a protected tag, or a
+		manifest a protected tag points to, is deleted.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
 )
@@ -16,6 +16,16 @@ const (
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
+	RouteNameVersion         = "version"
+	RouteNameAnnotations     = "annotations"
+	RouteNameSBOMs           = "sboms"
+	RouteNameVulnerabilities = "vulnerabilities"
+	RouteNameVulnerability   = "vulnerability"
+	RouteNameFiles           = "files"
+	RouteNameReferrers       = "referrers"
+	RouteNameGraphQL         = "graphql"
+	RouteNameMirrors         = "mirrors"
+	RouteNameUsage           = "usage"
 )
 
 var (
@@ -36,6 +36,21 @@ func TestRouter(t *testing.T) {
 			RequestURI: "/v2/",
 			Vars:       map[string]string{},
 		},
+		{
+			RouteName:  RouteNameVersion,
+			RequestURI: "/v2/_version",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameGraphQL,
+			RequestURI: "/v2/_graphql",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameMirrors,
+			RequestURI: "/v2/_mirrors",
+			Vars:       map[string]string{},
+		},
 		{
 			RouteName:  RouteNameManifest,
 			RequestURI: "/v2/foo/manifests/bar",
@@ -67,6 +82,59 @@ func TestRouter(t *testing.T) {
 				"name": "foo/bar",
 			},
 		},
+		{
+			RouteName:  RouteNameAnnotations,
+			RequestURI: "/v2/foo/bar/_annotations",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameSBOMs,
+			RequestURI: "/v2/foo/bar/_sboms",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameVulnerabilities,
+			RequestURI: "/v2/foo/bar/_vulnerabilities",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameVulnerability,
+			RequestURI: "/v2/foo/bar/_vulnerabilities/latest",
+			Vars: map[string]string{
+				"name":      "foo/bar",
+				"reference": "latest",
+			},
+		},
+		{
+			RouteName:  RouteNameVulnerability,
+			RequestURI: "/v2/foo/bar/_vulnerabilities/sha256:abcdef0919234",
+			Vars: map[string]string{
+				"name":      "foo/bar",
+				"reference": "sha256:abcdef0919234",
+			},
+		},
+		{
+			RouteName:  RouteNameFiles,
+			RequestURI: "/v2/foo/bar/_files/sha256:abcdef0919234",
+			Vars: map[string]string{
+				"name":   "foo/bar",
+				"digest": "sha256:abcdef0919234",
+			},
+		},
+		{
+			RouteName:  RouteNameReferrers,
+			RequestURI: "/v2/foo/bar/referrers/sha256:abcdef0919234",
+			Vars: map[string]string{
+				"name":   "foo/bar",
+				"digest": "sha256:abcdef0919234",
+			},
+		},
 		{
 			RouteName:  RouteNameTags,
 			RequestURI: "/v2/docker.com/foo/tags/list",
@@ -127,6 +127,145 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildMirrorsURL constructs a url to list the alternative endpoints clients
+// may pull from.
+func (ub *URLBuilder) BuildMirrorsURL() (string, error) {
+	route := ub.cloneRoute(RouteNameMirrors)
+
+	mirrorsURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return mirrorsURL.String(), nil
+}
+
+// BuildUsageURL constructs a url to get the storage usage of repositories.
+func (ub *URLBuilder) BuildUsageURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameUsage)
+
+	usageURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(usageURL, values...).String(), nil
+}
+
+// BuildVersionURL constructs a url to get the build information of the
+// registry.
+func (ub *URLBuilder) BuildVersionURL() (string, error) {
+	route := ub.cloneRoute(RouteNameVersion)
+
+	versionURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return versionURL.String(), nil
+}
+
+// BuildGraphQLURL constructs a url to query the metadata of the registry
+// with GraphQL.
+func (ub *URLBuilder) BuildGraphQLURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameGraphQL)
+
+	graphQLURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(graphQLURL, values...).String(), nil
+}
+
+// BuildAnnotationsURL constructs a url to query the manifests of the named
+// repository by annotation.
+func (ub *URLBuilder) BuildAnnotationsURL(name reference.Named, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameAnnotations)
+
+	annotationsURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(annotationsURL, values...).String(), nil
+}
+
+// BuildSBOMsURL constructs a url to query the images of the named repository
+// by the packages listed in their SBOMs.
+func (ub *URLBuilder) BuildSBOMsURL(name reference.Named, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameSBOMs)
+
+	sbomsURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(sbomsURL, values...).String(), nil
+}
+
+// BuildVulnerabilitiesURL constructs a url to list the vulnerability status
+// of the tags of the named repository.
+func (ub *URLBuilder) BuildVulnerabilitiesURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameVulnerabilities)
+
+	vulnerabilitiesURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return vulnerabilitiesURL.String(), nil
+}
+
+// BuildVulnerabilityURL constructs a url to fetch the vulnerability status of
+// the image identified by the reference, either a tag or a digest.
+func (ub *URLBuilder) BuildVulnerabilityURL(ref reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameVulnerability)
+
+	tagOrDigest := ""
+	switch v := ref.(type) {
+	case reference.Tagged:
+		tagOrDigest = v.Tag()
+	case reference.Digested:
+		tagOrDigest = v.Digest().String()
+	default:
+		return "", fmt.Errorf("reference must have a tag or digest")
+	}
+
+	vulnerabilityURL, err := route.URL("name", ref.Name(), "reference", tagOrDigest)
+	if err != nil {
+		return "", err
+	}
+
+	return vulnerabilityURL.String(), nil
+}
+
+// BuildFilesURL constructs a url to list the files of the image identified by
+// the canonical reference of its manifest.
+func (ub *URLBuilder) BuildFilesURL(ref reference.Canonical) (string, error) {
+	route := ub.cloneRoute(RouteNameFiles)
+
+	filesURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	if err != nil {
+		return "", err
+	}
+
+	return filesURL.String(), nil
+}
+
+// BuildReferrersURL constructs a url to list the manifests referring to the
+// manifest identified by the canonical reference.
+func (ub *URLBuilder) BuildReferrersURL(ref reference.Canonical, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameReferrers)
+
+	referrersURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(referrersURL, values...).String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -54,6 +54,10 @@ var (
 
 	// ErrAuthenticationFailure returned when authentication fails.
 	ErrAuthenticationFailure = errors.New("authentication failure")
+
+	// ErrAccessDenied is returned, possibly wrapped, when an authenticated
+	// user is not granted the requested access.
+	ErrAccessDenied = errors.New("access denied")
 )
 
 // UserInfo carries information about
@@ -0,0 +1,241 @@
+// Package ldap provides an access controller authenticating users of basic
+// authentication against an LDAP directory, such as Active Directory, and
+// granting access to repositories from the groups they are members of.
+//
+// This authentication method MUST be used under TLS, as basic authentication
+// sends passwords in the clear.
+package ldap
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/rules"
+)
+
+// defaultCacheTTL is how long successful authentications are reused, so that
+// the directory is not queried for every request of a pull or push.
+const defaultCacheTTL = time.Minute
+
+// maxCachedUsers bounds the number of cached authentications.
+const maxCachedUsers = 10000
+
+// rule grants the actions on the repositories matching any of the patterns
+// to the users, and to the members of the groups. A user or group named "*"
+// stands for all authenticated users.
+type rule struct {
+	Users       []string `yaml:"users,omitempty"`
+	Groups      []string `yaml:"groups,omitempty"`
+	rules.Grant `yaml:",inline"`
+}
+
+// cachedUser is a successful authentication of a user.
+type cachedUser struct {
+	password [sha256.Size]byte // salted hash
+	groups   []string
+	expires  time.Time
+}
+
+type accessController struct {
+	realm     string
+	directory directory
+	rules     []rule
+	cacheTTL  time.Duration
+
+	salt  [16]byte
+	mu    sync.Mutex
+	cache map[string]cachedUser
+}
+
+var _ auth.AccessController = &accessController{}
+
+// newAccessController creates an accessController using the given options.
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	realm, ok := options["realm"].(string)
+	if !ok || realm == "" {
+		return nil, fmt.Errorf(`"realm" must be set for ldap access controller`)
+	}
+
+	directory, err := newLDAPDirectory(options)
+	if err != nil {
+		return nil, err
+	}
+
+	ac := &accessController{
+		realm:     realm,
+		directory: directory,
+		cacheTTL:  defaultCacheTTL,
+		cache:     make(map[string]cachedUser),
+	}
+	if _, err := rand.Read(ac.salt[:]); err != nil {
+		return nil, err
+	}
+
+	switch v := options["cachettl"].(type) {
+	case nil:
+	case string:
+		if ac.cacheTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("ldap access controller requires a valid option duration: cachettl: %v", err)
+		}
+	case int:
+		ac.cacheTTL = time.Duration(v) * time.Second
+	default:
+		return nil, fmt.Errorf("ldap access controller requires a valid option duration: cachettl")
+	}
+
+	if option, ok := options["rules"]; ok {
+		if err := rules.Decode(option, &ac.rules); err != nil {
+			return nil, fmt.Errorf("ldap access controller: invalid option: rules: %v", err)
+		}
+	}
+	for i, rule := range ac.rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("ldap access controller: rule %d: %v", i, err)
+		}
+	}
+
+	return ac, nil
+}
+
+// Authorized authenticates the user of the request against the directory and
+// checks the rules grant all of the requested access. All access is granted
+// to authenticated users if no rules are configured.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, &challenge{
+			realm: ac.realm,
+			err:   auth.ErrInvalidCredential,
+		}
+	}
+
+	groups, err := ac.authenticate(username, password)
+	if err != nil {
+		if err == errInvalidCredentials {
+			dcontext.GetLogger(ctx).Errorf("error authenticating user %q: %v", username, err)
+			return nil, &challenge{
+				realm: ac.realm,
+				err:   auth.ErrAuthenticationFailure,
+			}
+		}
+		return nil, err
+	}
+
+	if len(ac.rules) > 0 {
+		for _, access := range accessRecords {
+			if !ac.allowed(username, groups, access) {
+				return nil, fmt.Errorf("%w: user %q may not %s %s %s", auth.ErrAccessDenied, username, access.Action, access.Type, access.Name)
+			}
+		}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
+}
+
+// authenticate returns the groups of the user, authenticating them against
+// the directory unless they were authenticated recently with the same
+// password.
+func (ac *accessController) authenticate(username, password string) ([]string, error) {
+	hash := sha256.Sum256(append(ac.salt[:], password...))
+	now := time.Now()
+
+	ac.mu.Lock()
+	cached, ok := ac.cache[username]
+	ac.mu.Unlock()
+	if ok && now.Before(cached.expires) && subtle.ConstantTimeCompare(cached.password[:], hash[:]) == 1 {
+		return cached.groups, nil
+	}
+
+	groups, err := ac.directory.authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if ac.cacheTTL > 0 {
+		ac.mu.Lock()
+		if len(ac.cache) >= maxCachedUsers {
+			for name, user := range ac.cache {
+				if now.After(user.expires) {
+					delete(ac.cache, name)
+				}
+			}
+		}
+		if len(ac.cache) < maxCachedUsers {
+			ac.cache[username] = cachedUser{password: hash, groups: groups, expires: now.Add(ac.cacheTTL)}
+		}
+		ac.mu.Unlock()
+	}
+	return groups, nil
+}
+
+// allowed returns whether any rule grants the access to the user.
+func (ac *accessController) allowed(username string, groups []string, access auth.Access) bool {
+	for _, rule := range ac.rules {
+		if rule.appliesTo(username, groups) && rule.Allows(access) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) validate() error {
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return fmt.Errorf("applies to no users or groups")
+	}
+	return r.Grant.Validate()
+}
+
+// appliesTo returns whether the rule applies to the user or any of their
+// groups.
+func (r rule) appliesTo(username string, groups []string) bool {
+	for _, user := range r.Users {
+		if user == "*" || user == username {
+			return true
+		}
+	}
+	for _, group := range r.Groups {
+		if group == "*" {
+			return true
+		}
+		for _, g := range groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// challenge implements the auth.Challenge interface.
+type challenge struct {
+	realm string
+	err   error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders sets the basic challenge header on the response.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ch.realm))
+}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("basic authentication challenge for realm %q: %s", ch.realm, ch.err)
+}
+
+func init() {
+	auth.Register("ldap", auth.InitFunc(newAccessController))
+}
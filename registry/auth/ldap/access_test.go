@@ -0,0 +1,182 @@
+package ldap
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// testDirectory authenticates users with fixed passwords and groups.
+type testDirectory struct {
+	passwords map[string]string
+	groups    map[string][]string
+	calls     int
+	err       error
+}
+
+func (d *testDirectory) authenticate(username, password string) ([]string, error) {
+	d.calls++
+	if d.err != nil {
+		return nil, d.err
+	}
+	if p, ok := d.passwords[username]; !ok || p != password {
+		return nil, errInvalidCredentials
+	}
+	return d.groups[username], nil
+}
+
+func newTestAccessController(t *testing.T, rules []interface{}) (*accessController, *testDirectory) {
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":  "test-realm",
+		"url":    "ldap://ldap.example.com",
+		"basedn": "dc=example,dc=com",
+		"rules":  rules,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDirectory{
+		passwords: map[string]string{"alice": "secret", "bob": "secret"},
+		groups:    map[string][]string{"alice": {"developers"}},
+	}
+	ac.(*accessController).directory = d
+	return ac.(*accessController), d
+}
+
+func authorize(ac auth.AccessController, username, password string, access ...auth.Access) (string, error) {
+	req := httptest.NewRequest("GET", "/v2/", nil)
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	ctx, err := ac.Authorized(context.WithRequest(context.Background(), req), access...)
+	if err != nil {
+		return "", err
+	}
+	return context.GetStringValue(ctx, auth.UserNameKey), nil
+}
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+}
+
+func TestAccessControllerAuthentication(t *testing.T) {
+	ac, d := newTestAccessController(t, nil)
+
+	if _, err := authorize(ac, "", ""); err == nil {
+		t.Fatal("expected challenge without credentials")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected challenge without credentials, got %v", err)
+	}
+	if _, err := authorize(ac, "alice", "wrong"); err == nil {
+		t.Fatal("expected challenge with wrong password")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected challenge with wrong password, got %v", err)
+	}
+
+	// all access is granted without rules
+	user, err := authorize(ac, "alice", "secret", repositoryAccess("any/repository", "delete"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user != "alice" {
+		t.Fatalf("unexpected user %q", user)
+	}
+
+	// authentications are cached for the same password only
+	calls := d.calls
+	if _, err := authorize(ac, "alice", "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.calls != calls {
+		t.Fatal("expected cached authentication to be reused")
+	}
+	if _, err := authorize(ac, "alice", "wrong"); err == nil {
+		t.Fatal("expected wrong password to be rejected after caching")
+	}
+
+	// errors of the directory are not challenges
+	d.err = errors.New("connection refused")
+	if _, err := authorize(ac, "bob", "secret"); err == nil {
+		t.Fatal("expected error when the directory is unavailable")
+	} else if _, ok := err.(auth.Challenge); ok {
+		t.Fatalf("unexpected challenge when the directory is unavailable: %v", err)
+	}
+}
+
+func TestAccessControllerRules(t *testing.T) {
+	ac, _ := newTestAccessController(t, []interface{}{
+		map[interface{}]interface{}{
+			"groups":       []interface{}{"developers"},
+			"repositories": []interface{}{"team-a/*"},
+			"actions":      []interface{}{"pull", "push"},
+		},
+		map[interface{}]interface{}{
+			"users":        []interface{}{"*"},
+			"repositories": []interface{}{"public/*"},
+			"actions":      []interface{}{"pull", "catalog"},
+		},
+	})
+
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+	for _, tc := range []struct {
+		user    string
+		access  auth.Access
+		allowed bool
+	}{
+		{"alice", repositoryAccess("team-a/app", "push"), true},
+		{"alice", repositoryAccess("team-a/app", "delete"), false},
+		{"bob", repositoryAccess("team-a/app", "pull"), false},
+		{"bob", repositoryAccess("public/app", "pull"), true},
+		{"bob", repositoryAccess("public/app", "push"), false},
+		{"bob", catalog, true},
+	} {
+		_, err := authorize(ac, tc.user, "secret", tc.access)
+		if tc.allowed && err != nil {
+			t.Errorf("%s %s %s: unexpected error: %v", tc.user, tc.access.Action, tc.access.Name, err)
+		}
+		if !tc.allowed && !errors.Is(err, auth.ErrAccessDenied) {
+			t.Errorf("%s %s %s: expected access denied, got %v", tc.user, tc.access.Action, tc.access.Name, err)
+		}
+	}
+}
+
+func TestAccessControllerOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{"url": "ldap://ldap.example.com", "basedn": "dc=example,dc=com"},
+		{"realm": "r", "basedn": "dc=example,dc=com"},
+		{"realm": "r", "url": "http://ldap.example.com", "basedn": "dc=example,dc=com"},
+		{"realm": "r", "url": "ldap://ldap.example.com"},
+		{"realm": "r", "url": "ldap://ldap.example.com", "userdn": "cn=admin,dc=example,dc=com"},
+		{"realm": "r", "url": "ldaps://ldap.example.com", "basedn": "dc=example,dc=com", "starttls": true},
+		{"realm": "r", "url": "ldap://ldap.example.com", "basedn": "dc=example,dc=com", "starttls": "yes"},
+		{"realm": "r", "url": "ldap://ldap.example.com", "basedn": "dc=example,dc=com", "ca": "/nonexistent/ca.pem"},
+		{"realm": "r", "url": "ldap://ldap.example.com", "basedn": "dc=example,dc=com", "cachettl": "soon"},
+		{"realm": "r", "url": "ldap://ldap.example.com", "basedn": "dc=example,dc=com", "rules": []interface{}{
+			map[interface{}]interface{}{"repositories": []interface{}{"*"}, "actions": []interface{}{"pull"}},
+		}},
+	} {
+		if _, err := newAccessController(options); err == nil {
+			t.Errorf("expected error creating access controller with options %v", options)
+		}
+	}
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":    "r",
+		"url":      "ldap://ldap.example.com:389",
+		"userdn":   "uid={username},ou=people,dc=example,dc=com",
+		"starttls": true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d := ac.(*accessController).directory.(*ldapDirectory)
+	if d.tlsConfig.ServerName != "ldap.example.com" {
+		t.Fatalf("unexpected TLS server name %q", d.tlsConfig.ServerName)
+	}
+	if _, err := d.authenticate("alice", ""); err != errInvalidCredentials {
+		t.Fatalf("expected empty password to be rejected without binding, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// errInvalidCredentials is returned when the directory rejects the username
+// or the password.
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// directory authenticates users, returning the groups they are members of.
+type directory interface {
+	authenticate(username, password string) (groups []string, err error)
+}
+
+// ldapDirectory authenticates users by binding to an LDAP server, such as
+// Active Directory, as them.
+type ldapDirectory struct {
+	url       string
+	startTLS  bool
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	// bindDN and bindPassword are the credentials of the account searching
+	// for the DN of users with userFilter under baseDN. The DN of users is
+	// built from the userDN template instead if set.
+	bindDN       string
+	bindPassword string
+	baseDN       string
+	userFilter   string
+	userDN       string
+
+	// groupBaseDN and groupFilter find the groups of users, named by
+	// groupAttribute.
+	groupBaseDN    string
+	groupFilter    string
+	groupAttribute string
+}
+
+// newLDAPDirectory configures the directory from the options of the access
+// controller.
+func newLDAPDirectory(options map[string]interface{}) (*ldapDirectory, error) {
+	d := &ldapDirectory{
+		timeout:        10 * time.Second,
+		userFilter:     "(uid={username})",
+		groupFilter:    "(|(member={dn})(uniqueMember={dn}))",
+		groupAttribute: "cn",
+	}
+
+	stringOptions := map[string]*string{
+		"url":            &d.url,
+		"binddn":         &d.bindDN,
+		"bindpassword":   &d.bindPassword,
+		"basedn":         &d.baseDN,
+		"userfilter":     &d.userFilter,
+		"userdn":         &d.userDN,
+		"groupbasedn":    &d.groupBaseDN,
+		"groupfilter":    &d.groupFilter,
+		"groupattribute": &d.groupAttribute,
+	}
+	for key, value := range stringOptions {
+		v, ok := options[key]
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("ldap access controller requires a valid option string: %q", key)
+		}
+		*value = s
+	}
+
+	if d.url == "" {
+		return nil, fmt.Errorf(`"url" must be set for ldap access controller`)
+	}
+	if !strings.HasPrefix(d.url, "ldap://") && !strings.HasPrefix(d.url, "ldaps://") {
+		return nil, fmt.Errorf("ldap access controller: url must start with ldap:// or ldaps://")
+	}
+	u, err := url.Parse(d.url)
+	if err != nil {
+		return nil, fmt.Errorf("ldap access controller: invalid url: %v", err)
+	}
+	if d.userDN == "" && d.baseDN == "" {
+		return nil, fmt.Errorf(`either "userdn" or "basedn" must be set for ldap access controller`)
+	}
+	if d.userDN != "" && !strings.Contains(d.userDN, "{username}") {
+		return nil, fmt.Errorf("ldap access controller: userdn must contain {username}")
+	}
+
+	if d.startTLS, err = boolOption(options, "starttls"); err != nil {
+		return nil, err
+	}
+	if d.startTLS && strings.HasPrefix(d.url, "ldaps://") {
+		return nil, fmt.Errorf("ldap access controller: starttls cannot be used with ldaps://")
+	}
+
+	insecureSkipVerify, err := boolOption(options, "insecureskipverify")
+	if err != nil {
+		return nil, err
+	}
+	d.tlsConfig = &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: insecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if ca, ok := options["ca"].(string); ok && ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("ldap access controller: error reading ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ldap access controller: no certificates in ca %s", ca)
+		}
+		d.tlsConfig.RootCAs = pool
+	}
+
+	return d, nil
+}
+
+// authenticate binds as the user and looks up their groups.
+func (d *ldapDirectory) authenticate(username, password string) ([]string, error) {
+	// an empty password makes an unauthenticated bind, which succeeds
+	if username == "" || password == "" {
+		return nil, errInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	userDN := strings.ReplaceAll(d.userDN, "{username}", ldap.EscapeDN(username))
+	if d.userDN == "" {
+		if userDN, err = d.searchUser(conn, username); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		return nil, fmt.Errorf("error binding as %s: %v", userDN, err)
+	}
+
+	if d.groupBaseDN == "" {
+		return nil, nil
+	}
+
+	// groups are searched as the service account if there is one, as users
+	// may not be allowed to
+	if d.bindDN != "" {
+		if err := conn.Bind(d.bindDN, d.bindPassword); err != nil {
+			return nil, fmt.Errorf("error binding as %s: %v", d.bindDN, err)
+		}
+	}
+
+	filter := strings.NewReplacer(
+		"{dn}", ldap.EscapeFilter(userDN),
+		"{username}", ldap.EscapeFilter(username),
+	).Replace(d.groupFilter)
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.groupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(d.timeout.Seconds()), false,
+		filter, []string{d.groupAttribute}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error searching groups of %s: %v", userDN, err)
+	}
+
+	groups := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.GetAttributeValues(d.groupAttribute)...)
+	}
+	return groups, nil
+}
+
+// searchUser finds the DN of the user, binding as the service account first
+// if there is one.
+func (d *ldapDirectory) searchUser(conn *ldap.Conn, username string) (string, error) {
+	if d.bindDN != "" {
+		if err := conn.Bind(d.bindDN, d.bindPassword); err != nil {
+			return "", fmt.Errorf("error binding as %s: %v", d.bindDN, err)
+		}
+	}
+
+	filter := strings.ReplaceAll(d.userFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(d.timeout.Seconds()), false,
+		filter, []string{"dn"}, nil,
+	))
+	if err != nil {
+		return "", fmt.Errorf("error searching user %q: %v", username, err)
+	}
+	if len(result.Entries) != 1 {
+		// unknown and ambiguous users cannot log in
+		return "", errInvalidCredentials
+	}
+	return result.Entries[0].DN, nil
+}
+
+func (d *ldapDirectory) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.url, ldap.DialWithTLSConfig(d.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %v", d.url, err)
+	}
+	conn.SetTimeout(d.timeout)
+
+	if d.startTLS {
+		if err := conn.StartTLS(d.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error starting TLS with %s: %v", d.url, err)
+		}
+	}
+	return conn, nil
+}
+
+// boolOption returns the value of an optional boolean option.
+func boolOption(options map[string]interface{}, key string) (bool, error) {
+	v, ok := options[key]
+	if !ok {
+		return false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("ldap access controller requires a valid option bool: %s", key)
+	}
+	return b, nil
+}
@@ -0,0 +1,187 @@
+// Package mtls provides an access controller authorizing requests from the
+// client certificate verified by the TLS server of the registry, configured
+// with http.tls.clientcas, and granting access to repositories from the
+// subject and the subject alternative names of the certificate.
+package mtls
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/rules"
+)
+
+// errNoCertificate is returned for requests without a verified client
+// certificate, such as when TLS is terminated by a proxy.
+var errNoCertificate = errors.New("no verified client certificate")
+
+// rule grants the actions on the repositories matching any of the patterns
+// to the certificates whose subject or subject alternative names match any of
+// the patterns of the rule.
+type rule struct {
+	CommonNames         []string `yaml:"commonnames,omitempty"`
+	OrganizationalUnits []string `yaml:"organizationalunits,omitempty"`
+	DNSNames            []string `yaml:"dnsnames,omitempty"`
+	EmailAddresses      []string `yaml:"emailaddresses,omitempty"`
+	URIs                []string `yaml:"uris,omitempty"`
+	rules.Grant         `yaml:",inline"`
+}
+
+type accessController struct {
+	rules []rule
+}
+
+var _ auth.AccessController = &accessController{}
+
+// newAccessController creates an accessController using the given options.
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	ac := &accessController{}
+
+	if option, ok := options["rules"]; ok {
+		if err := rules.Decode(option, &ac.rules); err != nil {
+			return nil, fmt.Errorf("mtls access controller: invalid option: rules: %v", err)
+		}
+	}
+	for i, rule := range ac.rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("mtls access controller: rule %d: %v", i, err)
+		}
+	}
+
+	return ac, nil
+}
+
+// Authorized checks the rules grant all of the requested access to the
+// verified client certificate of the request. All access is granted to
+// verified certificates if no rules are configured.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, challenge{err: errNoCertificate}
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+	username := certificateName(cert)
+
+	if len(ac.rules) > 0 {
+		for _, access := range accessRecords {
+			if !ac.allowed(cert, access) {
+				return nil, fmt.Errorf("%w: certificate %q may not %s %s %s", auth.ErrAccessDenied, username, access.Action, access.Type, access.Name)
+			}
+		}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
+}
+
+// certificateName names the user of the certificate after its common name,
+// or its first subject alternative name if it has none.
+func certificateName(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return cert.Subject.String()
+}
+
+// allowed returns whether any rule grants the access to the certificate.
+func (ac *accessController) allowed(cert *x509.Certificate, access auth.Access) bool {
+	for _, rule := range ac.rules {
+		if rule.appliesTo(cert) && rule.Allows(access) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) validate() error {
+	if len(r.CommonNames)+len(r.OrganizationalUnits)+len(r.DNSNames)+len(r.EmailAddresses)+len(r.URIs) == 0 {
+		return fmt.Errorf("applies to no certificates")
+	}
+	for _, patterns := range [][]string{r.CommonNames, r.OrganizationalUnits, r.DNSNames, r.EmailAddresses, r.URIs} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	return r.Grant.Validate()
+}
+
+// appliesTo returns whether the subject or any subject alternative name of
+// the certificate matches the rule.
+func (r rule) appliesTo(cert *x509.Certificate) bool {
+	if matchAny(r.CommonNames, cert.Subject.CommonName) {
+		return true
+	}
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if matchAny(r.OrganizationalUnits, ou) {
+			return true
+		}
+	}
+	for _, name := range cert.DNSNames {
+		if matchAny(r.DNSNames, name) {
+			return true
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if matchAny(r.EmailAddresses, email) {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if matchAny(r.URIs, uri.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny returns whether the value is not empty and matches any of the
+// patterns.
+func matchAny(patterns []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// challenge implements the auth.Challenge interface for requests without a
+// client certificate. There is no challenge header for certificates, which
+// are presented when the connection is established.
+type challenge struct {
+	err error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders does not set any header.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("client certificate authentication: %s", ch.err)
+}
+
+func init() {
+	auth.Register("mtls", auth.InitFunc(newAccessController))
+}
@@ -0,0 +1,136 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+)
+
+func authorize(ac auth.AccessController, cert *x509.Certificate, access ...auth.Access) (string, error) {
+	req := httptest.NewRequest("GET", "https://registry.example.com/v2/", nil)
+	if cert != nil {
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	ctx, err := ac.Authorized(context.WithRequest(context.Background(), req), access...)
+	if err != nil {
+		return "", err
+	}
+	return context.GetStringValue(ctx, auth.UserNameKey), nil
+}
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+}
+
+func TestAccessControllerCertificate(t *testing.T) {
+	ac, err := newAccessController(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authorize(ac, nil); err == nil {
+		t.Fatal("expected challenge without certificate")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected challenge without certificate, got %v", err)
+	}
+
+	// unverified certificates are not in the verified chains
+	req := httptest.NewRequest("GET", "https://registry.example.com/v2/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "ci"}}}}
+	if _, err := ac.Authorized(context.WithRequest(context.Background(), req)); err == nil {
+		t.Fatal("expected challenge with unverified certificate")
+	}
+
+	spiffe, _ := url.Parse("spiffe://example.com/ns/ci/sa/builder")
+	for _, tc := range []struct {
+		cert *x509.Certificate
+		user string
+	}{
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "ci"}, DNSNames: []string{"ci.example.com"}}, "ci"},
+		{&x509.Certificate{URIs: []*url.URL{spiffe}}, "spiffe://example.com/ns/ci/sa/builder"},
+		{&x509.Certificate{DNSNames: []string{"ci.example.com"}}, "ci.example.com"},
+	} {
+		user, err := authorize(ac, tc.cert, repositoryAccess("any/repository", "delete"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if user != tc.user {
+			t.Errorf("unexpected user %q, expected %q", user, tc.user)
+		}
+	}
+}
+
+func TestAccessControllerRules(t *testing.T) {
+	ac, err := newAccessController(map[string]interface{}{
+		"rules": []interface{}{
+			map[interface{}]interface{}{
+				"commonnames":  []interface{}{"ci-*"},
+				"uris":         []interface{}{"spiffe://example.com/ns/ci/sa/*"},
+				"repositories": []interface{}{"team-a/*"},
+				"actions":      []interface{}{"pull", "push"},
+			},
+			map[interface{}]interface{}{
+				"organizationalunits": []interface{}{"developers"},
+				"dnsnames":            []interface{}{"*.example.com"},
+				"repositories":        []interface{}{"public/*"},
+				"actions":             []interface{}{"pull", "catalog"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://example.com/ns/ci/sa/builder")
+	ci := &x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner"}}
+	workload := &x509.Certificate{URIs: []*url.URL{spiffe}}
+	developer := &x509.Certificate{Subject: pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"developers"}}}
+	host := &x509.Certificate{DNSNames: []string{"build.example.com"}}
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}, DNSNames: []string{"example.org"}}
+
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+	for _, tc := range []struct {
+		name    string
+		cert    *x509.Certificate
+		access  auth.Access
+		allowed bool
+	}{
+		{"ci push", ci, repositoryAccess("team-a/app", "push"), true},
+		{"ci delete", ci, repositoryAccess("team-a/app", "delete"), false},
+		{"workload push", workload, repositoryAccess("team-a/app", "push"), true},
+		{"developer pull", developer, repositoryAccess("public/app", "pull"), true},
+		{"developer push", developer, repositoryAccess("team-a/app", "push"), false},
+		{"host catalog", host, catalog, true},
+		{"other pull", other, repositoryAccess("public/app", "pull"), false},
+		{"other catalog", other, catalog, false},
+	} {
+		_, err := authorize(ac, tc.cert, tc.access)
+		if tc.allowed && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !tc.allowed && !errors.Is(err, auth.ErrAccessDenied) {
+			t.Errorf("%s: expected access denied, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestAccessControllerOptions(t *testing.T) {
+	for _, rules := range []interface{}{
+		"all",
+		[]interface{}{map[interface{}]interface{}{"repositories": []interface{}{"*"}, "actions": []interface{}{"pull"}}},
+		[]interface{}{map[interface{}]interface{}{"commonnames": []interface{}{"["}, "actions": []interface{}{"pull"}}},
+		[]interface{}{map[interface{}]interface{}{"commonnames": []interface{}{"ci"}}},
+		[]interface{}{map[interface{}]interface{}{"commonnames": []interface{}{"ci"}, "actions": []interface{}{"write"}}},
+	} {
+		if _, err := newAccessController(map[string]interface{}{"rules": rules}); err == nil {
+			t.Errorf("expected error creating access controller with rules %v", rules)
+		}
+	}
+}
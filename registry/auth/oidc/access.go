@@ -0,0 +1,257 @@
+// Package oidc provides an access controller authenticating requests with
+// the ID tokens of an OpenID Connect identity provider, such as Keycloak or
+// Dex, and granting access to repositories from the claims of the tokens.
+//
+// Clients send the ID token as a bearer token, or as the password of basic
+// authentication so that `docker login` can be used with it.
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/rules"
+)
+
+const (
+	// defaultClockSkew is the clock skew tolerated with the identity
+	// provider when checking the exp and nbf claims.
+	defaultClockSkew = 60 * time.Second
+
+	// defaultKeyRefresh is how often the keys of the identity provider
+	// are fetched again.
+	defaultKeyRefresh = time.Hour
+)
+
+// rule grants the actions on the repositories matching any of the patterns
+// to the tokens whose claim has any of the values. The value "*" matches any
+// value of the claim.
+type rule struct {
+	Claim       string   `yaml:"claim"`
+	Values      []string `yaml:"values"`
+	rules.Grant `yaml:",inline"`
+}
+
+type accessController struct {
+	realm         string
+	issuer        string
+	audiences     []string
+	usernameClaim string
+	clockSkew     time.Duration
+	rules         []rule
+	keys          *keySet
+}
+
+var _ auth.AccessController = &accessController{}
+
+// newAccessController creates an accessController using the given options.
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	ac := &accessController{
+		usernameClaim: "sub",
+		clockSkew:     defaultClockSkew,
+	}
+	keyRefresh := defaultKeyRefresh
+
+	var ok bool
+	if ac.realm, ok = options["realm"].(string); !ok || ac.realm == "" {
+		return nil, fmt.Errorf(`"realm" must be set for oidc access controller`)
+	}
+	if ac.issuer, ok = options["issuer"].(string); !ok || ac.issuer == "" {
+		return nil, fmt.Errorf(`"issuer" must be set for oidc access controller`)
+	}
+
+	var err error
+	ac.audiences, err = stringSliceOption(options, "audiences")
+	if err != nil {
+		return nil, err
+	}
+	if len(ac.audiences) == 0 {
+		return nil, fmt.Errorf(`"audiences" must be set for oidc access controller`)
+	}
+
+	jwksURL, _ := options["jwksurl"].(string)
+	if claim, ok := options["usernameclaim"].(string); ok && claim != "" {
+		ac.usernameClaim = claim
+	}
+	if ac.clockSkew, err = durationOption(options, "clockskew", ac.clockSkew); err != nil {
+		return nil, err
+	}
+	if keyRefresh, err = durationOption(options, "keyrefresh", keyRefresh); err != nil {
+		return nil, err
+	}
+
+	if option, ok := options["rules"]; ok {
+		if err := rules.Decode(option, &ac.rules); err != nil {
+			return nil, fmt.Errorf("oidc access controller: invalid option: rules: %v", err)
+		}
+	}
+	for i, rule := range ac.rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("oidc access controller: rule %d: %v", i, err)
+		}
+	}
+
+	ac.keys = &keySet{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		issuer:  ac.issuer,
+		jwksURL: jwksURL,
+		refresh: keyRefresh,
+	}
+	return ac, nil
+}
+
+// Authorized verifies the ID token of the request and checks the rules grant
+// all of the requested access. All access is granted to valid tokens if no
+// rules are configured.
+func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rawToken, ok := bearerToken(req)
+	if !ok {
+		return nil, &challenge{realm: ac.realm, err: auth.ErrInvalidCredential}
+	}
+
+	c, err := ac.verifyToken(ctx, rawToken)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error verifying ID token: %v", err)
+		return nil, &challenge{realm: ac.realm, err: auth.ErrAuthenticationFailure}
+	}
+
+	username := ""
+	if values := c.strings(ac.usernameClaim); len(values) > 0 {
+		username = values[0]
+	}
+
+	if len(ac.rules) > 0 {
+		for _, access := range accessItems {
+			if !ac.allowed(c, access) {
+				return nil, fmt.Errorf("%w: user %q may not %s %s %s", auth.ErrAccessDenied, username, access.Action, access.Type, access.Name)
+			}
+		}
+	}
+
+	ctx = auth.WithUser(ctx, auth.UserInfo{Name: username})
+	return dcontext.WithLogger(ctx, dcontext.GetLogger(ctx, auth.UserNameKey)), nil
+}
+
+// bearerToken returns the ID token of the request, sent as a bearer token or
+// as the password of basic authentication.
+func bearerToken(req *http.Request) (string, bool) {
+	if _, password, ok := req.BasicAuth(); ok {
+		return password, password != ""
+	}
+
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// allowed returns whether any rule grants the access to the token.
+func (ac *accessController) allowed(c claims, access auth.Access) bool {
+	for _, rule := range ac.rules {
+		if rule.appliesTo(c) && rule.Allows(access) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r rule) validate() error {
+	if r.Claim == "" {
+		return fmt.Errorf("claim must be set")
+	}
+	if len(r.Values) == 0 {
+		return fmt.Errorf("values must be set")
+	}
+	return r.Grant.Validate()
+}
+
+// appliesTo returns whether the claim of the rule has any of its values.
+func (r rule) appliesTo(c claims) bool {
+	for _, value := range c.strings(r.Claim) {
+		for _, v := range r.Values {
+			if v == "*" || v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stringSliceOption returns the list of strings of an optional option, which
+// may also be a single string.
+func stringSliceOption(options map[string]interface{}, key string) ([]string, error) {
+	switch v := options[key].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for _, vv := range v {
+			vs, ok := vv.(string)
+			if !ok {
+				return nil, fmt.Errorf("oidc access controller requires a valid option list of strings: %q", key)
+			}
+			ss = append(ss, vs)
+		}
+		return ss, nil
+	}
+	return nil, fmt.Errorf("oidc access controller requires a valid option list of strings: %q", key)
+}
+
+// durationOption returns the value of an optional duration option.
+func durationOption(options map[string]interface{}, key string, defaultValue time.Duration) (time.Duration, error) {
+	var d time.Duration
+	switch v := options[key].(type) {
+	case nil:
+		return defaultValue, nil
+	case string:
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return 0, fmt.Errorf("oidc access controller requires a valid option duration: %s: %v", key, err)
+		}
+	case int:
+		d = time.Duration(v) * time.Second
+	default:
+		return 0, fmt.Errorf("oidc access controller requires a valid option duration: %s", key)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("oidc access controller requires a positive option duration: %s", key)
+	}
+	return d, nil
+}
+
+// challenge implements the auth.Challenge interface.
+type challenge struct {
+	realm string
+	err   error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders sets the basic challenge header on the response, so that
+// clients such as docker send the ID token as the password.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ch.realm))
+}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("oidc authentication challenge for realm %q: %s", ch.realm, ch.err)
+}
+
+func init() {
+	auth.Register("oidc", auth.InitFunc(newAccessController))
+}
@@ -0,0 +1,359 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+)
+
+// testProvider is an identity provider serving its OpenID configuration and
+// keys.
+type testProvider struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	keys       []jsonWebKey
+	keyFetches int
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	p := &testProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   p.URL,
+			"jwks_uri": p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.keyFetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": p.keys})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *testProvider) addRSAKey(t *testing.T, id string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = append(p.keys, jsonWebKey{
+		KeyType:   "RSA",
+		KeyID:     id,
+		Use:       "sig",
+		Algorithm: "RS256",
+		N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	})
+	return key
+}
+
+func (p *testProvider) addECKey(t *testing.T, id string) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = append(p.keys, jsonWebKey{
+		KeyType: "EC",
+		KeyID:   id,
+		Curve:   "P-256",
+		X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	})
+	return key
+}
+
+func (p *testProvider) fetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keyFetches
+}
+
+func signToken(t *testing.T, key crypto.Signer, keyID string, claims map[string]interface{}) string {
+	algorithm := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		algorithm = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func authorize(ac auth.AccessController, token string, basic bool, access ...auth.Access) (string, error) {
+	req := httptest.NewRequest("GET", "/v2/", nil)
+	if basic {
+		req.SetBasicAuth("oauth2", token)
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	ctx, err := ac.Authorized(context.WithRequest(context.Background(), req), access...)
+	if err != nil {
+		return "", err
+	}
+	return context.GetStringValue(ctx, auth.UserNameKey), nil
+}
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+}
+
+func TestAccessControllerTokens(t *testing.T) {
+	provider := newTestProvider(t)
+	rsaKey := provider.addRSAKey(t, "rsa")
+	ecKey := provider.addECKey(t, "ec")
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":         "test-realm",
+		"issuer":        provider.URL,
+		"audiences":     []interface{}{"registry"},
+		"usernameclaim": "preferred_username",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid := map[string]interface{}{
+		"iss":                provider.URL,
+		"sub":                "1234",
+		"aud":                []string{"other", "registry"},
+		"exp":                now.Add(time.Hour).Unix(),
+		"preferred_username": "alice",
+	}
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := make(map[string]interface{})
+		for k, v := range valid {
+			c[k] = v
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		basic bool
+	}{
+		{"rsa", signToken(t, rsaKey, "rsa", valid), false},
+		{"ec", signToken(t, ecKey, "ec", valid), false},
+		{"basic", signToken(t, rsaKey, "rsa", valid), true},
+		{"no key id", signToken(t, rsaKey, "", valid), false},
+		{"single audience", signToken(t, rsaKey, "rsa", claims(map[string]interface{}{"aud": "registry"})), false},
+		{"clock skew", signToken(t, rsaKey, "rsa", claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), false},
+	} {
+		user, err := authorize(ac, tc.token, tc.basic)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		} else if user != "alice" {
+			t.Errorf("%s: unexpected user %q", tc.name, user)
+		}
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"malformed", "foo.bar"},
+		{"unknown key", signToken(t, otherKey, "rsa", valid)},
+		{"wrong issuer", signToken(t, rsaKey, "rsa", claims(map[string]interface{}{"iss": "https://other.example.com"}))},
+		{"wrong audience", signToken(t, rsaKey, "rsa", claims(map[string]interface{}{"aud": "other"}))},
+		{"no expiry", signToken(t, rsaKey, "rsa", claims(map[string]interface{}{"exp": nil}))},
+		{"expired", signToken(t, rsaKey, "rsa", claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}))},
+		{"not valid yet", signToken(t, rsaKey, "rsa", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}))},
+	} {
+		_, err := authorize(ac, tc.token, false)
+		if _, ok := err.(auth.Challenge); !ok {
+			t.Errorf("%s: expected challenge, got %v", tc.name, err)
+		}
+	}
+
+	// an unsigned token is rejected
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, _ := json.Marshal(valid)
+	if _, err := authorize(ac, header+"."+base64.RawURLEncoding.EncodeToString(payload)+".", false); err == nil {
+		t.Error("expected unsigned token to be rejected")
+	}
+}
+
+func TestAccessControllerKeyRotation(t *testing.T) {
+	provider := newTestProvider(t)
+	key := provider.addRSAKey(t, "first")
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":     "test-realm",
+		"issuer":    provider.URL,
+		"audiences": "registry",
+		"jwksurl":   provider.URL + "/keys",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{
+		"iss": provider.URL,
+		"sub": "alice",
+		"aud": "registry",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	if _, err := authorize(ac, signToken(t, key, "first", claims), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := authorize(ac, signToken(t, key, "first", claims), false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider.fetches() != 1 {
+		t.Fatalf("expected keys to be fetched once, got %d", provider.fetches())
+	}
+
+	// a token signed by a new key makes the keys be fetched again, at
+	// most every minKeyRefreshInterval
+	rotated := provider.addRSAKey(t, "second")
+	ac.(*accessController).keys.lastAttempt = time.Now().Add(-minKeyRefreshInterval)
+	user, err := authorize(ac, signToken(t, rotated, "second", claims), false)
+	if err != nil {
+		t.Fatalf("unexpected error after key rotation: %v", err)
+	}
+	if user != "alice" {
+		t.Fatalf("unexpected user %q", user)
+	}
+	if provider.fetches() != 2 {
+		t.Fatalf("expected keys to be fetched again, got %d fetches", provider.fetches())
+	}
+
+	otherKey := provider.addRSAKey(t, "third")
+	if _, err := authorize(ac, signToken(t, otherKey, "third", claims), false); err == nil {
+		t.Fatal("expected keys not to be fetched again so soon")
+	}
+	if provider.fetches() != 2 {
+		t.Fatalf("expected keys not to be fetched again, got %d fetches", provider.fetches())
+	}
+}
+
+func TestAccessControllerRules(t *testing.T) {
+	provider := newTestProvider(t)
+	key := provider.addRSAKey(t, "key")
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":     "test-realm",
+		"issuer":    provider.URL,
+		"audiences": []interface{}{"registry"},
+		"rules": []interface{}{
+			map[interface{}]interface{}{
+				"claim":        "groups",
+				"values":       []interface{}{"developers"},
+				"repositories": []interface{}{"team-a/*"},
+				"actions":      []interface{}{"pull", "push"},
+			},
+			map[interface{}]interface{}{
+				"claim":        "email_verified",
+				"values":       []interface{}{"true"},
+				"repositories": []interface{}{"public/*"},
+				"actions":      []interface{}{"pull", "catalog"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := func(claims map[string]interface{}) string {
+		claims["iss"] = provider.URL
+		claims["sub"] = "alice"
+		claims["aud"] = "registry"
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		return signToken(t, key, "key", claims)
+	}
+	developer := token(map[string]interface{}{"groups": []string{"developers"}, "email_verified": true})
+	other := token(map[string]interface{}{"groups": []string{"operators"}})
+
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+	for _, tc := range []struct {
+		token   string
+		access  auth.Access
+		allowed bool
+	}{
+		{developer, repositoryAccess("team-a/app", "push"), true},
+		{developer, repositoryAccess("team-a/app", "delete"), false},
+		{developer, repositoryAccess("public/app", "pull"), true},
+		{developer, catalog, true},
+		{other, repositoryAccess("team-a/app", "pull"), false},
+		{other, repositoryAccess("public/app", "pull"), false},
+	} {
+		_, err := authorize(ac, tc.token, false, tc.access)
+		if tc.allowed && err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tc.access.Action, tc.access.Name, err)
+		}
+		if !tc.allowed && !errors.Is(err, auth.ErrAccessDenied) {
+			t.Errorf("%s %s: expected access denied, got %v", tc.access.Action, tc.access.Name, err)
+		}
+	}
+}
+
+func TestAccessControllerOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{"issuer": "https://idp.example.com", "audiences": "registry"},
+		{"realm": "r", "audiences": "registry"},
+		{"realm": "r", "issuer": "https://idp.example.com"},
+		{"realm": "r", "issuer": "https://idp.example.com", "audiences": "registry", "clockskew": "soon"},
+		{"realm": "r", "issuer": "https://idp.example.com", "audiences": "registry", "rules": "all"},
+		{"realm": "r", "issuer": "https://idp.example.com", "audiences": "registry", "rules": []interface{}{
+			map[interface{}]interface{}{"claim": "groups", "values": []interface{}{"a"}, "actions": []interface{}{"write"}},
+		}},
+	} {
+		if _, err := newAccessController(options); err == nil {
+			t.Errorf("expected error creating access controller with options %v", options)
+		}
+	}
+}
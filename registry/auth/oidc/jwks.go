@@ -0,0 +1,302 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minKeyRefreshInterval limits how often the keys are fetched again when a
+// token is signed with an unknown key, so that forged tokens cannot flood
+// the identity provider.
+const minKeyRefreshInterval = 10 * time.Second
+
+// errUnknownKey is returned when no key of the identity provider verifies
+// the signature of a token.
+var errUnknownKey = errors.New("token signed with an unknown key")
+
+// jsonWebKey is a public key of a JSON Web Key Set, as defined by RFC 7517.
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC keys
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// publicKey is a signing key of the identity provider.
+type publicKey struct {
+	id        string
+	algorithm string
+	key       crypto.PublicKey
+}
+
+// keySet caches the signing keys of the identity provider, fetching them
+// again once they are older than the refresh interval or when a token is
+// signed by a key not in the set, such as after the keys were rotated.
+type keySet struct {
+	client  *http.Client
+	issuer  string
+	jwksURL string
+	refresh time.Duration
+
+	mu          sync.Mutex
+	keys        []publicKey
+	fetched     time.Time
+	lastAttempt time.Time
+}
+
+// verify checks the signature of the token was made by one of the keys of
+// the identity provider with the algorithm.
+func (ks *keySet) verify(ctx context.Context, keyID, algorithm string, signed, signature []byte) error {
+	keys, err := ks.current(ctx, false)
+	if err != nil {
+		return err
+	}
+	if ok := verifyWithKeys(keys, keyID, algorithm, signed, signature); ok {
+		return nil
+	}
+
+	keys, err = ks.current(ctx, true)
+	if err != nil {
+		return err
+	}
+	if ok := verifyWithKeys(keys, keyID, algorithm, signed, signature); ok {
+		return nil
+	}
+	return errUnknownKey
+}
+
+// current returns the keys, fetching them if they are stale or, if
+// unknownKey is set, to find a key which was just added. Keys are fetched at
+// most every minKeyRefreshInterval once they were fetched successfully.
+func (ks *keySet) current(ctx context.Context, unknownKey bool) ([]publicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := time.Now()
+	refetch := unknownKey || now.Sub(ks.fetched) > ks.refresh
+	if ks.keys != nil && (!refetch || now.Sub(ks.lastAttempt) < minKeyRefreshInterval) {
+		return ks.keys, nil
+	}
+
+	ks.lastAttempt = now
+	keys, err := ks.fetch(ctx)
+	if err != nil {
+		if ks.keys != nil {
+			// keep verifying tokens with the keys fetched last
+			return ks.keys, nil
+		}
+		return nil, err
+	}
+	ks.keys = keys
+	ks.fetched = now
+	return keys, nil
+}
+
+// fetch gets the keys from the identity provider, discovering the location
+// of its JSON Web Key Set from its OpenID configuration unless it is set.
+func (ks *keySet) fetch(ctx context.Context) ([]publicKey, error) {
+	if ks.jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURL string `json:"jwks_uri"`
+		}
+		if err := ks.getJSON(ctx, strings.TrimSuffix(ks.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, fmt.Errorf("error discovering OpenID configuration: %v", err)
+		}
+		if discovery.Issuer != ks.issuer {
+			return nil, fmt.Errorf("OpenID configuration is for issuer %q, expected %q", discovery.Issuer, ks.issuer)
+		}
+		if discovery.JWKSURL == "" {
+			return nil, fmt.Errorf("OpenID configuration of %q has no jwks_uri", ks.issuer)
+		}
+		ks.jwksURL = discovery.JWKSURL
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := ks.getJSON(ctx, ks.jwksURL, &set); err != nil {
+		return nil, fmt.Errorf("error fetching JSON Web Key Set: %v", err)
+	}
+
+	keys := make([]publicKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// keys of unsupported types are skipped
+			continue
+		}
+		keys = append(keys, publicKey{id: jwk.KeyID, algorithm: jwk.Algorithm, key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no supported signing keys in %s", ks.jwksURL)
+	}
+	return keys, nil
+}
+
+func (ks *keySet) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// publicKey decodes the RSA or EC public key.
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 || e.Int64() < 3 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.KeyType)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	p, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("empty key parameter")
+	}
+	return new(big.Int).SetBytes(p), nil
+}
+
+// verifyWithKeys returns whether any of the keys with the id, or all of them
+// if the token names no key, verifies the signature.
+func verifyWithKeys(keys []publicKey, keyID, algorithm string, signed, signature []byte) bool {
+	for _, key := range keys {
+		if keyID != "" && key.id != keyID {
+			continue
+		}
+		if key.algorithm != "" && key.algorithm != algorithm {
+			continue
+		}
+		if verifySignature(key.key, algorithm, signed, signature) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// signingAlgorithms maps the supported signing algorithms, as defined by
+// RFC 7518, to their hash functions. Only asymmetric algorithms are
+// supported, so that the public keys cannot be used to forge tokens.
+var signingAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// verifySignature verifies the signature with the algorithm.
+func verifySignature(key crypto.PublicKey, algorithm string, signed, signature []byte) error {
+	hash, ok := signingAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch algorithm[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		if algorithm[:2] != "ES" {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("%s signature cannot be verified with %T", algorithm, key)
+}
@@ -0,0 +1,139 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors returned when verifying ID tokens.
+var (
+	errMalformedToken  = errors.New("malformed token")
+	errInvalidIssuer   = errors.New("token issued by another issuer")
+	errInvalidAudience = errors.New("token issued for another audience")
+	errExpiredToken    = errors.New("token expired")
+	errTokenNotValid   = errors.New("token not valid yet")
+)
+
+// claims are the claims of an ID token, kept generic so that any of them can
+// grant access.
+type claims map[string]interface{}
+
+// verifyToken checks the signature and the registered claims of the ID token
+// in compact serialization, returning its claims.
+func (ac *accessController) verifyToken(ctx context.Context, rawToken string) (claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errMalformedToken
+	}
+	if _, ok := signingAlgorithms[header.Algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := ac.keys.verify(ctx, header.KeyID, header.Algorithm, signed, signature); err != nil {
+		return nil, err
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, errMalformedToken
+	}
+
+	if issuer, _ := c["iss"].(string); issuer != ac.issuer {
+		return nil, errInvalidIssuer
+	}
+	if !c.hasAudience(ac.audiences) {
+		return nil, errInvalidAudience
+	}
+
+	now := time.Now()
+	expiry, ok := c.time("exp")
+	if !ok {
+		return nil, errMalformedToken
+	}
+	if now.After(expiry.Add(ac.clockSkew)) {
+		return nil, errExpiredToken
+	}
+	if notBefore, ok := c.time("nbf"); ok && now.Add(ac.clockSkew).Before(notBefore) {
+		return nil, errTokenNotValid
+	}
+
+	return c, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	p, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// hasAudience returns whether the audience of the token is one of the
+// audiences.
+func (c claims) hasAudience(audiences []string) bool {
+	for _, aud := range c.strings("aud") {
+		for _, audience := range audiences {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// time returns the value of a NumericDate claim.
+func (c claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// strings returns the values of a claim which is a string, a boolean, a
+// number or a list of them.
+func (c claims) strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case bool, json.Number:
+		return []string{fmt.Sprint(v)}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, vv := range v {
+			switch vv := vv.(type) {
+			case string:
+				values = append(values, vv)
+			case bool, json.Number:
+				values = append(values, fmt.Sprint(vv))
+			}
+		}
+		return values
+	}
+	return nil
+}
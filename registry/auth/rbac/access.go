@@ -0,0 +1,153 @@
+// Package rbac provides an access controller granting users pull, push and
+// delete access to repositories according to a policy file, so that small
+// deployments can restrict access per repository without a token server.
+// Users are authenticated by another access controller, such as htpasswd.
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+)
+
+type accessController struct {
+	authenticator auth.AccessController
+	path          string
+
+	mu      sync.Mutex
+	modtime time.Time
+	policy  *policy
+}
+
+var _ auth.AccessController = &accessController{}
+
+// newAccessController configures the access controller from the path of the
+// policy file, in the "policy" option, and the options of the access
+// controller authenticating users, keyed by its name.
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	path, ok := options["policy"].(string)
+	if !ok || path == "" {
+		return nil, fmt.Errorf(`"policy" must be set for rbac access controller`)
+	}
+
+	var authenticator auth.AccessController
+	for name, value := range options {
+		if name == "policy" {
+			continue
+		}
+		if authenticator != nil {
+			return nil, fmt.Errorf("rbac access controller accepts a single authenticator, found %q", name)
+		}
+		authOptions, ok := parameters(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid options for %q authenticator of rbac access controller", name)
+		}
+		if name == "rbac" {
+			return nil, fmt.Errorf("rbac access controller cannot authenticate users with itself")
+		}
+
+		var err error
+		authenticator, err = auth.GetAccessController(name, authOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if authenticator == nil {
+		return nil, fmt.Errorf("an authenticator, such as htpasswd, must be set for rbac access controller")
+	}
+
+	ac := &accessController{authenticator: authenticator, path: path}
+	if _, err := ac.currentPolicy(); err != nil {
+		return nil, err
+	}
+	return ac, nil
+}
+
+// Authorized authenticates the user of the request, then checks the policy
+// grants them all of the requested access.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	ctx, err := ac.authenticator.Authorized(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := ac.currentPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	username := dcontext.GetStringValue(ctx, auth.UserNameKey)
+	for _, access := range accessRecords {
+		if !p.allowed(username, access) {
+			return nil, fmt.Errorf("%w: user %q may not %s %s %s", auth.ErrAccessDenied, username, access.Action, access.Type, access.Name)
+		}
+	}
+
+	return ctx, nil
+}
+
+// currentPolicy returns the policy, parsing the file again if it was
+// modified. The previous policy is kept if the file is invalid.
+func (ac *accessController) currentPolicy() (*policy, error) {
+	fstat, err := os.Stat(ac.path)
+	if err != nil {
+		return nil, err
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if ac.policy != nil && ac.modtime.Equal(fstat.ModTime()) {
+		return ac.policy, nil
+	}
+
+	f, err := os.Open(ac.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := parsePolicy(f)
+	if err != nil {
+		if ac.policy != nil {
+			dcontext.GetLogger(context.Background()).Errorf("error parsing rbac policy %s, keeping the previous one: %v", ac.path, err)
+			return ac.policy, nil
+		}
+		return nil, fmt.Errorf("error parsing rbac policy %s: %v", ac.path, err)
+	}
+
+	ac.policy = p
+	ac.modtime = fstat.ModTime()
+	return p, nil
+}
+
+// parameters converts the options of the authenticator, decoded from yaml,
+// to the options of an access controller.
+func parameters(value interface{}) (map[string]interface{}, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, true
+	case map[interface{}]interface{}:
+		options := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			key, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			options[key] = v
+		}
+		return options, true
+	case nil:
+		return map[string]interface{}{}, true
+	}
+	return nil, false
+}
+
+func init() {
+	auth.Register("rbac", auth.InitFunc(newAccessController))
+}
@@ -0,0 +1,112 @@
+package rbac
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	_ "github.com/docker/distribution/registry/auth/htpasswd"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccessController(t *testing.T) {
+	dir := t.TempDir()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(htpasswdPath, []byte("alice:"+string(hash)+"\ncarol:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policyPath := filepath.Join(dir, "policy.yml")
+	if err := os.WriteFile(policyPath, []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// options are decoded from the yaml configuration
+	ac, err := newAccessController(map[string]interface{}{
+		"policy": policyPath,
+		"htpasswd": map[interface{}]interface{}{
+			"realm": "test-realm",
+			"path":  htpasswdPath,
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating access controller: %v", err)
+	}
+
+	authorize := func(user, password string, access ...auth.Access) (string, error) {
+		req := httptest.NewRequest("GET", "/v2/", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		ctx, err := ac.Authorized(context.WithRequest(context.Background(), req), access...)
+		if err != nil {
+			return "", err
+		}
+		return context.GetStringValue(ctx, auth.UserNameKey), nil
+	}
+
+	if _, err := authorize("", "", repositoryAccess("public/app", "pull")); err == nil {
+		t.Fatal("expected challenge without credentials")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("expected challenge without credentials, got %v", err)
+	}
+	if _, err := authorize("alice", "wrong", repositoryAccess("team-a/app", "pull")); err == nil {
+		t.Fatal("expected challenge with wrong password")
+	}
+
+	user, err := authorize("alice", "secret", repositoryAccess("team-a/app", "pull"), repositoryAccess("team-a/app", "push"))
+	if err != nil {
+		t.Fatalf("unexpected error authorizing alice: %v", err)
+	}
+	if user != "alice" {
+		t.Fatalf("unexpected user in context: %q", user)
+	}
+
+	_, err = authorize("carol", "secret", repositoryAccess("team-a/app", "pull"))
+	if !errors.Is(err, auth.ErrAccessDenied) {
+		t.Fatalf("expected access denied for carol, got %v", err)
+	}
+
+	// the policy is reloaded when the file changes
+	if err := os.WriteFile(policyPath, []byte("rules: [{users: [carol], repositories: ['team-a/*'], actions: [pull]}]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(policyPath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authorize("carol", "secret", repositoryAccess("team-a/app", "pull")); err != nil {
+		t.Fatalf("unexpected error authorizing carol after reload: %v", err)
+	}
+	if _, err := authorize("alice", "secret", repositoryAccess("team-a/app", "pull")); !errors.Is(err, auth.ErrAccessDenied) {
+		t.Fatalf("expected access denied for alice after reload, got %v", err)
+	}
+}
+
+func TestAccessControllerOptions(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "policy.yml")
+	if err := os.WriteFile(policyPath, []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, options := range []map[string]interface{}{
+		{"htpasswd": map[string]interface{}{"realm": "test-realm", "path": "/tmp/htpasswd"}},
+		{"policy": policyPath},
+		{"policy": policyPath, "unknown": map[string]interface{}{}},
+		{"policy": policyPath, "htpasswd": "invalid"},
+		{"policy": policyPath, "rbac": map[string]interface{}{"policy": policyPath}},
+		{"policy": filepath.Join(t.TempDir(), "missing.yml"), "silly": map[string]interface{}{"realm": "r", "service": "s"}},
+	} {
+		if _, err := newAccessController(options); err == nil {
+			t.Errorf("expected error creating access controller with options %v", options)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package rbac
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/auth/rules"
+	"gopkg.in/yaml.v2"
+)
+
+// policy grants actions on repositories to users and groups of users.
+type policy struct {
+	// Groups maps the name of each group to the names of its members.
+	Groups map[string][]string `yaml:"groups,omitempty"`
+
+	// Rules grant access, a request being allowed if any of them grants
+	// it.
+	Rules []rule `yaml:"rules"`
+}
+
+// rule grants the actions on the repositories matching any of the patterns
+// to the users, and to the members of the groups. A user named "*" stands
+// for all authenticated users.
+type rule struct {
+	Users       []string `yaml:"users,omitempty"`
+	Groups      []string `yaml:"groups,omitempty"`
+	rules.Grant `yaml:",inline"`
+}
+
+// parsePolicy reads and validates a yaml policy.
+func parsePolicy(r io.Reader) (*policy, error) {
+	p := &policy{}
+	if err := yaml.NewDecoder(r).Decode(p); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	for i, rule := range p.Rules {
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return nil, fmt.Errorf("rule %d applies to no users or groups", i)
+		}
+		for _, group := range rule.Groups {
+			if _, ok := p.Groups[group]; !ok {
+				return nil, fmt.Errorf("rule %d: unknown group %q", i, group)
+			}
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+	}
+
+	return p, nil
+}
+
+// allowed returns whether the policy grants the access to the user.
+func (p *policy) allowed(username string, access auth.Access) bool {
+	for _, rule := range p.Rules {
+		if p.appliesTo(rule, username) && rule.Allows(access) {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesTo returns whether the rule applies to the user.
+func (p *policy) appliesTo(r rule, username string) bool {
+	if username == "" {
+		return false
+	}
+	for _, user := range r.Users {
+		if user == "*" || user == username {
+			return true
+		}
+	}
+	for _, group := range r.Groups {
+		for _, member := range p.Groups[group] {
+			if member == username {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/registry/auth"
+)
+
+const testPolicy = `
+groups:
+  developers: [alice, bob]
+rules:
+  - groups: [developers]
+    repositories: ["team-a/*"]
+    actions: [pull, push]
+  - users: [alice]
+    repositories: ["team-a/*"]
+    actions: [delete]
+  - users: [admin]
+    repositories: ["*", "*/*"]
+    actions: ["*"]
+  - users: ["*"]
+    repositories: ["public/*"]
+    actions: [pull, catalog]
+`
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	p, err := parsePolicy(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatalf("error parsing policy: %v", err)
+	}
+
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+	for _, tc := range []struct {
+		user    string
+		access  auth.Access
+		allowed bool
+	}{
+		{"alice", repositoryAccess("team-a/app", "pull"), true},
+		{"bob", repositoryAccess("team-a/app", "push"), true},
+		{"bob", repositoryAccess("team-a/app", "delete"), false},
+		{"alice", repositoryAccess("team-a/app", "delete"), true},
+		{"bob", repositoryAccess("team-a/app/nested", "pull"), false},
+		{"bob", repositoryAccess("team-b/app", "pull"), false},
+		{"carol", repositoryAccess("team-a/app", "pull"), false},
+		{"carol", repositoryAccess("public/app", "pull"), true},
+		{"carol", repositoryAccess("public/app", "push"), false},
+		{"", repositoryAccess("public/app", "pull"), false},
+		{"admin", repositoryAccess("team-b/app", "delete"), true},
+		{"carol", catalog, true},
+		{"", catalog, false},
+		{"admin", auth.Access{Resource: auth.Resource{Type: "unknown", Name: "app"}, Action: "pull"}, false},
+	} {
+		if allowed := p.allowed(tc.user, tc.access); allowed != tc.allowed {
+			t.Errorf("user %q %s %s %s: expected allowed %t, got %t", tc.user, tc.access.Action, tc.access.Type, tc.access.Name, tc.allowed, allowed)
+		}
+	}
+}
+
+func TestParseInvalidPolicy(t *testing.T) {
+	for _, policy := range []string{
+		"rules: [{repositories: [foo], actions: [pull]}]",
+		"rules: [{groups: [missing], repositories: [foo], actions: [pull]}]",
+		"rules: [{users: [alice], repositories: ['[foo'], actions: [pull]}]",
+		"rules: [{users: [alice], repositories: [foo]}]",
+		"rules: [{users: [alice], repositories: [foo], actions: [write]}]",
+		"rules: foo",
+	} {
+		if _, err := parsePolicy(strings.NewReader(policy)); err == nil {
+			t.Errorf("expected error parsing policy %q", policy)
+		}
+	}
+}
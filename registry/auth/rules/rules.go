@@ -0,0 +1,94 @@
+// Package rules provides the grants of the rules configured for access
+// controllers, such as rbac, oidc, ldap and mtls, which grant actions on
+// repositories to the users each access controller identifies in its own way.
+package rules
+
+import (
+	"fmt"
+	"path"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/docker/distribution/registry/auth"
+)
+
+// Actions granted by rules. ActionCatalog grants listing the repositories of
+// the registry, whichever repositories the rule applies to.
+const (
+	ActionPull    = "pull"
+	ActionPush    = "push"
+	ActionDelete  = "delete"
+	ActionCatalog = "catalog"
+	ActionAll     = "*"
+)
+
+// Grant grants the actions on the repositories matching any of the patterns,
+// in the syntax of path.Match. Access controllers embed it inline in their
+// rules, along with the fields selecting who the rule applies to.
+type Grant struct {
+	Repositories []string `yaml:"repositories,omitempty"`
+	Actions      []string `yaml:"actions"`
+}
+
+// Validate checks the patterns and actions of the grant.
+func (g Grant) Validate() error {
+	for _, pattern := range g.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid repository pattern %q: %v", pattern, err)
+		}
+	}
+	if len(g.Actions) == 0 {
+		return fmt.Errorf("actions must be set")
+	}
+	for _, action := range g.Actions {
+		switch action {
+		case ActionPull, ActionPush, ActionDelete, ActionCatalog, ActionAll:
+		default:
+			return fmt.Errorf("unknown action %q", action)
+		}
+	}
+	return nil
+}
+
+// Allows returns whether the grant allows the access.
+func (g Grant) Allows(access auth.Access) bool {
+	switch access.Type {
+	case "repository":
+		return g.grants(access.Action) && g.matches(access.Name)
+	case "registry":
+		return access.Name == "catalog" && g.grants(ActionCatalog)
+	}
+	return false
+}
+
+// grants returns whether the grant includes the action.
+func (g Grant) grants(action string) bool {
+	for _, a := range g.Actions {
+		if a == action || a == ActionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns whether the repository matches any of the patterns of the
+// grant.
+func (g Grant) matches(repository string) bool {
+	for _, pattern := range g.Repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode decodes the rules option of an access controller, as decoded from
+// the yaml configuration, into rules, which points to a slice of the rules of
+// the access controller.
+func Decode(option interface{}, rules interface{}) error {
+	p, err := yaml.Marshal(option)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(p, rules)
+}
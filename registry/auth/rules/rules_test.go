@@ -0,0 +1,76 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/registry/auth"
+)
+
+// testRule embeds a grant inline, as the rules of access controllers do.
+type testRule struct {
+	Users []string `yaml:"users"`
+	Grant `yaml:",inline"`
+}
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+}
+
+func TestDecode(t *testing.T) {
+	option := []interface{}{
+		map[interface{}]interface{}{
+			"users":        []interface{}{"alice"},
+			"repositories": []interface{}{"team-a/*"},
+			"actions":      []interface{}{"pull", "push"},
+		},
+	}
+
+	var rules []testRule
+	if err := Decode(option, &rules); err != nil {
+		t.Fatalf("unexpected error decoding rules: %v", err)
+	}
+	if len(rules) != 1 || len(rules[0].Users) != 1 || len(rules[0].Repositories) != 1 || len(rules[0].Actions) != 2 {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	if err := Decode("foo", &rules); err == nil {
+		t.Fatal("expected error decoding invalid rules")
+	}
+}
+
+func TestGrantAllows(t *testing.T) {
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+	for _, tc := range []struct {
+		grant   Grant
+		access  auth.Access
+		allowed bool
+	}{
+		{Grant{Repositories: []string{"team-a/*"}, Actions: []string{"pull"}}, repositoryAccess("team-a/app", "pull"), true},
+		{Grant{Repositories: []string{"team-a/*"}, Actions: []string{"pull"}}, repositoryAccess("team-a/app", "push"), false},
+		{Grant{Repositories: []string{"team-a/*"}, Actions: []string{"pull"}}, repositoryAccess("team-a/app/nested", "pull"), false},
+		{Grant{Repositories: []string{"*", "*/*"}, Actions: []string{"*"}}, repositoryAccess("team-b/app", "delete"), true},
+		{Grant{Actions: []string{"catalog"}}, catalog, true},
+		{Grant{Repositories: []string{"*"}, Actions: []string{"pull"}}, catalog, false},
+		{Grant{Repositories: []string{"*"}, Actions: []string{"*"}}, auth.Access{Resource: auth.Resource{Type: "unknown", Name: "app"}, Action: "pull"}, false},
+	} {
+		if allowed := tc.grant.Allows(tc.access); allowed != tc.allowed {
+			t.Errorf("%+v %s %s %s: expected allowed %t, got %t", tc.grant, tc.access.Action, tc.access.Type, tc.access.Name, tc.allowed, allowed)
+		}
+	}
+}
+
+func TestGrantValidate(t *testing.T) {
+	if err := (Grant{Repositories: []string{"foo/*"}, Actions: []string{"pull", "catalog"}}).Validate(); err != nil {
+		t.Fatalf("unexpected error validating grant: %v", err)
+	}
+
+	for _, grant := range []Grant{
+		{Repositories: []string{"[foo"}, Actions: []string{"pull"}},
+		{Repositories: []string{"foo"}},
+		{Repositories: []string{"foo"}, Actions: []string{"write"}},
+	} {
+		if err := grant.Validate(); err == nil {
+			t.Errorf("expected error validating grant %+v", grant)
+		}
+	}
+}
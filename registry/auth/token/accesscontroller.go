@@ -11,7 +11,9 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	dcontext "github.com/docker/distribution/context"
 	"github.com/docker/distribution/registry/auth"
 	"github.com/docker/libtrust"
 )
@@ -126,12 +128,15 @@ func (ac authChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
 
 // accessController implements the auth.AccessController interface.
 type accessController struct {
-	realm        string
-	autoRedirect bool
-	issuer       string
-	service      string
-	rootCerts    *x509.CertPool
-	trustedKeys  map[string]libtrust.PublicKey
+	realm          string
+	autoRedirect   bool
+	issuer         string
+	service        string
+	audiences      []string
+	clockSkew      time.Duration
+	requiredClaims []string
+	rootCerts      *x509.CertPool
+	trustedKeys    map[string]libtrust.PublicKey
 }
 
 // tokenAccessOptions is a convenience type for handling
@@ -142,6 +147,9 @@ type tokenAccessOptions struct {
 	issuer         string
 	service        string
 	rootCertBundle string
+	audiences      []string
+	clockSkew      time.Duration
+	requiredClaims []string
 }
 
 // checkOptions gathers the necessary options
@@ -170,9 +178,68 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		opts.autoRedirect = autoRedirect
 	}
 
+	// the service is always an accepted audience
+	audiences, err := stringSliceOption(options, "audiences")
+	if err != nil {
+		return opts, err
+	}
+	opts.audiences = append([]string{opts.service}, audiences...)
+
+	if clockSkewVal, ok := options["clockskew"]; ok {
+		switch v := clockSkewVal.(type) {
+		case string:
+			clockSkew, err := time.ParseDuration(v)
+			if err != nil {
+				return opts, fmt.Errorf("token auth requires a valid option duration: clockskew: %v", err)
+			}
+			opts.clockSkew = clockSkew
+		case int:
+			opts.clockSkew = time.Duration(v) * time.Second
+		default:
+			return opts, fmt.Errorf("token auth requires a valid option duration: clockskew")
+		}
+		if opts.clockSkew <= 0 {
+			return opts, fmt.Errorf("token auth requires a positive option duration: clockskew")
+		}
+	}
+
+	opts.requiredClaims, err = stringSliceOption(options, "requiredclaims")
+	if err != nil {
+		return opts, err
+	}
+	for _, claim := range opts.requiredClaims {
+		if _, ok := requiredClaims[claim]; !ok {
+			return opts, fmt.Errorf("token auth option requiredclaims: unknown claim %q", claim)
+		}
+	}
+
 	return opts, nil
 }
 
+// stringSliceOption returns the list of strings of an optional option.
+func stringSliceOption(options map[string]interface{}, key string) ([]string, error) {
+	val, ok := options[key]
+	if !ok {
+		return nil, nil
+	}
+
+	switch v := val.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for _, vv := range v {
+			vs, ok := vv.(string)
+			if !ok {
+				return nil, fmt.Errorf("token auth requires a valid option list of strings: %q", key)
+			}
+			ss = append(ss, vs)
+		}
+		return ss, nil
+	}
+	return nil, fmt.Errorf("token auth requires a valid option list of strings: %q", key)
+}
+
 // newAccessController creates an accessController using the given options.
 func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
 	config, err := checkOptions(options)
@@ -222,65 +289,70 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 	}
 
 	return &accessController{
-		realm:        config.realm,
-		autoRedirect: config.autoRedirect,
-		issuer:       config.issuer,
-		service:      config.service,
-		rootCerts:    rootPool,
-		trustedKeys:  trustedKeys,
+		realm:          config.realm,
+		autoRedirect:   config.autoRedirect,
+		issuer:         config.issuer,
+		service:        config.service,
+		audiences:      config.audiences,
+		clockSkew:      config.clockSkew,
+		requiredClaims: config.requiredClaims,
+		rootCerts:      rootPool,
+		trustedKeys:    trustedKeys,
 	}, nil
 }
 
 // Authorized handles checking whether the given request is authorized
 // for actions on resources described by the given access items.
 func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.Access) (context.Context, error) {
-	//challenge := &authChallenge{
-	//	realm:        ac.realm,
-	//	autoRedirect: ac.autoRedirect,
-	//	service:      ac.service,
-	//	accessSet:    newAccessSet(accessItems...),
-	//}
-	//
-	//req, err := dcontext.GetRequest(ctx)
-	//if err != nil {
-	//	return nil, err
-	//}
-
-	//prefix, rawToken, ok := strings.Cut(req.Header.Get("Authorization"), " ")
-	//if !ok || rawToken == "" || !strings.EqualFold(prefix, "bearer") {
-	//	challenge.err = ErrTokenRequired
-	//	return nil, challenge
-	//}
-
-	//token, err := NewToken(rawToken)
-	//if err != nil {
-	//	challenge.err = err
-	//	return nil, challenge
-	//}
-	//
-	//verifyOpts := VerifyOptions{
-	//	TrustedIssuers:    []string{ac.issuer},
-	//	AcceptedAudiences: []string{ac.service},
-	//	Roots:             ac.rootCerts,
-	//	TrustedKeys:       ac.trustedKeys,
-	//}
-	//
-	//if err = token.Verify(verifyOpts); err != nil {
-	//	challenge.err = err
-	//	return nil, challenge
-	//}
-	//
-	//accessSet := token.accessSet()
-	//for _, access := range accessItems {
-	//	if !accessSet.contains(access) {
-	//		challenge.err = ErrInsufficientScope
-	//		return nil, challenge
-	//	}
-	//}
-	//
-	//ctx = auth.WithResources(ctx, token.resources())
-
-	return auth.WithUser(ctx, auth.UserInfo{Name: "test"}), nil
+	challenge := &authChallenge{
+		realm:        ac.realm,
+		autoRedirect: ac.autoRedirect,
+		service:      ac.service,
+		accessSet:    newAccessSet(accessItems...),
+	}
+
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, rawToken, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || rawToken == "" || !strings.EqualFold(prefix, "bearer") {
+		challenge.err = ErrTokenRequired
+		return nil, challenge
+	}
+
+	token, err := NewToken(rawToken)
+	if err != nil {
+		challenge.err = err
+		return nil, challenge
+	}
+
+	verifyOpts := VerifyOptions{
+		TrustedIssuers:    []string{ac.issuer},
+		AcceptedAudiences: ac.audiences,
+		Roots:             ac.rootCerts,
+		TrustedKeys:       ac.trustedKeys,
+		Leeway:            ac.clockSkew,
+		RequiredClaims:    ac.requiredClaims,
+	}
+
+	if err = token.Verify(verifyOpts); err != nil {
+		challenge.err = err
+		return nil, challenge
+	}
+
+	accessSet := token.accessSet()
+	for _, access := range accessItems {
+		if !accessSet.contains(access) {
+			challenge.err = ErrInsufficientScope
+			return nil, challenge
+		}
+	}
+
+	ctx = auth.WithResources(ctx, token.resources())
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: token.Claims.Subject}), nil
 }
 
 // init handles registering the token auth backend.
@@ -78,6 +78,23 @@ type VerifyOptions struct {
 	AcceptedAudiences []string
 	Roots             *x509.CertPool
 	TrustedKeys       map[string]libtrust.PublicKey
+
+	// Leeway is added to NBF and EXP claim checks to account for clock
+	// skew with the token issuer. Defaults to Leeway if zero.
+	Leeway time.Duration
+
+	// RequiredClaims lists the claims, among sub, exp, nbf, iat and jti,
+	// which the token must set.
+	RequiredClaims []string
+}
+
+// requiredClaims reports whether each claim which may be required is set.
+var requiredClaims = map[string]func(*ClaimSet) bool{
+	"sub": func(c *ClaimSet) bool { return c.Subject != "" },
+	"exp": func(c *ClaimSet) bool { return c.Expiration != 0 },
+	"nbf": func(c *ClaimSet) bool { return c.NotBefore != 0 },
+	"iat": func(c *ClaimSet) bool { return c.IssuedAt != 0 },
+	"jti": func(c *ClaimSet) bool { return c.JWTID != "" },
 }
 
 // NewToken parses the given raw token string
@@ -148,16 +165,28 @@ func (t *Token) Verify(verifyOpts VerifyOptions) error {
 		return ErrInvalidToken
 	}
 
+	// Verify that the required claims are set.
+	for _, claim := range verifyOpts.RequiredClaims {
+		if isSet, ok := requiredClaims[claim]; !ok || !isSet(t.Claims) {
+			log.Infof("token without required claim %q", claim)
+			return ErrInvalidToken
+		}
+	}
+
 	// Verify that the token is currently usable and not expired.
 	currentTime := time.Now()
+	leeway := verifyOpts.Leeway
+	if leeway == 0 {
+		leeway = Leeway
+	}
 
-	ExpWithLeeway := time.Unix(t.Claims.Expiration, 0).Add(Leeway)
+	ExpWithLeeway := time.Unix(t.Claims.Expiration, 0).Add(leeway)
 	if currentTime.After(ExpWithLeeway) {
 		log.Infof("token not to be used after %s - currently %s", ExpWithLeeway, currentTime)
 		return ErrInvalidToken
 	}
 
-	NotBeforeWithLeeway := time.Unix(t.Claims.NotBefore, 0).Add(-Leeway)
+	NotBeforeWithLeeway := time.Unix(t.Claims.NotBefore, 0).Add(-leeway)
 	if currentTime.Before(NotBeforeWithLeeway) {
 		log.Infof("token not to be used before %s - currently %s", NotBeforeWithLeeway, currentTime)
 		return ErrInvalidToken
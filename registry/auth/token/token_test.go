@@ -276,6 +276,46 @@ func TestLeeway(t *testing.T) {
 	if err = token.Verify(verifyOps); err == nil {
 		t.Fatal("Verification should fail for token with exp in the future outside leeway")
 	}
+
+	// a configured leeway replaces the default one
+	verifyOps.Leeway = 5 * time.Minute
+	futureNow = time.Now().Add(4 * time.Minute)
+	token, err = makeTestToken(issuer, audience, access, rootKeys[0], 0, futureNow, futureNow.Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = token.Verify(verifyOps); err != nil {
+		t.Fatalf("Verification should pass for token with nbf in the future within the configured leeway: %v", err)
+	}
+}
+
+func TestRequiredClaims(t *testing.T) {
+	issuer, audience := "test-issuer", "test-audience"
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := makeTestToken(issuer, audience, nil, rootKeys[0], 0, time.Now(), time.Now().Add(5*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyOps := VerifyOptions{
+		TrustedIssuers:    []string{issuer},
+		AcceptedAudiences: []string{audience},
+		TrustedKeys:       makeTrustedKeyMap(rootKeys),
+		RequiredClaims:    []string{"sub", "iat", "jti"},
+	}
+	if err := token.Verify(verifyOps); err != nil {
+		t.Fatalf("Verification should pass for token with the required claims: %v", err)
+	}
+
+	token.Claims.JWTID = ""
+	if err := token.Verify(verifyOps); err != ErrInvalidToken {
+		t.Fatalf("Verification should fail for token without a required claim: %v", err)
+	}
 }
 
 func writeTempRootCerts(rootKeys []libtrust.PrivateKey) (filename string, err error) {
@@ -477,6 +517,83 @@ func TestAccessController(t *testing.T) {
 	}
 }
 
+func TestAccessControllerOptions(t *testing.T) {
+	rootKeys, err := makeRootKeys(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCertBundleFilename, err := writeTempRootCerts(rootKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(rootCertBundleFilename)
+
+	issuer := "test-issuer.example.com"
+	options := map[string]interface{}{
+		"realm":          "https://auth.example.com/token/",
+		"issuer":         issuer,
+		"service":        "test-service.example.com",
+		"rootcertbundle": rootCertBundleFilename,
+		"audiences":      []interface{}{"registry.example.com"},
+		"clockskew":      "3m",
+		"requiredclaims": []interface{}{"sub", "jti"},
+	}
+
+	accessController, err := newAccessController(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithRequest(context.Background(), req)
+
+	for _, tc := range []struct {
+		audience   string
+		notBefore  time.Time
+		authorized bool
+	}{
+		{"test-service.example.com", time.Now(), true},
+		{"registry.example.com", time.Now(), true},
+		{"other.example.com", time.Now(), false},
+		// issued by a server whose clock is ahead
+		{"registry.example.com", time.Now().Add(2 * time.Minute), true},
+		{"registry.example.com", time.Now().Add(4 * time.Minute), false},
+	} {
+		token, err := makeTestToken(issuer, tc.audience, nil, rootKeys[0], 1, tc.notBefore, tc.notBefore.Add(5*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.compactRaw()))
+
+		authCtx, err := accessController.Authorized(ctx)
+		if authorized := err == nil; authorized != tc.authorized {
+			t.Fatalf("unexpected result for token for %s not before %v: %v", tc.audience, tc.notBefore, err)
+		}
+		if tc.authorized && context.GetStringValue(authCtx, auth.UserNameKey) != "foo" {
+			t.Fatalf("unexpected user name: %q", context.GetStringValue(authCtx, auth.UserNameKey))
+		}
+	}
+
+	for key, value := range map[string]interface{}{
+		"audiences":      "registry.example.com",
+		"clockskew":      "soon",
+		"requiredclaims": []interface{}{"email"},
+	} {
+		invalid := make(map[string]interface{}, len(options))
+		for k, v := range options {
+			invalid[k] = v
+		}
+		invalid[key] = value
+		if _, err := newAccessController(invalid); err == nil {
+			t.Errorf("expected an error with invalid option %s: %v", key, value)
+		}
+	}
+}
+
 // This tests that newAccessController can handle PEM blocks in the certificate
 // file other than certificates, for example a private key.
 func TestNewAccessControllerPemBlock(t *testing.T) {
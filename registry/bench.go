@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/docker/distribution/registry/bench"
+	"github.com/spf13/cobra"
+)
+
+var benchOptions struct {
+	repository  string
+	concurrency int
+	pushes      int
+	pulls       int
+	layerSizes  []string
+	username    string
+	password    string
+	insecure    bool
+}
+
+// BenchCmd is the cobra command that corresponds to the bench subcommand
+var BenchCmd = &cobra.Command{
+	Use:   "bench <url>",
+	Short: "`bench` measures the push and pull performance of a registry",
+	Long: "`bench` pushes images with layers of random content to the registry at the url, " +
+		"then pulls them, with the given concurrency, and prints the throughput and latency " +
+		"percentiles of pushes and pulls",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "the url of the registry must be given")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		sizes := make([]int64, 0, len(benchOptions.layerSizes))
+		for _, s := range benchOptions.layerSizes {
+			size, err := bench.ParseSize(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid layer size: %v\n", err)
+				os.Exit(1)
+			}
+			sizes = append(sizes, size)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if benchOptions.insecure {
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		// interrupting stops starting operations and reports the ones
+		// already run
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		report, err := bench.Run(ctx, args[0], bench.Options{
+			Repository:  benchOptions.repository,
+			Concurrency: benchOptions.concurrency,
+			Pushes:      benchOptions.pushes,
+			Pulls:       benchOptions.pulls,
+			LayerSizes:  sizes,
+			Username:    benchOptions.username,
+			Password:    benchOptions.password,
+			Transport:   transport,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to run benchmark: %v\n", err)
+			os.Exit(1)
+		}
+		if err := report.Print(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print report: %v\n", err)
+			os.Exit(1)
+		}
+		if report.Push.Errors > 0 || report.Pull.Errors > 0 {
+			stop()
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,423 @@
+// Package bench drives concurrent pushes and pulls of synthetic images
+// against a registry and measures their throughput and latency.
+package bench
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/docker/distribution/registry/client/transport"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The operations measured by a run.
+const (
+	OperationPush = "push"
+	OperationPull = "pull"
+)
+
+// Options configures a run.
+type Options struct {
+	// Repository is the repository images are pushed to and pulled from.
+	// Defaults to a random repository under bench/.
+	Repository string
+	// Concurrency is the number of pushes or pulls in flight. Defaults to
+	// 1.
+	Concurrency int
+	// Pushes is the number of images pushed, each with a single layer of
+	// random content.
+	Pushes int
+	// Pulls is the number of images pulled, going round the pushed images.
+	Pulls int
+	// LayerSizes are the sizes in bytes of the layers of the pushed images,
+	// used in turn. Defaults to 1MiB.
+	LayerSizes []int64
+	// Username and Password authenticate with the registry, either with
+	// HTTP basic authentication or with a token server.
+	Username string
+	Password string
+	// Transport is the transport to the registry. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// Stats are the measurements of an operation.
+type Stats struct {
+	Operation string
+	// Count is the number of successful operations.
+	Count int
+	// Errors is the number of failed operations and Err the first error.
+	Errors int
+	Err    error
+	// Bytes is the size of the layers transferred by successful
+	// operations.
+	Bytes int64
+	// Elapsed is the time all operations took.
+	Elapsed time.Duration
+	// Latencies are the durations of the successful operations, sorted.
+	Latencies []time.Duration
+}
+
+// Throughput returns the bytes transferred per second.
+func (s *Stats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+// Rate returns the successful operations per second.
+func (s *Stats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Count) / s.Elapsed.Seconds()
+}
+
+// Percentile returns the latency under which the percentage p of the
+// successful operations completed, using the nearest rank.
+func (s *Stats) Percentile(p float64) time.Duration {
+	if len(s.Latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(s.Latencies))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(s.Latencies) {
+		rank = len(s.Latencies)
+	}
+	return s.Latencies[rank-1]
+}
+
+// Report holds the measurements of a run, by operation.
+type Report struct {
+	Push Stats
+	Pull Stats
+}
+
+// Print writes the throughput and latency percentiles of each operation to
+// w.
+func (r *Report) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "operation\tcount\terrors\tops/s\tMiB/s\tp50\tp90\tp99\tmax\t")
+	for _, s := range []*Stats{&r.Push, &r.Pull} {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%v\t%v\t%v\t%v\t\n",
+			s.Operation, s.Count, s.Errors, s.Rate(), s.Throughput()/(1<<20),
+			round(s.Percentile(50)), round(s.Percentile(90)), round(s.Percentile(99)), round(s.Percentile(100)))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, s := range []*Stats{&r.Push, &r.Pull} {
+		if s.Err != nil {
+			if _, err := fmt.Fprintf(w, "first %s error: %v\n", s.Operation, s.Err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+// Run pushes the images, then pulls them, measuring each phase. An error is
+// returned if the run could not start; failed operations are counted in the
+// report.
+func Run(ctx context.Context, baseURL string, opts Options) (*Report, error) {
+	if opts.Repository == "" {
+		opts.Repository = "bench/" + randomHex(8)
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if len(opts.LayerSizes) == 0 {
+		opts.LayerSizes = []int64{1 << 20}
+	}
+	for _, size := range opts.LayerSizes {
+		if size < 0 {
+			return nil, fmt.Errorf("invalid layer size %d", size)
+		}
+	}
+	if opts.Pulls > 0 && opts.Pushes == 0 {
+		return nil, errors.New("images must be pushed to be pulled")
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+
+	named, err := reference.WithName(opts.Repository)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := authorize(ctx, baseURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := client.NewRepository(named, baseURL, rt)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bench{repo: repo, opts: opts, tag: "bench-" + randomHex(4)}
+	report := &Report{
+		Push: b.measure(ctx, OperationPush, opts.Pushes, b.push),
+		Pull: b.measure(ctx, OperationPull, opts.Pulls, b.pull),
+	}
+	return report, nil
+}
+
+// credentials authenticates with the registry with static credentials.
+type credentials struct {
+	username, password string
+}
+
+func (c credentials) Basic(*url.URL) (string, string) {
+	return c.username, c.password
+}
+
+func (c credentials) RefreshToken(*url.URL, string) string {
+	return ""
+}
+
+func (c credentials) SetRefreshToken(*url.URL, string, string) {
+}
+
+// authorize returns a transport authenticating with the challenges of the
+// registry, if any.
+func authorize(ctx context.Context, baseURL string, opts Options) (http.RoundTripper, error) {
+	challenges := challenge.NewSimpleManager()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/v2/", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Transport: opts.Transport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the registry: %v", err)
+	}
+	resp.Body.Close()
+	if err := challenges.AddResponse(resp); err != nil {
+		return nil, err
+	}
+
+	creds := credentials{username: opts.Username, password: opts.Password}
+	return transport.NewTransport(opts.Transport, auth.NewAuthorizer(challenges,
+		auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+			Transport:   opts.Transport,
+			Credentials: creds,
+			Scopes: []auth.Scope{
+				auth.RepositoryScope{Repository: opts.Repository, Actions: []string{"pull", "push"}},
+			},
+		}),
+		auth.NewBasicHandler(creds))), nil
+}
+
+// bench pushes and pulls the images of a run.
+type bench struct {
+	repo distribution.Repository
+	opts Options
+	tag  string
+
+	mu     sync.Mutex
+	pushed []image
+}
+
+// image is a pushed image.
+type image struct {
+	tag   string
+	layer distribution.Descriptor
+}
+
+// measure runs n operations with the configured concurrency, measuring
+// their latencies. The operations return the bytes they transferred.
+func (b *bench) measure(ctx context.Context, operation string, n int, op func(ctx context.Context, i int) (int64, error)) Stats {
+	stats := Stats{Operation: operation}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan int)
+
+	start := time.Now()
+	for w := 0; w < b.opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				opStart := time.Now()
+				size, err := op(ctx, i)
+				latency := time.Since(opStart)
+
+				mu.Lock()
+				if err != nil {
+					stats.Errors++
+					if stats.Err == nil {
+						stats.Err = err
+					}
+				} else {
+					stats.Count++
+					stats.Bytes += size
+					stats.Latencies = append(stats.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	stats.Elapsed = time.Since(start)
+
+	sort.Slice(stats.Latencies, func(i, j int) bool { return stats.Latencies[i] < stats.Latencies[j] })
+	return stats
+}
+
+// push pushes an image with a layer of random content, of the next of the
+// configured sizes, and its config, then tags it.
+func (b *bench) push(ctx context.Context, i int) (int64, error) {
+	size := b.opts.LayerSizes[i%len(b.opts.LayerSizes)]
+	content := make([]byte, size)
+	if _, err := rand.Read(content); err != nil {
+		return 0, err
+	}
+
+	blobs := b.repo.Blobs(ctx)
+	layer, err := blobs.Put(ctx, v1.MediaTypeImageLayer, content)
+	if err != nil {
+		return 0, fmt.Errorf("error pushing layer: %v", err)
+	}
+	config, err := blobs.Put(ctx, v1.MediaTypeImageConfig, []byte(fmt.Sprintf(
+		`{"architecture": "amd64", "os": "linux", "rootfs": {"type": "layers", "diff_ids": [%q]}}`, layer.Digest)))
+	if err != nil {
+		return 0, fmt.Errorf("error pushing config: %v", err)
+	}
+
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: config,
+		Layers: []distribution.Descriptor{layer},
+	})
+	if err != nil {
+		return 0, err
+	}
+	manifests, err := b.repo.Manifests(ctx)
+	if err != nil {
+		return 0, err
+	}
+	tag := b.tag + "-" + strconv.Itoa(i)
+	if _, err := manifests.Put(ctx, m, distribution.WithTag(tag)); err != nil {
+		return 0, fmt.Errorf("error pushing manifest: %v", err)
+	}
+
+	b.mu.Lock()
+	b.pushed = append(b.pushed, image{tag: tag, layer: layer})
+	b.mu.Unlock()
+	return size, nil
+}
+
+// pull pulls the next of the pushed images by tag, verifying the content of
+// its layer.
+func (b *bench) pull(ctx context.Context, i int) (int64, error) {
+	b.mu.Lock()
+	if len(b.pushed) == 0 {
+		b.mu.Unlock()
+		return 0, errors.New("no image could be pushed")
+	}
+	img := b.pushed[i%len(b.pushed)]
+	b.mu.Unlock()
+
+	manifests, err := b.repo.Manifests(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m, err := manifests.Get(ctx, "", distribution.WithTag(img.tag))
+	if err != nil {
+		return 0, fmt.Errorf("error pulling manifest: %v", err)
+	}
+	if !references(m, img.layer) {
+		return 0, fmt.Errorf("the manifest of %s does not reference its layer", img.tag)
+	}
+
+	rc, err := b.repo.Blobs(ctx).Open(ctx, img.layer.Digest)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	verifier := img.layer.Digest.Verifier()
+	n, err := io.Copy(verifier, rc)
+	if err != nil {
+		return 0, fmt.Errorf("error pulling layer: %v", err)
+	}
+	if n != img.layer.Size || !verifier.Verified() {
+		return 0, fmt.Errorf("layer %s was not pulled intact", img.layer.Digest)
+	}
+	return n, nil
+}
+
+func references(m distribution.Manifest, layer distribution.Descriptor) bool {
+	for _, desc := range m.References() {
+		if desc.Digest == layer.Digest {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSize parses a size in bytes with an optional binary unit, such as
+// 512, 64KiB, 1MiB or 2GiB.
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"KiB", 1 << 10},
+		{"MiB", 1 << 20},
+		{"GiB", 1 << 30},
+		{"B", 1},
+	}
+	value, unit := s, int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			value, unit = strings.TrimSuffix(s, u.suffix), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+func randomHex(n int) string {
+	p := make([]byte, n)
+	if _, err := rand.Read(p); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(p)
+}
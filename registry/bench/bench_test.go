@@ -0,0 +1,112 @@
+package bench_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/bench"
+	"github.com/docker/distribution/registry/handlers"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestRun(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{
+		"inmemory": map[string]interface{}{},
+	}
+	server := httptest.NewServer(handlers.NewApp(context.Background(), config))
+	defer server.Close()
+
+	report, err := bench.Run(context.Background(), server.URL, bench.Options{
+		Concurrency: 3,
+		Pushes:      5,
+		Pulls:       7,
+		LayerSizes:  []int64{1 << 10, 4 << 10},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error running benchmark: %v", err)
+	}
+
+	for _, tc := range []struct {
+		stats *bench.Stats
+		count int
+		bytes int64
+	}{
+		{stats: &report.Push, count: 5, bytes: 3*1<<10 + 2*4<<10},
+		{stats: &report.Pull, count: 7},
+	} {
+		s := tc.stats
+		if s.Errors != 0 {
+			t.Fatalf("unexpected %s errors: %d, first: %v", s.Operation, s.Errors, s.Err)
+		}
+		if s.Count != tc.count || len(s.Latencies) != tc.count {
+			t.Errorf("unexpected %s count: %d", s.Operation, s.Count)
+		}
+		if tc.bytes != 0 && s.Bytes != tc.bytes {
+			t.Errorf("unexpected %s bytes: %d != %d", s.Operation, s.Bytes, tc.bytes)
+		}
+		if s.Percentile(50) > s.Percentile(99) || s.Percentile(100) != s.Latencies[tc.count-1] {
+			t.Errorf("unexpected %s percentiles: %v", s.Operation, s.Latencies)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.Print(&buf); err != nil {
+		t.Fatalf("unexpected error printing report: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	s := bench.Stats{}
+	for i := 1; i <= 10; i++ {
+		s.Latencies = append(s.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{
+		0:   time.Millisecond,
+		50:  5 * time.Millisecond,
+		90:  9 * time.Millisecond,
+		99:  10 * time.Millisecond,
+		100: 10 * time.Millisecond,
+	} {
+		if latency := s.Percentile(p); latency != expected {
+			t.Errorf("unexpected p%v: %v != %v", p, latency, expected)
+		}
+	}
+	if latency := (&bench.Stats{}).Percentile(50); latency != 0 {
+		t.Errorf("unexpected percentile without latencies: %v", latency)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"0":      0,
+		"512":    512,
+		"512B":   512,
+		"64KiB":  64 << 10,
+		"1MiB":   1 << 20,
+		"2GiB":   2 << 30,
+		"":       -1,
+		"1MB":    -1,
+		"-1KiB":  -1,
+		"1.5MiB": -1,
+	} {
+		size, err := bench.ParseSize(s)
+		if expected < 0 {
+			if err == nil {
+				t.Errorf("expected an error parsing %q, got %d", s, size)
+			}
+			continue
+		}
+		if err != nil || size != expected {
+			t.Errorf("unexpected size of %q: %d, %v", s, size, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+)
+
+// defaultCertificateReloadInterval is how often the TLS certificate files are
+// checked for changes if http.tls.reloadinterval is not set.
+const defaultCertificateReloadInterval = 10 * time.Second
+
+// certificateReloader serves the TLS certificate of the registry, loading it
+// again when its files are replaced on disk so that certificates can be
+// rotated without restarting the registry.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// newCertificateReloader loads the certificate and private key from the
+// files.
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	cr := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.
+func (cr *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// reload loads the certificate again if either of its files changed since it
+// was last loaded, returning whether it did. The current certificate is kept
+// if the files cannot be loaded, such as while they are being replaced.
+func (cr *certificateReloader) reload() (bool, error) {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	cr.mu.RLock()
+	unchanged := cr.cert != nil && certInfo.ModTime().Equal(cr.certMod) && keyInfo.ModTime().Equal(cr.keyMod)
+	cr.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert = &cert
+	cr.certMod = certInfo.ModTime()
+	cr.keyMod = keyInfo.ModTime()
+	return true, nil
+}
+
+// watchCertificate reloads the certificate when its files change, checking
+// them every interval until the registry is shut down.
+func (registry *Registry) watchCertificate(cr *certificateReloader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := cr.reload()
+			if err != nil {
+				dcontext.GetLogger(registry.app).Errorf("error reloading TLS certificate, keeping the current one: %v", err)
+				continue
+			}
+			if reloaded {
+				dcontext.GetLogger(registry.app).Infof("reloaded TLS certificate from %s", cr.certFile)
+			}
+		case <-registry.stopped:
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func copyFile(t *testing.T, src, dst string, modTime time.Time) {
+	t.Helper()
+	p, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, p, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dst, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	first, err := buildRegistryTLSConfig("registry_test_reload_first", "ecdsa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := buildRegistryTLSConfig("registry_test_reload_second", "ecdsa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	modTime := time.Now().Add(-time.Hour)
+	copyFile(t, first.certificatePath, certFile, modTime)
+	copyFile(t, first.privateKeyPath, keyFile, modTime)
+
+	cr, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCertificate := func(expected [][]byte) {
+		t.Helper()
+		cert, err := cr.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(cert.Certificate[0], expected[0]) {
+			t.Fatal("unexpected certificate served")
+		}
+	}
+	assertCertificate(first.certificate.Certificate)
+
+	if reloaded, err := cr.reload(); err != nil || reloaded {
+		t.Fatalf("unexpected reload of unchanged files: %t, %v", reloaded, err)
+	}
+
+	// a certificate not matching the key is not loaded
+	modTime = modTime.Add(time.Minute)
+	copyFile(t, second.certificatePath, certFile, modTime)
+	if _, err := cr.reload(); err == nil {
+		t.Fatal("expected error loading mismatched certificate and key")
+	}
+	assertCertificate(first.certificate.Certificate)
+
+	copyFile(t, second.privateKeyPath, keyFile, modTime)
+	if reloaded, err := cr.reload(); err != nil || !reloaded {
+		t.Fatalf("expected certificate to be reloaded: %t, %v", reloaded, err)
+	}
+	assertCertificate(second.certificate.Certificate)
+}
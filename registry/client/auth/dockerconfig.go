@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// tokenUsername is the username returned by credential helpers and stored in
+// config.json when the secret is an identity token.
+const tokenUsername = "<token>"
+
+// DockerConfig holds the credentials of a Docker client configuration file,
+// either stored inline or provided by credential helpers.
+type DockerConfig struct {
+	Auths       map[string]DockerAuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore,omitempty"`
+	CredHelpers map[string]string           `json:"credHelpers,omitempty"`
+}
+
+// DockerAuthConfig holds the credentials of a registry in a Docker client
+// configuration file.
+type DockerAuthConfig struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// DefaultDockerConfigPath returns the path of the Docker client
+// configuration file, in the directory given by the DOCKER_CONFIG environment
+// variable or in ~/.docker.
+func DefaultDockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// LoadDockerConfig reads a Docker client configuration file. A missing file
+// results in an empty configuration.
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	config := &DockerConfig{}
+
+	p, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(p, config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// CredentialStore returns a CredentialStore which provides the credentials
+// configured for the registry, given as a host name such as
+// "registry.example.com:5000". The credentials are used for any URL the
+// store is asked about, since token servers are usually on a different host
+// than the registry they issue tokens for.
+//
+// Credential helpers are run each time credentials are requested, so that
+// rotated credentials are picked up by long-running clients.
+func (c *DockerConfig) CredentialStore(registry string) CredentialStore {
+	return &dockerCredentialStore{
+		config:        c,
+		registry:      normalizeRegistryHost(registry),
+		refreshTokens: make(map[string]string),
+	}
+}
+
+// credentials returns the username and secret configured for the registry.
+func (c *DockerConfig) credentials(registry string) (string, string, error) {
+	helper := c.CredsStore
+	for host, h := range c.CredHelpers {
+		if normalizeRegistryHost(host) == registry {
+			helper = h
+			break
+		}
+	}
+	if helper != "" {
+		username, secret, err := runCredentialHelper(helper, registry)
+		if err == nil || !errors.Is(err, errCredentialsNotFound) {
+			return username, secret, err
+		}
+	}
+
+	for host, ac := range c.Auths {
+		if normalizeRegistryHost(host) != registry {
+			continue
+		}
+		if ac.IdentityToken != "" {
+			return tokenUsername, ac.IdentityToken, nil
+		}
+		if ac.Auth == "" {
+			return ac.Username, ac.Password, nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(ac.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid auth for %s: %w", host, err)
+		}
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return "", "", fmt.Errorf("invalid auth for %s", host)
+		}
+		return username, password, nil
+	}
+
+	return "", "", nil
+}
+
+var errCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// runCredentialHelper gets the credentials of the registry from the
+// docker-credential-<helper> program.
+func runCredentialHelper(helper, registry string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if msg == errCredentialsNotFound.Error() {
+			return "", "", errCredentialsNotFound
+		}
+		return "", "", fmt.Errorf("credential helper %s: %v: %s", helper, err, msg)
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", fmt.Errorf("credential helper %s: %w", helper, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// normalizeRegistryHost strips the scheme and path from a registry address,
+// mapping the legacy Docker Hub address to its registry host.
+func normalizeRegistryHost(registry string) string {
+	if strings.Contains(registry, "://") {
+		if u, err := url.Parse(registry); err == nil {
+			registry = u.Host
+		}
+	}
+	registry = strings.SplitN(registry, "/", 2)[0]
+	if registry == "index.docker.io" {
+		return "registry-1.docker.io"
+	}
+	return registry
+}
+
+// dockerCredentialStore is a CredentialStore backed by a DockerConfig.
+type dockerCredentialStore struct {
+	config   *DockerConfig
+	registry string
+
+	mu            sync.Mutex
+	refreshTokens map[string]string
+}
+
+// Basic returns the username and password configured for the registry. If
+// the configured secret is an identity token, no basic credentials are
+// returned and the token is used as refresh token instead.
+func (s *dockerCredentialStore) Basic(*url.URL) (string, string) {
+	username, secret, err := s.config.credentials(s.registry)
+	if err != nil || username == tokenUsername {
+		return "", ""
+	}
+	return username, secret
+}
+
+// RefreshToken returns the refresh token issued for the service, or the
+// identity token configured for the registry.
+func (s *dockerCredentialStore) RefreshToken(u *url.URL, service string) string {
+	s.mu.Lock()
+	token, ok := s.refreshTokens[service]
+	s.mu.Unlock()
+	if ok {
+		return token
+	}
+
+	username, secret, err := s.config.credentials(s.registry)
+	if err != nil || username != tokenUsername {
+		return ""
+	}
+	return secret
+}
+
+// SetRefreshToken stores the refresh token issued for the service.
+func (s *dockerCredentialStore) SetRefreshToken(u *url.URL, service, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshTokens[service] = token
+}
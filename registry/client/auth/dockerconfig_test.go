@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func writeDockerConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDockerConfigAuths(t *testing.T) {
+	config, err := LoadDockerConfig(writeDockerConfig(t, `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "aHVidXNlcjpodWJwYXNz"},
+			"registry.example.com:5000": {"username": "user", "password": "pass"},
+			"tokens.example.com": {"identitytoken": "identity"}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error loading config: %v", err)
+	}
+
+	realm, _ := url.Parse("https://auth.example.com/token")
+	for _, tc := range []struct {
+		registry     string
+		username     string
+		password     string
+		refreshToken string
+	}{
+		{"registry-1.docker.io", "hubuser", "hubpass", ""},
+		{"registry.example.com:5000", "user", "pass", ""},
+		{"tokens.example.com", "", "", "identity"},
+		{"unknown.example.com", "", "", ""},
+	} {
+		creds := config.CredentialStore(tc.registry)
+		username, password := creds.Basic(realm)
+		if username != tc.username || password != tc.password {
+			t.Fatalf("unexpected credentials for %s: %s:%s", tc.registry, username, password)
+		}
+		if token := creds.RefreshToken(realm, "service"); token != tc.refreshToken {
+			t.Fatalf("unexpected refresh token for %s: %q", tc.registry, token)
+		}
+	}
+
+	creds := config.CredentialStore("tokens.example.com")
+	creds.SetRefreshToken(realm, "service", "refreshed")
+	if token := creds.RefreshToken(realm, "service"); token != "refreshed" {
+		t.Fatalf("unexpected refresh token after refresh: %q", token)
+	}
+}
+
+func TestDockerConfigCredentialHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper script requires a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	helper := `#!/bin/sh
+read registry
+if [ "$registry" = "helped.example.com" ]; then
+	echo '{"ServerURL":"helped.example.com","Username":"helper","Secret":"secret"}'
+else
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(helper), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config, err := LoadDockerConfig(writeDockerConfig(t, `{
+		"auths": {"fallback.example.com": {"username": "user", "password": "pass"}},
+		"credHelpers": {"helped.example.com": "test", "fallback.example.com": "test"}
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error loading config: %v", err)
+	}
+
+	realm, _ := url.Parse("https://auth.example.com/token")
+	if username, password := config.CredentialStore("helped.example.com").Basic(realm); username != "helper" || password != "secret" {
+		t.Fatalf("unexpected credentials from helper: %s:%s", username, password)
+	}
+	if username, password := config.CredentialStore("fallback.example.com").Basic(realm); username != "user" || password != "pass" {
+		t.Fatalf("unexpected fallback credentials: %s:%s", username, password)
+	}
+}
+
+func TestLoadDockerConfigMissing(t *testing.T) {
+	config, err := LoadDockerConfig(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatalf("unexpected error loading missing config: %v", err)
+	}
+	if len(config.Auths) != 0 {
+		t.Fatalf("unexpected auths: %v", config.Auths)
+	}
+}
@@ -129,6 +129,7 @@ type tokenHandler struct {
 	forceOAuth    bool
 	clientID      string
 	scopes        []Scope
+	refreshWindow time.Duration
 
 	tokenLock       sync.Mutex
 	tokenCache      string
@@ -198,6 +199,11 @@ type TokenHandlerOptions struct {
 	ClientID      string
 	Scopes        []Scope
 	Logger        Logger
+
+	// RefreshWindow is how long before its expiration a cached token is
+	// refreshed, so that requests of long-running jobs are not sent with a
+	// token that expires before the registry handles them.
+	RefreshWindow time.Duration
 }
 
 // An implementation of clock for providing real time data.
@@ -232,6 +238,7 @@ func NewTokenHandlerWithOptions(options TokenHandlerOptions) AuthenticationHandl
 		forceOAuth:    options.ForceOAuth,
 		clientID:      options.ClientID,
 		scopes:        options.Scopes,
+		refreshWindow: options.RefreshWindow,
 		clock:         realClock{},
 		logger:        options.Logger,
 	}
@@ -286,7 +293,7 @@ func (th *tokenHandler) getToken(ctx context.Context, params map[string]string,
 	}
 
 	now := th.clock.Now()
-	if now.After(th.tokenExpiration) || addedScopes {
+	if now.Add(th.refreshWindow).After(th.tokenExpiration) || addedScopes {
 		token, expiration, err := th.fetchToken(ctx, params, scopes)
 		if err != nil {
 			return "", err
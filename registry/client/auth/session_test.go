@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"net/http"
@@ -863,3 +864,40 @@ func TestEndpointAuthorizeBasic(t *testing.T) {
 		t.Fatalf("Unexpected status code: %d, expected %d", resp.StatusCode, http.StatusAccepted)
 	}
 }
+
+func TestTokenHandlerRefreshWindow(t *testing.T) {
+	tokenExchanges := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenExchanges++
+		fmt.Fprintf(w, `{"token":"token%d", "expires_in": 100}`, tokenExchanges)
+	}))
+	defer ts.Close()
+
+	clock := &fakeClock{current: time.Now()}
+	tHandler := NewTokenHandlerWithOptions(TokenHandlerOptions{
+		Credentials:   &testCredentialStore{},
+		RefreshWindow: 10 * time.Second,
+	})
+	tHandler.(*tokenHandler).clock = clock
+
+	params := map[string]string{"realm": ts.URL + "/token", "service": "localhost.localdomain"}
+	for _, tc := range []struct {
+		elapsed time.Duration
+		token   string
+	}{
+		{0, "token1"},
+		{50 * time.Second, "token1"},
+		// within the refresh window of the first token
+		{45 * time.Second, "token2"},
+		{10 * time.Second, "token2"},
+	} {
+		clock.current = clock.current.Add(tc.elapsed)
+		token, err := tHandler.(*tokenHandler).getToken(context.Background(), params)
+		if err != nil {
+			t.Fatalf("unexpected error getting token: %v", err)
+		}
+		if token != tc.token {
+			t.Fatalf("unexpected token after %v: %s != %s", tc.elapsed, token, tc.token)
+		}
+	}
+}
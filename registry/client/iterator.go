@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution"
+)
+
+// Iterator iterates over a paginated listing, such as the repositories of
+// the catalog or the tags of a repository, fetching pages as needed by
+// following the Link header of each response. Successive calls to Next step
+// through the entries; iteration stops on the last entry or on the first
+// error, which is returned by Err.
+//
+//	it := registry.AllRepositories(ctx)
+//	for it.Next() {
+//		fmt.Println(it.Value())
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type Iterator struct {
+	ctx    context.Context
+	client *http.Client
+	key    string
+
+	next  *url.URL
+	page  []string
+	value string
+	err   error
+}
+
+// newIterator returns an iterator over the entries listed under key in the
+// responses of the paginated endpoint at u.
+func newIterator(ctx context.Context, client *http.Client, u, key string) *Iterator {
+	it := &Iterator{
+		ctx:    ctx,
+		client: client,
+		key:    key,
+	}
+	it.next, it.err = url.Parse(u)
+	return it
+}
+
+// sliceIterator returns an iterator over entries which were already fetched.
+func sliceIterator(entries []string, err error) *Iterator {
+	return &Iterator{
+		page: entries,
+		err:  err,
+	}
+}
+
+// Next advances the iterator to the next entry, fetching the next page if
+// needed. It returns false when there are no more entries or an error
+// occurred.
+func (it *Iterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.err != nil || it.next == nil {
+			return false
+		}
+		it.fetch()
+	}
+
+	it.value, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Value returns the current entry.
+func (it *Iterator) Value() string {
+	return it.value
+}
+
+// Err returns the first error encountered by the iterator.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// fetch fetches the next page and advances to the page after it.
+func (it *Iterator) fetch() {
+	current := it.next
+	it.next = nil
+
+	req, err := http.NewRequestWithContext(it.ctx, http.MethodGet, current.String(), nil)
+	if err != nil {
+		it.err = err
+		return
+	}
+	resp, err := it.client.Do(req)
+	if err != nil {
+		it.err = err
+		return
+	}
+	defer resp.Body.Close()
+
+	if !SuccessStatus(resp.StatusCode) {
+		it.err = HandleErrorResponse(resp)
+		return
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		it.err = err
+		return
+	}
+	if entries, ok := body[it.key]; ok {
+		if err := json.Unmarshal(entries, &it.page); err != nil {
+			it.err = err
+			return
+		}
+	}
+
+	if link := resp.Header.Get("Link"); link != "" {
+		firstLink, _, _ := strings.Cut(link, ";")
+		linkURL, err := url.Parse(strings.Trim(strings.TrimSpace(firstLink), "<>"))
+		if err != nil {
+			it.err = err
+			return
+		}
+		it.next = current.ResolveReference(linkURL)
+	}
+}
+
+// AllRepositories returns an iterator over the repositories of the catalog.
+func (r *registry) AllRepositories(ctx context.Context) *Iterator {
+	u, err := r.ub.BuildCatalogURL()
+	if err != nil {
+		return sliceIterator(nil, err)
+	}
+	return newIterator(ctx, r.client, u, "repositories")
+}
+
+// AllTags returns an iterator over the tags of the repository. Tags of
+// repositories which were not created with NewRepository are listed with a
+// single call to the tag service.
+func AllTags(ctx context.Context, repo distribution.Repository) *Iterator {
+	if r, ok := repo.(*repository); ok {
+		u, err := r.ub.BuildTagsURL(r.name)
+		if err != nil {
+			return sliceIterator(nil, err)
+		}
+		return newIterator(ctx, r.client, u, "tags")
+	}
+
+	tags, err := repo.Tags(ctx).All(ctx)
+	return sliceIterator(tags, err)
+}
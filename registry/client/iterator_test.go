@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/testutil"
+)
+
+func TestAllRepositories(t *testing.T) {
+	var m testutil.RequestResponseMap
+	addTestCatalog(
+		"/v2/_catalog",
+		[]byte("{\"repositories\":[\"bar\", \"baz\"]}"),
+		"</v2/_catalog?cursor=abc&n=2>; rel=\"next\"", &m)
+	addTestCatalog(
+		"/v2/_catalog?cursor=abc&n=2",
+		[]byte("{\"repositories\":[]}"),
+		"</v2/_catalog?cursor=def&n=2>; rel=\"next\"", &m)
+	addTestCatalog(
+		"/v2/_catalog?cursor=def&n=2",
+		[]byte("{\"repositories\":[\"foo\"]}"),
+		"", &m)
+
+	e, c := testServer(m)
+	defer c()
+
+	r, err := NewRegistry(e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var repos []string
+	it := r.AllRepositories(context.Background())
+	for it.Next() {
+		repos = append(repos, it.Value())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error iterating catalog: %v", err)
+	}
+	if expected := []string{"bar", "baz", "foo"}; !reflect.DeepEqual(repos, expected) {
+		t.Fatalf("unexpected repositories: %v != %v", repos, expected)
+	}
+}
+
+func TestAllTagsError(t *testing.T) {
+	repo, _ := reference.WithName("test.example.com/repo")
+	m := testutil.RequestResponseMap{
+		{
+			Request: testutil.Request{
+				Method: http.MethodGet,
+				Route:  "/v2/" + repo.Name() + "/tags/list",
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Body:       []byte(`{"name":"test.example.com/repo","tags":["a","b"]}`),
+				Headers: http.Header(map[string][]string{
+					"Link": {"</v2/" + repo.Name() + "/tags/list?last=b&n=2>; rel=\"next\""},
+				}),
+			},
+		},
+		{
+			Request: testutil.Request{
+				Method: http.MethodGet,
+				Route:  "/v2/" + repo.Name() + "/tags/list?last=b&n=2",
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusNotFound,
+			},
+		},
+	}
+
+	e, c := testServer(m)
+	defer c()
+
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tags []string
+	it := AllTags(context.Background(), r)
+	for it.Next() {
+		tags = append(tags, it.Value())
+	}
+	if it.Err() == nil {
+		t.Fatal("expected an error fetching the second page")
+	}
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("unexpected tags: %v != %v", tags, expected)
+	}
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+)
+
+const (
+	defaultPushConcurrency = 3
+	defaultPushChunkSize   = 5 << 20
+	defaultPushRetries     = 3
+	defaultPushRetryDelay  = time.Second
+)
+
+// Layer describes a blob to be pushed by PushLayers.
+type Layer struct {
+	// Descriptor identifies the blob. The digest and size are required.
+	Descriptor distribution.Descriptor
+
+	// Open returns a reader for the blob content. It is only called if the
+	// blob has to be uploaded.
+	Open func() (io.ReadCloser, error)
+
+	// MountFrom lists repositories which may already hold the blob. A cross
+	// repository mount is attempted from each of them, in order, before the
+	// content is uploaded.
+	MountFrom []reference.Named
+}
+
+// PushOptions configures PushLayers.
+type PushOptions struct {
+	// Concurrency is the maximum number of layers pushed at once. Defaults
+	// to 3.
+	Concurrency int
+
+	// ChunkSize is the size of each chunk of an upload. Defaults to 5MiB.
+	ChunkSize int64
+
+	// Retries is the number of times a failed chunk or commit is retried.
+	// Defaults to 3; a negative value disables retries.
+	Retries int
+
+	// RetryDelay is the delay before the first retry, doubled for each
+	// subsequent one. Defaults to one second.
+	RetryDelay time.Duration
+}
+
+// PushLayers pushes the layers to the blob store, skipping those which
+// already exist. Layers are pushed concurrently, mounted from another
+// repository when possible and otherwise uploaded in chunks, retrying failed
+// chunks. The first error cancels the remaining pushes and is returned.
+func PushLayers(ctx context.Context, blobs distribution.BlobStore, layers []Layer, opts PushOptions) error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultPushConcurrency
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultPushChunkSize
+	}
+	if opts.Retries == 0 {
+		opts.Retries = defaultPushRetries
+	} else if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultPushRetryDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, opts.Concurrency)
+	)
+
+	for _, layer := range layers {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(layer Layer) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := pushLayer(ctx, blobs, layer, opts); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("pushing layer %s: %w", layer.Descriptor.Digest, err)
+					cancel()
+				})
+			}
+		}(layer)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// pushLayer pushes a single layer, by mounting it if possible.
+func pushLayer(ctx context.Context, blobs distribution.BlobStore, layer Layer, opts PushOptions) error {
+	if _, err := blobs.Stat(ctx, layer.Descriptor.Digest); err == nil {
+		return nil
+	} else if err != distribution.ErrBlobUnknown {
+		return err
+	}
+
+	var bw distribution.BlobWriter
+	for _, from := range layer.MountFrom {
+		canonical, err := reference.WithDigest(reference.TrimNamed(from), layer.Descriptor.Digest)
+		if err != nil {
+			return err
+		}
+
+		bw, err = blobs.Create(ctx, WithMountFrom(canonical))
+		if err == nil {
+			// the registry started an upload instead of mounting
+			break
+		}
+		if errors.As(err, &distribution.ErrBlobMounted{}) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	if bw == nil {
+		var err error
+		bw, err = blobs.Create(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := uploadLayer(ctx, bw, layer, opts); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	return nil
+}
+
+// uploadLayer uploads the layer content in chunks and commits the upload.
+func uploadLayer(ctx context.Context, bw distribution.BlobWriter, layer Layer, opts PushOptions) error {
+	rc, err := layer.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	buf := make([]byte, opts.ChunkSize)
+	for offset := int64(0); offset < layer.Descriptor.Size; {
+		n, err := io.ReadFull(rc, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if offset+int64(n) != layer.Descriptor.Size {
+				return fmt.Errorf("unexpected end of content at %d of %d bytes", offset+int64(n), layer.Descriptor.Size)
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if err := retry(ctx, opts, func() error {
+			_, err := bw.Write(buf[:n])
+			return err
+		}); err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+
+	return retry(ctx, opts, func() error {
+		_, err := bw.Commit(ctx, layer.Descriptor)
+		return err
+	})
+}
+
+// retry calls fn until it succeeds, the retries are exhausted or the context
+// is done, doubling the delay between attempts.
+func retry(ctx context.Context, opts PushOptions, fn func() error) error {
+	delay := opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= opts.Retries {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// pushTestBlobStore is a blob store which fails the first write of each
+// upload and mounts blobs from the repositories in mountable.
+type pushTestBlobStore struct {
+	distribution.BlobStore
+
+	mu        sync.Mutex
+	blobs     map[digest.Digest][]byte
+	mountable map[string]bool
+	mounted   []string
+	writes    int
+	active    int
+	maxActive int
+}
+
+func (bs *pushTestBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	p, ok := bs.blobs[dgst]
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	return distribution.Descriptor{Digest: dgst, Size: int64(len(p))}, nil
+}
+
+func (bs *pushTestBlobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	var opts distribution.CreateOptions
+	for _, option := range options {
+		if err := option.Apply(&opts); err != nil {
+			return nil, err
+		}
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if opts.Mount.ShouldMount && bs.mountable[opts.Mount.From.Name()] {
+		desc := distribution.Descriptor{Digest: opts.Mount.From.Digest()}
+		bs.blobs[desc.Digest] = nil
+		bs.mounted = append(bs.mounted, opts.Mount.From.String())
+		return nil, distribution.ErrBlobMounted{From: opts.Mount.From, Descriptor: desc}
+	}
+
+	bs.active++
+	if bs.active > bs.maxActive {
+		bs.maxActive = bs.active
+	}
+	return &pushTestBlobWriter{bs: bs}, nil
+}
+
+type pushTestBlobWriter struct {
+	distribution.BlobWriter
+
+	bs     *pushTestBlobStore
+	buf    bytes.Buffer
+	failed bool
+}
+
+func (bw *pushTestBlobWriter) Write(p []byte) (int, error) {
+	bw.bs.mu.Lock()
+	bw.bs.writes++
+	bw.bs.mu.Unlock()
+
+	if !bw.failed {
+		bw.failed = true
+		return 0, errors.New("connection reset")
+	}
+	// give other uploads a chance to start
+	time.Sleep(time.Millisecond)
+	return bw.buf.Write(p)
+}
+
+func (bw *pushTestBlobWriter) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
+	bw.bs.mu.Lock()
+	defer bw.bs.mu.Unlock()
+
+	if digest.FromBytes(bw.buf.Bytes()) != desc.Digest {
+		return distribution.Descriptor{}, distribution.ErrBlobInvalidDigest{Digest: desc.Digest}
+	}
+	bw.bs.blobs[desc.Digest] = bw.buf.Bytes()
+	bw.bs.active--
+	return desc, nil
+}
+
+func (bw *pushTestBlobWriter) Cancel(ctx context.Context) error {
+	bw.bs.mu.Lock()
+	defer bw.bs.mu.Unlock()
+
+	bw.bs.active--
+	return nil
+}
+
+func newPushTestLayer(t *testing.T, size int) (Layer, []byte) {
+	t.Helper()
+
+	dgst, b := newRandomBlob(size)
+	return Layer{
+		Descriptor: distribution.Descriptor{Digest: dgst, Size: int64(len(b))},
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		},
+	}, b
+}
+
+func TestPushLayers(t *testing.T) {
+	from, err := reference.WithName("library/base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing, _ := newPushTestLayer(t, 16)
+	mountable, _ := newPushTestLayer(t, 16)
+	mountable.MountFrom = []reference.Named{from}
+
+	bs := &pushTestBlobStore{
+		blobs:     map[digest.Digest][]byte{existing.Descriptor.Digest: nil},
+		mountable: map[string]bool{from.Name(): true},
+	}
+
+	layers := []Layer{existing, mountable}
+	contents := make(map[digest.Digest][]byte)
+	for i := 0; i < 6; i++ {
+		layer, b := newPushTestLayer(t, 100)
+		layers = append(layers, layer)
+		contents[layer.Descriptor.Digest] = b
+	}
+
+	err = PushLayers(context.Background(), bs, layers, PushOptions{
+		Concurrency: 2,
+		ChunkSize:   32,
+		RetryDelay:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error pushing layers: %v", err)
+	}
+
+	for dgst, b := range contents {
+		if !bytes.Equal(bs.blobs[dgst], b) {
+			t.Fatalf("unexpected content for %s", dgst)
+		}
+	}
+	if len(bs.mounted) != 1 || bs.mounted[0] != "library/base@"+mountable.Descriptor.Digest.String() {
+		t.Fatalf("unexpected mounts: %v", bs.mounted)
+	}
+	// four chunks per layer, and one failed write each
+	if bs.writes != 6*5 {
+		t.Fatalf("unexpected number of writes: %d", bs.writes)
+	}
+	if bs.maxActive > 2 {
+		t.Fatalf("too many concurrent uploads: %d", bs.maxActive)
+	}
+}
+
+func TestPushLayersError(t *testing.T) {
+	bs := &pushTestBlobStore{blobs: make(map[digest.Digest][]byte)}
+
+	layer, _ := newPushTestLayer(t, 16)
+	err := PushLayers(context.Background(), bs, []Layer{layer}, PushOptions{
+		Retries: -1,
+	})
+	if err == nil {
+		t.Fatal("expected an error pushing layers without retries")
+	}
+	if bs.active != 0 {
+		t.Fatalf("expected the upload to be cancelled, %d still active", bs.active)
+	}
+}
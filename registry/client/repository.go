@@ -11,7 +11,6 @@ import (
 	"net/url"
 	"path"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/docker/distribution"
@@ -26,6 +25,9 @@ import (
 // Registry provides an interface for calling Repositories, which returns a catalog of repositories.
 type Registry interface {
 	Repositories(ctx context.Context, repos []string, last string) (n int, err error)
+
+	// AllRepositories returns an iterator over the whole catalog.
+	AllRepositories(ctx context.Context) *Iterator
 }
 
 // checkHTTPRedirect is a callback that can manipulate redirected HTTP
@@ -87,7 +89,7 @@ type registry struct {
 
 // Repositories returns a lexigraphically sorted catalog given a base URL.  The 'entries' slice will be filled up to the size
 // of the slice, starting at the value provided in 'last'.  The number of entries will be returned along with io.EOF if there
-// are no more entries
+// are no more entries. AllRepositories is simpler to use for listing the whole catalog.
 func (r *registry) Repositories(ctx context.Context, entries []string, last string) (int, error) {
 	var numFilled int
 	var returnErr error
@@ -202,55 +204,16 @@ type tags struct {
 func (t *tags) All(ctx context.Context) ([]string, error) {
 	var tags []string
 
-	listURLStr, err := t.ub.BuildTagsURL(t.name)
-	if err != nil {
-		return tags, err
-	}
-
-	listURL, err := url.Parse(listURLStr)
+	listURL, err := t.ub.BuildTagsURL(t.name)
 	if err != nil {
 		return tags, err
 	}
 
-	for {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL.String(), nil)
-		if err != nil {
-			return nil, err
-		}
-		resp, err := t.client.Do(req)
-		if err != nil {
-			return tags, err
-		}
-		defer resp.Body.Close()
-
-		if SuccessStatus(resp.StatusCode) {
-			b, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return tags, err
-			}
-
-			tagsResponse := struct {
-				Tags []string `json:"tags"`
-			}{}
-			if err := json.Unmarshal(b, &tagsResponse); err != nil {
-				return tags, err
-			}
-			tags = append(tags, tagsResponse.Tags...)
-			if link := resp.Header.Get("Link"); link != "" {
-				firsLink, _, _ := strings.Cut(link, ";")
-				linkURL, err := url.Parse(strings.Trim(firsLink, "<>"))
-				if err != nil {
-					return tags, err
-				}
-
-				listURL = listURL.ResolveReference(linkURL)
-			} else {
-				return tags, nil
-			}
-		} else {
-			return tags, HandleErrorResponse(resp)
-		}
+	it := newIterator(ctx, t.client, listURL, "tags")
+	for it.Next() {
+		tags = append(tags, it.Value())
 	}
+	return tags, it.Err()
 }
 
 func descriptorFromResponse(response *http.Response) (distribution.Descriptor, error) {
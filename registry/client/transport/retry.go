@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures the retries of a transport created with
+// NewRetryTransport.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of times a request is retried.
+	MaxRetries int
+
+	// InitialBackoff is the delay before the first retry, doubled for each
+	// subsequent one.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries, including delays requested
+	// by the server with a Retry-After header.
+	MaxBackoff time.Duration
+
+	// RetryNonIdempotent allows retrying requests with methods which are not
+	// idempotent, such as POST and PATCH. A PATCH of a blob upload that is
+	// retried after the registry received part of it is rejected, so this
+	// should only be enabled for registries known to handle it.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy is the retry policy used by NewRetryTransport when
+// none is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+}
+
+// NewRetryTransport creates a new transport which retries requests failing
+// with a network error or a temporary server error, backing off
+// exponentially between attempts and honoring the Retry-After header.
+// Requests are only retried if their method is idempotent, unless the policy
+// allows otherwise, and if their body can be replayed through GetBody.
+//
+// The transport can be given to NewRepository or NewRegistry to configure
+// retries for a single client.
+func NewRetryTransport(base http.RoundTripper, policy *RetryPolicy) http.RoundTripper {
+	if policy == nil {
+		policy = &DefaultRetryPolicy
+	}
+	return DefaultTransportWrapper(
+		&retryTransport{
+			Base:   base,
+			policy: *policy,
+		})
+}
+
+// retryTransport is an http.RoundTripper that retries failed requests.
+type retryTransport struct {
+	Base   http.RoundTripper
+	policy RetryPolicy
+}
+
+// RoundTrip sends the request, retrying it according to the policy.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := t.retryable(req)
+	backoff := t.policy.InitialBackoff
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base().RoundTrip(req)
+		if !retryable || attempt >= t.policy.MaxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay := backoff
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = retryAfter
+			}
+			// drain the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if t.policy.MaxBackoff > 0 && delay > t.policy.MaxBackoff {
+			delay = t.policy.MaxBackoff
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+
+		if req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		backoff *= 2
+	}
+}
+
+// retryable returns true if the request may be sent more than once.
+func (t *retryTransport) retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if t.policy.RetryNonIdempotent {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (t *retryTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// shouldRetry returns true if the result of a request indicates a temporary
+// failure.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given either in
+// seconds or as an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		delay := time.Until(t)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
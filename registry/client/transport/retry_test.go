@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	t.Parallel()
+
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(nil, &RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: time.Hour,
+	})}
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "content" {
+		t.Fatalf("unexpected body of retried request: %q", body)
+	}
+	if attempts != 3 {
+		t.Fatalf("unexpected number of attempts: %d", attempts)
+	}
+}
+
+func TestRetryTransportLimits(t *testing.T) {
+	t.Parallel()
+
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(nil, &RetryPolicy{
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+	})}
+
+	for _, tc := range []struct {
+		method   string
+		attempts int32
+	}{
+		{http.MethodGet, 3},
+		{http.MethodPost, 1},
+		{http.MethodPatch, 1},
+	} {
+		atomic.StoreInt32(&attempts, 0)
+
+		req, err := http.NewRequest(tc.method, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("unexpected status for %s: %d", tc.method, resp.StatusCode)
+		}
+		if n := atomic.LoadInt32(&attempts); n != tc.attempts {
+			t.Fatalf("unexpected number of attempts for %s: %d != %d", tc.method, n, tc.attempts)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0, true},
+	} {
+		delay, ok := parseRetryAfter(tc.value)
+		if delay != tc.delay || ok != tc.ok {
+			t.Fatalf("unexpected result for %q: %v, %v", tc.value, delay, ok)
+		}
+	}
+}
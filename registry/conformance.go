@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/conformance"
+	"github.com/docker/distribution/version"
+	"github.com/spf13/cobra"
+)
+
+var (
+	conformanceUsername string
+	conformancePassword string
+)
+
+// ConformanceCmd is the cobra command that corresponds to the conformance
+// subcommand
+var ConformanceCmd = &cobra.Command{
+	Use:   "conformance <config>",
+	Short: "`conformance` checks the registry against the OCI distribution specification",
+	Long: "`conformance` starts the registry with the configuration on a local port, " +
+		"runs the workflows of the OCI distribution specification against it and " +
+		"prints a pass/fail report, exiting with status 1 if a check failed",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := dcontext.WithVersion(dcontext.Background(), version.Version)
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		// serve on a free local port, whatever the configured address
+		config.HTTP.Net = "tcp"
+		config.HTTP.Addr = "127.0.0.1:0"
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		registry, err := NewRegistry(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v\n", err)
+			os.Exit(1)
+		}
+		if err := registry.Start(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start registry: %v\n", err)
+			os.Exit(1)
+		}
+
+		scheme := "http"
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if config.HTTP.TLS.Certificate != "" || config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
+			// the certificate is not issued for the local address
+			scheme = "https"
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		baseURL := scheme + "://" + registry.Addr().String() + strings.TrimSuffix(config.HTTP.Prefix, "/")
+
+		report, err := conformance.Run(ctx, baseURL, conformance.Options{
+			Username:  conformanceUsername,
+			Password:  conformancePassword,
+			Transport: transport,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to run conformance checks: %v\n", err)
+			os.Exit(1)
+		}
+		if err := report.Print(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print report: %v\n", err)
+			os.Exit(1)
+		}
+		if report.Failed() > 0 {
+			cancel()
+			os.Exit(1)
+		}
+	},
+}
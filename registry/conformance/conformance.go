@@ -0,0 +1,894 @@
+// Package conformance checks that a registry implements the workflows of the
+// OCI distribution specification, modeled on the conformance suite of the
+// specification: pushing, pulling, discovering and managing content.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/docker/distribution/registry/client/transport"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The workflows of the distribution specification, in the order they run.
+const (
+	WorkflowPush              = "push"
+	WorkflowPull              = "pull"
+	WorkflowContentDiscovery  = "content discovery"
+	WorkflowContentManagement = "content management"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+// The outcomes of a check.
+const (
+	StatusPass Status = "PASS"
+	StatusFail Status = "FAIL"
+	StatusSkip Status = "SKIP"
+)
+
+// Check is the outcome of a single check of a workflow.
+type Check struct {
+	Workflow string
+	Name     string
+	Status   Status
+	// Detail explains why the check failed or was skipped.
+	Detail string
+}
+
+// Report lists the outcomes of the checks run against a registry.
+type Report struct {
+	Checks []Check
+}
+
+// Failed returns the number of failed checks.
+func (r *Report) Failed() int {
+	var failed int
+	for _, check := range r.Checks {
+		if check.Status == StatusFail {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Print writes the outcome of each check and a summary to w.
+func (r *Report) Print(w io.Writer) error {
+	counts := make(map[Status]int)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, check := range r.Checks {
+		counts[check.Status]++
+		line := fmt.Sprintf("%s\t%s\t%s", check.Status, check.Workflow, check.Name)
+		if check.Detail != "" {
+			line += "\t" + check.Detail
+		}
+		fmt.Fprintln(tw, line)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", counts[StatusPass], counts[StatusFail], counts[StatusSkip])
+	return err
+}
+
+// Options configures the checks.
+type Options struct {
+	// Namespace is the repository content is pushed to. Defaults to a
+	// random repository under conformance/.
+	Namespace string
+	// CrossmountNamespace is the repository blobs are mounted to from
+	// Namespace. Defaults to a random repository under conformance/.
+	CrossmountNamespace string
+	// Username and Password authenticate with the registry, either with
+	// HTTP basic authentication or with a token server.
+	Username string
+	Password string
+	// Transport is the transport to the registry. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// errSkip is returned by checks which do not apply to the registry.
+type errSkip string
+
+func (e errSkip) Error() string {
+	return string(e)
+}
+
+// Run runs the checks of every workflow against the registry at baseURL,
+// pushing and deleting content in the configured namespaces. An error is
+// returned if the checks could not be run at all.
+func Run(ctx context.Context, baseURL string, opts Options) (*Report, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry url: %v", err)
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "conformance/" + randomHex(8)
+	}
+	if opts.CrossmountNamespace == "" {
+		opts.CrossmountNamespace = "conformance/" + randomHex(8)
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+
+	c := &checker{
+		ctx:     ctx,
+		base:    base,
+		opts:    opts,
+		tag:     "conformance-" + randomHex(4),
+		report:  &Report{},
+		blobs:   make(map[digest.Digest][]byte),
+		mounted: make(map[digest.Digest]bool),
+	}
+	if err := c.authorize(); err != nil {
+		return nil, err
+	}
+
+	c.push()
+	c.pull()
+	c.contentDiscovery()
+	c.contentManagement()
+	return c.report, nil
+}
+
+// checker runs the checks, carrying the content pushed by earlier checks to
+// later ones.
+type checker struct {
+	ctx    context.Context
+	base   *url.URL
+	opts   Options
+	client *http.Client
+	report *Report
+
+	workflow string
+	tag      string
+
+	config, layer  v1.Descriptor
+	blobs          map[digest.Digest][]byte
+	mounted        map[digest.Digest]bool
+	manifest       []byte
+	manifestDigest digest.Digest
+	tags           []string
+}
+
+// credentials authenticates with the registry with static credentials.
+type credentials struct {
+	username, password string
+}
+
+func (c credentials) Basic(*url.URL) (string, string) {
+	return c.username, c.password
+}
+
+func (c credentials) RefreshToken(*url.URL, string) string {
+	return ""
+}
+
+func (c credentials) SetRefreshToken(*url.URL, string, string) {
+}
+
+// authorize sets up the client, authenticating with the challenges of the
+// registry, if any.
+func (c *checker) authorize() error {
+	challenges := challenge.NewSimpleManager()
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, c.url("/v2/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: c.opts.Transport}).Do(req)
+	if err != nil {
+		return fmt.Errorf("error connecting to the registry: %v", err)
+	}
+	resp.Body.Close()
+	if err := challenges.AddResponse(resp); err != nil {
+		return err
+	}
+
+	creds := credentials{username: c.opts.Username, password: c.opts.Password}
+	actions := []string{"pull", "push", "delete"}
+	c.client = &http.Client{
+		Transport: transport.NewTransport(c.opts.Transport, auth.NewAuthorizer(challenges,
+			auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+				Transport:   c.opts.Transport,
+				Credentials: creds,
+				Scopes: []auth.Scope{
+					auth.RepositoryScope{Repository: c.opts.Namespace, Actions: actions},
+					auth.RepositoryScope{Repository: c.opts.CrossmountNamespace, Actions: actions},
+				},
+			}),
+			auth.NewBasicHandler(creds))),
+		// redirects to blob storage are followed, but uploads are not
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	return nil
+}
+
+// check runs a check of the current workflow and records its outcome.
+func (c *checker) check(name string, fn func() error) bool {
+	result := Check{Workflow: c.workflow, Name: name, Status: StatusPass}
+	if err := fn(); err != nil {
+		var skip errSkip
+		if errors.As(err, &skip) {
+			result.Status = StatusSkip
+		} else {
+			result.Status = StatusFail
+		}
+		result.Detail = err.Error()
+	}
+	c.report.Checks = append(c.report.Checks, result)
+	return result.Status == StatusPass
+}
+
+func (c *checker) url(path string) string {
+	return c.base.String() + path
+}
+
+// location resolves the Location header of a response against the registry
+// url.
+func (c *checker) location(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("missing Location header")
+	}
+	u, err := c.base.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid Location header %q: %v", location, err)
+	}
+	return u.String(), nil
+}
+
+// do sends a request and reads the body of the response.
+func (c *checker) do(method, u string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(c.ctx, method, u, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	p, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, p, nil
+}
+
+// expect returns an error if the status of the response is not one of the
+// expected ones.
+func expect(resp *http.Response, p []byte, expected ...int) error {
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	err := fmt.Errorf("unexpected status %d, expected %v", resp.StatusCode, expected)
+	// error responses carry the error codes of the registry
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		detail := strings.TrimSpace(string(p))
+		if len(detail) > 200 {
+			detail = detail[:200] + "..."
+		}
+		err = fmt.Errorf("%v: %s", err, detail)
+	}
+	return err
+}
+
+func (c *checker) blobURL(name string, dgst digest.Digest) string {
+	return c.url("/v2/" + name + "/blobs/" + dgst.String())
+}
+
+func (c *checker) manifestURL(name, reference string) string {
+	return c.url("/v2/" + name + "/manifests/" + reference)
+}
+
+func (c *checker) uploadURL(name string) string {
+	return c.url("/v2/" + name + "/blobs/uploads/")
+}
+
+// newBlob generates random content with a descriptor of the media type.
+func (c *checker) newBlob(mediaType string, size int) v1.Descriptor {
+	p := make([]byte, size)
+	if _, err := rand.Read(p); err != nil {
+		panic(err)
+	}
+	dgst := digest.FromBytes(p)
+	c.blobs[dgst] = p
+	return v1.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(size)}
+}
+
+// startUpload starts an upload, returning its location.
+func (c *checker) startUpload(name string) (string, error) {
+	resp, p, err := c.do(http.MethodPost, c.uploadURL(name), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := expect(resp, p, http.StatusAccepted); err != nil {
+		return "", err
+	}
+	return c.location(resp)
+}
+
+// putUpload completes an upload, with the remaining content if any.
+func (c *checker) putUpload(location string, dgst digest.Digest, p []byte) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("digest", dgst.String())
+	u.RawQuery = q.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, body, err := c.do(http.MethodPut, u.String(), header, p)
+	if err != nil {
+		return err
+	}
+	if err := expect(resp, body, http.StatusCreated); err != nil {
+		return err
+	}
+	if _, err := c.location(resp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// pushBlob uploads a blob monolithically with a POST and a PUT.
+func (c *checker) pushBlob(name string, desc v1.Descriptor) error {
+	location, err := c.startUpload(name)
+	if err != nil {
+		return err
+	}
+	return c.putUpload(location, desc.Digest, c.blobs[desc.Digest])
+}
+
+func (c *checker) push() {
+	c.workflow = WorkflowPush
+	// the config is unique to the run, like the other content
+	config := []byte(fmt.Sprintf(`{"architecture": "amd64", "os": "linux", "config": {"Labels": {"run": %q}}}`, randomHex(8)))
+	c.config = v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))}
+	c.blobs[c.config.Digest] = config
+	c.layer = c.newBlob(v1.MediaTypeImageLayer, 64<<10)
+
+	c.check("GET /v2/ returns 200", func() error {
+		resp, p, err := c.do(http.MethodGet, c.url("/v2/"), nil, nil)
+		if err != nil {
+			return err
+		}
+		return expect(resp, p, http.StatusOK)
+	})
+
+	c.check("monolithic upload with POST then PUT", func() error {
+		return c.pushBlob(c.opts.Namespace, c.config)
+	})
+
+	c.check("monolithic upload with a single POST", func() error {
+		desc := c.newBlob(v1.MediaTypeImageLayer, 1024)
+		header := http.Header{"Content-Type": {"application/octet-stream"}}
+		resp, p, err := c.do(http.MethodPost, c.uploadURL(c.opts.Namespace)+"?digest="+desc.Digest.String(), header, c.blobs[desc.Digest])
+		if err != nil {
+			return err
+		}
+		// registries may ignore the content and start an upload instead
+		if err := expect(resp, p, http.StatusCreated, http.StatusAccepted); err != nil {
+			return err
+		}
+		_, err = c.location(resp)
+		return err
+	})
+
+	c.check("chunked upload with PATCH", func() error {
+		content := c.blobs[c.layer.Digest]
+		location, err := c.startUpload(c.opts.Namespace)
+		if err != nil {
+			return err
+		}
+		for offset := 0; offset < len(content); {
+			end := offset + 16<<10
+			if end > len(content) {
+				end = len(content)
+			}
+			header := http.Header{
+				"Content-Type":  {"application/octet-stream"},
+				"Content-Range": {fmt.Sprintf("%d-%d", offset, end-1)},
+			}
+			resp, p, err := c.do(http.MethodPatch, location, header, content[offset:end])
+			if err != nil {
+				return err
+			}
+			if err := expect(resp, p, http.StatusAccepted); err != nil {
+				return err
+			}
+			if r := resp.Header.Get("Range"); r != fmt.Sprintf("0-%d", end-1) {
+				return fmt.Errorf("unexpected Range header %q after chunk ending at %d", r, end-1)
+			}
+			if location, err = c.location(resp); err != nil {
+				return err
+			}
+			offset = end
+		}
+		return c.putUpload(location, c.layer.Digest, nil)
+	})
+
+	c.check("GET upload status returns 204 with its range", func() error {
+		location, err := c.startUpload(c.opts.Namespace)
+		if err != nil {
+			return err
+		}
+		header := http.Header{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {"0-9"},
+		}
+		resp, p, err := c.do(http.MethodPatch, location, header, make([]byte, 10))
+		if err != nil {
+			return err
+		}
+		if err := expect(resp, p, http.StatusAccepted); err != nil {
+			return err
+		}
+		if location, err = c.location(resp); err != nil {
+			return err
+		}
+		resp, p, err = c.do(http.MethodGet, location, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(resp, p, http.StatusNoContent); err != nil {
+			return err
+		}
+		if r := resp.Header.Get("Range"); r != "0-9" {
+			return fmt.Errorf("unexpected Range header %q", r)
+		}
+		return nil
+	})
+
+	c.check("out of order chunk returns 416", func() error {
+		location, err := c.startUpload(c.opts.Namespace)
+		if err != nil {
+			return err
+		}
+		header := http.Header{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {"10-19"},
+		}
+		resp, p, err := c.do(http.MethodPatch, location, header, make([]byte, 10))
+		if err != nil {
+			return err
+		}
+		return expect(resp, p, http.StatusRequestedRangeNotSatisfiable)
+	})
+
+	c.check("upload with a mismatched digest returns 400", func() error {
+		location, err := c.startUpload(c.opts.Namespace)
+		if err != nil {
+			return err
+		}
+		u, err := url.Parse(location)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		q.Set("digest", digest.FromString("other").String())
+		u.RawQuery = q.Encode()
+		resp, p, err := c.do(http.MethodPut, u.String(), http.Header{"Content-Type": {"application/octet-stream"}}, []byte("content"))
+		if err != nil {
+			return err
+		}
+		return expect(resp, p, http.StatusBadRequest)
+	})
+
+	c.check("cross-repository blob mount returns 201", func() error {
+		u := c.uploadURL(c.opts.CrossmountNamespace) + "?" + url.Values{
+			"mount": {c.layer.Digest.String()},
+			"from":  {c.opts.Namespace},
+		}.Encode()
+		resp, p, err := c.do(http.MethodPost, u, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(resp, p, http.StatusCreated); err != nil {
+			return err
+		}
+		c.mounted[c.layer.Digest] = true
+		location, err := c.location(resp)
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(location, "/v2/"+c.opts.CrossmountNamespace+"/blobs/"+c.layer.Digest.String()) {
+			return fmt.Errorf("unexpected Location %q of the mounted blob", location)
+		}
+		return nil
+	})
+
+	c.check("PUT manifest by tag returns 201", func() error {
+		m := v1.Manifest{
+			MediaType: v1.MediaTypeImageManifest,
+			Config:    c.config,
+			Layers:    []v1.Descriptor{c.layer},
+		}
+		m.SchemaVersion = 2
+		p, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		resp, body, err := c.do(http.MethodPut, c.manifestURL(c.opts.Namespace, c.tag), http.Header{"Content-Type": {v1.MediaTypeImageManifest}}, p)
+		if err != nil {
+			return err
+		}
+		if err := expect(resp, body, http.StatusCreated); err != nil {
+			return err
+		}
+		if _, err := c.location(resp); err != nil {
+			return err
+		}
+		c.manifest, c.manifestDigest = p, digest.FromBytes(p)
+		if dgst := resp.Header.Get("Docker-Content-Digest"); dgst != "" && dgst != c.manifestDigest.String() {
+			return fmt.Errorf("unexpected Docker-Content-Digest %q", dgst)
+		}
+		return nil
+	})
+
+	c.check("PUT manifest with an unknown blob returns 400", func() error {
+		m := v1.Manifest{
+			MediaType: v1.MediaTypeImageManifest,
+			Config:    c.config,
+			Layers: []v1.Descriptor{{
+				MediaType: v1.MediaTypeImageLayer,
+				Digest:    digest.FromString(randomHex(8)),
+				Size:      8,
+			}},
+		}
+		m.SchemaVersion = 2
+		p, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		resp, body, err := c.do(http.MethodPut, c.manifestURL(c.opts.Namespace, "unknown-blob"), http.Header{"Content-Type": {v1.MediaTypeImageManifest}}, p)
+		if err != nil {
+			return err
+		}
+		return expect(resp, body, http.StatusBadRequest)
+	})
+}
+
+// requireManifest skips checks depending on the manifest pushed by the push
+// workflow if it failed.
+func (c *checker) requireManifest() error {
+	if c.manifest == nil {
+		return errSkip("the manifest could not be pushed")
+	}
+	return nil
+}
+
+func (c *checker) pull() {
+	c.workflow = WorkflowPull
+
+	for _, reference := range []string{c.tag, ""} {
+		reference := reference
+		kind := "tag"
+		if reference == "" {
+			kind = "digest"
+		}
+		c.check("HEAD manifest by "+kind+" returns 200", func() error {
+			if err := c.requireManifest(); err != nil {
+				return err
+			}
+			if reference == "" {
+				reference = c.manifestDigest.String()
+			}
+			resp, p, err := c.do(http.MethodHead, c.manifestURL(c.opts.Namespace, reference), http.Header{"Accept": {v1.MediaTypeImageManifest}}, nil)
+			if err != nil {
+				return err
+			}
+			if err := expect(resp, p, http.StatusOK); err != nil {
+				return err
+			}
+			if length := resp.Header.Get("Content-Length"); length != strconv.Itoa(len(c.manifest)) {
+				return fmt.Errorf("unexpected Content-Length %q", length)
+			}
+			return nil
+		})
+		c.check("GET manifest by "+kind+" returns its content", func() error {
+			if err := c.requireManifest(); err != nil {
+				return err
+			}
+			if reference == "" {
+				reference = c.manifestDigest.String()
+			}
+			resp, p, err := c.do(http.MethodGet, c.manifestURL(c.opts.Namespace, reference), http.Header{"Accept": {v1.MediaTypeImageManifest}}, nil)
+			if err != nil {
+				return err
+			}
+			if err := expect(resp, p, http.StatusOK); err != nil {
+				return err
+			}
+			if !bytes.Equal(p, c.manifest) {
+				return errors.New("the manifest differs from the pushed one")
+			}
+			if mediaType := resp.Header.Get("Content-Type"); mediaType != v1.MediaTypeImageManifest {
+				return fmt.Errorf("unexpected Content-Type %q", mediaType)
+			}
+			return nil
+		})
+	}
+
+	c.check("GET unknown manifest returns 404", func() error {
+		resp, p, err := c.do(http.MethodGet, c.manifestURL(c.opts.Namespace, "unknown-"+randomHex(4)), http.Header{"Accept": {v1.MediaTypeImageManifest}}, nil)
+		if err != nil {
+			return err
+		}
+		return expect(resp, p, http.StatusNotFound)
+	})
+
+	c.check("HEAD blob returns 200", func() error {
+		if err := c.requireManifest(); err != nil {
+			return err
+		}
+		resp, p, err := c.do(http.MethodHead, c.blobURL(c.opts.Namespace, c.layer.Digest), nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(resp, p, http.StatusOK); err != nil {
+			return err
+		}
+		if length := resp.Header.Get("Content-Length"); length != strconv.FormatInt(c.layer.Size, 10) {
+			return fmt.Errorf("unexpected Content-Length %q", length)
+		}
+		return nil
+	})
+
+	c.check("GET blob returns its content", func() error {
+		if err := c.requireManifest(); err != nil {
+			return err
+		}
+		resp, p, err := c.do(http.MethodGet, c.blobURL(c.opts.Namespace, c.layer.Digest), nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(resp, p, http.StatusOK); err != nil {
+			return err
+		}
+		if !bytes.Equal(p, c.blobs[c.layer.Digest]) {
+			return errors.New("the blob differs from the pushed one")
+		}
+		return nil
+	})
+
+	c.check("GET mounted blob returns its content", func() error {
+		if !c.mounted[c.layer.Digest] {
+			return errSkip("the blob could not be mounted")
+		}
+		resp, p, err := c.do(http.MethodGet, c.blobURL(c.opts.CrossmountNamespace, c.layer.Digest), nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := expect(resp, p, http.StatusOK); err != nil {
+			return err
+		}
+		if !bytes.Equal(p, c.blobs[c.layer.Digest]) {
+			return errors.New("the blob differs from the pushed one")
+		}
+		return nil
+	})
+
+	c.check("GET unknown blob returns 404", func() error {
+		resp, p, err := c.do(http.MethodGet, c.blobURL(c.opts.Namespace, digest.FromString(randomHex(8))), nil, nil)
+		if err != nil {
+			return err
+		}
+		return expect(resp, p, http.StatusNotFound)
+	})
+}
+
+func (c *checker) contentDiscovery() {
+	c.workflow = WorkflowContentDiscovery
+
+	pushed := c.check("PUT more tags", func() error {
+		if err := c.requireManifest(); err != nil {
+			return err
+		}
+		c.tags = []string{c.tag}
+		for i := 0; i < 3; i++ {
+			tag := fmt.Sprintf("%s-%d", c.tag, i)
+			resp, p, err := c.do(http.MethodPut, c.manifestURL(c.opts.Namespace, tag), http.Header{"Content-Type": {v1.MediaTypeImageManifest}}, c.manifest)
+			if err != nil {
+				return err
+			}
+			if err := expect(resp, p, http.StatusCreated); err != nil {
+				return err
+			}
+			c.tags = append(c.tags, tag)
+		}
+		sort.Strings(c.tags)
+		return nil
+	})
+
+	tagsURL := c.url("/v2/" + c.opts.Namespace + "/tags/list")
+	listTags := func(query url.Values) (*http.Response, []string, error) {
+		u := tagsURL
+		if len(query) > 0 {
+			u += "?" + query.Encode()
+		}
+		resp, p, err := c.do(http.MethodGet, u, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := expect(resp, p, http.StatusOK); err != nil {
+			return nil, nil, err
+		}
+		var body struct {
+			Name string   `json:"name"`
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(p, &body); err != nil {
+			return nil, nil, fmt.Errorf("error decoding tags: %v", err)
+		}
+		if body.Name != c.opts.Namespace {
+			return nil, nil, fmt.Errorf("unexpected name %q", body.Name)
+		}
+		return resp, body.Tags, nil
+	}
+	requireTags := func() error {
+		if !pushed {
+			return errSkip("the tags could not be pushed")
+		}
+		return nil
+	}
+
+	c.check("GET tags lists the tags", func() error {
+		if err := requireTags(); err != nil {
+			return err
+		}
+		_, tags, err := listTags(nil)
+		if err != nil {
+			return err
+		}
+		if strings.Join(tags, ",") != strings.Join(c.tags, ",") {
+			return fmt.Errorf("unexpected tags %v, expected %v", tags, c.tags)
+		}
+		return nil
+	})
+
+	c.check("GET tags with n returns a page with a Link header", func() error {
+		if err := requireTags(); err != nil {
+			return err
+		}
+		resp, tags, err := listTags(url.Values{"n": {"2"}})
+		if err != nil {
+			return err
+		}
+		if strings.Join(tags, ",") != strings.Join(c.tags[:2], ",") {
+			return fmt.Errorf("unexpected tags %v, expected %v", tags, c.tags[:2])
+		}
+		if link := resp.Header.Get("Link"); !strings.Contains(link, `rel="next"`) {
+			return fmt.Errorf("unexpected Link header %q", link)
+		}
+		return nil
+	})
+
+	c.check("GET tags with n and last returns the next page", func() error {
+		if err := requireTags(); err != nil {
+			return err
+		}
+		_, tags, err := listTags(url.Values{"n": {"2"}, "last": {c.tags[1]}})
+		if err != nil {
+			return err
+		}
+		if strings.Join(tags, ",") != strings.Join(c.tags[2:4], ",") {
+			return fmt.Errorf("unexpected tags %v, expected %v", tags, c.tags[2:4])
+		}
+		return nil
+	})
+}
+
+// errDeleteDisabled is returned by deletions if the registry does not
+// support them.
+var errDeleteDisabled = errSkip("deletion is disabled")
+
+// deleteURL deletes the resource at u, skipping the check if deletion is
+// not supported.
+func (c *checker) deleteURL(u string) error {
+	resp, p, err := c.do(http.MethodDelete, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		return errDeleteDisabled
+	}
+	return expect(resp, p, http.StatusAccepted)
+}
+
+// expectDeleted returns an error unless u has been deleted.
+func (c *checker) expectDeleted(u string) error {
+	resp, p, err := c.do(http.MethodGet, u, http.Header{"Accept": {v1.MediaTypeImageManifest}}, nil)
+	if err != nil {
+		return err
+	}
+	return expect(resp, p, http.StatusNotFound)
+}
+
+func (c *checker) contentManagement() {
+	c.workflow = WorkflowContentManagement
+
+	c.check("DELETE tag returns 202", func() error {
+		if len(c.tags) == 0 {
+			return errSkip("the tags could not be pushed")
+		}
+		u := c.manifestURL(c.opts.Namespace, c.tags[len(c.tags)-1])
+		if err := c.deleteURL(u); err != nil {
+			return err
+		}
+		return c.expectDeleted(u)
+	})
+
+	c.check("DELETE manifest returns 202", func() error {
+		if err := c.requireManifest(); err != nil {
+			return err
+		}
+		u := c.manifestURL(c.opts.Namespace, c.manifestDigest.String())
+		if err := c.deleteURL(u); err != nil {
+			return err
+		}
+		return c.expectDeleted(u)
+	})
+
+	for _, blob := range []struct {
+		name string
+		desc v1.Descriptor
+	}{
+		{name: "config", desc: c.config},
+		{name: "layer", desc: c.layer},
+	} {
+		blob := blob
+		c.check("DELETE "+blob.name+" blob returns 202", func() error {
+			if err := c.requireManifest(); err != nil {
+				return err
+			}
+			u := c.blobURL(c.opts.Namespace, blob.desc.Digest)
+			if err := c.deleteURL(u); err != nil {
+				return err
+			}
+			return c.expectDeleted(u)
+		})
+	}
+}
+
+func randomHex(n int) string {
+	p := make([]byte, n)
+	if _, err := rand.Read(p); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(p)
+}
@@ -0,0 +1,84 @@
+package conformance_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/conformance"
+	"github.com/docker/distribution/registry/handlers"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func newRegistry(t *testing.T, deleteEnabled bool) *httptest.Server {
+	t.Helper()
+
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{
+		"inmemory": map[string]interface{}{},
+		"delete":   map[string]interface{}{"enabled": deleteEnabled},
+	}
+	server := httptest.NewServer(handlers.NewApp(context.Background(), config))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRun(t *testing.T) {
+	server := newRegistry(t, true)
+
+	report, err := conformance.Run(context.Background(), server.URL, conformance.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error running checks: %v", err)
+	}
+	for _, check := range report.Checks {
+		// whether deleted content is gone is left to the storage tests
+		if check.Workflow == conformance.WorkflowContentManagement {
+			if check.Status == conformance.StatusSkip {
+				t.Errorf("unexpected skip of %s: %s", check.Name, check.Detail)
+			}
+			continue
+		}
+		if check.Status != conformance.StatusPass {
+			t.Errorf("%s %s: %s: %s", check.Status, check.Workflow, check.Name, check.Detail)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.Print(&buf); err != nil {
+		t.Fatalf("unexpected error printing report: %v", err)
+	}
+	if !strings.Contains(buf.String(), "GET /v2/ returns 200\n") || !strings.HasSuffix(buf.String(), " 0 skipped\n") {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
+
+func TestRunDeleteDisabled(t *testing.T) {
+	server := newRegistry(t, false)
+
+	report, err := conformance.Run(context.Background(), server.URL, conformance.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error running checks: %v", err)
+	}
+	for _, check := range report.Checks {
+		// tags can be deleted with deletion disabled
+		skipped := check.Workflow == conformance.WorkflowContentManagement && !strings.HasPrefix(check.Name, "DELETE tag")
+		if skipped && check.Status != conformance.StatusSkip {
+			t.Errorf("unexpected status of %s: %s: %s", check.Name, check.Status, check.Detail)
+		}
+		if !skipped && check.Status != conformance.StatusPass {
+			t.Errorf("%s %s: %s: %s", check.Status, check.Workflow, check.Name, check.Detail)
+		}
+	}
+}
+
+func TestRunUnreachable(t *testing.T) {
+	server := newRegistry(t, true)
+	server.Close()
+
+	if _, err := conformance.Run(context.Background(), server.URL, conformance.Options{}); err == nil {
+		t.Error("expected an error running checks against a stopped registry")
+	}
+}
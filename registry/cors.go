@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	gorhandlers "github.com/gorilla/handlers"
+)
+
+// defaultCORSMethods are the methods allowed in cross-origin requests if
+// none are configured.
+var defaultCORSMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// defaultCORSHeaders are the request headers allowed in cross-origin requests
+// if none are configured.
+var defaultCORSHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"Content-Range",
+	"Range",
+}
+
+// defaultCORSExposedHeaders are the response headers exposed to cross-origin
+// clients if none are configured.
+var defaultCORSExposedHeaders = []string{
+	"Docker-Content-Digest",
+	"Docker-Distribution-Api-Version",
+	"Docker-Upload-Uuid",
+	"Link",
+	"Location",
+	"Range",
+	"Www-Authenticate",
+}
+
+// configureCORS wraps the handler with a CORS handler if any allowed origins
+// are configured.
+func configureCORS(config *configuration.Configuration, handler http.Handler) (http.Handler, error) {
+	cors := config.HTTP.CORS
+	if len(cors.AllowedOrigins) == 0 {
+		return handler, nil
+	}
+
+	methods := cors.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := cors.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	exposedHeaders := cors.ExposedHeaders
+	if len(exposedHeaders) == 0 {
+		exposedHeaders = defaultCORSExposedHeaders
+	}
+
+	options := []gorhandlers.CORSOption{
+		gorhandlers.AllowedOrigins(cors.AllowedOrigins),
+		gorhandlers.AllowedMethods(methods),
+		gorhandlers.AllowedHeaders(headers),
+		gorhandlers.ExposedHeaders(exposedHeaders),
+	}
+	if cors.AllowCredentials {
+		for _, origin := range cors.AllowedOrigins {
+			if origin == "*" {
+				return nil, fmt.Errorf("credentials cannot be allowed for all origins")
+			}
+		}
+		options = append(options, gorhandlers.AllowCredentials())
+	}
+	if cors.MaxAge > 0 {
+		options = append(options, gorhandlers.MaxAge(int(cors.MaxAge/time.Second)))
+	}
+
+	return gorhandlers.CORS(options...)(handler), nil
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestConfigureCORS(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.CORS.AllowedOrigins = []string{"https://ui.example.com"}
+	config.HTTP.CORS.AllowCredentials = true
+
+	handler, err := configureCORS(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error configuring CORS: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodOptions, "/v2/foo/bar/manifests/latest", nil)
+	req.Header.Set("Origin", "https://ui.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status for preflight request: %d", rec.Code)
+	}
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "https://ui.example.com" {
+		t.Fatalf("unexpected Access-Control-Allow-Origin header: %q", origin)
+	}
+	if credentials := rec.Header().Get("Access-Control-Allow-Credentials"); credentials != "true" {
+		t.Fatalf("unexpected Access-Control-Allow-Credentials header: %q", credentials)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Fatalf("unexpected Access-Control-Allow-Origin header for disallowed origin: %q", origin)
+	}
+}
+
+func TestConfigureCORSCredentialsWithWildcard(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.CORS.AllowedOrigins = []string{"*"}
+	config.HTTP.CORS.AllowCredentials = true
+
+	if _, err := configureCORS(config, http.NotFoundHandler()); err == nil {
+		t.Fatal("expected error allowing credentials for all origins")
+	}
+}
@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/health"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// defaultDebugReadHeaderTimeout is the time allowed to read the headers of
+// requests to the debug server.
+const defaultDebugReadHeaderTimeout = 10 * time.Second
+
+// debugHandler serves the health of the registry at /debug/health and its
+// Prometheus metrics if enabled, along with the handlers registered on the
+// default mux, such as pprof and expvar.
+func debugHandler(config *configuration.Configuration, healthRegistry *health.Registry, gatherer prometheus.Gatherer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/health", healthRegistry.StatusHandler)
+	mux.HandleFunc("/debug/health/history", healthRegistry.HistoryHandler)
+	if config.HTTP.Debug.Prometheus.Enabled {
+		path := config.HTTP.Debug.Prometheus.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		logrus.Info("providing prometheus metrics on ", path)
+		mux.Handle(path, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	}
+	mux.Handle("/", http.DefaultServeMux)
+	return mux
+}
+
+// newDebugServer creates the debug server serving the handler, protected
+// with the configured authentication and rate limit.
+func newDebugServer(config *configuration.Configuration, handler http.Handler) (*http.Server, error) {
+	debug := config.HTTP.Debug
+
+	basicAuth := debug.Auth.Username != "" || debug.Auth.Password != ""
+	if err := checkDebugAddr(debug.Addr, basicAuth || len(debug.TLS.ClientCAs) != 0); err != nil {
+		return nil, err
+	}
+
+	// The rate limit applies to authenticated requests only, so that
+	// unauthenticated clients cannot exhaust it.
+	if debug.RateLimit.Rate > 0 {
+		handler = debugRateLimit(debug.RateLimit, handler)
+	}
+	if basicAuth {
+		handler = debugBasicAuth(debug.Auth, handler)
+	}
+
+	server := &http.Server{
+		Addr:              debug.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: defaultDebugReadHeaderTimeout,
+	}
+
+	if debug.TLS.Certificate != "" {
+		server.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+		if len(debug.TLS.ClientCAs) != 0 {
+			pool := x509.NewCertPool()
+			for _, ca := range debug.TLS.ClientCAs {
+				caPem, err := os.ReadFile(ca)
+				if err != nil {
+					return nil, err
+				}
+				if ok := pool.AppendCertsFromPEM(caPem); !ok {
+					return nil, fmt.Errorf("could not add CA to pool")
+				}
+			}
+			server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			server.TLSConfig.ClientCAs = pool
+		}
+	} else if len(debug.TLS.ClientCAs) != 0 {
+		return nil, fmt.Errorf("debug server client CAs require a TLS certificate")
+	}
+
+	return server, nil
+}
+
+// checkDebugAddr validates the bind address of the debug server, and warns if
+// profiling data is exposed beyond the loopback interface without
+// authentication.
+func checkDebugAddr(addr string, authenticated bool) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid debug server address %q: %v", addr, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid debug server port %q", port)
+	}
+
+	if authenticated {
+		return nil
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	logrus.Warnf("debug server on %s is reachable beyond the loopback interface without authentication; "+
+		"configure http.debug.auth or http.debug.tls.clientcas, or bind it to a loopback address", addr)
+	return nil
+}
+
+// debugBasicAuth requires the configured credentials from clients.
+func debugBasicAuth(config configuration.DebugAuth, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(config.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(config.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="debug"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// debugRateLimit rejects requests exceeding the configured rate.
+func debugRateLimit(config configuration.DebugRateLimit, handler http.Handler) http.Handler {
+	burst := config.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	limiter := rate.NewLimiter(rate.Limit(config.Rate), burst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.Allow() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
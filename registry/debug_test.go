@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestDebugServerAuth(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Debug.Addr = "127.0.0.1:5001"
+	config.HTTP.Debug.Auth.Username = "admin"
+	config.HTTP.Debug.Auth.Password = "secret"
+
+	server, err := newDebugServer(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error creating debug server: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status without credentials: %d", rec.Code)
+	}
+
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status with credentials: %d", rec.Code)
+	}
+}
+
+func TestDebugServerRateLimit(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Debug.Addr = "localhost:5001"
+	config.HTTP.Debug.RateLimit.Rate = 0.001
+	config.HTTP.Debug.RateLimit.Burst = 2
+
+	server, err := newDebugServer(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error creating debug server: %v", err)
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/health", nil))
+		if rec.Code != expected {
+			t.Fatalf("unexpected status for request %d: %d != %d", i, rec.Code, expected)
+		}
+	}
+}
+
+func TestDebugServerRateLimitAuthenticated(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Debug.Addr = "localhost:5001"
+	config.HTTP.Debug.Auth.Password = "secret"
+	config.HTTP.Debug.RateLimit.Rate = 0.001
+	config.HTTP.Debug.RateLimit.Burst = 1
+
+	server, err := newDebugServer(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error creating debug server: %v", err)
+	}
+
+	// unauthenticated requests do not consume the rate limit
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/health", nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("unexpected status without credentials: %d", rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/health", nil)
+	req.SetBasicAuth("", "secret")
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, req)
+		if rec.Code != expected {
+			t.Fatalf("unexpected status for request %d: %d != %d", i, rec.Code, expected)
+		}
+	}
+}
+
+func TestDebugServerInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		debug func(config *configuration.Configuration)
+	}{
+		{
+			name: "invalid address",
+			debug: func(config *configuration.Configuration) {
+				config.HTTP.Debug.Addr = "localhost"
+			},
+		},
+		{
+			name: "client CAs without certificate",
+			debug: func(config *configuration.Configuration) {
+				config.HTTP.Debug.Addr = ":5001"
+				config.HTTP.Debug.TLS.ClientCAs = []string{"/path/to/ca.pem"}
+			},
+		},
+	} {
+		config := &configuration.Configuration{}
+		tc.debug(config)
+		if _, err := newDebugServer(config, http.NotFoundHandler()); err == nil {
+			t.Errorf("%s: expected error creating debug server", tc.name)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/registry/listener"
+)
+
+// trustForwardedHeaders wraps the handler to only honor the X-Forwarded-For
+// and X-Real-Ip headers of requests sent by trusted proxies. Headers from
+// other peers are removed, as they may be spoofed by clients. For trusted
+// peers, X-Forwarded-For is replaced by the first address which is not a
+// trusted proxy, walking the list of hops from the closest one.
+func trustForwardedHeaders(trusted []*net.IPNet, handler http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(peer); err == nil {
+			peer = host
+		}
+
+		if !listener.ContainsIP(trusted, net.ParseIP(peer)) {
+			r.Header.Del("X-Forwarded-For")
+			r.Header.Del("X-Real-Ip")
+		} else if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) != 0 {
+			hops := strings.Split(strings.Join(forwardedFor, ","), ",")
+			client := peer
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				ip := net.ParseIP(hop)
+				if ip == nil {
+					break
+				}
+				client = hop
+				if !listener.ContainsIP(trusted, ip) {
+					break
+				}
+			}
+			r.Header.Set("X-Forwarded-For", client)
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
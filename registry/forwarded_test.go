@@ -0,0 +1,50 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/listener"
+)
+
+func TestTrustForwardedHeaders(t *testing.T) {
+	trusted, err := listener.ParseNetworks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("unexpected error parsing networks: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		realIP       string
+		expected     string
+	}{
+		{name: "untrusted peer", remoteAddr: "192.0.2.1:1234", forwardedFor: "203.0.113.1", realIP: "203.0.113.2", expected: "192.0.2.1"},
+		{name: "trusted peer", remoteAddr: "10.0.0.1:1234", forwardedFor: "203.0.113.1", expected: "203.0.113.1"},
+		{name: "spoofed hops", remoteAddr: "10.0.0.1:1234", forwardedFor: "198.51.100.1, 203.0.113.1, 10.0.0.2", expected: "203.0.113.1"},
+		{name: "only trusted hops", remoteAddr: "10.0.0.1:1234", forwardedFor: "10.0.0.3, 10.0.0.2", expected: "10.0.0.3"},
+		{name: "trusted real ip", remoteAddr: "10.0.0.1:1234", realIP: "203.0.113.2", expected: "203.0.113.2"},
+	} {
+		var actual string
+		handler := trustForwardedHeaders(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actual = dcontext.RemoteIP(r)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-Ip", tc.realIP)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if actual != tc.expected {
+			t.Errorf("%s: expected remote IP %s, got %s", tc.name, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+)
+
+type accessLogEntryKey struct{}
+
+// AccessLogEntry collects details of a request which are only known to the
+// application, such as the authenticated user and the repository, for access
+// loggers wrapping the application.
+type AccessLogEntry struct {
+	// RequestID is the identifier of the request in the application logs.
+	RequestID string
+	// User is the name of the authenticated user, if any.
+	User string
+	// Repository is the name of the repository the request operates on, if
+	// any.
+	Repository string
+	// Action is "pull", "push" or "delete" for repository requests.
+	Action string
+}
+
+// WithAccessLogEntry returns a context which the application populates with
+// the details of the request when serving it.
+func WithAccessLogEntry(ctx context.Context, entry *AccessLogEntry) context.Context {
+	return context.WithValue(ctx, accessLogEntryKey{}, entry)
+}
+
+// getAccessLogEntry returns the access log entry of the request, or nil if
+// the request is not access logged by a wrapping handler.
+func getAccessLogEntry(ctx context.Context) *AccessLogEntry {
+	entry, _ := ctx.Value(accessLogEntryKey{}).(*AccessLogEntry)
+	return entry
+}
@@ -0,0 +1,618 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/replication"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/cache"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/gorilla/mux"
+)
+
+const errGroupAdmin = "registry.api.admin"
+
+var (
+	// errCodeAdminInvalid is returned when the body of an admin request
+	// cannot be decoded.
+	errCodeAdminInvalid = errcode.Register(errGroupAdmin, errcode.ErrorDescriptor{
+		Value:          "ADMIN_REQUEST_INVALID",
+		Message:        "invalid admin request",
+		Description:    `Returned when the body of an admin API request is malformed.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// errCodeAdminNotFound is returned when the object of an admin action
+	// does not exist.
+	errCodeAdminNotFound = errcode.Register(errGroupAdmin, errcode.ErrorDescriptor{
+		Value:          "ADMIN_NOT_FOUND",
+		Message:        "the object of the admin action does not exist",
+		Description:    `Returned when the object of an admin API action, such as a notification endpoint, is not configured.`,
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// errCodeAdminConflict is returned when an admin action cannot be
+	// performed in the current state of the registry.
+	errCodeAdminConflict = errcode.Register(errGroupAdmin, errcode.ErrorDescriptor{
+		Value:   "ADMIN_CONFLICT",
+		Message: "the action conflicts with the state of the registry",
+		Description: `Returned when an admin action cannot be performed in
+		the current state of the registry, such as triggering a garbage
+		collection while the registry accepts writes.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+)
+
+// adminFunc performs an admin action, returning the value to encode as the
+// JSON response body.
+type adminFunc func(r *http.Request) (interface{}, error)
+
+// AdminHandler returns the handler serving the admin API under /admin/.
+// Authentication is left to the caller. Every action is logged for auditing.
+func (app *App) AdminHandler() http.Handler {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	v1 := router.PathPrefix("/admin/v1").Subrouter()
+	v1.Methods(http.MethodGet).Path("/readonly").Handler(app.adminHandler("readonly.get", app.getReadOnly))
+	v1.Methods(http.MethodPut).Path("/readonly").Handler(app.adminHandler("readonly.put", app.putReadOnly))
+	v1.Methods(http.MethodGet).Path("/maintenance").Handler(app.adminHandler("maintenance.get", app.getMaintenance))
+	v1.Methods(http.MethodPut).Path("/maintenance").Handler(app.adminHandler("maintenance.put", app.putMaintenance))
+	v1.Methods(http.MethodPost).Path("/cache/purge").Handler(app.adminHandler("cache.purge", app.purgeCache))
+	v1.Methods(http.MethodGet).Path("/gc").Handler(app.adminHandler("gc.get", app.getGC))
+	v1.Methods(http.MethodPost).Path("/gc").Handler(app.adminHandler("gc.post", app.postGC))
+	v1.Methods(http.MethodGet).Path("/uploads").Handler(app.adminHandler("uploads.list", app.listUploads))
+	v1.Methods(http.MethodGet).Path("/uploads/{uuid}").Handler(app.adminHandler("uploads.get", app.getUpload))
+	v1.Methods(http.MethodGet).Path("/replication").Handler(app.adminHandler("replication.get", app.getReplication))
+	v1.Methods(http.MethodPost).Path("/notifications/{endpoint}/replay").Handler(app.adminHandler("notifications.replay", app.replayNotifications))
+	v1.Methods(http.MethodGet).Path("/quotas/{name:" + reference.NameRegexp.String() + "}").Handler(app.adminHandler("quotas.get", app.getQuotas))
+	v1.Methods(http.MethodGet).Path("/repositories").Handler(app.adminHandler("repositories.list", app.listRepositories))
+	// the history route is matched first, as its path is also a valid
+	// repository name
+	v1.Methods(http.MethodGet).Path("/repositories/{name:" + reference.NameRegexp.String() + "}/tags/{tag:" + reference.TagRegexp.String() + "}/history").Handler(app.adminHandler("repositories.tags.history", app.getTagHistory))
+	v1.Methods(http.MethodGet).Path("/repositories/{name:" + reference.NameRegexp.String() + "}").Handler(app.adminHandler("repositories.get", app.getRepository))
+	v1.Methods(http.MethodDelete).Path("/repositories/{name:" + reference.NameRegexp.String() + "}").Handler(app.adminHandler("repositories.delete", app.deleteRepository))
+
+	// unversioned alias of the readonly endpoint
+	router.Methods(http.MethodGet).Path("/admin/readonly").Handler(app.adminHandler("readonly.get", app.getReadOnly))
+	router.Methods(http.MethodPut).Path("/admin/readonly").Handler(app.adminHandler("readonly.put", app.putReadOnly))
+
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errcode.ServeJSON(w, errcode.ErrorCodeUnsupported)
+	})
+	router.MethodNotAllowedHandler = router.NotFoundHandler
+
+	return router
+}
+
+// adminHandler wraps fn, encoding its result or error as JSON and writing an
+// audit log entry.
+func (app *App) adminHandler(action string, fn adminFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		fields := map[interface{}]interface{}{
+			"admin.action":            action,
+			"admin.user":              user,
+			"http.request.remoteaddr": r.RemoteAddr,
+		}
+		for k, v := range mux.Vars(r) {
+			fields["vars."+k] = v
+		}
+		logger := dcontext.GetLoggerWithFields(app, fields)
+
+		result, err := fn(r)
+		if err != nil {
+			logger.Warnf("admin action failed: %v", err)
+			if _, ok := err.(errcode.Error); !ok {
+				err = errcode.ErrorCodeUnknown.WithDetail(err)
+			}
+			if err := errcode.ServeJSON(w, err); err != nil {
+				logger.Errorf("error serving error json: %v", err)
+			}
+			return
+		}
+		logger.Info("admin action")
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			logger.Errorf("error encoding admin response: %v", err)
+		}
+	})
+}
+
+// readOnlyStatus is the body of the readonly admin endpoint.
+type readOnlyStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (app *App) getReadOnly(r *http.Request) (interface{}, error) {
+	return readOnlyStatus{Enabled: app.isReadOnly()}, nil
+}
+
+func (app *App) putReadOnly(r *http.Request) (interface{}, error) {
+	var status readOnlyStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		return nil, errCodeAdminInvalid.WithDetail(err.Error())
+	}
+
+	app.SetReadOnly(status.Enabled)
+	return readOnlyStatus{Enabled: app.isReadOnly()}, nil
+}
+
+func (app *App) getMaintenance(r *http.Request) (interface{}, error) {
+	return app.maintenance.status(), nil
+}
+
+func (app *App) putMaintenance(r *http.Request) (interface{}, error) {
+	var status maintenanceStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		return nil, errCodeAdminInvalid.WithDetail(err.Error())
+	}
+
+	config := configuration.Maintenance{
+		Enabled: status.Enabled,
+		Reads:   status.Reads,
+		Message: status.Message,
+	}
+	if status.RetryAfter != "" {
+		retryAfter, err := time.ParseDuration(status.RetryAfter)
+		if err != nil {
+			return nil, errCodeAdminInvalid.WithDetail(err.Error())
+		}
+		config.RetryAfter = retryAfter
+	}
+
+	app.maintenance.set(config)
+	return app.maintenance.status(), nil
+}
+
+// purgeStatus is the body returned by the cache purge admin endpoint.
+type purgeStatus struct {
+	Purged bool `json:"purged"`
+}
+
+func (app *App) purgeCache(r *http.Request) (interface{}, error) {
+	purger, ok := app.blobDescriptorCache.(cache.Purger)
+	if !ok {
+		return nil, errcode.ErrorCodeUnsupported.WithMessage("no purgeable blob descriptor cache configured")
+	}
+
+	if err := purger.Purge(r.Context()); err != nil {
+		return nil, err
+	}
+	return purgeStatus{Purged: true}, nil
+}
+
+// gcRequest is the body of a request triggering a garbage collection.
+type gcRequest struct {
+	DryRun         bool `json:"dryRun"`
+	RemoveUntagged bool `json:"removeUntagged"`
+}
+
+// gcStatus tracks the garbage collection triggered through the admin API or
+// run in the background.
+type gcStatus struct {
+	mu sync.Mutex
+
+	Running        bool       `json:"running"`
+	DryRun         bool       `json:"dryRun"`
+	RemoveUntagged bool       `json:"removeUntagged"`
+	StartedAt      *time.Time `json:"startedAt,omitempty"`
+	FinishedAt     *time.Time `json:"finishedAt,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// begin records the start of a garbage collection, and returns false if one
+// is already running.
+func (s *gcStatus) begin(req gcRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Running {
+		return false
+	}
+	s.Running = true
+	s.DryRun = req.DryRun
+	s.RemoveUntagged = req.RemoveUntagged
+	now := time.Now()
+	s.StartedAt = &now
+	s.FinishedAt = nil
+	s.Error = ""
+	return true
+}
+
+// end records the outcome of the running garbage collection.
+func (s *gcStatus) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Running = false
+	now := time.Now()
+	s.FinishedAt = &now
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// snapshot returns a copy of the status safe to encode.
+func (s *gcStatus) snapshot() *gcStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &gcStatus{
+		Running:        s.Running,
+		DryRun:         s.DryRun,
+		RemoveUntagged: s.RemoveUntagged,
+		StartedAt:      s.StartedAt,
+		FinishedAt:     s.FinishedAt,
+		Error:          s.Error,
+	}
+}
+
+func (app *App) getGC(r *http.Request) (interface{}, error) {
+	return app.gc.snapshot(), nil
+}
+
+// postGC starts a garbage collection in the background. As collecting
+// garbage while blobs are being pushed may delete them, the registry must be
+// in read-only mode unless online garbage collection is enabled.
+func (app *App) postGC(r *http.Request) (interface{}, error) {
+	var req gcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errCodeAdminInvalid.WithDetail(err.Error())
+	}
+
+	if !app.isReadOnly() && !app.Config.GC.Enabled {
+		return nil, errCodeAdminConflict.WithMessage("the registry must be in read-only mode to collect garbage")
+	}
+
+	if !app.gc.begin(req) {
+		return nil, errCodeAdminConflict.WithMessage("a garbage collection is already running")
+	}
+
+	app.goBackground(func(ctx context.Context) {
+		err := app.collectGarbage(ctx, req, nil)
+		app.gc.end(err)
+		if err != nil {
+			dcontext.GetLogger(app).Errorf("admin garbage collection failed: %v", err)
+			return
+		}
+		dcontext.GetLogger(app).Info("admin garbage collection finished")
+	})
+
+	return app.gc.snapshot(), nil
+}
+
+// collectGarbage marks and sweeps the storage backend, then purges the blob
+// descriptor cache so it does not refer to deleted blobs. The collection is
+// online if blob references are tracked. The blobs deleted are added to the
+// report, if any. The collection is aborted when ctx is done.
+func (app *App) collectGarbage(ctx context.Context, req gcRequest, report *storage.GCReport) error {
+	// The registry used to serve requests may have a cache in front of the
+	// storage, so mark and sweep with an uncached one.
+	registry, err := storage.NewRegistry(ctx, app.driver, storage.Schema1SigningKey(app.trustKey))
+	if err != nil {
+		return err
+	}
+
+	opts := storage.GCOpts{
+		DryRun:         req.DryRun,
+		RemoveUntagged: req.RemoveUntagged,
+		Metadata:       app.metadataIndex,
+		Report:         report,
+	}
+	if app.Config.GC.Enabled {
+		opts.Online = true
+		opts.GracePeriod = app.Config.GC.GracePeriod
+		if opts.GracePeriod <= 0 {
+			opts.GracePeriod = defaultGCGracePeriod
+		}
+	}
+	if err := storage.MarkAndSweep(ctx, app.driver, registry, opts); err != nil {
+		return err
+	}
+
+	if purger, ok := app.blobDescriptorCache.(cache.Purger); ok && !req.DryRun {
+		return purger.Purge(ctx)
+	}
+	return nil
+}
+
+// uploadsStatus is the body returned by the upload listing admin endpoint.
+type uploadsStatus struct {
+	Uploads []storage.UploadSession `json:"uploads"`
+}
+
+// listUploads returns the in-progress blob uploads, optionally restricted to
+// the repository given by the repository query parameter.
+func (app *App) listUploads(r *http.Request) (interface{}, error) {
+	sessions := app.uploadSessions(r)
+
+	repository := r.URL.Query().Get("repository")
+	uploads := make([]storage.UploadSession, 0, len(sessions))
+	for _, session := range sessions {
+		if repository == "" || session.Repository == repository {
+			uploads = append(uploads, session)
+		}
+	}
+	return uploadsStatus{Uploads: uploads}, nil
+}
+
+// getUpload returns the in-progress blob upload with the given uuid.
+func (app *App) getUpload(r *http.Request) (interface{}, error) {
+	id := mux.Vars(r)["uuid"]
+	for _, session := range app.uploadSessions(r) {
+		if session.ID == id {
+			return session, nil
+		}
+	}
+	return nil, v2.ErrorCodeBlobUploadUnknown.WithDetail(id)
+}
+
+// replicationStatus is the body returned by the replication admin endpoint.
+type replicationStatus struct {
+	Destinations []replication.Status `json:"destinations"`
+}
+
+// getReplication returns the status of the replication to each peer and
+// downstream registry.
+func (app *App) getReplication(r *http.Request) (interface{}, error) {
+	destinations := make([]replication.Status, 0, len(app.replication))
+	for _, sink := range app.replication {
+		destinations = append(destinations, sink.Status())
+	}
+	return replicationStatus{Destinations: destinations}, nil
+}
+
+// notificationsReplayed is the body returned by the notification replay
+// admin endpoint.
+type notificationsReplayed struct {
+	Endpoint string `json:"endpoint"`
+	Replayed int    `json:"replayed"`
+}
+
+// replayNotifications queues the events of the dead-letter queue of a
+// notification endpoint for delivery again.
+func (app *App) replayNotifications(r *http.Request) (interface{}, error) {
+	name := mux.Vars(r)["endpoint"]
+	for _, endpoint := range app.events.endpoints {
+		if endpoint.Name() != name {
+			continue
+		}
+		replayed, err := endpoint.Replay()
+		if err == notifications.ErrNoDeadLetterQueue {
+			return nil, errCodeAdminConflict.WithDetail(err.Error())
+		}
+		if err != nil {
+			return nil, err
+		}
+		return notificationsReplayed{Endpoint: name, Replayed: replayed}, nil
+	}
+	return nil, errCodeAdminNotFound.WithDetail(name)
+}
+
+// quotaUsage is the usage of a quota applying to a repository.
+type quotaUsage struct {
+	// Scope is the pattern of a repository quota, or the namespace of a
+	// namespace quota.
+	Scope string `json:"scope"`
+	Limit int64  `json:"limit"`
+	Usage int64  `json:"usage"`
+}
+
+// repositoryQuotas is the body returned by the quota admin endpoint.
+type repositoryQuotas struct {
+	Name   string       `json:"name"`
+	Quotas []quotaUsage `json:"quotas"`
+}
+
+// getQuotas returns the quotas applying to a repository, with the number of
+// bytes stored in the scope of each.
+func (app *App) getQuotas(r *http.Request) (interface{}, error) {
+	named, err := repositoryName(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := repositoryQuotas{Name: named.Name(), Quotas: []quotaUsage{}}
+	if app.quotas == nil || !app.quotas.applies(named.Name()) {
+		return result, nil
+	}
+
+	usage, _, err := app.repositoryUsage(r.Context(), named, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range app.quotas.repositories {
+		if ok, _ := path.Match(q.name, named.Name()); ok {
+			result.Quotas = append(result.Quotas, quotaUsage{Scope: q.name, Limit: q.limit, Usage: usage})
+		}
+	}
+	for _, q := range app.quotas.namespaces {
+		if !strings.HasPrefix(named.Name(), q.name+"/") {
+			continue
+		}
+		others, err := app.namespaceUsage(r.Context(), q.name, named)
+		if err != nil {
+			return nil, err
+		}
+		result.Quotas = append(result.Quotas, quotaUsage{Scope: q.name, Limit: q.limit, Usage: usage + others})
+	}
+	return result, nil
+}
+
+// uploadSessions returns the in-progress blob uploads of all repositories.
+// Uploads which cannot be read are logged and skipped.
+func (app *App) uploadSessions(r *http.Request) []storage.UploadSession {
+	sessions, errs := storage.UploadSessions(r.Context(), app.driver)
+	for _, err := range errs {
+		dcontext.GetLogger(app).Warnf("error reading upload session: %v", err)
+	}
+	return sessions
+}
+
+// repositoriesPage is the body returned by the repository listing admin
+// endpoint.
+type repositoriesPage struct {
+	Repositories []storage.RepositoryInfo `json:"repositories"`
+	// Next is the value of the last query parameter listing the next page,
+	// if there are more repositories.
+	Next string `json:"next,omitempty"`
+}
+
+// listRepositories returns a page of at most n repositories, in lexical
+// order after the last query parameter, with their metadata.
+func (app *App) listRepositories(r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	entries := defaultReturnedEntries
+	if n := q.Get("n"); n != "" {
+		parsed, err := strconv.Atoi(n)
+		if err != nil || parsed <= 0 {
+			return nil, errCodeAdminInvalid.WithDetail(map[string]string{"n": n})
+		}
+		entries = parsed
+	}
+	if max := app.Config.Catalog.MaxEntries; max > 0 && entries > max {
+		entries = max
+	}
+
+	names := make([]string, entries)
+	n, err := app.registry.Repositories(r.Context(), names, q.Get("last"))
+	more := true
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok && err != io.EOF {
+			return nil, err
+		}
+		more = false
+	}
+
+	page := repositoriesPage{Repositories: make([]storage.RepositoryInfo, 0, n)}
+	for _, name := range names[:n] {
+		named, err := reference.WithName(name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := storage.GetRepositoryInfo(r.Context(), app.driverFor(name), named)
+		if err != nil {
+			if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+				// deleted while listing
+				continue
+			}
+			return nil, err
+		}
+		page.Repositories = append(page.Repositories, info)
+	}
+	if more && n > 0 {
+		page.Next = names[n-1]
+	}
+	return page, nil
+}
+
+// repositoryName returns the name of the repository of an admin request.
+func repositoryName(r *http.Request) (reference.Named, error) {
+	name := mux.Vars(r)["name"]
+	named, err := reference.WithName(name)
+	if err != nil {
+		return nil, v2.ErrorCodeNameInvalid.WithDetail(err)
+	}
+	return named, nil
+}
+
+func (app *App) getRepository(r *http.Request) (interface{}, error) {
+	named, err := repositoryName(r)
+	if err != nil {
+		return nil, err
+	}
+	info, err := storage.GetRepositoryInfo(r.Context(), app.driverFor(named.Name()), named)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return nil, v2.ErrorCodeNameUnknown.WithDetail(err)
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+// repositoryDeleted is the body returned by the repository deletion admin
+// endpoint.
+type repositoryDeleted struct {
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+}
+
+// deleteRepository deletes the manifests, tags and layer links of a
+// repository, and notifies the listeners of the deletion. Blobs which are no
+// longer referenced are left to garbage collection.
+func (app *App) deleteRepository(r *http.Request) (interface{}, error) {
+	named, err := repositoryName(r)
+	if err != nil {
+		return nil, err
+	}
+	if app.repoRemover == nil {
+		return nil, errcode.ErrorCodeUnsupported.WithMessage("the registry cannot delete repositories")
+	}
+
+	_, remover := notifications.Listen(nil, app.repoRemover, app.eventBridge(app.context(nil, r), r))
+	if err := remover.Remove(r.Context(), named); err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError, distribution.ErrRepositoryUnknown:
+			return nil, v2.ErrorCodeNameUnknown.WithDetail(named.Name())
+		}
+		return nil, err
+	}
+	app.catalogIndex.remove(named.Name())
+
+	// the blob descriptor cache would otherwise still report the layers of
+	// the repository
+	if purger, ok := app.blobDescriptorCache.(cache.Purger); ok {
+		if err := purger.Purge(r.Context()); err != nil {
+			dcontext.GetLogger(app).Warnf("error purging the blob descriptor cache: %v", err)
+		}
+	}
+	return repositoryDeleted{Name: named.Name(), Deleted: true}, nil
+}
+
+// tagHistory is the body returned by the tag history admin endpoint.
+type tagHistory struct {
+	Name    string                    `json:"name"`
+	Tag     string                    `json:"tag"`
+	History []storage.TagHistoryEntry `json:"history"`
+}
+
+// getTagHistory returns the manifests a tag referred to, most recently
+// tagged first.
+func (app *App) getTagHistory(r *http.Request) (interface{}, error) {
+	named, err := repositoryName(r)
+	if err != nil {
+		return nil, err
+	}
+	tag := mux.Vars(r)["tag"]
+
+	history, err := storage.TagHistory(r.Context(), app.driverFor(named.Name()), named, tag)
+	if err != nil {
+		switch err.(type) {
+		case distribution.ErrTagUnknown:
+			return nil, v2.ErrorCodeManifestUnknown.WithDetail(err)
+		case storagedriver.PathNotFoundError:
+			return nil, v2.ErrorCodeNameUnknown.WithDetail(named.Name())
+		}
+		return nil, err
+	}
+	return tagHistory{Name: named.Name(), Tag: tag, History: history}, nil
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func newAdminTestApp(cache string) *App {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	if cache != "" {
+		config.Storage["cache"] = configuration.Parameters{"blobdescriptor": cache}
+	}
+	return NewApp(context.Background(), config)
+}
+
+func serveAdmin(t *testing.T, handler http.Handler, method, path, body string, expected int) map[string]interface{} {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != expected {
+		t.Fatalf("unexpected status for %s %s: %d != %d: %s", method, path, rec.Code, expected, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("unexpected content type for %s %s: %q", method, path, ct)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("error decoding response of %s %s: %v", method, path, err)
+	}
+	return result
+}
+
+func TestAdminReadOnly(t *testing.T) {
+	app := newAdminTestApp("")
+	handler := app.AdminHandler()
+
+	result := serveAdmin(t, handler, http.MethodGet, "/admin/v1/readonly", "", http.StatusOK)
+	if result["enabled"] != false {
+		t.Fatalf("unexpected read-only status: %v", result)
+	}
+
+	serveAdmin(t, handler, http.MethodPut, "/admin/v1/readonly", `{"enabled": true}`, http.StatusOK)
+	if !app.isReadOnly() {
+		t.Fatal("expected the registry to be read-only")
+	}
+
+	result = serveAdmin(t, handler, http.MethodPut, "/admin/v1/readonly", `{"enabled":`, http.StatusBadRequest)
+	if _, ok := result["errors"]; !ok {
+		t.Fatalf("expected an error response: %v", result)
+	}
+	if !app.isReadOnly() {
+		t.Fatal("expected the registry to still be read-only")
+	}
+
+	serveAdmin(t, handler, http.MethodPut, "/admin/readonly", `{"enabled": false}`, http.StatusOK)
+	result = serveAdmin(t, handler, http.MethodGet, "/admin/readonly", "", http.StatusOK)
+	if result["enabled"] != false || app.isReadOnly() {
+		t.Fatalf("unexpected read-only status through the alias: %v", result)
+	}
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	app := newAdminTestApp("")
+	handler := app.AdminHandler()
+
+	serveAPI := func(method, path string, expected int) *httptest.ResponseRecorder {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		if rec.Code != expected {
+			t.Fatalf("unexpected status for %s %s: %d != %d: %s", method, path, rec.Code, expected, rec.Body.String())
+		}
+		return rec
+	}
+
+	result := serveAdmin(t, handler, http.MethodGet, "/admin/v1/maintenance", "", http.StatusOK)
+	if result["enabled"] != false || result["retryAfter"] != "1m0s" {
+		t.Fatalf("unexpected maintenance status: %v", result)
+	}
+
+	serveAPI(http.MethodPost, "/v2/foo/bar/blobs/uploads/", http.StatusAccepted)
+
+	serveAdmin(t, handler, http.MethodPut, "/admin/v1/maintenance", `{"enabled": true, "retryAfter": "90s", "message": "migrating storage"}`, http.StatusOK)
+
+	rec := serveAPI(http.MethodPost, "/v2/foo/bar/blobs/uploads/", http.StatusServiceUnavailable)
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "90" {
+		t.Fatalf("unexpected Retry-After: %q", retryAfter)
+	}
+	if !strings.Contains(rec.Body.String(), "migrating storage") {
+		t.Fatalf("expected the maintenance message in the response: %s", rec.Body.String())
+	}
+
+	// reads are served unless rejected as well
+	serveAPI(http.MethodGet, "/v2/", http.StatusOK)
+	serveAdmin(t, handler, http.MethodPut, "/admin/v1/maintenance", `{"enabled": true, "reads": true}`, http.StatusOK)
+	serveAPI(http.MethodGet, "/v2/", http.StatusServiceUnavailable)
+
+	serveAdmin(t, handler, http.MethodPut, "/admin/v1/maintenance", `{"enabled": true, "retryAfter": "soon"}`, http.StatusBadRequest)
+
+	serveAdmin(t, handler, http.MethodPut, "/admin/v1/maintenance", `{"enabled": false}`, http.StatusOK)
+	serveAPI(http.MethodGet, "/v2/", http.StatusOK)
+}
+
+func TestAdminCachePurge(t *testing.T) {
+	serveAdmin(t, newAdminTestApp("").AdminHandler(), http.MethodPost, "/admin/v1/cache/purge", "", http.StatusMethodNotAllowed)
+
+	result := serveAdmin(t, newAdminTestApp("inmemory").AdminHandler(), http.MethodPost, "/admin/v1/cache/purge", "", http.StatusOK)
+	if result["purged"] != true {
+		t.Fatalf("unexpected purge result: %v", result)
+	}
+}
+
+func TestAdminGC(t *testing.T) {
+	app := newAdminTestApp("inmemory")
+	handler := app.AdminHandler()
+
+	// garbage collection requires at least one repository
+	named, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := app.registry.Repository(app, named)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	if _, err := repo.Blobs(app).Put(app, "application/octet-stream", []byte("unreferenced")); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	serveAdmin(t, handler, http.MethodPost, "/admin/v1/gc", "", http.StatusConflict)
+
+	app.SetReadOnly(true)
+	result := serveAdmin(t, handler, http.MethodPost, "/admin/v1/gc", `{"removeUntagged": true}`, http.StatusOK)
+	if result["running"] != true || result["removeUntagged"] != true {
+		t.Fatalf("unexpected gc status: %v", result)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		result = serveAdmin(t, handler, http.MethodGet, "/admin/v1/gc", "", http.StatusOK)
+		if result["running"] == false {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("garbage collection did not finish: %v", result)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := result["finishedAt"]; !ok {
+		t.Fatalf("expected a finish time: %v", result)
+	}
+	if errMsg, ok := result["error"]; ok {
+		t.Fatalf("unexpected gc error: %v", errMsg)
+	}
+}
+
+func TestAdminOnlineGC(t *testing.T) {
+	app := NewApp(context.Background(), &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		GC: configuration.GC{Enabled: true},
+	})
+	handler := app.AdminHandler()
+
+	named, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := app.registry.Repository(app, named)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	desc, err := repo.Blobs(app).Put(app, "application/octet-stream", []byte("pushed"))
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	// online garbage collection does not require the registry to be
+	// read-only
+	serveAdmin(t, handler, http.MethodPost, "/admin/v1/gc", "", http.StatusOK)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		result := serveAdmin(t, handler, http.MethodGet, "/admin/v1/gc", "", http.StatusOK)
+		if result["running"] == false {
+			if errMsg, ok := result["error"]; ok {
+				t.Fatalf("unexpected gc error: %v", errMsg)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("garbage collection did not finish: %v", result)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the blob was referenced within the grace period
+	if _, err := app.registry.BlobStatter().Stat(app, desc.Digest); err != nil {
+		t.Fatalf("expected the recently pushed blob to be kept: %v", err)
+	}
+}
+
+func TestAdminUnknownAction(t *testing.T) {
+	serveAdmin(t, newAdminTestApp("").AdminHandler(), http.MethodGet, "/admin/v1/unknown", "", http.StatusMethodNotAllowed)
+}
+
+func TestAdminNotificationReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Notifications.Endpoints = []configuration.Endpoint{
+		{Name: "queued", URL: server.URL, DeadLetterDir: t.TempDir()},
+		{Name: "unqueued", URL: server.URL},
+	}
+	app := NewApp(context.Background(), config)
+	defer app.CloseEvents()
+	handler := app.AdminHandler()
+
+	result := serveAdmin(t, handler, http.MethodPost, "/admin/v1/notifications/queued/replay", "", http.StatusOK)
+	if result["endpoint"] != "queued" || result["replayed"] != float64(0) {
+		t.Fatalf("unexpected replay result: %v", result)
+	}
+	serveAdmin(t, handler, http.MethodPost, "/admin/v1/notifications/unqueued/replay", "", http.StatusConflict)
+	serveAdmin(t, handler, http.MethodPost, "/admin/v1/notifications/unknown/replay", "", http.StatusNotFound)
+}
+
+func TestAdminUploads(t *testing.T) {
+	app := newAdminTestApp("")
+	handler := app.AdminHandler()
+
+	result := serveAdmin(t, handler, http.MethodGet, "/admin/v1/uploads", "", http.StatusOK)
+	if uploads, ok := result["uploads"].([]interface{}); !ok || len(uploads) != 0 {
+		t.Fatalf("unexpected uploads: %v", result)
+	}
+
+	named, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := app.registry.Repository(app, named)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	bw, err := repo.Blobs(app).Create(app)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if _, err := bw.Write([]byte("partial")); err != nil {
+		t.Fatalf("unexpected error writing upload: %v", err)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatalf("unexpected error closing upload: %v", err)
+	}
+
+	result = serveAdmin(t, handler, http.MethodGet, "/admin/v1/uploads?repository=foo/bar", "", http.StatusOK)
+	if uploads, ok := result["uploads"].([]interface{}); !ok || len(uploads) != 1 {
+		t.Fatalf("unexpected uploads: %v", result)
+	}
+	result = serveAdmin(t, handler, http.MethodGet, "/admin/v1/uploads?repository=foo/baz", "", http.StatusOK)
+	if uploads, ok := result["uploads"].([]interface{}); !ok || len(uploads) != 0 {
+		t.Fatalf("unexpected uploads: %v", result)
+	}
+
+	result = serveAdmin(t, handler, http.MethodGet, "/admin/v1/uploads/"+bw.ID(), "", http.StatusOK)
+	if result["repository"] != "foo/bar" || result["size"] != float64(len("partial")) {
+		t.Fatalf("unexpected upload: %v", result)
+	}
+
+	serveAdmin(t, handler, http.MethodGet, "/admin/v1/uploads/c7d2f0a8-0b9e-4bd7-9a1c-7c2a0b6b3a4e", "", http.StatusNotFound)
+}
+
+func TestAdminRepositories(t *testing.T) {
+	app := newAdminTestApp("inmemory")
+	handler := app.AdminHandler()
+
+	for _, name := range []string{"foo/bar", "foo/baz", "qux"} {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := app.registry.Repository(app, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repository: %v", err)
+		}
+		desc, err := repo.Blobs(app).Put(app, "application/octet-stream", []byte(name))
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+		if err := repo.Tags(app).Tag(app, "latest", desc); err != nil {
+			t.Fatalf("unexpected error tagging: %v", err)
+		}
+	}
+
+	result := serveAdmin(t, handler, http.MethodGet, "/admin/v1/repositories?n=2", "", http.StatusOK)
+	repos, _ := result["repositories"].([]interface{})
+	if len(repos) != 2 || result["next"] != "foo/baz" {
+		t.Fatalf("unexpected first page: %v", result)
+	}
+	if repo := repos[0].(map[string]interface{}); repo["name"] != "foo/bar" || repo["tags"] != float64(1) || repo["lastTagged"] == nil {
+		t.Fatalf("unexpected repository: %v", repo)
+	}
+	result = serveAdmin(t, handler, http.MethodGet, "/admin/v1/repositories?n=2&last=foo/baz", "", http.StatusOK)
+	if repos, _ := result["repositories"].([]interface{}); len(repos) != 1 || result["next"] != nil {
+		t.Fatalf("unexpected last page: %v", result)
+	}
+	serveAdmin(t, handler, http.MethodGet, "/admin/v1/repositories?n=-1", "", http.StatusBadRequest)
+
+	result = serveAdmin(t, handler, http.MethodGet, "/admin/v1/repositories/foo/bar/tags/latest/history", "", http.StatusOK)
+	if history, _ := result["history"].([]interface{}); len(history) != 1 || history[0].(map[string]interface{})["current"] != true {
+		t.Fatalf("unexpected tag history: %v", result)
+	}
+	serveAdmin(t, handler, http.MethodGet, "/admin/v1/repositories/foo/bar/tags/unknown/history", "", http.StatusNotFound)
+
+	serveAdmin(t, handler, http.MethodGet, "/admin/v1/repositories/foo/bar", "", http.StatusOK)
+	result = serveAdmin(t, handler, http.MethodDelete, "/admin/v1/repositories/foo/bar", "", http.StatusOK)
+	if result["deleted"] != true {
+		t.Fatalf("unexpected deletion result: %v", result)
+	}
+	serveAdmin(t, handler, http.MethodGet, "/admin/v1/repositories/foo/bar", "", http.StatusNotFound)
+	serveAdmin(t, handler, http.MethodDelete, "/admin/v1/repositories/foo/bar", "", http.StatusNotFound)
+
+	result = serveAdmin(t, handler, http.MethodGet, "/admin/v1/repositories", "", http.StatusOK)
+	if repos, _ := result["repositories"].([]interface{}); len(repos) != 2 {
+		t.Fatalf("expected the repository to be deleted: %v", result)
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// annotationsDispatcher constructs the annotations handler api endpoint.
+func annotationsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	annotationsHandler := &annotationsHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(annotationsHandler.GetAnnotations),
+	}
+}
+
+// annotationsHandler handles requests for manifests by annotation under a
+// repository name.
+type annotationsHandler struct {
+	*Context
+}
+
+type annotationsAPIResponse struct {
+	Name      string          `json:"name"`
+	Key       string          `json:"key"`
+	Value     string          `json:"value"`
+	Manifests []digest.Digest `json:"manifests"`
+}
+
+// GetAnnotations returns the digests of the manifests of the repository with
+// the requested annotation key and value.
+func (ah *annotationsHandler) GetAnnotations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, value := q.Get("key"), q.Get("value")
+	if key == "" || !q.Has("value") {
+		ah.Errors = append(ah.Errors, v2.ErrorCodeAnnotationQueryInvalid.WithDetail("key and value are required"))
+		return
+	}
+	if !ah.App.annotationIndexed(key) {
+		ah.Errors = append(ah.Errors, v2.ErrorCodeAnnotationQueryInvalid.WithDetail(map[string]string{"key": key}))
+		return
+	}
+
+	index := storage.NewAnnotationIndex(ah.App.driver, ah.Repository.Named())
+	revisions, err := index.Lookup(ah, key, value)
+	if err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	manifests, err := ah.Repository.Manifests(ah)
+	if err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	// the index is not updated when manifests are deleted
+	existing := make([]digest.Digest, 0, len(revisions))
+	for _, revision := range revisions {
+		exists, err := manifests.Exists(ah, revision)
+		if err != nil {
+			ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if exists {
+			existing = append(existing, revision)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(annotationsAPIResponse{
+		Name:      ah.Repository.Named().Name(),
+		Key:       key,
+		Value:     value,
+		Manifests: existing,
+	}); err != nil {
+		ah.Errors = append(ah.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// annotationIndexed returns true if the annotation key is configured to be
+// indexed.
+func (app *App) annotationIndexed(key string) bool {
+	for _, k := range app.Config.AnnotationIndex.Keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// indexAnnotations indexes the manifest by its annotations and the labels of
+// its image configuration, restricted to the configured keys.
+func (imh *manifestHandler) indexAnnotations(manifest distribution.Manifest, revision digest.Digest) error {
+	if len(imh.App.Config.AnnotationIndex.Keys) == 0 {
+		return nil
+	}
+
+	var (
+		annotations  map[string]string
+		configDigest digest.Digest
+	)
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		annotations = m.Annotations
+		if m.Config.MediaType == v1.MediaTypeImageConfig {
+			configDigest = m.Config.Digest
+		}
+	case *schema2.DeserializedManifest:
+		if m.Config.MediaType == schema2.MediaTypeImageConfig {
+			configDigest = m.Config.Digest
+		}
+	default:
+		return nil
+	}
+
+	index := storage.NewAnnotationIndex(imh.App.driver, imh.Repository.Named())
+	if err := index.Add(imh, revision, imh.App.indexedAnnotations(annotations)); err != nil {
+		return err
+	}
+
+	if configDigest == "" {
+		return nil
+	}
+
+	p, err := imh.Repository.Blobs(imh).Get(imh, configDigest)
+	if err != nil {
+		return err
+	}
+
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(p, &config); err != nil {
+		return err
+	}
+
+	return index.Add(imh, revision, imh.App.indexedAnnotations(config.Config.Labels))
+}
+
+// indexedAnnotations returns the annotations with a configured key.
+func (app *App) indexedAnnotations(annotations map[string]string) map[string]string {
+	indexed := make(map[string]string)
+	for key, value := range annotations {
+		if app.annotationIndexed(key) {
+			indexed[key] = value
+		}
+	}
+	return indexed
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/testutil"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestAnnotationsAPI(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.AnnotationIndex.Keys = []string{"org.opencontainers.image.revision", "maintainer"}
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+
+	sampleConfig := []byte(`{"architecture": "amd64", "os": "linux", "config": {"Labels": {"maintainer": "team-a"}}, "rootfs": {"type": "layers", "diff_ids": []}}`)
+	sampleConfigDigest := digest.FromBytes(sampleConfig)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, sampleConfigDigest, uploadURLBase, bytes.NewReader(sampleConfig))
+
+	rs, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	uploadURLBase, _ = startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+	m := &ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: distribution.Descriptor{
+			Digest:    sampleConfigDigest,
+			Size:      int64(len(sampleConfig)),
+			MediaType: v1.MediaTypeImageConfig,
+		},
+		Layers: []distribution.Descriptor{
+			{
+				Digest:    layerDigest,
+				Size:      6323,
+				MediaType: v1.MediaTypeImageLayer,
+			},
+		},
+		Annotations: map[string]string{
+			"org.opencontainers.image.revision": "0123abc",
+			"org.opencontainers.image.source":   "https://example.com/foo/bar.git",
+		},
+	}
+	deserialized, err := ocischema.FromStruct(*m)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	_, payload, _ := deserialized.Payload()
+	dgst := digest.FromBytes(payload)
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp := putManifest(t, "putting annotated manifest", manifestURL, v1.MediaTypeImageManifest, deserialized)
+	defer resp.Body.Close()
+	checkResponse(t, "putting annotated manifest", resp, http.StatusCreated)
+
+	for _, tc := range []struct {
+		key, value string
+		status     int
+		expected   []digest.Digest
+	}{
+		{key: "org.opencontainers.image.revision", value: "0123abc", status: http.StatusOK, expected: []digest.Digest{dgst}},
+		{key: "org.opencontainers.image.revision", value: "fedcba9", status: http.StatusOK, expected: []digest.Digest{}},
+		{key: "maintainer", value: "team-a", status: http.StatusOK, expected: []digest.Digest{dgst}},
+		// not an indexed key
+		{key: "org.opencontainers.image.source", value: "https://example.com/foo/bar.git", status: http.StatusBadRequest},
+	} {
+		annotationsURL, err := env.builder.BuildAnnotationsURL(imageName, url.Values{
+			"key":   []string{tc.key},
+			"value": []string{tc.value},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error building annotations url: %v", err)
+		}
+
+		resp, err := http.Get(annotationsURL)
+		if err != nil {
+			t.Fatalf("unexpected error querying annotations: %v", err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, "querying "+tc.key, resp, tc.status)
+		if tc.status != http.StatusOK {
+			checkBodyHasErrorCodes(t, "querying "+tc.key, resp, v2.ErrorCodeAnnotationQueryInvalid)
+			continue
+		}
+
+		var body annotationsAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("error decoding annotations response: %v", err)
+		}
+		if !reflect.DeepEqual(body.Manifests, tc.expected) {
+			t.Errorf("unexpected manifests for %s=%s: %v != %v", tc.key, tc.value, body.Manifests, tc.expected)
+		}
+	}
+}
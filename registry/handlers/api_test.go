@@ -18,6 +18,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/configuration"
@@ -32,9 +33,11 @@ import (
 	"github.com/docker/distribution/registry/storage/driver/factory"
 	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
 	"github.com/docker/distribution/testutil"
+	"github.com/docker/distribution/version"
 	"github.com/docker/libtrust"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 var headerConfig = http.Header{
@@ -78,6 +81,105 @@ func TestCheckAPI(t *testing.T) {
 	}
 }
 
+// TestVersionAPI tests the /v2/_version endpoint
+func TestVersionAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	versionURL, err := env.builder.BuildVersionURL()
+	if err != nil {
+		t.Fatalf("unexpected error building version url: %v", err)
+	}
+
+	resp, err := http.Get(versionURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "issuing version api check", resp, http.StatusOK)
+
+	var info version.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("error decoding fetched version info: %v", err)
+	}
+
+	if info.Version != version.Version {
+		t.Fatalf("unexpected version: %q != %q", info.Version, version.Version)
+	}
+
+	var found bool
+	for _, driver := range info.StorageDrivers {
+		if driver == "inmemory" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("inmemory driver not listed in %v", info.StorageDrivers)
+	}
+}
+
+// TestMirrorsAPI tests the /v2/_mirrors endpoint
+func TestMirrorsAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	mirrorsURL, err := env.builder.BuildMirrorsURL()
+	if err != nil {
+		t.Fatalf("unexpected error building mirrors url: %v", err)
+	}
+
+	resp, err := http.Get(mirrorsURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "listing mirrors without mirrors", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "listing mirrors without mirrors", resp, errcode.ErrorCodeUnsupported)
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Mirrors: []configuration.Mirror{
+			{URL: "https://cdn.example.com", Priority: 20},
+			{URL: "https://eu.mirror.example.com", Priority: 10, Region: "eu"},
+			{URL: "https://us.mirror.example.com", Priority: 10, Region: "us"},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env = newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	mirrorsURL, err = env.builder.BuildMirrorsURL()
+	if err != nil {
+		t.Fatalf("unexpected error building mirrors url: %v", err)
+	}
+	resp, err = http.Get(mirrorsURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "listing mirrors", resp, http.StatusOK)
+
+	var body mirrorsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("error decoding fetched mirrors: %v", err)
+	}
+	expected := []mirror{
+		{URL: "https://eu.mirror.example.com", Priority: 10, Region: "eu"},
+		{URL: "https://us.mirror.example.com", Priority: 10, Region: "us"},
+		{URL: "https://cdn.example.com", Priority: 20},
+	}
+	if !reflect.DeepEqual(body.Mirrors, expected) {
+		t.Fatalf("unexpected mirrors: %v != %v", body.Mirrors, expected)
+	}
+
+	if _, err := newMirrors([]configuration.Mirror{{URL: "mirror.example.com"}}); err == nil {
+		t.Fatal("expected an error with a relative mirror url")
+	}
+}
+
 // TestCatalogAPI tests the /v2/_catalog endpoint
 func TestCatalogAPI(t *testing.T) {
 	env := newTestEnv(t, false)
@@ -343,6 +445,57 @@ func TestCatalogAPI(t *testing.T) {
 	checkResponse(t, "issuing catalog api check", resp, http.StatusBadRequest)
 	checkBodyHasErrorCodes(t, "invalid number of results requested", resp, v2.ErrorCodePaginationNumberInvalid)
 
+	// -----------------------------------
+	// Case No. 5.1: request with an invalid cursor
+
+	values = url.Values{
+		"cursor": []string{"not-a-cursor"},
+	}
+
+	catalogURL, err = env.builder.BuildCatalogURL(values)
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+
+	resp, err = http.Get(catalogURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "issuing catalog api check", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "invalid pagination cursor", resp, v2.ErrorCodePaginationCursorInvalid)
+
+	// -----------------------------------
+	// Case No. 5.2: a cursor remains valid if its repository no longer exists
+
+	values = url.Values{
+		"n":      []string{strconv.Itoa(maxEntries)},
+		"cursor": []string{encodeCatalogCursor(catalogCursor{Last: "foo/bbbb0"})},
+	}
+
+	catalogURL, err = env.builder.BuildCatalogURL(values)
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+
+	resp, err = http.Get(catalogURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "issuing catalog api check", resp, http.StatusOK)
+
+	dec = json.NewDecoder(resp.Body)
+	if err = dec.Decode(&ctlg); err != nil {
+		t.Fatalf("error decoding fetched manifest: %v", err)
+	}
+
+	if !reflect.DeepEqual(ctlg.Repositories, allCatalog[2:]) {
+		t.Fatalf("repositories after cursor are incorrect (expected: %v, returned: %v)", allCatalog[2:], ctlg.Repositories)
+	}
+
 	// -----------------------------------
 	// Case No. 6: request n > maxentries but <= total catalog
 
@@ -619,8 +772,12 @@ func checkLink(t *testing.T, urlStr string, numEntries int, last string) url.Val
 		t.Fatalf("Catalog link entry size is incorrect (expected: %v, returned: %v)", urlValues.Get("n"), strconv.Itoa(numEntries))
 	}
 
-	if urlValues.Get("last") != last {
-		t.Fatal("Catalog link last entry is incorrect")
+	cursor, err := decodeCatalogCursor(urlValues.Get("cursor"))
+	if err != nil {
+		t.Fatalf("Catalog link cursor is invalid: %v", err)
+	}
+	if cursor.Last != last {
+		t.Fatal("Catalog link cursor entry is incorrect")
 	}
 
 	return urlValues
@@ -673,6 +830,51 @@ func TestURLPrefix(t *testing.T) {
 	})
 }
 
+func TestURLPrefixLocation(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		prefix      string
+		host        string
+		relative    bool
+		expected    string
+	}{
+		{"relative URLs", "/test/", "", true, "/test/v2/foo/bar/blobs/uploads/"},
+		{"prefix without trailing slash", "/test", "", true, "/test/v2/foo/bar/blobs/uploads/"},
+		{"configured host", "/test/", "https://registry.example.com", false, "https://registry.example.com/test/v2/foo/bar/blobs/uploads/"},
+		{"configured host with path", "/test/", "https://registry.example.com/test/", false, "https://registry.example.com/test/v2/foo/bar/blobs/uploads/"},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			config := configuration.Configuration{
+				Storage: configuration.Storage{
+					"inmemory": configuration.Parameters{},
+					"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+						"enabled": false,
+					}},
+				},
+			}
+			config.HTTP.Prefix = tc.prefix
+			config.HTTP.Host = tc.host
+			config.HTTP.RelativeURLs = tc.relative
+			config.HTTP.Headers = headerConfig
+
+			env := newTestEnvWithConfig(t, &config)
+			defer env.Shutdown()
+
+			imageName, _ := reference.WithName("foo/bar")
+			resp, err := http.Post(env.server.URL+"/test/v2/foo/bar/blobs/uploads/", "", nil)
+			if err != nil {
+				t.Fatalf("unexpected error starting layer push: %v", err)
+			}
+			defer resp.Body.Close()
+
+			checkResponse(t, fmt.Sprintf("starting layer push %v", imageName), resp, http.StatusAccepted)
+			if location := resp.Header.Get("Location"); !strings.HasPrefix(location, tc.expected) {
+				t.Fatalf("unexpected location: %q does not start with %q", location, tc.expected)
+			}
+		})
+	}
+}
+
 type blobArgs struct {
 	imageName   reference.Named
 	layerFile   io.ReadSeeker
@@ -1192,7 +1394,7 @@ func TestDeleteReadOnly(t *testing.T) {
 	uploadURLBase, _ := startPushLayer(t, env, imageName)
 	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, layerFile)
 
-	env.app.readOnly = true
+	env.app.SetReadOnly(true)
 
 	resp, err := httpDelete(layerURL)
 	if err != nil {
@@ -1205,7 +1407,7 @@ func TestDeleteReadOnly(t *testing.T) {
 func TestStartPushReadOnly(t *testing.T) {
 	env := newTestEnv(t, true)
 	defer env.Shutdown()
-	env.app.readOnly = true
+	env.app.SetReadOnly(true)
 
 	imageName, _ := reference.WithName("foo/bar")
 
@@ -1223,6 +1425,193 @@ func TestStartPushReadOnly(t *testing.T) {
 	checkResponse(t, "starting push in read-only mode", resp, http.StatusMethodNotAllowed)
 }
 
+func TestBlobUploadExpired(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Uploads.TTL = time.Nanosecond
+	config.Uploads.ReapInterval = time.Hour
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+
+	resp, err := doPushChunk(t, uploadURLBase, strings.NewReader("chunk"), chunkOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error pushing chunk: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "pushing chunk to expired upload", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "pushing chunk to expired upload", resp, v2.ErrorCodeBlobUploadUnknown)
+
+	resp, err = http.Get(uploadURLBase)
+	if err != nil {
+		t.Fatalf("unexpected error getting upload status: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting status of cancelled upload", resp, http.StatusNotFound)
+}
+
+func TestBlobUploadReaper(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Uploads.TTL = time.Nanosecond
+	config.Uploads.ReapInterval = 10 * time.Millisecond
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	_, uuid := startPushLayer(t, env, imageName)
+
+	uploadPath := path.Join("/docker/registry/v2/repositories", imageName.Name(), "_uploads", uuid)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		_, err := env.app.driver.Stat(env.ctx, uploadPath)
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upload session was not removed: %v", err)
+		}
+	}
+}
+
+func TestBlobUploadBLAKE3(t *testing.T) {
+	imageName, _ := reference.WithName("foo/bar")
+	content := []byte("hashed with blake3")
+	dgst := digest.BLAKE3.FromBytes(content)
+
+	for _, enabled := range []bool{false, true} {
+		config := &configuration.Configuration{
+			Storage: configuration.Storage{
+				"inmemory": configuration.Parameters{},
+			},
+		}
+		config.HTTP.Headers = headerConfig
+		config.Digests.BLAKE3 = enabled
+		env := newTestEnvWithConfig(t, config)
+		defer env.Shutdown()
+
+		baseURL, err := env.builder.BuildBaseURL()
+		if err != nil {
+			t.Fatalf("unexpected error building base url: %v", err)
+		}
+		resp, err := http.Get(baseURL)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		resp.Body.Close()
+		checkResponse(t, "checking api base", resp, http.StatusOK)
+		if advertised := strings.Contains(resp.Header.Get(digestAlgorithmsHeader), "blake3"); advertised != enabled {
+			t.Fatalf("unexpected digest algorithms advertised: %q", resp.Header.Get(digestAlgorithmsHeader))
+		}
+
+		uploadURL, err := env.builder.BuildBlobUploadURL(imageName, url.Values{"digest-algorithm": []string{"blake3"}})
+		if err != nil {
+			t.Fatalf("unexpected error building upload url: %v", err)
+		}
+		resp, err = http.Post(uploadURL, "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if !enabled {
+			checkResponse(t, "starting blake3 upload", resp, http.StatusBadRequest)
+			checkBodyHasErrorCodes(t, "starting blake3 upload", resp, v2.ErrorCodeDigestInvalid)
+			continue
+		}
+		checkResponse(t, "starting blake3 upload", resp, http.StatusAccepted)
+
+		resp, err = doPushLayer(t, env.builder, imageName, dgst, resp.Header.Get("Location"), bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("unexpected error pushing layer: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "putting blake3 layer", resp, http.StatusCreated)
+
+		resp, err = http.Get(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("unexpected error fetching layer: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "fetching blake3 layer", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Docker-Content-Digest": []string{dgst.String()},
+		})
+	}
+}
+
+func TestManifestPutBodyLimit(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.BodyLimits.Manifests = 64
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+
+	body := bytes.Repeat([]byte(" "), 128)
+
+	// a body exceeding the declared content length is rejected upfront
+	resp, err := http.DefaultClient.Do(&http.Request{
+		Method:        http.MethodPut,
+		URL:           mustParseURL(t, manifestURL),
+		Header:        http.Header{"Content-Type": []string{schema2.MediaTypeManifest}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "putting oversized manifest", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "putting oversized manifest", resp, errcode.ErrorCodeRequestTooLarge)
+
+	// a chunked body is cut off at the limit
+	resp, err = http.DefaultClient.Do(&http.Request{
+		Method:        http.MethodPut,
+		URL:           mustParseURL(t, manifestURL),
+		Header:        http.Header{"Content-Type": []string{schema2.MediaTypeManifest}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: -1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error putting manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "putting oversized chunked manifest", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "putting oversized chunked manifest", resp, errcode.ErrorCodeRequestTooLarge)
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatalf("error parsing url %q: %v", s, err)
+	}
+	return u
+}
+
 func httpDelete(url string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodDelete, url, nil)
 	if err != nil {
@@ -1331,7 +1720,7 @@ func TestManifestAPI_DeleteTag_Unknown(t *testing.T) {
 func TestManifestAPI_DeleteTag_ReadOnly(t *testing.T) {
 	env := newTestEnv(t, false)
 	defer env.Shutdown()
-	env.app.readOnly = true
+	env.app.SetReadOnly(true)
 
 	imageName, err := reference.WithName("foo/bar")
 	checkErr(t, err, "building named object")
@@ -1458,6 +1847,61 @@ func TestManifestDeleteDisabled(t *testing.T) {
 	testManifestDeleteDisabled(t, env, schema1Repo)
 }
 
+func TestManifestDeleteReferencedByIndex(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/multiplatform")
+	config := pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux"}`))
+	layer := pushBlob(t, env, imageName, v1.MediaTypeImageLayer, []byte("layer"))
+	child := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"digest":%q,"size":%d},"layers":[{"mediaType":%q,"digest":%q,"size":%d}]}`,
+		v1.MediaTypeImageManifest, config.MediaType, config.Digest, config.Size, layer.MediaType, layer.Digest, layer.Size)
+	// putManifest indents the manifest
+	payload, _ := json.MarshalIndent(json.RawMessage(child), "", "   ")
+	childDigest := digest.FromBytes(payload)
+	childRef, _ := reference.WithDigest(imageName, childDigest)
+	childURL, err := env.builder.BuildManifestURL(childRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp := putManifest(t, "putting platform manifest", childURL, v1.MediaTypeImageManifest, json.RawMessage(child))
+	defer resp.Body.Close()
+	checkResponse(t, "putting platform manifest", resp, http.StatusCreated)
+
+	index := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[{"mediaType":%q,"digest":%q,"size":%d,"platform":{"architecture":"amd64","os":"linux"}}]}`,
+		v1.MediaTypeImageIndex, v1.MediaTypeImageManifest, childDigest, len(payload))
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp = putManifest(t, "putting index", tagURL, v1.MediaTypeImageIndex, json.RawMessage(index))
+	defer resp.Body.Close()
+	checkResponse(t, "putting index", resp, http.StatusCreated)
+
+	resp, err = httpDelete(childURL)
+	if err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "deleting referenced manifest", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting referenced manifest", resp, v2.ErrorCodeManifestReferenced)
+
+	resp, err = httpDelete(tagURL)
+	if err != nil {
+		t.Fatalf("unexpected error deleting tag: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "deleting index tag", resp, http.StatusAccepted)
+
+	resp, err = httpDelete(childURL)
+	if err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "deleting unreferenced manifest", resp, http.StatusAccepted)
+}
+
 func testManifestDeleteDisabled(t *testing.T, env *testEnv, imageName reference.Named) {
 	ref, _ := reference.WithDigest(imageName, digestSha256EmptyTar)
 	manifestURL, err := env.builder.BuildManifestURL(ref)
@@ -3154,3 +3598,59 @@ func TestProxyManifestGetByTag(t *testing.T) {
 		"Docker-Content-Digest": []string{newDigest.String()},
 	})
 }
+
+func TestStrictManifestParsing(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Validation.Manifests.Strict.Enabled = true
+	config.Validation.Manifests.Strict.MaxAnnotations = 1
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/strict")
+	configDesc := pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux"}`))
+	layer := pushBlob(t, env, imageName, v1.MediaTypeImageLayer, []byte("layer"))
+	descriptors := fmt.Sprintf(`"config": {"mediaType": %q, "digest": %q, "size": %d}, "layers": [{"mediaType": %q, "digest": %q, "size": %d}]`,
+		configDesc.MediaType, configDesc.Digest, configDesc.Size, layer.MediaType, layer.Digest, layer.Size)
+
+	for _, tc := range []struct {
+		name     string
+		manifest string
+		valid    bool
+	}{
+		{
+			name:     "valid",
+			manifest: `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json", ` + descriptors + `, "annotations": {"a": "b"}}`,
+			valid:    true,
+		},
+		{
+			name:     "duplicate keys",
+			manifest: `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json", ` + descriptors + `, "layers": []}`,
+		},
+		{
+			name:     "too many annotations",
+			manifest: `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json", ` + descriptors + `, "annotations": {"a": "b", "c": "d"}}`,
+		},
+	} {
+		tagRef, _ := reference.WithTag(imageName, strings.ReplaceAll(tc.name, " ", "-"))
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		if err != nil {
+			t.Fatalf("unexpected error building manifest url: %v", err)
+		}
+		resp := putManifest(t, "putting "+tc.name+" manifest", manifestURL, v1.MediaTypeImageManifest, json.RawMessage(tc.manifest))
+		defer resp.Body.Close()
+		if tc.valid {
+			checkResponse(t, "putting "+tc.name+" manifest", resp, http.StatusCreated)
+			continue
+		}
+		checkResponse(t, "putting "+tc.name+" manifest", resp, http.StatusBadRequest)
+		checkBodyHasErrorCodes(t, "putting "+tc.name+" manifest", resp, v2.ErrorCodeManifestInvalid)
+	}
+}
@@ -3,6 +3,9 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"expvar"
 	"fmt"
 	"math"
@@ -15,6 +18,8 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	events "github.com/docker/go-events"
@@ -22,13 +27,17 @@ import (
 	"github.com/docker/libtrust"
 	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
+	graphql "github.com/graph-gophers/graphql-go"
+	promclient "github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/configuration"
 	dcontext "github.com/docker/distribution/context"
 	"github.com/docker/distribution/health"
 	"github.com/docker/distribution/health/checks"
+	"github.com/docker/distribution/manifest/strict"
 	prometheus "github.com/docker/distribution/metrics"
 	"github.com/docker/distribution/notifications"
 	"github.com/docker/distribution/reference"
@@ -38,12 +47,15 @@ import (
 	registrymiddleware "github.com/docker/distribution/registry/middleware/registry"
 	repositorymiddleware "github.com/docker/distribution/registry/middleware/repository"
 	"github.com/docker/distribution/registry/proxy"
+	"github.com/docker/distribution/registry/replication"
 	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/cache"
 	memorycache "github.com/docker/distribution/registry/storage/cache/memory"
 	rediscache "github.com/docker/distribution/registry/storage/cache/redis"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/factory"
 	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
+	"github.com/docker/distribution/registry/transparency"
 	"github.com/docker/distribution/version"
 )
 
@@ -54,6 +66,10 @@ const randomSecretSize = 32
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
+// defaultCheckTimeout is the default timeout for health checks which make
+// network requests
+const defaultCheckTimeout = 5 * time.Second
+
 // context key for storing the Cloudflare True-Client-IP header
 const cfRealIPKey string = "http_request_cf-true-client-ip"
 
@@ -72,13 +88,18 @@ type App struct {
 	accessController auth.AccessController          // main access controller for application
 
 	// httpHost is a parsed representation of the http.host parameter from
-	// the configuration. Only the Scheme and Host fields are used.
+	// the configuration. Its path defaults to the configured prefix.
 	httpHost url.URL
 
+	// prefix is the path prefix the API is served under, with leading and
+	// trailing slashes, or "/" if the API is served at the root.
+	prefix string
+
 	// events contains notification related configuration.
 	events struct {
-		sink   events.Sink
-		source notifications.SourceRecord
+		sink      *events.Broadcaster
+		source    notifications.SourceRecord
+		endpoints []*notifications.Endpoint
 	}
 
 	redis *redis.Pool
@@ -91,8 +112,89 @@ type App struct {
 	// isCache is true if this registry is configured as a pull through cache
 	isCache bool
 
-	// readOnly is true if the registry is in a read-only maintenance mode
-	readOnly bool
+	// readOnly is non-zero if the registry is in a read-only maintenance
+	// mode. It is accessed atomically as the mode can be toggled at runtime.
+	readOnly int32
+
+	// blobDescriptorCache is the configured blob descriptor cache, if any.
+	blobDescriptorCache cache.BlobDescriptorCacheProvider
+
+	// tenants stores the repositories of tenants in storages of their own,
+	// if any are configured.
+	tenants *tenantNamespace
+
+	// background is the context of the background tasks, such as garbage
+	// collection and the purge of uploads, canceled by Stop. The running
+	// tasks are tracked by backgroundTasks.
+	background      context.Context
+	stopBackground  context.CancelFunc
+	backgroundTasks sync.WaitGroup
+
+	// gc tracks garbage collections triggered through the admin API or run
+	// in the background.
+	gc gcStatus
+
+	// maintenance is the maintenance mode, which can be toggled at runtime.
+	maintenance maintenanceMode
+
+	// replication lists the sinks replicating changes to other registries.
+	replication []*replication.Sink
+
+	// namePolicy enforces the naming policy of pushed repositories, if any.
+	namePolicy *namePolicy
+
+	// bandwidthClasses throttle blob downloads, if any.
+	bandwidthClasses []*bandwidthClass
+
+	// downloadLimits cap the blob downloads of repositories, if any.
+	downloadLimits []*downloadLimit
+
+	// rateLimits limit the rate of requests, if any.
+	rateLimits *rateLimits
+
+	// quotas limit the storage used by repositories and namespaces, if any.
+	quotas *storageQuotas
+
+	// tagProtection makes the tags matching its rules immutable, if any.
+	tagProtection *tagProtection
+
+	// signaturePolicy requires the tags pushed to protected repositories to
+	// point to signed manifests, if any keys are trusted.
+	signaturePolicy *signaturePolicy
+
+	// retention removes the old tags of repositories, if any rules are
+	// configured.
+	retention *retention
+
+	// usage accounts the storage used by repositories, if enabled.
+	usage *usageAccounting
+
+	// catalogIndex indexes the repositories to serve and filter the
+	// catalog, if enabled.
+	catalogIndex *catalogIndex
+
+	// metadataIndex indexes the repositories, tags and manifests of the
+	// storage in an embedded database, if enabled.
+	metadataIndex *storage.MetadataIndex
+
+	// manifestLimits bounds pushed manifests if strict parsing is enabled.
+	manifestLimits *strict.Limits
+
+	// federation routes namespaces served by other registries, if any.
+	federation *federation
+
+	// repositoryMetrics records per-repository request metrics, if enabled
+	repositoryMetrics *repositoryMetrics
+
+	// metrics holds the HTTP metrics of the app instance, so that multiple
+	// apps can be created in the same process.
+	metrics *promclient.Registry
+
+	// mirrors are the alternative endpoints advertised to clients.
+	mirrors []mirror
+
+	// graphQLSchema executes queries of the GraphQL API, if enabled.
+	graphQLSchema *graphql.Schema
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -102,30 +204,39 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app := &App{
 		Config:  config,
 		Context: ctx,
-		router:  v2.RouterWithPrefix(config.HTTP.Prefix),
-		isCache: config.Proxy.RemoteURL != "",
+		prefix:  pathPrefix(config.HTTP.Prefix),
+		isCache: config.Proxy.RemoteURL != "" || len(config.Proxy.Remotes) > 0,
+		metrics: promclient.NewRegistry(),
 	}
+	app.router = v2.RouterWithPrefix(strings.TrimSuffix(app.prefix, "/"))
+	app.background, app.stopBackground = context.WithCancel(app)
 
 	// Register the handler dispatchers.
 	app.register(v2.RouteNameBase, func(ctx *Context, r *http.Request) http.Handler {
-		return http.HandlerFunc(apiBase)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			app.setDigestAlgorithmsHeader(w)
+			apiBase(w, r)
+		})
 	})
 	app.register(v2.RouteNameManifest, manifestDispatcher)
 	app.register(v2.RouteNameCatalog, catalogDispatcher)
+	app.register(v2.RouteNameVersion, versionDispatcher)
+	app.register(v2.RouteNameGraphQL, graphQLDispatcher)
+	app.register(v2.RouteNameMirrors, mirrorsDispatcher)
+	app.register(v2.RouteNameUsage, usageDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
+	app.register(v2.RouteNameAnnotations, annotationsDispatcher)
+	app.register(v2.RouteNameSBOMs, sbomsDispatcher)
+	app.register(v2.RouteNameVulnerabilities, vulnerabilitiesDispatcher)
+	app.register(v2.RouteNameVulnerability, vulnerabilityDispatcher)
+	app.register(v2.RouteNameFiles, filesDispatcher)
+	app.register(v2.RouteNameReferrers, referrersDispatcher)
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
 
-	// override the storage driver's UA string for registry outbound HTTP requests
-	storageParams := config.Storage.Parameters()
-	if storageParams == nil {
-		storageParams = make(configuration.Parameters)
-	}
-	storageParams["useragent"] = fmt.Sprintf("distribution/%s %s", version.Version, runtime.Version())
-
 	var err error
-	app.driver, err = factory.Create(config.Storage.Type(), storageParams)
+	app.driver, err = createStorageDriver(config.Storage)
 	if err != nil {
 		// TODO(stevvooe): Move the creation of a service into a protected
 		// method, where this is created lazily. Its status can be queried via
@@ -147,21 +258,35 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				panic("readonly config key must contain additional keys")
 			}
 			if readOnlyEnabled, ok := readOnly["enabled"]; ok {
-				app.readOnly, ok = readOnlyEnabled.(bool)
+				enabled, ok := readOnlyEnabled.(bool)
 				if !ok {
 					panic("readonly's enabled config key must have a boolean value")
 				}
+				app.SetReadOnly(enabled)
 			}
 		}
 	}
 
-	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
+	app.maintenance.set(config.Maintenance)
+
+	app.startUploadCleanup(app.driver, purgeConfig)
+
+	// blobs are scrubbed in storage itself rather than through the middleware,
+	// which may cache them
+	scrubDriver := app.driver
 
 	app.driver, err = applyStorageMiddleware(app.driver, config.Middleware["storage"])
 	if err != nil {
 		panic(err)
 	}
 
+	if config.Metrics.Repositories.Enabled {
+		app.repositoryMetrics, err = newRepositoryMetrics(config.Metrics.Repositories)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	app.configureSecret(config)
 	app.configureEvents(config)
 	app.configureRedis(config)
@@ -188,11 +313,20 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.EnableSchema1)
 	}
 
+	switch config.HTTP.ErrorDetail {
+	case "", errorDetailFull, errorDetailMinimal, errorDetailNone:
+	default:
+		panic(fmt.Sprintf("invalid http errordetail value %q", config.HTTP.ErrorDetail))
+	}
+
 	if config.HTTP.Host != "" {
 		u, err := url.Parse(config.HTTP.Host)
 		if err != nil {
 			panic(fmt.Sprintf(`could not parse http "host" parameter: %v`, err))
 		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = app.prefix
+		}
 		app.httpHost = *u
 	}
 
@@ -200,6 +334,19 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.DisableDigestResumption)
 	}
 
+	if config.GC.Enabled {
+		options = append(options, storage.TrackBlobReferences)
+	}
+
+	if config.MetadataIndex.Enabled {
+		if len(config.Tenants) > 0 {
+			// the index is built from the default storage only
+			panic("metadataindex cannot be used with tenants")
+		}
+		app.openMetadataIndex(config.MetadataIndex)
+		options = append(options, storage.UseMetadataIndex(app.metadataIndex))
+	}
+
 	// configure deletion
 	if d, ok := config.Storage["delete"]; ok {
 		e, ok := d["enabled"]
@@ -227,6 +374,14 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.EnableRedirect)
 	}
 
+	redirectPolicy, err := newRedirectPolicy(config.Policy.Repository.Redirects, !redirectDisabled)
+	if err != nil {
+		panic(err.Error())
+	}
+	if redirectPolicy != nil {
+		options = append(options, storage.RedirectPolicy(redirectPolicy))
+	}
+
 	if !config.Validation.Enabled {
 		config.Validation.Enabled = !config.Validation.Disabled
 	}
@@ -262,6 +417,71 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				options = append(options, storage.ManifestURLsDenyRegexp(re))
 			}
 		}
+		if config.Validation.Manifests.Strict.Enabled {
+			app.manifestLimits = manifestLimits(config)
+		}
+	}
+
+	namePolicy, err := newNamePolicy(config.Policy.Repository.Names)
+	if err != nil {
+		panic(err.Error())
+	}
+	app.namePolicy = namePolicy
+
+	app.bandwidthClasses, err = newBandwidthClasses(config.HTTP.BandwidthClasses)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	app.downloadLimits, err = newDownloadLimits(config.HTTP.DownloadLimits)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	app.rateLimits, err = newRateLimits(config.HTTP.RateLimit)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	app.quotas, err = newStorageQuotas(config.Quotas)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	app.tagProtection, err = newTagProtection(config.TagProtection)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	app.retention, err = newRetention(config.Retention)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	app.signaturePolicy, err = newSignaturePolicy(config.Policy.Signatures)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	app.mirrors, err = newMirrors(config.Mirrors)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if err := checkVulnerabilityPolicy(config); err != nil {
+		panic(err.Error())
+	}
+
+	if config.GraphQL.Enabled {
+		app.graphQLSchema, err = newGraphQLSchema(app)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	app.federation, err = newFederation(config.Federation, app.prefix)
+	if err != nil {
+		panic(err)
 	}
 
 	// configure storage caches
@@ -281,6 +501,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				dcontext.GetLogger(app).Warnf("blobdescriptorsize parameter is not supported with redis cache")
 			}
 			cacheProvider := rediscache.NewRedisBlobDescriptorCacheProvider(app.redis)
+			app.blobDescriptorCache = cacheProvider
 			localOptions := append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
 			app.registry, err = storage.NewRegistry(app, app.driver, localOptions...)
 			if err != nil {
@@ -299,6 +520,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 			}
 
 			cacheProvider := memorycache.NewInMemoryBlobDescriptorCacheProvider(blobDescriptorSize)
+			app.blobDescriptorCache = cacheProvider
 			localOptions := append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
 			app.registry, err = storage.NewRegistry(app, app.driver, localOptions...)
 			if err != nil {
@@ -320,6 +542,14 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	if len(config.Tenants) > 0 {
+		app.tenants, err = app.newTenantNamespace(app.registry, config.Tenants, purgeConfig, options)
+		if err != nil {
+			panic(err)
+		}
+		app.registry = app.tenants
+	}
+
 	app.registry, err = applyRegistryMiddleware(app, app.registry, app.driver, config.Middleware["registry"])
 	if err != nil {
 		panic(err)
@@ -337,13 +567,17 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 
 	// configure as a pull through cache
-	if config.Proxy.RemoteURL != "" {
+	if app.isCache {
 		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
 		if err != nil {
 			panic(err.Error())
 		}
-		app.isCache = true
-		dcontext.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
+		for _, remote := range config.Proxy.Remotes {
+			dcontext.GetLogger(app).Infof("Registry configured as a proxy cache of %s to %s", remote.Namespace, remote.RemoteURL)
+		}
+		if config.Proxy.RemoteURL != "" {
+			dcontext.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
+		}
 	}
 	var ok bool
 	app.repoRemover, ok = app.registry.(distribution.RepositoryRemover)
@@ -351,9 +585,39 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		dcontext.GetLogger(app).Warnf("Registry does not implement RepositoryRemover. Will not be able to delete repos and tags")
 	}
 
+	app.configureReplication(config)
+	app.configureTransparency(config)
+	app.startScrubber(config.Scrub, scrubDriver)
+	app.startGarbageCollector(config.GC)
+	app.startScheduler(config.Schedule)
+	app.startUsageAccounting(config.Usage)
+	app.startCatalogIndex(config.Catalog.Index)
+
 	return app
 }
 
+// goBackground runs fn in a goroutine, with a context canceled when the app
+// is stopped. Stop waits for fn to return.
+func (app *App) goBackground(fn func(ctx context.Context)) {
+	app.backgroundTasks.Add(1)
+	go func() {
+		defer app.backgroundTasks.Done()
+		fn(app.background)
+	}()
+}
+
+// Stop stops the background tasks of the app, such as garbage collection
+// and the purge of uploads, and waits for those running to return. The
+// resources they share, such as the metadata index, can be closed once it
+// returns.
+func (app *App) Stop() {
+	if app.stopBackground == nil {
+		return
+	}
+	app.stopBackground()
+	app.backgroundTasks.Wait()
+}
+
 // RegisterHealthChecks is an awful hack to defer health check registration
 // control to callers. This should only ever be called once per registry
 // process, typically in a main function. The correct way would be register
@@ -371,11 +635,6 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 	}
 
 	if app.Config.Health.StorageDriver.Enabled {
-		interval := app.Config.Health.StorageDriver.Interval
-		if interval == 0 {
-			interval = defaultCheckInterval
-		}
-
 		storageDriverCheck := func() error {
 			_, err := app.driver.Stat(app, "/") // "/" should always exist
 			if _, ok := err.(storagedriver.PathNotFoundError); ok {
@@ -387,11 +646,59 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 			return err
 		}
 
-		if app.Config.Health.StorageDriver.Threshold != 0 {
-			healthRegistry.RegisterPeriodicThresholdFunc("storagedriver_"+app.Config.Storage.Type(), interval, app.Config.Health.StorageDriver.Threshold, storageDriverCheck)
-		} else {
-			healthRegistry.RegisterPeriodicFunc("storagedriver_"+app.Config.Storage.Type(), interval, storageDriverCheck)
+		app.registerDependencyCheck(healthRegistry, "storagedriver_"+app.Config.Storage.Type(), app.Config.Health.StorageDriver, storageDriverCheck)
+	}
+
+	if app.Config.Health.Redis.Enabled {
+		if app.redis == nil {
+			panic("redis configuration required to use redis health check")
+		}
+
+		redisCheck := func() error {
+			conn := app.redis.Get()
+			defer conn.Close()
+
+			_, err := conn.Do("PING")
+			if err != nil {
+				dcontext.GetLogger(app).Errorf("redis health check: %v", err)
+			}
+			return err
 		}
+
+		app.registerDependencyCheck(healthRegistry, "redis", app.Config.Health.Redis, redisCheck)
+	}
+
+	if app.Config.Health.TokenRealm.Enabled {
+		realm, _ := app.Config.Auth.Parameters()["realm"].(string)
+		if app.Config.Auth.Type() != "token" || realm == "" {
+			panic("token auth configuration with a realm required to use token realm health check")
+		}
+
+		timeout := app.Config.Health.TokenRealm.Timeout
+		if timeout == 0 {
+			timeout = defaultCheckTimeout
+		}
+		client := http.Client{
+			Timeout: timeout,
+		}
+
+		tokenRealmCheck := func() error {
+			resp, err := client.Get(realm)
+			if err != nil {
+				dcontext.GetLogger(app).Errorf("token realm health check: %v", err)
+				return errors.New("error while checking token realm: " + realm)
+			}
+			resp.Body.Close()
+
+			// The token server is expected to reject an anonymous request
+			// without scope, so only server errors are treated as unhealthy.
+			if resp.StatusCode >= http.StatusInternalServerError {
+				return fmt.Errorf("token realm returned unexpected status: %d", resp.StatusCode)
+			}
+			return nil
+		}
+
+		app.registerDependencyCheck(healthRegistry, "tokenrealm", app.Config.Health.TokenRealm, tokenRealmCheck)
 	}
 
 	for _, fileChecker := range app.Config.Health.FileCheckers {
@@ -416,13 +723,8 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 
 		checker := checks.HTTPChecker(httpChecker.URI, statusCode, httpChecker.Timeout, httpChecker.Headers)
 
-		if httpChecker.Threshold != 0 {
-			dcontext.GetLogger(app).Infof("configuring HTTP health check uri=%s, interval=%d, threshold=%d", httpChecker.URI, interval/time.Second, httpChecker.Threshold)
-			healthRegistry.Register(httpChecker.URI, health.PeriodicThresholdChecker(checker, interval, httpChecker.Threshold))
-		} else {
-			dcontext.GetLogger(app).Infof("configuring HTTP health check uri=%s, interval=%d", httpChecker.URI, interval/time.Second)
-			healthRegistry.Register(httpChecker.URI, health.PeriodicChecker(checker, interval))
-		}
+		dcontext.GetLogger(app).Infof("configuring HTTP health check uri=%s, interval=%d, threshold=%d, recoverythreshold=%d", httpChecker.URI, interval/time.Second, httpChecker.Threshold, httpChecker.RecoveryThreshold)
+		healthRegistry.Register(httpChecker.URI, periodicChecker(checker, interval, httpChecker.Threshold, httpChecker.RecoveryThreshold))
 	}
 
 	for _, tcpChecker := range app.Config.Health.TCPCheckers {
@@ -433,27 +735,130 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 
 		checker := checks.TCPChecker(tcpChecker.Addr, tcpChecker.Timeout)
 
-		if tcpChecker.Threshold != 0 {
-			dcontext.GetLogger(app).Infof("configuring TCP health check addr=%s, interval=%d, threshold=%d", tcpChecker.Addr, interval/time.Second, tcpChecker.Threshold)
-			healthRegistry.Register(tcpChecker.Addr, health.PeriodicThresholdChecker(checker, interval, tcpChecker.Threshold))
-		} else {
-			dcontext.GetLogger(app).Infof("configuring TCP health check addr=%s, interval=%d", tcpChecker.Addr, interval/time.Second)
-			healthRegistry.Register(tcpChecker.Addr, health.PeriodicChecker(checker, interval))
+		dcontext.GetLogger(app).Infof("configuring TCP health check addr=%s, interval=%d, threshold=%d, recoverythreshold=%d", tcpChecker.Addr, interval/time.Second, tcpChecker.Threshold, tcpChecker.RecoveryThreshold)
+		healthRegistry.Register(tcpChecker.Addr, periodicChecker(checker, interval, tcpChecker.Threshold, tcpChecker.RecoveryThreshold))
+	}
+
+	for _, grpcChecker := range app.Config.Health.GRPCCheckers {
+		interval := grpcChecker.Interval
+		if interval == 0 {
+			interval = defaultCheckInterval
+		}
+
+		checker := checks.GRPCChecker(grpcChecker.Addr, grpcChecker.Service, grpcChecker.TLS, grpcChecker.Timeout)
+
+		name := grpcChecker.Addr
+		if grpcChecker.Service != "" {
+			name += "/" + grpcChecker.Service
 		}
+
+		dcontext.GetLogger(app).Infof("configuring gRPC health check addr=%s, service=%s, interval=%d, threshold=%d, recoverythreshold=%d", grpcChecker.Addr, grpcChecker.Service, interval/time.Second, grpcChecker.Threshold, grpcChecker.RecoveryThreshold)
+		healthRegistry.Register(name, periodicChecker(checker, interval, grpcChecker.Threshold, grpcChecker.RecoveryThreshold))
+	}
+
+	for _, commandChecker := range app.Config.Health.CommandCheckers {
+		interval := commandChecker.Interval
+		if interval == 0 {
+			interval = defaultCheckInterval
+		}
+
+		checker := checks.CommandChecker(commandChecker.Command, commandChecker.Timeout)
+
+		name := strings.Join(commandChecker.Command, " ")
+
+		dcontext.GetLogger(app).Infof("configuring command health check command=%q, interval=%d, threshold=%d, recoverythreshold=%d", name, interval/time.Second, commandChecker.Threshold, commandChecker.RecoveryThreshold)
+		healthRegistry.Register(name, periodicChecker(checker, interval, commandChecker.Threshold, commandChecker.RecoveryThreshold))
 	}
 }
 
+// registerDependencyCheck registers a periodic health check for a dependency
+// of the registry, honoring the interval and thresholds from the
+// configuration.
+func (app *App) registerDependencyCheck(healthRegistry *health.Registry, name string, config configuration.DependencyChecker, check health.CheckFunc) {
+	interval := config.Interval
+	if interval == 0 {
+		interval = defaultCheckInterval
+	}
+
+	dcontext.GetLogger(app).Infof("configuring %s health check interval=%d, threshold=%d, recoverythreshold=%d", name, interval/time.Second, config.Threshold, config.RecoveryThreshold)
+	healthRegistry.Register(name, periodicChecker(check, interval, config.Threshold, config.RecoveryThreshold))
+}
+
+// periodicChecker returns the periodic checker matching the configured
+// thresholds. Checks without thresholds change state on every result.
+func periodicChecker(check health.Checker, interval time.Duration, threshold, recoveryThreshold int) health.Checker {
+	switch {
+	case recoveryThreshold != 0:
+		return health.PeriodicDampedChecker(check, interval, threshold, recoveryThreshold)
+	case threshold != 0:
+		return health.PeriodicThresholdChecker(check, interval, threshold)
+	default:
+		return health.PeriodicChecker(check, interval)
+	}
+}
+
+// isReadOnly returns true if the registry is in read-only maintenance mode.
+func (app *App) isReadOnly() bool {
+	return atomic.LoadInt32(&app.readOnly) != 0
+}
+
+// SetReadOnly enables or disables the read-only maintenance mode at runtime.
+func (app *App) SetReadOnly(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&app.readOnly, v)
+}
+
+// ToggleReadOnly enables the read-only maintenance mode if it is disabled,
+// and disables it otherwise, returning whether it is now enabled.
+func (app *App) ToggleReadOnly() bool {
+	for {
+		v := atomic.LoadInt32(&app.readOnly)
+		if atomic.CompareAndSwapInt32(&app.readOnly, v, 1-v) {
+			return v == 0
+		}
+	}
+}
+
+// pathPrefix normalizes the configured http prefix, adding the leading and
+// trailing slashes if missing.
+func pathPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "/"
+	}
+	return "/" + prefix + "/"
+}
+
+// metricsNamespace returns the namespace of the HTTP metrics of the app.
+func (app *App) metricsNamespace() string {
+	if app.Config.Metrics.Namespace != "" {
+		return app.Config.Metrics.Namespace
+	}
+	return prometheus.NamespacePrefix
+}
+
+// MetricsGatherer returns the gatherer of the metrics of the app, combining
+// its HTTP metrics with the metrics shared by the process, such as those of
+// the storage drivers and notifications.
+func (app *App) MetricsGatherer() promclient.Gatherer {
+	return promclient.Gatherers{promclient.DefaultGatherer, app.metrics}
+}
+
 // register a handler with the application, by route name. The handler will be
 // passed through the application filters and context will be constructed at
 // request time.
 func (app *App) register(routeName string, dispatch dispatchFunc) {
-	handler := app.dispatcher(dispatch)
+	handler := otelhttp.WithRouteTag(routeName, app.dispatcher(dispatch))
 
-	// Chain the handler with prometheus instrumented handler
-	if app.Config.HTTP.Debug.Prometheus.Enabled {
-		namespace := metrics.NewNamespace(prometheus.NamespacePrefix, "http", nil)
+	// Chain the handler with prometheus instrumented handler, if metrics are
+	// exposed by the debug server or the main listener, or exported over OTLP
+	if app.Config.HTTP.Debug.Prometheus.Enabled || app.Config.Metrics.HTTP.Enabled || app.Config.Metrics.OTLP.Enabled {
+		namespace := metrics.NewNamespace(app.metricsNamespace(), "http", nil)
 		httpMetrics := namespace.NewDefaultHttpMetrics(strings.Replace(routeName, "-", "_", -1))
-		metrics.Register(namespace)
+		app.metrics.MustRegister(namespace)
 		handler = metrics.InstrumentHandler(httpMetrics, handler)
 	}
 
@@ -466,6 +871,96 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 	app.router.GetRoute(routeName).Handler(handler)
 }
 
+// manifestLimits returns the configured limits of strict manifest parsing,
+// using the defaults for the limits which are not configured.
+func manifestLimits(config *configuration.Configuration) *strict.Limits {
+	configured := config.Validation.Manifests.Strict
+	limits := strict.DefaultLimits
+	if configured.MaxSize > 0 {
+		limits.MaxSize = configured.MaxSize
+	}
+	if configured.MaxDepth > 0 {
+		limits.MaxDepth = configured.MaxDepth
+	}
+	if configured.MaxReferences > 0 {
+		limits.MaxReferences = configured.MaxReferences
+	}
+	if configured.MaxAnnotations > 0 {
+		limits.MaxAnnotations = configured.MaxAnnotations
+	}
+	if configured.MaxAnnotationSize > 0 {
+		limits.MaxAnnotationSize = configured.MaxAnnotationSize
+	}
+	return &limits
+}
+
+// bodyLimit returns the maximum size of request bodies accepted on the route,
+// or zero if the size is not limited. Blob uploads are never limited.
+func (app *App) bodyLimit(routeName string) int64 {
+	switch routeName {
+	case v2.RouteNameBlobUpload, v2.RouteNameBlobUploadChunk:
+		return 0
+	case v2.RouteNameManifest:
+		if app.Config.HTTP.BodyLimits.Manifests > 0 {
+			return app.Config.HTTP.BodyLimits.Manifests
+		}
+		return maxManifestBodySize
+	default:
+		return app.Config.HTTP.BodyLimits.Default
+	}
+}
+
+// configureReplication adds a sink replicating changes to each configured
+// peer and downstream registry to the event sinks. It must be called once
+// the registry is configured, since the replicated content is read from it.
+func (app *App) configureReplication(configuration *configuration.Configuration) {
+	for _, peer := range configuration.Replication.Peers {
+		sink, err := replication.NewPeerSink(app, app.registry, peer, configuration.Replication.Identities)
+		if err != nil {
+			panic(err)
+		}
+
+		dcontext.GetLogger(app).Infof("configuring replication to peer %v (%v), conflict=%s", peer.Name, peer.URL, peer.Conflict)
+		app.addReplicationSink(sink)
+	}
+
+	for _, rule := range configuration.Replication.Rules {
+		sink, err := replication.NewRuleSink(app, app.registry, rule, configuration.Replication.Identities)
+		if err != nil {
+			panic(err)
+		}
+
+		dcontext.GetLogger(app).Infof("configuring replication rule %v to %v, repositories=%v", rule.Name, rule.URL, rule.Repositories)
+		app.addReplicationSink(sink)
+	}
+}
+
+// configureTransparency adds a sink logging pushes to the configured
+// transparency log to the event sinks, if any. The proofs are stored in the
+// registry, so it must be called once the registry is configured.
+func (app *App) configureTransparency(configuration *configuration.Configuration) {
+	if configuration.Transparency.Rekor == "" {
+		return
+	}
+
+	sink, err := transparency.NewSink(app, app.registry, configuration.Transparency)
+	if err != nil {
+		panic(err)
+	}
+
+	dcontext.GetLogger(app).Infof("configuring transparency log %v, repositories=%v", configuration.Transparency.Rekor, configuration.Transparency.Repositories)
+	if err := app.events.sink.Add(sink); err != nil {
+		panic(err)
+	}
+}
+
+func (app *App) addReplicationSink(sink *replication.Sink) {
+	if err := app.events.sink.Add(sink); err != nil {
+		panic(err)
+	}
+	app.replication = append(app.replication, sink)
+}
+
 // configureEvents prepares the event sink for action.
 func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// Configure all of the endpoint sinks.
@@ -476,17 +971,41 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 			continue
 		}
 
-		dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
-		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
+		endpointConfig := notifications.EndpointConfig{
 			Timeout:           endpoint.Timeout,
 			Threshold:         endpoint.Threshold,
 			Backoff:           endpoint.Backoff,
+			MaxBackoff:        endpoint.MaxBackoff,
+			MaxRetries:        endpoint.MaxRetries,
+			QueueSize:         endpoint.QueueSize,
+			DeadLetterDir:     endpoint.DeadLetterDir,
 			Headers:           endpoint.Headers,
 			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
 			Ignore:            endpoint.Ignore,
-		})
+		}
 
-		sinks = append(sinks, endpoint)
+		var e *notifications.Endpoint
+		switch endpoint.Type {
+		case "", "http":
+			dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
+			e = notifications.NewEndpoint(endpoint.Name, endpoint.URL, endpointConfig)
+		case "kafka":
+			dcontext.GetLogger(app).Infof("configuring kafka endpoint %v (topic %v on %v), timeout=%s", endpoint.Name, endpoint.Kafka.Topic, endpoint.Kafka.Brokers, endpoint.Timeout)
+			kafkaConfig, err := kafkaConfig(endpoint.Kafka)
+			if err != nil {
+				panic(fmt.Sprintf("invalid kafka configuration of endpoint %s: %v", endpoint.Name, err))
+			}
+			endpointConfig.Kafka = kafkaConfig
+			e, err = notifications.NewKafkaEndpoint(endpoint.Name, endpointConfig)
+			if err != nil {
+				panic(fmt.Sprintf("invalid kafka configuration of endpoint %s: %v", endpoint.Name, err))
+			}
+		default:
+			panic(fmt.Sprintf("unknown type %q of endpoint %s", endpoint.Type, endpoint.Name))
+		}
+
+		sinks = append(sinks, e)
+		app.events.endpoints = append(app.events.endpoints, e)
 	}
 
 	// NOTE(stevvooe): Moving to a new queuing implementation is as easy as
@@ -513,70 +1032,187 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	}
 }
 
+// kafkaConfig returns the configuration of the Kafka topic a notification
+// endpoint publishes to.
+func kafkaConfig(config configuration.Kafka) (*notifications.KafkaConfig, error) {
+	kafkaConfig := &notifications.KafkaConfig{
+		Brokers:       config.Brokers,
+		Topic:         config.Topic,
+		SASLMechanism: config.SASL.Mechanism,
+		Username:      config.SASL.Username,
+		Password:      config.SASL.Password,
+	}
+
+	switch config.PartitionBy {
+	case "", "repository":
+		kafkaConfig.PartitionByRepository = true
+	case "none":
+	default:
+		return nil, fmt.Errorf("unknown partitionby %q", config.PartitionBy)
+	}
+
+	if !config.TLS.Enabled {
+		return kafkaConfig, nil
+	}
+
+	kafkaConfig.TLS = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.TLS.InsecureSkipVerify,
+	}
+	if config.TLS.CA != "" {
+		pem, err := os.ReadFile(config.TLS.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", config.TLS.CA)
+		}
+		kafkaConfig.TLS.RootCAs = pool
+	}
+	if config.TLS.Certificate != "" || config.TLS.Key != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLS.Certificate, config.TLS.Key)
+		if err != nil {
+			return nil, err
+		}
+		kafkaConfig.TLS.Certificates = []tls.Certificate{cert}
+	}
+	return kafkaConfig, nil
+}
+
+// CloseEvents stops the delivery of notifications, persisting the events
+// pending delivery to the dead-letter queues of the endpoints configured with
+// one.
+func (app *App) CloseEvents() error {
+	var err error
+	for _, endpoint := range app.events.endpoints {
+		if cerr := endpoint.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openMetadataIndex opens the metadata index, and builds it from the storage
+// before the registry serves requests if it never was.
+func (app *App) openMetadataIndex(config configuration.MetadataIndex) {
+	if config.Path == "" {
+		panic("metadataindex requires a path")
+	}
+
+	var err error
+	app.metadataIndex, err = storage.OpenMetadataIndex(config.Path)
+	if err != nil {
+		panic(err)
+	}
+
+	builtAt, err := app.metadataIndex.BuiltAt()
+	if err != nil {
+		panic(fmt.Sprintf("unable to read metadata index: %v", err))
+	}
+	if !builtAt.IsZero() {
+		dcontext.GetLogger(app).Infof("using the metadata index %s built at %s", config.Path, builtAt)
+		return
+	}
+
+	dcontext.GetLogger(app).Infof("building the metadata index %s", config.Path)
+	start := time.Now()
+	if err := app.metadataIndex.Rebuild(app, app.driver); err != nil {
+		panic(fmt.Sprintf("unable to build metadata index: %v", err))
+	}
+	dcontext.GetLogger(app).Infof("built the metadata index in %s", time.Since(start))
+}
+
+// CloseMetadataIndex closes the database of the metadata index, if enabled.
+func (app *App) CloseMetadataIndex() error {
+	if app.metadataIndex == nil {
+		return nil
+	}
+	return app.metadataIndex.Close()
+}
+
 type redisStartAtKey struct{}
 
+// redisEndpoint describes the redis deployment the registry connects to.
+func redisEndpoint(configuration *configuration.Configuration) string {
+	switch {
+	case len(configuration.Redis.Cluster.Addrs) > 0:
+		return "cluster " + strings.Join(configuration.Redis.Cluster.Addrs, ",")
+	case configuration.Redis.Sentinel.Master != "":
+		return "sentinel " + configuration.Redis.Sentinel.Master
+	}
+	return configuration.Redis.Addr
+}
+
 func (app *App) configureRedis(configuration *configuration.Configuration) {
-	if configuration.Redis.Addr == "" {
+	cluster, sentinel := configuration.Redis.Cluster, configuration.Redis.Sentinel
+	if configuration.Redis.Addr == "" && len(cluster.Addrs) == 0 && sentinel.Master == "" {
 		dcontext.GetLogger(app).Infof("redis not configured")
 		return
 	}
 
-	pool := &redis.Pool{
-		Dial: func() (redis.Conn, error) {
-			// TODO(stevvooe): Yet another use case for contextual timing.
-			ctx := context.WithValue(app, redisStartAtKey{}, time.Now())
+	dialOptions := []redis.DialOption{
+		redis.DialConnectTimeout(configuration.Redis.DialTimeout),
+		redis.DialReadTimeout(configuration.Redis.ReadTimeout),
+		redis.DialWriteTimeout(configuration.Redis.WriteTimeout),
+		redis.DialUseTLS(configuration.Redis.TLS.Enabled),
+	}
 
-			done := func(err error) {
-				logger := dcontext.GetLoggerWithField(ctx, "redis.connect.duration",
-					dcontext.Since(ctx, redisStartAtKey{}))
-				if err != nil {
-					logger.Errorf("redis: error connecting: %v", err)
-				} else {
-					logger.Infof("redis: connect %v", configuration.Redis.Addr)
-				}
-			}
+	dial := func(addr string) (redis.Conn, error) {
+		// TODO(stevvooe): Yet another use case for contextual timing.
+		ctx := context.WithValue(app, redisStartAtKey{}, time.Now())
 
-			conn, err := redis.Dial("tcp",
-				configuration.Redis.Addr,
-				redis.DialConnectTimeout(configuration.Redis.DialTimeout),
-				redis.DialReadTimeout(configuration.Redis.ReadTimeout),
-				redis.DialWriteTimeout(configuration.Redis.WriteTimeout),
-				redis.DialUseTLS(configuration.Redis.TLS.Enabled))
+		done := func(err error) {
+			logger := dcontext.GetLoggerWithField(ctx, "redis.connect.duration",
+				dcontext.Since(ctx, redisStartAtKey{}))
 			if err != nil {
-				dcontext.GetLogger(app).Errorf("error connecting to redis instance %s: %v",
-					configuration.Redis.Addr, err)
-				done(err)
-				return nil, err
+				logger.Errorf("redis: error connecting: %v", err)
+			} else {
+				logger.Infof("redis: connect %v", addr)
 			}
+		}
 
-			// authorize the connection
-			authArgs := make([]interface{}, 0, 2)
-			if configuration.Redis.Username != "" {
-				authArgs = append(authArgs, configuration.Redis.Username)
-			}
-			if configuration.Redis.Password != "" {
-				authArgs = append(authArgs, configuration.Redis.Password)
-			}
+		conn, err := redis.Dial("tcp", addr, dialOptions...)
+		if err != nil {
+			dcontext.GetLogger(app).Errorf("error connecting to redis instance %s: %v",
+				addr, err)
+			done(err)
+			return nil, err
+		}
 
-			if len(authArgs) > 0 {
-				if _, err = conn.Do("AUTH", authArgs...); err != nil {
-					defer conn.Close()
-					done(err)
-					return nil, err
-				}
+		// authorize the connection
+		authArgs := make([]interface{}, 0, 2)
+		if configuration.Redis.Username != "" {
+			authArgs = append(authArgs, configuration.Redis.Username)
+		}
+		if configuration.Redis.Password != "" {
+			authArgs = append(authArgs, configuration.Redis.Password)
+		}
+
+		if len(authArgs) > 0 {
+			if _, err = conn.Do("AUTH", authArgs...); err != nil {
+				defer conn.Close()
+				done(err)
+				return nil, err
 			}
+		}
 
-			// select the database to use
-			if configuration.Redis.DB != 0 {
-				if _, err = conn.Do("SELECT", configuration.Redis.DB); err != nil {
-					defer conn.Close()
-					done(err)
-					return nil, err
-				}
+		// select the database to use
+		if configuration.Redis.DB != 0 {
+			if _, err = conn.Do("SELECT", configuration.Redis.DB); err != nil {
+				defer conn.Close()
+				done(err)
+				return nil, err
 			}
+		}
 
-			done(nil)
-			return conn, nil
+		done(nil)
+		return conn, nil
+	}
+
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return dial(configuration.Redis.Addr)
 		},
 		MaxIdle:     configuration.Redis.Pool.MaxIdle,
 		MaxActive:   configuration.Redis.Pool.MaxActive,
@@ -590,6 +1226,25 @@ func (app *App) configureRedis(configuration *configuration.Configuration) {
 		Wait: false, // if a connection is not available, proceed without cache.
 	}
 
+	switch {
+	case len(cluster.Addrs) > 0 && sentinel.Master != "":
+		panic("redis cluster and sentinel cannot be configured together")
+	case len(cluster.Addrs) > 0:
+		if configuration.Redis.DB != 0 {
+			panic("redis cluster only supports database 0")
+		}
+		pool.Dial = rediscache.ClusterDialer(cluster.Addrs, dial)
+	case sentinel.Master != "":
+		if len(sentinel.Addrs) == 0 {
+			panic("redis sentinel requires the addresses of the sentinels")
+		}
+		dialSentinel := func(addr string) (redis.Conn, error) {
+			return redis.Dial("tcp", addr, append(dialOptions, redis.DialPassword(sentinel.Password))...)
+		}
+		pool.Dial = rediscache.SentinelDialer(sentinel.Master, sentinel.Addrs, dialSentinel, dial)
+		pool.TestOnBorrow = rediscache.TestMaster
+	}
+
 	app.redis = pool
 
 	// setup expvar
@@ -660,6 +1315,10 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx = dcontext.WithLogger(ctx, dcontext.GetRequestLogger(ctx))
 	r = r.WithContext(ctx)
 
+	if entry := getAccessLogEntry(ctx); entry != nil {
+		entry.RequestID = dcontext.GetStringValue(ctx, "http.request.id")
+	}
+
 	// Set a header with the Docker Distribution API Version for all responses.
 	w.Header().Add("Docker-Distribution-API-Version", "registry/2.0")
 	app.router.ServeHTTP(w, r)
@@ -683,20 +1342,73 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				w.Header().Add(headerName, value)
 			}
 		}
+		w = app.withRouteHeaders(w, r)
 		context := app.context(w, r)
 
+		var body *countingReadCloser
+		if app.repositoryMetrics != nil {
+			body = &countingReadCloser{ReadCloser: r.Body}
+			r.Body = body
+		}
+
 		defer func() {
+			app.annotateSpan(context, r)
+			recordSpanErrors(context)
+
 			// Automated error response handling here. Handlers may return their
 			// own errors if they need different behavior (such as range errors
 			// for layer upload).
 			if context.Errors.Len() > 0 {
-				_ = errcode.ServeJSON(w, context.Errors)
+				_ = errcode.ServeJSON(w, app.responseErrors(context.Errors))
 				app.logError(context, context.Errors)
 			} else if status, ok := context.Value("http.response.status").(int); ok && status >= 200 && status <= 399 {
 				dcontext.GetResponseLogger(context).Infof("response completed")
 			}
+
+			// Only requests to resolved repositories are recorded, so that
+			// arbitrary names cannot inflate the number of time series.
+			if app.repositoryMetrics != nil && context.Repository != nil {
+				status, _ := context.Value("http.response.status").(int)
+				written, _ := context.Value("http.response.written").(int64)
+				app.repositoryMetrics.record(getName(context), r.Method, status, written, body.n)
+			}
 		}()
 
+		if err, rejected := app.maintenance.reject(w, r); rejected {
+			context.Errors = append(context.Errors, err)
+			return
+		}
+
+		// Clients are limited before authorization, so that unauthorized
+		// requests are limited too, and users and repositories after it.
+		if app.rateLimits != nil && !app.limitRate(context, w, r, app.rateLimits.client, app.clientIP(r)) {
+			return
+		}
+
+		// Clients are redirected to the registry serving a federated
+		// namespace before authorization, which is left to that registry.
+		var federated *federatedNamespace
+		if app.nameRequired(r) {
+			federated = app.federation.namespace(getName(context))
+		}
+		if federated != nil && federated.mode == configuration.FederationRedirect {
+			federated.redirect(w, r)
+			return
+		}
+
+		// Reject requests which declare a body larger than the limit of the
+		// route upfront, and cap the body of all other requests.
+		if route := mux.CurrentRoute(r); route != nil {
+			if limit := app.bodyLimit(route.GetName()); limit > 0 {
+				if r.ContentLength > limit {
+					context.Errors = append(context.Errors, errcode.ErrorCodeRequestTooLarge.WithDetail(
+						fmt.Sprintf("request body of %d bytes exceeds the limit of %d bytes", r.ContentLength, limit)))
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+		}
+
 		if err := app.authorized(w, r, context); err != nil {
 			dcontext.GetLogger(context).Warnf("error authorizing context: %v", err)
 			return
@@ -704,9 +1416,31 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		// Add username to request logging
 		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, auth.UserNameKey))
 
+		if app.rateLimits != nil {
+			if !app.limitRate(context, w, r, app.rateLimits.user, dcontext.GetStringValue(context, auth.UserNameKey)) {
+				return
+			}
+			if app.nameRequired(r) && !app.limitRate(context, w, r, app.rateLimits.repository, getName(context)) {
+				return
+			}
+		}
+
+		if entry := getAccessLogEntry(context); entry != nil {
+			entry.User = dcontext.GetStringValue(context, auth.UserNameKey)
+			if app.nameRequired(r) {
+				entry.Repository = getName(context)
+				entry.Action = repositoryAction(r.Method)
+			}
+		}
+
 		// sync up context on the request.
 		r = r.WithContext(context)
 
+		if federated != nil {
+			federated.proxy.ServeHTTP(w, r)
+			return
+		}
+
 		if app.nameRequired(r) {
 			nameRef, err := reference.WithName(getName(context))
 			if err != nil {
@@ -715,7 +1449,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 					Name:   getName(context),
 					Reason: err,
 				})
-				if err := errcode.ServeJSON(w, context.Errors); err != nil {
+				if err := errcode.ServeJSON(w, app.responseErrors(context.Errors)); err != nil {
 					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 				}
 				return
@@ -733,7 +1467,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 					context.Errors = append(context.Errors, err)
 				}
 
-				if err := errcode.ServeJSON(w, context.Errors); err != nil {
+				if err := errcode.ServeJSON(w, app.responseErrors(context.Errors)); err != nil {
 					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 				}
 				return
@@ -750,7 +1484,7 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				dcontext.GetLogger(context).Errorf("error initializing repository middleware: %v", err)
 				context.Errors = append(context.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 
-				if err := errcode.ServeJSON(w, context.Errors); err != nil {
+				if err := errcode.ServeJSON(w, app.responseErrors(context.Errors)); err != nil {
 					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 				}
 				return
@@ -761,6 +1495,81 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 	})
 }
 
+// withRouteHeaders returns a response writer adding the configured route
+// headers matching the route and method of the request to the response, if
+// any match.
+func (app *App) withRouteHeaders(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if len(app.Config.HTTP.RouteHeaders) == 0 {
+		return w
+	}
+
+	var routeName string
+	if route := mux.CurrentRoute(r); route != nil {
+		routeName = route.GetName()
+	}
+
+	headers := make(http.Header)
+	for _, routeHeaders := range app.Config.HTTP.RouteHeaders {
+		if !matchesAny(routeHeaders.Routes, routeName) || !matchesAny(routeHeaders.Methods, r.Method) {
+			continue
+		}
+
+		for headerName, headerValues := range routeHeaders.Headers {
+			for _, value := range headerValues {
+				headers.Add(headerName, value)
+			}
+		}
+	}
+	if len(headers) == 0 {
+		return w
+	}
+	return &routeHeadersResponseWriter{ResponseWriter: w, headers: headers}
+}
+
+// routeHeadersResponseWriter adds route headers to successful and redirect
+// responses only, so that headers such as a long lived Cache-Control never
+// make clients or caches keep an error response.
+type routeHeadersResponseWriter struct {
+	http.ResponseWriter
+	headers     http.Header
+	wroteHeader bool
+}
+
+func (w *routeHeadersResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= 200 {
+		w.wroteHeader = true
+		if status < 400 {
+			for headerName, headerValues := range w.headers {
+				for _, value := range headerValues {
+					w.Header().Add(headerName, value)
+				}
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *routeHeadersResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// matchesAny returns true if candidates is empty or contains value, ignoring
+// case.
+func matchesAny(candidates []string, value string) bool {
+	if len(candidates) == 0 {
+		return true
+	}
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
 type errCodeKey struct{}
 
 func (errCodeKey) String() string { return "err.code" }
@@ -799,6 +1608,47 @@ func (app *App) logError(ctx context.Context, errors errcode.Errors) {
 	}
 }
 
+// Levels of the http.errordetail option.
+const (
+	errorDetailFull    = "full"
+	errorDetailMinimal = "minimal"
+	errorDetailNone    = "none"
+)
+
+// responseErrors returns the errors to serve in a response, stripping the
+// detail excluded by the http.errordetail option. The detail of the errors
+// is logged in full by logError regardless.
+func (app *App) responseErrors(errs errcode.Errors) errcode.Errors {
+	level := app.Config.HTTP.ErrorDetail
+	if level == "" || level == errorDetailFull {
+		return errs
+	}
+
+	stripped := make(errcode.Errors, 0, len(errs))
+	for _, err := range errs {
+		var e errcode.Error
+		switch err := err.(type) {
+		case errcode.ErrorCode:
+			stripped = append(stripped, err)
+			continue
+		case errcode.Error:
+			e = err
+		default:
+			// Plain errors are otherwise served with the error itself as
+			// detail, which may include driver errors and storage paths.
+			e = errcode.ErrorCodeUnknown.WithDetail(nil)
+		}
+
+		// Only the detail of server errors is internal; the detail of client
+		// errors describes the request, such as an invalid digest.
+		if level == errorDetailNone || e.Code.Descriptor().HTTPStatusCode >= http.StatusInternalServerError {
+			e.Detail = nil
+		}
+		stripped = append(stripped, e)
+	}
+	return stripped
+}
+
 // context constructs the context object for the application. This only be
 // called once per request.
 func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
@@ -826,6 +1676,10 @@ func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
 		// X-Forwarded-Proto and X-Forwarded-Host headers, and the
 		// hostname in the request.
 		context.urlBuilder = v2.NewURLBuilder(&app.httpHost, false)
+	} else if app.Config.HTTP.RelativeURLs && app.prefix != "/" {
+		// Relative URLs are resolved against the prefix alone, so that
+		// they remain valid when the registry is hosted under a sub-path.
+		context.urlBuilder = v2.NewURLBuilder(&url.URL{Path: app.prefix}, false)
 	} else {
 		context.urlBuilder = v2.NewURLBuilderFromRequest(r, app.Config.HTTP.RelativeURLs)
 	}
@@ -881,6 +1735,14 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 				dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
 			}
 		default:
+			if errors.Is(err, auth.ErrAccessDenied) {
+				dcontext.GetLogger(context).Infof("access denied: %v", err)
+				if err := errcode.ServeJSON(w, errcode.ErrorCodeDenied.WithDetail(accessRecords)); err != nil {
+					dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+				}
+				return err
+			}
+
 			// This condition is a potential security problem either in
 			// the configuration or whatever is backing the access
 			// controller. Just return a bad request with no information
@@ -911,6 +1773,30 @@ func (app *App) eventBridge(ctx *Context, r *http.Request) notifications.Listene
 	return notifications.NewBridge(ctx.urlBuilder, app.events.source, actor, request, app.events.sink, app.Config.Notifications.EventConfig.IncludeReferences)
 }
 
+// backgroundEventBridge returns a bridge for the changes made by a task of
+// the registry itself, such as a maintenance job, attributed to the actor.
+func (app *App) backgroundEventBridge(actor string) notifications.Listener {
+	ub := v2.NewURLBuilder(&url.URL{Path: app.prefix}, true)
+	if app.httpHost.Scheme != "" && app.httpHost.Host != "" {
+		ub = v2.NewURLBuilder(&app.httpHost, false)
+	}
+	return notifications.NewBridge(ub, app.events.source, notifications.ActorRecord{Name: actor}, notifications.RequestRecord{}, app.events.sink, app.Config.Notifications.EventConfig.IncludeReferences)
+}
+
+// clientIP returns the IP address of the client of the request, which is the
+// address of the peer unless trusted proxies are configured. The registry
+// server then discards the forwarded headers not set by a trusted proxy, so
+// that clients can't spoof their address.
+func (app *App) clientIP(r *http.Request) string {
+	if len(app.Config.HTTP.TrustedProxies) > 0 {
+		return dcontext.RemoteIP(r)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 // nameRequired returns true if the route requires a name.
 func (app *App) nameRequired(r *http.Request) bool {
 	route := mux.CurrentRoute(r)
@@ -918,7 +1804,8 @@ func (app *App) nameRequired(r *http.Request) bool {
 		return true
 	}
 	routeName := route.GetName()
-	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog
+	// GraphQL queries authorize access to each repository they select.
+	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog && routeName != v2.RouteNameVersion && routeName != v2.RouteNameGraphQL && routeName != v2.RouteNameMirrors && routeName != v2.RouteNameUsage
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -966,12 +1853,13 @@ func appendAccessRecords(records []auth.Access, method string, repo string) []au
 	return records
 }
 
-// Add the access record for the catalog if it's our current route
+// Add the access record for the catalog if it's our current route. The
+// version and usage routes require the same access as the catalog.
 func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
 
-	if routeName == v2.RouteNameCatalog {
+	if routeName == v2.RouteNameCatalog || routeName == v2.RouteNameVersion || routeName == v2.RouteNameUsage {
 		resource := auth.Resource{
 			Type: "registry",
 			Name: "catalog",
@@ -1011,6 +1899,18 @@ func applyRepoMiddleware(ctx context.Context, repository distribution.Repository
 }
 
 // applyStorageMiddleware wraps a storage driver with the configured middlewares
+// createStorageDriver creates the storage driver configured by storage,
+// overriding its UA string for registry outbound HTTP requests.
+func createStorageDriver(storage configuration.Storage) (storagedriver.StorageDriver, error) {
+	storageParams := make(configuration.Parameters)
+	for k, v := range storage.Parameters() {
+		storageParams[k] = v
+	}
+	storageParams["useragent"] = fmt.Sprintf("distribution/%s %s", version.Version, runtime.Version())
+
+	return factory.Create(storage.Type(), storageParams)
+}
+
 func applyStorageMiddleware(driver storagedriver.StorageDriver, middlewares []configuration.Middleware) (storagedriver.StorageDriver, error) {
 	for _, mw := range middlewares {
 		smw, err := storagemiddleware.Get(mw.Name, mw.Options, driver)
@@ -1040,7 +1940,7 @@ func badPurgeUploadConfig(reason string) {
 
 // startUploadPurger schedules a goroutine which will periodically
 // check upload directories for old files and delete them
-func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
+func startUploadPurger(app *App, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
 	if config["enabled"] == false {
 		return
 	}
@@ -1088,7 +1988,7 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		badPurgeUploadConfig("dryrun missing")
 	}
 
-	go func() {
+	app.goBackground(func(ctx context.Context) {
 		randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
 		if err != nil {
 			log.Infof("Failed to generate random jitter: %v", err)
@@ -1097,12 +1997,20 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		}
 		jitter := time.Duration(randInt.Int64()%60) * time.Minute
 		log.Infof("Starting upload purge in %s", jitter)
-		time.Sleep(jitter)
+		if !sleepContext(ctx, jitter) {
+			return
+		}
 
+		ticker := time.NewTicker(intervalDuration)
+		defer ticker.Stop()
 		for {
 			storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
 			log.Infof("Starting upload purge in %s", intervalDuration)
-			time.Sleep(intervalDuration)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
 		}
-	}()
+	})
 }
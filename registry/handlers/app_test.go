@@ -1,15 +1,21 @@
 package handlers
 
 import (
+	"bytes"
+	stdcontext "context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution/configuration"
 	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/auth"
@@ -17,6 +23,7 @@ import (
 	"github.com/docker/distribution/registry/storage"
 	memorycache "github.com/docker/distribution/registry/storage/cache/memory"
 	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
 )
 
 // TestAppDispatcher builds an application with a test dispatcher and ensures
@@ -39,7 +46,9 @@ func TestAppDispatcher(t *testing.T) {
 	}
 	server := httptest.NewServer(app)
 	defer server.Close()
-	router := v2.Router()
+	// routes are bound to the server host below, so use a router of its own
+	// rather than the shared one
+	router := v2.RouterWithPrefix("")
 
 	serverURL, err := url.Parse(server.URL)
 	if err != nil {
@@ -210,6 +219,86 @@ func TestNewApp(t *testing.T) {
 	}
 }
 
+// TestAppStop ensures that stopping an application stops its background
+// tasks.
+func TestAppStop(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		GC: configuration.GC{Enabled: true, Interval: time.Millisecond},
+		Catalog: configuration.Catalog{
+			Index: configuration.CatalogIndex{Enabled: true, RebuildInterval: time.Millisecond},
+		},
+	}
+	app := NewApp(context.Background(), &config)
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		app.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background tasks were not stopped")
+	}
+}
+
+// denyingAccessController authenticates every request and denies access to
+// all repositories.
+type denyingAccessController struct{}
+
+func (denyingAccessController) Authorized(ctx stdcontext.Context, access ...auth.Access) (stdcontext.Context, error) {
+	if len(access) > 0 {
+		return nil, fmt.Errorf("%w: denied for testing", auth.ErrAccessDenied)
+	}
+	return auth.WithUser(ctx, auth.UserInfo{Name: "test"}), nil
+}
+
+func TestAccessDenied(t *testing.T) {
+	auth.Register("denying", func(options map[string]interface{}) (auth.AccessController, error) {
+		return denyingAccessController{}, nil
+	})
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": nil,
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{"denying": {}},
+	}
+	app := NewApp(context.Background(), &config)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v2/foo/bar/tags/list")
+	if err != nil {
+		t.Fatalf("unexpected error during GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+	checkBodyHasErrorCodes(t, "access denied", resp, errcode.ErrorCodeDenied)
+
+	resp, err = http.Get(server.URL + "/v2/")
+	if err != nil {
+		t.Fatalf("unexpected error during GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code for base route: %d", resp.StatusCode)
+	}
+}
+
 // Test the access record accumulator
 func TestAppendAccessRecords(t *testing.T) {
 	repo := "testRepo"
@@ -274,3 +363,180 @@ func TestAppendAccessRecords(t *testing.T) {
 		t.Fatalf("Actual access record differs from expected")
 	}
 }
+
+// TestRouteHeaders ensures that route headers are only added to the
+// successful responses of matching routes and methods.
+func TestRouteHeaders(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.RouteHeaders = []configuration.RouteHeaders{
+		{
+			Routes:  []string{v2.RouteNameBlob},
+			Methods: []string{http.MethodGet},
+			Headers: http.Header{"Cache-Control": []string{"max-age=86400"}},
+		},
+		{
+			Headers: http.Header{"X-Registry": []string{"test"}},
+		},
+	}
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	content := []byte("route headers")
+	dgst := digest.FromBytes(content)
+	name, _ := reference.WithName("foo/bar")
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(content))
+
+	for _, tc := range []struct {
+		method       string
+		path         string
+		status       int
+		cacheControl bool
+		registry     string
+	}{
+		{method: http.MethodGet, path: "/v2/foo/bar/blobs/" + dgst.String(), status: http.StatusOK, cacheControl: true, registry: "test"},
+		{method: http.MethodHead, path: "/v2/foo/bar/blobs/" + dgst.String(), status: http.StatusOK, registry: "test"},
+		{method: http.MethodGet, path: "/v2/", status: http.StatusOK, registry: "test"},
+		// error responses never get the route headers
+		{method: http.MethodGet, path: "/v2/foo/bar/blobs/sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/v2/foo/bar/manifests/latest", status: http.StatusNotFound},
+	} {
+		req, err := http.NewRequest(tc.method, env.server.URL+tc.path, nil)
+		if err != nil {
+			t.Fatalf("error creating request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: unexpected status: %d != %d", tc.method, tc.path, resp.StatusCode, tc.status)
+		}
+		// blob responses have a Cache-Control header of their own
+		cacheControl := resp.Header.Values("Cache-Control")
+		if tc.cacheControl != (len(cacheControl) > 0 && cacheControl[len(cacheControl)-1] == "max-age=86400") {
+			t.Errorf("%s %s: unexpected Cache-Control header: %q", tc.method, tc.path, cacheControl)
+		}
+		if resp.StatusCode >= 400 && len(cacheControl) > 0 {
+			t.Errorf("%s %s: unexpected Cache-Control header in error response: %q", tc.method, tc.path, cacheControl)
+		}
+		if registry := resp.Header.Get("X-Registry"); registry != tc.registry {
+			t.Errorf("%s %s: unexpected X-Registry header: %q != %q", tc.method, tc.path, registry, tc.registry)
+		}
+	}
+}
+
+func TestResponseErrors(t *testing.T) {
+	errs := errcode.Errors{
+		errcode.ErrorCodeUnknown.WithDetail("open /var/lib/registry/docker/registry/v2/blobs: permission denied"),
+		v2.ErrorCodeDigestInvalid.WithDetail("digest parsing failed"),
+		errcode.ErrorCodeUnsupported,
+		fmt.Errorf("driver error"),
+	}
+
+	for _, tc := range []struct {
+		level    string
+		expected errcode.Errors
+	}{
+		{"", errs},
+		{"full", errs},
+		{"minimal", errcode.Errors{
+			errcode.ErrorCodeUnknown.WithDetail(nil),
+			v2.ErrorCodeDigestInvalid.WithDetail("digest parsing failed"),
+			errcode.ErrorCodeUnsupported,
+			errcode.ErrorCodeUnknown.WithDetail(nil),
+		}},
+		{"none", errcode.Errors{
+			errcode.ErrorCodeUnknown.WithDetail(nil),
+			v2.ErrorCodeDigestInvalid.WithDetail(nil),
+			errcode.ErrorCodeUnsupported,
+			errcode.ErrorCodeUnknown.WithDetail(nil),
+		}},
+	} {
+		app := &App{Config: &configuration.Configuration{}}
+		app.Config.HTTP.ErrorDetail = tc.level
+
+		if actual := app.responseErrors(errs); !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("unexpected errors for level %q: %#v != %#v", tc.level, actual, tc.expected)
+		}
+	}
+}
+
+// TestMetadataIndexAPI checks that the catalog and the tags are served from
+// the metadata index, which is built when the app is created.
+func TestMetadataIndexAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Catalog:       configuration.Catalog{MaxEntries: 5},
+		MetadataIndex: configuration.MetadataIndex{Enabled: true, Path: filepath.Join(t.TempDir(), "index.db")},
+	}
+	config.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/docker/distribution/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+	defer env.app.CloseMetadataIndex()
+
+	if builtAt, err := env.app.metadataIndex.BuiltAt(); err != nil || builtAt.IsZero() {
+		t.Fatalf("expected the metadata index to be built: %v, %v", builtAt, err)
+	}
+
+	createRepository(env, t, "foo/bar", "latest")
+	createRepository(env, t, "foo/bar", "v1")
+	createRepository(env, t, "baz", "latest")
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+	resp, err := http.Get(catalogURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "issuing catalog api check", resp, http.StatusOK)
+	var ctlg catalogAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+		t.Fatalf("error decoding catalog: %v", err)
+	}
+	if expected := []string{"baz", "foo/bar"}; !reflect.DeepEqual(ctlg.Repositories, expected) {
+		t.Fatalf("unexpected repositories: %v != %v", ctlg.Repositories, expected)
+	}
+
+	named, _ := reference.WithName("foo/bar")
+	tagsURL, err := env.builder.BuildTagsURL(named)
+	if err != nil {
+		t.Fatalf("unexpected error building tags url: %v", err)
+	}
+	resp, err = http.Get(tagsURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting tags", resp, http.StatusOK)
+	var tags tagsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		t.Fatalf("error decoding tags: %v", err)
+	}
+	if expected := []string{"latest", "v1"}; !reflect.DeepEqual(tags.Tags, expected) {
+		t.Fatalf("unexpected tags: %v != %v", tags.Tags, expected)
+	}
+	if indexed, err := env.app.metadataIndex.Tags("foo/bar"); err != nil || !reflect.DeepEqual(indexed, tags.Tags) {
+		t.Fatalf("unexpected indexed tags: %v, %v", indexed, err)
+	}
+}
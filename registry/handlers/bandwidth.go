@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/listener"
+	"golang.org/x/time/rate"
+)
+
+// maxIdleBandwidthClients is the number of clients a bandwidth class tracks
+// before forgetting those which are not throttled anymore.
+const maxIdleBandwidthClients = 1024
+
+// bandwidthClass throttles the blob downloads of the clients it matches,
+// with a token bucket for each client.
+type bandwidthClass struct {
+	name         string
+	limit        rate.Limit
+	burst        int
+	scopes       []auth.Resource
+	repositories []string
+	networks     []*net.IPNet
+
+	mu      sync.Mutex
+	clients map[string]*rate.Limiter
+}
+
+// newBandwidthClasses compiles the configured bandwidth classes.
+func newBandwidthClasses(config []configuration.BandwidthClass) ([]*bandwidthClass, error) {
+	classes := make([]*bandwidthClass, 0, len(config))
+	for _, c := range config {
+		if c.Rate <= 0 {
+			return nil, fmt.Errorf("http.bandwidthclasses: class %q must have a positive rate", c.Name)
+		}
+		burst := c.Burst
+		if burst <= 0 {
+			burst = c.Rate
+		}
+
+		class := &bandwidthClass{
+			name:         c.Name,
+			limit:        rate.Limit(c.Rate),
+			burst:        int(burst),
+			repositories: c.Repositories,
+			clients:      make(map[string]*rate.Limiter),
+		}
+		for _, scope := range c.Scopes {
+			typ, name, ok := strings.Cut(scope, ":")
+			if !ok {
+				return nil, fmt.Errorf("http.bandwidthclasses: class %q has scope %q not of the form type:name", c.Name, scope)
+			}
+			if _, err := path.Match(name, ""); err != nil {
+				return nil, fmt.Errorf("http.bandwidthclasses: class %q has scope %q: %v", c.Name, scope, err)
+			}
+			class.scopes = append(class.scopes, auth.Resource{Type: typ, Name: name})
+		}
+		for _, pattern := range c.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("http.bandwidthclasses: class %q has repository pattern %q: %v", c.Name, pattern, err)
+			}
+		}
+		networks, err := listener.ParseNetworks(c.Networks)
+		if err != nil {
+			return nil, fmt.Errorf("http.bandwidthclasses: class %q: %v", c.Name, err)
+		}
+		class.networks = networks
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// matches returns whether the class applies to a download of the repository
+// by the client.
+func (c *bandwidthClass) matches(ctx context.Context, repository string, ip net.IP) bool {
+	if len(c.scopes) == 0 && len(c.repositories) == 0 && len(c.networks) == 0 {
+		return true
+	}
+
+	for _, scope := range c.scopes {
+		for _, resource := range auth.AuthorizedResources(ctx) {
+			if resource.Type != scope.Type {
+				continue
+			}
+			if ok, _ := path.Match(scope.Name, resource.Name); ok {
+				return true
+			}
+		}
+	}
+	for _, pattern := range c.repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	if ip != nil {
+		for _, network := range c.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// limiter returns the token bucket of the client.
+func (c *bandwidthClass) limiter(client string) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if limiter, ok := c.clients[client]; ok {
+		return limiter
+	}
+
+	// a client whose bucket is full again is not throttled anymore, and a
+	// new bucket is equivalent
+	if len(c.clients) >= maxIdleBandwidthClients {
+		now := time.Now()
+		for key, limiter := range c.clients {
+			if limiter.TokensAt(now) >= float64(c.burst) {
+				delete(c.clients, key)
+			}
+		}
+	}
+
+	limiter := rate.NewLimiter(c.limit, c.burst)
+	c.clients[client] = limiter
+	return limiter
+}
+
+// throttleDownload returns the response writer of a blob download,
+// throttled by the first bandwidth class matching the request.
+func (app *App) throttleDownload(ctx *Context, w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if len(app.bandwidthClasses) == 0 {
+		return w
+	}
+
+	client := app.clientIP(r)
+	ip := net.ParseIP(client)
+	for _, class := range app.bandwidthClasses {
+		if !class.matches(ctx, ctx.Repository.Named().Name(), ip) {
+			continue
+		}
+		dcontext.GetLogger(ctx).Debugf("throttling blob download with bandwidth class %q", class.name)
+		return &throttledResponseWriter{
+			ResponseWriter: w,
+			ctx:            ctx,
+			limiter:        class.limiter(client),
+		}
+	}
+	return w
+}
+
+// throttledResponseWriter delays writes of the response body to the rate of
+// its token bucket.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		if burst := w.limiter.Burst(); n > burst {
+			n = burst
+		}
+		if err := w.limiter.WaitN(w.ctx, n); err != nil {
+			return written, err
+		}
+		nn, err := w.ResponseWriter.Write(p[:n])
+		written += nn
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestBandwidthClasses(t *testing.T) {
+	classes, err := newBandwidthClasses([]configuration.BandwidthClass{
+		{Name: "batch", Rate: 1 << 20, Scopes: []string{"registry:batch-*"}},
+		{Name: "mirrors", Rate: 1 << 20, Repositories: []string{"mirror/*"}, Networks: []string{"10.0.0.0/8", "192.168.1.1"}},
+		{Name: "default", Rate: 1 << 30},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error compiling bandwidth classes: %v", err)
+	}
+
+	batch := auth.WithResources(context.Background(), []auth.Resource{{Type: "registry", Name: "batch-nightly"}})
+	for _, tc := range []struct {
+		ctx        context.Context
+		repository string
+		ip         string
+		expected   string
+	}{
+		{batch, "app", "172.16.0.1", "batch"},
+		{context.Background(), "mirror/alpine", "172.16.0.1", "mirrors"},
+		{context.Background(), "app", "10.1.2.3", "mirrors"},
+		{context.Background(), "app", "192.168.1.1", "mirrors"},
+		{context.Background(), "app", "192.168.1.2", "default"},
+		{context.Background(), "mirror/alpine/edge", "", "default"},
+	} {
+		var matched string
+		for _, class := range classes {
+			if class.matches(tc.ctx, tc.repository, net.ParseIP(tc.ip)) {
+				matched = class.name
+				break
+			}
+		}
+		if matched != tc.expected {
+			t.Errorf("unexpected class for %s from %q: %q != %q", tc.repository, tc.ip, matched, tc.expected)
+		}
+	}
+
+	for _, config := range []configuration.BandwidthClass{
+		{Name: "norate"},
+		{Name: "scope", Rate: 1, Scopes: []string{"batch"}},
+		{Name: "pattern", Rate: 1, Repositories: []string{"["}},
+		{Name: "network", Rate: 1, Networks: []string{"10.0.0.0/33"}},
+	} {
+		if _, err := newBandwidthClasses([]configuration.BandwidthClass{config}); err == nil {
+			t.Errorf("expected an error compiling class %q", config.Name)
+		}
+	}
+}
+
+func TestThrottleDownloadClientAddress(t *testing.T) {
+	classes, err := newBandwidthClasses([]configuration.BandwidthClass{
+		{Name: "internal", Rate: 1 << 20, Networks: []string{"10.0.0.0/8"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error compiling bandwidth classes: %v", err)
+	}
+	registry, err := storage.NewRegistry(context.Background(), inmemory.New())
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	name, _ := reference.WithName("foo/bar")
+	repository, err := registry.Repository(context.Background(), name)
+	if err != nil {
+		t.Fatalf("error getting repository: %v", err)
+	}
+
+	for _, tc := range []struct {
+		trustedProxies []string
+		remoteAddr     string
+		throttled      bool
+	}{
+		// the forwarded header is ignored without trusted proxies
+		{nil, "192.0.2.1:1234", false},
+		{nil, "10.1.2.3:1234", true},
+		{[]string{"192.0.2.1"}, "192.0.2.1:1234", true},
+	} {
+		app := &App{Config: &configuration.Configuration{}, bandwidthClasses: classes}
+		app.Config.HTTP.TrustedProxies = tc.trustedProxies
+		ctx := &Context{App: app, Context: context.Background(), Repository: repository}
+
+		r := httptest.NewRequest("GET", "/v2/foo/bar/blobs/sha256:abc", nil)
+		r.RemoteAddr = tc.remoteAddr
+		r.Header.Set("X-Forwarded-For", "10.1.2.3")
+		w := httptest.NewRecorder()
+		if _, throttled := app.throttleDownload(ctx, w, r).(*throttledResponseWriter); throttled != tc.throttled {
+			t.Errorf("unexpected throttling of %s with trusted proxies %v: %v", tc.remoteAddr, tc.trustedProxies, throttled)
+		}
+	}
+}
+
+func TestThrottledResponseWriter(t *testing.T) {
+	classes, err := newBandwidthClasses([]configuration.BandwidthClass{{Rate: 4096, Burst: 1024}})
+	if err != nil {
+		t.Fatalf("unexpected error compiling bandwidth classes: %v", err)
+	}
+	class := classes[0]
+	if class.limiter("10.0.0.1") != class.limiter("10.0.0.1") {
+		t.Fatal("expected the token bucket of a client to be reused")
+	}
+	if class.limiter("10.0.0.1") == class.limiter("10.0.0.2") {
+		t.Fatal("expected clients to have their own token bucket")
+	}
+
+	recorder := httptest.NewRecorder()
+	w := &throttledResponseWriter{
+		ResponseWriter: recorder,
+		ctx:            context.Background(),
+		limiter:        class.limiter("10.0.0.1"),
+	}
+	content := bytes.Repeat([]byte("a"), 3072)
+
+	start := time.Now()
+	n, err := w.Write(content)
+	if err != nil || n != len(content) {
+		t.Fatalf("unexpected result writing: %d, %v", n, err)
+	}
+	// the first 1024 bytes are the burst, the other 2048 take half a second
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("write was not throttled: %v", elapsed)
+	}
+	if !bytes.Equal(recorder.Body.Bytes(), content) {
+		t.Fatal("unexpected content written")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.ctx = ctx
+	if _, err := w.Write(content); err == nil {
+		t.Fatal("expected an error writing after the request is cancelled")
+	}
+}
@@ -28,6 +28,12 @@ func blobDispatcher(ctx *Context, r *http.Request) http.Handler {
 		})
 	}
 
+	if !ctx.App.digestAlgorithmSupported(dgst.Algorithm()) {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, v2.ErrorCodeDigestInvalid.WithDetail(digest.ErrDigestUnsupported))
+		})
+	}
+
 	blobHandler := &blobHandler{
 		Context: ctx,
 		Digest:  dgst,
@@ -38,7 +44,7 @@ func blobDispatcher(ctx *Context, r *http.Request) http.Handler {
 		http.MethodHead: http.HandlerFunc(blobHandler.GetBlob),
 	}
 
-	if !ctx.readOnly {
+	if !ctx.isReadOnly() {
 		mhandler[http.MethodDelete] = http.HandlerFunc(blobHandler.DeleteBlob)
 	}
 
@@ -72,6 +78,15 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 	if handled {
 		return
 	}
+	if r.Method == http.MethodGet {
+		limited, done, ok := bh.App.limitDownload(bh.Context, w)
+		if !ok {
+			return
+		}
+		defer done()
+		w = limited
+	}
+	w = bh.App.throttleDownload(bh.Context, w, r)
 	if err := blobs.ServeBlob(bh, w, r, desc.Digest); err != nil {
 		context.GetLogger(bh).Debugf("unexpected error getting blob HTTP handler: %v", err)
 		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -99,6 +114,7 @@ func (bh *blobHandler) DeleteBlob(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	bh.App.usage.unlink(bh.Repository.Named().Name(), bh.Digest)
 
 	w.Header().Set("Content-Length", "0")
 	w.WriteHeader(http.StatusAccepted)
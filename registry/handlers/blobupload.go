@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -21,6 +22,10 @@ import (
 // blobUploadDispatcher constructs and returns the blob upload handler for the
 // given request context.
 func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
+	if r.ContentLength > 0 {
+		// let the storage driver size its writes for the request body
+		ctx.Context = storagedriver.WithSizeHint(ctx.Context, r.ContentLength)
+	}
 	buh := &blobUploadHandler{
 		Context: ctx,
 		UUID:    getUploadUUID(ctx),
@@ -31,7 +36,7 @@ func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
 		http.MethodHead: http.HandlerFunc(buh.GetUploadStatus),
 	}
 
-	if !ctx.readOnly {
+	if !ctx.isReadOnly() {
 		handler[http.MethodPost] = http.HandlerFunc(buh.PostBlobData)
 		handler[http.MethodPatch] = http.HandlerFunc(buh.PatchBlobData)
 		handler[http.MethodPut] = http.HandlerFunc(buh.BlobUploadComplete)
@@ -67,6 +72,11 @@ type blobUploadHandler struct {
 // StartBlobUpload begins the blob upload process and allocates a server-side
 // blob writer session, optionally mounting the blob from a separate repository.
 func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Request) {
+	if err := buh.namePolicy.check(buh.Repository.Named().Name()); err != nil {
+		buh.Errors = append(buh.Errors, err)
+		return
+	}
+
 	var options []distribution.BlobCreateOption
 
 	fromRepo := r.FormValue("from")
@@ -74,15 +84,24 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 
 	if mountDigest != "" && fromRepo != "" {
 		opt, err := buh.createBlobMountOption(fromRepo, mountDigest)
-		if opt != nil && err == nil {
+		if opt != nil && err == nil && buh.mountWithinQuota(digest.Digest(mountDigest)) {
 			options = append(options, opt)
 		}
 	}
 
+	if alg := digest.Algorithm(r.FormValue("digest-algorithm")); alg != "" {
+		if !buh.App.digestAlgorithmSupported(alg) {
+			buh.Errors = append(buh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(digest.ErrDigestUnsupported))
+			return
+		}
+		options = append(options, storage.WithDigestAlgorithm(alg))
+	}
+
 	blobs := buh.Repository.Blobs(buh)
 	upload, err := blobs.Create(buh, options...)
 	if err != nil {
 		if ebm, ok := err.(distribution.ErrBlobMounted); ok {
+			buh.App.usage.link(buh.Repository.Named().Name(), ebm.Descriptor.Digest, ebm.Descriptor.Size)
 			if err := buh.writeBlobCreatedHeaders(w, ebm.Descriptor); err != nil {
 				buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
@@ -104,6 +123,7 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 	}
 
 	w.Header().Set("Docker-Upload-UUID", buh.Upload.ID())
+	buh.App.setDigestAlgorithmsHeader(w)
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -122,6 +142,12 @@ func (buh *blobUploadHandler) GetUploadStatus(w http.ResponseWriter, r *http.Req
 			return
 		}
 
+		if buh.App.uploadExpired(upload) {
+			upload.Close()
+			buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadUnknown.WithDetail("upload session expired"))
+			return
+		}
+
 		buh.Upload = upload
 	}
 
@@ -171,7 +197,20 @@ func (buh *blobUploadHandler) PatchBlobData(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PATCH"); err != nil {
+	limit, err := buh.blobSizeLimit(r)
+	if err != nil {
+		buh.Errors = append(buh.Errors, err)
+		buh.cancelUpload()
+		return
+	}
+
+	if err := copyFullPayload(buh, w, r, buh.Upload, limit, "blob PATCH"); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			buh.Errors = append(buh.Errors, buh.blobTooLarge())
+			buh.cancelUpload()
+			return
+		}
 		switch err := err.(type) {
 		case storagedriver.QuotaExceededError:
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
@@ -205,13 +244,6 @@ func (buh *blobUploadHandler) PostBlobData(w http.ResponseWriter, r *http.Reques
 // verified. If successful, the blob is linked into the blob store and 201
 // Created is returned with the canonical url of the blob.
 func (buh *blobUploadHandler) BlobUploadComplete(w http.ResponseWriter, r *http.Request) {
-	var err error
-	if buh.Upload == nil {
-		blobs := buh.Repository.Blobs(buh)
-		buh.Upload, err = blobs.Create(buh)
-	}
-	defer buh.Upload.Close()
-
 	dgstStr := r.FormValue("digest") // TODO(stevvooe): Support multiple digest parameters!
 
 	if dgstStr == "" {
@@ -227,7 +259,36 @@ func (buh *blobUploadHandler) BlobUploadComplete(w http.ResponseWriter, r *http.
 		return
 	}
 
-	if err := copyFullPayload(buh, w, r, buh.Upload, -1, "blob PUT"); err != nil {
+	if !buh.App.digestAlgorithmSupported(dgst.Algorithm()) {
+		buh.Errors = append(buh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(digest.ErrDigestUnsupported))
+		return
+	}
+
+	if buh.Upload == nil {
+		// a monolithic upload is hashed with the algorithm of its digest
+		blobs := buh.Repository.Blobs(buh)
+		buh.Upload, err = blobs.Create(buh, storage.WithDigestAlgorithm(dgst.Algorithm()))
+		if err != nil {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+	defer buh.Upload.Close()
+
+	limit, err := buh.blobSizeLimit(r)
+	if err != nil {
+		buh.Errors = append(buh.Errors, err)
+		buh.cancelUpload()
+		return
+	}
+
+	if err := copyFullPayload(buh, w, r, buh.Upload, limit, "blob PUT"); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			buh.Errors = append(buh.Errors, buh.blobTooLarge())
+			buh.cancelUpload()
+			return
+		}
 		switch err := err.(type) {
 		case storagedriver.QuotaExceededError:
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeDenied.WithMessage("quota exceeded"))
@@ -239,6 +300,12 @@ func (buh *blobUploadHandler) BlobUploadComplete(w http.ResponseWriter, r *http.
 		return
 	}
 
+	if err := buh.App.checkQuota(buh, buh.Repository.Named(), dgst, buh.Upload.Size()); err != nil {
+		buh.Errors = append(buh.Errors, err)
+		buh.cancelUpload()
+		return
+	}
+
 	desc, err := buh.Upload.Commit(buh, distribution.Descriptor{
 		Digest: dgst,
 
@@ -270,13 +337,12 @@ func (buh *blobUploadHandler) BlobUploadComplete(w http.ResponseWriter, r *http.
 		}
 
 		// Clean up the backend blob data if there was an error.
-		if err := buh.Upload.Cancel(buh); err != nil {
-			// If the cleanup fails, all we can do is observe and report.
-			dcontext.GetLogger(buh).Errorf("error canceling upload after error: %v", err)
-		}
+		buh.cancelUpload()
 
 		return
 	}
+	buh.App.usage.link(buh.Repository.Named().Name(), desc.Digest, desc.Size)
+
 	if err := buh.writeBlobCreatedHeaders(w, desc); err != nil {
 		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
@@ -338,6 +404,15 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		})
 	}
+	if ctx.App.uploadExpired(upload) {
+		dcontext.GetLogger(ctx).Infof("cancelling expired upload %s", buh.UUID)
+		if err := upload.Cancel(buh); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error cancelling expired upload: %v", err)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buh.Errors = append(buh.Errors, v2.ErrorCodeBlobUploadUnknown.WithDetail("upload session expired"))
+		})
+	}
 	buh.Upload = upload
 
 	if size := upload.Size(); size != buh.State.Offset {
@@ -390,6 +465,51 @@ func (buh *blobUploadHandler) blobUploadResponse(w http.ResponseWriter, r *http.
 	return nil
 }
 
+// cancelUpload removes the data of the upload after an error.
+func (buh *blobUploadHandler) cancelUpload() {
+	if err := buh.Upload.Cancel(buh); err != nil {
+		// If the cleanup fails, all we can do is observe and report.
+		dcontext.GetLogger(buh).Errorf("error canceling upload after error: %v", err)
+	}
+}
+
+// blobSizeLimit returns the number of bytes the request may add to the
+// upload, or -1 if the size of blobs is not limited. An error is returned if
+// the request declares a body which would make the blob too large.
+func (buh *blobUploadHandler) blobSizeLimit(r *http.Request) (int64, error) {
+	max := buh.App.Config.HTTP.BodyLimits.Blobs
+	if max <= 0 {
+		return -1, nil
+	}
+
+	remaining := max - buh.Upload.Size()
+	if r.ContentLength > remaining || (remaining <= 0 && r.ContentLength != 0) {
+		return 0, buh.blobTooLarge()
+	}
+	return remaining, nil
+}
+
+// blobTooLarge returns the error rejecting blobs larger than the limit.
+func (buh *blobUploadHandler) blobTooLarge() error {
+	return errcode.ErrorCodeRequestTooLarge.WithDetail(
+		fmt.Sprintf("blob exceeds the limit of %d bytes", buh.App.Config.HTTP.BodyLimits.Blobs))
+}
+
+// mountWithinQuota reports whether mounting the blob keeps the repository
+// within its quotas. Mounts which would not are turned into regular uploads,
+// which are denied once completed.
+func (buh *blobUploadHandler) mountWithinQuota(dgst digest.Digest) bool {
+	if buh.App.quotas == nil {
+		return true
+	}
+	desc, err := buh.App.registry.BlobStatter().Stat(buh, dgst)
+	if err != nil {
+		// The mount fails on its own.
+		return true
+	}
+	return buh.App.checkQuota(buh, buh.Repository.Named(), dgst, desc.Size) == nil
+}
+
 // mountBlob attempts to mount a blob from another repository by its digest. If
 // successful, the blob is linked into the blob store and 201 Created is
 // returned with the canonical url of the blob.
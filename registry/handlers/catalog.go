@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
@@ -40,6 +43,29 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	lastEntry := q.Get("last")
 
+	filter, err := parseCatalogFilter(q)
+	if err != nil {
+		ch.Errors = append(ch.Errors, v2.ErrorCodeCatalogQueryInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	// a cursor takes precedence over the last entry of the previous page
+	var position catalogCursor
+	if cursor := q.Get("cursor"); cursor != "" {
+		position, err = decodeCatalogCursor(cursor)
+		if err != nil || position.sort() != filter.sort {
+			ch.Errors = append(ch.Errors, v2.ErrorCodePaginationCursorInvalid.WithDetail(map[string]string{"cursor": cursor}))
+			return
+		}
+		lastEntry = position.Last
+	} else if lastEntry != "" {
+		if filter.sort != catalogSortName {
+			ch.Errors = append(ch.Errors, v2.ErrorCodeCatalogQueryInvalid.WithDetail("last only applies to the lexical order"))
+			return
+		}
+		position.Last = lastEntry
+	}
+
 	entries := defaultReturnedEntries
 	maximumConfiguredEntries := ch.App.Config.Catalog.MaxEntries
 
@@ -68,10 +94,33 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	repos := make([]string, entries)
 	filled := 0
 
-	// entries is guaranteed to be >= 0 and < maximumConfiguredEntries
-	if entries == 0 {
+	// the index serves the catalog once built, and is required to filter it
+	indexed, more, ready := ch.App.catalogIndex.query(filter, position, entries)
+	switch {
+	case ready:
+		for i, entry := range indexed {
+			repos[i] = entry.name
+		}
+		filled = len(indexed)
+		moreEntries = more
+		if more {
+			position = catalogCursor{Last: indexed[filled-1].name}
+			if filter.sort == catalogSortModified {
+				modified := indexed[filled-1].modified
+				position.Sort = catalogSortModified
+				position.Modified = &modified
+			}
+		}
+	case filter.active() && ch.App.catalogIndex == nil:
+		ch.Errors = append(ch.Errors, v2.ErrorCodeCatalogQueryInvalid.WithDetail("filtering the catalog requires the catalog index"))
+		return
+	case filter.active():
+		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnavailable.WithMessage("the catalog index is being built"))
+		return
+	case entries == 0:
+		// entries is guaranteed to be >= 0 and < maximumConfiguredEntries
 		moreEntries = false
-	} else {
+	default:
 		returnedRepositories, err := ch.App.registry.Repositories(ch.Context, repos, lastEntry)
 		if err != nil {
 			_, pathNotFound := err.(driver.PathNotFoundError)
@@ -83,14 +132,16 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 			moreEntries = false
 		}
 		filled = returnedRepositories
+		if moreEntries {
+			position = catalogCursor{Last: repos[filled-1]}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
 	// Add a link header if there are more entries to retrieve
 	if moreEntries {
-		lastEntry = repos[filled-1]
-		urlStr, err := createLinkEntry(r.URL.String(), entries, lastEntry)
+		urlStr, err := createCatalogLinkEntry(r.URL, entries, position)
 		if err != nil {
 			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			return
@@ -107,18 +158,120 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseCatalogFilter parses the filtering and sorting parameters of a
+// catalog request.
+func parseCatalogFilter(q url.Values) (catalogFilter, error) {
+	filter := catalogFilter{
+		prefix:   q.Get("prefix"),
+		contains: q.Get("contains"),
+		sort:     catalogSortName,
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"modifiedsince", &filter.modifiedSince},
+		{"modifiedbefore", &filter.modifiedBefore},
+	} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time: %v", p.name, err)
+			}
+			*p.t = t
+		}
+	}
+	switch sort := q.Get("sort"); sort {
+	case "", catalogSortName:
+	case catalogSortModified:
+		filter.sort = sort
+	default:
+		return filter, fmt.Errorf("sort must be %s or %s, got %q", catalogSortName, catalogSortModified, sort)
+	}
+	return filter, nil
+}
+
+// catalogCursorVersion is the version of the catalog cursor format. Cursors
+// of other versions are rejected.
+const catalogCursorVersion = 1
+
+// catalogCursor is the position in the catalog, encoded as an opaque cursor
+// in pagination links. As repositories are listed in lexical order, the last
+// returned repository is a stable position even while repositories are
+// created and deleted. When the most recently tagged repositories are listed
+// first, the position also holds the time the last one was tagged.
+type catalogCursor struct {
+	Version  int        `json:"v"`
+	Last     string     `json:"last"`
+	Sort     string     `json:"sort,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+// sort returns the order of the catalog the cursor is a position in.
+func (c catalogCursor) sort() string {
+	if c.Sort == "" {
+		return catalogSortName
+	}
+	return c.Sort
+}
+
+// encodeCatalogCursor returns the opaque cursor of the catalog position.
+func encodeCatalogCursor(c catalogCursor) string {
+	c.Version = catalogCursorVersion
+	// encoding a struct of strings, an int and a time cannot fail
+	p, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(p)
+}
+
+// decodeCatalogCursor returns the catalog position encoded in cursor.
+func decodeCatalogCursor(cursor string) (catalogCursor, error) {
+	p, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return catalogCursor{}, err
+	}
+
+	var c catalogCursor
+	if err := json.Unmarshal(p, &c); err != nil {
+		return catalogCursor{}, err
+	}
+	if c.Version != catalogCursorVersion {
+		return catalogCursor{}, errors.New("unsupported cursor version")
+	}
+	if c.sort() == catalogSortModified && c.Modified == nil {
+		return catalogCursor{}, errors.New("missing modification time")
+	}
+	return c, nil
+}
+
+// createCatalogLinkEntry creates the link header of the next catalog page,
+// which continues after the cursor with the filters of the request.
+func createCatalogLinkEntry(reqURL *url.URL, maxEntries int, cursor catalogCursor) (string, error) {
+	v := reqURL.Query()
+	v.Del("last")
+	v.Set("n", strconv.Itoa(maxEntries))
+	v.Set("cursor", encodeCatalogCursor(cursor))
+
+	return createLink(reqURL.String(), v)
+}
+
 // Use the original URL from the request to create a new URL for
 // the link header
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
+	v := url.Values{}
+	v.Add("n", strconv.Itoa(maxEntries))
+	v.Add("last", lastEntry)
+
+	return createLink(origURL, v)
+}
+
+// createLink creates a link header to the original URL with the query
+// replaced by v.
+func createLink(origURL string, v url.Values) (string, error) {
 	calledURL, err := url.Parse(origURL)
 	if err != nil {
 		return "", err
 	}
 
-	v := url.Values{}
-	v.Add("n", strconv.Itoa(maxEntries))
-	v.Add("last", lastEntry)
-
 	calledURL.RawQuery = v.Encode()
 
 	calledURL.Fragment = ""
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+const defaultCatalogIndexRebuildInterval = time.Hour
+
+const (
+	// catalogSortName lists repositories in lexical order.
+	catalogSortName = "name"
+	// catalogSortModified lists the most recently tagged repositories
+	// first.
+	catalogSortModified = "modified"
+)
+
+// catalogFilter selects and orders the repositories of a catalog request.
+type catalogFilter struct {
+	prefix         string
+	contains       string
+	modifiedSince  time.Time
+	modifiedBefore time.Time
+	sort           string
+}
+
+// active returns whether the filter restricts or reorders the catalog.
+func (f catalogFilter) active() bool {
+	return f.prefix != "" || f.contains != "" || !f.modifiedSince.IsZero() || !f.modifiedBefore.IsZero() || (f.sort != "" && f.sort != catalogSortName)
+}
+
+func (f catalogFilter) matches(name string, modified time.Time) bool {
+	return strings.HasPrefix(name, f.prefix) &&
+		strings.Contains(name, f.contains) &&
+		(f.modifiedSince.IsZero() || !modified.Before(f.modifiedSince)) &&
+		(f.modifiedBefore.IsZero() || modified.Before(f.modifiedBefore))
+}
+
+// catalogEntry is a repository of the index, with the last time it was
+// tagged.
+type catalogEntry struct {
+	name     string
+	modified time.Time
+}
+
+// catalogUpdate is a repository tagged or removed.
+type catalogUpdate struct {
+	name     string
+	modified time.Time
+	removed  bool
+}
+
+// catalogIndex keeps the names of the repositories and the last time each was
+// tagged, so that the catalog can be served and filtered without walking the
+// storage.
+type catalogIndex struct {
+	mu           sync.Mutex
+	repositories map[string]time.Time
+	builtAt      time.Time
+
+	// journal records the updates made while the index is rebuilt, to
+	// replay them on the rebuilt index.
+	rebuilding bool
+	journal    []catalogUpdate
+}
+
+func newCatalogIndex() *catalogIndex {
+	return &catalogIndex{repositories: make(map[string]time.Time)}
+}
+
+// touch records that the repository was tagged at the given time. A nil
+// index records nothing.
+func (ci *catalogIndex) touch(name string, modified time.Time) {
+	ci.update(catalogUpdate{name: name, modified: modified})
+}
+
+// remove records that the repository was deleted. A nil index records
+// nothing.
+func (ci *catalogIndex) remove(name string) {
+	ci.update(catalogUpdate{name: name, removed: true})
+}
+
+func (ci *catalogIndex) update(u catalogUpdate) {
+	if ci == nil {
+		return
+	}
+
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if ci.rebuilding {
+		ci.journal = append(ci.journal, u)
+	}
+	applyCatalogUpdate(ci.repositories, u)
+}
+
+func applyCatalogUpdate(repositories map[string]time.Time, u catalogUpdate) {
+	if u.removed {
+		delete(repositories, u.name)
+		return
+	}
+	if modified, ok := repositories[u.name]; !ok || u.modified.After(modified) {
+		repositories[u.name] = u.modified
+	}
+}
+
+// beginRebuild starts journaling updates, and returns false if a rebuild is
+// already running.
+func (ci *catalogIndex) beginRebuild() bool {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if ci.rebuilding {
+		return false
+	}
+	ci.rebuilding = true
+	ci.journal = nil
+	return true
+}
+
+// endRebuild replaces the index with the repositories read from the
+// storage, on which the updates journaled meanwhile are replayed. If
+// repositories is nil, the rebuild failed and the index is kept.
+func (ci *catalogIndex) endRebuild(repositories map[string]time.Time, builtAt time.Time) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	journal := ci.journal
+	ci.rebuilding = false
+	ci.journal = nil
+	if repositories == nil {
+		return
+	}
+
+	for _, u := range journal {
+		applyCatalogUpdate(repositories, u)
+	}
+	ci.repositories = repositories
+	ci.builtAt = builtAt
+}
+
+// query returns at most n repositories matching the filter, in its order,
+// after the position of the cursor, and whether there are more. The last
+// return value is false until the index is built for the first time.
+func (ci *catalogIndex) query(f catalogFilter, after catalogCursor, n int) ([]catalogEntry, bool, bool) {
+	if ci == nil {
+		return nil, false, false
+	}
+
+	ci.mu.Lock()
+	if ci.builtAt.IsZero() {
+		ci.mu.Unlock()
+		return nil, false, false
+	}
+	var entries []catalogEntry
+	for name, modified := range ci.repositories {
+		if f.matches(name, modified) {
+			entries = append(entries, catalogEntry{name: name, modified: modified})
+		}
+	}
+	ci.mu.Unlock()
+
+	less := func(a, b catalogEntry) bool { return a.name < b.name }
+	if f.sort == catalogSortModified {
+		less = func(a, b catalogEntry) bool {
+			if !a.modified.Equal(b.modified) {
+				return a.modified.After(b.modified)
+			}
+			return a.name < b.name
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+	if after.Last != "" {
+		position := catalogEntry{name: after.Last}
+		if after.Modified != nil {
+			position.modified = *after.Modified
+		}
+		i := sort.Search(len(entries), func(i int) bool { return less(position, entries[i]) })
+		entries = entries[i:]
+	}
+	if len(entries) > n {
+		return entries[:n], true, true
+	}
+	return entries, false, true
+}
+
+// startCatalogIndex builds the index of the repositories in the background,
+// right away and then periodically.
+func (app *App) startCatalogIndex(config configuration.CatalogIndex) {
+	if !config.Enabled {
+		return
+	}
+
+	interval := config.RebuildInterval
+	if interval <= 0 {
+		interval = defaultCatalogIndexRebuildInterval
+	}
+	app.catalogIndex = newCatalogIndex()
+
+	log := dcontext.GetLogger(app)
+	log.Infof("catalog: rebuilding the repository index every %s", interval)
+
+	app.goBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			start := time.Now()
+			if err := app.rebuildCatalogIndex(ctx); err != nil {
+				log.Errorf("catalog: error building the repository index: %v", err)
+			} else {
+				log.Infof("catalog: built the repository index in %s", time.Since(start))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// rebuildCatalogIndex reads the repositories listed in the catalog and the
+// last time each was tagged from the storage.
+func (app *App) rebuildCatalogIndex(ctx context.Context) error {
+	if !app.catalogIndex.beginRebuild() {
+		return nil
+	}
+	builtAt := time.Now()
+
+	repositories := make(map[string]time.Time)
+	repos := make([]string, quotaRepositoriesPage)
+	last := ""
+	for {
+		n, err := app.registry.Repositories(ctx, repos, last)
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && err != io.EOF && !ok {
+			app.catalogIndex.endRebuild(nil, builtAt)
+			return err
+		}
+		for _, repo := range repos[:n] {
+			named, err := reference.WithName(repo)
+			if err != nil {
+				app.catalogIndex.endRebuild(nil, builtAt)
+				return err
+			}
+			info, err := storage.GetRepositoryInfo(ctx, app.driverFor(repo), named)
+			if err != nil {
+				if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+					// deleted while listing
+					continue
+				}
+				app.catalogIndex.endRebuild(nil, builtAt)
+				return err
+			}
+			var modified time.Time
+			if info.LastTagged != nil {
+				modified = *info.LastTagged
+			}
+			repositories[repo] = modified
+		}
+		if err != nil || n == 0 {
+			break
+		}
+		last = repos[n-1]
+	}
+
+	app.catalogIndex.endRebuild(repositories, builtAt)
+	return nil
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	v2 "github.com/docker/distribution/registry/api/v2"
+)
+
+func catalogNames(entries []catalogEntry) []string {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.name
+	}
+	return names
+}
+
+func TestCatalogIndexQuery(t *testing.T) {
+	ci := newCatalogIndex()
+	if _, _, ready := ci.query(catalogFilter{}, catalogCursor{}, 10); ready {
+		t.Fatal("expected the index not to be ready before it is built")
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !ci.beginRebuild() {
+		t.Fatal("expected the rebuild to begin")
+	}
+	// updates made during the rebuild are kept
+	ci.touch("team-b/web", base.Add(3*time.Hour))
+	ci.endRebuild(map[string]time.Time{
+		"team-a/api":   base.Add(time.Hour),
+		"team-a/web":   base.Add(2 * time.Hour),
+		"team-b/api":   base,
+		"team-b/web":   base,
+		"team-c/empty": {},
+	}, base)
+
+	for _, tc := range []struct {
+		filter   catalogFilter
+		after    catalogCursor
+		n        int
+		expected []string
+		more     bool
+	}{
+		{
+			filter:   catalogFilter{sort: catalogSortName},
+			n:        3,
+			expected: []string{"team-a/api", "team-a/web", "team-b/api"},
+			more:     true,
+		},
+		{
+			filter:   catalogFilter{sort: catalogSortName},
+			after:    catalogCursor{Last: "team-b/api"},
+			n:        3,
+			expected: []string{"team-b/web", "team-c/empty"},
+		},
+		{
+			filter:   catalogFilter{prefix: "team-a/", sort: catalogSortName},
+			n:        10,
+			expected: []string{"team-a/api", "team-a/web"},
+		},
+		{
+			filter:   catalogFilter{contains: "web", sort: catalogSortName},
+			n:        10,
+			expected: []string{"team-a/web", "team-b/web"},
+		},
+		{
+			filter:   catalogFilter{modifiedSince: base.Add(time.Hour), modifiedBefore: base.Add(3 * time.Hour), sort: catalogSortName},
+			n:        10,
+			expected: []string{"team-a/api", "team-a/web"},
+		},
+		{
+			filter:   catalogFilter{sort: catalogSortModified},
+			n:        2,
+			expected: []string{"team-b/web", "team-a/web"},
+			more:     true,
+		},
+		{
+			filter:   catalogFilter{sort: catalogSortModified},
+			after:    catalogCursor{Last: "team-a/web", Sort: catalogSortModified, Modified: timePtr(base.Add(2 * time.Hour))},
+			n:        10,
+			expected: []string{"team-a/api", "team-b/api", "team-c/empty"},
+		},
+	} {
+		entries, more, ready := ci.query(tc.filter, tc.after, tc.n)
+		if !ready {
+			t.Fatal("expected the index to be ready")
+		}
+		if names := catalogNames(entries); !reflect.DeepEqual(names, tc.expected) || more != tc.more {
+			t.Errorf("unexpected result for %+v after %+v: %v, %v", tc.filter, tc.after, names, more)
+		}
+	}
+
+	ci.remove("team-b/web")
+	entries, _, _ := ci.query(catalogFilter{prefix: "team-b/"}, catalogCursor{}, 10)
+	if names := catalogNames(entries); !reflect.DeepEqual(names, []string{"team-b/api"}) {
+		t.Fatalf("expected the removed repository not to be listed: %v", names)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func TestCatalogFilterAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Catalog: configuration.Catalog{
+			MaxEntries: 5,
+			Index:      configuration.CatalogIndex{Enabled: true},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/docker/distribution/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, _, ready := env.app.catalogIndex.query(catalogFilter{}, catalogCursor{}, 1); ready {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the catalog index was not built")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, name := range []string{"foo/aaaa", "foo/bbbb", "bar/aaaa", "foo/cccc"} {
+		createRepository(env, t, name, "latest")
+	}
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+	getCatalog := func(query url.Values, expected int) ([]string, string) {
+		t.Helper()
+
+		resp, err := http.Get(catalogURL + "?" + query.Encode())
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "issuing catalog api check", resp, expected)
+		if expected != http.StatusOK {
+			return nil, ""
+		}
+
+		var ctlg catalogAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		next := ""
+		if m := regexp.MustCompile("<(.*)>; rel=\"next\"").FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next = m[1]
+		}
+		return ctlg.Repositories, next
+	}
+
+	repos, next := getCatalog(url.Values{"prefix": {"foo/"}, "n": {"2"}}, http.StatusOK)
+	if !reflect.DeepEqual(repos, []string{"foo/aaaa", "foo/bbbb"}) || next == "" {
+		t.Fatalf("unexpected first page: %v, %q", repos, next)
+	}
+	// the link keeps the filters of the request
+	u, err := url.Parse(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos, next = getCatalog(u.Query(), http.StatusOK)
+	if !reflect.DeepEqual(repos, []string{"foo/cccc"}) || next != "" {
+		t.Fatalf("unexpected second page: %v, %q", repos, next)
+	}
+
+	// the most recently tagged repository is listed first
+	repos, _ = getCatalog(url.Values{"sort": {"modified"}, "n": {"1"}}, http.StatusOK)
+	if !reflect.DeepEqual(repos, []string{"foo/cccc"}) {
+		t.Fatalf("unexpected most recently tagged repository: %v", repos)
+	}
+	repos, _ = getCatalog(url.Values{"contains": {"aaaa"}, "modifiedsince": {time.Now().Add(-time.Hour).Format(time.RFC3339)}}, http.StatusOK)
+	if !reflect.DeepEqual(repos, []string{"bar/aaaa", "foo/aaaa"}) {
+		t.Fatalf("unexpected repositories containing aaaa: %v", repos)
+	}
+
+	for _, invalid := range []url.Values{
+		{"sort": {"size"}},
+		{"modifiedsince": {"yesterday"}},
+		{"sort": {"modified"}, "last": {"foo/aaaa"}},
+	} {
+		resp, err := http.Get(catalogURL + "?" + invalid.Encode())
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		checkResponse(t, "issuing invalid catalog query", resp, http.StatusBadRequest)
+		checkBodyHasErrorCodes(t, "invalid catalog query", resp, v2.ErrorCodeCatalogQueryInvalid)
+		resp.Body.Close()
+	}
+}
+
+func TestCatalogFilterWithoutIndex(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+	resp, err := http.Get(catalogURL + "?prefix=foo")
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "issuing filtered catalog query", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "filtered catalog query", resp, v2.ErrorCodeCatalogQueryInvalid)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// digestAlgorithmsHeader advertises the digest algorithms uploads may be
+// hashed with, as requested by the digest-algorithm parameter of the request
+// starting the upload.
+const digestAlgorithmsHeader = "Docker-Digest-Algorithms"
+
+// digestAlgorithmSupported returns whether blobs may be addressed by digests
+// of the algorithm. blake3 is only supported if enabled in the
+// configuration.
+func (app *App) digestAlgorithmSupported(alg digest.Algorithm) bool {
+	if alg == digest.BLAKE3 {
+		return app.Config.Digests.BLAKE3
+	}
+	return alg.Available()
+}
+
+// setDigestAlgorithmsHeader advertises the digest algorithms supported for
+// uploads.
+func (app *App) setDigestAlgorithmsHeader(w http.ResponseWriter) {
+	algorithms := []string{digest.SHA256.String(), digest.SHA384.String(), digest.SHA512.String()}
+	if app.Config.Digests.BLAKE3 {
+		algorithms = append(algorithms, digest.BLAKE3.String())
+	}
+	w.Header().Set(digestAlgorithmsHeader, strings.Join(algorithms, ", "))
+}
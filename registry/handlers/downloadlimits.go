@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/errcode"
+	"golang.org/x/time/rate"
+)
+
+// maxIdleLimitedRepositories is the number of repositories a download limit
+// tracks before forgetting those which are idle.
+const maxIdleLimitedRepositories = 1024
+
+// downloadLimit caps the concurrent downloads and the aggregate egress of
+// each repository it matches.
+type downloadLimit struct {
+	repositories  []string
+	maxConcurrent int
+	limit         rate.Limit
+	burst         int
+	retryAfter    time.Duration
+
+	mu    sync.Mutex
+	repos map[string]*repositoryDownloads
+}
+
+// repositoryDownloads tracks the downloads in flight from a repository.
+type repositoryDownloads struct {
+	inFlight int
+	egress   *rate.Limiter
+}
+
+// newDownloadLimits compiles the configured download limits.
+func newDownloadLimits(config []configuration.DownloadLimit) ([]*downloadLimit, error) {
+	limits := make([]*downloadLimit, 0, len(config))
+	for _, c := range config {
+		for _, pattern := range c.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("http.downloadlimits: repository pattern %q: %v", pattern, err)
+			}
+		}
+		if c.MaxConcurrent < 0 || c.Rate < 0 {
+			return nil, fmt.Errorf("http.downloadlimits: limits of %v must not be negative", c.Repositories)
+		}
+
+		limit := &downloadLimit{
+			repositories:  c.Repositories,
+			maxConcurrent: c.MaxConcurrent,
+			limit:         rate.Inf,
+			retryAfter:    c.RetryAfter,
+			repos:         make(map[string]*repositoryDownloads),
+		}
+		if c.Rate > 0 {
+			burst := c.Burst
+			if burst <= 0 {
+				burst = c.Rate
+			}
+			limit.limit = rate.Limit(c.Rate)
+			limit.burst = int(burst)
+		}
+		if limit.retryAfter <= 0 {
+			limit.retryAfter = time.Second
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+// matches returns whether the downloads of the repository are limited.
+func (l *downloadLimit) matches(repository string) bool {
+	if len(l.repositories) == 0 {
+		return true
+	}
+	for _, pattern := range l.repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire starts a download from the repository, returning the limiter of
+// the egress of the repository, or an error if a limit is reached. The
+// download must be released once done.
+func (l *downloadLimit) acquire(repository string) (*rate.Limiter, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	downloads, ok := l.repos[repository]
+	if !ok {
+		if len(l.repos) >= maxIdleLimitedRepositories {
+			l.forgetIdle()
+		}
+		downloads = &repositoryDownloads{egress: rate.NewLimiter(l.limit, l.burst)}
+		l.repos[repository] = downloads
+	}
+
+	if l.maxConcurrent > 0 && downloads.inFlight >= l.maxConcurrent {
+		return nil, errcode.ErrorCodeUnavailable.WithDetail(fmt.Sprintf("too many concurrent downloads from repository %s", repository))
+	}
+	// the egress is saturated while downloads in flight wait for tokens
+	if l.limit != rate.Inf && downloads.egress.Tokens() < 0 {
+		return nil, errcode.ErrorCodeTooManyRequests.WithDetail(fmt.Sprintf("egress limit of repository %s reached", repository))
+	}
+
+	downloads.inFlight++
+	return downloads.egress, nil
+}
+
+func (l *downloadLimit) release(repository string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if downloads, ok := l.repos[repository]; ok {
+		downloads.inFlight--
+	}
+}
+
+// forgetIdle forgets the repositories without downloads in flight and whose
+// token bucket is full, which are equivalent to new ones.
+func (l *downloadLimit) forgetIdle() {
+	now := time.Now()
+	for repository, downloads := range l.repos {
+		if downloads.inFlight == 0 && (l.limit == rate.Inf || downloads.egress.TokensAt(now) >= float64(l.burst)) {
+			delete(l.repos, repository)
+		}
+	}
+}
+
+// limitDownload starts a blob download from the repository of the request,
+// subject to the first download limit matching the repository. It returns the
+// response writer throttled to the egress of the repository and a function
+// ending the download, or false if the download was rejected.
+func (app *App) limitDownload(ctx *Context, w http.ResponseWriter) (http.ResponseWriter, func(), bool) {
+	repository := ctx.Repository.Named().Name()
+	for _, limit := range app.downloadLimits {
+		if !limit.matches(repository) {
+			continue
+		}
+
+		egress, err := limit.acquire(repository)
+		if err != nil {
+			dcontext.GetLogger(ctx).Warnf("rejecting blob download: %v", err)
+			seconds := int64((limit.retryAfter + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			ctx.Errors = append(ctx.Errors, err)
+			return w, nil, false
+		}
+		done := func() { limit.release(repository) }
+		if limit.limit == rate.Inf {
+			return w, done, true
+		}
+		return &throttledResponseWriter{ResponseWriter: w, ctx: ctx, limiter: egress}, done, true
+	}
+	return w, func() {}, true
+}
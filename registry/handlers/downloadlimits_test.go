@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestDownloadLimits(t *testing.T) {
+	limits, err := newDownloadLimits([]configuration.DownloadLimit{
+		{Repositories: []string{"hot/*"}, MaxConcurrent: 2},
+		{Rate: 1024},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error compiling download limits: %v", err)
+	}
+	concurrency, egress := limits[0], limits[1]
+	if !concurrency.matches("hot/app") || concurrency.matches("cold/app") || !egress.matches("cold/app") {
+		t.Fatal("unexpected repositories matched")
+	}
+
+	// repositories are limited separately
+	for i := 0; i < 2; i++ {
+		if _, err := concurrency.acquire("hot/app"); err != nil {
+			t.Fatalf("unexpected error starting download %d: %v", i, err)
+		}
+	}
+	if _, err := concurrency.acquire("hot/app"); err == nil || err.(errcode.Error).Code != errcode.ErrorCodeUnavailable {
+		t.Fatalf("unexpected error exceeding the concurrent downloads: %v", err)
+	}
+	if _, err := concurrency.acquire("hot/web"); err != nil {
+		t.Fatalf("unexpected error starting download from another repository: %v", err)
+	}
+	concurrency.release("hot/app")
+	if _, err := concurrency.acquire("hot/app"); err != nil {
+		t.Fatalf("unexpected error starting download after one ended: %v", err)
+	}
+
+	limiter, err := egress.acquire("cold/app")
+	if err != nil {
+		t.Fatalf("unexpected error starting download: %v", err)
+	}
+	w := &throttledResponseWriter{ResponseWriter: httptest.NewRecorder(), ctx: context.Background(), limiter: limiter}
+	if _, err := w.Write(bytes.Repeat([]byte("a"), 1024)); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	// a download waiting for tokens saturates the egress of the repository
+	go w.Write(bytes.Repeat([]byte("a"), 1024))
+	time.Sleep(100 * time.Millisecond)
+	if _, err := egress.acquire("cold/app"); err == nil || err.(errcode.Error).Code != errcode.ErrorCodeTooManyRequests {
+		t.Fatalf("unexpected error exceeding the egress: %v", err)
+	}
+
+	if _, err := newDownloadLimits([]configuration.DownloadLimit{{Repositories: []string{"["}}}); err == nil {
+		t.Fatal("expected an error compiling an invalid pattern")
+	}
+}
+
+func TestBlobDownloadLimited(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.DownloadLimits = []configuration.DownloadLimit{{MaxConcurrent: 1, RetryAfter: 1500 * time.Millisecond}}
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+	app := env.app
+
+	imageName, _ := reference.WithName("foo/bar")
+	content := []byte("limited")
+	dgst := digest.FromBytes(content)
+	repository, err := app.registry.Repository(env.ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	if _, err := repository.Blobs(env.ctx).Put(env.ctx, "application/octet-stream", content); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	if err != nil {
+		t.Fatalf("unexpected error building blob url: %v", err)
+	}
+
+	// the download of another client is in flight
+	if _, err := app.downloadLimits[0].acquire(imageName.Name()); err != nil {
+		t.Fatalf("unexpected error starting download: %v", err)
+	}
+	resp, err := http.Get(blobURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching blob: %v", err)
+	}
+	resp.Body.Close()
+	checkResponse(t, "fetching blob beyond the limit", resp, http.StatusServiceUnavailable)
+	checkHeaders(t, resp, http.Header{"Retry-After": []string{"2"}})
+
+	// a HEAD request is not a download
+	resp, err = http.Head(blobURL)
+	if err != nil {
+		t.Fatalf("unexpected error checking blob: %v", err)
+	}
+	resp.Body.Close()
+	checkResponse(t, "checking blob", resp, http.StatusOK)
+
+	app.downloadLimits[0].release(imageName.Name())
+	resp, err = http.Get(blobURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching blob: %v", err)
+	}
+	resp.Body.Close()
+	checkResponse(t, "fetching blob", resp, http.StatusOK)
+}
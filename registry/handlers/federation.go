@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/docker/distribution/registry/client/transport"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// federatedTransports is the number of authorized transports, one per
+// repository and set of actions, cached by each federated namespace.
+const federatedTransports = 256
+
+// federatedNamespace is a namespace prefix whose repositories are served by
+// another registry.
+type federatedNamespace struct {
+	prefix string
+	mode   string
+	remote *url.URL
+
+	// localPrefix is the path prefix the API is served under locally,
+	// without trailing slash.
+	localPrefix string
+
+	// credentials authenticate forwarded requests, if configured. The
+	// transports authorized with them are cached by scope.
+	credentials auth.CredentialStore
+	challenges  challenge.Manager
+	transports  *lru.Cache
+
+	proxy *httputil.ReverseProxy
+}
+
+// federation routes repositories to the registry serving the namespace
+// their name starts with.
+type federation struct {
+	// namespaces is sorted by decreasing length of prefix, so that the most
+	// specific prefix is matched first.
+	namespaces []*federatedNamespace
+}
+
+// newFederation returns the federation of the configured namespaces, or nil
+// if none is configured.
+func newFederation(namespaces []configuration.FederatedNamespace, localPrefix string) (*federation, error) {
+	if len(namespaces) == 0 {
+		return nil, nil
+	}
+
+	f := &federation{}
+	for _, config := range namespaces {
+		ns, err := newFederatedNamespace(config, localPrefix)
+		if err != nil {
+			return nil, err
+		}
+		f.namespaces = append(f.namespaces, ns)
+	}
+
+	sort.SliceStable(f.namespaces, func(i, j int) bool {
+		return len(f.namespaces[i].prefix) > len(f.namespaces[j].prefix)
+	})
+	return f, nil
+}
+
+func newFederatedNamespace(config configuration.FederatedNamespace, localPrefix string) (*federatedNamespace, error) {
+	if config.Prefix == "" {
+		return nil, fmt.Errorf("federation: a prefix is required")
+	}
+	remote, err := url.Parse(config.URL)
+	if err != nil || remote.Scheme == "" || remote.Host == "" {
+		return nil, fmt.Errorf("federation: invalid url for prefix %q: %q", config.Prefix, config.URL)
+	}
+	remote.Path = strings.TrimSuffix(remote.Path, "/")
+
+	ns := &federatedNamespace{
+		prefix:      config.Prefix,
+		mode:        config.Mode,
+		remote:      remote,
+		localPrefix: strings.TrimSuffix(localPrefix, "/"),
+	}
+
+	switch ns.mode {
+	case "":
+		ns.mode = configuration.FederationProxy
+	case configuration.FederationProxy, configuration.FederationRedirect:
+	default:
+		return nil, fmt.Errorf("federation: invalid mode for prefix %q: %q", config.Prefix, config.Mode)
+	}
+
+	if config.Username != "" {
+		ns.credentials = staticCredentials{username: config.Username, password: config.Password}
+		ns.challenges = challenge.NewSimpleManager()
+		ns.transports, err = lru.New(federatedTransports)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ns.proxy = &httputil.ReverseProxy{
+		Director:       ns.direct,
+		Transport:      ns,
+		ModifyResponse: ns.modifyResponse,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			dcontext.GetLogger(r.Context()).Errorf("federation: error forwarding request to %s: %v", ns.remote.Host, err)
+			_ = errcode.ServeJSON(w, errcode.ErrorCodeUnavailable.WithDetail(fmt.Sprintf("the registry serving %s is unavailable", ns.prefix)))
+		},
+	}
+	return ns, nil
+}
+
+// namespace returns the federated namespace serving the named repository,
+// or nil if it is served locally.
+func (f *federation) namespace(name string) *federatedNamespace {
+	if f == nil {
+		return nil
+	}
+	for _, ns := range f.namespaces {
+		if strings.HasPrefix(name, ns.prefix) {
+			return ns
+		}
+	}
+	return nil
+}
+
+// remoteURL returns the URL of the request on the remote registry.
+func (ns *federatedNamespace) remoteURL(u *url.URL) *url.URL {
+	remote := *u
+	remote.Scheme = ns.remote.Scheme
+	remote.Host = ns.remote.Host
+	remote.Path = ns.remote.Path + strings.TrimPrefix(u.Path, ns.localPrefix)
+	remote.RawPath = ""
+	return &remote
+}
+
+// redirect redirects the client to the remote registry, preserving the
+// method of the request.
+func (ns *federatedNamespace) redirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, ns.remoteURL(r.URL).String(), http.StatusTemporaryRedirect)
+}
+
+// direct rewrites the request to be forwarded to the remote registry.
+func (ns *federatedNamespace) direct(r *http.Request) {
+	r.URL = ns.remoteURL(r.URL)
+	r.Host = ns.remote.Host
+	if ns.credentials != nil {
+		// the credentials of the client are for this registry only
+		r.Header.Del("Authorization")
+	}
+}
+
+// modifyResponse rewrites the locations on the remote registry returned to
+// the client, such as those of blob uploads, so that the client keeps going
+// through this registry.
+func (ns *federatedNamespace) modifyResponse(resp *http.Response) error {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil
+	}
+	u, err := resp.Request.URL.Parse(location)
+	if err != nil || u.Host != ns.remote.Host || !strings.HasPrefix(u.Path, ns.remote.Path+"/") {
+		return nil
+	}
+
+	local := &url.URL{
+		Path:     ns.localPrefix + strings.TrimPrefix(u.Path, ns.remote.Path),
+		RawQuery: u.RawQuery,
+	}
+	resp.Header.Set("Location", local.String())
+	return nil
+}
+
+// RoundTrip forwards the request to the remote registry, authorizing it
+// with the configured credentials if any.
+func (ns *federatedNamespace) RoundTrip(r *http.Request) (*http.Response, error) {
+	if ns.credentials == nil {
+		return http.DefaultTransport.RoundTrip(r)
+	}
+
+	name := getName(r.Context())
+	actions := []string{"pull"}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		actions = []string{"pull", "push"}
+		if r.Method == http.MethodDelete {
+			actions = append(actions, "delete")
+		}
+	}
+
+	tr, err := ns.transport(r, name, actions)
+	if err != nil {
+		return nil, err
+	}
+	return tr.RoundTrip(r)
+}
+
+// transport returns a transport authorized for the actions on the named
+// repository of the remote registry.
+func (ns *federatedNamespace) transport(r *http.Request, name string, actions []string) (http.RoundTripper, error) {
+	key := name + ":" + strings.Join(actions, ",")
+	if tr, ok := ns.transports.Get(key); ok {
+		return tr.(http.RoundTripper), nil
+	}
+
+	if err := ns.ping(r); err != nil {
+		return nil, err
+	}
+
+	tr := transport.NewTransport(http.DefaultTransport, auth.NewAuthorizer(ns.challenges,
+		auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+			Transport:   http.DefaultTransport,
+			Credentials: ns.credentials,
+			Scopes: []auth.Scope{
+				auth.RepositoryScope{Repository: name, Actions: actions},
+			},
+		}),
+		auth.NewBasicHandler(ns.credentials)))
+	ns.transports.Add(key, tr)
+	return tr, nil
+}
+
+// ping establishes the authentication challenges of the remote registry,
+// if unknown.
+func (ns *federatedNamespace) ping(r *http.Request) error {
+	endpoint := *ns.remote
+	endpoint.Path += "/v2/"
+
+	challenges, err := ns.challenges.GetChallenges(endpoint)
+	if err != nil || len(challenges) > 0 {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return ns.challenges.AddResponse(resp)
+}
+
+// staticCredentials authenticates with a registry with fixed credentials.
+type staticCredentials struct {
+	username, password string
+}
+
+func (c staticCredentials) Basic(*url.URL) (string, string) {
+	return c.username, c.password
+}
+
+func (c staticCredentials) RefreshToken(*url.URL, string) string {
+	return ""
+}
+
+func (c staticCredentials) SetRefreshToken(*url.URL, string, string) {
+}
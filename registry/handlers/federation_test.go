@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+)
+
+func newFederationTestServer(t *testing.T, federation ...configuration.FederatedNamespace) *httptest.Server {
+	t.Helper()
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Federation: federation,
+	}
+	server := httptest.NewServer(NewApp(context.Background(), config))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func federationTestRepository(t *testing.T, server *httptest.Server, name string) distribution.Repository {
+	t.Helper()
+
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := client.NewRepository(named, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestFederationProxy(t *testing.T) {
+	backend := newFederationTestServer(t)
+	front := newFederationTestServer(t, configuration.FederatedNamespace{
+		Prefix: "remote/",
+		URL:    backend.URL,
+	})
+	ctx := context.Background()
+
+	for _, name := range []string{"remote/app", "local/app"} {
+		// pushing exercises the rewriting of upload locations
+		desc, err := federationTestRepository(t, front, name).Blobs(ctx).Put(ctx, "application/octet-stream", []byte(name))
+		if err != nil {
+			t.Fatalf("unexpected error pushing to %s: %v", name, err)
+		}
+
+		_, backendErr := federationTestRepository(t, backend, name).Blobs(ctx).Stat(ctx, desc.Digest)
+		if federated := name == "remote/app"; federated != (backendErr == nil) {
+			t.Fatalf("unexpected backend storage of %s: %v", name, backendErr)
+		}
+
+		content, err := federationTestRepository(t, front, name).Blobs(ctx).Get(ctx, desc.Digest)
+		if err != nil {
+			t.Fatalf("unexpected error pulling from %s: %v", name, err)
+		}
+		if string(content) != name {
+			t.Fatalf("unexpected content pulled from %s: %q", name, content)
+		}
+	}
+}
+
+func TestFederationRedirect(t *testing.T) {
+	front := newFederationTestServer(t, configuration.FederatedNamespace{
+		Prefix: "remote/",
+		URL:    "https://registry.example.com/mirror/",
+		Mode:   configuration.FederationRedirect,
+	})
+
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := c.Get(front.URL + "/v2/remote/app/manifests/latest?n=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("unexpected status: %d != %d", resp.StatusCode, http.StatusTemporaryRedirect)
+	}
+	expected := "https://registry.example.com/mirror/v2/remote/app/manifests/latest?n=1"
+	if location := resp.Header.Get("Location"); location != expected {
+		t.Fatalf("unexpected location: %q != %q", location, expected)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultFilesMaxLayerSize is the size of the largest layer scanned, unless
+// configured.
+const defaultFilesMaxLayerSize = 1 << 30
+
+// layerMediaTypePrefixes are the prefixes of the media types of the layers
+// which are tar archives.
+var layerMediaTypePrefixes = []string{
+	"application/vnd.docker.image.rootfs.diff.tar",
+	"application/vnd.oci.image.layer.",
+}
+
+// filesDispatcher constructs the handler listing the files of an image.
+func filesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	dgst, err := getDigest(ctx)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		})
+	}
+
+	filesHandler := &filesHandler{
+		Context: ctx,
+		Digest:  dgst,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(filesHandler.GetFiles),
+	}
+}
+
+// filesHandler handles requests for the files of an image.
+type filesHandler struct {
+	*Context
+
+	Digest digest.Digest
+}
+
+// GetFiles streams the files of the image, merged from its layers.
+func (fh *filesHandler) GetFiles(w http.ResponseWriter, r *http.Request) {
+	if !fh.App.Config.Files.Enabled {
+		fh.Errors = append(fh.Errors, errcode.ErrorCodeUnsupported.WithMessage("file listings are not enabled"))
+		return
+	}
+
+	manifests, err := fh.Repository.Manifests(fh)
+	if err != nil {
+		fh.Errors = append(fh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	manifest, err := manifests.Get(fh, fh.Digest)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			fh.Errors = append(fh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		} else {
+			fh.Errors = append(fh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	var layers []distribution.Descriptor
+	switch m := manifest.(type) {
+	case *schema2.DeserializedManifest:
+		layers = m.Layers
+	case *ocischema.DeserializedManifest:
+		layers = m.Layers
+	default:
+		fh.Errors = append(fh.Errors, errcode.ErrorCodeUnsupported.WithMessage("file listings are only available for image manifests"))
+		return
+	}
+
+	listings := make([][]storage.LayerFile, 0, len(layers))
+	skipped := make([]digest.Digest, 0)
+	for _, layer := range layers {
+		files, ok, err := fh.layerFiles(layer)
+		if err != nil {
+			fh.Errors = append(fh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if !ok {
+			skipped = append(skipped, layer.Digest)
+			continue
+		}
+		listings = append(listings, files)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeFiles(w, fh.Repository.Named().Name(), fh.Digest, storage.MergeLayerFiles(listings...), skipped); err != nil {
+		dcontext.GetLogger(fh).Errorf("error writing file listing: %v", err)
+	}
+}
+
+// layerFiles returns the files of the layer, scanning it unless they are
+// cached, or false if the layer is skipped.
+func (fh *filesHandler) layerFiles(layer distribution.Descriptor) ([]storage.LayerFile, bool, error) {
+	if !isLayerMediaType(layer.MediaType) {
+		return nil, false, nil
+	}
+	maxSize := fh.App.Config.Files.MaxLayerSize
+	if maxSize <= 0 {
+		maxSize = defaultFilesMaxLayerSize
+	}
+	if layer.Size > maxSize {
+		return nil, false, nil
+	}
+
+	store := storage.NewLayerFileStore(fh.App.driver)
+	files, ok, err := store.Get(fh, layer.Digest)
+	if err != nil || ok {
+		return files, ok, err
+	}
+
+	blobs := fh.Repository.Blobs(fh)
+	rc, err := blobs.Open(fh, layer.Digest)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			// foreign and non-distributable layers are not stored
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer rc.Close()
+
+	files, err = storage.ScanLayerFiles(layer.Digest, rc)
+	if err != nil {
+		dcontext.GetLogger(fh).Warnf("skipping layer %s which is not a valid tar archive: %v", layer.Digest, err)
+		return nil, false, nil
+	}
+	if err := store.Put(fh, layer.Digest, files); err != nil {
+		dcontext.GetLogger(fh).Errorf("error caching the files of layer %s: %v", layer.Digest, err)
+	}
+	return files, true, nil
+}
+
+// isLayerMediaType returns whether the media type is that of a tar archive
+// layer.
+func isLayerMediaType(mediaType string) bool {
+	for _, prefix := range layerMediaTypePrefixes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFiles writes the JSON listing of the files of an image, encoding the
+// files one at a time so that the listings of large images are not buffered.
+func writeFiles(w http.ResponseWriter, name string, dgst digest.Digest, files []storage.LayerFile, skipped []digest.Digest) error {
+	header, err := json.Marshal(struct {
+		Name   string        `json:"name"`
+		Digest digest.Digest `json:"digest"`
+	}{name, dgst})
+	if err != nil {
+		return err
+	}
+	// open the object for the files to follow
+	if _, err := w.Write(append(header[:len(header)-1], `,"files":[`...)); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for i, file := range files {
+		if i > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(file); err != nil {
+			return err
+		}
+	}
+
+	trailer, err := json.Marshal(skipped)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(append([]byte(`],"skipped":`), trailer...), "}\n"...))
+	return err
+}
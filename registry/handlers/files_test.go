@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tarLayer returns a gzipped tar archive of the files, mapping their names
+// to their contents.
+func tarLayer(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for i := 0; i < len(files); i += 2 {
+		if err := tw.WriteHeader(&tar.Header{Name: files[i], Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[i+1]))}); err != nil {
+			t.Fatalf("unexpected error writing header: %v", err)
+		}
+		if _, err := tw.Write([]byte(files[i+1])); err != nil {
+			t.Fatalf("unexpected error writing file: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error closing tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("unexpected error closing gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestFilesAPI(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Files.Enabled = true
+	config.Validation.Manifests.URLs.Allow = []string{"^https://example\\.com/"}
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	base := pushBlob(t, env, imageName, v1.MediaTypeImageLayerGzip, tarLayer(t, "etc/os-release", "alpine", "etc/shadow", "root"))
+	top := pushBlob(t, env, imageName, v1.MediaTypeImageLayerGzip, tarLayer(t, "etc/.wh.shadow", "", "app/main", "binary"))
+	foreign := distribution.Descriptor{MediaType: v1.MediaTypeImageLayerNonDistributableGzip, Digest: digest.FromString("foreign"), Size: 6, URLs: []string{"https://example.com/layer"}}
+	image := pushOCIManifest(t, env, imageName, ocischema.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+		Config:    pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux"}`)),
+		Layers:    []distribution.Descriptor{base, foreign, top},
+	}, "latest")
+
+	filesURL, err := env.builder.BuildFilesURL(mustWithDigest(t, imageName, image))
+	if err != nil {
+		t.Fatalf("unexpected error building files url: %v", err)
+	}
+	resp, err := http.Get(filesURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching files: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching files", resp, http.StatusOK)
+
+	var body struct {
+		Name    string              `json:"name"`
+		Digest  digest.Digest       `json:"digest"`
+		Files   []storage.LayerFile `json:"files"`
+		Skipped []digest.Digest     `json:"skipped"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("error decoding files response: %v", err)
+	}
+	if body.Name != "foo/bar" || body.Digest != image {
+		t.Fatalf("unexpected image listed: %s@%s", body.Name, body.Digest)
+	}
+	if len(body.Skipped) != 1 || body.Skipped[0] != foreign.Digest {
+		t.Fatalf("unexpected layers skipped: %v", body.Skipped)
+	}
+	expected := []storage.LayerFile{
+		{Path: "/app/main", Type: storage.LayerFileTypeFile, Size: 6, Mode: 0644, Layer: top.Digest},
+		{Path: "/etc/os-release", Type: storage.LayerFileTypeFile, Size: 6, Mode: 0644, Layer: base.Digest},
+	}
+	if len(body.Files) != len(expected) {
+		t.Fatalf("unexpected files: %+v", body.Files)
+	}
+	for i, file := range expected {
+		if body.Files[i] != file {
+			t.Errorf("unexpected file: %+v != %+v", body.Files[i], file)
+		}
+	}
+
+	// the files of each layer are cached
+	if _, ok, err := storage.NewLayerFileStore(env.app.driver).Get(env.ctx, base.Digest); err != nil || !ok {
+		t.Fatalf("expected the files of the layer to be cached: %v, %v", ok, err)
+	}
+
+	filesURL, err = env.builder.BuildFilesURL(mustWithDigest(t, imageName, digest.FromString("unknown")))
+	if err != nil {
+		t.Fatalf("unexpected error building files url: %v", err)
+	}
+	resp, err = http.Get(filesURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching files: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching files of an unknown manifest", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "fetching files of an unknown manifest", resp, v2.ErrorCodeManifestUnknown)
+
+	env.app.Config.Files.Enabled = false
+	resp, err = http.Get(filesURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching files: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching files when disabled", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "fetching files when disabled", resp, errcode.ErrorCodeUnsupported)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+)
+
+// defaultGCGracePeriod is how long blobs and manifests are kept after they
+// were last referenced by an online garbage collection.
+const defaultGCGracePeriod = time.Hour
+
+// startGarbageCollector schedules a goroutine which periodically collects
+// garbage while the registry serves requests. A collection is skipped when
+// one triggered through the admin API is still running.
+func (app *App) startGarbageCollector(config configuration.GC) {
+	if !config.Enabled || config.Interval <= 0 {
+		return
+	}
+
+	req := gcRequest{
+		DryRun:         config.DryRun,
+		RemoveUntagged: config.RemoveUntagged,
+	}
+
+	log := dcontext.GetLogger(app)
+	log.Infof("gc: collecting garbage every %s", config.Interval)
+
+	app.goBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !app.gc.begin(req) {
+				log.Infof("gc: skipping garbage collection, one is already running")
+				continue
+			}
+			start := time.Now()
+			err := app.collectGarbage(ctx, req, nil)
+			app.gc.end(err)
+			if err != nil {
+				log.Errorf("gc: error collecting garbage: %v", err)
+				continue
+			}
+			log.Infof("gc: collected garbage in %s", time.Since(start))
+		}
+	})
+}
@@ -0,0 +1,654 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/gorilla/handlers"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// defaultGraphQLMaxDepth is the deepest nesting of fields a GraphQL query may
+// select, unless configured.
+const defaultGraphQLMaxDepth = 12
+
+// maxGraphQLRequestSize is the size of the largest GraphQL request body.
+const maxGraphQLRequestSize = 1 << 20
+
+// graphQLSchema is the schema of the GraphQL API.
+const graphQLSchema = `
+schema {
+	query: Query
+}
+
+# Int64 is a 64-bit integer, such as the size of a blob.
+scalar Int64
+
+type Query {
+	# Lists the repositories of the registry in lexical order, after the
+	# given repository. Requires access to the catalog.
+	repositories(first: Int, after: String): [Repository!]!
+	# Returns the repository with the given name. Requires pull access to it.
+	repository(name: String!): Repository
+}
+
+type Repository {
+	name: String!
+	# Lists the tags of the repository in lexical order, after the given tag.
+	tags(first: Int, after: String): [Tag!]!
+	tag(name: String!): Tag
+	# Returns the manifest with the given tag or digest.
+	manifest(reference: String!): Manifest
+}
+
+type Tag {
+	name: String!
+	digest: String!
+	manifest: Manifest
+}
+
+type Manifest {
+	digest: String!
+	mediaType: String!
+	artifactType: String
+	# The size of the manifest itself.
+	size: Int64!
+	config: Descriptor
+	layers: [Descriptor!]!
+	# The entries of an image index or manifest list.
+	manifests: [Descriptor!]!
+	subject: Descriptor
+	annotations: [Annotation!]!
+	# Lists the manifests of the repository whose subject is this manifest.
+	referrers(artifactType: String): [Manifest!]!
+	payload: String!
+}
+
+type Descriptor {
+	digest: String!
+	mediaType: String!
+	size: Int64!
+	annotations: [Annotation!]!
+	platform: Platform
+	# The manifest the descriptor points to, for entries of an image index
+	# or the subject of a manifest.
+	manifest: Manifest
+}
+
+type Platform {
+	architecture: String!
+	os: String!
+	osVersion: String
+	variant: String
+}
+
+type Annotation {
+	key: String!
+	value: String!
+}
+`
+
+// newGraphQLSchema parses the schema of the GraphQL API, resolving queries
+// against the registry of app.
+func newGraphQLSchema(app *App) (*graphql.Schema, error) {
+	maxDepth := app.Config.GraphQL.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultGraphQLMaxDepth
+	}
+	return graphql.ParseSchema(graphQLSchema, &graphQLResolver{app: app}, graphql.MaxDepth(maxDepth))
+}
+
+// graphQLDispatcher constructs the GraphQL api endpoint.
+func graphQLDispatcher(ctx *Context, r *http.Request) http.Handler {
+	graphQLHandler := &graphQLHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet:  http.HandlerFunc(graphQLHandler.Query),
+		http.MethodPost: http.HandlerFunc(graphQLHandler.Query),
+	}
+}
+
+// graphQLHandler handles GraphQL queries over the metadata of the registry.
+type graphQLHandler struct {
+	*Context
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Query executes a GraphQL query passed in the query string or the body of
+// the request. The request has been authenticated, but access to each
+// repository is authorized as the query selects it.
+func (gh *graphQLHandler) Query(w http.ResponseWriter, r *http.Request) {
+	if gh.App.graphQLSchema == nil {
+		gh.Errors = append(gh.Errors, errcode.ErrorCodeUnsupported.WithMessage("the GraphQL API is not enabled"))
+		return
+	}
+
+	var req graphQLRequest
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxGraphQLRequestSize+1))
+		if err != nil {
+			gh.Errors = append(gh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if len(body) > maxGraphQLRequestSize {
+			gh.Errors = append(gh.Errors, v2.ErrorCodeGraphQLRequestInvalid.WithDetail("request body too large"))
+			return
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			gh.Errors = append(gh.Errors, v2.ErrorCodeGraphQLRequestInvalid.WithDetail(err.Error()))
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if variables := q.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				gh.Errors = append(gh.Errors, v2.ErrorCodeGraphQLRequestInvalid.WithDetail(err.Error()))
+				return
+			}
+		}
+	}
+	if req.Query == "" {
+		gh.Errors = append(gh.Errors, v2.ErrorCodeGraphQLRequestInvalid.WithDetail("query is required"))
+		return
+	}
+
+	response := gh.App.graphQLSchema.Exec(gh, req.Query, req.OperationName, req.Variables)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(response); err != nil {
+		gh.Errors = append(gh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// graphQLInt64 is the Int64 scalar of the GraphQL schema, as sizes do not fit
+// the 32-bit Int scalar.
+type graphQLInt64 int64
+
+// ImplementsGraphQLType maps the type to the Int64 scalar.
+func (graphQLInt64) ImplementsGraphQLType(name string) bool {
+	return name == "Int64"
+}
+
+// UnmarshalGraphQL decodes an Int64 argument.
+func (i *graphQLInt64) UnmarshalGraphQL(input interface{}) error {
+	switch input := input.(type) {
+	case int32:
+		*i = graphQLInt64(input)
+	case int64:
+		*i = graphQLInt64(input)
+	case float64:
+		*i = graphQLInt64(input)
+	default:
+		return fmt.Errorf("invalid Int64 value %v", input)
+	}
+	return nil
+}
+
+// graphQLResolver resolves the root query of the GraphQL API.
+type graphQLResolver struct {
+	app *App
+}
+
+// authorize checks the access of the request being resolved. Unlike the
+// registry API, denied access fails the selected field only.
+func (gr *graphQLResolver) authorize(ctx context.Context, access ...auth.Access) error {
+	if gr.app.accessController == nil {
+		return nil
+	}
+	if _, err := gr.app.accessController.Authorized(ctx, access...); err != nil {
+		if _, ok := err.(auth.Challenge); ok {
+			return errcode.ErrorCodeDenied
+		}
+		return err
+	}
+	return nil
+}
+
+type graphQLPageArgs struct {
+	First *int32
+	After *string
+}
+
+// limit returns the number of entries requested, capped at max.
+func (args graphQLPageArgs) limit(max int) int {
+	n := defaultReturnedEntries
+	if args.First != nil {
+		n = int(*args.First)
+	}
+	if n < 0 {
+		n = 0
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	return n
+}
+
+func (args graphQLPageArgs) after() string {
+	if args.After == nil {
+		return ""
+	}
+	return *args.After
+}
+
+func (gr *graphQLResolver) Repositories(ctx context.Context, args graphQLPageArgs) ([]*graphQLRepository, error) {
+	if err := gr.authorize(ctx, auth.Access{
+		Resource: auth.Resource{Type: "registry", Name: "catalog"},
+		Action:   "*",
+	}); err != nil {
+		return nil, err
+	}
+
+	n := args.limit(gr.app.Config.Catalog.MaxEntries)
+	if n == 0 {
+		return nil, nil
+	}
+	names := make([]string, n)
+	filled, err := gr.app.registry.Repositories(ctx, names, args.after())
+	if err != nil {
+		var pathNotFound driver.PathNotFoundError
+		if err != io.EOF && !errors.As(err, &pathNotFound) {
+			return nil, err
+		}
+	}
+
+	repositories := make([]*graphQLRepository, 0, filled)
+	for _, name := range names[:filled] {
+		named, err := reference.WithName(name)
+		if err != nil {
+			return nil, err
+		}
+		repositories = append(repositories, &graphQLRepository{resolver: gr, named: named})
+	}
+	return repositories, nil
+}
+
+func (gr *graphQLResolver) Repository(ctx context.Context, args struct{ Name string }) (*graphQLRepository, error) {
+	named, err := reference.WithName(args.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &graphQLRepository{resolver: gr, named: named}, nil
+}
+
+// graphQLRepository resolves a repository. Pull access to the repository is
+// authorized when its tags or manifests are selected, so that repositories
+// can be listed with access to the catalog only.
+type graphQLRepository struct {
+	resolver *graphQLResolver
+	named    reference.Named
+}
+
+func (r *graphQLRepository) Name() string {
+	return r.named.Name()
+}
+
+func (r *graphQLRepository) repository(ctx context.Context) (distribution.Repository, error) {
+	if err := r.resolver.authorize(ctx, appendAccessRecords(nil, http.MethodGet, r.named.Name())...); err != nil {
+		return nil, err
+	}
+	return r.resolver.app.registry.Repository(ctx, r.named)
+}
+
+func (r *graphQLRepository) Tags(ctx context.Context, args graphQLPageArgs) ([]*graphQLTag, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Strings(tags)
+
+	after := args.after()
+	start := sort.Search(len(tags), func(i int) bool { return tags[i] > after })
+	tags = tags[start:]
+	if n := args.limit(0); n < len(tags) {
+		tags = tags[:n]
+	}
+
+	results := make([]*graphQLTag, 0, len(tags))
+	for _, tag := range tags {
+		result, err := r.tag(ctx, repo, tag)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func (r *graphQLRepository) Tag(ctx context.Context, args struct{ Name string }) (*graphQLTag, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.tag(ctx, repo, args.Name)
+}
+
+// tag resolves the tag with the given name, or nil if it does not exist.
+func (r *graphQLRepository) tag(ctx context.Context, repo distribution.Repository, tag string) (*graphQLTag, error) {
+	desc, err := repo.Tags(ctx).Get(ctx, tag)
+	if err != nil {
+		switch err.(type) {
+		case distribution.ErrTagUnknown, distribution.ErrRepositoryUnknown:
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &graphQLTag{repository: r, name: tag, digest: desc.Digest}, nil
+}
+
+func (r *graphQLRepository) Manifest(ctx context.Context, args struct{ Reference string }) (*graphQLManifest, error) {
+	dgst, err := digest.Parse(args.Reference)
+	if err != nil {
+		repo, err := r.repository(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := r.tag(ctx, repo, args.Reference)
+		if err != nil || tag == nil {
+			return nil, err
+		}
+		dgst = tag.digest
+	}
+	return r.manifest(ctx, dgst)
+}
+
+// manifest resolves the manifest with the given digest, or nil if it does
+// not exist in the repository.
+func (r *graphQLRepository) manifest(ctx context.Context, dgst digest.Digest) (*graphQLManifest, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		return nil, err
+	}
+	return &graphQLManifest{
+		repository: r,
+		manifests:  manifests,
+		digest:     dgst,
+		mediaType:  mediaType,
+		payload:    payload,
+		manifest:   m,
+	}, nil
+}
+
+type graphQLTag struct {
+	repository *graphQLRepository
+	name       string
+	digest     digest.Digest
+}
+
+func (t *graphQLTag) Name() string {
+	return t.name
+}
+
+func (t *graphQLTag) Digest() string {
+	return t.digest.String()
+}
+
+func (t *graphQLTag) Manifest(ctx context.Context) (*graphQLManifest, error) {
+	return t.repository.manifest(ctx, t.digest)
+}
+
+type graphQLManifest struct {
+	repository *graphQLRepository
+	manifests  distribution.ManifestService
+	digest     digest.Digest
+	mediaType  string
+	payload    []byte
+	manifest   distribution.Manifest
+}
+
+func (m *graphQLManifest) Digest() string {
+	return m.digest.String()
+}
+
+func (m *graphQLManifest) MediaType() string {
+	return m.mediaType
+}
+
+func (m *graphQLManifest) ArtifactType() *string {
+	if oci, ok := m.manifest.(*ocischema.DeserializedManifest); ok && oci.ArtifactType != "" {
+		return &oci.ArtifactType
+	}
+	return nil
+}
+
+func (m *graphQLManifest) Size() graphQLInt64 {
+	return graphQLInt64(len(m.payload))
+}
+
+func (m *graphQLManifest) Config() *graphQLDescriptor {
+	switch manifest := m.manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		return m.descriptor(manifest.Config)
+	case *schema2.DeserializedManifest:
+		return m.descriptor(manifest.Config)
+	}
+	return nil
+}
+
+func (m *graphQLManifest) Layers() []*graphQLDescriptor {
+	var layers []distribution.Descriptor
+	switch manifest := m.manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		layers = manifest.Layers
+	case *schema2.DeserializedManifest:
+		layers = manifest.Layers
+	case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+	default:
+		layers = manifest.References()
+	}
+	return m.descriptors(layers)
+}
+
+func (m *graphQLManifest) Manifests() []*graphQLDescriptor {
+	switch manifest := m.manifest.(type) {
+	case *ocischema.DeserializedImageIndex:
+		return m.descriptors(manifest.Manifests)
+	case *manifestlist.DeserializedManifestList:
+		descriptors := make([]*graphQLDescriptor, 0, len(manifest.Manifests))
+		for _, entry := range manifest.Manifests {
+			desc := entry.Descriptor
+			desc.Platform = &v1.Platform{
+				Architecture: entry.Platform.Architecture,
+				OS:           entry.Platform.OS,
+				OSVersion:    entry.Platform.OSVersion,
+				Variant:      entry.Platform.Variant,
+			}
+			descriptors = append(descriptors, m.descriptor(desc))
+		}
+		return descriptors
+	}
+	return []*graphQLDescriptor{}
+}
+
+func (m *graphQLManifest) Subject() *graphQLDescriptor {
+	if oci, ok := m.manifest.(*ocischema.DeserializedManifest); ok && oci.Subject != nil {
+		return m.descriptor(*oci.Subject)
+	}
+	return nil
+}
+
+func (m *graphQLManifest) Annotations() []graphQLAnnotation {
+	switch manifest := m.manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		return graphQLAnnotations(manifest.Annotations)
+	case *ocischema.DeserializedImageIndex:
+		return graphQLAnnotations(manifest.Annotations)
+	}
+	return []graphQLAnnotation{}
+}
+
+func (m *graphQLManifest) Referrers(ctx context.Context, args struct{ ArtifactType *string }) ([]*graphQLManifest, error) {
+	found, err := referrers(ctx, m.manifests, m.digest)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*graphQLManifest, 0, len(found))
+	for _, ref := range found {
+		if args.ArtifactType != nil && ref.ArtifactType != *args.ArtifactType {
+			continue
+		}
+		result, err := m.repository.manifest(ctx, ref.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func (m *graphQLManifest) Payload() string {
+	return string(m.payload)
+}
+
+func (m *graphQLManifest) descriptor(desc distribution.Descriptor) *graphQLDescriptor {
+	return &graphQLDescriptor{repository: m.repository, desc: desc}
+}
+
+func (m *graphQLManifest) descriptors(descs []distribution.Descriptor) []*graphQLDescriptor {
+	descriptors := make([]*graphQLDescriptor, 0, len(descs))
+	for _, desc := range descs {
+		descriptors = append(descriptors, m.descriptor(desc))
+	}
+	return descriptors
+}
+
+type graphQLDescriptor struct {
+	repository *graphQLRepository
+	desc       distribution.Descriptor
+}
+
+func (d *graphQLDescriptor) Digest() string {
+	return d.desc.Digest.String()
+}
+
+func (d *graphQLDescriptor) MediaType() string {
+	return d.desc.MediaType
+}
+
+func (d *graphQLDescriptor) Size() graphQLInt64 {
+	return graphQLInt64(d.desc.Size)
+}
+
+func (d *graphQLDescriptor) Annotations() []graphQLAnnotation {
+	return graphQLAnnotations(d.desc.Annotations)
+}
+
+func (d *graphQLDescriptor) Platform() *graphQLPlatform {
+	if d.desc.Platform == nil {
+		return nil
+	}
+	return &graphQLPlatform{platform: d.desc.Platform}
+}
+
+// Manifest resolves the manifest the descriptor points to, or nil if the
+// descriptor points to a blob or the manifest does not exist.
+func (d *graphQLDescriptor) Manifest(ctx context.Context) (*graphQLManifest, error) {
+	if !isManifestMediaType(d.desc.MediaType) {
+		return nil, nil
+	}
+	return d.repository.manifest(ctx, d.desc.Digest)
+}
+
+type graphQLPlatform struct {
+	platform *v1.Platform
+}
+
+func (p *graphQLPlatform) Architecture() string {
+	return p.platform.Architecture
+}
+
+func (p *graphQLPlatform) Os() string {
+	return p.platform.OS
+}
+
+func (p *graphQLPlatform) OsVersion() *string {
+	if p.platform.OSVersion == "" {
+		return nil
+	}
+	return &p.platform.OSVersion
+}
+
+func (p *graphQLPlatform) Variant() *string {
+	if p.platform.Variant == "" {
+		return nil
+	}
+	return &p.platform.Variant
+}
+
+type graphQLAnnotation struct {
+	key, value string
+}
+
+func (a graphQLAnnotation) Key() string {
+	return a.key
+}
+
+func (a graphQLAnnotation) Value() string {
+	return a.value
+}
+
+// graphQLAnnotations lists annotations sorted by key.
+func graphQLAnnotations(annotations map[string]string) []graphQLAnnotation {
+	results := make([]graphQLAnnotation, 0, len(annotations))
+	for key, value := range annotations {
+		results = append(results, graphQLAnnotation{key: key, value: value})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].key < results[j].key
+	})
+	return results
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestGraphQLAPI(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.GraphQL.Enabled = true
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	versioned := manifest.Versioned{
+		SchemaVersion: 2,
+		MediaType:     v1.MediaTypeImageManifest,
+	}
+	layer := pushBlob(t, env, imageName, v1.MediaTypeImageLayer, []byte("layer"))
+	image := pushOCIManifest(t, env, imageName, ocischema.Manifest{
+		Versioned:   versioned,
+		Config:      pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux"}`)),
+		Layers:      []distribution.Descriptor{layer},
+		Annotations: map[string]string{"org.opencontainers.image.title": "bar"},
+	}, "")
+	signature := pushOCIManifest(t, env, imageName, ocischema.Manifest{
+		Versioned:    versioned,
+		ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+		Config:       pushBlob(t, env, imageName, "application/vnd.oci.empty.v1+json", []byte("{}")),
+		Layers:       []distribution.Descriptor{},
+		Subject:      &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: image},
+	}, "")
+
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{{
+		MediaType: v1.MediaTypeImageManifest,
+		Digest:    image,
+		Size:      1,
+		Platform:  &v1.Platform{Architecture: "amd64", OS: "linux"},
+	}}, nil)
+	if err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp := putManifest(t, "putting index", manifestURL, v1.MediaTypeImageIndex, index)
+	defer resp.Body.Close()
+	checkResponse(t, "putting index", resp, http.StatusCreated)
+
+	graphQLURL, err := env.builder.BuildGraphQLURL()
+	if err != nil {
+		t.Fatalf("unexpected error building graphql url: %v", err)
+	}
+
+	query := `query($name: String!) {
+		repositories { name }
+		repository(name: $name) {
+			tags {
+				name
+				manifest {
+					mediaType
+					manifests {
+						platform { architecture os }
+						manifest {
+							digest
+							layers { digest size }
+							annotations { key value }
+							referrers(artifactType: "application/vnd.dev.cosign.artifact.sig.v1+json") {
+								digest
+								subject { digest }
+							}
+						}
+					}
+				}
+			}
+			unknown: manifest(reference: "unknown") { digest }
+		}
+	}`
+	body, _ := json.Marshal(graphQLRequest{Query: query, Variables: map[string]interface{}{"name": "foo/bar"}})
+	resp, err = http.Post(graphQLURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error querying: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "querying", resp, http.StatusOK)
+
+	type descriptor struct {
+		Digest string
+		Size   int64
+	}
+	var result struct {
+		Errors []interface{}
+		Data   struct {
+			Repositories []struct{ Name string }
+			Repository   struct {
+				Tags []struct {
+					Name     string
+					Manifest struct {
+						MediaType string
+						Manifests []struct {
+							Platform struct{ Architecture, OS string }
+							Manifest struct {
+								Digest      string
+								Layers      []descriptor
+								Annotations []struct{ Key, Value string }
+								Referrers   []struct {
+									Digest  string
+									Subject descriptor
+								}
+							}
+						}
+					}
+				}
+				Unknown *struct{ Digest string }
+			}
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if len(result.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+
+	data := result.Data
+	if len(data.Repositories) != 1 || data.Repositories[0].Name != "foo/bar" {
+		t.Errorf("unexpected repositories: %+v", data.Repositories)
+	}
+	if len(data.Repository.Tags) != 1 || data.Repository.Tags[0].Name != "latest" || data.Repository.Tags[0].Manifest.MediaType != v1.MediaTypeImageIndex {
+		t.Fatalf("unexpected tags: %+v", data.Repository.Tags)
+	}
+	entries := data.Repository.Tags[0].Manifest.Manifests
+	if len(entries) != 1 || entries[0].Platform.Architecture != "amd64" || entries[0].Platform.OS != "linux" {
+		t.Fatalf("unexpected index entries: %+v", entries)
+	}
+	child := entries[0].Manifest
+	if child.Digest != image.String() || len(child.Layers) != 1 || child.Layers[0].Digest != layer.Digest.String() || child.Layers[0].Size != layer.Size {
+		t.Errorf("unexpected image: %+v", child)
+	}
+	if len(child.Annotations) != 1 || child.Annotations[0].Key != "org.opencontainers.image.title" || child.Annotations[0].Value != "bar" {
+		t.Errorf("unexpected annotations: %+v", child.Annotations)
+	}
+	if len(child.Referrers) != 1 || child.Referrers[0].Digest != signature.String() || child.Referrers[0].Subject.Digest != image.String() {
+		t.Errorf("unexpected referrers: %+v", child.Referrers)
+	}
+	if data.Repository.Unknown != nil {
+		t.Errorf("unexpected manifest for unknown tag: %+v", data.Repository.Unknown)
+	}
+
+	// queries can also be passed in the query string
+	graphQLURL, err = env.builder.BuildGraphQLURL(url.Values{"query": {`{ repository(name: "foo/bar") { tag(name: "latest") { digest } } }`}})
+	if err != nil {
+		t.Fatalf("unexpected error building graphql url: %v", err)
+	}
+	resp, err = http.Get(graphQLURL)
+	if err != nil {
+		t.Fatalf("unexpected error querying: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "querying with GET", resp, http.StatusOK)
+
+	resp, err = http.Post(graphQLURL, "application/json", bytes.NewReader([]byte("{")))
+	if err != nil {
+		t.Fatalf("unexpected error querying: %v", err)
+	}
+	defer resp.Body.Close()
+	checkBodyHasErrorCodes(t, "invalid request", resp, v2.ErrorCodeGraphQLRequestInvalid)
+}
+
+func TestGraphQLAPIDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	graphQLURL, err := env.builder.BuildGraphQLURL(url.Values{"query": {"{ repositories { name } }"}})
+	if err != nil {
+		t.Fatalf("unexpected error building graphql url: %v", err)
+	}
+	resp, err := http.Get(graphQLURL)
+	if err != nil {
+		t.Fatalf("unexpected error querying: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "querying", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "querying", resp, errcode.ErrorCodeUnsupported)
+}
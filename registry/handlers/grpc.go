@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/rpc"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/opencontainers/go-digest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcBlobChunkSize is the size of the chunks blobs are streamed in, well
+// below the default maximum message size of gRPC.
+const grpcBlobChunkSize = 256 << 10
+
+// RegisterGRPC registers the gRPC API of the registry on server. Requests are
+// authorized by the access controller of the app, like requests to the HTTP
+// API.
+func (app *App) RegisterGRPC(server *grpc.Server) {
+	rpc.RegisterRegistryServer(server, &grpcRegistry{app: app})
+}
+
+// grpcRegistry implements the gRPC API over the registry of the app.
+type grpcRegistry struct {
+	rpc.UnimplementedRegistryServer
+	app *App
+}
+
+// repository authorizes pull access to the named repository and returns it.
+func (gr *grpcRegistry) repository(ctx context.Context, name string) (context.Context, distribution.Repository, error) {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "invalid repository name: %v", err)
+	}
+
+	// the access controllers read the credentials from the HTTP request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return nil, nil, status.Error(codes.Internal, err.Error())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, value := range md.Get("authorization") {
+			req.Header.Add("Authorization", value)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+	}
+	ctx = dcontext.WithRequest(ctx, req)
+
+	if gr.app.accessController != nil {
+		ctx, err = gr.app.accessController.Authorized(ctx, appendAccessRecords(nil, http.MethodGet, named.Name())...)
+		if err != nil {
+			if _, ok := err.(auth.Challenge); ok {
+				return nil, nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			dcontext.GetLogger(req.Context()).Errorf("error checking authorization of gRPC request: %v", err)
+			return nil, nil, status.Error(codes.PermissionDenied, "error checking authorization")
+		}
+	}
+
+	repo, err := gr.app.registry.Repository(ctx, named)
+	if err != nil {
+		return nil, nil, grpcError(err)
+	}
+	return ctx, repo, nil
+}
+
+// ResolveTag returns the descriptor of the manifest a tag points to.
+func (gr *grpcRegistry) ResolveTag(ctx context.Context, req *rpc.ResolveTagRequest) (*rpc.Descriptor, error) {
+	ctx, repo, err := gr.repository(ctx, req.Repository)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, req.Tag)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcDescriptor(desc), nil
+}
+
+// StatBlob returns the descriptor of a blob of a repository.
+func (gr *grpcRegistry) StatBlob(ctx context.Context, req *rpc.StatBlobRequest) (*rpc.Descriptor, error) {
+	dgst, err := digest.Parse(req.Digest)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid digest: %v", err)
+	}
+	ctx, repo, err := gr.repository(ctx, req.Repository)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := repo.Blobs(ctx).Stat(ctx, dgst)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcDescriptor(desc), nil
+}
+
+// ListTags lists the tags of a repository in lexical order.
+func (gr *grpcRegistry) ListTags(ctx context.Context, req *rpc.ListTagsRequest) (*rpc.ListTagsResponse, error) {
+	if req.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page size")
+	}
+	ctx, repo, err := gr.repository(ctx, req.Repository)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	sort.Strings(tags)
+
+	start := sort.Search(len(tags), func(i int) bool { return tags[i] > req.Last })
+	tags = tags[start:]
+
+	response := &rpc.ListTagsResponse{Tags: tags}
+	if req.PageSize > 0 && int(req.PageSize) < len(tags) {
+		response.Tags = tags[:req.PageSize]
+		response.More = true
+	}
+	return response, nil
+}
+
+// GetBlob streams the content of a blob of a repository, starting at the
+// requested offset. The first chunk carries the descriptor of the blob.
+func (gr *grpcRegistry) GetBlob(req *rpc.GetBlobRequest, stream rpc.Registry_GetBlobServer) error {
+	dgst, err := digest.Parse(req.Digest)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid digest: %v", err)
+	}
+	ctx, repo, err := gr.repository(stream.Context(), req.Repository)
+	if err != nil {
+		return err
+	}
+
+	blobs := repo.Blobs(ctx)
+	desc, err := blobs.Stat(ctx, dgst)
+	if err != nil {
+		return grpcError(err)
+	}
+	if req.Offset < 0 || req.Offset > desc.Size {
+		return status.Errorf(codes.OutOfRange, "offset %d is outside of the blob of size %d", req.Offset, desc.Size)
+	}
+
+	rc, err := blobs.Open(ctx, dgst)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer rc.Close()
+	if _, err := rc.Seek(req.Offset, io.SeekStart); err != nil {
+		return grpcError(err)
+	}
+
+	chunk := &rpc.BlobChunk{Blob: grpcDescriptor(desc)}
+	buf := make([]byte, grpcBlobChunkSize)
+	for {
+		n, err := io.ReadFull(rc, buf)
+		if n > 0 || chunk.Blob != nil {
+			chunk.Data = buf[:n]
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			chunk = &rpc.BlobChunk{}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return grpcError(err)
+		}
+	}
+}
+
+func grpcDescriptor(desc distribution.Descriptor) *rpc.Descriptor {
+	return &rpc.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest.String(),
+		Size:      desc.Size,
+	}
+}
+
+// grpcError maps the errors of the registry to gRPC status errors.
+func grpcError(err error) error {
+	switch err.(type) {
+	case distribution.ErrTagUnknown, distribution.ErrRepositoryUnknown, distribution.ErrManifestUnknownRevision:
+		return status.Error(codes.NotFound, err.Error())
+	case distribution.ErrRepositoryNameInvalid:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, distribution.ErrBlobUnknown) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/rpc"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newGRPCClient serves the gRPC API of app and returns a client of it.
+func newGRPCClient(t *testing.T, app *App) (rpc.RegistryClient, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	server := grpc.NewServer()
+	app.RegisterGRPC(server)
+	go server.Serve(ln)
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	return rpc.NewRegistryClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestGRPCAPI(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	content := bytes.Repeat([]byte("layer"), grpcBlobChunkSize/2)
+	layer := pushBlob(t, env, imageName, v1.MediaTypeImageLayer, content)
+	m := ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux"}`)),
+		Layers: []distribution.Descriptor{layer},
+	}
+	image := pushOCIManifest(t, env, imageName, m, "latest")
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		pushOCIManifest(t, env, imageName, m, tag)
+	}
+
+	client, stop := newGRPCClient(t, env.app)
+	defer stop()
+	ctx := context.Background()
+
+	desc, err := client.ResolveTag(ctx, &rpc.ResolveTagRequest{Repository: "foo/bar", Tag: "latest"})
+	if err != nil {
+		t.Fatalf("unexpected error resolving tag: %v", err)
+	}
+	if desc.Digest != image.String() {
+		t.Errorf("unexpected descriptor: %v", desc)
+	}
+
+	desc, err = client.StatBlob(ctx, &rpc.StatBlobRequest{Repository: "foo/bar", Digest: layer.Digest.String()})
+	if err != nil {
+		t.Fatalf("unexpected error stating blob: %v", err)
+	}
+	if desc.Digest != layer.Digest.String() || desc.Size != layer.Size {
+		t.Errorf("unexpected descriptor: %v", desc)
+	}
+
+	for _, tc := range []struct {
+		req      *rpc.ListTagsRequest
+		expected []string
+		more     bool
+	}{
+		{req: &rpc.ListTagsRequest{Repository: "foo/bar"}, expected: []string{"latest", "v1", "v2", "v3"}},
+		{req: &rpc.ListTagsRequest{Repository: "foo/bar", PageSize: 2}, expected: []string{"latest", "v1"}, more: true},
+		{req: &rpc.ListTagsRequest{Repository: "foo/bar", PageSize: 2, Last: "v1"}, expected: []string{"v2", "v3"}},
+	} {
+		resp, err := client.ListTags(ctx, tc.req)
+		if err != nil {
+			t.Fatalf("unexpected error listing tags: %v", err)
+		}
+		if !reflect.DeepEqual(resp.Tags, tc.expected) || resp.More != tc.more {
+			t.Errorf("unexpected tags for %v: %v, more %v", tc.req, resp.Tags, resp.More)
+		}
+	}
+
+	for _, offset := range []int64{0, 10, layer.Size} {
+		stream, err := client.GetBlob(ctx, &rpc.GetBlobRequest{Repository: "foo/bar", Digest: layer.Digest.String(), Offset: offset})
+		if err != nil {
+			t.Fatalf("unexpected error getting blob: %v", err)
+		}
+		var received []byte
+		for i := 0; ; i++ {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error receiving blob: %v", err)
+			}
+			if (chunk.Blob != nil) != (i == 0) {
+				t.Errorf("unexpected descriptor in chunk %d: %v", i, chunk.Blob)
+			}
+			received = append(received, chunk.Data...)
+		}
+		if !bytes.Equal(received, content[offset:]) {
+			t.Errorf("unexpected content from offset %d: %d bytes", offset, len(received))
+		}
+	}
+
+	for _, tc := range []struct {
+		call func() error
+		code codes.Code
+	}{
+		{
+			call: func() error {
+				_, err := client.ResolveTag(ctx, &rpc.ResolveTagRequest{Repository: "foo/bar", Tag: "unknown"})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			call: func() error {
+				_, err := client.StatBlob(ctx, &rpc.StatBlobRequest{Repository: "foo/bar", Digest: image.String() + "0"})
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			call: func() error {
+				_, err := client.ListTags(ctx, &rpc.ListTagsRequest{Repository: "Foo"})
+				return err
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			call: func() error {
+				_, err := client.ListTags(ctx, &rpc.ListTagsRequest{Repository: "foo/unknown"})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			call: func() error {
+				stream, err := client.GetBlob(ctx, &rpc.GetBlobRequest{Repository: "foo/bar", Digest: layer.Digest.String(), Offset: layer.Size + 1})
+				if err != nil {
+					return err
+				}
+				_, err = stream.Recv()
+				return err
+			},
+			code: codes.OutOfRange,
+		},
+	} {
+		if code := status.Code(tc.call()); code != tc.code {
+			t.Errorf("unexpected code: %v != %v", code, tc.code)
+		}
+	}
+}
+
+func TestGRPCAPIAuthorization(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	app := NewApp(context.Background(), config)
+	client, stop := newGRPCClient(t, app)
+	defer stop()
+
+	req := &rpc.ResolveTagRequest{Repository: "foo/bar", Tag: "latest"}
+	if _, err := client.ResolveTag(context.Background(), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("unexpected error without credentials: %v", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+	if _, err := client.ResolveTag(ctx, req); status.Code(err) != codes.NotFound {
+		t.Errorf("unexpected error with credentials: %v", err)
+	}
+}
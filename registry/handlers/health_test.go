@@ -129,6 +129,44 @@ func TestTCPHealthCheck(t *testing.T) {
 	}
 }
 
+func TestCommandHealthCheck(t *testing.T) {
+	interval := 100 * time.Millisecond
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Health: configuration.Health{
+			CommandCheckers: []configuration.CommandChecker{
+				{
+					Interval: interval,
+					Command:  []string{"sh", "-c", "echo unmounted; exit 1"},
+				},
+			},
+		},
+	}
+
+	ctx := context.Background()
+
+	app := NewApp(ctx, config)
+	healthRegistry := health.NewRegistry()
+	app.RegisterHealthChecks(healthRegistry)
+
+	// Wait for health check to happen
+	<-time.After(2 * interval)
+
+	status := healthRegistry.CheckStatus()
+	if len(status) != 1 {
+		t.Fatal("expected 1 item in health check results")
+	}
+	if result := status["sh -c echo unmounted; exit 1"]; result != "command sh failed: exit status 1: unmounted" {
+		t.Fatalf("unexpected result for command health check: %q", result)
+	}
+}
+
 func TestHTTPHealthCheck(t *testing.T) {
 	interval := time.Second
 	threshold := 3
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/api/errcode"
+)
+
+// defaultMaintenanceRetryAfter is the default duration suggested to clients
+// whose requests are rejected during maintenance.
+const defaultMaintenanceRetryAfter = time.Minute
+
+// maintenanceStatus is the state of the maintenance mode, in which requests
+// are rejected with 503 Service Unavailable. It is the body of the
+// maintenance admin endpoint.
+type maintenanceStatus struct {
+	Enabled    bool   `json:"enabled"`
+	Reads      bool   `json:"reads"`
+	RetryAfter string `json:"retryAfter"`
+	Message    string `json:"message,omitempty"`
+}
+
+// maintenanceMode guards the maintenance status, which can be toggled at
+// runtime.
+type maintenanceMode struct {
+	mu         sync.RWMutex
+	enabled    bool
+	reads      bool
+	retryAfter time.Duration
+	message    string
+}
+
+// set updates the maintenance mode from the configuration.
+func (m *maintenanceMode) set(config configuration.Maintenance) {
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = defaultMaintenanceRetryAfter
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = config.Enabled
+	m.reads = config.Reads
+	m.retryAfter = config.RetryAfter
+	m.message = config.Message
+}
+
+func (m *maintenanceMode) status() maintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maintenanceStatus{
+		Enabled:    m.enabled,
+		Reads:      m.reads,
+		RetryAfter: m.retryAfter.String(),
+		Message:    m.message,
+	}
+}
+
+// reject returns the error to serve for the request, and sets its
+// Retry-After header, if the request is rejected for maintenance.
+func (m *maintenanceMode) reject(w http.ResponseWriter, r *http.Request) (errcode.Error, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.enabled {
+		return errcode.Error{}, false
+	}
+	if !m.reads && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return errcode.Error{}, false
+	}
+
+	// Retry-After is expressed in whole seconds, rounded up.
+	seconds := int64((m.retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+
+	if m.message != "" {
+		return errcode.ErrorCodeUnavailable.WithMessage(m.message), true
+	}
+	return errcode.ErrorCodeUnavailable.WithMessage("the registry is down for maintenance"), true
+}
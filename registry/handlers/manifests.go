@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"mime"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/gorilla/handlers"
@@ -18,6 +20,7 @@ import (
 	"github.com/docker/distribution/manifest/ocischema"
 	"github.com/docker/distribution/manifest/schema1"
 	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/manifest/strict"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
@@ -65,7 +68,7 @@ func manifestDispatcher(ctx *Context, r *http.Request) http.Handler {
 		http.MethodHead: http.HandlerFunc(manifestHandler.GetManifest),
 	}
 
-	if !ctx.readOnly {
+	if !ctx.isReadOnly() {
 		mhandler[http.MethodPut] = http.HandlerFunc(manifestHandler.PutManifest)
 		mhandler[http.MethodDelete] = http.HandlerFunc(manifestHandler.DeleteManifest)
 	}
@@ -142,6 +145,11 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		imh.Digest = desc.Digest
 	}
 
+	if err := imh.checkVulnerabilities(imh.Digest); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
 	if etagMatch(r, imh.Digest.String()) {
 		w.WriteHeader(http.StatusNotModified)
 		return
@@ -311,6 +319,11 @@ func etagMatch(r *http.Request, etag string) bool {
 // PutManifest validates and stores a manifest in the registry.
 func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("PutImageManifest")
+	if err := imh.namePolicy.check(imh.Repository.Named().Name()); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
 	manifests, err := imh.Repository.Manifests(imh)
 	if err != nil {
 		imh.Errors = append(imh.Errors, err)
@@ -318,13 +331,24 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 	}
 
 	var jsonBuf bytes.Buffer
-	if err := copyFullPayload(imh, w, r, &jsonBuf, maxManifestBodySize, "image manifest PUT"); err != nil {
+	if err := copyFullPayload(imh, w, r, &jsonBuf, imh.App.bodyLimit(v2.RouteNameManifest), "image manifest PUT"); err != nil {
 		// copyFullPayload reports the error if necessary
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeRequestTooLarge.WithDetail(err.Error()))
+			return
+		}
 		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err.Error()))
 		return
 	}
 
 	mediaType := r.Header.Get("Content-Type")
+	if imh.App.manifestLimits != nil {
+		if err := strict.Validate(mediaType, jsonBuf.Bytes(), *imh.App.manifestLimits); err != nil {
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err.Error()))
+			return
+		}
+	}
 	manifest, desc, err := distribution.UnmarshalManifest(mediaType, jsonBuf.Bytes())
 	if err != nil {
 		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err))
@@ -344,6 +368,11 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := imh.checkTagMove(desc.Digest); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
 	isAnOCIManifest := mediaType == v1.MediaTypeImageManifest || mediaType == v1.MediaTypeImageIndex
 
 	if isAnOCIManifest {
@@ -362,6 +391,16 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := imh.checkSignatures(imh.Tag, manifest, desc.Digest); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
+	if err := imh.App.checkQuota(imh, imh.Repository.Named(), desc.Digest, desc.Size); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
 	_, err = manifests.Put(imh, manifest, options...)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
@@ -402,6 +441,8 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	imh.App.usage.link(imh.Repository.Named().Name(), imh.Digest, desc.Size)
+
 	// Tag this manifest
 	if imh.Tag != "" {
 		tags := imh.Repository.Tags(imh)
@@ -410,7 +451,21 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			return
 		}
+		imh.App.catalogIndex.touch(imh.Repository.Named().Name(), time.Now())
+	} else {
+		// repositories are listed from their first manifest
+		imh.App.catalogIndex.touch(imh.Repository.Named().Name(), time.Time{})
+	}
 
+	// Indexing is best effort, the manifest was stored successfully.
+	if err := imh.indexAnnotations(manifest, imh.Digest); err != nil {
+		dcontext.GetLogger(imh).Errorf("error indexing manifest annotations: %v", err)
+	}
+	if err := imh.indexSBOM(manifest, imh.Digest); err != nil {
+		dcontext.GetLogger(imh).Errorf("error indexing SBOM: %v", err)
+	}
+	if err := imh.summarizeVulnerabilities(manifest, imh.Digest); err != nil {
+		dcontext.GetLogger(imh).Errorf("error summarizing vulnerability report: %v", err)
 	}
 
 	// Construct a canonical url for the uploaded manifest.
@@ -430,6 +485,11 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 
 	w.Header().Set("Location", location)
 	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+	// tell clients the referrers API indexes the subject, so that they do
+	// not maintain a referrers tag themselves
+	if m, ok := manifest.(*ocischema.DeserializedManifest); ok && m.Subject != nil {
+		w.Header().Set("OCI-Subject", m.Subject.Digest.String())
+	}
 	w.WriteHeader(http.StatusCreated)
 
 	dcontext.GetLogger(imh).Debug("Succeeded in putting manifest!")
@@ -516,6 +576,10 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 
 	if imh.Tag != "" {
 		dcontext.GetLogger(imh).Debug("DeleteImageTag")
+		if imh.App.tagProtection.protected(imh.Repository.Named().Name(), imh.Tag) {
+			imh.Errors = append(imh.Errors, v2.ErrorCodeTagImmutable.WithDetail(fmt.Sprintf("tag %s is protected", imh.Tag)))
+			return
+		}
 		tagService := imh.Repository.Tags(imh.Context)
 		if err := tagService.Untag(imh.Context, imh.Tag); err != nil {
 			switch err.(type) {
@@ -536,6 +600,28 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	tagService := imh.Repository.Tags(imh)
+	// deleting the manifest would delete the tags pointing to it
+	tag, err := imh.protectedTag(tagService)
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if tag != "" {
+		imh.Errors = append(imh.Errors, v2.ErrorCodeTagImmutable.WithDetail(fmt.Sprintf("pointed to by protected tag %s", tag)))
+		return
+	}
+
+	tag, err = imh.referencingTag(manifests, tagService)
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if tag != "" {
+		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestReferenced.WithDetail(fmt.Sprintf("referenced by tag %s", tag)))
+		return
+	}
+
 	err = manifests.Delete(imh, imh.Digest)
 	if err != nil {
 		switch err {
@@ -554,8 +640,8 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
+	imh.App.usage.unlink(imh.Repository.Named().Name(), imh.Digest)
 
-	tagService := imh.Repository.Tags(imh)
 	referencedTags, err := tagService.Lookup(imh, distribution.Descriptor{Digest: imh.Digest})
 	if err != nil {
 		imh.Errors = append(imh.Errors, err)
@@ -571,3 +657,118 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// checkTagMove returns an error if the tag being pushed is protected and
+// points to a manifest other than dgst. Pushing the manifest a protected tag
+// already points to again is allowed.
+func (imh *manifestHandler) checkTagMove(dgst digest.Digest) error {
+	if imh.Tag == "" || !imh.App.tagProtection.protected(imh.Repository.Named().Name(), imh.Tag) {
+		return nil
+	}
+
+	desc, err := imh.Repository.Tags(imh).Get(imh, imh.Tag)
+	if err != nil {
+		switch err.(type) {
+		case distribution.ErrTagUnknown, distribution.ErrRepositoryUnknown:
+			return nil
+		}
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	if desc.Digest != dgst {
+		return v2.ErrorCodeTagImmutable.WithDetail(fmt.Sprintf("tag %s is protected and points to %s", imh.Tag, desc.Digest))
+	}
+	return nil
+}
+
+// protectedTag returns a protected tag of the repository pointing to the
+// manifest being deleted, or an empty string if there is none.
+func (imh *manifestHandler) protectedTag(tagService distribution.TagService) (string, error) {
+	if imh.App.tagProtection == nil {
+		return "", nil
+	}
+
+	tags, err := tagService.Lookup(imh, distribution.Descriptor{Digest: imh.Digest})
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, tag := range tags {
+		if imh.App.tagProtection.protected(imh.Repository.Named().Name(), tag) {
+			return tag, nil
+		}
+	}
+	return "", nil
+}
+
+// referencingTag returns a tag of the repository pointing to a manifest list
+// or image index which references the manifest, directly or through nested
+// indexes, or an empty string if there is none.
+func (imh *manifestHandler) referencingTag(manifests distribution.ManifestService, tagService distribution.TagService) (string, error) {
+	tags, err := tagService.All(imh)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return "", nil
+		}
+		return "", err
+	}
+
+	visited := make(map[digest.Digest]struct{})
+	for _, tag := range tags {
+		desc, err := tagService.Get(imh, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return "", err
+		}
+		if desc.Digest == imh.Digest {
+			continue
+		}
+		referenced, err := imh.indexReferences(manifests, desc.Digest, visited)
+		if err != nil {
+			return "", err
+		}
+		if referenced {
+			return tag, nil
+		}
+	}
+	return "", nil
+}
+
+// indexReferences returns whether the manifest of the digest is an index
+// referencing the manifest being deleted. Indexes are only fetched once.
+func (imh *manifestHandler) indexReferences(manifests distribution.ManifestService, dgst digest.Digest, visited map[digest.Digest]struct{}) (bool, error) {
+	if _, ok := visited[dgst]; ok {
+		return false, nil
+	}
+	visited[dgst] = struct{}{}
+
+	manifest, err := manifests.Get(imh, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	switch manifest.(type) {
+	case *manifestlist.DeserializedManifestList, *ocischema.DeserializedImageIndex:
+	default:
+		return false, nil
+	}
+
+	for _, ref := range manifest.References() {
+		if ref.Digest == imh.Digest {
+			return true, nil
+		}
+		if ref.MediaType != manifestlist.MediaTypeManifestList && ref.MediaType != v1.MediaTypeImageIndex {
+			continue
+		}
+		referenced, err := imh.indexReferences(manifests, ref.Digest, visited)
+		if err != nil || referenced {
+			return referenced, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/docker/distribution/configuration"
+	prometheus "github.com/docker/distribution/metrics"
+	"github.com/docker/go-metrics"
+)
+
+// otherRepository is the repository label of requests to repositories which
+// are not labeled by name.
+const otherRepository = "other"
+
+var (
+	// repositoryRequests counts requests by repository, action and status class.
+	repositoryRequests = prometheus.RepositoryNamespace.NewLabeledCounter("requests", "The number of requests by repository", "repository", "action", "status")
+
+	// repositoryBytes counts the bytes served and received by repository.
+	repositoryBytes = prometheus.RepositoryNamespace.NewLabeledCounter("bytes", "The number of bytes transferred by repository", "repository", "direction")
+)
+
+func init() {
+	metrics.Register(prometheus.RepositoryNamespace)
+}
+
+// repositoryMetrics records request metrics labeled by repository, keeping
+// the number of labeled repositories within the configured bounds.
+type repositoryMetrics struct {
+	allowed []*regexp.Regexp
+	limit   int
+
+	mu      sync.Mutex
+	labeled map[string]struct{}
+}
+
+func newRepositoryMetrics(config configuration.RepositoryMetrics) (*repositoryMetrics, error) {
+	rm := &repositoryMetrics{
+		limit:   config.Limit,
+		labeled: make(map[string]struct{}),
+	}
+	for _, pattern := range config.Allowed {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository pattern %q: %v", pattern, err)
+		}
+		rm.allowed = append(rm.allowed, re)
+	}
+	return rm, nil
+}
+
+// label returns the repository label of requests to the named repository.
+func (rm *repositoryMetrics) label(name string) string {
+	if len(rm.allowed) != 0 {
+		allowed := false
+		for _, re := range rm.allowed {
+			if re.MatchString(name) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return otherRepository
+		}
+	}
+
+	if rm.limit <= 0 {
+		return name
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if _, ok := rm.labeled[name]; !ok {
+		if len(rm.labeled) >= rm.limit {
+			return otherRepository
+		}
+		rm.labeled[name] = struct{}{}
+	}
+	return name
+}
+
+// record counts a completed request to the named repository.
+func (rm *repositoryMetrics) record(name, method string, status int, served, received int64) {
+	repository := rm.label(name)
+	repositoryRequests.WithValues(repository, repositoryAction(method), fmt.Sprintf("%dxx", status/100)).Inc(1)
+	if served > 0 {
+		repositoryBytes.WithValues(repository, "served").Inc(float64(served))
+	}
+	if received > 0 {
+		repositoryBytes.WithValues(repository, "received").Inc(float64(received))
+	}
+}
+
+// repositoryAction classifies requests as pulls, pushes or deletes.
+func repositoryAction(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "pull"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "push"
+	}
+}
+
+// countingReadCloser counts the bytes read from a request body.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestRepositoryMetricsLabel(t *testing.T) {
+	rm, err := newRepositoryMetrics(configuration.RepositoryMetrics{
+		Enabled: true,
+		Allowed: []string{"team-a/.*", "library/ubuntu"},
+		Limit:   2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating repository metrics: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{name: "library/ubuntu", expected: "library/ubuntu"},
+		{name: "library/ubuntu-debug", expected: otherRepository},
+		{name: "team-b/app", expected: otherRepository},
+		{name: "team-a/app", expected: "team-a/app"},
+		// the limit of labeled repositories is reached
+		{name: "team-a/db", expected: otherRepository},
+		{name: "library/ubuntu", expected: "library/ubuntu"},
+		{name: "team-a/app", expected: "team-a/app"},
+	} {
+		if label := rm.label(tc.name); label != tc.expected {
+			t.Errorf("unexpected label for %q: %q != %q", tc.name, label, tc.expected)
+		}
+	}
+}
+
+func TestRepositoryMetricsInvalidPattern(t *testing.T) {
+	_, err := newRepositoryMetrics(configuration.RepositoryMetrics{
+		Enabled: true,
+		Allowed: []string{"team-a/("},
+	})
+	if err == nil {
+		t.Fatal("expected an error for an invalid repository pattern")
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/gorilla/handlers"
+)
+
+// mirror is an alternative endpoint advertised by the mirror discovery
+// endpoint.
+type mirror struct {
+	URL      string `json:"url"`
+	Priority int    `json:"priority"`
+	Region   string `json:"region,omitempty"`
+}
+
+// newMirrors returns the configured mirrors ordered by priority.
+func newMirrors(config []configuration.Mirror) ([]mirror, error) {
+	mirrors := make([]mirror, 0, len(config))
+	for _, m := range config {
+		u, err := url.Parse(m.URL)
+		if err != nil {
+			return nil, fmt.Errorf("mirrors: invalid url %q: %v", m.URL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("mirrors: url %q must be an absolute http or https url", m.URL)
+		}
+		mirrors = append(mirrors, mirror{URL: m.URL, Priority: m.Priority, Region: m.Region})
+	}
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		return mirrors[i].Priority < mirrors[j].Priority
+	})
+	return mirrors, nil
+}
+
+func mirrorsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	mirrorsHandler := &mirrorsHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(mirrorsHandler.GetMirrors),
+	}
+}
+
+type mirrorsHandler struct {
+	*Context
+}
+
+type mirrorsAPIResponse struct {
+	Mirrors []mirror `json:"mirrors"`
+}
+
+// GetMirrors lists the alternative endpoints clients may pull from.
+func (mh *mirrorsHandler) GetMirrors(w http.ResponseWriter, r *http.Request) {
+	if len(mh.App.mirrors) == 0 {
+		mh.Errors = append(mh.Errors, errcode.ErrorCodeUnsupported.WithMessage("no mirrors are configured"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(mirrorsAPIResponse{Mirrors: mh.App.mirrors}); err != nil {
+		mh.Errors = append(mh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution/configuration"
+	v2 "github.com/docker/distribution/registry/api/v2"
+)
+
+// namePolicy enforces the configured policy on the names of repositories
+// pushed to.
+type namePolicy struct {
+	prefixes  []string
+	forbidden map[string]struct{}
+	maxDepth  int
+	allow     *regexp.Regexp
+}
+
+// newNamePolicy compiles the policy, returning nil if the policy places no
+// restriction on names.
+func newNamePolicy(config configuration.RepositoryNamePolicy) (*namePolicy, error) {
+	if len(config.Prefixes) == 0 && len(config.Forbidden) == 0 && config.MaxDepth <= 0 && len(config.Allow) == 0 {
+		return nil, nil
+	}
+
+	policy := &namePolicy{
+		prefixes:  config.Prefixes,
+		forbidden: make(map[string]struct{}, len(config.Forbidden)),
+		maxDepth:  config.MaxDepth,
+	}
+	for _, name := range config.Forbidden {
+		policy.forbidden[name] = struct{}{}
+	}
+
+	if len(config.Allow) > 0 {
+		patterns := make([]string, len(config.Allow))
+		for i, s := range config.Allow {
+			if _, err := regexp.Compile(s); err != nil {
+				return nil, fmt.Errorf("policy.repository.names.allow: %s", err)
+			}
+			// Anchor each pattern, so that it matches the whole name.
+			patterns[i] = fmt.Sprintf("^(?:%s)$", s)
+		}
+		policy.allow = regexp.MustCompile(strings.Join(patterns, "|"))
+	}
+
+	return policy, nil
+}
+
+// check returns an error if the repository name does not comply with the
+// policy. A nil policy allows every name.
+func (p *namePolicy) check(name string) error {
+	if p == nil {
+		return nil
+	}
+
+	if _, ok := p.forbidden[name]; ok {
+		return v2.ErrorCodeNameInvalid.WithDetail(fmt.Sprintf("repository name %q is forbidden", name))
+	}
+
+	if p.maxDepth > 0 {
+		if depth := strings.Count(name, "/") + 1; depth > p.maxDepth {
+			return v2.ErrorCodeNameInvalid.WithDetail(fmt.Sprintf("repository name %q has %d path components, more than the maximum of %d", name, depth, p.maxDepth))
+		}
+	}
+
+	if len(p.prefixes) > 0 {
+		var matched bool
+		for _, prefix := range p.prefixes {
+			if strings.HasPrefix(name, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return v2.ErrorCodeNameInvalid.WithDetail(fmt.Sprintf("repository name %q must start with one of %s", name, strings.Join(p.prefixes, ", ")))
+		}
+	}
+
+	if p.allow != nil && !p.allow.MatchString(name) {
+		return v2.ErrorCodeNameInvalid.WithDetail(fmt.Sprintf("repository name %q is not allowed by the naming policy", name))
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestNamePolicy(t *testing.T) {
+	policy, err := newNamePolicy(configuration.RepositoryNamePolicy{
+		Prefixes:  []string{"team-a/", "team-b/"},
+		Forbidden: []string{"team-a/admin"},
+		MaxDepth:  3,
+		Allow:     []string{"[a-z-]+/[a-z]+(/[a-z]+)?"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error compiling policy: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		allowed bool
+	}{
+		{"team-a/app", true},
+		{"team-b/app/web", true},
+		{"team-c/app", false},
+		{"app", false},
+		{"team-a/admin", false},
+		{"team-a/app/web/static", false},
+		{"team-a/app2", false},
+	} {
+		if err := policy.check(tc.name); (err == nil) != tc.allowed {
+			t.Errorf("unexpected result checking %q: %v", tc.name, err)
+		}
+	}
+
+	if policy, err := newNamePolicy(configuration.RepositoryNamePolicy{}); err != nil || policy != nil {
+		t.Fatalf("expected no policy without restrictions: %v, %v", policy, err)
+	}
+	if err := (*namePolicy)(nil).check("anything"); err != nil {
+		t.Fatalf("unexpected error checking without a policy: %v", err)
+	}
+
+	if _, err := newNamePolicy(configuration.RepositoryNamePolicy{Allow: []string{"("}}); err == nil {
+		t.Fatal("expected an error compiling an invalid pattern")
+	}
+}
+
+func TestNamePolicyEnforced(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Policy.Repository.Names.Prefixes = []string{"team-a/"}
+	app := NewApp(context.Background(), config)
+
+	for _, tc := range []struct {
+		method, path string
+		expected     int
+	}{
+		{http.MethodPost, "/v2/team-a/app/blobs/uploads/", http.StatusAccepted},
+		{http.MethodPost, "/v2/team-b/app/blobs/uploads/", http.StatusBadRequest},
+		{http.MethodPut, "/v2/team-b/app/manifests/latest", http.StatusBadRequest},
+		// names which do not comply can still be read
+		{http.MethodGet, "/v2/team-b/app/tags/list", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.expected {
+			t.Errorf("unexpected status for %s %s: %d != %d: %s", tc.method, tc.path, rec.Code, tc.expected, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// defaultPreflightTimeout bounds the connectivity checks run by Preflight
+// when the configuration does not specify a timeout of its own.
+const defaultPreflightTimeout = 10 * time.Second
+
+// PreflightCheck is the outcome of a single check run by Preflight.
+type PreflightCheck struct {
+	// Name identifies the checked dependency.
+	Name string
+	// Err is nil if the check succeeded.
+	Err error
+}
+
+// Preflight checks that the dependencies of the app, such as the storage
+// backend, redis and notification endpoints, are reachable. It is meant to be
+// run before serving any requests.
+func (app *App) Preflight(ctx context.Context) []PreflightCheck {
+	var checks []PreflightCheck
+	check := func(name string, fn func() error) {
+		checks = append(checks, PreflightCheck{Name: name, Err: fn()})
+	}
+
+	check("storage driver "+app.Config.Storage.Type(), func() error {
+		_, err := app.driver.Stat(ctx, "/")
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			err = nil // the backend is responding, but the root does not exist yet.
+		}
+		return err
+	})
+
+	if app.redis != nil {
+		check("redis "+redisEndpoint(app.Config), func() error {
+			conn := app.redis.Get()
+			defer conn.Close()
+
+			_, err := conn.Do("PING")
+			return err
+		})
+	}
+
+	if app.accessController != nil {
+		check("auth "+app.Config.Auth.Type(), func() error {
+			realm, _ := app.Config.Auth.Parameters()["realm"].(string)
+			if app.Config.Auth.Type() != "token" || realm == "" {
+				return nil
+			}
+			return dialURL(ctx, realm, defaultPreflightTimeout)
+		})
+	}
+
+	for _, endpoint := range app.Config.Notifications.Endpoints {
+		if endpoint.Disabled {
+			continue
+		}
+		endpoint := endpoint
+		timeout := endpoint.Timeout
+		if timeout == 0 {
+			timeout = defaultPreflightTimeout
+		}
+		check("notification endpoint "+endpoint.Name, func() error {
+			if endpoint.Type == "kafka" {
+				return dialBrokers(ctx, endpoint.Kafka.Brokers, timeout)
+			}
+			return dialURL(ctx, endpoint.URL, timeout)
+		})
+	}
+
+	if app.Config.Proxy.RemoteURL != "" {
+		check("proxy "+app.Config.Proxy.RemoteURL, func() error {
+			return dialURL(ctx, app.Config.Proxy.RemoteURL, defaultPreflightTimeout)
+		})
+	}
+	for _, remote := range app.Config.Proxy.Remotes {
+		remote := remote
+		check("proxy "+remote.RemoteURL, func() error {
+			return dialURL(ctx, remote.RemoteURL, defaultPreflightTimeout)
+		})
+	}
+
+	return checks
+}
+
+// dialURL checks that a TCP connection can be established to the host of the
+// given http or https URL.
+func dialURL(ctx context.Context, rawURL string, timeout time.Duration) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "http":
+			host = net.JoinHostPort(u.Hostname(), "80")
+		case "https":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		default:
+			return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+		}
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// dialBrokers checks that a TCP connection can be established to any of the
+// given Kafka brokers, which is enough for the client to discover the others.
+func dialBrokers(ctx context.Context, brokers []string, timeout time.Duration) error {
+	err := fmt.Errorf("no broker configured")
+	for _, broker := range brokers {
+		dialer := net.Dialer{Timeout: timeout}
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
+	}
+	return err
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// quotaRepositoriesPage is the number of repositories listed at once when
+// computing the usage of a namespace.
+const quotaRepositoriesPage = 100
+
+// storageQuota is the maximum number of bytes stored in a repository matching
+// a pattern, or in all repositories of a namespace.
+type storageQuota struct {
+	name  string
+	limit int64
+}
+
+// storageQuotas limits the storage used by repositories and namespaces.
+type storageQuotas struct {
+	repositories []storageQuota
+	namespaces   []storageQuota
+}
+
+// quotaDetail is the detail of errors denying pushes which would exceed a
+// quota.
+type quotaDetail struct {
+	// Scope is the pattern of a repository quota, or the namespace of a
+	// namespace quota.
+	Scope string `json:"scope"`
+	// Limit is the number of bytes the scope may store.
+	Limit int64 `json:"limit"`
+	// Usage is the number of bytes the scope stores.
+	Usage int64 `json:"usage"`
+	// Requested is the number of bytes the push would add.
+	Requested int64 `json:"requested"`
+}
+
+// newStorageQuotas returns the configured quotas, or nil if none are.
+func newStorageQuotas(config configuration.Quotas) (*storageQuotas, error) {
+	if len(config.Repositories) == 0 && len(config.Namespaces) == 0 {
+		return nil, nil
+	}
+
+	quotas := &storageQuotas{}
+	for _, q := range config.Repositories {
+		if q.Name == "" {
+			return nil, fmt.Errorf("quotas.repositories: a name is required")
+		}
+		if _, err := path.Match(q.Name, ""); err != nil {
+			return nil, fmt.Errorf("quotas.repositories: invalid pattern %q: %v", q.Name, err)
+		}
+		if q.Limit <= 0 {
+			return nil, fmt.Errorf("quotas.repositories: limit of %q must be positive", q.Name)
+		}
+		quotas.repositories = append(quotas.repositories, storageQuota{name: q.Name, limit: q.Limit})
+	}
+	for _, q := range config.Namespaces {
+		name := strings.Trim(q.Name, "/")
+		if name == "" {
+			return nil, fmt.Errorf("quotas.namespaces: a name is required")
+		}
+		if q.Limit <= 0 {
+			return nil, fmt.Errorf("quotas.namespaces: limit of %q must be positive", q.Name)
+		}
+		quotas.namespaces = append(quotas.namespaces, storageQuota{name: name, limit: q.Limit})
+	}
+	return quotas, nil
+}
+
+// applies reports whether any quota applies to the named repository.
+func (sq *storageQuotas) applies(name string) bool {
+	for _, q := range sq.repositories {
+		if ok, _ := path.Match(q.name, name); ok {
+			return true
+		}
+	}
+	for _, q := range sq.namespaces {
+		if strings.HasPrefix(name, q.name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkQuota returns an error if storing the blob or manifest of size bytes
+// in the named repository would exceed any of its quotas. Content already
+// stored in the repository is not counted again. Exceeded quotas are
+// reported as denied, with the usage of the quota as detail.
+func (app *App) checkQuota(ctx context.Context, name reference.Named, dgst digest.Digest, size int64) error {
+	if app.quotas == nil || size <= 0 || !app.quotas.applies(name.Name()) {
+		return nil
+	}
+
+	usage, stored, err := app.repositoryUsage(ctx, name, dgst)
+	if err != nil {
+		return errcode.ErrorCodeUnknown.WithDetail(err)
+	}
+	if stored {
+		return nil
+	}
+
+	for _, q := range app.quotas.repositories {
+		if ok, _ := path.Match(q.name, name.Name()); ok && usage+size > q.limit {
+			return quotaExceeded(q, usage, size)
+		}
+	}
+
+	for _, q := range app.quotas.namespaces {
+		if !strings.HasPrefix(name.Name(), q.name+"/") {
+			continue
+		}
+		others, err := app.namespaceUsage(ctx, q.name, name)
+		if err != nil {
+			return errcode.ErrorCodeUnknown.WithDetail(err)
+		}
+		if usage+others+size > q.limit {
+			return quotaExceeded(q, usage+others, size)
+		}
+	}
+
+	return nil
+}
+
+// quotaExceeded returns the error denying a push of size bytes to a scope
+// storing usage bytes.
+func quotaExceeded(q storageQuota, usage, size int64) error {
+	return errcode.ErrorCodeDenied.WithMessage("quota exceeded").WithDetail(quotaDetail{
+		Scope:     q.name,
+		Limit:     q.limit,
+		Usage:     usage,
+		Requested: size,
+	})
+}
+
+// repositoryUsage returns the number of bytes of the layers and manifests
+// linked in the named repository, and whether dgst is one of them. Blobs
+// shared with other repositories are counted in each of them. The usage is
+// read from the usage accounting if it is enabled, and computed from the
+// storage otherwise.
+func (app *App) repositoryUsage(ctx context.Context, name reference.Named, dgst digest.Digest) (int64, bool, error) {
+	if usage, stored, ok := app.usage.repository(name.Name(), dgst); ok {
+		return usage, stored, nil
+	}
+
+	sizes, err := app.storedContent(ctx, name)
+	if err != nil {
+		return 0, false, err
+	}
+	var usage int64
+	for _, size := range sizes {
+		usage += size
+	}
+	_, stored := sizes[dgst]
+	return usage, stored, nil
+}
+
+// namespaceUsage returns the number of bytes stored in the repositories of
+// the namespace listed in the catalog, other than the current one.
+func (app *App) namespaceUsage(ctx context.Context, namespace string, current reference.Named) (int64, error) {
+	if usage, ok := app.usage.prefix(namespace+"/", current.Name()); ok {
+		return usage, nil
+	}
+
+	var usage int64
+	repos := make([]string, quotaRepositoriesPage)
+	last := ""
+	for {
+		n, err := app.registry.Repositories(ctx, repos, last)
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && err != io.EOF && !ok {
+			return 0, err
+		}
+		for _, repo := range repos[:n] {
+			if !strings.HasPrefix(repo, namespace+"/") || repo == current.Name() {
+				continue
+			}
+			named, err := reference.WithName(repo)
+			if err != nil {
+				return 0, err
+			}
+			repoUsage, _, err := app.repositoryUsage(ctx, named, "")
+			if err != nil {
+				return 0, err
+			}
+			usage += repoUsage
+		}
+		if err != nil || n == 0 {
+			return usage, nil
+		}
+		last = repos[n-1]
+	}
+}
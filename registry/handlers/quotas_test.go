@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNewStorageQuotas(t *testing.T) {
+	if quotas, err := newStorageQuotas(configuration.Quotas{}); err != nil || quotas != nil {
+		t.Fatalf("expected no quotas, got %v, %v", quotas, err)
+	}
+
+	for _, config := range []configuration.Quotas{
+		{Repositories: []configuration.StorageQuota{{Limit: 1}}},
+		{Repositories: []configuration.StorageQuota{{Name: "[", Limit: 1}}},
+		{Repositories: []configuration.StorageQuota{{Name: "foo/*"}}},
+		{Namespaces: []configuration.StorageQuota{{Name: "/", Limit: 1}}},
+		{Namespaces: []configuration.StorageQuota{{Name: "foo", Limit: -1}}},
+	} {
+		if _, err := newStorageQuotas(config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+
+	quotas, err := newStorageQuotas(configuration.Quotas{
+		Repositories: []configuration.StorageQuota{{Name: "library/*", Limit: 1}},
+		Namespaces:   []configuration.StorageQuota{{Name: "team/", Limit: 1}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, expected := range map[string]bool{
+		"library/ubuntu":  true,
+		"team/app":        true,
+		"team/app/worker": true,
+		"teams/app":       false,
+		"other":           false,
+	} {
+		if applies := quotas.applies(name); applies != expected {
+			t.Errorf("applies(%q) = %v, expected %v", name, applies, expected)
+		}
+	}
+}
+
+func TestQuotaEnforcement(t *testing.T) {
+	imageConfig := []byte(`{"architecture": "amd64", "os": "linux", "rootfs": {"type": "layers", "diff_ids": []}}`)
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: distribution.Descriptor{
+			Digest:    digest.FromBytes(imageConfig),
+			Size:      int64(len(imageConfig)),
+			MediaType: v1.MediaTypeImageConfig,
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	_, payload, _ := m.Payload()
+	imageSize := int64(len(imageConfig) + len(payload))
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Quotas: configuration.Quotas{
+			Repositories: []configuration.StorageQuota{{Name: "quota/*", Limit: 150}},
+			Namespaces:   []configuration.StorageQuota{{Name: "team", Limit: imageSize + 100}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.BodyLimits.Blobs = 100
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	push := func(repo string, content []byte) *http.Response {
+		t.Helper()
+		name, err := reference.WithName(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		resp, err := doPushLayer(t, env.builder, name, digest.FromBytes(content), uploadURLBase, bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("unexpected error pushing layer: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	blob := func(b byte, size int) []byte {
+		return bytes.Repeat([]byte{b}, size)
+	}
+
+	resp := push("other/repo", blob(0, 101))
+	checkResponse(t, "pushing too large blob", resp, http.StatusRequestEntityTooLarge)
+	checkBodyHasErrorCodes(t, "pushing too large blob", resp, errcode.ErrorCodeRequestTooLarge)
+	checkResponse(t, "pushing blob at size limit", push("other/repo", blob(0, 100)), http.StatusCreated)
+
+	checkResponse(t, "pushing within repository quota", push("quota/a", blob(1, 80)), http.StatusCreated)
+	resp = push("quota/a", blob(2, 80))
+	checkResponse(t, "pushing over repository quota", resp, http.StatusForbidden)
+	errs, _, _ := checkBodyHasErrorCodes(t, "pushing over repository quota", resp, errcode.ErrorCodeDenied)
+	detail, ok := errs[0].(errcode.Error).Detail.(map[string]interface{})
+	if !ok || detail["scope"] != "quota/*" || detail["limit"] != float64(150) || detail["usage"] != float64(80) || detail["requested"] != float64(80) {
+		t.Fatalf("unexpected quota detail: %#v", errs[0])
+	}
+	checkResponse(t, "pushing linked blob again", push("quota/a", blob(1, 80)), http.StatusCreated)
+	checkResponse(t, "pushing to other repository", push("quota/b", blob(2, 80)), http.StatusCreated)
+
+	// the image is listed in the catalog once its manifest is pushed
+	checkResponse(t, "pushing image config", push("team/a", imageConfig), http.StatusCreated)
+	manifestURL := func(repo string) string {
+		name, _ := reference.WithName(repo)
+		ref, _ := reference.WithTag(name, "latest")
+		u, err := env.builder.BuildManifestURL(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	resp = putManifest(t, "pushing manifest within namespace quota", manifestURL("team/a"), v1.MediaTypeImageManifest, m)
+	defer resp.Body.Close()
+	checkResponse(t, "pushing manifest within namespace quota", resp, http.StatusCreated)
+
+	checkResponse(t, "pushing within namespace quota", push("team/b", blob(3, 80)), http.StatusCreated)
+	resp = push("team/b", blob(4, 80))
+	checkResponse(t, "pushing over namespace quota", resp, http.StatusForbidden)
+	errs, _, _ = checkBodyHasErrorCodes(t, "pushing over namespace quota", resp, errcode.ErrorCodeDenied)
+	detail, ok = errs[0].(errcode.Error).Detail.(map[string]interface{})
+	if !ok || detail["scope"] != "team" || detail["usage"] != float64(imageSize+80) {
+		t.Fatalf("unexpected quota detail: %#v", errs[0])
+	}
+
+	// the quota is checked before the manifest references are verified
+	resp = putManifest(t, "pushing manifest over namespace quota", manifestURL("team/b"), v1.MediaTypeImageManifest, m)
+	defer resp.Body.Close()
+	checkResponse(t, "pushing manifest over namespace quota", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "pushing manifest over namespace quota", resp, errcode.ErrorCodeDenied)
+
+	// the usage of the quotas is reported by the admin API
+	handler := env.app.AdminHandler()
+	for repo, expected := range map[string][]interface{}{
+		"quota/a":    {map[string]interface{}{"scope": "quota/*", "limit": float64(150), "usage": float64(80)}},
+		"team/b":     {map[string]interface{}{"scope": "team", "limit": float64(imageSize + 100), "usage": float64(imageSize + 80)}},
+		"other/repo": {},
+	} {
+		result := serveAdmin(t, handler, http.MethodGet, "/admin/v1/quotas/"+repo, "", http.StatusOK)
+		if result["name"] != repo || !reflect.DeepEqual(result["quotas"], expected) {
+			t.Fatalf("unexpected quotas of %s: %v", repo, result)
+		}
+	}
+}
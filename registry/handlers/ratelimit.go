@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/listener"
+	"golang.org/x/time/rate"
+)
+
+// maxIdleRateLimitKeys is the number of clients, users or repositories a rate
+// limit tracks before forgetting those whose token bucket is full again.
+const maxIdleRateLimitKeys = 10000
+
+// requestRate limits the requests of each key, such as a client or a
+// repository, with a token bucket for each.
+type requestRate struct {
+	kind  string
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+// newRequestRate returns the rate limit of the kind of keys, or nil if it is
+// disabled.
+func newRequestRate(kind string, config configuration.RequestRate) (*requestRate, error) {
+	if config.Rate < 0 || config.Burst < 0 {
+		return nil, fmt.Errorf("http.ratelimit.%s: rate and burst must not be negative", kind)
+	}
+	if config.Rate == 0 {
+		return nil, nil
+	}
+
+	burst := config.Burst
+	if burst <= 0 {
+		burst = int(config.Rate + 0.5)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &requestRate{
+		kind:    kind,
+		limit:   rate.Limit(config.Rate),
+		burst:   burst,
+		buckets: make(map[string]*rate.Limiter),
+	}, nil
+}
+
+// reserve takes a request from the bucket of the key, returning how long to
+// wait for the next one if it is empty.
+func (l *requestRate) reserve(key string) (time.Duration, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		// a bucket which is full again is equivalent to a new one
+		if len(l.buckets) >= maxIdleRateLimitKeys {
+			for k, b := range l.buckets {
+				if b.TokensAt(now) >= float64(l.burst) {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		bucket = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[key] = bucket
+	}
+
+	r := bucket.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+// rateLimits limits the rate of requests of clients, users and repositories.
+type rateLimits struct {
+	client     *requestRate
+	user       *requestRate
+	repository *requestRate
+	exempt     []*net.IPNet
+}
+
+// newRateLimits compiles the configured rate limits, returning nil if none is
+// enabled.
+func newRateLimits(config configuration.RateLimit) (*rateLimits, error) {
+	var (
+		limits = &rateLimits{}
+		err    error
+	)
+	if limits.client, err = newRequestRate("client", config.Client); err != nil {
+		return nil, err
+	}
+	if limits.user, err = newRequestRate("user", config.User); err != nil {
+		return nil, err
+	}
+	if limits.repository, err = newRequestRate("repository", config.Repository); err != nil {
+		return nil, err
+	}
+	if limits.exempt, err = listener.ParseNetworks(config.ExemptNetworks); err != nil {
+		return nil, fmt.Errorf("http.ratelimit.exemptnetworks: %v", err)
+	}
+
+	if limits.client == nil && limits.user == nil && limits.repository == nil {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+// exempted returns whether the client of the given IP address is not
+// limited.
+func (l *rateLimits) exempted(client string) bool {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return false
+	}
+	for _, network := range l.exempt {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitRate rejects the request if the rate of the key is exceeded, returning
+// false. A nil rate and an empty key are not limited.
+func (app *App) limitRate(ctx *Context, w http.ResponseWriter, r *http.Request, l *requestRate, key string) bool {
+	if l == nil || key == "" || app.rateLimits.exempted(app.clientIP(r)) {
+		return true
+	}
+
+	delay, ok := l.reserve(key)
+	if ok {
+		return true
+	}
+
+	dcontext.GetLogger(ctx).Warnf("rejecting request: rate limit of %s %s exceeded", l.kind, key)
+	// Retry-After is expressed in whole seconds, rounded up.
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithDetail(fmt.Sprintf("rate limit of %s exceeded", l.kind)))
+	return false
+}
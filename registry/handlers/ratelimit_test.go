@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/api/errcode"
+)
+
+func TestRequestRate(t *testing.T) {
+	l, err := newRequestRate("client", configuration.RequestRate{Rate: 0.5, Burst: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := l.reserve("a"); !ok {
+			t.Fatalf("request %d unexpectedly limited", i)
+		}
+	}
+	delay, ok := l.reserve("a")
+	if ok {
+		t.Fatal("expected request over the burst to be limited")
+	}
+	if delay <= 0 || delay.Seconds() > 2 {
+		t.Fatalf("unexpected delay: %v", delay)
+	}
+	if _, ok := l.reserve("b"); !ok {
+		t.Fatal("expected keys to be limited separately")
+	}
+
+	if l, err := newRequestRate("user", configuration.RequestRate{}); err != nil || l != nil {
+		t.Fatalf("expected disabled rate limit, got %v, %v", l, err)
+	}
+	if _, err := newRequestRate("user", configuration.RequestRate{Rate: -1}); err == nil {
+		t.Fatal("expected error with a negative rate")
+	}
+	if limits, err := newRateLimits(configuration.RateLimit{ExemptNetworks: []string{"10.0.0.0/8"}}); err != nil || limits != nil {
+		t.Fatalf("expected no rate limits, got %v, %v", limits, err)
+	}
+	if _, err := newRateLimits(configuration.RateLimit{Client: configuration.RequestRate{Rate: 1}, ExemptNetworks: []string{"foo"}}); err == nil {
+		t.Fatal("expected error with an invalid network")
+	}
+}
+
+func TestRateLimitedRequests(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.RateLimit = configuration.RateLimit{
+		Client:         configuration.RequestRate{Rate: 0.01, Burst: 3},
+		Repository:     configuration.RequestRate{Rate: 0.01, Burst: 1},
+		ExemptNetworks: []string{"10.0.0.0/8"},
+	}
+	// the test client is a trusted proxy, reporting the address of clients
+	config.HTTP.TrustedProxies = []string{"127.0.0.1"}
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	get := func(path, client string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, env.server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// the repository is limited whichever client requests it
+	if resp := get("/v2/foo/bar/tags/list", "192.0.2.1"); resp.StatusCode == http.StatusTooManyRequests {
+		t.Fatal("first request to the repository unexpectedly limited")
+	}
+	resp := get("/v2/foo/bar/tags/list", "192.0.2.2")
+	checkResponse(t, "repository limited", resp, http.StatusTooManyRequests)
+	checkBodyHasErrorCodes(t, "repository limited", resp, errcode.ErrorCodeTooManyRequests)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+
+	// the client has made one request
+	for i := 0; i < 2; i++ {
+		checkResponse(t, "client under its limit", get("/v2/", "192.0.2.1"), http.StatusOK)
+	}
+	checkResponse(t, "client limited", get("/v2/", "192.0.2.1"), http.StatusTooManyRequests)
+	checkResponse(t, "other client", get("/v2/", "192.0.2.3"), http.StatusOK)
+
+	for i := 0; i < 5; i++ {
+		checkResponse(t, "exempted client", get("/v2/", "10.1.2.3"), http.StatusOK)
+	}
+}
+
+func TestRateLimitedRequestsIgnoreForwardedHeaders(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.RateLimit = configuration.RateLimit{
+		Client:         configuration.RequestRate{Rate: 0.01, Burst: 2},
+		ExemptNetworks: []string{"10.0.0.0/8"},
+	}
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	// without trusted proxies, clients are limited by their peer address,
+	// whatever address they claim
+	for i, client := range []string{"10.1.2.3", "192.0.2.1", "192.0.2.2"} {
+		req, err := http.NewRequest(http.MethodGet, env.server.URL+"/v2/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", client)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+
+		expected := http.StatusOK
+		if i == 2 {
+			expected = http.StatusTooManyRequests
+		}
+		checkResponse(t, "claiming "+client, resp, expected)
+	}
+}
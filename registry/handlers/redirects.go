@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/storage"
+)
+
+// newRedirectPolicy returns the redirect policy deciding for each repository
+// whether blob downloads are redirected to the storage, or nil if no rules
+// are configured. Repositories matching no rule are redirected if
+// defaultRedirect is set.
+func newRedirectPolicy(rules []configuration.RedirectPolicy, defaultRedirect bool) (storage.RedirectFunc, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	for _, rule := range rules {
+		if len(rule.Repositories) == 0 {
+			return nil, fmt.Errorf("policy.repository.redirects: rules must list repositories")
+		}
+		for _, pattern := range rule.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("policy.repository.redirects: repository pattern %q: %v", pattern, err)
+			}
+		}
+		if rule.Expiry < 0 {
+			return nil, fmt.Errorf("policy.repository.redirects: expiry of %v must not be negative", rule.Repositories)
+		}
+	}
+
+	return func(repository string) (bool, time.Duration) {
+		for _, rule := range rules {
+			for _, pattern := range rule.Repositories {
+				if ok, _ := path.Match(pattern, repository); ok {
+					return !rule.Disable, rule.Expiry
+				}
+			}
+		}
+		return defaultRedirect, 0
+	}, nil
+}
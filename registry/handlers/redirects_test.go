@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	policy, err := newRedirectPolicy([]configuration.RedirectPolicy{
+		{Repositories: []string{"private/*"}, Disable: true},
+		{Repositories: []string{"public/*", "library/*"}, Expiry: 15 * time.Minute},
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected error compiling redirect policy: %v", err)
+	}
+
+	for _, tc := range []struct {
+		repository string
+		redirect   bool
+		expiry     time.Duration
+	}{
+		{"private/app", false, 0},
+		{"public/app", true, 15 * time.Minute},
+		{"library/alpine", true, 15 * time.Minute},
+		{"team/app", false, 0},
+	} {
+		if redirect, expiry := policy(tc.repository); redirect != tc.redirect || expiry != tc.expiry {
+			t.Errorf("unexpected redirect of %s: %v, %v", tc.repository, redirect, expiry)
+		}
+	}
+
+	if policy, err := newRedirectPolicy(nil, true); err != nil || policy != nil {
+		t.Fatalf("expected no policy without rules: %v", err)
+	}
+	for _, rules := range [][]configuration.RedirectPolicy{
+		{{Disable: true}},
+		{{Repositories: []string{"["}}},
+	} {
+		if _, err := newRedirectPolicy(rules, true); err == nil {
+			t.Errorf("expected an error compiling %v", rules)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// referrersDispatcher constructs the handler listing the referrers of a
+// manifest.
+func referrersDispatcher(ctx *Context, r *http.Request) http.Handler {
+	dgst, err := getDigest(ctx)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		})
+	}
+
+	referrersHandler := &referrersHandler{
+		Context: ctx,
+		Digest:  dgst,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(referrersHandler.GetReferrers),
+	}
+}
+
+// referrersHandler handles requests for the referrers of a manifest.
+type referrersHandler struct {
+	*Context
+
+	Digest digest.Digest
+}
+
+type referrersAPIResponse struct {
+	SchemaVersion int                `json:"schemaVersion"`
+	MediaType     string             `json:"mediaType"`
+	Manifests     []storage.Referrer `json:"manifests"`
+}
+
+// GetReferrers returns an image index of the manifests referring to the
+// manifest, optionally filtered by artifact type.
+func (rh *referrersHandler) GetReferrers(w http.ResponseWriter, r *http.Request) {
+	var referrers []storage.Referrer
+	var err error
+	if rh.App.metadataIndex != nil {
+		referrers, err = rh.App.metadataIndex.Referrers(rh.Repository.Named().Name(), rh.Digest)
+	} else {
+		referrers, err = storage.NewReferrerIndex(rh.App.driver, rh.Repository.Named()).Lookup(rh, rh.Digest)
+	}
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	manifests, err := rh.Repository.Manifests(rh)
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	artifactType := r.URL.Query().Get("artifactType")
+
+	// the index is not updated when manifests are garbage collected
+	existing := make([]storage.Referrer, 0, len(referrers))
+	for _, referrer := range referrers {
+		if artifactType != "" && referrer.ArtifactType != artifactType {
+			continue
+		}
+		exists, err := manifests.Exists(rh, referrer.Digest)
+		if err != nil {
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if exists {
+			existing = append(existing, referrer)
+		}
+	}
+
+	w.Header().Set("Content-Type", v1.MediaTypeImageIndex)
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(referrersAPIResponse{
+		SchemaVersion: 2,
+		MediaType:     v1.MediaTypeImageIndex,
+		Manifests:     existing,
+	}); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReferrersAPI(t *testing.T) {
+	testReferrersAPI(t, configuration.MetadataIndex{})
+}
+
+func TestReferrersAPIWithMetadataIndex(t *testing.T) {
+	testReferrersAPI(t, configuration.MetadataIndex{Enabled: true, Path: filepath.Join(t.TempDir(), "index.db")})
+}
+
+func testReferrersAPI(t *testing.T, metadataIndex configuration.MetadataIndex) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		MetadataIndex: metadataIndex,
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, config)
+	defer env.app.CloseMetadataIndex()
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	versioned := manifest.Versioned{
+		SchemaVersion: 2,
+		MediaType:     v1.MediaTypeImageManifest,
+	}
+	image := pushOCIManifest(t, env, imageName, ocischema.Manifest{
+		Versioned: versioned,
+		Config:    pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux"}`)),
+		Layers:    []distribution.Descriptor{},
+	}, "latest")
+	emptyConfig := pushBlob(t, env, imageName, "application/vnd.oci.empty.v1+json", []byte("{}"))
+	artifact := func(artifactType, content string) ocischema.Manifest {
+		return ocischema.Manifest{
+			Versioned:    versioned,
+			ArtifactType: artifactType,
+			Config:       emptyConfig,
+			Layers: []distribution.Descriptor{
+				pushBlob(t, env, imageName, artifactType, []byte(content)),
+			},
+			Subject:     &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: image},
+			Annotations: map[string]string{"org.example.content": content},
+		}
+	}
+
+	// the subject of the manifest is acknowledged
+	signature, err := ocischema.FromStruct(artifact("application/vnd.dev.cosign.artifact.sig.v1+json", "signature"))
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	_, payload, _ := signature.Payload()
+	signatureDigest := digest.FromBytes(payload)
+	manifestURL, err := env.builder.BuildManifestURL(mustWithDigest(t, imageName, signatureDigest))
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp := putManifest(t, "putting signature", manifestURL, v1.MediaTypeImageManifest, signature)
+	defer resp.Body.Close()
+	checkResponse(t, "putting signature", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{"OCI-Subject": []string{image.String()}})
+
+	sbom := pushOCIManifest(t, env, imageName, artifact("application/spdx+json", "sbom"), "")
+	deleted := pushOCIManifest(t, env, imageName, artifact("application/spdx+json", "deleted"), "")
+	manifestURL, err = env.builder.BuildManifestURL(mustWithDigest(t, imageName, deleted))
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp, err = httpDelete(manifestURL)
+	if err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	getReferrers := func(subject digest.Digest, values url.Values) (*http.Response, referrersAPIResponse) {
+		referrersURL, err := env.builder.BuildReferrersURL(mustWithDigest(t, imageName, subject), values)
+		if err != nil {
+			t.Fatalf("unexpected error building referrers url: %v", err)
+		}
+		resp, err := http.Get(referrersURL)
+		if err != nil {
+			t.Fatalf("unexpected error fetching referrers: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "fetching referrers", resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{"Content-Type": []string{v1.MediaTypeImageIndex}})
+
+		var body referrersAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("error decoding referrers response: %v", err)
+		}
+		if body.SchemaVersion != 2 || body.MediaType != v1.MediaTypeImageIndex {
+			t.Fatalf("unexpected referrers index: %+v", body)
+		}
+		return resp, body
+	}
+
+	resp, body := getReferrers(image, nil)
+	if resp.Header.Get("OCI-Filters-Applied") != "" {
+		t.Fatalf("unexpected filters applied: %q", resp.Header.Get("OCI-Filters-Applied"))
+	}
+	expected := map[digest.Digest]string{
+		signatureDigest: "application/vnd.dev.cosign.artifact.sig.v1+json",
+		sbom:            "application/spdx+json",
+	}
+	if len(body.Manifests) != len(expected) {
+		t.Fatalf("unexpected referrers: %+v", body.Manifests)
+	}
+	for _, referrer := range body.Manifests {
+		if expected[referrer.Digest] != referrer.ArtifactType || referrer.MediaType != v1.MediaTypeImageManifest || referrer.Annotations["org.example.content"] == "" {
+			t.Errorf("unexpected referrer: %+v", referrer)
+		}
+	}
+
+	resp, body = getReferrers(image, url.Values{"artifactType": []string{"application/spdx+json"}})
+	checkHeaders(t, resp, http.Header{"OCI-Filters-Applied": []string{"artifactType"}})
+	if len(body.Manifests) != 1 || body.Manifests[0].Digest != sbom {
+		t.Fatalf("unexpected filtered referrers: %+v", body.Manifests)
+	}
+
+	// subjects without referrers have an empty index
+	if _, body = getReferrers(sbom, nil); body.Manifests == nil || len(body.Manifests) != 0 {
+		t.Fatalf("unexpected referrers of manifest without referrers: %+v", body.Manifests)
+	}
+}
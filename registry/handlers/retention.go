@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// retentionRule removes the tags of the repositories matching one of its
+// repository patterns, or of every repository if it has none, which none of
+// its conditions keeps.
+type retentionRule struct {
+	repositories []string
+	keepLast     int
+	keep         []*regexp.Regexp
+	maxAge       time.Duration
+}
+
+// retention removes the old tags of repositories by the first rule matching
+// each of them.
+type retention struct {
+	rules           []retentionRule
+	deleteManifests bool
+	dryRun          bool
+}
+
+// newRetention validates the rules, returning nil if none are configured.
+func newRetention(config configuration.Retention) (*retention, error) {
+	if len(config.Rules) == 0 {
+		return nil, nil
+	}
+
+	r := &retention{
+		deleteManifests: config.DeleteManifests,
+		dryRun:          config.DryRun,
+	}
+	for i, rule := range config.Rules {
+		if rule.KeepLast < 0 || rule.MaxAge < 0 {
+			return nil, fmt.Errorf("retention.rules[%d]: keeplast and maxage cannot be negative", i)
+		}
+		if rule.KeepLast == 0 && len(rule.Keep) == 0 && rule.MaxAge == 0 {
+			return nil, fmt.Errorf("retention.rules[%d]: at least one of keeplast, keep or maxage is required", i)
+		}
+		for _, pattern := range rule.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("retention.rules[%d]: invalid pattern %q: %v", i, pattern, err)
+			}
+		}
+
+		rr := retentionRule{
+			repositories: rule.Repositories,
+			keepLast:     rule.KeepLast,
+			maxAge:       rule.MaxAge,
+		}
+		for _, expr := range rule.Keep {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("retention.rules[%d]: invalid regular expression %q: %v", i, expr, err)
+			}
+			rr.keep = append(rr.keep, re)
+		}
+		r.rules = append(r.rules, rr)
+	}
+	return r, nil
+}
+
+// rule returns the first rule applying to the named repository, or nil if
+// its tags are kept.
+func (r *retention) rule(name string) *retentionRule {
+	for i, rule := range r.rules {
+		if len(rule.repositories) == 0 || matchesGlob(rule.repositories, name) {
+			return &r.rules[i]
+		}
+	}
+	return nil
+}
+
+// expired returns the tags which none of the conditions of the rule keeps
+// at now. The tags are ordered most recently pushed first.
+func (rule *retentionRule) expired(tags []storage.TagInfo, now time.Time) []storage.TagInfo {
+	var expired []storage.TagInfo
+	for i, tag := range tags {
+		if i < rule.keepLast {
+			continue
+		}
+		if rule.maxAge > 0 && now.Sub(tag.TaggedAt) < rule.maxAge {
+			continue
+		}
+		if rule.keeps(tag.Name) {
+			continue
+		}
+		expired = append(expired, tag)
+	}
+	return expired
+}
+
+// keeps reports whether the tag matches one of the kept expressions.
+func (rule *retentionRule) keeps(tag string) bool {
+	for _, re := range rule.keep {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRetention removes the expired tags of the repositories listed in the
+// catalog, notifying the listeners of each tag and manifest removed.
+func (app *App) applyRetention(ctx context.Context) error {
+	if app.retention == nil {
+		return nil
+	}
+
+	listener := app.backgroundEventBridge("retention")
+	now := time.Now()
+	repos := make([]string, quotaRepositoriesPage)
+	last := ""
+	for {
+		n, err := app.registry.Repositories(ctx, repos, last)
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && err != io.EOF && !ok {
+			return err
+		}
+		for _, repo := range repos[:n] {
+			rule := app.retention.rule(repo)
+			if rule == nil {
+				continue
+			}
+			named, err := reference.WithName(repo)
+			if err != nil {
+				return err
+			}
+			if err := app.applyRetentionRule(ctx, named, rule, listener, now); err != nil {
+				return fmt.Errorf("failed to apply retention to %s: %v", repo, err)
+			}
+		}
+		if err != nil || n == 0 {
+			return nil
+		}
+		last = repos[n-1]
+	}
+}
+
+// applyRetentionRule removes the tags of the named repository the rule
+// expires, except protected ones, then the manifests they referred to if
+// configured.
+func (app *App) applyRetentionRule(ctx context.Context, named reference.Named, rule *retentionRule, listener notifications.Listener, now time.Time) error {
+	tags, err := storage.ListTags(ctx, app.driverFor(named.Name()), named)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	removed := make(map[string]struct{})
+	var expired []storage.TagInfo
+	for _, tag := range rule.expired(tags, now) {
+		if app.tagProtection.protected(named.Name(), tag.Name) {
+			continue
+		}
+		removed[tag.Name] = struct{}{}
+		expired = append(expired, tag)
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	repository, err := app.registry.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+	repository, _ = notifications.Listen(repository, nil, listener)
+
+	log := dcontext.GetLogger(app)
+	tagService := repository.Tags(ctx)
+	for _, tag := range expired {
+		log.Infof("retention: removing tag %s:%s pushed at %s", named.Name(), tag.Name, tag.TaggedAt.Format(time.RFC3339))
+		if app.retention.dryRun {
+			continue
+		}
+		if err := tagService.Untag(ctx, tag.Name); err != nil {
+			return err
+		}
+	}
+
+	if !app.retention.deleteManifests {
+		return nil
+	}
+
+	// the manifests of the remaining tags, and those their indexes
+	// reference, are kept
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	referenced := make(map[digest.Digest]struct{})
+	for _, tag := range tags {
+		if _, ok := removed[tag.Name]; ok {
+			continue
+		}
+		referenced[tag.Digest] = struct{}{}
+		manifest, err := manifests.Get(ctx, tag.Digest)
+		if err != nil {
+			return err
+		}
+		for _, desc := range manifest.References() {
+			referenced[desc.Digest] = struct{}{}
+		}
+	}
+
+	deleted := make(map[digest.Digest]struct{})
+	for _, tag := range expired {
+		if _, ok := referenced[tag.Digest]; ok {
+			continue
+		}
+		if _, ok := deleted[tag.Digest]; ok {
+			continue
+		}
+		deleted[tag.Digest] = struct{}{}
+
+		log.Infof("retention: deleting manifest %s@%s", named.Name(), tag.Digest)
+		if app.retention.dryRun {
+			continue
+		}
+		if err := manifests.Delete(ctx, tag.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNewRetention(t *testing.T) {
+	if r, err := newRetention(configuration.Retention{}); err != nil || r != nil {
+		t.Fatalf("expected no retention, got %v, %v", r, err)
+	}
+
+	for _, rule := range []configuration.RetentionRule{
+		{},
+		{KeepLast: -1},
+		{Keep: []string{"("}},
+		{Repositories: []string{"["}, KeepLast: 1},
+	} {
+		config := configuration.Retention{Rules: []configuration.RetentionRule{rule}}
+		if _, err := newRetention(config); err == nil {
+			t.Errorf("expected error for %+v", rule)
+		}
+	}
+
+	r, err := newRetention(configuration.Retention{
+		Rules: []configuration.RetentionRule{
+			{Repositories: []string{"ci/*"}, KeepLast: 2},
+			{Repositories: []string{"library/*"}, Keep: []string{"^v[0-9]+"}, MaxAge: 24 * time.Hour},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rule := r.rule("other/app"); rule != nil {
+		t.Fatalf("unexpected rule for a repository no rule matches: %+v", rule)
+	}
+
+	now := time.Now()
+	tags := []storage.TagInfo{
+		{Name: "latest", TaggedAt: now.Add(-time.Hour)},
+		{Name: "v2", TaggedAt: now.Add(-48 * time.Hour)},
+		{Name: "nightly", TaggedAt: now.Add(-72 * time.Hour)},
+		{Name: "v1", TaggedAt: now.Add(-96 * time.Hour)},
+	}
+	for _, tc := range []struct {
+		name     string
+		expected []string
+	}{
+		{"ci/build", []string{"nightly", "v1"}},
+		{"library/ubuntu", []string{"nightly"}},
+	} {
+		var expired []string
+		for _, tag := range r.rule(tc.name).expired(tags, now) {
+			expired = append(expired, tag.Name)
+		}
+		if !reflect.DeepEqual(expired, tc.expected) {
+			t.Errorf("unexpected expired tags of %s: %v != %v", tc.name, expired, tc.expected)
+		}
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+		},
+		TagProtection: configuration.TagProtection{
+			Rules: []configuration.TagProtectionRule{{Tags: []string{"stable"}}},
+		},
+		Retention: configuration.Retention{
+			Rules: []configuration.RetentionRule{
+				{Repositories: []string{"foo/*"}, KeepLast: 1, Keep: []string{"^v"}},
+			},
+			DeleteManifests: true,
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/retained")
+	tags := []string{"old", "v1", "stable", "latest"}
+	digests := make(map[string]digest.Digest)
+	for i, tag := range tags {
+		imageConfig := []byte(fmt.Sprintf(`{"architecture": "amd64", "os": "linux", "rootfs": {"type": "layers", "diff_ids": []}, "created": "2024-01-0%dT00:00:00Z"}`, i+1))
+		dgst := digest.FromBytes(imageConfig)
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(imageConfig))
+
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: manifest.Versioned{
+				SchemaVersion: 2,
+				MediaType:     v1.MediaTypeImageManifest,
+			},
+			Config: distribution.Descriptor{
+				Digest:    dgst,
+				Size:      int64(len(imageConfig)),
+				MediaType: v1.MediaTypeImageConfig,
+			},
+		})
+		if err != nil {
+			t.Fatalf("error creating manifest: %v", err)
+		}
+		ref, _ := reference.WithTag(name, tag)
+		u, err := env.builder.BuildManifestURL(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := putManifest(t, "pushing "+tag, u, v1.MediaTypeImageManifest, m)
+		resp.Body.Close()
+		checkResponse(t, "pushing "+tag, resp, http.StatusCreated)
+
+		_, payload, _ := m.Payload()
+		digests[tag] = digest.FromBytes(payload)
+	}
+
+	if err := env.app.applyRetention(context.Background()); err != nil {
+		t.Fatalf("unexpected error applying retention: %v", err)
+	}
+
+	remaining, err := storage.ListTags(context.Background(), env.app.driver, name)
+	if err != nil {
+		t.Fatalf("unexpected error listing tags: %v", err)
+	}
+	var remainingTags []string
+	for _, tag := range remaining {
+		remainingTags = append(remainingTags, tag.Name)
+	}
+	if expected := []string{"latest", "stable", "v1"}; !reflect.DeepEqual(remainingTags, expected) {
+		t.Fatalf("unexpected remaining tags: %v != %v", remainingTags, expected)
+	}
+
+	repository, err := env.app.registry.Repository(context.Background(), name)
+	if err != nil {
+		t.Fatalf("unexpected error getting repository: %v", err)
+	}
+	manifests, err := repository.Manifests(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error getting manifest service: %v", err)
+	}
+	revisions := make(map[digest.Digest]struct{})
+	err = manifests.(distribution.ManifestEnumerator).Enumerate(context.Background(), func(dgst digest.Digest) error {
+		revisions[dgst] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error enumerating manifests: %v", err)
+	}
+	for tag, expected := range map[string]bool{"old": false, "stable": true, "v1": true, "latest": true} {
+		if _, ok := revisions[digests[tag]]; ok != expected {
+			t.Errorf("unexpected presence of the manifest of %s: %v != %v", tag, ok, expected)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// Media types of the SBOM documents which are indexed, either as the
+// artifact type of an OCI manifest or as the media type of its config.
+const (
+	mediaTypeSPDX      = "application/spdx+json"
+	mediaTypeCycloneDX = "application/vnd.cyclonedx+json"
+)
+
+// defaultSBOMMaxSize is the size of the largest SBOM document indexed, unless
+// configured.
+const defaultSBOMMaxSize = 16 << 20
+
+// sbomFormats maps the media types of the SBOM documents to their format.
+var sbomFormats = map[string]string{
+	mediaTypeSPDX:      "spdx",
+	mediaTypeCycloneDX: "cyclonedx",
+}
+
+// sbomsDispatcher constructs the SBOMs handler api endpoint.
+func sbomsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	sbomsHandler := &sbomsHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(sbomsHandler.GetSBOMs),
+	}
+}
+
+// sbomsHandler handles requests for images by the packages listed in their
+// SBOMs under a repository name.
+type sbomsHandler struct {
+	*Context
+}
+
+type sbomsAPIResponse struct {
+	Name    string          `json:"name"`
+	Package string          `json:"package"`
+	Version string          `json:"version,omitempty"`
+	Images  []sbomAPIResult `json:"images"`
+}
+
+type sbomAPIResult struct {
+	Digest   digest.Digest `json:"digest"`
+	SBOM     digest.Digest `json:"sbom"`
+	Format   string        `json:"format"`
+	Package  string        `json:"package"`
+	Versions []string      `json:"versions"`
+}
+
+// GetSBOMs returns the images of the repository whose SBOMs list the
+// requested package, optionally restricted to a version.
+func (sh *sbomsHandler) GetSBOMs(w http.ResponseWriter, r *http.Request) {
+	if !sh.App.Config.SBOMIndex.Enabled {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnsupported.WithMessage("SBOMs are not indexed"))
+		return
+	}
+
+	q := r.URL.Query()
+	pkg, version := q.Get("package"), q.Get("version")
+	if pkg == "" {
+		sh.Errors = append(sh.Errors, v2.ErrorCodeSBOMQueryInvalid.WithDetail("package is required"))
+		return
+	}
+
+	index := storage.NewSBOMIndex(sh.App.driver, sh.Repository.Named())
+	entries, err := index.Lookup(sh, pkg, version)
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	manifests, err := sh.Repository.Manifests(sh)
+	if err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	// the index is not updated when images or SBOMs are deleted
+	images := make([]sbomAPIResult, 0, len(entries))
+	for _, entry := range entries {
+		exists, err := manifestsExist(sh, manifests, entry.Subject, entry.SBOM)
+		if err != nil {
+			sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if exists {
+			images = append(images, sbomAPIResult{
+				Digest:   entry.Subject,
+				SBOM:     entry.SBOM,
+				Format:   entry.Format,
+				Package:  entry.Package,
+				Versions: entry.Versions,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(sbomsAPIResponse{
+		Name:    sh.Repository.Named().Name(),
+		Package: pkg,
+		Version: version,
+		Images:  images,
+	}); err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// manifestsExist returns true if all the manifests exist.
+func manifestsExist(ctx context.Context, manifests distribution.ManifestService, dgsts ...digest.Digest) (bool, error) {
+	for _, dgst := range dgsts {
+		exists, err := manifests.Exists(ctx, dgst)
+		if err != nil || !exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// indexSBOM indexes the packages listed by the manifest if it is an SBOM
+// artifact referring to an image.
+func (imh *manifestHandler) indexSBOM(manifest distribution.Manifest, revision digest.Digest) error {
+	if !imh.App.Config.SBOMIndex.Enabled {
+		return nil
+	}
+
+	m, ok := manifest.(*ocischema.DeserializedManifest)
+	if !ok || m.Subject == nil {
+		return nil
+	}
+	format, document, ok := artifactDocument(m, sbomFormats)
+	if !ok {
+		return nil
+	}
+
+	maxSize := imh.App.Config.SBOMIndex.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultSBOMMaxSize
+	}
+	if document.Size > maxSize {
+		return fmt.Errorf("SBOM %s of %d bytes exceeds the maximum size of %d bytes", document.Digest, document.Size, maxSize)
+	}
+
+	p, err := imh.Repository.Blobs(imh).Get(imh, document.Digest)
+	if err != nil {
+		return err
+	}
+
+	packages, err := parseSBOM(format, p)
+	if err != nil {
+		return err
+	}
+
+	index := storage.NewSBOMIndex(imh.App.driver, imh.Repository.Named())
+	return index.Add(imh, m.Subject.Digest, revision, format, packages)
+}
+
+// artifactDocument returns the type and the descriptor of the document of
+// the artifact, if its artifact type, or the media type of its config, is
+// one of the given media types, which map to their type. The document is the
+// layer of that media type, or the single layer of the manifest.
+func artifactDocument(m *ocischema.DeserializedManifest, mediaTypes map[string]string) (string, distribution.Descriptor, bool) {
+	mediaType := m.ArtifactType
+	if mediaType == "" {
+		mediaType = m.Config.MediaType
+	}
+	typ, ok := mediaTypes[mediaType]
+	if !ok {
+		return "", distribution.Descriptor{}, false
+	}
+
+	for _, layer := range m.Layers {
+		if layer.MediaType == mediaType {
+			return typ, layer, true
+		}
+	}
+	if len(m.Layers) == 1 {
+		return typ, m.Layers[0], true
+	}
+	return "", distribution.Descriptor{}, false
+}
+
+// parseSBOM returns the packages listed by the SBOM document of the format.
+func parseSBOM(format string, p []byte) ([]storage.SBOMPackage, error) {
+	var packages []storage.SBOMPackage
+	switch format {
+	case "spdx":
+		var document struct {
+			Packages []struct {
+				Name        string `json:"name"`
+				VersionInfo string `json:"versionInfo"`
+			} `json:"packages"`
+		}
+		if err := json.Unmarshal(p, &document); err != nil {
+			return nil, fmt.Errorf("invalid SPDX document: %v", err)
+		}
+		for _, pkg := range document.Packages {
+			packages = append(packages, storage.SBOMPackage{Name: pkg.Name, Version: pkg.VersionInfo})
+		}
+	case "cyclonedx":
+		var document struct {
+			Components []cycloneDXComponent `json:"components"`
+		}
+		if err := json.Unmarshal(p, &document); err != nil {
+			return nil, fmt.Errorf("invalid CycloneDX document: %v", err)
+		}
+		packages = appendCycloneDXComponents(packages, document.Components)
+	}
+	return packages, nil
+}
+
+// cycloneDXComponent is a component of a CycloneDX document, which may
+// include nested components.
+type cycloneDXComponent struct {
+	Name       string               `json:"name"`
+	Version    string               `json:"version"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+func appendCycloneDXComponents(packages []storage.SBOMPackage, components []cycloneDXComponent) []storage.SBOMPackage {
+	for _, component := range components {
+		packages = append(packages, storage.SBOMPackage{Name: component.Name, Version: component.Version})
+		packages = appendCycloneDXComponents(packages, component.Components)
+	}
+	return packages
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/testutil"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSBOMsAPI(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.SBOMIndex.Enabled = true
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+
+	rs, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer: %v", err)
+	}
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, rs)
+
+	image := ocischema.Manifest{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageManifest,
+		},
+		Config: pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux", "rootfs": {"type": "layers", "diff_ids": []}}`)),
+		Layers: []distribution.Descriptor{
+			{
+				Digest:    layerDigest,
+				Size:      6323,
+				MediaType: v1.MediaTypeImageLayer,
+			},
+		},
+	}
+	imageDigest := pushOCIManifest(t, env, imageName, image, "latest")
+	subject := &distribution.Descriptor{
+		MediaType: v1.MediaTypeImageManifest,
+		Digest:    imageDigest,
+	}
+
+	emptyConfig := pushBlob(t, env, imageName, "application/vnd.oci.empty.v1+json", []byte("{}"))
+	spdxDigest := pushOCIManifest(t, env, imageName, ocischema.Manifest{
+		Versioned:    image.Versioned,
+		ArtifactType: mediaTypeSPDX,
+		Config:       emptyConfig,
+		Layers: []distribution.Descriptor{
+			pushBlob(t, env, imageName, mediaTypeSPDX, []byte(`{"spdxVersion": "SPDX-2.3", "packages": [{"name": "log4j-core", "versionInfo": "2.14.1"}, {"name": "zlib", "versionInfo": "1.2.11"}]}`)),
+		},
+		Subject: subject,
+	}, "")
+	cycloneDXDigest := pushOCIManifest(t, env, imageName, ocischema.Manifest{
+		Versioned: image.Versioned,
+		Config:    pushBlob(t, env, imageName, mediaTypeCycloneDX, []byte(`{}`)),
+		Layers: []distribution.Descriptor{
+			pushBlob(t, env, imageName, mediaTypeCycloneDX, []byte(`{"bomFormat": "CycloneDX", "components": [{"name": "spring-boot", "version": "2.5.0", "components": [{"name": "log4j-api", "version": "2.14.1"}]}]}`)),
+		},
+		Subject: subject,
+	}, "")
+
+	for _, tc := range []struct {
+		pkg, version string
+		status       int
+		expected     []sbomAPIResult
+	}{
+		{pkg: "log4j-core", version: "2.14", status: http.StatusOK, expected: []sbomAPIResult{
+			{Digest: imageDigest, SBOM: spdxDigest, Format: "spdx", Package: "log4j-core", Versions: []string{"2.14.1"}},
+		}},
+		{pkg: "log4j-core", version: "2.17", status: http.StatusOK, expected: []sbomAPIResult{}},
+		{pkg: "log4j-api", status: http.StatusOK, expected: []sbomAPIResult{
+			{Digest: imageDigest, SBOM: cycloneDXDigest, Format: "cyclonedx", Package: "log4j-api", Versions: []string{"2.14.1"}},
+		}},
+		{status: http.StatusBadRequest},
+	} {
+		sbomsURL, err := env.builder.BuildSBOMsURL(imageName, url.Values{
+			"package": []string{tc.pkg},
+			"version": []string{tc.version},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error building sboms url: %v", err)
+		}
+
+		resp, err := http.Get(sbomsURL)
+		if err != nil {
+			t.Fatalf("unexpected error querying sboms: %v", err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, "querying "+tc.pkg, resp, tc.status)
+		if tc.status != http.StatusOK {
+			checkBodyHasErrorCodes(t, "querying "+tc.pkg, resp, v2.ErrorCodeSBOMQueryInvalid)
+			continue
+		}
+
+		var body sbomsAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("error decoding sboms response: %v", err)
+		}
+		if !reflect.DeepEqual(body.Images, tc.expected) {
+			t.Errorf("unexpected images for %s %s: %v != %v", tc.pkg, tc.version, body.Images, tc.expected)
+		}
+	}
+}
+
+// pushBlob pushes the content to the repository, returning its descriptor.
+func pushBlob(t *testing.T, env *testEnv, name reference.Named, mediaType string, p []byte) distribution.Descriptor {
+	dgst := digest.FromBytes(p)
+	uploadURLBase, _ := startPushLayer(t, env, name)
+	pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(p))
+	return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(p))}
+}
+
+// pushOCIManifest pushes the manifest to the repository, under the tag if
+// set and by digest otherwise, returning its digest.
+func pushOCIManifest(t *testing.T, env *testEnv, name reference.Named, m ocischema.Manifest, tag string) digest.Digest {
+	deserialized, err := ocischema.FromStruct(m)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	_, payload, _ := deserialized.Payload()
+	dgst := digest.FromBytes(payload)
+
+	var ref reference.Named
+	ref, _ = reference.WithDigest(name, dgst)
+	if tag != "" {
+		ref, _ = reference.WithTag(name, tag)
+	}
+	manifestURL, err := env.builder.BuildManifestURL(ref)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp := putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, deserialized)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest", resp, http.StatusCreated)
+	return dgst
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	prometheus "github.com/docker/distribution/metrics"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/go-metrics"
+)
+
+const defaultScheduledUploadPurgeAge = 7 * 24 * time.Hour
+
+var (
+	// maintenanceLastRunGauge records when each scheduled task last ran.
+	maintenanceLastRunGauge = prometheus.MaintenanceNamespace.NewLabeledGauge("last_run_timestamp", "The time each scheduled maintenance task last ran, in seconds since the epoch", metrics.Seconds, "task")
+	// maintenanceDurationGauge records how long each scheduled task last ran.
+	maintenanceDurationGauge = prometheus.MaintenanceNamespace.NewLabeledGauge("last_run_duration", "The duration of the last run of each scheduled maintenance task", metrics.Seconds, "task")
+	// maintenanceReclaimedGauge records the bytes reclaimed by the last run
+	// of each scheduled task.
+	maintenanceReclaimedGauge = prometheus.MaintenanceNamespace.NewLabeledGauge("last_run_reclaimed", "The number of bytes reclaimed by the last run of each scheduled maintenance task", metrics.Bytes, "task")
+	// maintenanceFailures counts the failed runs of each scheduled task.
+	maintenanceFailures = prometheus.MaintenanceNamespace.NewLabeledCounter("failures", "The number of failed runs of each scheduled maintenance task", "task")
+)
+
+func init() {
+	metrics.Register(prometheus.MaintenanceNamespace)
+}
+
+// scheduledTask is a maintenance task run at the times of a cron schedule.
+// run returns the number of bytes reclaimed.
+type scheduledTask struct {
+	name     string
+	schedule *cronSchedule
+	run      func(ctx context.Context) (int64, error)
+}
+
+// startScheduler schedules the maintenance tasks whose cron expression is
+// configured. Invalid expressions panic, as other invalid maintenance
+// configuration does.
+func (app *App) startScheduler(config configuration.Schedule) {
+	var tasks []scheduledTask
+
+	if config.GC != "" {
+		if !app.Config.GC.Enabled {
+			panic("scheduled garbage collection requires gc to be enabled")
+		}
+		schedule, err := parseCronSchedule(config.GC)
+		if err != nil {
+			panic(fmt.Sprintf("invalid gc schedule: %v", err))
+		}
+		tasks = append(tasks, scheduledTask{name: "gc", schedule: schedule, run: app.scheduledGC})
+	}
+
+	if config.Retention != "" {
+		if app.retention == nil {
+			panic("scheduled retention requires retention rules")
+		}
+		schedule, err := parseCronSchedule(config.Retention)
+		if err != nil {
+			panic(fmt.Sprintf("invalid retention schedule: %v", err))
+		}
+		tasks = append(tasks, scheduledTask{name: "retention", schedule: schedule, run: func(ctx context.Context) (int64, error) {
+			return 0, app.applyRetention(ctx)
+		}})
+	}
+
+	if config.UploadPurging != "" {
+		schedule, err := parseCronSchedule(config.UploadPurging)
+		if err != nil {
+			panic(fmt.Sprintf("invalid upload purging schedule: %v", err))
+		}
+		age := config.UploadPurgeAge
+		if age <= 0 {
+			age = app.Config.Uploads.TTL
+		}
+		if age <= 0 {
+			age = defaultScheduledUploadPurgeAge
+		}
+		tasks = append(tasks, scheduledTask{name: "uploadpurging", schedule: schedule, run: func(ctx context.Context) (int64, error) {
+			return 0, app.scheduledUploadPurge(ctx, age)
+		}})
+	}
+
+	for _, task := range tasks {
+		task := task
+		app.goBackground(func(ctx context.Context) {
+			app.runScheduledTask(ctx, task)
+		})
+	}
+}
+
+// runScheduledTask runs the task at each time of its schedule, recording
+// the outcome of each run in the maintenance metrics, until ctx is done.
+func (app *App) runScheduledTask(ctx context.Context, task scheduledTask) {
+	log := dcontext.GetLogger(app)
+	log.Infof("schedule: running %s at %s", task.name, task.schedule)
+
+	for {
+		next := task.schedule.next(time.Now())
+		if !sleepContext(ctx, time.Until(next)) {
+			return
+		}
+
+		start := time.Now()
+		reclaimed, err := task.run(ctx)
+		duration := time.Since(start)
+
+		maintenanceLastRunGauge.WithValues(task.name).Set(float64(start.Unix()))
+		maintenanceDurationGauge.WithValues(task.name).Set(duration.Seconds())
+		if err != nil {
+			maintenanceFailures.WithValues(task.name).Inc(1)
+			log.Errorf("schedule: error running %s: %v", task.name, err)
+			continue
+		}
+		maintenanceReclaimedGauge.WithValues(task.name).Set(float64(reclaimed))
+		log.Infof("schedule: ran %s in %s, reclaiming %d bytes", task.name, duration, reclaimed)
+	}
+}
+
+// scheduledGC collects garbage online with the options of the gc section,
+// unless a collection is already running.
+func (app *App) scheduledGC(ctx context.Context) (int64, error) {
+	req := gcRequest{
+		DryRun:         app.Config.GC.DryRun,
+		RemoveUntagged: app.Config.GC.RemoveUntagged,
+	}
+	if !app.gc.begin(req) {
+		return 0, fmt.Errorf("a garbage collection is already running")
+	}
+
+	report := &storage.GCReport{}
+	err := app.collectGarbage(ctx, req, report)
+	app.gc.end(err)
+	if err != nil || req.DryRun {
+		return 0, err
+	}
+	return report.ReclaimableBytes, nil
+}
+
+// scheduledUploadPurge removes the upload sessions older than age.
+func (app *App) scheduledUploadPurge(ctx context.Context, age time.Duration) error {
+	_, errs := storage.PurgeUploads(ctx, app.driver, time.Now().Add(-age), true)
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to purge %d upload sessions: %v", len(errs), errs[0])
+	}
+	return nil
+}
+
+// sleepContext waits for the duration, returning false if ctx is done
+// first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// cronSchedule is a cron expression of five fields: minute, hour, day of
+// month, month and day of week. Each field is a *, or a comma separated
+// list of values and ranges, optionally stepped as in */15 or 1-5/2.
+type cronSchedule struct {
+	expr       string
+	minutes    uint64
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+	anyDay     bool
+	anyWeekday bool
+}
+
+// cronDescriptors are the shorthands accepted in place of an expression.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCronSchedule parses a cron expression or descriptor such as @daily.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		if expanded, ok := cronDescriptors[fields[0]]; ok {
+			fields = strings.Fields(expanded)
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	schedule := &cronSchedule{
+		expr:       expr,
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+	for _, field := range []struct {
+		value    string
+		min, max int
+		bits     *uint64
+	}{
+		{fields[0], 0, 59, &schedule.minutes},
+		{fields[1], 0, 23, &schedule.hours},
+		{fields[2], 1, 31, &schedule.days},
+		{fields[3], 1, 12, &schedule.months},
+		{fields[4], 0, 7, &schedule.weekdays},
+	} {
+		bits, err := parseCronField(field.value, field.min, field.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %v", expr, err)
+		}
+		*field.bits = bits
+	}
+	// both 0 and 7 are sunday
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+	return schedule, nil
+}
+
+// parseCronField returns the values of a cron field as a bit set.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			var err error
+			bounds := strings.SplitN(rng, "-", 2)
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time of the schedule strictly after t, to the
+// minute.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every schedule matches within a few years, unless it names a day
+	// which no month has, such as the 30th of February
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return limit
+}
+
+// matchesDay returns whether the day of t matches the schedule. As in cron,
+// a day matches either restricted field when both the day of month and the
+// day of week are restricted.
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<uint(t.Day())) != 0
+	weekday := s.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+func (s *cronSchedule) String() string {
+	return s.expr
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// a wednesday
+	from := time.Date(2024, time.January, 10, 12, 34, 56, 0, time.UTC)
+
+	for _, tc := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 10, 12, 35, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 10, 12, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.January, 11, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.January, 11, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 10, 13, 0, 0, 0, time.UTC)},
+		{"30 2 * * 0", time.Date(2024, time.January, 14, 2, 30, 0, 0, time.UTC)},
+		{"30 2 * * 7", time.Date(2024, time.January, 14, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2024, time.January, 10, 13, 0, 0, 0, time.UTC)},
+		// either the day of month or the day of week matches
+		{"0 0 15 * 5", time.Date(2024, time.January, 12, 0, 0, 0, 0, time.UTC)},
+		{"0,30 12 10,20 * *", time.Date(2024, time.January, 20, 12, 0, 0, 0, time.UTC)},
+	} {
+		schedule, err := parseCronSchedule(tc.expr)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", tc.expr, err)
+		}
+		if next := schedule.next(from); !next.Equal(tc.next) {
+			t.Errorf("unexpected next time of %q: %s != %s", tc.expr, next, tc.next)
+		}
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"@often",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := parseCronSchedule(expr); err == nil {
+			t.Errorf("expected an error parsing %q", expr)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	defaultScrubInterval = time.Hour
+	defaultScrubSample   = 0.1
+	defaultScrubRate     = 10 << 20 // 10MiB per second
+)
+
+// maxRepairRepositories bounds the repositories a corrupted blob is looked
+// up in on the replicas.
+const maxRepairRepositories = 5
+
+// errEnough stops an enumeration once enough entries are found.
+var errEnough = errors.New("enough entries found")
+
+// startScrubber schedules a goroutine which periodically verifies a sample
+// of the blobs in storage, repairing corrupted blobs from the registries
+// pushes are replicated to if configured.
+func (app *App) startScrubber(config configuration.Scrub, storageDriver storagedriver.StorageDriver) {
+	if !config.Enabled {
+		return
+	}
+
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultScrubInterval
+	}
+	opts := storage.ScrubOpts{
+		Sample:     config.Sample,
+		Rate:       config.Rate,
+		Quarantine: config.Quarantine,
+	}
+	if opts.Sample == 0 {
+		opts.Sample = defaultScrubSample
+	}
+	if opts.Rate <= 0 {
+		opts.Rate = defaultScrubRate
+	}
+
+	// The registry used to serve requests may have a cache in front of the
+	// storage, so scrub with an uncached one.
+	registry, err := storage.NewRegistry(app, storageDriver, storage.Schema1SigningKey(app.trustKey))
+	if err != nil {
+		panic(err)
+	}
+
+	if config.Repair {
+		if len(app.replication) == 0 {
+			dcontext.GetLogger(app).Warnf("scrub: repair enabled without replication, corrupted blobs cannot be repaired")
+		} else {
+			opts.Repair = func(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+				return app.openReplicatedBlob(ctx, registry, dgst)
+			}
+		}
+	}
+
+	log := dcontext.GetLogger(app)
+	log.Infof("scrub: verifying %.0f%% of the blobs every %s at %d bytes per second", opts.Sample*100, interval, opts.Rate)
+
+	app.goBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			start := time.Now()
+			result, err := storage.Scrub(ctx, storageDriver, registry, opts)
+			if err != nil {
+				log.Errorf("scrub: error scrubbing blobs: %v", err)
+			}
+			log.Infof("scrub: verified %d bytes in %s: %v", result.Bytes, time.Since(start), result.Blobs)
+
+			if app.blobDescriptorCache != nil {
+				for dgst, outcome := range result.Corrupted {
+					if outcome == storage.ScrubQuarantined {
+						app.blobDescriptorCache.Clear(ctx, dgst)
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// openReplicatedBlob opens the copy of the blob on a registry pushes are
+// replicated to, looking it up in the repositories the blob is linked in.
+func (app *App) openReplicatedBlob(ctx context.Context, registry distribution.Namespace, dgst digest.Digest) (io.ReadCloser, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	var names []reference.Named
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return nil
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return nil
+		}
+		if _, err := repository.Blobs(ctx).Stat(ctx, dgst); err != nil {
+			return nil
+		}
+
+		names = append(names, named)
+		if len(names) == maxRepairRepositories {
+			return errEnough
+		}
+		return nil
+	})
+	if err != nil && err != errEnough {
+		return nil, err
+	}
+
+	lastErr := fmt.Errorf("no repository links blob %s", dgst)
+	for _, named := range names {
+		for _, sink := range app.replication {
+			rc, err := sink.OpenBlob(ctx, named, dgst)
+			if err == nil {
+				return rc, nil
+			}
+			lastErr = fmt.Errorf("%s on %s: %v", named.Name(), sink.Status().Name, err)
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,524 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest/ocischema"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+)
+
+// Media types and annotations of the signatures and attestations pushed by
+// cosign, under the tags named after the digest of the manifest they sign.
+const (
+	mediaTypeCosignSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	mediaTypeDSSEEnvelope        = "application/vnd.dsse.envelope.v1+json"
+	mediaTypeInTotoStatement     = "application/vnd.in-toto+json"
+
+	annotationCosignSignature   = "dev.cosignproject.cosign/signature"
+	annotationCosignCertificate = "dev.sigstore.cosign/certificate"
+	annotationCosignChain       = "dev.sigstore.cosign/chain"
+	annotationCosignBundle      = "dev.sigstore.cosign/bundle"
+
+	cosignSignatureSuffix   = ".sig"
+	cosignAttestationSuffix = ".att"
+	cosignSBOMSuffix        = ".sbom"
+)
+
+// maxSignaturePayloadSize is the size of the largest signature payload or
+// attestation envelope verified.
+const maxSignaturePayloadSize = 1 << 20
+
+// cosignTagRegexp matches the tags under which cosign stores the signatures,
+// attestations and SBOMs of a manifest, which are not signed themselves.
+var cosignTagRegexp = regexp.MustCompile(`^sha256-[a-f0-9]{64}(\.sig|\.att|\.sbom)$`)
+
+// cosignArtifactMediaTypes are the media types of the layers of the artifacts
+// cosign stores under the tags of each suffix.
+var cosignArtifactMediaTypes = map[string][]string{
+	cosignSignatureSuffix:   {mediaTypeCosignSimpleSigning},
+	cosignAttestationSuffix: {mediaTypeDSSEEnvelope},
+	cosignSBOMSuffix: {
+		"text/spdx",
+		"text/spdx+json",
+		"text/spdx+xml",
+		"application/vnd.cyclonedx",
+		"application/vnd.cyclonedx+json",
+		"application/vnd.cyclonedx+xml",
+		"application/vnd.syft+json",
+	},
+}
+
+var (
+	errSignatureMismatch = errors.New("signature does not match any trusted key")
+	errNoCosignArtifact  = errors.New("no cosign artifact")
+)
+
+// signaturePolicy accepts the push of a tag to a protected repository only
+// if the manifest is signed by a trusted key.
+type signaturePolicy struct {
+	repositories []string
+	keys         []crypto.PublicKey
+	roots        *x509.CertPool
+	identities   *regexp.Regexp
+	rekorKeys    []crypto.PublicKey
+}
+
+// newSignaturePolicy loads the trusted keys and certificates of the policy,
+// returning nil if none are configured.
+func newSignaturePolicy(config configuration.SignaturePolicy) (*signaturePolicy, error) {
+	if len(config.PublicKeys) == 0 && len(config.FulcioRoots) == 0 {
+		if len(config.Repositories) > 0 || len(config.Identities) > 0 || len(config.RekorKeys) > 0 {
+			return nil, fmt.Errorf("policy.signatures: publickeys or fulcioroots are required")
+		}
+		return nil, nil
+	}
+
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("policy.signatures.repositories: invalid pattern %q: %v", pattern, err)
+		}
+	}
+	policy := &signaturePolicy{repositories: config.Repositories}
+
+	for _, filename := range config.PublicKeys {
+		key, err := loadPublicKey(filename)
+		if err != nil {
+			return nil, fmt.Errorf("policy.signatures.publickeys: %v", err)
+		}
+		policy.keys = append(policy.keys, key)
+	}
+
+	if len(config.FulcioRoots) > 0 {
+		if len(config.Identities) == 0 {
+			return nil, fmt.Errorf("policy.signatures.identities: required with fulcioroots")
+		}
+		if len(config.RekorKeys) == 0 {
+			return nil, fmt.Errorf("policy.signatures.rekorkeys: required with fulcioroots")
+		}
+		for _, filename := range config.RekorKeys {
+			key, err := loadPublicKey(filename)
+			if err != nil {
+				return nil, fmt.Errorf("policy.signatures.rekorkeys: %v", err)
+			}
+			policy.rekorKeys = append(policy.rekorKeys, key)
+		}
+		policy.roots = x509.NewCertPool()
+		for _, filename := range config.FulcioRoots {
+			b, err := os.ReadFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("policy.signatures.fulcioroots: %v", err)
+			}
+			if !policy.roots.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("policy.signatures.fulcioroots: no certificates in %s", filename)
+			}
+		}
+
+		patterns := make([]string, len(config.Identities))
+		for i, s := range config.Identities {
+			if _, err := regexp.Compile(s); err != nil {
+				return nil, fmt.Errorf("policy.signatures.identities: %s", err)
+			}
+			// Anchor each pattern, so that it matches the whole identity.
+			patterns[i] = fmt.Sprintf("^(?:%s)$", s)
+		}
+		policy.identities = regexp.MustCompile(strings.Join(patterns, "|"))
+	}
+
+	return policy, nil
+}
+
+// loadPublicKey loads the PEM encoded ECDSA, RSA or Ed25519 public key, in
+// PKIX form.
+func loadPublicKey(filename string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in public key %s", filename)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse public key %s: %v", filename, err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("public key %s is not an ECDSA, RSA or Ed25519 key", filename)
+	}
+}
+
+// protects returns whether the pushes of tags to the repository are subject
+// to the policy. A nil policy protects no repository.
+func (p *signaturePolicy) protects(name string) bool {
+	return p != nil && (len(p.repositories) == 0 || matchesGlob(p.repositories, name))
+}
+
+// checkSignatures returns an error if the manifest pushed under the tag is
+// not signed, as the signature policy requires. The signatures and
+// attestations are read from the tags cosign stores them under, which only
+// cosign artifacts can be pushed to and are not signed themselves.
+func (imh *manifestHandler) checkSignatures(tag string, manifest distribution.Manifest, dgst digest.Digest) error {
+	policy := imh.App.signaturePolicy
+	if tag == "" || !policy.protects(imh.Repository.Named().Name()) {
+		return nil
+	}
+	if match := cosignTagRegexp.FindStringSubmatch(tag); match != nil {
+		if err := checkCosignArtifact(manifest, match[1]); err != nil {
+			return v2.ErrorCodeManifestUnsigned.WithDetail(map[string]interface{}{
+				"digest": dgst,
+				"reason": err.Error(),
+			})
+		}
+		return nil
+	}
+
+	reason := "no signature or attestation found"
+	for _, verify := range []struct {
+		suffix    string
+		mediaType string
+		fn        func([]byte, map[string]string, digest.Digest) error
+	}{
+		{cosignSignatureSuffix, mediaTypeCosignSimpleSigning, policy.verifySignature},
+		{cosignAttestationSuffix, mediaTypeDSSEEnvelope, policy.verifyAttestation},
+	} {
+		tag := fmt.Sprintf("%s-%s%s", dgst.Algorithm(), dgst.Encoded(), verify.suffix)
+		err := imh.verifyCosignArtifact(tag, verify.mediaType, dgst, verify.fn)
+		if err == nil {
+			return nil
+		}
+		if err != errNoCosignArtifact {
+			reason = err.Error()
+		}
+	}
+
+	return v2.ErrorCodeManifestUnsigned.WithDetail(map[string]interface{}{
+		"digest": dgst,
+		"reason": reason,
+	})
+}
+
+// checkCosignArtifact returns an error unless the manifest is a cosign
+// artifact which can be stored under a tag of the suffix: an OCI image
+// manifest whose layers are all of the media types of the suffix.
+func checkCosignArtifact(manifest distribution.Manifest, suffix string) error {
+	m, ok := manifest.(*ocischema.DeserializedManifest)
+	if !ok {
+		return fmt.Errorf("tags ending in %s are reserved to cosign artifacts, which are OCI image manifests", suffix)
+	}
+	if len(m.Layers) == 0 {
+		return fmt.Errorf("tags ending in %s are reserved to cosign artifacts, which have layers", suffix)
+	}
+	for _, layer := range m.Layers {
+		var allowed bool
+		for _, mediaType := range cosignArtifactMediaTypes[suffix] {
+			if layer.MediaType == mediaType {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("tags ending in %s are reserved to cosign artifacts, which have no layer of media type %s", suffix, layer.MediaType)
+		}
+	}
+	return nil
+}
+
+// verifyCosignArtifact verifies the layers of the media type of the artifact
+// under the tag with fn, succeeding if any layer is verified.
+func (imh *manifestHandler) verifyCosignArtifact(tag, mediaType string, dgst digest.Digest, fn func([]byte, map[string]string, digest.Digest) error) error {
+	desc, err := imh.Repository.Tags(imh).Get(imh, tag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return errNoCosignArtifact
+		}
+		return err
+	}
+	manifests, err := imh.Repository.Manifests(imh)
+	if err != nil {
+		return err
+	}
+	manifest, err := manifests.Get(imh, desc.Digest)
+	if err != nil {
+		return err
+	}
+	m, ok := manifest.(*ocischema.DeserializedManifest)
+	if !ok {
+		return fmt.Errorf("%s is not an OCI image manifest", tag)
+	}
+
+	err = errNoCosignArtifact
+	for _, layer := range m.Layers {
+		if layer.MediaType != mediaType {
+			continue
+		}
+		if layer.Size > maxSignaturePayloadSize {
+			err = fmt.Errorf("%s of %d bytes exceeds the maximum size of %d bytes", layer.Digest, layer.Size, maxSignaturePayloadSize)
+			continue
+		}
+		p, getErr := imh.Repository.Blobs(imh).Get(imh, layer.Digest)
+		if getErr != nil {
+			return getErr
+		}
+		if err = fn(p, layer.Annotations, dgst); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// verifySignature verifies a cosign signature, whose payload must name the
+// digest of the manifest.
+func (p *signaturePolicy) verifySignature(payload []byte, annotations map[string]string, dgst digest.Digest) error {
+	var simpleSigning struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+		return fmt.Errorf("invalid signature payload: %v", err)
+	}
+	if simpleSigning.Critical.Image.DockerManifestDigest != dgst {
+		return fmt.Errorf("signature payload refers to %s", simpleSigning.Critical.Image.DockerManifestDigest)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(annotations[annotationCosignSignature])
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("invalid signature annotation")
+	}
+	return p.verify(payload, sig, annotations)
+}
+
+// verifyAttestation verifies the DSSE envelope of an in-toto attestation,
+// whose statement must have the manifest as subject.
+func (p *signaturePolicy) verifyAttestation(envelope []byte, annotations map[string]string, dgst digest.Digest) error {
+	var dsse struct {
+		PayloadType string `json:"payloadType"`
+		Payload     []byte `json:"payload"`
+		Signatures  []struct {
+			Sig []byte `json:"sig"`
+		} `json:"signatures"`
+	}
+	if err := json.Unmarshal(envelope, &dsse); err != nil {
+		return fmt.Errorf("invalid attestation envelope: %v", err)
+	}
+	if dsse.PayloadType != mediaTypeInTotoStatement {
+		return fmt.Errorf("unsupported attestation payload type %q", dsse.PayloadType)
+	}
+
+	var statement struct {
+		Subject []struct {
+			Digest map[string]string `json:"digest"`
+		} `json:"subject"`
+	}
+	if err := json.Unmarshal(dsse.Payload, &statement); err != nil {
+		return fmt.Errorf("invalid attestation statement: %v", err)
+	}
+	var subject bool
+	for _, s := range statement.Subject {
+		if s.Digest[dgst.Algorithm().String()] == dgst.Encoded() {
+			subject = true
+			break
+		}
+	}
+	if !subject {
+		return fmt.Errorf("attestation statement does not refer to %s", dgst)
+	}
+
+	// DSSE signs the pre-authentication encoding of the payload.
+	pae := []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(dsse.PayloadType), dsse.PayloadType, len(dsse.Payload), dsse.Payload))
+	err := errSignatureMismatch
+	for _, sig := range dsse.Signatures {
+		if err = p.verify(pae, sig.Sig, annotations); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// verify verifies the signature of the message with the key of the
+// certificate of a keyless signature, if the policy trusts a Fulcio root,
+// and with the trusted public keys otherwise.
+func (p *signaturePolicy) verify(message, sig []byte, annotations map[string]string) error {
+	if certificate := annotations[annotationCosignCertificate]; certificate != "" && p.roots != nil {
+		key, err := p.verifyCertificate(certificate, annotations[annotationCosignChain], annotations[annotationCosignBundle])
+		if err != nil {
+			return err
+		}
+		return verifyWithKey(key, message, sig)
+	}
+
+	for _, key := range p.keys {
+		if verifyWithKey(key, message, sig) == nil {
+			return nil
+		}
+	}
+	return errSignatureMismatch
+}
+
+// verifyCertificate verifies that the certificate of a keyless signature is
+// issued by a trusted Fulcio root to a trusted identity, returning its key.
+// Fulcio certificates expire minutes after they are issued, so the
+// certificate is verified at the time the signature was logged by Rekor, as
+// proven by the bundle of its log entry.
+func (p *signaturePolicy) verifyCertificate(certificate, chain, bundle string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		return nil, fmt.Errorf("invalid certificate annotation")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %v", err)
+	}
+
+	integratedTime, err := p.verifyRekorBundle(bundle, certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(chain))
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         p.roots,
+		Intermediates: intermediates,
+		CurrentTime:   integratedTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted certificate: %v", err)
+	}
+
+	identities := cert.EmailAddresses
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	for _, identity := range identities {
+		if p.identities.MatchString(identity) {
+			return cert.PublicKey, nil
+		}
+	}
+	return nil, fmt.Errorf("untrusted certificate identity %s", strings.Join(identities, ", "))
+}
+
+// verifyRekorBundle verifies the signed entry timestamp of the Rekor log
+// entry in the bundle with a trusted Rekor key, returning the time the entry
+// was integrated in the log. The entry must record the certificate, whose
+// key is used once, binding the time to the signature.
+func (p *signaturePolicy) verifyRekorBundle(bundle, certificate string) (time.Time, error) {
+	if bundle == "" {
+		return time.Time{}, fmt.Errorf("keyless signature has no Rekor bundle")
+	}
+	var rekorBundle struct {
+		SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+		Payload              struct {
+			Body           string `json:"body"`
+			IntegratedTime int64  `json:"integratedTime"`
+			LogIndex       int64  `json:"logIndex"`
+			LogID          string `json:"logID"`
+		} `json:"Payload"`
+	}
+	if err := json.Unmarshal([]byte(bundle), &rekorBundle); err != nil {
+		return time.Time{}, fmt.Errorf("invalid Rekor bundle: %v", err)
+	}
+
+	// The signed entry timestamp signs the canonical JSON of the payload,
+	// whose fields are marshaled in lexical order.
+	canonical, err := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{rekorBundle.Payload.Body, rekorBundle.Payload.IntegratedTime, rekorBundle.Payload.LogID, rekorBundle.Payload.LogIndex})
+	if err != nil {
+		return time.Time{}, err
+	}
+	verified := false
+	for _, key := range p.rekorKeys {
+		if verifyWithKey(key, canonical, rekorBundle.SignedEntryTimestamp) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return time.Time{}, fmt.Errorf("bundle is not signed by a trusted Rekor key")
+	}
+
+	body, err := base64.StdEncoding.DecodeString(rekorBundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid Rekor entry: %v", err)
+	}
+	var entry interface{}
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("invalid Rekor entry: %v", err)
+	}
+	if !containsString(entry, base64.StdEncoding.EncodeToString([]byte(certificate))) {
+		return time.Time{}, fmt.Errorf("log entry of the bundle does not record the certificate of the signature")
+	}
+	return time.Unix(rekorBundle.Payload.IntegratedTime, 0), nil
+}
+
+// containsString returns whether the decoded JSON value v contains the string
+// s, at any depth.
+func containsString(v interface{}, s string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == s
+	case []interface{}:
+		for _, e := range v {
+			if containsString(e, s) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, e := range v {
+			if containsString(e, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyWithKey verifies the signature of the message, hashed with SHA-256
+// unless the key is an Ed25519 key.
+func verifyWithKey(key crypto.PublicKey, message, sig []byte) error {
+	sum := sha256.Sum256(message)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, sum[:], sig) {
+			return errSignatureMismatch
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) != nil {
+			return errSignatureMismatch
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, sig) {
+			return errSignatureMismatch
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNewSignaturePolicy(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := writePublicKey(t, dir, "cosign.pub", generateSigningKey(t))
+	rekorFile := writePublicKey(t, dir, "rekor.pub", generateSigningKey(t))
+	rootFile, _, _ := generateFulcioRoot(t, dir)
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	if err := os.WriteFile(invalidFile, []byte("not a key"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, err := newSignaturePolicy(configuration.SignaturePolicy{}); err != nil || p != nil {
+		t.Fatalf("expected no policy, got %v, %v", p, err)
+	}
+
+	for _, tc := range []struct {
+		config configuration.SignaturePolicy
+		valid  bool
+	}{
+		{config: configuration.SignaturePolicy{PublicKeys: []string{keyFile}}, valid: true},
+		{config: configuration.SignaturePolicy{FulcioRoots: []string{rootFile}, Identities: []string{".*@example\\.com"}, RekorKeys: []string{rekorFile}}, valid: true},
+		{config: configuration.SignaturePolicy{Repositories: []string{"signed/*"}}},
+		{config: configuration.SignaturePolicy{RekorKeys: []string{rekorFile}}},
+		{config: configuration.SignaturePolicy{Repositories: []string{"["}, PublicKeys: []string{keyFile}}},
+		{config: configuration.SignaturePolicy{PublicKeys: []string{filepath.Join(dir, "missing.pub")}}},
+		{config: configuration.SignaturePolicy{PublicKeys: []string{invalidFile}}},
+		{config: configuration.SignaturePolicy{FulcioRoots: []string{invalidFile}, Identities: []string{".*"}, RekorKeys: []string{rekorFile}}},
+		{config: configuration.SignaturePolicy{FulcioRoots: []string{rootFile}, RekorKeys: []string{rekorFile}}},
+		{config: configuration.SignaturePolicy{FulcioRoots: []string{rootFile}, Identities: []string{".*"}}},
+		{config: configuration.SignaturePolicy{FulcioRoots: []string{rootFile}, Identities: []string{".*"}, RekorKeys: []string{invalidFile}}},
+		{config: configuration.SignaturePolicy{FulcioRoots: []string{rootFile}, Identities: []string{"("}, RekorKeys: []string{rekorFile}}},
+	} {
+		if _, err := newSignaturePolicy(tc.config); (err == nil) != tc.valid {
+			t.Errorf("unexpected result for %+v: %v", tc.config, err)
+		}
+	}
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	dir := t.TempDir()
+	trustedKey := generateSigningKey(t)
+	untrustedKey := generateSigningKey(t)
+	rekorKey := generateSigningKey(t)
+	rootFile, root, rootKey := generateFulcioRoot(t, dir)
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Policy.Signatures = configuration.SignaturePolicy{
+		Repositories: []string{"signed/*"},
+		PublicKeys:   []string{writePublicKey(t, dir, "cosign.pub", trustedKey)},
+		FulcioRoots:  []string{rootFile},
+		Identities:   []string{"ci@example\\.com"},
+		RekorKeys:    []string{writePublicKey(t, dir, "rekor.pub", rekorKey)},
+	}
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	versioned := manifest.Versioned{
+		SchemaVersion: 2,
+		MediaType:     v1.MediaTypeImageManifest,
+	}
+	pushImage := func(name reference.Named, image string) (ocischema.Manifest, digest.Digest) {
+		m := ocischema.Manifest{
+			Versioned: versioned,
+			Config:    pushBlob(t, env, name, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux", "image": "`+image+`"}`)),
+			Layers:    []distribution.Descriptor{},
+		}
+		return m, pushOCIManifest(t, env, name, m, "")
+	}
+	pushCosignArtifact := func(name reference.Named, dgst digest.Digest, suffix, mediaType string, p []byte, annotations map[string]string) {
+		layer := pushBlob(t, env, name, mediaType, p)
+		layer.Annotations = annotations
+		pushOCIManifest(t, env, name, ocischema.Manifest{
+			Versioned: versioned,
+			Config:    pushBlob(t, env, name, v1.MediaTypeImageConfig, []byte("{}")),
+			Layers:    []distribution.Descriptor{layer},
+		}, fmt.Sprintf("sha256-%s%s", dgst.Encoded(), suffix))
+	}
+	sign := func(name reference.Named, dgst digest.Digest, key *ecdsa.PrivateKey) {
+		payload := []byte(fmt.Sprintf(`{"critical": {"identity": {"docker-reference": "%s"}, "image": {"docker-manifest-digest": "%s"}, "type": "cosign container image signature"}, "optional": null}`, name, dgst))
+		pushCosignArtifact(name, dgst, ".sig", mediaTypeCosignSimpleSigning, payload, map[string]string{
+			annotationCosignSignature: base64.StdEncoding.EncodeToString(signMessage(t, key, payload)),
+		})
+	}
+	// attest pushes a keyless attestation, logged by Rekor at the time if
+	// set, with a certificate valid from 30 to 20 minutes ago.
+	attest := func(name reference.Named, dgst digest.Digest, email string, logged time.Time) {
+		key := generateSigningKey(t)
+		statement, _ := json.Marshal(map[string]interface{}{
+			"_type":         "https://in-toto.io/Statement/v0.1",
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"subject":       []interface{}{map[string]interface{}{"name": name.Name(), "digest": map[string]string{"sha256": dgst.Encoded()}}},
+		})
+		pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(mediaTypeInTotoStatement), mediaTypeInTotoStatement, len(statement), statement)
+		envelope, _ := json.Marshal(map[string]interface{}{
+			"payloadType": mediaTypeInTotoStatement,
+			"payload":     statement,
+			"signatures":  []interface{}{map[string][]byte{"sig": signMessage(t, key, []byte(pae))}},
+		})
+		certificate := issueFulcioCertificate(t, root, rootKey, &key.PublicKey, email)
+		annotations := map[string]string{annotationCosignCertificate: string(certificate)}
+		if !logged.IsZero() {
+			annotations[annotationCosignBundle] = string(rekorBundle(t, rekorKey, certificate, logged))
+		}
+		pushCosignArtifact(name, dgst, ".att", mediaTypeDSSEEnvelope, envelope, annotations)
+	}
+	issued := time.Now().Add(-25 * time.Minute)
+
+	signed, _ := reference.WithName("signed/app")
+	other, _ := reference.WithName("other/app")
+	for _, tc := range []struct {
+		name    reference.Named
+		image   string
+		prepare func(reference.Named, digest.Digest)
+		status  int
+	}{
+		{signed, "unsigned", func(reference.Named, digest.Digest) {}, http.StatusForbidden},
+		{signed, "signed", func(name reference.Named, dgst digest.Digest) { sign(name, dgst, trustedKey) }, http.StatusCreated},
+		{signed, "untrusted", func(name reference.Named, dgst digest.Digest) { sign(name, dgst, untrustedKey) }, http.StatusForbidden},
+		{signed, "attested", func(name reference.Named, dgst digest.Digest) { attest(name, dgst, "ci@example.com", issued) }, http.StatusCreated},
+		{signed, "unknown-identity", func(name reference.Named, dgst digest.Digest) { attest(name, dgst, "dev@example.com", issued) }, http.StatusForbidden},
+		{signed, "unlogged", func(name reference.Named, dgst digest.Digest) { attest(name, dgst, "ci@example.com", time.Time{}) }, http.StatusForbidden},
+		{signed, "logged-after-expiry", func(name reference.Named, dgst digest.Digest) { attest(name, dgst, "ci@example.com", time.Now()) }, http.StatusForbidden},
+		{other, "unprotected", func(reference.Named, digest.Digest) {}, http.StatusCreated},
+	} {
+		m, dgst := pushImage(tc.name, tc.image)
+		tc.prepare(tc.name, dgst)
+
+		deserialized, err := ocischema.FromStruct(m)
+		if err != nil {
+			t.Fatalf("error creating manifest: %v", err)
+		}
+		ref, _ := reference.WithTag(tc.name, tc.image)
+		manifestURL, err := env.builder.BuildManifestURL(ref)
+		if err != nil {
+			t.Fatalf("unexpected error building manifest url: %v", err)
+		}
+		resp := putManifest(t, "tagging "+tc.image, manifestURL, v1.MediaTypeImageManifest, deserialized)
+		checkResponse(t, "tagging "+tc.image, resp, tc.status)
+		if tc.status == http.StatusForbidden {
+			checkBodyHasErrorCodes(t, "tagging "+tc.image, resp, v2.ErrorCodeManifestUnsigned)
+		}
+		resp.Body.Close()
+	}
+
+	// only cosign artifacts can be pushed under the tags of cosign artifacts
+	image, dgst := pushImage(signed, "reserved")
+	signature, _ := pushImage(signed, "signature")
+	signature.Layers = []distribution.Descriptor{pushBlob(t, env, signed, mediaTypeCosignSimpleSigning, []byte("{}"))}
+	for _, tc := range []struct {
+		tag    string
+		m      ocischema.Manifest
+		status int
+	}{
+		{fmt.Sprintf("sha256-%s.latest", dgst.Encoded()), image, http.StatusForbidden},
+		{fmt.Sprintf("sha256-%s.sig", dgst.Encoded()), image, http.StatusForbidden},
+		{fmt.Sprintf("sha256-%s.att", dgst.Encoded()), signature, http.StatusForbidden},
+		{fmt.Sprintf("sha256-%s.sig", dgst.Encoded()), signature, http.StatusCreated},
+	} {
+		deserialized, err := ocischema.FromStruct(tc.m)
+		if err != nil {
+			t.Fatalf("error creating manifest: %v", err)
+		}
+		ref, _ := reference.WithTag(signed, tc.tag)
+		manifestURL, err := env.builder.BuildManifestURL(ref)
+		if err != nil {
+			t.Fatalf("unexpected error building manifest url: %v", err)
+		}
+		resp := putManifest(t, "tagging "+tc.tag, manifestURL, v1.MediaTypeImageManifest, deserialized)
+		checkResponse(t, "tagging "+tc.tag, resp, tc.status)
+		if tc.status == http.StatusForbidden {
+			checkBodyHasErrorCodes(t, "tagging "+tc.tag, resp, v2.ErrorCodeManifestUnsigned)
+		}
+		resp.Body.Close()
+	}
+}
+
+func generateSigningKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %v", err)
+	}
+	return key
+}
+
+func writePublicKey(t *testing.T, dir, name string, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling public key: %v", err)
+	}
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func signMessage(t *testing.T, key *ecdsa.PrivateKey, message []byte) []byte {
+	sum := sha256.Sum256(message)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatalf("unexpected error signing: %v", err)
+	}
+	return sig
+}
+
+// generateFulcioRoot writes the certificate of a new root certificate
+// authority to dir, returning its path, the certificate and its key.
+func generateFulcioRoot(t *testing.T, dir string) (string, *x509.Certificate, crypto.Signer) {
+	key := generateSigningKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sigstore"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error creating root certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	filename := filepath.Join(dir, "fulcio.pem")
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename, cert, key
+}
+
+// issueFulcioCertificate issues a short-lived code signing certificate of
+// the key to the email address, as Fulcio does, returning it PEM encoded.
+func issueFulcioCertificate(t *testing.T, root *x509.Certificate, rootKey crypto.Signer, key crypto.PublicKey, email string) []byte {
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		NotBefore:      time.Now().Add(-30 * time.Minute),
+		NotAfter:       time.Now().Add(-20 * time.Minute),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses: []string{email},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, key, rootKey)
+	if err != nil {
+		t.Fatalf("unexpected error issuing certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// rekorBundle returns the bundle of a Rekor log entry recording the
+// certificate, integrated in the log at the time.
+func rekorBundle(t *testing.T, rekorKey *ecdsa.PrivateKey, certificate []byte, integrated time.Time) []byte {
+	entry, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.2",
+		"kind":       "intoto",
+		"spec": map[string]interface{}{
+			"content": map[string]interface{}{
+				"envelope": map[string]interface{}{
+					"payloadType": mediaTypeInTotoStatement,
+					"signatures":  []interface{}{map[string]string{"publicKey": base64.StdEncoding.EncodeToString(certificate)}},
+				},
+			},
+		},
+	})
+	payload := map[string]interface{}{
+		"body":           base64.StdEncoding.EncodeToString(entry),
+		"integratedTime": integrated.Unix(),
+		"logID":          "c0d23d6ad406973f9559f3ba2d1ca01f84147d8ffc5b8445c224f98b9591801d",
+		"logIndex":       1,
+	}
+	canonical, _ := json.Marshal(payload)
+	bundle, _ := json.Marshal(map[string]interface{}{
+		"SignedEntryTimestamp": signMessage(t, rekorKey, canonical),
+		"Payload":              payload,
+	})
+	return bundle
+}
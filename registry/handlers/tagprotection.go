@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/docker/distribution/configuration"
+)
+
+// tagProtectionRule protects the tags matching one of its patterns in the
+// repositories matching one of its repository patterns, or in every
+// repository if it has none.
+type tagProtectionRule struct {
+	repositories []string
+	tags         []string
+}
+
+// tagProtection makes the tags matching its rules immutable: once pushed,
+// they cannot be moved to another manifest or deleted.
+type tagProtection struct {
+	rules []tagProtectionRule
+}
+
+// newTagProtection validates the rules, returning nil if none are
+// configured.
+func newTagProtection(config configuration.TagProtection) (*tagProtection, error) {
+	if len(config.Rules) == 0 {
+		return nil, nil
+	}
+
+	tp := &tagProtection{}
+	for i, rule := range config.Rules {
+		if len(rule.Tags) == 0 {
+			return nil, fmt.Errorf("tagprotection.rules[%d]: at least one tag pattern is required", i)
+		}
+		for _, patterns := range [][]string{rule.Repositories, rule.Tags} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("tagprotection.rules[%d]: invalid pattern %q: %v", i, pattern, err)
+				}
+			}
+		}
+		tp.rules = append(tp.rules, tagProtectionRule{
+			repositories: rule.Repositories,
+			tags:         rule.Tags,
+		})
+	}
+	return tp, nil
+}
+
+// protected reports whether the tag of the named repository is protected. A
+// nil tagProtection protects no tag.
+func (tp *tagProtection) protected(name, tag string) bool {
+	if tp == nil {
+		return false
+	}
+	for _, rule := range tp.rules {
+		if len(rule.repositories) > 0 && !matchesGlob(rule.repositories, name) {
+			continue
+		}
+		if matchesGlob(rule.tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesGlob reports whether s matches any of the patterns.
+func matchesGlob(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestNewTagProtection(t *testing.T) {
+	if tp, err := newTagProtection(configuration.TagProtection{}); err != nil || tp != nil {
+		t.Fatalf("expected no tag protection, got %v, %v", tp, err)
+	}
+
+	for _, rule := range []configuration.TagProtectionRule{
+		{},
+		{Tags: []string{"["}},
+		{Repositories: []string{"["}, Tags: []string{"v*"}},
+	} {
+		config := configuration.TagProtection{Rules: []configuration.TagProtectionRule{rule}}
+		if _, err := newTagProtection(config); err == nil {
+			t.Errorf("expected error for %+v", rule)
+		}
+	}
+
+	tp, err := newTagProtection(configuration.TagProtection{
+		Rules: []configuration.TagProtectionRule{
+			{Tags: []string{"v*", "release-*"}},
+			{Repositories: []string{"library/*"}, Tags: []string{"stable"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tc := range []struct {
+		name, tag string
+		expected  bool
+	}{
+		{"foo/bar", "v1.0", true},
+		{"foo/bar", "release-2024", true},
+		{"foo/bar", "latest", false},
+		{"foo/bar", "stable", false},
+		{"library/ubuntu", "stable", true},
+	} {
+		if protected := tp.protected(tc.name, tc.tag); protected != tc.expected {
+			t.Errorf("protected(%q, %q) = %v, expected %v", tc.name, tc.tag, protected, tc.expected)
+		}
+	}
+
+	var nilProtection *tagProtection
+	if nilProtection.protected("foo/bar", "v1.0") {
+		t.Error("expected a nil tag protection to protect no tag")
+	}
+}
+
+func TestTagProtection(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+		},
+		TagProtection: configuration.TagProtection{
+			Rules: []configuration.TagProtectionRule{{Tags: []string{"v*"}}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/protected")
+	manifests := make([]*ocischema.DeserializedManifest, 2)
+	for i := range manifests {
+		imageConfig := []byte(fmt.Sprintf(`{"architecture": "amd64", "os": "linux", "rootfs": {"type": "layers", "diff_ids": []}, "created": "2024-01-0%dT00:00:00Z"}`, i+1))
+		dgst := digest.FromBytes(imageConfig)
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(imageConfig))
+
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: manifest.Versioned{
+				SchemaVersion: 2,
+				MediaType:     v1.MediaTypeImageManifest,
+			},
+			Config: distribution.Descriptor{
+				Digest:    dgst,
+				Size:      int64(len(imageConfig)),
+				MediaType: v1.MediaTypeImageConfig,
+			},
+		})
+		if err != nil {
+			t.Fatalf("error creating manifest: %v", err)
+		}
+		manifests[i] = m
+	}
+
+	manifestURL := func(tag string) string {
+		ref, _ := reference.WithTag(name, tag)
+		u, err := env.builder.BuildManifestURL(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	put := func(msg, tag string, m *ocischema.DeserializedManifest, status int) *http.Response {
+		t.Helper()
+		resp := putManifest(t, msg, manifestURL(tag), v1.MediaTypeImageManifest, m)
+		t.Cleanup(func() { resp.Body.Close() })
+		checkResponse(t, msg, resp, status)
+		return resp
+	}
+	del := func(msg, u string) *http.Response {
+		t.Helper()
+		resp, err := httpDelete(u)
+		if err != nil {
+			t.Fatalf("unexpected error %s: %v", msg, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	put("pushing protected tag", "v1", manifests[0], http.StatusCreated)
+	put("pushing protected tag again", "v1", manifests[0], http.StatusCreated)
+	resp := put("moving protected tag", "v1", manifests[1], http.StatusConflict)
+	checkBodyHasErrorCodes(t, "moving protected tag", resp, v2.ErrorCodeTagImmutable)
+
+	put("pushing unprotected tag", "latest", manifests[0], http.StatusCreated)
+	put("moving unprotected tag", "latest", manifests[1], http.StatusCreated)
+
+	resp = del("deleting protected tag", manifestURL("v1"))
+	checkResponse(t, "deleting protected tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting protected tag", resp, v2.ErrorCodeTagImmutable)
+
+	_, payload, _ := manifests[0].Payload()
+	ref, _ := reference.WithDigest(name, digest.FromBytes(payload))
+	digestURL, err := env.builder.BuildManifestURL(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = del("deleting manifest of protected tag", digestURL)
+	checkResponse(t, "deleting manifest of protected tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting manifest of protected tag", resp, v2.ErrorCodeTagImmutable)
+
+	checkResponse(t, "deleting unprotected tag", del("deleting unprotected tag", manifestURL("latest")), http.StatusAccepted)
+}
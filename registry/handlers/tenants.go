@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// tenant is a namespace prefix whose repositories are kept in a storage of
+// their own.
+type tenant struct {
+	prefix   string
+	driver   storagedriver.StorageDriver
+	registry distribution.Namespace
+}
+
+// tenantNamespace routes repositories to the storage of the tenant whose
+// prefix their name starts with, and to the default namespace otherwise.
+// Blobs are enumerated and statted in the default namespace only.
+type tenantNamespace struct {
+	distribution.Namespace
+
+	// tenants is sorted by decreasing length of prefix, so that the most
+	// specific prefix is matched first.
+	tenants []tenant
+}
+
+var _ distribution.RepositoryRemover = &tenantNamespace{}
+
+// newTenantNamespace creates the storage of each tenant, with the same
+// options, storage middleware and upload cleanup as the default storage.
+func (app *App) newTenantNamespace(defaultNamespace distribution.Namespace, tenants []configuration.Tenant, purgeConfig map[interface{}]interface{}, options []storage.RegistryOption) (*tenantNamespace, error) {
+	tn := &tenantNamespace{Namespace: defaultNamespace}
+
+	for _, t := range tenants {
+		if t.Prefix == "" {
+			return nil, fmt.Errorf("tenants: a prefix is required")
+		}
+		if t.Storage.Type() == "" {
+			return nil, fmt.Errorf("tenants: no storage configured for prefix %q", t.Prefix)
+		}
+
+		driver, err := createStorageDriver(t.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("tenants: creating storage for prefix %q: %v", t.Prefix, err)
+		}
+		app.startUploadCleanup(driver, purgeConfig)
+
+		driver, err = applyStorageMiddleware(driver, app.Config.Middleware["storage"])
+		if err != nil {
+			return nil, err
+		}
+
+		registry, err := storage.NewRegistry(app, driver, options...)
+		if err != nil {
+			return nil, fmt.Errorf("tenants: creating registry for prefix %q: %v", t.Prefix, err)
+		}
+
+		tn.tenants = append(tn.tenants, tenant{
+			prefix:   t.Prefix,
+			driver:   driver,
+			registry: registry,
+		})
+		dcontext.GetLogger(app).Infof("using %s storage for repositories prefixed with %q", t.Storage.Type(), t.Prefix)
+	}
+
+	sort.SliceStable(tn.tenants, func(i, j int) bool {
+		return len(tn.tenants[i].prefix) > len(tn.tenants[j].prefix)
+	})
+
+	return tn, nil
+}
+
+// tenant returns the tenant the named repository is stored by, or nil if it
+// is stored in the default namespace.
+func (tn *tenantNamespace) tenant(name string) *tenant {
+	for i, t := range tn.tenants {
+		if strings.HasPrefix(name, t.prefix) {
+			return &tn.tenants[i]
+		}
+	}
+	return nil
+}
+
+// namespace returns the namespace the named repository is stored in.
+func (tn *tenantNamespace) namespace(name string) distribution.Namespace {
+	if t := tn.tenant(name); t != nil {
+		return t.registry
+	}
+	return tn.Namespace
+}
+
+// driverFor returns the storage driver the named repository is stored in.
+func (app *App) driverFor(name string) storagedriver.StorageDriver {
+	if app.tenants != nil {
+		if t := app.tenants.tenant(name); t != nil {
+			return t.driver
+		}
+	}
+	return app.driver
+}
+
+func (tn *tenantNamespace) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	return tn.namespace(name.Name()).Repository(ctx, name)
+}
+
+// Repositories merges the catalogs of the default namespace and of every
+// tenant.
+func (tn *tenantNamespace) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	namespaces := []distribution.Namespace{tn.Namespace}
+	for _, t := range tn.tenants {
+		namespaces = append(namespaces, t.registry)
+	}
+
+	var merged []string
+	complete := true
+	for _, ns := range namespaces {
+		page := make([]string, len(repos))
+		n, err := ns.Repositories(ctx, page, last)
+		switch err {
+		case nil:
+			// The namespace may hold more repositories than fit in the page.
+			complete = false
+		case io.EOF:
+		default:
+			return 0, err
+		}
+		merged = append(merged, page[:n]...)
+	}
+
+	sort.Strings(merged)
+	// Drop repositories found in several namespaces, such as those stored
+	// before their prefix was assigned to a tenant.
+	unique := merged[:0]
+	for i, name := range merged {
+		if i == 0 || name != merged[i-1] {
+			unique = append(unique, name)
+		}
+	}
+
+	n := copy(repos, unique)
+	if complete && len(unique) <= len(repos) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Remove removes the named repository from the namespace it is stored in.
+func (tn *tenantNamespace) Remove(ctx context.Context, name reference.Named) error {
+	remover, ok := tn.namespace(name.Name()).(distribution.RepositoryRemover)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	return remover.Remove(ctx, name)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+)
+
+func TestTenantNamespace(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Tenants: []configuration.Tenant{
+			{Prefix: "team-a/", Storage: configuration.Storage{"inmemory": configuration.Parameters{}}},
+			{Prefix: "team-a/special/", Storage: configuration.Storage{"inmemory": configuration.Parameters{}}},
+		},
+	}
+	app := NewApp(context.Background(), config)
+	ctx := context.Background()
+
+	tn, ok := app.registry.(*tenantNamespace)
+	if !ok {
+		t.Fatalf("unexpected namespace type %T", app.registry)
+	}
+
+	for _, name := range []string{"team-b/app", "team-a/app", "team-a/special/app", "team-a/web"} {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := app.registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repository %s: %v", name, err)
+		}
+		desc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte(name))
+		if err != nil {
+			t.Fatalf("unexpected error putting blob in %s: %v", name, err)
+		}
+		// repositories are listed in the catalog once they have a tag
+		if err := repo.Tags(ctx).Tag(ctx, "latest", desc); err != nil {
+			t.Fatalf("unexpected error tagging %s: %v", name, err)
+		}
+	}
+
+	catalog := func(ns distribution.Namespace, n int) []string {
+		t.Helper()
+
+		var names []string
+		last := ""
+		for {
+			repos := make([]string, n)
+			filled, err := ns.Repositories(ctx, repos, last)
+			if err != nil && err != io.EOF {
+				t.Fatalf("unexpected error listing repositories: %v", err)
+			}
+			names = append(names, repos[:filled]...)
+			if err == io.EOF || filled == 0 {
+				return names
+			}
+			last = repos[filled-1]
+		}
+	}
+
+	// repositories are stored in the storage of the most specific prefix
+	for _, tc := range []struct {
+		ns       distribution.Namespace
+		expected []string
+	}{
+		{tn.Namespace, []string{"team-b/app"}},
+		{tn.namespace("team-a/app"), []string{"team-a/app", "team-a/web"}},
+		{tn.namespace("team-a/special/app"), []string{"team-a/special/app"}},
+	} {
+		if names := catalog(tc.ns, 10); !reflect.DeepEqual(names, tc.expected) {
+			t.Fatalf("unexpected repositories: %v != %v", names, tc.expected)
+		}
+	}
+
+	expected := []string{"team-a/app", "team-a/special/app", "team-a/web", "team-b/app"}
+	for _, n := range []int{1, 2, 10} {
+		if names := catalog(app.registry, n); !reflect.DeepEqual(names, expected) {
+			t.Fatalf("unexpected catalog with pages of %d: %v != %v", n, names, expected)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// annotateSpan names the span of the request, started by the OpenTelemetry
+// HTTP handler, after the method and route of the request, and records the
+// repository, reference and user the request operates as, so that traces can
+// be searched by them.
+func (app *App) annotateSpan(ctx *Context, r *http.Request) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+		span.SetName(r.Method + " " + route.GetName())
+	}
+
+	var attributes []attribute.KeyValue
+	if app.nameRequired(r) {
+		attributes = append(attributes, attribute.String("registry.repository", getName(ctx)))
+	}
+	if reference := getReference(ctx); reference != "" {
+		attributes = append(attributes, attribute.String("registry.reference", reference))
+	}
+	if dgst := dcontext.GetStringValue(ctx, "vars.digest"); dgst != "" {
+		attributes = append(attributes, attribute.String("registry.digest", dgst))
+	}
+	if uuid := getUploadUUID(ctx); uuid != "" {
+		attributes = append(attributes, attribute.String("registry.upload.uuid", uuid))
+	}
+	if user := dcontext.GetStringValue(ctx, auth.UserNameKey); user != "" {
+		attributes = append(attributes, attribute.String("enduser.id", user))
+	}
+	span.SetAttributes(attributes...)
+}
+
+// recordSpanErrors marks the span of the request as failed with the errors
+// served to the client, along with their codes.
+func recordSpanErrors(ctx *Context) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() || ctx.Errors.Len() == 0 {
+		return
+	}
+
+	errorCodes := make([]string, 0, len(ctx.Errors))
+	for _, err := range ctx.Errors {
+		if coder, ok := err.(errcode.ErrorCoder); ok {
+			errorCodes = append(errorCodes, coder.ErrorCode().String())
+		} else {
+			errorCodes = append(errorCodes, errcode.ErrorCodeUnknown.String())
+		}
+	}
+	span.SetAttributes(attribute.StringSlice("registry.error.codes", errorCodes))
+	span.SetStatus(codes.Error, ctx.Errors.Error())
+}
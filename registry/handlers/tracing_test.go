@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestRequestSpans ensures that the span of a request is named after its
+// route and records the repository, reference and errors of the request.
+func TestRequestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	app := NewApp(context.Background(), config)
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	req := httptest.NewRequest(http.MethodGet, "/v2/foo/bar/manifests/latest", nil).WithContext(ctx)
+	resp := httptest.NewRecorder()
+	app.ServeHTTP(resp, req)
+	span.End()
+
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status code: %d", resp.Code)
+	}
+
+	var recorded sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.SpanContext().SpanID() == span.SpanContext().SpanID() {
+			recorded = s
+		}
+	}
+	if recorded == nil {
+		t.Fatal("request span was not recorded")
+	}
+
+	if recorded.Name() != "GET "+v2.RouteNameManifest {
+		t.Fatalf("unexpected span name: %q", recorded.Name())
+	}
+	attributes := make(map[attribute.Key]string)
+	for _, kv := range recorded.Attributes() {
+		attributes[kv.Key] = kv.Value.Emit()
+	}
+	for key, expected := range map[attribute.Key]string{
+		"registry.repository":  "foo/bar",
+		"registry.reference":   "latest",
+		"registry.error.codes": "[MANIFEST_UNKNOWN]",
+	} {
+		if attributes[key] != expected {
+			t.Errorf("unexpected %s attribute: %q != %q", key, attributes[key], expected)
+		}
+	}
+	if recorded.Status().Code != codes.Error {
+		t.Fatalf("unexpected span status: %v", recorded.Status().Code)
+	}
+}
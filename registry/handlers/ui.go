@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/auth"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// uiCatalogPageSize is the number of repositories listed per page of the web
+// UI.
+const uiCatalogPageSize = 100
+
+//go:embed ui/*.html
+var uiFiles embed.FS
+
+// uiPages are the templates of the pages of the web UI, each parsed together
+// with the layout shared by all pages.
+var uiPages = func() map[string]*template.Template {
+	funcs := template.FuncMap{"size": formatSize}
+	pages := make(map[string]*template.Template)
+	for _, page := range []string{"catalog", "repository", "manifest"} {
+		pages[page] = template.Must(template.New("layout.html").Funcs(funcs).ParseFS(uiFiles, "ui/layout.html", "ui/"+page+".html"))
+	}
+	return pages
+}()
+
+// uiHandler serves the read-only web UI under prefix.
+type uiHandler struct {
+	*App
+	prefix string
+}
+
+// uiPage is the data every page of the web UI is rendered with.
+type uiPage struct {
+	Prefix string
+	Title  string
+	Data   interface{}
+}
+
+// uiCatalog lists a page of the repositories of the registry.
+type uiCatalog struct {
+	Repositories []string
+	// Next is the last repository of the page if more repositories follow.
+	Next string
+}
+
+// uiRepository lists the tags of a repository and its storage usage.
+type uiRepository struct {
+	Name string
+	Tags []uiTag
+	// Size is the size of the distinct blobs referenced by the tags.
+	Size int64
+}
+
+type uiTag struct {
+	Tag       string
+	Digest    digest.Digest
+	MediaType string
+	Size      int64
+}
+
+// uiManifest details a manifest of a repository.
+type uiManifest struct {
+	Name         string
+	Digest       digest.Digest
+	MediaType    string
+	ArtifactType string
+	// Size is the size of the manifest and the blobs it references.
+	Size        int64
+	References  []uiReference
+	Annotations map[string]string
+	Subject     *distribution.Descriptor
+	Referrers   []referrer
+	Payload     string
+}
+
+type uiReference struct {
+	distribution.Descriptor
+	// Manifest is set for references to other manifests, such as the
+	// entries of an image index.
+	Manifest bool
+}
+
+// referrer is a manifest whose subject is another manifest.
+type referrer struct {
+	Digest       digest.Digest
+	ArtifactType string
+}
+
+// UIHandler returns the handler serving the read-only web UI under prefix.
+// Every page is authorized with the access controller of the registry:
+// listing repositories requires catalog access and browsing a repository
+// requires pull access to it.
+func (app *App) UIHandler(prefix string) http.Handler {
+	return &uiHandler{App: app, prefix: prefix}
+}
+
+func (uh *uiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := dcontext.WithRequest(r.Context(), r)
+	ctx = dcontext.WithLogger(ctx, dcontext.GetRequestLogger(ctx))
+	r = r.WithContext(ctx)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, uh.prefix) {
+	case "":
+		uh.serveCatalog(w, r)
+	case "repository":
+		uh.serveRepository(w, r)
+	case "manifest":
+		uh.serveManifest(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize checks the access of the request, challenging the client if it
+// is not authorized.
+func (uh *uiHandler) authorize(w http.ResponseWriter, r *http.Request, access ...auth.Access) (context.Context, bool) {
+	ctx := r.Context()
+	if uh.accessController == nil {
+		return ctx, true
+	}
+
+	ctx, err := uh.accessController.Authorized(ctx, access...)
+	if err != nil {
+		switch err := err.(type) {
+		case auth.Challenge:
+			err.SetHeaders(r, w)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		default:
+			dcontext.GetLogger(r.Context()).Errorf("error checking authorization: %v", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		}
+		return nil, false
+	}
+	return ctx, true
+}
+
+// repository authorizes pull access to the repository named in the request
+// and returns it.
+func (uh *uiHandler) repository(w http.ResponseWriter, r *http.Request) (context.Context, distribution.Repository, bool) {
+	named, err := reference.WithName(r.FormValue("name"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid repository name: %v", err), http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	ctx, ok := uh.authorize(w, r, appendAccessRecords(nil, http.MethodGet, named.Name())...)
+	if !ok {
+		return nil, nil, false
+	}
+
+	repo, err := uh.registry.Repository(ctx, named)
+	if err != nil {
+		uh.fail(ctx, w, err)
+		return nil, nil, false
+	}
+	return ctx, repo, true
+}
+
+func (uh *uiHandler) serveCatalog(w http.ResponseWriter, r *http.Request) {
+	ctx, ok := uh.authorize(w, r, auth.Access{
+		Resource: auth.Resource{Type: "registry", Name: "catalog"},
+		Action:   "*",
+	})
+	if !ok {
+		return
+	}
+
+	repos := make([]string, uiCatalogPageSize)
+	n, err := uh.registry.Repositories(ctx, repos, r.FormValue("last"))
+	more := err == nil
+	if err != nil {
+		var pathNotFound driver.PathNotFoundError
+		if err != io.EOF && !errors.As(err, &pathNotFound) {
+			uh.fail(ctx, w, err)
+			return
+		}
+	}
+
+	catalog := uiCatalog{Repositories: repos[:n]}
+	if more && n > 0 {
+		catalog.Next = repos[n-1]
+	}
+	uh.render(ctx, w, "catalog", "Repositories", catalog)
+}
+
+func (uh *uiHandler) serveRepository(w http.ResponseWriter, r *http.Request) {
+	ctx, repo, ok := uh.repository(w, r)
+	if !ok {
+		return
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		uh.fail(ctx, w, err)
+		return
+	}
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			uh.fail(ctx, w, err)
+			return
+		}
+	}
+	sort.Strings(tags)
+
+	details := uiRepository{Name: repo.Named().Name()}
+	blobs := make(map[digest.Digest]int64)
+	for _, tag := range tags {
+		desc, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			uh.fail(ctx, w, err)
+			return
+		}
+
+		tagBlobs := make(map[digest.Digest]int64)
+		mediaType, err := blobUsage(ctx, manifests, desc.Digest, tagBlobs)
+		if err != nil {
+			uh.fail(ctx, w, err)
+			return
+		}
+		for dgst, size := range tagBlobs {
+			blobs[dgst] = size
+		}
+		details.Tags = append(details.Tags, uiTag{
+			Tag:       tag,
+			Digest:    desc.Digest,
+			MediaType: mediaType,
+			Size:      totalSize(tagBlobs),
+		})
+	}
+	details.Size = totalSize(blobs)
+
+	uh.render(ctx, w, "repository", details.Name, details)
+}
+
+func (uh *uiHandler) serveManifest(w http.ResponseWriter, r *http.Request) {
+	dgst, err := digest.Parse(r.FormValue("digest"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid digest: %v", err), http.StatusBadRequest)
+		return
+	}
+	ctx, repo, ok := uh.repository(w, r)
+	if !ok {
+		return
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		uh.fail(ctx, w, err)
+		return
+	}
+	m, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		uh.fail(ctx, w, err)
+		return
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		uh.fail(ctx, w, err)
+		return
+	}
+
+	blobs := make(map[digest.Digest]int64)
+	if _, err := blobUsage(ctx, manifests, dgst, blobs); err != nil {
+		uh.fail(ctx, w, err)
+		return
+	}
+
+	details := uiManifest{
+		Name:      repo.Named().Name(),
+		Digest:    dgst,
+		MediaType: mediaType,
+		Size:      totalSize(blobs),
+		Payload:   string(payload),
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, payload, "", "  "); err == nil {
+		details.Payload = indented.String()
+	}
+	for _, ref := range m.References() {
+		details.References = append(details.References, uiReference{
+			Descriptor: ref,
+			Manifest:   isManifestMediaType(ref.MediaType),
+		})
+	}
+	if oci, ok := m.(*ocischema.DeserializedManifest); ok {
+		details.ArtifactType = oci.ArtifactType
+		details.Annotations = oci.Annotations
+		details.Subject = oci.Subject
+	}
+
+	details.Referrers, err = referrers(ctx, manifests, dgst)
+	if err != nil {
+		uh.fail(ctx, w, err)
+		return
+	}
+
+	uh.render(ctx, w, "manifest", details.Name+"@"+dgst.String(), details)
+}
+
+func (uh *uiHandler) render(ctx context.Context, w http.ResponseWriter, page, title string, data interface{}) {
+	var buf bytes.Buffer
+	if err := uiPages[page].Execute(&buf, uiPage{Prefix: uh.prefix, Title: title, Data: data}); err != nil {
+		uh.fail(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	if _, err := buf.WriteTo(w); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error writing ui page: %v", err)
+	}
+}
+
+func (uh *uiHandler) fail(ctx context.Context, w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case distribution.ErrRepositoryUnknown, distribution.ErrManifestUnknownRevision:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	dcontext.GetLogger(ctx).Errorf("error serving ui page: %v", err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// blobUsage records the manifest with the given digest and the blobs it
+// references in blobs, descending into the manifests referenced by indexes.
+// It returns the media type of the manifest.
+func blobUsage(ctx context.Context, manifests distribution.ManifestService, dgst digest.Digest, blobs map[digest.Digest]int64) (string, error) {
+	m, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		return "", err
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		return "", err
+	}
+	blobs[dgst] = int64(len(payload))
+
+	for _, ref := range m.References() {
+		if _, ok := blobs[ref.Digest]; ok {
+			continue
+		}
+		if !isManifestMediaType(ref.MediaType) {
+			blobs[ref.Digest] = ref.Size
+			continue
+		}
+		if _, err := blobUsage(ctx, manifests, ref.Digest, blobs); err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				// entries of an index need not be pushed to the repository
+				continue
+			}
+			return "", err
+		}
+	}
+	return mediaType, nil
+}
+
+// referrers lists the manifests of the repository whose subject is the
+// manifest with the given digest. Without an index of referrers, every
+// manifest of the repository is read.
+func referrers(ctx context.Context, manifests distribution.ManifestService, subject digest.Digest) ([]referrer, error) {
+	enumerator, ok := manifests.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, nil
+	}
+
+	var results []referrer
+	err := enumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		m, err := manifests.Get(ctx, dgst)
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				return nil
+			}
+			return err
+		}
+		oci, ok := m.(*ocischema.DeserializedManifest)
+		if !ok || oci.Subject == nil || oci.Subject.Digest != subject {
+			return nil
+		}
+		artifactType := oci.ArtifactType
+		if artifactType == "" {
+			artifactType = oci.Config.MediaType
+		}
+		results = append(results, referrer{Digest: dgst, ArtifactType: artifactType})
+		return nil
+	})
+	if err != nil {
+		var pathNotFound driver.PathNotFoundError
+		if !errors.As(err, &pathNotFound) {
+			return nil, err
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Digest < results[j].Digest
+	})
+	return results, nil
+}
+
+// isManifestMediaType reports whether mediaType is the media type of a
+// manifest rather than a blob.
+func isManifestMediaType(mediaType string) bool {
+	for _, mt := range distribution.ManifestMediaTypes() {
+		if mt != "" && mt == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func totalSize(blobs map[digest.Digest]int64) int64 {
+	var total int64
+	for _, size := range blobs {
+		total += size
+	}
+	return total
+}
+
+// formatSize formats a size in bytes for display.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
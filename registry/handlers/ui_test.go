@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestUI(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	versioned := manifest.Versioned{
+		SchemaVersion: 2,
+		MediaType:     v1.MediaTypeImageManifest,
+	}
+	layer := pushBlob(t, env, imageName, v1.MediaTypeImageLayer, []byte("layer"))
+	imageManifest := ocischema.Manifest{
+		Versioned:   versioned,
+		Config:      pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux"}`)),
+		Layers:      []distribution.Descriptor{layer},
+		Annotations: map[string]string{"org.opencontainers.image.title": "<bar>"},
+	}
+	image := pushOCIManifest(t, env, imageName, imageManifest, "latest")
+	deserialized, err := ocischema.FromStruct(imageManifest)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	_, payload, _ := deserialized.Payload()
+	usage := formatSize(int64(len(payload)) + imageManifest.Config.Size + layer.Size)
+	signature := pushOCIManifest(t, env, imageName, ocischema.Manifest{
+		Versioned:    versioned,
+		ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+		Config:       pushBlob(t, env, imageName, "application/vnd.oci.empty.v1+json", []byte("{}")),
+		Layers:       []distribution.Descriptor{},
+		Subject:      &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: image},
+	}, "")
+
+	server := httptest.NewServer(env.app.UIHandler("/ui/"))
+	defer server.Close()
+
+	get := func(path string, query url.Values) (int, string) {
+		resp, err := http.Get(server.URL + path + "?" + query.Encode())
+		if err != nil {
+			t.Fatalf("unexpected error fetching %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", path, err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	for _, tc := range []struct {
+		path     string
+		query    url.Values
+		status   int
+		contains []string
+	}{
+		{
+			path:     "/ui/",
+			status:   http.StatusOK,
+			contains: []string{`href="/ui/repository?name=foo%2fbar"`},
+		},
+		{
+			path:     "/ui/repository",
+			query:    url.Values{"name": {"foo/bar"}},
+			status:   http.StatusOK,
+			contains: []string{"latest", image.String(), "Storage usage: " + usage},
+		},
+		{
+			path:   "/ui/manifest",
+			query:  url.Values{"name": {"foo/bar"}, "digest": {image.String()}},
+			status: http.StatusOK,
+			contains: []string{
+				layer.Digest.String(),
+				"&lt;bar&gt;",
+				signature.String(),
+				"application/vnd.dev.cosign.artifact.sig.v1&#43;json",
+			},
+		},
+		{
+			path:   "/ui/manifest",
+			query:  url.Values{"name": {"foo/bar"}, "digest": {signature.String()}},
+			status: http.StatusOK,
+			contains: []string{
+				`href="/ui/manifest?name=foo%2fbar&amp;digest=` + strings.Replace(image.String(), ":", "%3a", 1) + `"`,
+			},
+		},
+		{
+			path:   "/ui/manifest",
+			query:  url.Values{"name": {"foo/bar"}, "digest": {"sha256:" + strings.Repeat("0", 64)}},
+			status: http.StatusNotFound,
+		},
+		{
+			path:   "/ui/repository",
+			query:  url.Values{"name": {"Foo"}},
+			status: http.StatusBadRequest,
+		},
+		{
+			path:   "/ui/unknown",
+			status: http.StatusNotFound,
+		},
+	} {
+		status, body := get(tc.path, tc.query)
+		if status != tc.status {
+			t.Errorf("unexpected status for %s?%s: %d != %d", tc.path, tc.query.Encode(), status, tc.status)
+			continue
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(body, s) {
+				t.Errorf("expected %s?%s to contain %q:\n%s", tc.path, tc.query.Encode(), s, body)
+			}
+		}
+	}
+
+	resp, err := http.Post(server.URL+"/ui/", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("unexpected error posting: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status posting to the ui: %d", resp.StatusCode)
+	}
+}
+
+func TestUIAuthorization(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	app := NewApp(context.Background(), config)
+	server := httptest.NewServer(app.UIHandler("/ui/"))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		path      string
+		challenge string
+	}{
+		{path: "/ui/", challenge: `scope="registry:catalog:*"`},
+		{path: "/ui/repository?name=foo/bar", challenge: `scope="repository:foo/bar:pull"`},
+	} {
+		resp, err := http.Get(server.URL + tc.path)
+		if err != nil {
+			t.Fatalf("unexpected error fetching %s: %v", tc.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("unexpected status for %s: %d", tc.path, resp.StatusCode)
+		}
+		if challenge := resp.Header.Get("WWW-Authenticate"); !strings.Contains(challenge, tc.challenge) {
+			t.Errorf("unexpected challenge for %s: %q", tc.path, challenge)
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+tc.path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error fetching %s: %v", tc.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status for authorized %s: %d", tc.path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+const defaultUploadReapInterval = 10 * time.Minute
+
+// startUploadCleanup schedules the removal of stale upload sessions from the
+// storage, by the reaper if an upload TTL is configured and by the upload
+// purger otherwise.
+func (app *App) startUploadCleanup(storageDriver storagedriver.StorageDriver, purgeConfig map[interface{}]interface{}) {
+	if app.Config.Uploads.TTL <= 0 {
+		startUploadPurger(app, storageDriver, dcontext.GetLogger(app), purgeConfig)
+		return
+	}
+	app.startUploadReaper(storageDriver)
+}
+
+// startUploadReaper schedules a goroutine which periodically removes the
+// data of the upload sessions older than the upload TTL.
+func (app *App) startUploadReaper(storageDriver storagedriver.StorageDriver) {
+	ttl := app.Config.Uploads.TTL
+	interval := app.Config.Uploads.ReapInterval
+	if interval <= 0 {
+		interval = defaultUploadReapInterval
+	}
+
+	log := dcontext.GetLogger(app)
+	log.Infof("uploads: removing upload sessions older than %s every %s", ttl, interval)
+
+	app.goBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			deleted, errs := storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-ttl), true)
+			for _, err := range errs {
+				log.Errorf("uploads: error removing stale upload sessions: %v", err)
+			}
+			if len(deleted) > 0 {
+				log.Infof("uploads: removed %d stale upload sessions", len(deleted))
+			}
+		}
+	})
+}
+
+// uploadExpired returns whether the upload session is older than the upload
+// TTL.
+func (app *App) uploadExpired(upload distribution.BlobWriter) bool {
+	ttl := app.Config.Uploads.TTL
+	return ttl > 0 && time.Since(upload.StartedAt()) > ttl
+}
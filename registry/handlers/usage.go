@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	prometheus "github.com/docker/distribution/metrics"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/go-metrics"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+const defaultUsageReconcileInterval = 24 * time.Hour
+
+var (
+	// usageNamespaceGauge measures the bytes stored by each namespace.
+	usageNamespaceGauge = prometheus.UsageNamespace.NewLabeledGauge("namespace", "The number of bytes stored by the repositories of each namespace", metrics.Bytes, "namespace")
+	// usageTotalGauge measures the bytes stored by all repositories.
+	usageTotalGauge = prometheus.UsageNamespace.NewGauge("total", "The number of bytes stored by all repositories", metrics.Bytes)
+)
+
+func init() {
+	metrics.Register(prometheus.UsageNamespace)
+}
+
+// repositoryContent is the size of each layer and manifest linked in a
+// repository.
+type repositoryContent struct {
+	sizes map[digest.Digest]int64
+	total int64
+}
+
+// usageUpdate is a layer or manifest linked in or unlinked from a
+// repository.
+type usageUpdate struct {
+	repository string
+	dgst       digest.Digest
+	size       int64
+	unlinked   bool
+}
+
+// usageAccounting tracks the bytes stored by each repository. Shared blobs
+// are counted in each repository they are linked in.
+type usageAccounting struct {
+	mu           sync.Mutex
+	repositories map[string]*repositoryContent
+	namespaces   map[string]int64
+	total        int64
+	reconciledAt time.Time
+
+	// journal records the updates made while the accounting is reconciled,
+	// to replay them on the reconciled accounting.
+	reconciling bool
+	journal     []usageUpdate
+}
+
+func newUsageAccounting() *usageAccounting {
+	return &usageAccounting{
+		repositories: make(map[string]*repositoryContent),
+		namespaces:   make(map[string]int64),
+	}
+}
+
+// usageNamespace returns the namespace of the named repository, which is the
+// first component of its name.
+func usageNamespace(name string) string {
+	namespace, _, _ := strings.Cut(name, "/")
+	return namespace
+}
+
+// link records that the layer or manifest of size bytes is linked in the
+// repository. A nil accounting records nothing.
+func (ua *usageAccounting) link(repository string, dgst digest.Digest, size int64) {
+	ua.update(usageUpdate{repository: repository, dgst: dgst, size: size})
+}
+
+// unlink records that the layer or manifest is no longer linked in the
+// repository. A nil accounting records nothing.
+func (ua *usageAccounting) unlink(repository string, dgst digest.Digest) {
+	ua.update(usageUpdate{repository: repository, dgst: dgst, unlinked: true})
+}
+
+func (ua *usageAccounting) update(u usageUpdate) {
+	if ua == nil {
+		return
+	}
+
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	if ua.reconciling {
+		ua.journal = append(ua.journal, u)
+	}
+	if delta := applyUsageUpdate(ua.repositories, u); delta != 0 {
+		namespace := usageNamespace(u.repository)
+		ua.namespaces[namespace] += delta
+		ua.total += delta
+		usageNamespaceGauge.WithValues(namespace).Set(float64(ua.namespaces[namespace]))
+		usageTotalGauge.Set(float64(ua.total))
+	}
+}
+
+// applyUsageUpdate applies the update to the content of the repositories,
+// returning the change of the bytes stored.
+func applyUsageUpdate(repositories map[string]*repositoryContent, u usageUpdate) int64 {
+	content, ok := repositories[u.repository]
+	if !ok {
+		if u.unlinked {
+			return 0
+		}
+		content = &repositoryContent{sizes: make(map[digest.Digest]int64)}
+		repositories[u.repository] = content
+	}
+
+	previous := content.sizes[u.dgst]
+	if u.unlinked {
+		delete(content.sizes, u.dgst)
+		if len(content.sizes) == 0 {
+			delete(repositories, u.repository)
+		}
+		content.total -= previous
+		return -previous
+	}
+	content.sizes[u.dgst] = u.size
+	content.total += u.size - previous
+	return u.size - previous
+}
+
+// beginReconcile starts journaling updates, and returns false if a
+// reconciliation is already running.
+func (ua *usageAccounting) beginReconcile() bool {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	if ua.reconciling {
+		return false
+	}
+	ua.reconciling = true
+	ua.journal = nil
+	return true
+}
+
+// endReconcile replaces the accounting with the content of the repositories
+// read from the storage, on which the updates journaled meanwhile are
+// replayed. If repositories is nil, the reconciliation failed and the
+// accounting is kept.
+func (ua *usageAccounting) endReconcile(repositories map[string]*repositoryContent, reconciledAt time.Time) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	journal := ua.journal
+	ua.reconciling = false
+	ua.journal = nil
+	if repositories == nil {
+		return
+	}
+
+	for _, u := range journal {
+		applyUsageUpdate(repositories, u)
+	}
+
+	namespaces := make(map[string]int64)
+	var total int64
+	for name, content := range repositories {
+		namespaces[usageNamespace(name)] += content.total
+		total += content.total
+	}
+	// Namespaces which are now empty are reported as such.
+	for namespace := range ua.namespaces {
+		if _, ok := namespaces[namespace]; !ok {
+			usageNamespaceGauge.WithValues(namespace).Set(0)
+		}
+	}
+	for namespace, size := range namespaces {
+		usageNamespaceGauge.WithValues(namespace).Set(float64(size))
+	}
+	usageTotalGauge.Set(float64(total))
+
+	ua.repositories = repositories
+	ua.namespaces = namespaces
+	ua.total = total
+	ua.reconciledAt = reconciledAt
+}
+
+// repository returns the bytes stored by the named repository and whether
+// dgst is linked in it. The last return value is false until the accounting
+// is reconciled for the first time.
+func (ua *usageAccounting) repository(name string, dgst digest.Digest) (int64, bool, bool) {
+	if ua == nil {
+		return 0, false, false
+	}
+
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	if ua.reconciledAt.IsZero() {
+		return 0, false, false
+	}
+	content, ok := ua.repositories[name]
+	if !ok {
+		return 0, false, true
+	}
+	_, stored := content.sizes[dgst]
+	return content.total, stored, true
+}
+
+// prefix returns the bytes stored by the repositories whose name starts
+// with prefix, other than the excluded one. The last return value is false
+// until the accounting is reconciled for the first time.
+func (ua *usageAccounting) prefix(prefix, excluded string) (int64, bool) {
+	if ua == nil {
+		return 0, false
+	}
+
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	if ua.reconciledAt.IsZero() {
+		return 0, false
+	}
+	var usage int64
+	for name, content := range ua.repositories {
+		if strings.HasPrefix(name, prefix) && name != excluded {
+			usage += content.total
+		}
+	}
+	return usage, true
+}
+
+// usageEntry is the number of bytes stored by a repository or namespace.
+type usageEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// usageAPIResponse is the body returned by the usage endpoint.
+type usageAPIResponse struct {
+	ReconciledAt *time.Time   `json:"reconciledAt,omitempty"`
+	Total        int64        `json:"total"`
+	Namespaces   []usageEntry `json:"namespaces"`
+	Repositories []usageEntry `json:"repositories"`
+}
+
+// snapshot returns the usage of the repositories of the namespace, or of
+// all repositories if namespace is empty.
+func (ua *usageAccounting) snapshot(namespace string) usageAPIResponse {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	response := usageAPIResponse{
+		Namespaces:   []usageEntry{},
+		Repositories: []usageEntry{},
+	}
+	if !ua.reconciledAt.IsZero() {
+		reconciledAt := ua.reconciledAt
+		response.ReconciledAt = &reconciledAt
+	}
+
+	for name, content := range ua.repositories {
+		if namespace == "" || usageNamespace(name) == namespace {
+			response.Repositories = append(response.Repositories, usageEntry{Name: name, Size: content.total})
+			response.Total += content.total
+		}
+	}
+	for name, size := range ua.namespaces {
+		if size != 0 && (namespace == "" || name == namespace) {
+			response.Namespaces = append(response.Namespaces, usageEntry{Name: name, Size: size})
+		}
+	}
+
+	sort.Slice(response.Repositories, func(i, j int) bool {
+		return response.Repositories[i].Name < response.Repositories[j].Name
+	})
+	sort.Slice(response.Namespaces, func(i, j int) bool {
+		return response.Namespaces[i].Name < response.Namespaces[j].Name
+	})
+	return response
+}
+
+// startUsageAccounting reconciles the accounting of the storage used by
+// repositories with the storage in the background, right away and then
+// periodically.
+func (app *App) startUsageAccounting(config configuration.Usage) {
+	if !config.Enabled {
+		return
+	}
+
+	interval := config.ReconcileInterval
+	if interval <= 0 {
+		interval = defaultUsageReconcileInterval
+	}
+	app.usage = newUsageAccounting()
+
+	log := dcontext.GetLogger(app)
+	log.Infof("usage: reconciling the storage usage of repositories every %s", interval)
+
+	app.goBackground(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			start := time.Now()
+			if err := app.reconcileUsage(ctx); err != nil {
+				log.Errorf("usage: error reconciling storage usage: %v", err)
+			} else {
+				log.Infof("usage: reconciled storage usage in %s", time.Since(start))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// reconcileUsage recomputes the usage of the repositories listed in the
+// catalog from the storage.
+func (app *App) reconcileUsage(ctx context.Context) error {
+	if !app.usage.beginReconcile() {
+		return nil
+	}
+	reconciledAt := time.Now()
+
+	repositories := make(map[string]*repositoryContent)
+	repos := make([]string, quotaRepositoriesPage)
+	last := ""
+	for {
+		n, err := app.registry.Repositories(ctx, repos, last)
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && err != io.EOF && !ok {
+			app.usage.endReconcile(nil, reconciledAt)
+			return err
+		}
+		for _, repo := range repos[:n] {
+			named, err := reference.WithName(repo)
+			if err != nil {
+				app.usage.endReconcile(nil, reconciledAt)
+				return err
+			}
+			sizes, err := app.storedContent(ctx, named)
+			if err != nil {
+				app.usage.endReconcile(nil, reconciledAt)
+				return err
+			}
+			content := &repositoryContent{sizes: sizes}
+			for _, size := range sizes {
+				content.total += size
+			}
+			repositories[repo] = content
+		}
+		if err != nil || n == 0 {
+			break
+		}
+		last = repos[n-1]
+	}
+
+	app.usage.endReconcile(repositories, reconciledAt)
+	return nil
+}
+
+// storedContent returns the size of each layer and manifest linked in the
+// named repository. Links to blobs whose data is missing are skipped, so
+// that a single dangling link does not fail the accounting of the whole
+// repository.
+func (app *App) storedContent(ctx context.Context, name reference.Named) (map[digest.Digest]int64, error) {
+	repository, err := app.registry.Repository(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[digest.Digest]int64)
+	statter := app.registry.BlobStatter()
+	ingest := func(dgst digest.Digest) error {
+		desc, err := statter.Stat(ctx, dgst)
+		if err == distribution.ErrBlobUnknown {
+			dcontext.GetLogger(ctx).Warnf("usage: skipping link to missing blob %s in %s", dgst, name)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sizes[dgst] = desc.Size
+		return nil
+	}
+
+	if enumerator, ok := repository.Blobs(ctx).(distribution.BlobEnumerator); ok {
+		err := enumerator.Enumerate(ctx, ingest)
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+			return nil, err
+		}
+	}
+
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if enumerator, ok := manifests.(distribution.ManifestEnumerator); ok {
+		err := enumerator.Enumerate(ctx, ingest)
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+			return nil, err
+		}
+	}
+
+	return sizes, nil
+}
+
+func usageDispatcher(ctx *Context, r *http.Request) http.Handler {
+	usageHandler := &usageHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(usageHandler.GetUsage),
+	}
+}
+
+type usageHandler struct {
+	*Context
+}
+
+// GetUsage returns the bytes stored by each repository and namespace,
+// optionally restricted to the namespace given by the namespace query
+// parameter.
+func (uh *usageHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	if uh.App.usage == nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported.WithMessage("usage accounting is not enabled"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(uh.App.usage.snapshot(r.URL.Query().Get("namespace"))); err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
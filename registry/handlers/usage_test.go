@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestUsageAccounting(t *testing.T) {
+	ua := newUsageAccounting()
+	if _, _, ok := ua.repository("foo/bar", ""); ok {
+		t.Fatal("expected usage to be unknown before reconciliation")
+	}
+
+	ua.link("foo/bar", "sha256:a", 10)
+	ua.link("foo/bar", "sha256:a", 10)
+	ua.link("foo/baz", "sha256:a", 10)
+	ua.link("other", "sha256:b", 5)
+
+	if !ua.beginReconcile() {
+		t.Fatal("expected reconciliation to begin")
+	}
+	if ua.beginReconcile() {
+		t.Fatal("expected concurrent reconciliation to be refused")
+	}
+	// updates made during the reconciliation are replayed on its result
+	ua.link("foo/bar", "sha256:c", 20)
+	ua.unlink("foo/baz", "sha256:a")
+	ua.endReconcile(map[string]*repositoryContent{
+		"foo/bar": {sizes: map[digest.Digest]int64{"sha256:a": 10, "sha256:d": 1}, total: 11},
+		"foo/baz": {sizes: map[digest.Digest]int64{"sha256:a": 10}, total: 10},
+	}, time.Now())
+
+	usage, stored, ok := ua.repository("foo/bar", "sha256:c")
+	if !ok || !stored || usage != 31 {
+		t.Fatalf("unexpected usage of foo/bar: %d, %v, %v", usage, stored, ok)
+	}
+	if usage, stored, ok := ua.repository("foo/baz", "sha256:a"); !ok || stored || usage != 0 {
+		t.Fatalf("unexpected usage of foo/baz: %d, %v, %v", usage, stored, ok)
+	}
+	if usage, ok := ua.prefix("foo/", "foo/baz"); !ok || usage != 31 {
+		t.Fatalf("unexpected usage of namespace foo: %d, %v", usage, ok)
+	}
+
+	ua.unlink("foo/bar", "sha256:d")
+	snapshot := ua.snapshot("")
+	if snapshot.Total != 30 {
+		t.Fatalf("unexpected total: %d", snapshot.Total)
+	}
+	expected := []usageEntry{{Name: "foo", Size: 30}}
+	if !reflect.DeepEqual(snapshot.Namespaces, expected) {
+		t.Fatalf("unexpected namespaces: %v != %v", snapshot.Namespaces, expected)
+	}
+	expected = []usageEntry{{Name: "foo/bar", Size: 30}}
+	if !reflect.DeepEqual(snapshot.Repositories, expected) {
+		t.Fatalf("unexpected repositories: %v != %v", snapshot.Repositories, expected)
+	}
+	if snapshot := ua.snapshot("other"); snapshot.Total != 0 || len(snapshot.Repositories) != 0 {
+		t.Fatalf("unexpected usage of namespace other: %+v", snapshot)
+	}
+}
+
+func TestUsageAPI(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Usage.Enabled = true
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	// wait for the initial reconciliation
+	for i := 0; ; i++ {
+		if _, _, ok := env.app.usage.repository("", ""); ok {
+			break
+		}
+		if i == 100 {
+			t.Fatal("usage was not reconciled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	content := bytes.Repeat([]byte{1}, 100)
+	dgst := digest.FromBytes(content)
+	for _, repo := range []string{"team/a", "team/b"} {
+		name, _ := reference.WithName(repo)
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(content))
+	}
+
+	getUsage := func(values ...url.Values) usageAPIResponse {
+		t.Helper()
+		u, err := env.builder.BuildUsageURL(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("unexpected error getting usage: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "getting usage", resp, http.StatusOK)
+
+		var usage usageAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+			t.Fatalf("error decoding usage: %v", err)
+		}
+		return usage
+	}
+
+	usage := getUsage()
+	if usage.ReconciledAt == nil || usage.Total != 200 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+	expected := []usageEntry{{Name: "team/a", Size: 100}, {Name: "team/b", Size: 100}}
+	if !reflect.DeepEqual(usage.Repositories, expected) {
+		t.Fatalf("unexpected repositories: %v != %v", usage.Repositories, expected)
+	}
+
+	name, _ := reference.WithName("team/b")
+	ref, _ := reference.WithDigest(name, dgst)
+	blobURL, err := env.builder.BuildBlobURL(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := httpDelete(blobURL)
+	if err != nil {
+		t.Fatalf("unexpected error deleting blob: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "deleting blob", resp, http.StatusAccepted)
+
+	usage = getUsage(url.Values{"namespace": []string{"team"}})
+	expected = []usageEntry{{Name: "team", Size: 100}}
+	if usage.Total != 100 || !reflect.DeepEqual(usage.Namespaces, expected) {
+		t.Fatalf("unexpected usage after delete: %+v", usage)
+	}
+
+	// layers of repositories without manifests are not listed in the
+	// catalog, so the reconciliation forgets them
+	if err := env.app.reconcileUsage(env.ctx); err != nil {
+		t.Fatalf("unexpected error reconciling usage: %v", err)
+	}
+	if usage := getUsage(); usage.Total != 0 {
+		t.Fatalf("unexpected usage after reconciliation: %+v", usage)
+	}
+}
+
+func TestUsageDanglingLink(t *testing.T) {
+	// the repository enumerates its links through the blob descriptor
+	// cache, which still knows the deleted blob
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"cache":    configuration.Parameters{"blobdescriptor": "inmemory"},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	name, _ := reference.WithName("foo/bar")
+	var digests []digest.Digest
+	for _, content := range [][]byte{bytes.Repeat([]byte{1}, 100), bytes.Repeat([]byte{2}, 10)} {
+		dgst := digest.FromBytes(content)
+		uploadURLBase, _ := startPushLayer(t, env, name)
+		pushLayer(t, env.builder, name, dgst, uploadURLBase, bytes.NewReader(content))
+		digests = append(digests, dgst)
+	}
+
+	// delete the data of the second blob, leaving its link in place
+	dgst := digests[1]
+	blobDataPath := "/docker/registry/v2/blobs/" + dgst.Algorithm().String() + "/" + dgst.Encoded()[:2] + "/" + dgst.Encoded()
+	if err := env.app.driver.Delete(env.ctx, blobDataPath); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes, err := env.app.storedContent(env.ctx, name)
+	if err != nil {
+		t.Fatalf("unexpected error computing stored content: %v", err)
+	}
+	expected := map[digest.Digest]int64{digests[0]: 100}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Fatalf("unexpected stored content: %v != %v", sizes, expected)
+	}
+}
+
+func TestUsageAPIDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	u, err := env.builder.BuildUsageURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting usage", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "getting usage", resp, errcode.ErrorCodeUnsupported)
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	"github.com/docker/distribution/version"
+	"github.com/gorilla/handlers"
+)
+
+func versionDispatcher(ctx *Context, r *http.Request) http.Handler {
+	versionHandler := &versionHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(versionHandler.GetVersion),
+	}
+}
+
+type versionHandler struct {
+	*Context
+}
+
+// GetVersion returns the build information of the registry.
+func (vh *versionHandler) GetVersion(w http.ResponseWriter, r *http.Request) {
+	info := version.GetInfo()
+	info.StorageDrivers = factory.Drivers()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(info); err != nil {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// Media types of the vulnerability reports which are summarized, either as
+// the artifact type of an OCI manifest or as the media type of its config.
+const (
+	mediaTypeTrivyReport = "application/vnd.aquasec.trivy.report+json"
+	mediaTypeGrypeReport = "application/vnd.anchore.grype.report+json"
+)
+
+// defaultVulnerabilityReportMaxSize is the size of the largest report
+// summarized, unless configured.
+const defaultVulnerabilityReportMaxSize = 16 << 20
+
+// Vulnerability statuses of an image.
+const (
+	vulnerabilityStatusUnscanned  = "unscanned"
+	vulnerabilityStatusClean      = "clean"
+	vulnerabilityStatusVulnerable = "vulnerable"
+	vulnerabilityStatusBlocked    = "blocked"
+)
+
+// vulnerabilityScanners maps the media types of the reports to the scanner
+// producing them.
+var vulnerabilityScanners = map[string]string{
+	mediaTypeTrivyReport: "trivy",
+	mediaTypeGrypeReport: "grype",
+}
+
+// checkVulnerabilityPolicy returns an error if the vulnerability policy is
+// invalid.
+func checkVulnerabilityPolicy(config *configuration.Configuration) error {
+	severity := config.Policy.Vulnerabilities.BlockSeverity
+	if severity == "" {
+		return nil
+	}
+	if storage.NormalizeSeverity(severity) != severity || severity == storage.SeverityUnknown {
+		return fmt.Errorf("policy.vulnerabilities.blockseverity: invalid severity %q, must be one of critical, high, medium or low", severity)
+	}
+	if !config.Vulnerabilities.Enabled {
+		return fmt.Errorf("policy.vulnerabilities.blockseverity requires vulnerabilities to be enabled")
+	}
+	return nil
+}
+
+// vulnerabilitiesDispatcher constructs the handler listing the vulnerability
+// status of the tags of a repository.
+func vulnerabilitiesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	vulnerabilitiesHandler := &vulnerabilitiesHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(vulnerabilitiesHandler.GetTagsStatus),
+	}
+}
+
+// vulnerabilityDispatcher constructs the handler returning the vulnerability
+// status of an image.
+func vulnerabilityDispatcher(ctx *Context, r *http.Request) http.Handler {
+	vulnerabilitiesHandler := &vulnerabilitiesHandler{
+		Context: ctx,
+	}
+
+	reference := getReference(ctx)
+	dgst, err := digest.Parse(reference)
+	if err != nil {
+		// We just have a tag
+		vulnerabilitiesHandler.Tag = reference
+	} else {
+		vulnerabilitiesHandler.Digest = dgst
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(vulnerabilitiesHandler.GetStatus),
+	}
+}
+
+// vulnerabilitiesHandler handles requests for the vulnerability status of the
+// images of a repository.
+type vulnerabilitiesHandler struct {
+	*Context
+
+	// One of tag or digest gets set, depending on what is present in context.
+	Tag    string
+	Digest digest.Digest
+}
+
+type vulnerabilityStatus struct {
+	Tag        string         `json:"tag,omitempty"`
+	Digest     digest.Digest  `json:"digest"`
+	Status     string         `json:"status"`
+	Highest    string         `json:"highest,omitempty"`
+	Severities map[string]int `json:"severities,omitempty"`
+	Report     digest.Digest  `json:"report,omitempty"`
+	Scanner    string         `json:"scanner,omitempty"`
+	Created    *time.Time     `json:"created,omitempty"`
+}
+
+type vulnerabilityAPIResponse struct {
+	Name string `json:"name"`
+	vulnerabilityStatus
+}
+
+type vulnerabilitiesAPIResponse struct {
+	Name string                `json:"name"`
+	Tags []vulnerabilityStatus `json:"tags"`
+}
+
+// GetStatus returns the vulnerability status of the image.
+func (vh *vulnerabilitiesHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	if !vh.App.Config.Vulnerabilities.Enabled {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnsupported.WithMessage("vulnerability reports are not stored"))
+		return
+	}
+
+	if vh.Tag != "" {
+		desc, err := vh.Repository.Tags(vh).Get(vh, vh.Tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				vh.Errors = append(vh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+			} else {
+				vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			}
+			return
+		}
+		vh.Digest = desc.Digest
+	} else {
+		manifests, err := vh.Repository.Manifests(vh)
+		if err != nil {
+			vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		exists, err := manifests.Exists(vh, vh.Digest)
+		if err != nil {
+			vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		if !exists {
+			vh.Errors = append(vh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(vh.Digest))
+			return
+		}
+	}
+
+	status, err := vh.status(vh.Tag, vh.Digest)
+	if err != nil {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(vulnerabilityAPIResponse{
+		Name:                vh.Repository.Named().Name(),
+		vulnerabilityStatus: status,
+	}); err != nil {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// GetTagsStatus returns the vulnerability status of the tags of the
+// repository.
+func (vh *vulnerabilitiesHandler) GetTagsStatus(w http.ResponseWriter, r *http.Request) {
+	if !vh.App.Config.Vulnerabilities.Enabled {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnsupported.WithMessage("vulnerability reports are not stored"))
+		return
+	}
+
+	tagService := vh.Repository.Tags(vh)
+	tags, err := tagService.All(vh)
+	if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrRepositoryUnknown:
+			vh.Errors = append(vh.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": vh.Repository.Named().Name()}))
+		default:
+			vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+	sort.Strings(tags)
+
+	statuses := make([]vulnerabilityStatus, 0, len(tags))
+	for _, tag := range tags {
+		desc, err := tagService.Get(vh, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				// deleted since it was listed
+				continue
+			}
+			vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+
+		status, err := vh.status(tag, desc.Digest)
+		if err != nil {
+			vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(vulnerabilitiesAPIResponse{
+		Name: vh.Repository.Named().Name(),
+		Tags: statuses,
+	}); err != nil {
+		vh.Errors = append(vh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// status returns the vulnerability status of the image, from its latest
+// report.
+func (vh *vulnerabilitiesHandler) status(tag string, dgst digest.Digest) (vulnerabilityStatus, error) {
+	status := vulnerabilityStatus{
+		Tag:    tag,
+		Digest: dgst,
+		Status: vulnerabilityStatusUnscanned,
+	}
+
+	store := storage.NewVulnerabilityStore(vh.App.driver, vh.Repository.Named())
+	summary, ok, err := store.Get(vh, dgst)
+	if err != nil || !ok {
+		return status, err
+	}
+
+	status.Highest = summary.Highest()
+	status.Severities = summary.Severities
+	status.Report = summary.Report
+	status.Scanner = summary.Scanner
+	status.Created = &summary.Created
+	switch {
+	case vh.App.blocksSeverity(status.Highest):
+		status.Status = vulnerabilityStatusBlocked
+	case status.Highest != "":
+		status.Status = vulnerabilityStatusVulnerable
+	default:
+		status.Status = vulnerabilityStatusClean
+	}
+	return status, nil
+}
+
+// blocksSeverity returns true if the pull of images with a vulnerability of
+// the severity is blocked by the vulnerability policy.
+func (app *App) blocksSeverity(severity string) bool {
+	threshold := app.Config.Policy.Vulnerabilities.BlockSeverity
+	return threshold != "" && severity != "" && storage.SeverityAtLeast(severity, threshold)
+}
+
+// checkVulnerabilities returns an error if the pull of the manifest is
+// blocked by the vulnerability policy.
+func (imh *manifestHandler) checkVulnerabilities(dgst digest.Digest) error {
+	if imh.App.Config.Policy.Vulnerabilities.BlockSeverity == "" {
+		return nil
+	}
+
+	store := storage.NewVulnerabilityStore(imh.App.driver, imh.Repository.Named())
+	summary, ok, err := store.Get(imh, dgst)
+	if err != nil || !ok {
+		return err
+	}
+	if highest := summary.Highest(); imh.App.blocksSeverity(highest) {
+		return v2.ErrorCodeManifestVulnerable.WithDetail(map[string]interface{}{
+			"digest":     dgst,
+			"highest":    highest,
+			"severities": summary.Severities,
+			"report":     summary.Report,
+		})
+	}
+	return nil
+}
+
+// summarizeVulnerabilities stores the summary of the manifest if it is a
+// vulnerability report referring to an image.
+func (imh *manifestHandler) summarizeVulnerabilities(manifest distribution.Manifest, revision digest.Digest) error {
+	if !imh.App.Config.Vulnerabilities.Enabled {
+		return nil
+	}
+
+	m, ok := manifest.(*ocischema.DeserializedManifest)
+	if !ok || m.Subject == nil {
+		return nil
+	}
+	scanner, document, ok := artifactDocument(m, vulnerabilityScanners)
+	if !ok {
+		return nil
+	}
+
+	maxSize := imh.App.Config.Vulnerabilities.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultVulnerabilityReportMaxSize
+	}
+	if document.Size > maxSize {
+		return fmt.Errorf("vulnerability report %s of %d bytes exceeds the maximum size of %d bytes", document.Digest, document.Size, maxSize)
+	}
+
+	p, err := imh.Repository.Blobs(imh).Get(imh, document.Digest)
+	if err != nil {
+		return err
+	}
+
+	severities, err := parseVulnerabilityReport(scanner, p)
+	if err != nil {
+		return err
+	}
+
+	store := storage.NewVulnerabilityStore(imh.App.driver, imh.Repository.Named())
+	return store.Put(imh, storage.VulnerabilitySummary{
+		Subject:    m.Subject.Digest,
+		Report:     revision,
+		Scanner:    scanner,
+		Created:    time.Now().UTC(),
+		Severities: severities,
+	})
+}
+
+// parseVulnerabilityReport returns the number of vulnerabilities of each
+// severity listed by the report of the scanner.
+func parseVulnerabilityReport(scanner string, p []byte) (map[string]int, error) {
+	severities := map[string]int{
+		storage.SeverityCritical: 0,
+		storage.SeverityHigh:     0,
+		storage.SeverityMedium:   0,
+		storage.SeverityLow:      0,
+		storage.SeverityUnknown:  0,
+	}
+
+	switch scanner {
+	case "trivy":
+		var report struct {
+			Results []struct {
+				Vulnerabilities []struct {
+					Severity string `json:"Severity"`
+				} `json:"Vulnerabilities"`
+			} `json:"Results"`
+		}
+		if err := json.Unmarshal(p, &report); err != nil {
+			return nil, fmt.Errorf("invalid Trivy report: %v", err)
+		}
+		for _, result := range report.Results {
+			for _, vulnerability := range result.Vulnerabilities {
+				severities[storage.NormalizeSeverity(vulnerability.Severity)]++
+			}
+		}
+	case "grype":
+		var report struct {
+			Matches []struct {
+				Vulnerability struct {
+					Severity string `json:"severity"`
+				} `json:"vulnerability"`
+			} `json:"matches"`
+		}
+		if err := json.Unmarshal(p, &report); err != nil {
+			return nil, fmt.Errorf("invalid Grype report: %v", err)
+		}
+		for _, match := range report.Matches {
+			severities[storage.NormalizeSeverity(match.Vulnerability.Severity)]++
+		}
+	}
+	return severities, nil
+}
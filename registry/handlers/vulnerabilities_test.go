@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestVulnerabilitiesAPI(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.Vulnerabilities.Enabled = true
+	config.Policy.Vulnerabilities.BlockSeverity = "high"
+	env := newTestEnvWithConfig(t, config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	versioned := manifest.Versioned{
+		SchemaVersion: 2,
+		MediaType:     v1.MediaTypeImageManifest,
+	}
+
+	pushImage := func(tag string) digest.Digest {
+		return pushOCIManifest(t, env, imageName, ocischema.Manifest{
+			Versioned: versioned,
+			Config:    pushBlob(t, env, imageName, v1.MediaTypeImageConfig, []byte(`{"architecture": "amd64", "os": "linux", "tag": "`+tag+`"}`)),
+			Layers:    []distribution.Descriptor{},
+		}, tag)
+	}
+	pushReport := func(subject digest.Digest, mediaType string, report string) digest.Digest {
+		return pushOCIManifest(t, env, imageName, ocischema.Manifest{
+			Versioned:    versioned,
+			ArtifactType: mediaType,
+			Config:       pushBlob(t, env, imageName, "application/vnd.oci.empty.v1+json", []byte("{}")),
+			Layers: []distribution.Descriptor{
+				pushBlob(t, env, imageName, mediaType, []byte(report)),
+			},
+			Subject: &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject},
+		}, "")
+	}
+
+	clean := pushImage("clean")
+	cleanReport := pushReport(clean, mediaTypeGrypeReport, `{"matches": [{"vulnerability": {"id": "CVE-2021-0001", "severity": "Negligible"}}, {"vulnerability": {"id": "CVE-2021-0002", "severity": "Medium"}}]}`)
+	vulnerable := pushImage("vulnerable")
+	pushReport(vulnerable, mediaTypeTrivyReport, `{"Results": [{"Vulnerabilities": [{"VulnerabilityID": "CVE-2021-44228", "Severity": "CRITICAL"}]}]}`)
+	// the latest report replaces the previous ones
+	vulnerableReport := pushReport(vulnerable, mediaTypeTrivyReport, `{"Results": [{"Vulnerabilities": [{"VulnerabilityID": "CVE-2021-44228", "Severity": "CRITICAL"}, {"VulnerabilityID": "CVE-2021-45046", "Severity": "CRITICAL"}]}, {"Vulnerabilities": [{"VulnerabilityID": "CVE-2021-0003", "Severity": "LOW"}]}]}`)
+	unscanned := pushImage("unscanned")
+
+	vulnerabilitiesURL, err := env.builder.BuildVulnerabilitiesURL(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error building vulnerabilities url: %v", err)
+	}
+	resp, err := http.Get(vulnerabilitiesURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching vulnerabilities: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching vulnerabilities", resp, http.StatusOK)
+
+	var body vulnerabilitiesAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("error decoding vulnerabilities response: %v", err)
+	}
+	if len(body.Tags) != 3 {
+		t.Fatalf("unexpected tags: %+v", body.Tags)
+	}
+	for i, expected := range []struct {
+		tag, status, highest string
+		digest, report       digest.Digest
+		severities           map[string]int
+	}{
+		{tag: "clean", digest: clean, status: "vulnerable", highest: "medium", report: cleanReport, severities: map[string]int{"critical": 0, "high": 0, "medium": 1, "low": 1, "unknown": 0}},
+		{tag: "unscanned", digest: unscanned, status: "unscanned"},
+		{tag: "vulnerable", digest: vulnerable, status: "blocked", highest: "critical", report: vulnerableReport, severities: map[string]int{"critical": 2, "high": 0, "medium": 0, "low": 1, "unknown": 0}},
+	} {
+		status := body.Tags[i]
+		if status.Tag != expected.tag || status.Digest != expected.digest || status.Status != expected.status ||
+			status.Highest != expected.highest || status.Report != expected.report || !reflect.DeepEqual(status.Severities, expected.severities) {
+			t.Errorf("unexpected status of %s: %+v", expected.tag, status)
+		}
+	}
+
+	vulnerabilityURL, err := env.builder.BuildVulnerabilityURL(mustWithDigest(t, imageName, vulnerable))
+	if err != nil {
+		t.Fatalf("unexpected error building vulnerability url: %v", err)
+	}
+	resp, err = http.Get(vulnerabilityURL)
+	if err != nil {
+		t.Fatalf("unexpected error fetching vulnerability status: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "fetching vulnerability status", resp, http.StatusOK)
+
+	var statusBody vulnerabilityAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&statusBody); err != nil {
+		t.Fatalf("error decoding vulnerability response: %v", err)
+	}
+	if statusBody.Name != "foo/bar" || statusBody.Status != "blocked" || statusBody.Scanner != "trivy" {
+		t.Errorf("unexpected status: %+v", statusBody)
+	}
+
+	// pulls of images with vulnerabilities of high severity or above are denied
+	for _, tc := range []struct {
+		tag    string
+		status int
+	}{
+		{tag: "clean", status: http.StatusOK},
+		{tag: "unscanned", status: http.StatusOK},
+		{tag: "vulnerable", status: http.StatusForbidden},
+	} {
+		tagRef, _ := reference.WithTag(imageName, tc.tag)
+		manifestURL, err := env.builder.BuildManifestURL(tagRef)
+		if err != nil {
+			t.Fatalf("unexpected error building manifest url: %v", err)
+		}
+		req, _ := http.NewRequest(http.MethodGet, manifestURL, nil)
+		req.Header.Set("Accept", v1.MediaTypeImageManifest)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error fetching manifest: %v", err)
+		}
+		defer resp.Body.Close()
+
+		checkResponse(t, "fetching "+tc.tag, resp, tc.status)
+		if tc.status != http.StatusOK {
+			checkBodyHasErrorCodes(t, "fetching "+tc.tag, resp, v2.ErrorCodeManifestVulnerable)
+		}
+	}
+}
+
+func TestCheckVulnerabilityPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		severity string
+		enabled  bool
+		valid    bool
+	}{
+		{valid: true},
+		{severity: "high", enabled: true, valid: true},
+		{severity: "high"},
+		{severity: "HIGH", enabled: true},
+		{severity: "unknown", enabled: true},
+		{severity: "severe", enabled: true},
+	} {
+		config := &configuration.Configuration{}
+		config.Vulnerabilities.Enabled = tc.enabled
+		config.Policy.Vulnerabilities.BlockSeverity = tc.severity
+		if err := checkVulnerabilityPolicy(config); (err == nil) != tc.valid {
+			t.Errorf("unexpected result for severity %q, enabled %v: %v", tc.severity, tc.enabled, err)
+		}
+	}
+}
+
+func mustWithDigest(t *testing.T, name reference.Named, dgst digest.Digest) reference.Canonical {
+	ref, err := reference.WithDigest(name, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	"github.com/spf13/cobra"
+)
+
+// IndexCmd is the cobra command grouping the metadata index subcommands
+var IndexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "`index` manages the metadata index of the registry",
+	Long:  "`index` manages the metadata index of the repositories, tags and manifests of the registry",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Usage()
+	},
+}
+
+// IndexRebuildCmd is the cobra command that corresponds to the index rebuild
+// subcommand
+var IndexRebuildCmd = &cobra.Command{
+	Use:   "rebuild <config>",
+	Short: "`rebuild` rebuilds the metadata index from the storage",
+	Long: "`rebuild` replaces the content of the metadata index with the repositories, " +
+		"tags and manifests read from the storage. The registry must be stopped, " +
+		"as it locks the index while it runs",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if !config.MetadataIndex.Enabled || config.MetadataIndex.Path == "" {
+			fmt.Fprintln(os.Stderr, "the metadata index is not enabled in the configuration")
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v\n", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		index, err := storage.OpenMetadataIndex(config.MetadataIndex.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		defer index.Close()
+
+		start := time.Now()
+		if err := index.Rebuild(ctx, driver); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rebuild metadata index: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("rebuilt the metadata index in %s\n", time.Since(start))
+	},
+}
@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportOutput     string
+	importRepository string
+)
+
+// ExportCmd is the cobra command that corresponds to the export subcommand
+var ExportCmd = &cobra.Command{
+	Use:   "export <repository[:tag]> <config>",
+	Short: "`export` writes a repository to a tar archive of an OCI image layout",
+	Long: "`export` writes the tagged manifests of a repository, or only the given tag, " +
+		"along with the manifests and blobs they reference, to a tar archive of an OCI " +
+		"image layout, reading them from the configured storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "the repository to export is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ref, err := reference.Parse(args[0])
+		named, ok := ref.(reference.Named)
+		if err != nil || !ok {
+			fmt.Fprintf(os.Stderr, "invalid repository %q\n", args[0])
+			os.Exit(1)
+		}
+		var tags []string
+		if tagged, ok := named.(reference.Tagged); ok {
+			tags = []string{tagged.Tag()}
+		}
+		config, err := resolveConfiguration(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if exportOutput == "" {
+			fmt.Fprintln(os.Stderr, "the archive to write is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx, registry, closeRegistry := openStorageRegistry(config, false)
+		defer closeRegistry()
+
+		var out io.Writer = os.Stdout
+		if exportOutput != "-" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := storage.ExportOCILayout(ctx, registry, reference.TrimNamed(named), tags, out); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to export %s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ImportCmd is the cobra command that corresponds to the import subcommand
+var ImportCmd = &cobra.Command{
+	Use:   "import <archive> <config>",
+	Short: "`import` stores the content of a tar archive of an OCI image layout",
+	Long: "`import` stores the manifests and blobs of a tar archive of an OCI image " +
+		"layout in the configured storage, then tags the manifests of its index. " +
+		"Manifests are imported into the repository named by their " +
+		"io.containerd.image.name annotation, as written by export, unless --repository is given",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "the archive to import is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		var named reference.Named
+		if importRepository != "" {
+			var err error
+			named, err = reference.WithName(importRepository)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid repository %q: %v\n", importRepository, err)
+				os.Exit(1)
+			}
+		}
+		config, err := resolveConfiguration(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		ctx, registry, closeRegistry := openStorageRegistry(config, true)
+		defer closeRegistry()
+
+		refs, err := storage.ImportOCILayout(ctx, registry, f, named)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import %s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+		for _, ref := range refs {
+			fmt.Printf("imported %s\n", ref)
+		}
+	},
+}
+
+// openStorageRegistry returns a registry reading and writing the configured
+// storage, exiting on error. If writes is true, the registry updates the
+// metadata index, if enabled, so the registry must be stopped, as it locks
+// the index while it runs.
+func openStorageRegistry(config *configuration.Configuration, writes bool) (context.Context, distribution.Namespace, func()) {
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v\n", config.Storage.Type(), err)
+		os.Exit(1)
+	}
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	closeRegistry := func() {}
+	var options []storage.RegistryOption
+	if writes && config.MetadataIndex.Enabled {
+		index, err := storage.OpenMetadataIndex(config.MetadataIndex.Path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		closeRegistry = func() { index.Close() }
+		options = append(options, storage.UseMetadataIndex(index))
+	}
+	registry, err := storage.NewRegistry(ctx, driver, options...)
+	if err != nil {
+		closeRegistry()
+		fmt.Fprintf(os.Stderr, "failed to construct registry: %v\n", err)
+		os.Exit(1)
+	}
+	return ctx, registry, closeRegistry
+}
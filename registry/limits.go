@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/api/errcode"
+)
+
+// defaultRetryAfter is the default duration suggested to clients whose
+// requests are shed.
+const defaultRetryAfter = time.Second
+
+// semaphore is a counting semaphore which never blocks on acquisition. A nil
+// semaphore places no limit.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// tryAcquire takes a slot in the semaphore, returning false if none is
+// available.
+func (s semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// concurrencyLimiter sheds requests in excess of the configured number of
+// in-flight requests.
+type concurrencyLimiter struct {
+	handler    http.Handler
+	requests   semaphore
+	reads      semaphore
+	writes     semaphore
+	retryAfter string
+}
+
+// limitConcurrency wraps the handler with a concurrency limiter if any limit
+// is configured.
+func limitConcurrency(config *configuration.Configuration, handler http.Handler) http.Handler {
+	limits := config.HTTP.ConcurrencyLimit
+	if limits.MaxRequests <= 0 && limits.MaxReads <= 0 && limits.MaxWrites <= 0 {
+		return handler
+	}
+
+	retryAfter := limits.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	// Retry-After is expressed in whole seconds, rounded up.
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+
+	return &concurrencyLimiter{
+		handler:    handler,
+		requests:   newSemaphore(limits.MaxRequests),
+		reads:      newSemaphore(limits.MaxReads),
+		writes:     newSemaphore(limits.MaxWrites),
+		retryAfter: strconv.FormatInt(seconds, 10),
+	}
+}
+
+func (cl *concurrencyLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	class := cl.writes
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		class = cl.reads
+	}
+
+	if !cl.requests.tryAcquire() {
+		cl.shed(w, r)
+		return
+	}
+	defer cl.requests.release()
+
+	if !class.tryAcquire() {
+		cl.shed(w, r)
+		return
+	}
+	defer class.release()
+
+	cl.handler.ServeHTTP(w, r)
+}
+
+// shed rejects the request as the registry is overloaded.
+func (cl *concurrencyLimiter) shed(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(r.Context()).Warnf("shedding request %s %s: concurrency limit reached", r.Method, r.URL.Path)
+	w.Header().Set("Retry-After", cl.retryAfter)
+	if err := errcode.ServeJSON(w, errcode.ErrorCodeUnavailable.WithDetail("concurrency limit reached")); err != nil {
+		dcontext.GetLogger(r.Context()).Errorf("error serving error json: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.ConcurrencyLimit.MaxReads = 1
+	config.HTTP.ConcurrencyLimit.RetryAfter = 1500 * time.Millisecond
+
+	block := make(chan struct{})
+	entered := make(chan struct{})
+	handler := limitConcurrency(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			entered <- struct{}{}
+			<-block
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v2/", nil))
+	}()
+	<-entered
+
+	// a second read is shed while the first is in flight
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/v2/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Fatalf("unexpected Retry-After header: %q", retryAfter)
+	}
+
+	// writes are not subject to the read limit
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/v2/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	close(block)
+	<-done
+
+	// the slot is released once the first read completes
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/v2/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestConcurrencyLimitDisabled(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if _, ok := limitConcurrency(&configuration.Configuration{}, handler).(*concurrencyLimiter); ok {
+		t.Fatal("expected no limiter without configured limits")
+	}
+}
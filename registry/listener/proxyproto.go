@@ -0,0 +1,275 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultProxyHeaderTimeout is the default amount of time allowed to read the
+// PROXY protocol header of a connection.
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+// maxV1HeaderLength is the maximum length of a PROXY protocol v1 header,
+// including the trailing CRLF.
+const maxV1HeaderLength = 107
+
+// v2Signature is the signature starting every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// errInvalidProxyHeader is returned when the PROXY protocol header of a
+// connection cannot be parsed.
+var errInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxyProtocolListener accepts connections prefixed by a PROXY protocol v1
+// or v2 header, as sent by load balancers such as HAProxy or AWS NLB, and
+// reports the client address carried by the header as the remote address of
+// the connection.
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// NewProxyProtocolListener wraps the listener to parse the PROXY protocol
+// header of accepted connections. If trusted is not empty, the header is only
+// expected from peers within the trusted networks, and connections from other
+// peers are passed through unchanged. A timeout of zero uses a default
+// timeout for reading the header.
+func NewProxyProtocolListener(ln net.Listener, trusted []*net.IPNet, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	return &proxyProtocolListener{
+		Listener: ln,
+		trusted:  trusted,
+		timeout:  timeout,
+	}
+}
+
+func (ln *proxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ln.trusted) != 0 && !ContainsAddr(ln.trusted, c.RemoteAddr()) {
+		return c, nil
+	}
+
+	return &proxyProtocolConn{
+		Conn:    c,
+		reader:  bufio.NewReader(c),
+		timeout: ln.timeout,
+	}, nil
+}
+
+// proxyProtocolConn reads the PROXY protocol header of the connection on
+// first use, so that a slow peer does not block the accepting goroutine.
+type proxyProtocolConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		c.remoteAddr, c.err = readProxyHeader(c.reader)
+	})
+}
+
+func (c *proxyProtocolConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the client address from the PROXY protocol header, or
+// the address of the peer if the header did not carry one.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from r, returning
+// the source address it carries. A nil address is returned for headers which
+// do not carry a source address, such as health checks of the proxy.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(len(v2Signature))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if bytes.Equal(prefix, v2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errInvalidProxyHeader
+}
+
+// readProxyHeaderV1 parses a human-readable header of the form
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1HeaderLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, errInvalidProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errInvalidProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 parses a binary header.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(v2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	versionCommand := header[12]
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidProxyHeader, versionCommand>>4)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch versionCommand & 0xf {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: unsupported command %d", errInvalidProxyHeader, versionCommand&0xf)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	default:
+		// unspecified or unsupported address family, such as unix sockets
+		return nil, nil
+	}
+}
+
+// ParseNetworks parses a list of CIDRs or single IP addresses.
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ContainsAddr returns true if the IP of addr is within any of the networks.
+func ContainsAddr(networks []*net.IPNet, addr net.Addr) bool {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+	return ContainsIP(networks, ip)
+}
+
+// ContainsIP returns true if ip is within any of the networks.
+func ContainsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
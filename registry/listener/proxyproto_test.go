@@ -0,0 +1,186 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	for _, tc := range []struct {
+		header   string
+		expected string
+		invalid  bool
+	}{
+		{header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", expected: "192.0.2.1:56324"},
+		{header: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", expected: "[2001:db8::1]:56324"},
+		{header: "PROXY UNKNOWN\r\n"},
+		{header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324\r\n", invalid: true},
+		{header: "PROXY TCP4 not-an-ip 192.0.2.2 56324 443\r\n", invalid: true},
+		{header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n", invalid: true},
+		{header: "GET / HTTP/1.1\r\n", invalid: true},
+	} {
+		r := bufio.NewReader(strings.NewReader(tc.header + "payload"))
+		addr, err := readProxyHeader(r)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%q: expected error", tc.header)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.header, err)
+			continue
+		}
+
+		checkAddr(t, tc.header, addr, tc.expected)
+		checkPayload(t, tc.header, r)
+	}
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	v4 := make([]byte, 12)
+	copy(v4[0:4], net.ParseIP("192.0.2.1").To4())
+	copy(v4[4:8], net.ParseIP("192.0.2.2").To4())
+	binary.BigEndian.PutUint16(v4[8:10], 56324)
+	binary.BigEndian.PutUint16(v4[10:12], 443)
+
+	v6 := make([]byte, 36)
+	copy(v6[0:16], net.ParseIP("2001:db8::1"))
+	copy(v6[16:32], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(v6[32:34], 56324)
+	binary.BigEndian.PutUint16(v6[34:36], 443)
+
+	for _, tc := range []struct {
+		name     string
+		command  byte
+		family   byte
+		payload  []byte
+		expected string
+		invalid  bool
+	}{
+		{name: "tcp4", command: 0x21, family: 0x11, payload: v4, expected: "192.0.2.1:56324"},
+		{name: "tcp6", command: 0x21, family: 0x21, payload: v6, expected: "[2001:db8::1]:56324"},
+		{name: "local", command: 0x20, family: 0x00},
+		{name: "unix", command: 0x21, family: 0x31, payload: make([]byte, 216)},
+		{name: "short", command: 0x21, family: 0x11, payload: v4[:8], invalid: true},
+		{name: "version", command: 0x11, family: 0x11, payload: v4, invalid: true},
+	} {
+		var header bytes.Buffer
+		header.Write(v2Signature)
+		header.WriteByte(tc.command)
+		header.WriteByte(tc.family)
+		binary.Write(&header, binary.BigEndian, uint16(len(tc.payload)))
+		header.Write(tc.payload)
+		header.WriteString("payload")
+
+		r := bufio.NewReader(&header)
+		addr, err := readProxyHeader(r)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+
+		checkAddr(t, tc.name, addr, tc.expected)
+		checkPayload(t, tc.name, r)
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not create listener: %v", err)
+	}
+	defer ln.Close()
+
+	trusted, err := ParseNetworks([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("unexpected error parsing networks: %v", err)
+	}
+	ln = NewProxyProtocolListener(ln, trusted, 0)
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\npayload"))
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error accepting connection: %v", err)
+	}
+	defer conn.Close()
+
+	if addr := conn.RemoteAddr().String(); addr != "192.0.2.1:56324" {
+		t.Fatalf("unexpected remote address: %s", addr)
+	}
+	payload, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("unexpected error reading payload: %v", err)
+	}
+	if string(payload) != "payload" {
+		t.Fatalf("unexpected payload: %q", payload)
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("unexpected error parsing networks: %v", err)
+	}
+
+	for ip, expected := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.0.2.1":   true,
+		"192.0.2.2":   false,
+		"2001:db8::1": true,
+		"2001:db9::1": false,
+	} {
+		if contains := ContainsIP(networks, net.ParseIP(ip)); contains != expected {
+			t.Errorf("%s: expected %v, got %v", ip, expected, contains)
+		}
+	}
+
+	if _, err := ParseNetworks([]string{"not-an-ip"}); err == nil {
+		t.Fatal("expected error parsing invalid address")
+	}
+}
+
+func checkAddr(t *testing.T, name string, addr net.Addr, expected string) {
+	t.Helper()
+
+	if expected == "" {
+		if addr != nil {
+			t.Errorf("%s: expected no address, got %v", name, addr)
+		}
+		return
+	}
+	if addr == nil || addr.String() != expected {
+		t.Errorf("%s: expected address %s, got %v", name, expected, addr)
+	}
+}
+
+func checkPayload(t *testing.T, name string, r io.Reader) {
+	t.Helper()
+
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		t.Errorf("%s: unexpected error reading payload: %v", name, err)
+	}
+	if string(payload) != "payload" {
+		t.Errorf("%s: unexpected payload %q", name, payload)
+	}
+}
@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"io"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logOutput returns a writer to the configured log file, rotating it once it
+// reaches its maximum size, or the fallback writer if no file is configured.
+func logOutput(output configuration.LogOutput, fallback io.Writer) io.Writer {
+	if output.Path == "" {
+		return fallback
+	}
+
+	var maxAgeDays int
+	if output.MaxAge > 0 {
+		day := 24 * time.Hour
+		maxAgeDays = int((output.MaxAge + day - 1) / day)
+	}
+
+	return &lumberjack.Logger{
+		Filename:   output.Path,
+		MaxSize:    output.MaxSize,
+		MaxAge:     maxAgeDays,
+		MaxBackups: output.MaxBackups,
+		Compress:   output.Compress,
+	}
+}
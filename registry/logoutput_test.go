@@ -0,0 +1,47 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+func TestLogOutput(t *testing.T) {
+	if w := logOutput(configuration.LogOutput{}, os.Stdout); w != os.Stdout {
+		t.Fatalf("expected fallback writer without a path, got %T", w)
+	}
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	w := logOutput(configuration.LogOutput{
+		Path:       path,
+		MaxSize:    10,
+		MaxAge:     36 * time.Hour,
+		MaxBackups: 3,
+		Compress:   true,
+	}, os.Stdout)
+
+	logger, ok := w.(*lumberjack.Logger)
+	if !ok {
+		t.Fatalf("unexpected writer: %T", w)
+	}
+	defer logger.Close()
+
+	if logger.MaxAge != 2 {
+		t.Fatalf("unexpected max age in days: %d != 2", logger.MaxAge)
+	}
+
+	if _, err := w.Write([]byte("GET /v2/ 200\n")); err != nil {
+		t.Fatalf("unexpected error writing log: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading log file: %v", err)
+	}
+	if string(content) != "GET /v2/ 200\n" {
+		t.Fatalf("unexpected log file content: %q", content)
+	}
+}
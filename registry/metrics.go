@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// defaultMetricsPath is the default path of the metrics endpoint.
+const defaultMetricsPath = "/metrics"
+
+// serveMetrics serves the Prometheus metrics of the gatherer on the
+// configured path of the main listener, passing all other requests to the
+// handler.
+func serveMetrics(config *configuration.Configuration, gatherer prometheus.Gatherer, handler http.Handler) http.Handler {
+	if !config.Metrics.HTTP.Enabled {
+		return handler
+	}
+
+	path := config.Metrics.HTTP.Path
+	if path == "" {
+		path = defaultMetricsPath
+	}
+	metricsHandler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if !authorizedRequest(r, config.Metrics.HTTP.Username, config.Metrics.HTTP.Password, config.Metrics.HTTP.BearerToken) {
+			if config.Metrics.HTTP.BearerToken != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		metricsHandler.ServeHTTP(w, r)
+	})
+}
+
+// authorizedRequest returns true if the request carries the given basic auth
+// or bearer token credentials, or if no credentials are given.
+func authorizedRequest(r *http.Request, username, password, bearerToken string) bool {
+	if bearerToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(bearerToken)) == 1 {
+			return true
+		}
+	}
+
+	if username != "" || password != "" {
+		user, pass, ok := r.BasicAuth()
+		if ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1 {
+			return true
+		}
+	}
+
+	return bearerToken == "" && username == "" && password == ""
+}
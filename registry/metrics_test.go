@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestServeMetrics(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Metrics.HTTP.Enabled = true
+	config.Metrics.HTTP.Path = "/internal/metrics"
+	config.Metrics.HTTP.Username = "prometheus"
+	config.Metrics.HTTP.Password = "secret"
+
+	handler := serveMetrics(config, prometheus.DefaultGatherer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	for _, tc := range []struct {
+		path               string
+		username, password string
+		expected           int
+	}{
+		{path: "/v2/", expected: http.StatusTeapot},
+		{path: "/internal/metrics", expected: http.StatusUnauthorized},
+		{path: "/internal/metrics", username: "prometheus", password: "wrong", expected: http.StatusUnauthorized},
+		{path: "/internal/metrics", username: "prometheus", password: "secret", expected: http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.username != "" {
+			req.SetBasicAuth(tc.username, tc.password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tc.expected {
+			t.Errorf("unexpected status for %s as %q: %d != %d", tc.path, tc.username, rec.Code, tc.expected)
+		}
+	}
+}
+
+func TestServeMetricsBearerToken(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Metrics.HTTP.Enabled = true
+	config.Metrics.HTTP.BearerToken = "token"
+
+	handler := serveMetrics(config, prometheus.DefaultGatherer, http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status without token: %d", rec.Code)
+	}
+	if challenge := rec.Header().Get("WWW-Authenticate"); challenge != `Bearer realm="metrics"` {
+		t.Fatalf("unexpected challenge: %q", challenge)
+	}
+
+	req.Header.Set("Authorization", "Bearer token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status with token: %d", rec.Code)
+	}
+}
+
+func TestIsolatedMetrics(t *testing.T) {
+	newRegistry := func(namespace string) *Registry {
+		config := &configuration.Configuration{}
+		config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+		config.Metrics.HTTP.Enabled = true
+		config.Metrics.Namespace = namespace
+
+		registry, err := NewRegistry(context.Background(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return registry
+	}
+
+	// creating registries with metrics enabled must not panic on duplicate
+	// registrations
+	first := newRegistry("")
+	newRegistry("")
+	other := newRegistry("mirror")
+
+	for _, tc := range []struct {
+		registry *Registry
+		metric   string
+	}{
+		{first, "registry_http_requests_total"},
+		{other, "mirror_http_requests_total"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		tc.registry.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+		rec := httptest.NewRecorder()
+		tc.registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status serving metrics: %d", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), tc.metric) {
+			t.Fatalf("expected metric %s in:\n%s", tc.metric, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// defaultOTLPMetricsInterval is the default time between two exports of
+// metrics to the OTLP collector.
+const defaultOTLPMetricsInterval = time.Minute
+
+// configureOTLPMetrics periodically exports the Prometheus metrics of the
+// gatherer to the configured OTLP collector. The returned function exports
+// the metrics a last time and stops the exporter.
+func configureOTLPMetrics(ctx context.Context, config *configuration.Configuration, gatherer prometheus.Gatherer) (func(context.Context) error, error) {
+	otlp := config.Metrics.OTLP
+	if !otlp.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var options []otlpmetrichttp.Option
+	if otlp.Endpoint != "" {
+		options = append(options, otlpmetrichttp.WithEndpoint(otlp.Endpoint))
+	}
+	if otlp.Insecure {
+		options = append(options, otlpmetrichttp.WithInsecure())
+	}
+	if len(otlp.Headers) != 0 {
+		options = append(options, otlpmetrichttp.WithHeaders(otlp.Headers))
+	}
+
+	exporter, err := otlpmetrichttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP metric exporter: %v", err)
+	}
+
+	res, err := telemetryResource(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating metrics resource: %v", err)
+	}
+
+	interval := otlp.Interval
+	if interval <= 0 {
+		interval = defaultOTLPMetricsInterval
+	}
+
+	reader := sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))
+	reader.RegisterProducer(metrics.NewPrometheusProducer(gatherer))
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(res),
+	)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,58 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/handlers"
+)
+
+// preflight initializes the registry described by config without binding its
+// listener, checks the connectivity to its dependencies and writes a report
+// to w. It returns an error if the registry could not be initialized or any
+// check failed.
+func preflight(ctx context.Context, config *configuration.Configuration, w io.Writer) (err error) {
+	// NewApp panics on invalid configuration, report it like any other
+	// failure instead.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error initializing registry: %v", r)
+		}
+	}()
+
+	registry, err := NewRegistry(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer registry.shutdownTracing(context.Background())
+	defer registry.shutdownMetrics(context.Background())
+
+	checks := registry.app.Preflight(ctx)
+	if config.HTTP.TLS.Certificate != "" {
+		_, err := tls.LoadX509KeyPair(config.HTTP.TLS.Certificate, config.HTTP.TLS.Key)
+		checks = append(checks, handlers.PreflightCheck{Name: "tls certificate", Err: err})
+	}
+
+	var failed int
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, check := range checks {
+		status := "ok"
+		if check.Err != nil {
+			status = "FAIL: " + check.Err.Error()
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", check.Name, status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d preflight checks failed", failed, len(checks))
+	}
+	return nil
+}
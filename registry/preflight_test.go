@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestPreflight(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	// reserve an address nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + ln.Addr().String() + "/events"
+	ln.Close()
+
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.Log.Level = "error"
+	config.Notifications.Endpoints = []configuration.Endpoint{
+		{Name: "reachable", URL: server.URL + "/events", Timeout: time.Second},
+		{Name: "disabled", URL: closedURL, Disabled: true},
+	}
+
+	var report bytes.Buffer
+	if err := preflight(context.Background(), config, &report); err != nil {
+		t.Fatalf("unexpected preflight error: %v\n%s", err, report.String())
+	}
+	for _, expected := range []string{"storage driver inmemory", "notification endpoint reachable"} {
+		if !strings.Contains(report.String(), expected) {
+			t.Errorf("report does not contain %q:\n%s", expected, report.String())
+		}
+	}
+	if strings.Contains(report.String(), "disabled") {
+		t.Errorf("report contains a disabled endpoint:\n%s", report.String())
+	}
+
+	config.Notifications.Endpoints = append(config.Notifications.Endpoints,
+		configuration.Endpoint{Name: "unreachable", URL: closedURL, Timeout: time.Second})
+
+	report.Reset()
+	if err := preflight(context.Background(), config, &report); err == nil {
+		t.Fatalf("expected preflight to fail:\n%s", report.String())
+	}
+	if !strings.Contains(report.String(), "notification endpoint unreachable  FAIL") {
+		t.Errorf("report does not contain the failed check:\n%s", report.String())
+	}
+}
+
+func TestPreflightInvalidConfiguration(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.Log.Level = "error"
+	config.Auth = configuration.Auth{"unknown": configuration.Parameters{}}
+
+	if err := preflight(context.Background(), config, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error for an unknown access controller")
+	}
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// cacheStateSaveFrequency is how often the index of the cached blobs is
+// persisted when it changed.
+const cacheStateSaveFrequency = 5 * time.Second
+
+// evictFunc removes the blob, linked into the repositories, from the cache.
+type evictFunc func(ctx context.Context, dgst digest.Digest, repositories []string) error
+
+// cacheEntry is a blob in the cache. Fields are exported for serialization.
+type cacheEntry struct {
+	Digest       digest.Digest `json:"digest"`
+	Size         int64         `json:"size"`
+	LastAccess   time.Time     `json:"lastaccess"`
+	Repositories []string      `json:"repositories,omitempty"`
+
+	element *list.Element
+}
+
+// cacheLimiter keeps the total size of the blobs in the cache under a limit
+// by evicting the least recently used blobs once it is exceeded. The index of
+// the cached blobs is persisted to the storage driver.
+type cacheLimiter struct {
+	ctx             context.Context
+	driver          driver.StorageDriver
+	pathToStateFile string
+	limit           int64
+	evict           evictFunc
+
+	mu      sync.Mutex
+	entries map[digest.Digest]*cacheEntry
+	lru     *list.List // most recently used first
+	size    int64
+	dirty   bool
+}
+
+// newCacheLimiter returns a limiter evicting blobs once their total size
+// exceeds limit bytes.
+func newCacheLimiter(ctx context.Context, driver driver.StorageDriver, path string, limit int64, evict evictFunc) *cacheLimiter {
+	return &cacheLimiter{
+		ctx:             ctx,
+		driver:          driver,
+		pathToStateFile: path,
+		limit:           limit,
+		evict:           evict,
+		entries:         make(map[digest.Digest]*cacheEntry),
+		lru:             list.New(),
+	}
+}
+
+// start loads the index of the cached blobs, or builds it from the blobs in
+// storage on first start, and persists it periodically from then on.
+func (cl *cacheLimiter) start(blobs distribution.BlobEnumerator, statter distribution.BlobStatter) error {
+	found, err := cl.readState()
+	if err != nil {
+		return err
+	}
+	proxyMetrics.CacheLimit(cl.limit)
+
+	if !found {
+		// the blobs cached before the limit was set are the first to
+		// be evicted
+		go func() {
+			err := blobs.Enumerate(cl.ctx, func(dgst digest.Digest) error {
+				desc, err := statter.Stat(cl.ctx, dgst)
+				if err != nil {
+					return nil
+				}
+				cl.seed(dgst, desc.Size)
+				return nil
+			})
+			if err != nil {
+				dcontext.GetLogger(cl.ctx).Errorf("Error indexing the blobs of the proxy cache: %s", err)
+			}
+			cl.evictOverLimit("")
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(cacheStateSaveFrequency)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cl.saveState()
+			case <-cl.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// seed indexes a blob found in storage, as used less recently than all the
+// others.
+func (cl *cacheLimiter) seed(dgst digest.Digest, size int64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if _, ok := cl.entries[dgst]; ok {
+		return
+	}
+	entry := &cacheEntry{Digest: dgst, Size: size}
+	entry.element = cl.lru.PushBack(entry)
+	cl.entries[dgst] = entry
+	cl.size += size
+	cl.dirty = true
+	proxyMetrics.CacheSize(cl.size)
+}
+
+// added records that the blob was cached in the repository, and evicts the
+// least recently used blobs if the cache is over its limit.
+func (cl *cacheLimiter) added(repository string, dgst digest.Digest, size int64) {
+	cl.mu.Lock()
+	entry, ok := cl.entries[dgst]
+	if !ok {
+		entry = &cacheEntry{Digest: dgst, Size: size}
+		entry.element = cl.lru.PushFront(entry)
+		cl.entries[dgst] = entry
+		cl.size += size
+		proxyMetrics.CacheSize(cl.size)
+	} else {
+		cl.lru.MoveToFront(entry.element)
+	}
+	entry.LastAccess = time.Now().UTC()
+	if !containsString(entry.Repositories, repository) {
+		entry.Repositories = append(entry.Repositories, repository)
+	}
+	cl.dirty = true
+	cl.mu.Unlock()
+
+	cl.evictOverLimit(dgst)
+}
+
+// accessed records that the blob was served from the cache.
+func (cl *cacheLimiter) accessed(dgst digest.Digest) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if entry, ok := cl.entries[dgst]; ok {
+		entry.LastAccess = time.Now().UTC()
+		cl.lru.MoveToFront(entry.element)
+		cl.dirty = true
+	}
+}
+
+// removed records that the blob was removed from the cache, such as when it
+// expired.
+func (cl *cacheLimiter) removed(dgst digest.Digest) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.remove(dgst)
+}
+
+// remove drops the blob from the index. The lock must be held.
+func (cl *cacheLimiter) remove(dgst digest.Digest) *cacheEntry {
+	entry, ok := cl.entries[dgst]
+	if !ok {
+		return nil
+	}
+	cl.lru.Remove(entry.element)
+	delete(cl.entries, dgst)
+	cl.size -= entry.Size
+	cl.dirty = true
+	proxyMetrics.CacheSize(cl.size)
+	return entry
+}
+
+// evictOverLimit evicts the least recently used blobs until the cache is
+// under its limit, sparing the given blob which was just cached.
+func (cl *cacheLimiter) evictOverLimit(keep digest.Digest) {
+	cl.mu.Lock()
+	var victims []*cacheEntry
+	for cl.size > cl.limit {
+		back := cl.lru.Back()
+		if back == nil || back.Value.(*cacheEntry).Digest == keep {
+			break
+		}
+		victims = append(victims, cl.remove(back.Value.(*cacheEntry).Digest))
+	}
+	cl.mu.Unlock()
+
+	// blobs are evicted without holding the lock, so that serving the
+	// cache is not held up by the storage
+	for _, entry := range victims {
+		dcontext.GetLogger(cl.ctx).Infof("Evicting %s (%d bytes) from the proxy cache", entry.Digest, entry.Size)
+		if err := cl.evict(cl.ctx, entry.Digest, entry.Repositories); err != nil {
+			dcontext.GetLogger(cl.ctx).Errorf("Error evicting %s from the proxy cache: %s", entry.Digest, err)
+			continue
+		}
+		proxyMetrics.CacheEviction(entry.Size)
+	}
+}
+
+// saveState persists the index if it changed.
+func (cl *cacheLimiter) saveState() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if !cl.dirty {
+		return
+	}
+
+	entries := make([]*cacheEntry, 0, len(cl.entries))
+	for e := cl.lru.Front(); e != nil; e = e.Next() {
+		entries = append(entries, e.Value.(*cacheEntry))
+	}
+	p, err := json.Marshal(entries)
+	if err == nil {
+		err = cl.driver.PutContent(cl.ctx, cl.pathToStateFile, p)
+	}
+	if err != nil {
+		dcontext.GetLogger(cl.ctx).Errorf("Error writing proxy cache state: %s", err)
+		return
+	}
+	cl.dirty = false
+}
+
+// readState loads the persisted index, returning whether there was one.
+func (cl *cacheLimiter) readState() (bool, error) {
+	p, err := cl.driver.GetContent(cl.ctx, cl.pathToStateFile)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var entries []*cacheEntry
+	if err := json.Unmarshal(p, &entries); err != nil {
+		return false, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastAccess.After(entries[j].LastAccess)
+	})
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, entry := range entries {
+		if _, ok := cl.entries[entry.Digest]; ok {
+			continue
+		}
+		entry.element = cl.lru.PushBack(entry)
+		cl.entries[entry.Digest] = entry
+		cl.size += entry.Size
+	}
+	proxyMetrics.CacheSize(cl.size)
+	return true, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
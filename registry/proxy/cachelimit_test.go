@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+type evictions struct {
+	sync.Mutex
+	evicted []digest.Digest
+	repos   map[digest.Digest][]string
+}
+
+func (e *evictions) evict(ctx context.Context, dgst digest.Digest, repositories []string) error {
+	e.Lock()
+	defer e.Unlock()
+	e.evicted = append(e.evicted, dgst)
+	e.repos[dgst] = repositories
+	return nil
+}
+
+func testDigest(s string) digest.Digest {
+	return digest.FromString(s)
+}
+
+func TestCacheLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	e := &evictions{repos: make(map[digest.Digest][]string)}
+	cl := newCacheLimiter(ctx, inmemory.New(), "/state.json", 100, e.evict)
+
+	a, b, c, d := testDigest("a"), testDigest("b"), testDigest("c"), testDigest("d")
+	cl.added("foo/bar", a, 40)
+	cl.added("foo/baz", a, 40)
+	cl.added("foo/bar", b, 40)
+	if len(e.evicted) != 0 {
+		t.Fatalf("unexpected evictions under the limit: %v", e.evicted)
+	}
+
+	// a becomes the most recently used, so b goes first
+	cl.accessed(a)
+	cl.added("foo/bar", c, 40)
+	if !reflect.DeepEqual(e.evicted, []digest.Digest{b}) {
+		t.Fatalf("unexpected evictions: %v", e.evicted)
+	}
+	if cl.size != 80 {
+		t.Fatalf("unexpected cache size: %d", cl.size)
+	}
+
+	cl.added("foo/bar", d, 60)
+	if !reflect.DeepEqual(e.evicted, []digest.Digest{b, a}) {
+		t.Fatalf("unexpected evictions: %v", e.evicted)
+	}
+	if !reflect.DeepEqual(e.repos[a], []string{"foo/bar", "foo/baz"}) {
+		t.Fatalf("unexpected repositories evicted for %s: %v", a, e.repos[a])
+	}
+	if cl.size != 100 {
+		t.Fatalf("unexpected cache size: %d", cl.size)
+	}
+}
+
+func TestCacheLimiterSparesNewBlob(t *testing.T) {
+	ctx := context.Background()
+	e := &evictions{repos: make(map[digest.Digest][]string)}
+	cl := newCacheLimiter(ctx, inmemory.New(), "/state.json", 100, e.evict)
+
+	a, b := testDigest("a"), testDigest("b")
+	cl.added("foo/bar", a, 50)
+	cl.added("foo/bar", b, 150)
+	if !reflect.DeepEqual(e.evicted, []digest.Digest{a}) {
+		t.Fatalf("unexpected evictions: %v", e.evicted)
+	}
+	if _, ok := cl.entries[b]; !ok {
+		t.Fatalf("blob larger than the limit was evicted")
+	}
+}
+
+func TestCacheLimiterRemoved(t *testing.T) {
+	ctx := context.Background()
+	e := &evictions{repos: make(map[digest.Digest][]string)}
+	cl := newCacheLimiter(ctx, inmemory.New(), "/state.json", 100, e.evict)
+
+	a, b := testDigest("a"), testDigest("b")
+	cl.added("foo/bar", a, 60)
+	cl.removed(a)
+	cl.added("foo/bar", b, 60)
+	if len(e.evicted) != 0 {
+		t.Fatalf("unexpected evictions: %v", e.evicted)
+	}
+	if cl.size != 60 {
+		t.Fatalf("unexpected cache size: %d", cl.size)
+	}
+}
+
+func TestCacheLimiterState(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	e := &evictions{repos: make(map[digest.Digest][]string)}
+	cl := newCacheLimiter(ctx, d, "/state.json", 100, e.evict)
+
+	a, b, c := testDigest("a"), testDigest("b"), testDigest("c")
+	cl.added("foo/bar", a, 40)
+	cl.added("foo/bar", b, 40)
+	cl.accessed(a)
+	cl.saveState()
+
+	restored := newCacheLimiter(ctx, d, "/state.json", 100, e.evict)
+	found, err := restored.readState()
+	if err != nil {
+		t.Fatalf("error reading state: %v", err)
+	}
+	if !found {
+		t.Fatalf("state not found")
+	}
+	if restored.size != 80 {
+		t.Fatalf("unexpected cache size: %d", restored.size)
+	}
+
+	restored.added("foo/bar", c, 40)
+	if !reflect.DeepEqual(e.evicted, []digest.Digest{b}) {
+		t.Fatalf("unexpected evictions: %v", e.evicted)
+	}
+}
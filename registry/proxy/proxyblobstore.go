@@ -21,6 +21,7 @@ type proxyBlobStore struct {
 	remoteStore    distribution.BlobService
 	scheduler      *scheduler.TTLExpirationScheduler
 	ttl            *time.Duration
+	limiter        *cacheLimiter
 	repositoryName reference.Named
 	authChallenger authChallenger
 }
@@ -76,6 +77,9 @@ func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter
 	}
 
 	proxyMetrics.BlobPush(uint64(localDesc.Size))
+	if pbs.limiter != nil {
+		pbs.limiter.accessed(dgst)
+	}
 	return true, pbs.localStore.ServeBlob(ctx, w, r, dgst)
 }
 
@@ -105,6 +109,9 @@ func (pbs *proxyBlobStore) storeLocal(ctx context.Context, dgst digest.Digest) e
 		return err
 	}
 
+	if pbs.limiter != nil {
+		pbs.limiter.added(pbs.repositoryName.Name(), dgst, desc.Size)
+	}
 	return nil
 }
 
@@ -183,6 +190,9 @@ func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distri
 func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
 	blob, err := pbs.localStore.Get(ctx, dgst)
 	if err == nil {
+		if pbs.limiter != nil {
+			pbs.limiter.accessed(dgst)
+		}
 		return blob, nil
 	}
 
@@ -195,10 +205,13 @@ func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte,
 		return []byte{}, err
 	}
 
-	_, err = pbs.localStore.Put(ctx, "", blob)
+	desc, err := pbs.localStore.Put(ctx, "", blob)
 	if err != nil {
 		return []byte{}, err
 	}
+	if pbs.limiter != nil {
+		pbs.limiter.added(pbs.repositoryName.Name(), desc.Digest, desc.Size)
+	}
 	return blob, nil
 }
 
@@ -3,6 +3,20 @@ package proxy
 import (
 	"expvar"
 	"sync/atomic"
+
+	prometheus "github.com/docker/distribution/metrics"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// cacheSizeGauge measures the total size of the blobs in the cache
+	cacheSizeGauge = prometheus.ProxyNamespace.NewGauge("cache_size", "The total size of the blobs in the pull through cache", metrics.Bytes)
+	// cacheLimitGauge measures the size the cache is kept under
+	cacheLimitGauge = prometheus.ProxyNamespace.NewGauge("cache_limit", "The size above which blobs are evicted from the pull through cache", metrics.Bytes)
+	// cacheEvictionsCounter counts the blobs evicted from the cache
+	cacheEvictionsCounter = prometheus.ProxyNamespace.NewCounter("cache_evictions", "The number of blobs evicted from the pull through cache")
+	// cacheEvictedBytesCounter counts the bytes evicted from the cache
+	cacheEvictedBytesCounter = prometheus.ProxyNamespace.NewCounter("cache_evicted_bytes", "The number of bytes evicted from the pull through cache")
 )
 
 // Metrics is used to hold metric counters
@@ -15,9 +29,18 @@ type Metrics struct {
 	BytesPushed uint64
 }
 
+// CacheMetrics is used to hold metrics related to the size of the cache
+type CacheMetrics struct {
+	Size         int64
+	Limit        int64
+	Evictions    uint64
+	EvictedBytes uint64
+}
+
 type proxyMetricsCollector struct {
 	blobMetrics     Metrics
 	manifestMetrics Metrics
+	cacheMetrics    CacheMetrics
 }
 
 // BlobPull tracks metrics about blobs pulled into the cache
@@ -46,6 +69,26 @@ func (pmc *proxyMetricsCollector) ManifestPush(bytesPushed uint64) {
 	atomic.AddUint64(&pmc.manifestMetrics.BytesPushed, bytesPushed)
 }
 
+// CacheSize tracks the total size of the blobs in the cache
+func (pmc *proxyMetricsCollector) CacheSize(size int64) {
+	atomic.StoreInt64(&pmc.cacheMetrics.Size, size)
+	cacheSizeGauge.Set(float64(size))
+}
+
+// CacheLimit tracks the size above which blobs are evicted from the cache
+func (pmc *proxyMetricsCollector) CacheLimit(limit int64) {
+	atomic.StoreInt64(&pmc.cacheMetrics.Limit, limit)
+	cacheLimitGauge.Set(float64(limit))
+}
+
+// CacheEviction tracks metrics about blobs evicted from the cache
+func (pmc *proxyMetricsCollector) CacheEviction(bytesEvicted int64) {
+	atomic.AddUint64(&pmc.cacheMetrics.Evictions, 1)
+	atomic.AddUint64(&pmc.cacheMetrics.EvictedBytes, uint64(bytesEvicted))
+	cacheEvictionsCounter.Inc(1)
+	cacheEvictedBytesCounter.Inc(float64(bytesEvicted))
+}
+
 // proxyMetrics tracks metrics about the proxy cache.  This is
 // kept globally and made available via expvar.
 var proxyMetrics = &proxyMetricsCollector{}
@@ -70,4 +113,16 @@ func init() {
 	pm.(*expvar.Map).Set("manifests", expvar.Func(func() interface{} {
 		return proxyMetrics.manifestMetrics
 	}))
+
+	pm.(*expvar.Map).Set("cache", expvar.Func(func() interface{} {
+		return CacheMetrics{
+			Size:         atomic.LoadInt64(&proxyMetrics.cacheMetrics.Size),
+			Limit:        atomic.LoadInt64(&proxyMetrics.cacheMetrics.Limit),
+			Evictions:    atomic.LoadUint64(&proxyMetrics.cacheMetrics.Evictions),
+			EvictedBytes: atomic.LoadUint64(&proxyMetrics.cacheMetrics.EvictedBytes),
+		}
+	}))
+
+	// register prometheus metrics
+	metrics.Register(prometheus.ProxyNamespace)
 }
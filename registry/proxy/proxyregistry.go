@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,28 +21,87 @@ import (
 	"github.com/docker/distribution/registry/proxy/scheduler"
 	"github.com/docker/distribution/registry/storage"
 	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 var repositoryTTL = 24 * 7 * time.Hour
 
-// proxyingRegistry fetches content from a remote registry and caches it locally
+// proxyingRegistry fetches content from remote registries and caches it locally
 type proxyingRegistry struct {
-	embedded       distribution.Namespace // provides local registry functionality
-	scheduler      *scheduler.TTLExpirationScheduler
-	ttl            *time.Duration
+	embedded  distribution.Namespace // provides local registry functionality
+	scheduler *scheduler.TTLExpirationScheduler
+	ttl       *time.Duration
+	limiter   *cacheLimiter
+	remotes   []remote // sorted by decreasing length of namespace
+}
+
+// remote is a registry the repositories of a namespace are pulled from.
+type remote struct {
+	// namespace is the prefix of the local names of the repositories,
+	// which is removed from their names on the remote. It is empty for the
+	// remote of the repositories outside of any other namespace.
+	namespace      string
 	remoteURL      url.URL
 	authChallenger authChallenger
 }
 
 // NewRegistryPullThroughCache creates a registry acting as a pull through cache
 func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
-	remoteURL, err := url.Parse(config.RemoteURL)
-	if err != nil {
-		return nil, err
+	var remotes []remote
+	for _, r := range config.Remotes {
+		namespace := strings.TrimSuffix(strings.TrimSuffix(r.Namespace, "*"), "/")
+		if namespace == "" {
+			return nil, fmt.Errorf("proxy: no namespace configured for remote %s", r.RemoteURL)
+		}
+		rem, err := newRemote(namespace, r.RemoteURL, r.Username, r.Password)
+		if err != nil {
+			return nil, err
+		}
+		remotes = append(remotes, rem)
+	}
+	if config.RemoteURL != "" {
+		rem, err := newRemote("", config.RemoteURL, config.Username, config.Password)
+		if err != nil {
+			return nil, err
+		}
+		remotes = append(remotes, rem)
 	}
+	if len(remotes) == 0 {
+		return nil, fmt.Errorf("proxy: no remote configured")
+	}
+	sort.SliceStable(remotes, func(i, j int) bool {
+		return len(remotes[i].namespace) > len(remotes[j].namespace)
+	})
 
 	v := storage.NewVacuum(ctx, driver)
 
+	var limiter *cacheLimiter
+	if config.CacheSizeLimit > 0 {
+		limiter = newCacheLimiter(ctx, driver, "/proxy-cache-state.json", config.CacheSizeLimit, func(ctx context.Context, dgst digest.Digest, repositories []string) error {
+			for _, name := range repositories {
+				named, err := reference.WithName(name)
+				if err != nil {
+					return err
+				}
+				repo, err := registry.Repository(ctx, named)
+				if err != nil {
+					return err
+				}
+
+				// Clear the repository reference and descriptor caches
+				if err := repo.Blobs(ctx).Delete(ctx, dgst); err != nil && err != distribution.ErrBlobUnknown {
+					return err
+				}
+			}
+
+			return v.RemoveBlob(dgst.String())
+		})
+		if err := limiter.start(registry.Blobs(), registry.BlobStatter()); err != nil {
+			return nil, err
+		}
+	}
+
 	var s *scheduler.TTLExpirationScheduler
 	var ttl *time.Duration
 	if config.TTL == nil {
@@ -80,6 +141,9 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 				return err
 			}
 
+			if limiter != nil {
+				limiter.removed(r.Digest())
+			}
 			return nil
 		})
 
@@ -106,21 +170,36 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 			return nil
 		})
 
-		err = s.Start()
+		err := s.Start()
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	cs, err := configureAuth(config.Username, config.Password, config.RemoteURL)
-	if err != nil {
-		return nil, err
-	}
-
 	return &proxyingRegistry{
 		embedded:  registry,
 		scheduler: s,
 		ttl:       ttl,
+		limiter:   limiter,
+		remotes:   remotes,
+	}, nil
+}
+
+// newRemote returns the remote registry at the URL, authenticating with the
+// credentials, which the repositories of the namespace are pulled from.
+func newRemote(namespace, rawURL, username, password string) (remote, error) {
+	remoteURL, err := url.Parse(rawURL)
+	if err != nil {
+		return remote{}, err
+	}
+
+	cs, err := configureAuth(username, password, rawURL)
+	if err != nil {
+		return remote{}, err
+	}
+
+	return remote{
+		namespace: namespace,
 		remoteURL: *remoteURL,
 		authChallenger: &remoteAuthChallenger{
 			remoteURL: *remoteURL,
@@ -130,6 +209,27 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 	}, nil
 }
 
+// remote returns the remote the repository is pulled from, along with the
+// name of the repository on the remote.
+func (pr *proxyingRegistry) remote(name reference.Named) (remote, reference.Named, error) {
+	for _, r := range pr.remotes {
+		if r.namespace == "" {
+			return r, name, nil
+		}
+		if !strings.HasPrefix(name.Name(), r.namespace+"/") {
+			continue
+		}
+
+		remoteName, err := reference.WithName(strings.TrimPrefix(name.Name(), r.namespace+"/"))
+		if err != nil {
+			return remote{}, nil, err
+		}
+		return r, remoteName, nil
+	}
+
+	return remote{}, nil, distribution.ErrRepositoryUnknown{Name: name.Name()}
+}
+
 func (pr *proxyingRegistry) Scope() distribution.Scope {
 	return distribution.GlobalScope
 }
@@ -139,21 +239,28 @@ func (pr *proxyingRegistry) Repositories(ctx context.Context, repos []string, la
 }
 
 func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
-	c := pr.authChallenger
+	r, remoteName, err := pr.remote(name)
+	if err != nil {
+		return nil, err
+	}
+	c := r.authChallenger
+
+	// propagate the trace context of the request to the remote registry
+	base := otelhttp.NewTransport(http.DefaultTransport)
 
 	tkopts := auth.TokenHandlerOptions{
-		Transport:   http.DefaultTransport,
+		Transport:   base,
 		Credentials: c.credentialStore(),
 		Scopes: []auth.Scope{
 			auth.RepositoryScope{
-				Repository: name.Name(),
+				Repository: remoteName.Name(),
 				Actions:    []string{"pull"},
 			},
 		},
 		Logger: dcontext.GetLogger(ctx),
 	}
 
-	tr := transport.NewTransport(http.DefaultTransport,
+	tr := transport.NewTransport(base,
 		auth.NewAuthorizer(c.challengeManager(),
 			auth.NewTokenHandlerWithOptions(tkopts)))
 
@@ -166,7 +273,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
-	remoteRepo, err := client.NewRepository(name, pr.remoteURL.String(), tr)
+	remoteRepo, err := client.NewRepository(remoteName, r.remoteURL.String(), tr)
 	if err != nil {
 		return nil, err
 	}
@@ -182,8 +289,9 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 			remoteStore:    remoteRepo.Blobs(ctx),
 			scheduler:      pr.scheduler,
 			ttl:            pr.ttl,
+			limiter:        pr.limiter,
 			repositoryName: name,
-			authChallenger: pr.authChallenger,
+			authChallenger: r.authChallenger,
 		},
 		manifests: &proxyManifestStore{
 			repositoryName:  name,
@@ -192,13 +300,13 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 			ctx:             ctx,
 			scheduler:       pr.scheduler,
 			ttl:             pr.ttl,
-			authChallenger:  pr.authChallenger,
+			authChallenger:  r.authChallenger,
 		},
 		name: name,
 		tags: &proxyTagService{
 			localTags:      localRepo.Tags(ctx),
 			remoteTags:     remoteRepo.Tags(ctx),
-			authChallenger: pr.authChallenger,
+			authChallenger: r.authChallenger,
 		},
 	}, nil
 }
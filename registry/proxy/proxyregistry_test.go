@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+// recordingRemote is a remote registry recording the paths of the requests
+// made to it, and which has no repository.
+type recordingRemote struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func newRecordingRemote(t *testing.T) *recordingRemote {
+	r := &recordingRemote{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.paths = append(r.paths, req.URL.Path)
+		r.mu.Unlock()
+
+		if req.URL.Path == "/v2/" {
+			w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *recordingRemote) requested(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProxyingRegistryRemotes(t *testing.T) {
+	ctx := context.Background()
+	dockerHub := newRecordingRemote(t)
+	quay := newRecordingRemote(t)
+	quayMirror := newRecordingRemote(t)
+	fallback := newRecordingRemote(t)
+
+	driver := inmemory.New()
+	local, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	ttl := time.Duration(0)
+	registry, err := NewRegistryPullThroughCache(ctx, local, driver, configuration.Proxy{
+		RemoteURL: fallback.URL,
+		TTL:       &ttl,
+		Remotes: []configuration.ProxyRemote{
+			{Namespace: "docker.io/*", RemoteURL: dockerHub.URL},
+			{Namespace: "quay.io", RemoteURL: quay.URL},
+			{Namespace: "quay.io/mirrored", RemoteURL: quayMirror.URL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating pull through cache: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		remote *recordingRemote
+		path   string
+	}{
+		{name: "docker.io/library/ubuntu", remote: dockerHub, path: "/v2/library/ubuntu/manifests/latest"},
+		{name: "quay.io/coreos/etcd", remote: quay, path: "/v2/coreos/etcd/manifests/latest"},
+		{name: "quay.io/mirrored/etcd", remote: quayMirror, path: "/v2/etcd/manifests/latest"},
+		{name: "docker.iox/library/ubuntu", remote: fallback, path: "/v2/docker.iox/library/ubuntu/manifests/latest"},
+	} {
+		name, err := reference.WithName(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := registry.Repository(ctx, name)
+		if err != nil {
+			t.Fatalf("error getting repository %s: %v", tc.name, err)
+		}
+		if repo.Named().Name() != tc.name {
+			t.Fatalf("unexpected repository name: %q != %q", repo.Named().Name(), tc.name)
+		}
+		if _, err := repo.Tags(ctx).Get(ctx, "latest"); err == nil {
+			t.Fatalf("expected an error getting an unknown tag of %s", tc.name)
+		}
+		if !tc.remote.requested(tc.path) {
+			t.Fatalf("expected %s to be pulled from %s as %s", tc.name, tc.remote.URL, tc.path)
+		}
+	}
+}
+
+func TestProxyingRegistryUnknownNamespace(t *testing.T) {
+	ctx := context.Background()
+	dockerHub := newRecordingRemote(t)
+
+	driver := inmemory.New()
+	local, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	ttl := time.Duration(0)
+	registry, err := NewRegistryPullThroughCache(ctx, local, driver, configuration.Proxy{
+		TTL: &ttl,
+		Remotes: []configuration.ProxyRemote{
+			{Namespace: "docker.io", RemoteURL: dockerHub.URL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating pull through cache: %v", err)
+	}
+
+	name, _ := reference.WithName("quay.io/coreos/etcd")
+	if _, err := registry.Repository(ctx, name); err == nil {
+		t.Fatal("expected an error getting a repository outside of the namespaces")
+	} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("unexpected error getting a repository outside of the namespaces: %v", err)
+	}
+}
@@ -1,27 +1,34 @@
 package registry
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	logrus_bugsnag "github.com/Shopify/logrus-bugsnag"
 	logstash "github.com/bshuster-repo/logrus-logstash-hook"
 	"github.com/bugsnag/bugsnag-go"
-	"github.com/docker/go-metrics"
-	gorhandlers "github.com/gorilla/handlers"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/yvasiyarov/gorelic"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/docker/distribution/configuration"
 	dcontext "github.com/docker/distribution/context"
@@ -78,9 +85,6 @@ var tlsVersions = map[string]uint16{
 	"tls1.3": tls.VersionTLS13,
 }
 
-// this channel gets notified when process receives signal. It is global to ease unit testing
-var quit = make(chan os.Signal, 1)
-
 // HandlerFunc defines an http middleware
 type HandlerFunc func(config *configuration.Configuration, handler http.Handler) http.Handler
 
@@ -91,9 +95,13 @@ func RegisterHandler(handlerFunc HandlerFunc) {
 	handlerMiddlewares = append(handlerMiddlewares, handlerFunc)
 }
 
+// serveDryRun makes ServeCmd check the configuration and its dependencies
+// and exit instead of serving requests.
+var serveDryRun bool
+
 // ServeCmd is a cobra command for running the registry.
 var ServeCmd = &cobra.Command{
-	Use:   "serve <config>",
+	Use:   "serve <config> [<overlay>...]",
 	Short: "`serve` stores and distributes Docker images",
 	Long:  "`serve` stores and distributes Docker images.",
 	Run: func(cmd *cobra.Command, args []string) {
@@ -106,12 +114,26 @@ var ServeCmd = &cobra.Command{
 			cmd.Usage()
 			os.Exit(1)
 		}
+		if serveDryRun {
+			if err := preflight(ctx, config, os.Stdout); err != nil {
+				logrus.Fatalln(err)
+			}
+			return
+		}
+
 		registry, err := NewRegistry(ctx, config)
 		if err != nil {
 			logrus.Fatalln(err)
 		}
+		registry.setDefaultLogger()
 
-		configureDebugServer(config)
+		if err = configureDebugServer(config, registry.health, registry.MetricsGatherer()); err != nil {
+			logrus.Fatalln(err)
+		}
+
+		if err = configureGRPCServer(config, registry.app); err != nil {
+			logrus.Fatalln(err)
+		}
 
 		if err = registry.ListenAndServe(); err != nil {
 			logrus.Fatalln(err)
@@ -119,13 +141,38 @@ var ServeCmd = &cobra.Command{
 	},
 }
 
-// A Registry represents a complete instance of the registry.
+// A Registry represents a complete instance of the registry. Registries do
+// not share health checks, loggers or signal handling, so that multiple
+// registries can be embedded in one process.
 //
 // TODO(aaronl): It might make sense for Registry to become an interface.
 type Registry struct {
-	config *configuration.Configuration
-	app    *handlers.App
-	server *http.Server
+	config         *configuration.Configuration
+	app            *handlers.App
+	server         *http.Server
+	trustedProxies []*net.IPNet
+
+	// health holds the health checks of the registry.
+	health *health.Registry
+
+	// quit gets notified when the process receives a signal to stop the
+	// server gracefully.
+	quit chan os.Signal
+
+	// stopped is closed when the registry is shut down.
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	mu   sync.Mutex // guards addr
+	addr net.Addr
+
+	// shutdownTracing flushes and stops the export of traces.
+	shutdownTracing func(context.Context) error
+
+	// shutdownMetrics flushes and stops the export of OTLP metrics.
+	shutdownMetrics func(context.Context) error
+
+	telemetryOnce sync.Once
 }
 
 // NewRegistry creates a new registry from a context and configuration struct.
@@ -142,16 +189,49 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	// with uuid generation under low entropy.
 	uuid.Loggerf = dcontext.GetLogger(ctx).Warnf
 
+	trustedProxies, err := listener.ParseNetworks(config.HTTP.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing trusted proxies: %v", err)
+	}
+
+	shutdownTracing, err := configureTracing(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring tracing: %v", err)
+	}
+
 	app := handlers.NewApp(ctx, config)
-	// TODO(aaronl): The global scope of the health checks means NewRegistry
-	// can only be called once per process.
-	app.RegisterHealthChecks()
+
+	shutdownMetrics, err := configureOTLPMetrics(ctx, config, app.MetricsGatherer())
+	if err != nil {
+		return nil, fmt.Errorf("error configuring OTLP metrics: %v", err)
+	}
+
+	healthRegistry := health.NewRegistry()
+	app.RegisterHealthChecks(healthRegistry)
 	handler := configureReporting(app)
+	handler = limitConcurrency(config, handler)
 	handler = alive("/", handler)
-	handler = health.Handler(handler)
+	handler = healthRegistry.Handler(handler)
+	handler = serveMetrics(config, app.MetricsGatherer(), handler)
+	handler, err = serveAdmin(config, app, handler)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring admin API: %v", err)
+	}
+	handler, err = serveUI(config, app, handler)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring web UI: %v", err)
+	}
+	handler = applyRouteTimeouts(config, handler)
+	handler = otelhttp.NewHandler(handler, "registry")
+	handler = trustForwardedHeaders(trustedProxies, handler)
 	handler = panicHandler(handler)
-	if !config.Log.AccessLog.Disabled {
-		handler = gorhandlers.CombinedLoggingHandler(os.Stdout, handler)
+	handler, err = configureCORS(config, handler)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring CORS: %v", err)
+	}
+	handler, err = configureAccessLog(config, handler)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring access log: %v", err)
 	}
 
 	for _, applyHandlerMiddleware := range handlerMiddlewares {
@@ -159,16 +239,54 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	}
 
 	server := &http.Server{
-		Handler: handler,
+		Handler:           handler,
+		ReadHeaderTimeout: config.HTTP.Timeouts.ReadHeader,
+		IdleTimeout:       config.HTTP.Timeouts.Idle,
+	}
+	if hasRouteTimeouts(config) {
+		server.ConnContext = withConn
 	}
 
 	return &Registry{
-		app:    app,
-		config: config,
-		server: server,
+		app:             app,
+		config:          config,
+		server:          server,
+		trustedProxies:  trustedProxies,
+		health:          healthRegistry,
+		quit:            make(chan os.Signal, 1),
+		stopped:         make(chan struct{}),
+		shutdownTracing: shutdownTracing,
+		shutdownMetrics: shutdownMetrics,
 	}, nil
 }
 
+// Handler returns the handler serving the registry API, wrapped with all
+// configured middlewares. It can be mounted in the HTTP server of an
+// embedding application instead of calling Serve.
+func (registry *Registry) Handler() http.Handler {
+	return registry.server.Handler
+}
+
+// MetricsGatherer returns the gatherer of the Prometheus metrics of the
+// registry, for embedding applications which expose metrics themselves.
+func (registry *Registry) MetricsGatherer() prometheus.Gatherer {
+	return registry.app.MetricsGatherer()
+}
+
+// setDefaultLogger makes the logger of the registry the default logger of
+// the process, used for logging without a context.
+func (registry *Registry) setDefaultLogger() {
+	logger := dcontext.GetLogger(registry.app)
+	if entry, ok := logger.(*logrus.Entry); ok {
+		std := logrus.StandardLogger()
+		std.SetOutput(entry.Logger.Out)
+		std.SetLevel(entry.Logger.GetLevel())
+		std.SetFormatter(entry.Logger.Formatter)
+		std.SetReportCaller(entry.Logger.ReportCaller)
+	}
+	dcontext.SetDefaultLogger(logger)
+}
+
 // takes a list of cipher suites and converts it to a list of respective tls constants
 // if an empty list is provided, then the defaults will be used
 func getCipherSuites(names []string) ([]uint16, error) {
@@ -206,22 +324,168 @@ func setDirectoryURL(directoryurl string) *acme.Client {
 	return nil
 }
 
-// ListenAndServe runs the registry's HTTP server.
+// ListenAndServe runs the registry's HTTP server on the configured address.
 func (registry *Registry) ListenAndServe() error {
+	ln, err := listener.NewListener(registry.config.HTTP.Net, registry.config.HTTP.Addr)
+	if err != nil {
+		return err
+	}
+
+	return registry.Serve(ln)
+}
+
+// Serve runs the registry's HTTP server on the listener, accepting the PROXY
+// protocol and TLS connections as configured.
+func (registry *Registry) Serve(ln net.Listener) error {
 	config := registry.config
 
-	ln, err := listener.NewListener(config.HTTP.Net, config.HTTP.Addr)
+	defer registry.shutdownTelemetry()
+
+	ln, err := registry.listen(ln)
+	if err != nil {
+		return err
+	}
+
+	stopSignals := registry.handleReadOnlySignals()
+	defer stopSignals()
+
+	if config.HTTP.DrainTimeout == 0 {
+		return registry.server.Serve(ln)
+	}
+
+	// setup channel to get notified on SIGTERM signal
+	signal.Notify(registry.quit, syscall.SIGTERM)
+	defer signal.Stop(registry.quit)
+	serveErr := make(chan error)
+
+	// Start serving in goroutine and listen for stop signal in main thread
+	go func() {
+		serveErr <- registry.server.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-registry.quit:
+		dcontext.GetLogger(registry.app).Info("stopping server gracefully. Draining connections for ", config.HTTP.DrainTimeout)
+		// shutdown the server with a grace period of configured timeout
+		c, cancel := context.WithTimeout(context.Background(), config.HTTP.DrainTimeout)
+		defer cancel()
+		return registry.Shutdown(c)
+	}
+}
+
+// SetReadOnly enables or disables the read-only maintenance mode of the
+// registry, in which pushes and deletes are rejected.
+func (registry *Registry) SetReadOnly(enabled bool) {
+	registry.app.SetReadOnly(enabled)
+	dcontext.GetLogger(registry.app).Infof("read-only mode set to %t", enabled)
+}
+
+// ToggleReadOnly enables the read-only maintenance mode of the registry if
+// it is disabled, and disables it otherwise.
+func (registry *Registry) ToggleReadOnly() {
+	enabled := registry.app.ToggleReadOnly()
+	dcontext.GetLogger(registry.app).Infof("read-only mode set to %t", enabled)
+}
+
+// Start starts serving the registry on the configured address in the
+// background, returning once the registry is listening. The registry is shut
+// down when ctx is done, draining connections for the configured drain
+// timeout, or when Shutdown is called.
+func (registry *Registry) Start(ctx context.Context) error {
+	ln, err := listener.NewListener(registry.config.HTTP.Net, registry.config.HTTP.Addr)
 	if err != nil {
 		return err
 	}
 
+	ln, err = registry.listen(ln)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	go func() {
+		if err := registry.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			dcontext.GetLogger(registry.app).Errorf("error serving: %v", err)
+		}
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-registry.stopped:
+			return
+		}
+
+		c, cancel := context.WithTimeout(context.Background(), registry.config.HTTP.DrainTimeout)
+		defer cancel()
+		if err := registry.Shutdown(c); err != nil {
+			dcontext.GetLogger(registry.app).Errorf("error shutting down: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown gracefully stops the registry, waiting for active connections to
+// finish until ctx is done, and flushes its notifications, traces and
+// metrics.
+func (registry *Registry) Shutdown(ctx context.Context) error {
+	registry.stopOnce.Do(func() { close(registry.stopped) })
+
+	err := registry.server.Shutdown(ctx)
+	// the background tasks may use the notifications and the metadata
+	// index, so they are stopped first
+	registry.app.Stop()
+	if cerr := registry.app.CloseEvents(); cerr != nil {
+		dcontext.GetLogger(ctx).Errorf("error closing notifications: %v", cerr)
+	}
+	if cerr := registry.app.CloseMetadataIndex(); cerr != nil {
+		dcontext.GetLogger(ctx).Errorf("error closing metadata index: %v", cerr)
+	}
+	registry.shutdownTelemetry()
+	return err
+}
+
+// Addr returns the address the registry is listening on, or nil if it was
+// not started.
+func (registry *Registry) Addr() net.Addr {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return registry.addr
+}
+
+// shutdownTelemetry flushes and stops the export of traces and metrics, once.
+func (registry *Registry) shutdownTelemetry() {
+	registry.telemetryOnce.Do(func() {
+		if err := registry.shutdownTracing(context.Background()); err != nil {
+			dcontext.GetLogger(registry.app).Errorf("error shutting down tracing: %v", err)
+		}
+		if err := registry.shutdownMetrics(context.Background()); err != nil {
+			dcontext.GetLogger(registry.app).Errorf("error shutting down OTLP metrics: %v", err)
+		}
+	})
+}
+
+// listen wraps the listener to accept the PROXY protocol and TLS connections
+// as configured.
+func (registry *Registry) listen(ln net.Listener) (net.Listener, error) {
+	config := registry.config
+
+	if config.HTTP.ProxyProtocol.Enabled {
+		ln = listener.NewProxyProtocolListener(ln, registry.trustedProxies, config.HTTP.ProxyProtocol.Timeout)
+		dcontext.GetLogger(registry.app).Info("accepting PROXY protocol headers")
+	}
+
 	if config.HTTP.TLS.Certificate != "" || config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
+		var err error
 		if config.HTTP.TLS.MinimumTLS == "" {
 			config.HTTP.TLS.MinimumTLS = defaultTLSVersionStr
 		}
 		tlsMinVersion, ok := tlsVersions[config.HTTP.TLS.MinimumTLS]
 		if !ok {
-			return fmt.Errorf("unknown minimum TLS level '%s' specified for http.tls.minimumtls", config.HTTP.TLS.MinimumTLS)
+			return nil, fmt.Errorf("unknown minimum TLS level '%s' specified for http.tls.minimumtls", config.HTTP.TLS.MinimumTLS)
 		}
 		dcontext.GetLogger(registry.app).Infof("restricting TLS version to %s or higher", config.HTTP.TLS.MinimumTLS)
 
@@ -233,7 +497,7 @@ func (registry *Registry) ListenAndServe() error {
 		} else {
 			tlsCipherSuites, err = getCipherSuites(config.HTTP.TLS.CipherSuites)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			dcontext.GetLogger(registry.app).Infof("restricting TLS cipher suites to: %s", strings.Join(getCipherSuiteNames(tlsCipherSuites), ","))
 		}
@@ -247,7 +511,7 @@ func (registry *Registry) ListenAndServe() error {
 
 		if config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
 			if config.HTTP.TLS.Certificate != "" {
-				return fmt.Errorf("cannot specify both certificate and Let's Encrypt")
+				return nil, fmt.Errorf("cannot specify both certificate and Let's Encrypt")
 			}
 			m := &autocert.Manager{
 				HostPolicy: autocert.HostWhitelist(config.HTTP.TLS.LetsEncrypt.Hosts...),
@@ -259,11 +523,17 @@ func (registry *Registry) ListenAndServe() error {
 			tlsConf.GetCertificate = m.GetCertificate
 			tlsConf.NextProtos = append(tlsConf.NextProtos, acme.ALPNProto)
 		} else {
-			tlsConf.Certificates = make([]tls.Certificate, 1)
-			tlsConf.Certificates[0], err = tls.LoadX509KeyPair(config.HTTP.TLS.Certificate, config.HTTP.TLS.Key)
+			certs, err := newCertificateReloader(config.HTTP.TLS.Certificate, config.HTTP.TLS.Key)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			tlsConf.GetCertificate = certs.GetCertificate
+
+			interval := config.HTTP.TLS.ReloadInterval
+			if interval <= 0 {
+				interval = defaultCertificateReloadInterval
+			}
+			go registry.watchCertificate(certs, interval)
 		}
 
 		if len(config.HTTP.TLS.ClientCAs) != 0 {
@@ -272,11 +542,11 @@ func (registry *Registry) ListenAndServe() error {
 			for _, ca := range config.HTTP.TLS.ClientCAs {
 				caPem, err := os.ReadFile(ca)
 				if err != nil {
-					return err
+					return nil, err
 				}
 
 				if ok := pool.AppendCertsFromPEM(caPem); !ok {
-					return fmt.Errorf("could not add CA to pool")
+					return nil, fmt.Errorf("could not add CA to pool")
 				}
 			}
 
@@ -294,52 +564,68 @@ func (registry *Registry) ListenAndServe() error {
 		dcontext.GetLogger(registry.app).Infof("listening on %v", ln.Addr())
 	}
 
-	if config.HTTP.DrainTimeout == 0 {
-		return registry.server.Serve(ln)
+	registry.mu.Lock()
+	registry.addr = ln.Addr()
+	registry.mu.Unlock()
+
+	return ln, nil
+}
+
+func configureDebugServer(config *configuration.Configuration, healthRegistry *health.Registry, gatherer prometheus.Gatherer) error {
+	if config.HTTP.Debug.Addr == "" {
+		return nil
 	}
 
-	// setup channel to get notified on SIGTERM signal
-	signal.Notify(quit, syscall.SIGTERM)
-	serveErr := make(chan error)
+	server, err := newDebugServer(config, debugHandler(config, healthRegistry, gatherer))
+	if err != nil {
+		return fmt.Errorf("error configuring debug server: %v", err)
+	}
 
-	// Start serving in goroutine and listen for stop signal in main thread
 	go func() {
-		serveErr <- registry.server.Serve(ln)
+		logrus.Infof("debug server listening %v", server.Addr)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS(config.HTTP.Debug.TLS.Certificate, config.HTTP.Debug.TLS.Key)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil {
+			logrus.Fatalf("error listening on debug interface: %v", err)
+		}
 	}()
-
-	select {
-	case err := <-serveErr:
-		return err
-	case <-quit:
-		dcontext.GetLogger(registry.app).Info("stopping server gracefully. Draining connections for ", config.HTTP.DrainTimeout)
-		// shutdown the server with a grace period of configured timeout
-		c, cancel := context.WithTimeout(context.Background(), config.HTTP.DrainTimeout)
-		defer cancel()
-		return registry.server.Shutdown(c)
-	}
+	return nil
 }
 
-func configureDebugServer(config *configuration.Configuration) {
-	if config.HTTP.Debug.Addr != "" {
-		go func(addr string) {
-			logrus.Infof("debug server listening %v", addr)
-			if err := http.ListenAndServe(addr, nil); err != nil {
-				logrus.Fatalf("error listening on debug interface: %v", err)
-			}
-		}(config.HTTP.Debug.Addr)
-		configurePrometheus(config)
+// configureGRPCServer serves the gRPC API of app on its own listener, if an
+// address is configured.
+func configureGRPCServer(config *configuration.Configuration, app *handlers.App) error {
+	if config.GRPC.Addr == "" {
+		return nil
 	}
-}
 
-func configurePrometheus(config *configuration.Configuration) {
-	if config.HTTP.Debug.Prometheus.Enabled {
-		path := config.HTTP.Debug.Prometheus.Path
-		if path == "" {
-			path = "/metrics"
+	var opts []grpc.ServerOption
+	if config.GRPC.TLS.Certificate != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.GRPC.TLS.Certificate, config.GRPC.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("error configuring gRPC TLS: %v", err)
 		}
-		logrus.Info("providing prometheus metrics on ", path)
-		http.Handle(path, metrics.Handler())
+		opts = append(opts, grpc.Creds(creds))
 	}
+	server := grpc.NewServer(opts...)
+	app.RegisterGRPC(server)
+
+	ln, err := net.Listen("tcp", config.GRPC.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening on gRPC interface: %v", err)
+	}
+
+	go func() {
+		logrus.Infof("gRPC server listening %v", ln.Addr())
+		if err := server.Serve(ln); err != nil {
+			logrus.Fatalf("error serving gRPC: %v", err)
+		}
+	}()
+	return nil
 }
 
 func configureReporting(app *handlers.App) http.Handler {
@@ -366,24 +652,27 @@ func configureReporting(app *handlers.App) http.Handler {
 }
 
 // configureLogging prepares the context with a logger using the
-// configuration.
+// configuration. The logger is specific to the context, so that registries
+// embedded in the same process can log differently.
 func configureLogging(ctx context.Context, config *configuration.Configuration) (context.Context, error) {
-	logrus.SetLevel(logLevel(config.Log.Level))
-	logrus.SetReportCaller(config.Log.ReportCaller)
+	logger := logrus.New()
+	logger.SetOutput(logOutput(config.Log.Output, os.Stderr))
+	logger.SetLevel(logLevel(config.Log.Level))
+	logger.SetReportCaller(config.Log.ReportCaller)
 
 	formatter := config.Log.Formatter
 	switch formatter {
 	case "json":
-		logrus.SetFormatter(&logrus.JSONFormatter{
+		logger.SetFormatter(&logrus.JSONFormatter{
 			TimestampFormat:   time.RFC3339Nano,
 			DisableHTMLEscape: true,
 		})
 	case "text":
-		logrus.SetFormatter(&logrus.TextFormatter{
+		logger.SetFormatter(&logrus.TextFormatter{
 			TimestampFormat: time.RFC3339Nano,
 		})
 	case "logstash":
-		logrus.SetFormatter(&logstash.LogstashFormatter{
+		logger.SetFormatter(&logstash.LogstashFormatter{
 			Formatter: &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano},
 		})
 	default:
@@ -392,7 +681,8 @@ func configureLogging(ctx context.Context, config *configuration.Configuration)
 		}
 	}
 
-	logrus.Debugf("using %q logging formatter", formatter)
+	logger.Debugf("using %q logging formatter", formatter)
+	ctx = dcontext.WithLogger(ctx, logrus.NewEntry(logger).WithField("go.version", runtime.Version()))
 	if len(config.Log.Fields) > 0 {
 		// build up the static fields, if present.
 		var fields []interface{}
@@ -404,7 +694,6 @@ func configureLogging(ctx context.Context, config *configuration.Configuration)
 		ctx = dcontext.WithLogger(ctx, dcontext.GetLogger(ctx, fields...))
 	}
 
-	dcontext.SetDefaultLogger(dcontext.GetLogger(ctx))
 	return ctx, nil
 }
 
@@ -476,33 +765,82 @@ func alive(path string, handler http.Handler) http.Handler {
 }
 
 func resolveConfiguration(args []string) (*configuration.Configuration, error) {
-	var configurationPath string
-
-	if len(args) > 0 {
-		configurationPath = args[0]
-	} else if os.Getenv("REGISTRY_CONFIGURATION_PATH") != "" {
-		configurationPath = os.Getenv("REGISTRY_CONFIGURATION_PATH")
+	configurationPaths := args
+	if len(configurationPaths) == 0 && os.Getenv("REGISTRY_CONFIGURATION_PATH") != "" {
+		configurationPaths = []string{os.Getenv("REGISTRY_CONFIGURATION_PATH")}
 	}
 
-	if configurationPath == "" {
+	if len(configurationPaths) == 0 {
 		return nil, fmt.Errorf("configuration path unspecified")
 	}
 
-	fp, err := os.Open(configurationPath)
+	files, err := configurationFiles(configurationPaths)
 	if err != nil {
 		return nil, err
 	}
 
-	defer fp.Close()
+	var documents [][]byte
+	for _, file := range files {
+		in, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, in)
+	}
 
-	config, err := configuration.Parse(fp)
+	// configuration overlays are deep-merged in order
+	in := documents[0]
+	if len(documents) > 1 {
+		in, err = configuration.Merge(documents...)
+		if err != nil {
+			return nil, fmt.Errorf("error merging %s: %v", strings.Join(files, ", "), err)
+		}
+	}
+
+	config, err := configuration.Parse(bytes.NewReader(in))
 	if err != nil {
-		return nil, fmt.Errorf("error parsing %s: %v", configurationPath, err)
+		return nil, fmt.Errorf("error parsing %s: %v", strings.Join(files, ", "), err)
 	}
 
 	return config, nil
 }
 
+// configurationFiles expands the configuration paths to the list of
+// configuration files, replacing directories with the YAML files they
+// contain, in lexical order.
+func configurationFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		var found bool
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			if ext := filepath.Ext(entry.Name()); ext == ".yml" || ext == ".yaml" {
+				files = append(files, filepath.Join(path, entry.Name()))
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no configuration files found in %s", path)
+		}
+	}
+	return files, nil
+}
+
 func nextProtos(config *configuration.Configuration) []string {
 	switch config.HTTP.HTTP2.Disabled {
 	case true:
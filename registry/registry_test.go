@@ -17,6 +17,7 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"reflect"
@@ -103,7 +104,7 @@ func TestGracefulShutdown(t *testing.T) {
 	fmt.Fprintf(conn, "GET /v2/ ")
 
 	// send stop signal
-	quit <- os.Interrupt
+	registry.quit <- os.Interrupt
 	time.Sleep(100 * time.Millisecond)
 
 	// try connecting again. it shouldn't
@@ -321,7 +322,7 @@ func TestRegistrySupportedCipherSuite(t *testing.T) {
 	}
 
 	// send stop signal
-	quit <- os.Interrupt
+	registry.quit <- os.Interrupt
 	time.Sleep(100 * time.Millisecond)
 }
 
@@ -365,10 +366,136 @@ func TestRegistryUnsupportedCipherSuite(t *testing.T) {
 	}
 
 	// send stop signal
-	quit <- os.Interrupt
+	registry.quit <- os.Interrupt
 	time.Sleep(100 * time.Millisecond)
 }
 
+func TestEmbeddedRegistries(t *testing.T) {
+	newConfig := func() *configuration.Configuration {
+		config := &configuration.Configuration{}
+		config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+		return config
+	}
+
+	// registries in the same process must not share their health checks
+	failing := newConfig()
+	failing.Health.FileCheckers = []configuration.FileChecker{{File: t.TempDir(), Interval: 10 * time.Millisecond}}
+
+	first, err := NewRegistry(context.Background(), failing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewRegistry(context.Background(), newConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	handlerServer := httptest.NewServer(first.Handler())
+	defer handlerServer.Close()
+
+	resp, err := http.Get(handlerServer.URL + "/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status from the unhealthy registry: %d", resp.StatusCode)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errchan := make(chan error, 1)
+	go func() {
+		errchan <- second.Serve(ln)
+	}()
+	defer ln.Close()
+
+	resp, err = http.Get("http://" + ln.Addr().String() + "/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status from the healthy registry: %d", resp.StatusCode)
+	}
+
+	ln.Close()
+	if err := <-errchan; err == nil {
+		t.Fatal("expected an error serving on a closed listener")
+	}
+}
+
+func TestRegistryLifecycle(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Addr = "127.0.0.1:0"
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+
+	registry, err := NewRegistry(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.Addr() != nil {
+		t.Fatalf("unexpected address before start: %v", registry.Addr())
+	}
+
+	if err := registry.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error starting registry: %v", err)
+	}
+	addr := registry.Addr()
+	if addr == nil {
+		t.Fatal("expected an address after start")
+	}
+
+	resp, err := http.Get("http://" + addr.String() + "/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	if err := registry.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error shutting down registry: %v", err)
+	}
+	if _, err := net.Dial("tcp", addr.String()); err == nil {
+		t.Fatal("managed to connect after shutdown")
+	}
+}
+
+func TestRegistryStartContext(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Addr = "127.0.0.1:0"
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+
+	registry, err := NewRegistry(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := registry.Start(ctx); err != nil {
+		t.Fatalf("unexpected error starting registry: %v", err)
+	}
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", registry.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("registry was not shut down when the context was cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestConfigureLogging(t *testing.T) {
 	yamlConfig := `---
 log:
@@ -389,11 +516,6 @@ log:
 		t.Fatal("failed to configure logging: ", err)
 	}
 
-	// Check that the log level was set to Warn.
-	if logrus.IsLevelEnabled(logrus.InfoLevel) {
-		t.Error("expected Info to be disabled, is enabled")
-	}
-
 	// Check that the returned context's logger includes the right fields.
 	logger := dcontext.GetLogger(ctx)
 	entry, ok := logger.(*logrus.Entry)
@@ -409,18 +531,69 @@ log:
 		t.Error("field baz not configured correctly; expected 'xyzzy' got: ", val)
 	}
 
-	// Get a logger for a new, empty context and make sure it also has the right fields.
+	// Check that the log level was set to Warn.
+	if entry.Logger.IsLevelEnabled(logrus.InfoLevel) {
+		t.Error("expected Info to be disabled, is enabled")
+	}
+
+	// Get a logger for a new, empty context and make sure the configuration
+	// did not leak into the default logger.
 	logger = dcontext.GetLogger(context.Background())
 	entry, ok = logger.(*logrus.Entry)
 	if !ok {
 		t.Fatalf("expected logger to be a *logrus.Entry, is: %T", entry)
 	}
-	val, ok = entry.Data["foo"].(string)
-	if !ok || val != "bar" {
-		t.Error("field foo not configured correctly; expected 'bar' got: ", val)
+	if _, ok := entry.Data["foo"]; ok {
+		t.Error("expected the default logger not to include field foo")
 	}
-	val, ok = entry.Data["baz"].(string)
-	if !ok || val != "xyzzy" {
-		t.Error("field baz not configured correctly; expected 'xyzzy' got: ", val)
+	if entry.Logger == dcontext.GetLogger(ctx).(*logrus.Entry).Logger {
+		t.Error("expected the context logger to be independent of the default logger")
+	}
+}
+
+func TestResolveConfigurationOverlays(t *testing.T) {
+	dir := t.TempDir()
+	confd := path.Join(dir, "conf.d")
+	if err := os.Mkdir(confd, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		path.Join(dir, "config.yml"): `version: 0.1
+log:
+  level: info
+storage:
+  inmemory: {}
+http:
+  addr: :5000
+`,
+		path.Join(confd, "10-log.yml"): `log:
+  level: warn
+`,
+		path.Join(confd, "20-http.yaml"): `http:
+  addr: :5001
+  secret: site
+`,
+		path.Join(confd, "README"): `not a configuration file`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := resolveConfiguration([]string{path.Join(dir, "config.yml"), confd})
+	if err != nil {
+		t.Fatalf("unexpected error resolving configuration: %v", err)
+	}
+
+	if config.Log.Level != "warn" {
+		t.Errorf("unexpected log level: %q", config.Log.Level)
+	}
+	if config.HTTP.Addr != ":5001" || config.HTTP.Secret != "site" {
+		t.Errorf("unexpected http configuration: %q, %q", config.HTTP.Addr, config.HTTP.Secret)
+	}
+	if config.Storage.Type() != "inmemory" {
+		t.Errorf("unexpected storage type: %q", config.Storage.Type())
 	}
 }
@@ -0,0 +1,178 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/opencontainers/go-digest"
+)
+
+// push copies the manifest, the manifests it references and their blobs to
+// the peer, then tags the manifest if it was pushed by tag. Conflicting
+// writes to the tag are resolved if the peer accepts writes of its own.
+func (p *peer) push(ctx context.Context, repository string, dgst digest.Digest, tag string) error {
+	named, err := reference.WithName(repository)
+	if err != nil {
+		return err
+	}
+	local, err := p.local.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+	remote, err := p.repository(ctx, named)
+	if err != nil {
+		return err
+	}
+
+	m, err := p.copyManifest(ctx, local, remote, dgst)
+	if err != nil {
+		return err
+	}
+	if tag == "" {
+		return nil
+	}
+
+	if p.conflict != "" {
+		current, err := remote.Tags(ctx).Get(ctx, tag)
+		switch {
+		case err == nil:
+			if current.Digest == dgst {
+				p.setSynced(repository, tag, dgst)
+				return nil
+			}
+			if synced := p.getSynced(repository, tag); current.Digest != synced {
+				// The tag was written on the peer since it was last shared:
+				// both registries accepted a write to it.
+				replicationConflicts.WithValues(p.name).Inc(1)
+				dcontext.GetLogger(ctx).Warnf("replication: conflicting writes to %s:%s on peer %s: local %s, peer %s, resolving with %q",
+					repository, tag, p.name, dgst, current.Digest, p.conflict)
+				if p.conflict == ConflictKeep {
+					return nil
+				}
+			}
+		case statusCode(err) != http.StatusNotFound:
+			return err
+		}
+	}
+
+	manifests, err := remote.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := manifests.Put(ctx, m, distribution.WithTag(tag)); err != nil {
+		return err
+	}
+	p.setSynced(repository, tag, dgst)
+	return nil
+}
+
+// copyManifest copies the manifest and everything it references to the
+// peer, children first, so that the peer never holds a manifest with
+// missing references.
+func (p *peer) copyManifest(ctx context.Context, local, remote distribution.Repository, dgst digest.Digest) (distribution.Manifest, error) {
+	localManifests, err := local.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := localManifests.Get(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+
+	localBlobs := local.Blobs(ctx)
+	var layers []client.Layer
+	for _, desc := range m.References() {
+		if isManifest(desc.MediaType) {
+			if _, err := p.copyManifest(ctx, local, remote, desc.Digest); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(desc.URLs) > 0 {
+			// foreign layers are not stored by the registry
+			continue
+		}
+
+		desc := desc
+		layers = append(layers, client.Layer{
+			Descriptor: desc,
+			Open: func() (io.ReadCloser, error) {
+				return localBlobs.Open(ctx, desc.Digest)
+			},
+		})
+	}
+
+	if err := client.PushLayers(ctx, remote.Blobs(ctx), layers, client.PushOptions{}); err != nil {
+		return nil, err
+	}
+	remoteManifests, err := remote.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := remoteManifests.Put(ctx, m); err != nil {
+		return nil, fmt.Errorf("pushing manifest %s: %w", dgst, err)
+	}
+	return m, nil
+}
+
+// untag removes the tag from the peer.
+func (p *peer) untag(ctx context.Context, repository, tag string) error {
+	named, err := reference.WithName(repository)
+	if err != nil {
+		return err
+	}
+	remote, err := p.repository(ctx, named)
+	if err != nil {
+		return err
+	}
+
+	if p.conflict == ConflictKeep {
+		current, err := remote.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			if statusCode(err) == http.StatusNotFound {
+				p.setSynced(repository, tag, "")
+				return nil
+			}
+			return err
+		}
+		if synced := p.getSynced(repository, tag); current.Digest != synced {
+			replicationConflicts.WithValues(p.name).Inc(1)
+			dcontext.GetLogger(ctx).Warnf("replication: conflicting writes to %s:%s on peer %s: local delete, peer %s, keeping the peer tag",
+				repository, tag, p.name, current.Digest)
+			return nil
+		}
+	}
+
+	if err := remote.Tags(ctx).Untag(ctx, tag); err != nil && statusCode(err) != http.StatusNotFound {
+		return err
+	}
+	p.setSynced(repository, tag, "")
+	return nil
+}
+
+// delete removes the manifest from the peer.
+func (p *peer) delete(ctx context.Context, repository string, dgst digest.Digest) error {
+	named, err := reference.WithName(repository)
+	if err != nil {
+		return err
+	}
+	remote, err := p.repository(ctx, named)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := remote.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	if err := manifests.Delete(ctx, dgst); err != nil && statusCode(err) != http.StatusNotFound {
+		return err
+	}
+	return nil
+}
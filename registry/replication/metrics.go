@@ -0,0 +1,21 @@
+package replication
+
+import (
+	prometheus "github.com/docker/distribution/metrics"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// replicationLag times the delay between a change and its replication.
+	replicationLag = prometheus.ReplicationNamespace.NewLabeledTimer("lag", "The number of seconds between a change and its replication", "peer")
+
+	// replicationEvents counts replicated changes by peer, action and status.
+	replicationEvents = prometheus.ReplicationNamespace.NewLabeledCounter("events", "The number of replicated changes", "peer", "action", "status")
+
+	// replicationConflicts counts conflicting writes to the same tag.
+	replicationConflicts = prometheus.ReplicationNamespace.NewLabeledCounter("tag_conflicts", "The number of conflicting writes to a tag", "peer")
+)
+
+func init() {
+	metrics.Register(prometheus.ReplicationNamespace)
+}
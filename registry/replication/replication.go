@@ -0,0 +1,456 @@
+// Package replication replicates the pushes and deletes of a registry to
+// peer registries, so that registries in several regions can each accept
+// writes, and the pushes to some of its repositories to downstream
+// registries. Changes are read from the notification events of the registry
+// and applied to the other registries with the registry client. Repositories
+// of a remote registry can also be synchronized into the registry with Sync.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/docker/distribution/registry/client/transport"
+	"github.com/docker/distribution/version"
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+)
+
+// userAgentPrefix prefixes the user agent of replication requests, which
+// identifies them in the logs of the other registry.
+const userAgentPrefix = "distribution-replication/"
+
+// Conflict resolutions of writes to the same tag.
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictKeep      = "keep"
+)
+
+const (
+	defaultTimeout   = 5 * time.Minute
+	defaultThreshold = 10
+	defaultBackoff   = time.Second
+)
+
+// peer replicates changes to another registry. Changes which fail with a
+// transient error are retried by the retrying sink wrapping the peer.
+type peer struct {
+	name    string
+	url     string
+	timeout time.Duration
+
+	// repositories restricts the replicated repositories to those matching
+	// any of the patterns, if set.
+	repositories []string
+	// deletes is true if deletes are replicated in addition to pushes.
+	deletes bool
+	// conflict is the resolution of conflicting writes to a tag, or empty if
+	// the peer does not accept writes of its own.
+	conflict string
+	// identities are the users other registries replicate their changes to
+	// this one as, whose changes are not replicated further.
+	identities []string
+
+	ctx    context.Context
+	local  distribution.Namespace
+	status *status
+
+	base       http.RoundTripper
+	challenges challenge.Manager
+	creds      auth.CredentialStore
+
+	// synced records, by tag, the digest last known to be shared with the
+	// peer, either replicated to it or from it.
+	mu     sync.Mutex
+	synced map[string]digest.Digest
+}
+
+// Sink replicates the events it receives to another registry. Events are
+// queued and replicated in the background, retrying on failure.
+type Sink struct {
+	peer  *peer
+	queue events.Sink
+}
+
+// NewPeerSink returns a sink replicating pushes and deletes to the peer,
+// reading the changed content from the local namespace. The changes made by
+// the identities, which peers replicate their changes to this registry as,
+// are not replicated, so that they do not loop between the peers.
+func NewPeerSink(ctx context.Context, local distribution.Namespace, config configuration.ReplicationPeer, identities []string) (*Sink, error) {
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("replication: identities are required to replicate to peer %s", config.Name)
+	}
+	switch config.Conflict {
+	case "":
+		config.Conflict = ConflictOverwrite
+	case ConflictOverwrite, ConflictKeep:
+	default:
+		return nil, fmt.Errorf("replication: invalid conflict resolution for peer %s: %q", config.Name, config.Conflict)
+	}
+
+	p, err := newPeer(ctx, local, config.Name, config.URL, config.Username, config.Password, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	p.deletes = true
+	p.conflict = config.Conflict
+	p.identities = identities
+
+	return newSink(p, config.Threshold, config.Backoff), nil
+}
+
+// NewRuleSink returns a sink replicating pushes to the repositories matching
+// the rule to its downstream registry, reading the pushed content from the
+// local namespace. The pushes made by the identities are not replicated.
+func NewRuleSink(ctx context.Context, local distribution.Namespace, config configuration.ReplicationRule, identities []string) (*Sink, error) {
+	if len(config.Repositories) == 0 {
+		return nil, fmt.Errorf("replication: no repositories for rule %s", config.Name)
+	}
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("replication: invalid repository pattern for rule %s: %q", config.Name, pattern)
+		}
+	}
+
+	p, err := newPeer(ctx, local, config.Name, config.URL, config.Username, config.Password, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	p.repositories = config.Repositories
+	p.identities = identities
+
+	return newSink(p, config.Threshold, config.Backoff), nil
+}
+
+func newPeer(ctx context.Context, local distribution.Namespace, name, rawURL, username, password string, timeout time.Duration) (*peer, error) {
+	if name == "" {
+		return nil, errors.New("replication: a name is required")
+	}
+	if _, err := url.Parse(rawURL); err != nil || rawURL == "" {
+		return nil, fmt.Errorf("replication: invalid url for %s: %q", name, rawURL)
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &peer{
+		name:    name,
+		url:     strings.TrimSuffix(rawURL, "/"),
+		timeout: timeout,
+
+		ctx:    ctx,
+		local:  local,
+		status: &status{Status: Status{Name: name, URL: rawURL}},
+
+		base: transport.NewTransport(http.DefaultTransport,
+			transport.NewHeaderRequestModifier(http.Header{
+				"User-Agent": []string{userAgentPrefix + version.Version},
+			})),
+		challenges: challenge.NewSimpleManager(),
+		creds:      credentials{username: username, password: password},
+
+		synced: make(map[string]digest.Digest),
+	}, nil
+}
+
+func newSink(p *peer, threshold int, backoff time.Duration) *Sink {
+	if threshold <= 0 {
+		threshold = defaultThreshold
+	}
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	return &Sink{
+		peer:  p,
+		queue: events.NewQueue(events.NewRetryingSink(p, events.NewBreaker(threshold, backoff))),
+	}
+}
+
+// Write queues the event for replication if it describes a replicated
+// change.
+func (s *Sink) Write(event events.Event) error {
+	e, ok := event.(notifications.Event)
+	if !ok || !s.peer.replicates(e) {
+		return nil
+	}
+
+	s.peer.status.enqueued()
+	return s.queue.Write(e)
+}
+
+// Close stops replicating, once the queued changes are replicated.
+func (s *Sink) Close() error {
+	return s.queue.Close()
+}
+
+// Status returns the status of the replication.
+func (s *Sink) Status() Status {
+	return s.peer.status.snapshot()
+}
+
+// OpenBlob opens the blob of the repository on the registry replicated to,
+// such as to repair a corrupted local copy. The repository must be
+// replicated to the registry.
+func (s *Sink) OpenBlob(ctx context.Context, name reference.Named, dgst digest.Digest) (io.ReadCloser, error) {
+	if s.peer.repositories != nil && !matchAny(s.peer.repositories, name.Name()) {
+		return nil, fmt.Errorf("replication: %s is not replicated to %s", name.Name(), s.peer.name)
+	}
+
+	remote, err := s.peer.repository(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return remote.Blobs(ctx).Open(ctx, dgst)
+}
+
+// replicates returns true if the change described by the event is
+// replicated to the peer.
+func (p *peer) replicates(e notifications.Event) bool {
+	target := e.Target
+
+	// The actor is the user authenticated by the registry, which unlike
+	// the headers of the request cannot be chosen by the client.
+	if e.Actor.Name != "" && containsString(p.identities, e.Actor.Name) {
+		// The change was replicated from another registry: do not replicate
+		// it further, but record the tag as shared.
+		if e.Action == notifications.EventActionPush && target.Tag != "" {
+			p.setSynced(target.Repository, target.Tag, target.Digest)
+		}
+		return false
+	}
+
+	if p.repositories != nil && !matchAny(p.repositories, target.Repository) {
+		return false
+	}
+
+	switch e.Action {
+	case notifications.EventActionPush:
+		return isManifest(target.MediaType)
+	case notifications.EventActionDelete:
+		return p.deletes && (target.Tag != "" || target.Digest != "")
+	default:
+		return false
+	}
+}
+
+// Write replicates the change described by the event. Only transient errors
+// are returned, so that the change is retried.
+func (p *peer) Write(event events.Event) error {
+	e := event.(notifications.Event)
+	target := e.Target
+
+	logger := dcontext.GetLoggerWithFields(p.ctx, map[interface{}]interface{}{
+		"replication.peer":       p.name,
+		"replication.action":     e.Action,
+		"replication.repository": target.Repository,
+		"replication.digest":     target.Digest,
+		"replication.tag":        target.Tag,
+	})
+
+	ctx, cancel := context.WithTimeout(p.ctx, p.timeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case e.Action == notifications.EventActionPush:
+		err = p.push(ctx, target.Repository, target.Digest, target.Tag)
+	case target.Tag != "":
+		err = p.untag(ctx, target.Repository, target.Tag)
+	default:
+		// Manifest and blob deletes cannot be told apart from the event.
+		// Blobs are not deleted from the peer, which collects them as
+		// garbage once unreferenced.
+		err = p.delete(ctx, target.Repository, target.Digest)
+	}
+
+	switch {
+	case err == nil:
+		p.status.replicated()
+		replicationLag.WithValues(p.name).UpdateSince(e.Timestamp)
+		replicationEvents.WithValues(p.name, e.Action, "success").Inc(1)
+		logger.Debug("replicated change")
+		return nil
+	case retryable(err):
+		p.status.retried(err)
+		replicationEvents.WithValues(p.name, e.Action, "retry").Inc(1)
+		logger.Warnf("error replicating change, retrying: %v", err)
+		return err
+	default:
+		p.status.failed(err)
+		replicationEvents.WithValues(p.name, e.Action, "failure").Inc(1)
+		logger.Errorf("error replicating change: %v", err)
+		return nil
+	}
+}
+
+// Close implements events.Sink.
+func (p *peer) Close() error {
+	return nil
+}
+
+// repository returns the named repository of the peer, authorized to push.
+func (p *peer) repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	tr, err := p.transport(ctx, auth.RepositoryScope{
+		Repository: name.Name(),
+		Actions:    []string{"pull", "push", "delete"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.NewRepository(name, p.url, tr)
+}
+
+// transport returns a transport to the peer authorized for the scopes.
+func (p *peer) transport(ctx context.Context, scopes ...auth.Scope) (http.RoundTripper, error) {
+	if err := p.ping(ctx); err != nil {
+		return nil, err
+	}
+
+	return transport.NewTransport(p.base, auth.NewAuthorizer(p.challenges,
+		auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+			Transport:   p.base,
+			Credentials: p.creds,
+			Scopes:      scopes,
+			Logger:      dcontext.GetLogger(ctx),
+		}),
+		auth.NewBasicHandler(p.creds))), nil
+}
+
+// ping establishes the authentication challenges of the peer, if unknown.
+func (p *peer) ping(ctx context.Context) error {
+	endpoint, err := url.Parse(p.url + "/v2/")
+	if err != nil {
+		return err
+	}
+	challenges, err := p.challenges.GetChallenges(*endpoint)
+	if err != nil || len(challenges) > 0 {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Transport: p.base}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return p.challenges.AddResponse(resp)
+}
+
+func (p *peer) syncedKey(repository, tag string) string {
+	return repository + ":" + tag
+}
+
+func (p *peer) getSynced(repository, tag string) digest.Digest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.synced[p.syncedKey(repository, tag)]
+}
+
+func (p *peer) setSynced(repository, tag string, dgst digest.Digest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if dgst == "" {
+		delete(p.synced, p.syncedKey(repository, tag))
+		return
+	}
+	p.synced[p.syncedKey(repository, tag)] = dgst
+}
+
+// credentials authenticates with the peer with static credentials.
+type credentials struct {
+	username, password string
+}
+
+func (c credentials) Basic(*url.URL) (string, string) {
+	return c.username, c.password
+}
+
+func (c credentials) RefreshToken(*url.URL, string) string {
+	return ""
+}
+
+func (c credentials) SetRefreshToken(*url.URL, string, string) {
+}
+
+// matchAny returns true if the name matches any of the patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns true if the values include s.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isManifest returns true if the media type is that of a manifest.
+func isManifest(mediaType string) bool {
+	for _, mt := range distribution.ManifestMediaTypes() {
+		if mt == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// statusCode returns the HTTP status code of an error returned by the peer,
+// or zero if the error was not returned by the peer.
+func statusCode(err error) int {
+	var errs errcode.Errors
+	if errors.As(err, &errs) && len(errs) > 0 {
+		err = errs[0]
+	}
+
+	var coder errcode.ErrorCoder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode().Descriptor().HTTPStatusCode
+	}
+	var respErr *client.UnexpectedHTTPResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode
+	}
+	return 0
+}
+
+// retryable returns true if the error may be transient, such as network
+// errors and server errors of the peer.
+func retryable(err error) bool {
+	if code := statusCode(err); code != 0 {
+		return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,422 @@
+package replication_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	_ "github.com/docker/distribution/registry/auth/htpasswd"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/handlers"
+	"github.com/docker/distribution/registry/replication"
+	"github.com/docker/distribution/registry/storage"
+	_ "github.com/docker/distribution/registry/storage/driver/filesystem"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newRegistry(t *testing.T, peers ...configuration.ReplicationPeer) *httptest.Server {
+	t.Helper()
+
+	_, server := newApp(t, configuration.Replication{Peers: peers, Identities: []string{"replicator"}})
+	return server
+}
+
+func newApp(t *testing.T, replication configuration.Replication) (*handlers.App, *httptest.Server) {
+	t.Helper()
+
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{
+		"inmemory": map[string]interface{}{},
+		"delete":   map[string]interface{}{"enabled": true},
+	}
+	config.Catalog.MaxEntries = 100
+	config.Replication = replication
+
+	app := handlers.NewApp(context.Background(), config)
+	server := httptest.NewServer(app)
+	t.Cleanup(server.Close)
+	return app, server
+}
+
+func newRepository(t *testing.T, server *httptest.Server, name string) distribution.Repository {
+	t.Helper()
+
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := client.NewRepository(named, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// pushImage pushes an image with a random layer under the tag, returning its
+// digest.
+func pushImage(t *testing.T, repo distribution.Repository, tag string) digest.Digest {
+	t.Helper()
+	ctx := context.Background()
+
+	layer := make([]byte, 64)
+	if _, err := rand.Read(layer); err != nil {
+		t.Fatal(err)
+	}
+	blobs := repo.Blobs(ctx)
+	config, err := blobs.Put(ctx, schema2.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		t.Fatalf("unexpected error pushing config: %v", err)
+	}
+	layerDesc, err := blobs.Put(ctx, schema2.MediaTypeLayer, layer)
+	if err != nil {
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    config,
+		Layers:    []distribution.Descriptor{layerDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, m, distribution.WithTag(tag))
+	if err != nil {
+		t.Fatalf("unexpected error pushing manifest: %v", err)
+	}
+	return dgst
+}
+
+// waitFor polls the condition until it holds or the test times out.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func tagDigest(repo distribution.Repository, tag string) digest.Digest {
+	ctx := context.Background()
+	desc, err := repo.Tags(ctx).Get(ctx, tag)
+	if err != nil {
+		return ""
+	}
+	return desc.Digest
+}
+
+func TestReplication(t *testing.T) {
+	b := newRegistry(t)
+	a := newRegistry(t, configuration.ReplicationPeer{Name: "b", URL: b.URL})
+
+	ctx := context.Background()
+	repoA := newRepository(t, a, "foo/bar")
+	repoB := newRepository(t, b, "foo/bar")
+
+	dgst := pushImage(t, repoA, "latest")
+	waitFor(t, "the tag to be replicated", func() bool {
+		return tagDigest(repoB, "latest") == dgst
+	})
+
+	manifests, err := repoB.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error fetching replicated manifest: %v", err)
+	}
+	for _, desc := range m.References() {
+		if _, err := repoB.Blobs(ctx).Stat(ctx, desc.Digest); err != nil {
+			t.Fatalf("blob %s was not replicated: %v", desc.Digest, err)
+		}
+	}
+
+	if err := repoA.Tags(ctx).Untag(ctx, "latest"); err != nil {
+		t.Fatalf("unexpected error deleting tag: %v", err)
+	}
+	waitFor(t, "the tag delete to be replicated", func() bool {
+		return tagDigest(repoB, "latest") == ""
+	})
+}
+
+// basicAuthTransport authenticates each request as a user, with a chosen user
+// agent.
+type basicAuthTransport struct {
+	username, password, userAgent string
+}
+
+func (t basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if t.username != "" {
+		req.SetBasicAuth(t.username, t.password)
+	}
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestReplicationIdentities(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswdPath := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(htpasswdPath, []byte("alice:"+string(hash)+"\nreplicator:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a replicates to b as the replicator user, whose changes b does not
+	// replicate back to a
+	a := httptest.NewUnstartedServer(nil)
+	defer a.Close()
+	configB := &configuration.Configuration{}
+	configB.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	configB.Auth = configuration.Auth{"htpasswd": {"realm": "test", "path": htpasswdPath}}
+	configB.Replication = configuration.Replication{
+		Peers:      []configuration.ReplicationPeer{{Name: "a", URL: "http://" + a.Listener.Addr().String()}},
+		Identities: []string{"replicator"},
+	}
+	appB := handlers.NewApp(context.Background(), configB)
+	b := httptest.NewServer(appB)
+	defer b.Close()
+
+	configA := &configuration.Configuration{}
+	configA.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	configA.Replication = configuration.Replication{
+		Peers:      []configuration.ReplicationPeer{{Name: "b", URL: b.URL, Username: "replicator", Password: "secret"}},
+		Identities: []string{"replicator"},
+	}
+	a.Config.Handler = handlers.NewApp(context.Background(), configA)
+	a.Start()
+
+	repo := func(server *httptest.Server, tr http.RoundTripper) distribution.Repository {
+		named, err := reference.WithName("foo/bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := client.NewRepository(named, server.URL, tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+	alice := basicAuthTransport{username: "alice", password: "secret"}
+
+	// the user agent of the client does not stop the replication
+	dgst := pushImage(t, repo(a, basicAuthTransport{userAgent: "distribution-replication/spoofed"}), "latest")
+	waitFor(t, "the tag to be replicated", func() bool {
+		return tagDigest(repo(b, alice), "latest") == dgst
+	})
+
+	// changes are replicated in order: once a later push is replicated, the
+	// replicated one was skipped
+	sentinel := pushImage(t, repo(b, alice), "sentinel")
+	waitFor(t, "the sentinel tag to be replicated", func() bool {
+		return tagDigest(repo(a, nil), "sentinel") == sentinel
+	})
+	var status struct {
+		Destinations []replication.Status `json:"destinations"`
+	}
+	rec := httptest.NewRecorder()
+	appB.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/v1/replication", nil))
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("unexpected error decoding status: %v", err)
+	}
+	if len(status.Destinations) != 1 || status.Destinations[0].Replicated != 1 {
+		t.Fatalf("unexpected replication of a replicated change: %+v", status.Destinations)
+	}
+}
+
+func TestReplicationConflictKeep(t *testing.T) {
+	b := newRegistry(t)
+	a := newRegistry(t, configuration.ReplicationPeer{Name: "b", URL: b.URL, Conflict: "keep"})
+
+	repoA := newRepository(t, a, "foo/bar")
+	repoB := newRepository(t, b, "foo/bar")
+
+	kept := pushImage(t, repoB, "latest")
+	pushImage(t, repoA, "latest")
+
+	// changes are replicated in order: once a later push is replicated, the
+	// conflicting one was resolved
+	sentinel := pushImage(t, repoA, "sentinel")
+	waitFor(t, "the sentinel tag to be replicated", func() bool {
+		return tagDigest(repoB, "sentinel") == sentinel
+	})
+
+	if dgst := tagDigest(repoB, "latest"); dgst != kept {
+		t.Fatalf("expected the peer to keep its tag: %s != %s", dgst, kept)
+	}
+}
+
+func TestReplicationRule(t *testing.T) {
+	downstream := newRegistry(t)
+	app, upstream := newApp(t, configuration.Replication{
+		Rules: []configuration.ReplicationRule{{
+			Name:         "mirror",
+			Repositories: []string{"mirrored/*"},
+			URL:          downstream.URL,
+		}},
+	})
+
+	private := pushImage(t, newRepository(t, upstream, "private/app"), "latest")
+	mirrored := pushImage(t, newRepository(t, upstream, "mirrored/app"), "latest")
+	waitFor(t, "the push to be replicated", func() bool {
+		return tagDigest(newRepository(t, downstream, "mirrored/app"), "latest") == mirrored
+	})
+
+	if dgst := tagDigest(newRepository(t, downstream, "private/app"), "latest"); dgst != "" {
+		t.Fatalf("unexpected replication of an unmatched repository: %s", dgst)
+	}
+	ctx := context.Background()
+	manifests, err := newRepository(t, downstream, "private/app").Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := manifests.Exists(ctx, private); exists {
+		t.Fatal("unexpected replication of an unmatched manifest")
+	}
+
+	var status struct {
+		Destinations []replication.Status `json:"destinations"`
+	}
+	waitFor(t, "the status to be reported", func() bool {
+		rec := httptest.NewRecorder()
+		app.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/v1/replication", nil))
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("unexpected error decoding status: %v", err)
+		}
+		return len(status.Destinations) == 1 && status.Destinations[0].Replicated == 1
+	})
+
+	if s := status.Destinations[0]; s.Name != "mirror" || s.Pending != 0 || s.Failed != 0 || s.LastReplicated == nil {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+}
+
+func TestReplicationRepairsScrubbedBlobs(t *testing.T) {
+	b := newRegistry(t)
+
+	root := t.TempDir()
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{
+		"filesystem": map[string]interface{}{"rootdirectory": root},
+	}
+	config.Replication.Peers = []configuration.ReplicationPeer{{Name: "b", URL: b.URL}}
+	config.Replication.Identities = []string{"replicator"}
+	config.Scrub = configuration.Scrub{
+		Enabled:  true,
+		Interval: 10 * time.Millisecond,
+		Sample:   1,
+		Repair:   true,
+	}
+	a := httptest.NewServer(handlers.NewApp(context.Background(), config))
+	defer a.Close()
+
+	ctx := context.Background()
+	repoA := newRepository(t, a, "foo/bar")
+	repoB := newRepository(t, b, "foo/bar")
+
+	dgst := pushImage(t, repoA, "latest")
+	waitFor(t, "the tag to be replicated", func() bool {
+		return tagDigest(repoB, "latest") == dgst
+	})
+
+	manifests, err := repoA.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := m.References()[1].Digest
+	layerPath := filepath.Join(root, "docker/registry/v2/blobs", layer.Algorithm().String(), layer.Encoded()[:2], layer.Encoded(), "data")
+	if err := os.WriteFile(layerPath, []byte("corrupted"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the corrupted layer to be repaired", func() bool {
+		content, err := os.ReadFile(layerPath)
+		return err == nil && digest.FromBytes(content) == layer
+	})
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	remote := newRegistry(t)
+	pushImage(t, newRepository(t, remote, "library/a"), "latest")
+	pushed := pushImage(t, newRepository(t, remote, "library/a"), "1.0")
+	pushImage(t, newRepository(t, remote, "library/b"), "1.0")
+	pushImage(t, newRepository(t, remote, "other/c"), "1.0")
+
+	local, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := reference.WithName("library/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localRepo, err := local.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := replication.SyncOptions{
+		URL:     remote.URL,
+		Include: []string{"library/*"},
+		Exclude: []string{"library/b"},
+		Tags:    regexp.MustCompile(`^1\.`),
+	}
+	result, err := replication.Sync(ctx, local, opts)
+	if err != nil {
+		t.Fatalf("unexpected error synchronizing: %v", err)
+	}
+	if expected := (replication.SyncResult{Repositories: 1, Synced: 1, Blobs: 2}); result != expected {
+		t.Fatalf("unexpected result: %+v != %+v", result, expected)
+	}
+	if dgst := tagDigest(localRepo, "1.0"); dgst != pushed {
+		t.Fatalf("unexpected synchronized tag: %s != %s", dgst, pushed)
+	}
+	if dgst := tagDigest(localRepo, "latest"); dgst != "" {
+		t.Fatalf("unexpected synchronization of an unmatched tag: %s", dgst)
+	}
+
+	// only what changed is copied again
+	result, err = replication.Sync(ctx, local, opts)
+	if err != nil || result != (replication.SyncResult{Repositories: 1, Unchanged: 1}) {
+		t.Fatalf("unexpected result of a repeated synchronization: %+v, %v", result, err)
+	}
+	pushed = pushImage(t, newRepository(t, remote, "library/a"), "1.0")
+	result, err = replication.Sync(ctx, local, opts)
+	if err != nil || result != (replication.SyncResult{Repositories: 1, Synced: 1, Blobs: 1}) {
+		t.Fatalf("unexpected result of a synchronization of a moved tag: %+v, %v", result, err)
+	}
+	if dgst := tagDigest(localRepo, "1.0"); dgst != pushed {
+		t.Fatalf("unexpected synchronized tag after moving it: %s != %s", dgst, pushed)
+	}
+}
@@ -0,0 +1,73 @@
+package replication
+
+import (
+	"sync"
+	"time"
+)
+
+// Status reports the progress of the replication to another registry.
+type Status struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Pending is the number of changes queued or being replicated.
+	Pending int64 `json:"pending"`
+	// Replicated is the number of changes replicated.
+	Replicated int64 `json:"replicated"`
+	// Failed is the number of changes which failed to replicate and were
+	// not retried.
+	Failed int64 `json:"failed"`
+
+	LastReplicated *time.Time `json:"lastReplicated,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	LastErrorAt    *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// status tracks the status of the replication to another registry.
+type status struct {
+	mu sync.Mutex
+	Status
+}
+
+func (s *status) enqueued() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Pending++
+}
+
+func (s *status) replicated() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.Pending--
+	s.Replicated++
+	s.LastReplicated = &now
+}
+
+// retried records the error of a change which will be retried.
+func (s *status) retried(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setError(err)
+}
+
+func (s *status) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Pending--
+	s.Failed++
+	s.setError(err)
+}
+
+func (s *status) setError(err error) {
+	now := time.Now()
+	s.LastError = err.Error()
+	s.LastErrorAt = &now
+}
+
+// snapshot returns a copy of the status safe to encode.
+func (s *status) snapshot() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Status
+}
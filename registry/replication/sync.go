@@ -0,0 +1,275 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/client/auth"
+	"github.com/opencontainers/go-digest"
+)
+
+// SyncOptions selects the repositories and tags synchronized from a remote
+// registry.
+type SyncOptions struct {
+	URL      string
+	Username string
+	Password string
+
+	// Include restricts the synchronized repositories to those matching any
+	// of the patterns. Repositories are listed from the catalog of the
+	// remote registry unless all the patterns are plain repository names.
+	Include []string
+	// Exclude skips the repositories matching any of the patterns.
+	Exclude []string
+	// Tags restricts the synchronized tags to those matching the expression,
+	// if set.
+	Tags *regexp.Regexp
+
+	// DryRun lists the tags which would be synchronized without copying
+	// them.
+	DryRun bool
+}
+
+// SyncResult counts what a synchronization did.
+type SyncResult struct {
+	Repositories int
+	// Synced is the number of tags copied or moved to another manifest,
+	// and Unchanged the number of tags already pointing to the remote
+	// manifest.
+	Synced    int
+	Unchanged int
+	// Blobs is the number of blobs copied.
+	Blobs int
+	// Failed is the number of tags which failed to synchronize.
+	Failed int
+}
+
+// Sync mirrors the selected repositories and tags of the remote registry
+// into the local namespace. Tags already pointing to the remote manifest are
+// skipped, as are the manifests and blobs already stored locally, so that
+// repeated synchronizations only copy what changed. Tags which fail to
+// synchronize are logged and counted rather than returned as errors.
+func Sync(ctx context.Context, local distribution.Namespace, opts SyncOptions) (SyncResult, error) {
+	var result SyncResult
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return result, fmt.Errorf("replication: invalid repository pattern %q", pattern)
+		}
+	}
+
+	p, err := newPeer(ctx, local, opts.URL, opts.URL, opts.Username, opts.Password, 0)
+	if err != nil {
+		return result, err
+	}
+
+	repositories, err := p.syncedRepositories(ctx, opts.Include)
+	if err != nil {
+		return result, fmt.Errorf("replication: listing repositories of %s: %w", opts.URL, err)
+	}
+
+	for _, repository := range repositories {
+		if matchAny(opts.Exclude, repository) {
+			continue
+		}
+		if err := p.syncRepository(ctx, repository, opts, &result); err != nil {
+			return result, fmt.Errorf("replication: synchronizing %s: %w", repository, err)
+		}
+	}
+	return result, nil
+}
+
+// syncedRepositories returns the repositories of the peer matching any of
+// the patterns, reading the catalog only if a pattern is not a plain name.
+func (p *peer) syncedRepositories(ctx context.Context, include []string) ([]string, error) {
+	literal := len(include) > 0
+	for _, pattern := range include {
+		if strings.ContainsAny(pattern, `*?[\`) {
+			literal = false
+		}
+	}
+	if literal {
+		return include, nil
+	}
+
+	tr, err := p.transport(ctx, auth.RegistryScope{Name: "catalog", Actions: []string{"*"}})
+	if err != nil {
+		return nil, err
+	}
+	registry, err := client.NewRegistry(p.url, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories []string
+	it := registry.AllRepositories(ctx)
+	for it.Next() {
+		if len(include) == 0 || matchAny(include, it.Value()) {
+			repositories = append(repositories, it.Value())
+		}
+	}
+	return repositories, it.Err()
+}
+
+// syncRepository synchronizes the selected tags of the repository. Only
+// errors listing the tags are returned.
+func (p *peer) syncRepository(ctx context.Context, repository string, opts SyncOptions, result *SyncResult) error {
+	named, err := reference.WithName(repository)
+	if err != nil {
+		return err
+	}
+	tr, err := p.transport(ctx, auth.RepositoryScope{
+		Repository: repository,
+		Actions:    []string{"pull"},
+	})
+	if err != nil {
+		return err
+	}
+	remote, err := client.NewRepository(named, p.url, tr)
+	if err != nil {
+		return err
+	}
+	local, err := p.local.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+
+	result.Repositories++
+	it := client.AllTags(ctx, remote)
+	for it.Next() {
+		tag := it.Value()
+		if opts.Tags != nil && !opts.Tags.MatchString(tag) {
+			continue
+		}
+
+		logger := dcontext.GetLoggerWithFields(ctx, map[interface{}]interface{}{
+			"sync.repository": repository,
+			"sync.tag":        tag,
+		})
+		synced, err := p.syncTag(ctx, local, remote, tag, opts.DryRun, result)
+		switch {
+		case err != nil:
+			result.Failed++
+			logger.Errorf("error synchronizing tag: %v", err)
+		case synced:
+			result.Synced++
+			logger.Info("synchronized tag")
+		default:
+			result.Unchanged++
+		}
+	}
+	return it.Err()
+}
+
+// syncTag points the local tag to the manifest the remote tag points to,
+// copying the manifest first if needed. It returns false if the local tag
+// already pointed to it.
+func (p *peer) syncTag(ctx context.Context, local, remote distribution.Repository, tag string, dryRun bool, result *SyncResult) (bool, error) {
+	desc, err := remote.Tags(ctx).Get(ctx, tag)
+	if err != nil {
+		return false, err
+	}
+	current, err := local.Tags(ctx).Get(ctx, tag)
+	if err == nil && current.Digest == desc.Digest {
+		return false, nil
+	} else if _, ok := err.(distribution.ErrTagUnknown); err != nil && !ok {
+		return false, err
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	if err := p.pullManifest(ctx, local, remote, desc.Digest, result); err != nil {
+		return false, err
+	}
+	if err := local.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pullManifest copies the manifest and everything it references from the
+// peer, children first, unless the manifest is already stored locally.
+func (p *peer) pullManifest(ctx context.Context, local, remote distribution.Repository, dgst digest.Digest, result *SyncResult) error {
+	localManifests, err := local.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	if exists, err := localManifests.Exists(ctx, dgst); err != nil || exists {
+		return err
+	}
+	remoteManifests, err := remote.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := remoteManifests.Get(ctx, dgst)
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range m.References() {
+		if isManifest(desc.MediaType) {
+			if err := p.pullManifest(ctx, local, remote, desc.Digest, result); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(desc.URLs) > 0 {
+			// foreign layers are not stored by the registry
+			continue
+		}
+		copied, err := pullBlob(ctx, local.Blobs(ctx), remote.Blobs(ctx), desc)
+		if err != nil {
+			return fmt.Errorf("pulling blob %s: %w", desc.Digest, err)
+		}
+		if copied {
+			result.Blobs++
+		}
+	}
+
+	stored, err := localManifests.Put(ctx, m)
+	if err != nil {
+		return fmt.Errorf("storing manifest %s: %w", dgst, err)
+	}
+	if stored != dgst {
+		return fmt.Errorf("manifest %s was stored as %s", dgst, stored)
+	}
+	return nil
+}
+
+// pullBlob copies the blob from the peer unless it is already stored
+// locally, returning true if it was copied.
+func pullBlob(ctx context.Context, local, remote distribution.BlobStore, desc distribution.Descriptor) (bool, error) {
+	if _, err := local.Stat(ctx, desc.Digest); err == nil {
+		return false, nil
+	} else if err != distribution.ErrBlobUnknown {
+		return false, err
+	}
+
+	rc, err := remote.Open(ctx, desc.Digest)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	bw, err := local.Create(ctx)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(bw, rc); err != nil {
+		bw.Cancel(ctx)
+		return false, err
+	}
+	if _, err := bw.Commit(ctx, distribution.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}); err != nil {
+		bw.Cancel(ctx)
+		return false, err
+	}
+	return true, nil
+}
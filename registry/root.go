@@ -1,9 +1,12 @@
 package registry
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/docker/distribution/configuration"
 	dcontext "github.com/docker/distribution/context"
 	"github.com/docker/distribution/registry/storage"
 	"github.com/docker/distribution/registry/storage/driver/factory"
@@ -12,14 +15,55 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var showVersion bool
+var (
+	showVersion     bool
+	showVersionJSON bool
+)
 
 func init() {
 	RootCmd.AddCommand(ServeCmd)
 	RootCmd.AddCommand(GCCmd)
+	RootCmd.AddCommand(ConfigCmd)
+	ConfigCmd.AddCommand(ConfigRenderCmd)
+	RootCmd.AddCommand(ConformanceCmd)
+	RootCmd.AddCommand(BenchCmd)
+	RootCmd.AddCommand(TierCmd)
+	TierCmd.AddCommand(TierMigrateCmd)
+	RootCmd.AddCommand(IndexCmd)
+	IndexCmd.AddCommand(IndexRebuildCmd)
+	RootCmd.AddCommand(SyncCmd)
+	RootCmd.AddCommand(ExportCmd)
+	RootCmd.AddCommand(ImportCmd)
+	ServeCmd.Flags().BoolVar(&serveDryRun, "dry-run", false, "initialize the registry, check its dependencies and exit without serving")
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
+	GCCmd.Flags().BoolVar(&online, "online", false, "collect garbage while the registry serves requests, keeping what was referenced within the gc grace period")
+	GCCmd.Flags().BoolVar(&gcReportJSON, "json", false, "print a JSON report of the manifests and blobs deleted, or eligible for deletion with --dry-run, instead of the progress")
+	GCCmd.Flags().BoolVar(&removeUnreferencedPlatforms, "delete-unreferenced-platforms", false, "delete untagged manifest lists and image indexes along with the platform manifests only they reference")
+	GCCmd.Flags().IntVarP(&gcConcurrency, "concurrency", "c", 1, "number of repositories marked, and of blobs deleted, at once")
+	GCCmd.Flags().Float64Var(&gcRate, "rate", 0, "maximum number of manifests read and of manifests, links and blobs deleted per second, unlimited if 0")
+	ConformanceCmd.Flags().StringVarP(&conformanceUsername, "username", "u", "", "username to authenticate with the registry")
+	ConformanceCmd.Flags().StringVarP(&conformancePassword, "password", "p", "", "password to authenticate with the registry")
+	BenchCmd.Flags().StringVar(&benchOptions.repository, "repository", "", "repository to push to, defaults to a random repository under bench/")
+	BenchCmd.Flags().IntVarP(&benchOptions.concurrency, "concurrency", "c", 1, "number of pushes or pulls in flight")
+	BenchCmd.Flags().IntVar(&benchOptions.pushes, "pushes", 10, "number of images to push")
+	BenchCmd.Flags().IntVar(&benchOptions.pulls, "pulls", 10, "number of images to pull")
+	BenchCmd.Flags().StringSliceVar(&benchOptions.layerSizes, "layer-size", []string{"1MiB"}, "sizes of the pushed layers, used in turn, such as 64KiB,1MiB")
+	BenchCmd.Flags().StringVarP(&benchOptions.username, "username", "u", "", "username to authenticate with the registry")
+	BenchCmd.Flags().StringVarP(&benchOptions.password, "password", "p", "", "password to authenticate with the registry")
+	BenchCmd.Flags().BoolVar(&benchOptions.insecure, "insecure", false, "skip verification of the certificate of the registry")
+	SyncCmd.Flags().StringVar(&syncOptions.URL, "from", "", "url of the registry to synchronize from")
+	SyncCmd.Flags().StringVarP(&syncOptions.Username, "username", "u", "", "username to authenticate with the registry synchronized from")
+	SyncCmd.Flags().StringVarP(&syncOptions.Password, "password", "p", "", "password to authenticate with the registry synchronized from")
+	SyncCmd.Flags().StringSliceVar(&syncOptions.Include, "include", nil, "repositories to synchronize, as names or glob patterns such as library/*, defaults to the whole catalog")
+	SyncCmd.Flags().StringSliceVar(&syncOptions.Exclude, "exclude", nil, "repositories not to synchronize, as names or glob patterns")
+	SyncCmd.Flags().StringVar(&syncTags, "tags", "", "regular expression the synchronized tags must match")
+	SyncCmd.Flags().BoolVarP(&syncOptions.DryRun, "dry-run", "d", false, "list the tags which would be synchronized without copying them")
+	ExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "archive to write, or - for stdout")
+	ImportCmd.Flags().StringVar(&importRepository, "repository", "", "repository to import all the manifests into")
+	TierMigrateCmd.Flags().BoolVarP(&tierDryRun, "dry-run", "d", false, "list the blobs which would be migrated without migrating them")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
+	RootCmd.Flags().BoolVar(&showVersionJSON, "json", false, "print the version and build information as JSON")
 }
 
 // RootCmd is the main command for the 'registry' binary.
@@ -29,6 +73,10 @@ var RootCmd = &cobra.Command{
 	Long:  "`registry`",
 	Run: func(cmd *cobra.Command, args []string) {
 		if showVersion {
+			if showVersionJSON {
+				printVersionJSON()
+				return
+			}
 			version.PrintVersion()
 			return
 		}
@@ -36,9 +84,28 @@ var RootCmd = &cobra.Command{
 	},
 }
 
+// printVersionJSON writes the version and build information, including the
+// compiled in storage drivers, to stdout as JSON.
+func printVersionJSON() {
+	info := version.GetInfo()
+	info.StorageDrivers = factory.Drivers()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode version information: %v\n", err)
+		os.Exit(1)
+	}
+}
+
 var (
-	dryRun         bool
-	removeUntagged bool
+	dryRun                      bool
+	removeUntagged              bool
+	removeUnreferencedPlatforms bool
+	online                      bool
+	gcReportJSON                bool
+	gcConcurrency               int
+	gcRate                      float64
 )
 
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
@@ -79,13 +146,85 @@ var GCCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
-			DryRun:         dryRun,
-			RemoveUntagged: removeUntagged,
-		})
+		opts := storage.GCOpts{
+			DryRun:                      dryRun,
+			RemoveUntagged:              removeUntagged,
+			RemoveUnreferencedPlatforms: removeUnreferencedPlatforms,
+			Concurrency:                 gcConcurrency,
+			Rate:                        gcRate,
+		}
+		if online {
+			if !config.GC.Enabled {
+				fmt.Fprintln(os.Stderr, "online garbage collection requires gc to be enabled in the configuration the registry runs with")
+				os.Exit(1)
+			}
+			opts.Online = true
+			opts.GracePeriod = config.GC.GracePeriod
+			if opts.GracePeriod <= 0 {
+				opts.GracePeriod = time.Hour
+			}
+		} else if config.MetadataIndex.Enabled {
+			// the registry is stopped, so the index is not locked
+			opts.Metadata, err = storage.OpenMetadataIndex(config.MetadataIndex.Path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			defer opts.Metadata.Close()
+		}
+
+		if gcReportJSON {
+			opts.Report = &storage.GCReport{}
+			opts.Quiet = true
+		}
+
+		err = storage.MarkAndSweep(ctx, driver, registry, opts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
 			os.Exit(1)
 		}
+
+		if opts.Report != nil {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(opts.Report); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write report: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// ConfigCmd is the cobra command grouping the configuration subcommands
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "`config` inspects the registry configuration",
+	Long:  "`config` inspects the registry configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Usage()
+	},
+}
+
+// ConfigRenderCmd is the cobra command that corresponds to the config render
+// subcommand
+var ConfigRenderCmd = &cobra.Command{
+	Use:   "render <config>",
+	Short: "`render` prints the effective configuration with secrets redacted",
+	Long: "`render` prints the configuration the registry runs with, after applying " +
+		"environment variable overrides and defaults, with secrets redacted",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		out, err := configuration.MarshalRedacted(config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to render configuration: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
 	},
 }
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package registry
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleReadOnlySignals enables the read-only maintenance mode of the
+// registry on SIGUSR1 and toggles it on SIGUSR2, until the returned function
+// is called.
+func (registry *Registry) handleReadOnlySignals() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGUSR1 {
+					registry.SetReadOnly(true)
+				} else {
+					registry.ToggleReadOnly()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
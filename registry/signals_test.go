@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+)
+
+func TestReadOnlySignals(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.Admin.Enabled = true
+	config.Admin.Username = "admin"
+	config.Admin.Password = "secret"
+
+	registry, err := NewRegistry(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := registry.handleReadOnlySignals()
+	defer stop()
+
+	readOnly := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/admin/v1/readonly", nil)
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		registry.Handler().ServeHTTP(rec, req)
+		return strings.TrimSpace(rec.Body.String())
+	}
+
+	for _, tc := range []struct {
+		signal   syscall.Signal
+		expected string
+	}{
+		{syscall.SIGUSR1, `{"enabled":true}`},
+		{syscall.SIGUSR1, `{"enabled":true}`},
+		{syscall.SIGUSR2, `{"enabled":false}`},
+		{syscall.SIGUSR2, `{"enabled":true}`},
+		{syscall.SIGUSR2, `{"enabled":false}`},
+	} {
+		if err := syscall.Kill(syscall.Getpid(), tc.signal); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for readOnly() != tc.expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("read-only status not updated after %v: %s", tc.signal, readOnly())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package registry
+
+// handleReadOnlySignals is a no-op on Windows, which has no user signals. The
+// read-only mode can be toggled through the admin API instead.
+func (registry *Registry) handleReadOnlySignals() (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// AnnotationIndex indexes the manifest revisions of a repository by
+// annotation key and value, so that manifests can be looked up by their
+// metadata, such as the source revision they were built from.
+type AnnotationIndex struct {
+	driver driver.StorageDriver
+	name   string
+}
+
+// NewAnnotationIndex returns the annotation index of the named repository,
+// stored with the given driver.
+func NewAnnotationIndex(storageDriver driver.StorageDriver, name reference.Named) *AnnotationIndex {
+	return &AnnotationIndex{
+		driver: storageDriver,
+		name:   name.Name(),
+	}
+}
+
+// Add indexes the manifest revision under each of the annotations.
+func (ai *AnnotationIndex) Add(ctx context.Context, revision digest.Digest, annotations map[string]string) error {
+	for key, value := range annotations {
+		linkPath, err := pathFor(annotationIndexEntryLinkPathSpec{
+			name:     ai.name,
+			key:      key,
+			value:    value,
+			revision: revision,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := ai.driver.PutContent(ctx, linkPath, []byte(revision)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup returns the sorted manifest revisions indexed under the annotation
+// key and value. The revisions may include manifests which were deleted
+// after they were indexed.
+func (ai *AnnotationIndex) Lookup(ctx context.Context, key, value string) ([]digest.Digest, error) {
+	root, err := pathFor(annotationIndexPathSpec{
+		name:  ai.name,
+		key:   key,
+		value: value,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []digest.Digest
+	err = ai.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+
+		content, err := ai.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		revision, err := digest.Parse(string(content))
+		if err != nil {
+			return err
+		}
+		revisions = append(revisions, revision)
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i] < revisions[j] })
+	return revisions, nil
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestAnnotationIndex(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("a/b")
+	index := NewAnnotationIndex(inmemory.New(), name)
+
+	revisions, err := index.Lookup(ctx, "org.opencontainers.image.revision", "abc")
+	if err != nil {
+		t.Fatalf("unexpected error looking up empty index: %v", err)
+	}
+	if len(revisions) != 0 {
+		t.Fatalf("unexpected revisions in empty index: %v", revisions)
+	}
+
+	first := digest.FromString("first")
+	second := digest.FromString("second")
+	if err := index.Add(ctx, first, map[string]string{
+		"org.opencontainers.image.revision": "abc",
+		"org.opencontainers.image.source":   "https://example.com/a/b.git",
+	}); err != nil {
+		t.Fatalf("unexpected error indexing: %v", err)
+	}
+	if err := index.Add(ctx, second, map[string]string{
+		"org.opencontainers.image.revision": "abc",
+	}); err != nil {
+		t.Fatalf("unexpected error indexing: %v", err)
+	}
+
+	for _, tc := range []struct {
+		key, value string
+		expected   []digest.Digest
+	}{
+		{key: "org.opencontainers.image.revision", value: "abc", expected: sortedDigests(first, second)},
+		{key: "org.opencontainers.image.source", value: "https://example.com/a/b.git", expected: []digest.Digest{first}},
+		{key: "org.opencontainers.image.revision", value: "def"},
+	} {
+		revisions, err := index.Lookup(ctx, tc.key, tc.value)
+		if err != nil {
+			t.Fatalf("unexpected error looking up %s=%s: %v", tc.key, tc.value, err)
+		}
+		if !reflect.DeepEqual(revisions, tc.expected) {
+			t.Errorf("unexpected revisions for %s=%s: %v != %v", tc.key, tc.value, revisions, tc.expected)
+		}
+	}
+}
+
+func sortedDigests(a, b digest.Digest) []digest.Digest {
+	if a < b {
+		return []digest.Digest{a, b}
+	}
+	return []digest.Digest{b, a}
+}
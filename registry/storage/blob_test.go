@@ -527,6 +527,85 @@ func TestLayerUploadZeroLength(t *testing.T) {
 	simpleUpload(t, bs, []byte{}, digestSha256Empty)
 }
 
+func TestBlobUploadDigestAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := inmemory.New()
+	registry, err := NewRegistry(ctx, driver, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)), EnableDelete, EnableRedirect)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+
+	content := []byte("hashed with blake3")
+	for _, tc := range []struct {
+		name     string
+		provided digest.Digest
+		resume   bool
+	}{
+		{name: "single writer", provided: digest.BLAKE3.FromBytes(content)},
+		{name: "resumed writer", provided: digest.BLAKE3.FromBytes(content), resume: true},
+		{name: "sha256 digest", provided: digest.SHA256.FromBytes(content)},
+	} {
+		wr, err := bs.Create(ctx, WithDigestAlgorithm(digest.BLAKE3))
+		if err != nil {
+			t.Fatalf("%s: unexpected error starting upload: %v", tc.name, err)
+		}
+		if tc.resume {
+			if _, err := wr.Write(content[:5]); err != nil {
+				t.Fatalf("%s: unexpected error writing: %v", tc.name, err)
+			}
+			if err := wr.Close(); err != nil {
+				t.Fatalf("%s: unexpected error closing writer: %v", tc.name, err)
+			}
+			if wr, err = bs.Resume(ctx, wr.ID()); err != nil {
+				t.Fatalf("%s: unexpected error resuming upload: %v", tc.name, err)
+			}
+			content := content[5:]
+			if _, err := wr.Write(content); err != nil {
+				t.Fatalf("%s: unexpected error writing: %v", tc.name, err)
+			}
+		} else if _, err := wr.Write(content); err != nil {
+			t.Fatalf("%s: unexpected error writing: %v", tc.name, err)
+		}
+
+		desc, err := wr.Commit(ctx, distribution.Descriptor{Digest: tc.provided})
+		if err != nil {
+			t.Fatalf("%s: unexpected error committing upload: %v", tc.name, err)
+		}
+		if expected := digest.BLAKE3.FromBytes(content); desc.Digest != expected {
+			t.Errorf("%s: unexpected canonical digest: %v != %v", tc.name, desc.Digest, expected)
+		}
+
+		p, err := bs.Get(ctx, desc.Digest)
+		if err != nil {
+			t.Fatalf("%s: unexpected error getting blob: %v", tc.name, err)
+		}
+		if !bytes.Equal(p, content) {
+			t.Errorf("%s: unexpected content: %q", tc.name, p)
+		}
+	}
+
+	wr, err := bs.Create(ctx, WithDigestAlgorithm(digest.BLAKE3))
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if _, err := wr.Write(content); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if _, err := wr.Commit(ctx, distribution.Descriptor{Digest: digest.BLAKE3.FromString("other")}); err == nil {
+		t.Fatal("expected an error committing a mismatching digest")
+	}
+
+	if _, err := bs.Create(ctx, WithDigestAlgorithm("md5")); err != digest.ErrDigestUnsupported {
+		t.Fatalf("unexpected error starting upload with an unknown algorithm: %v", err)
+	}
+}
+
 func simpleUpload(t *testing.T, bs distribution.BlobIngester, blob []byte, expectedDigest digest.Digest) {
 	ctx := context.Background()
 	wr, err := bs.Create(ctx)
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// blobReferences records when blobs were last linked into a repository or
+// referenced by a pushed manifest, so that a garbage collection running
+// while the registry serves requests does not remove blobs which a push in
+// flight relies on.
+type blobReferences struct {
+	driver driver.StorageDriver
+}
+
+// touch records that the blob is referenced now.
+func (br *blobReferences) touch(ctx context.Context, dgst digest.Digest) error {
+	referencedAtPath, err := pathFor(blobReferencedAtPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	return br.driver.PutContent(ctx, referencedAtPath, []byte(time.Now().UTC().Format(time.RFC3339Nano)))
+}
+
+// referencedAt returns the last time the blob was referenced, falling back
+// to the time its data was written for blobs which were not referenced since
+// references are recorded. The zero time is returned for unknown blobs.
+func (br *blobReferences) referencedAt(ctx context.Context, dgst digest.Digest) (time.Time, error) {
+	var last time.Time
+
+	dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return last, err
+	}
+	fileInfo, err := br.driver.Stat(ctx, dataPath)
+	switch err.(type) {
+	case nil:
+		last = fileInfo.ModTime()
+	case driver.PathNotFoundError:
+	default:
+		return last, err
+	}
+
+	referencedAtPath, err := pathFor(blobReferencedAtPathSpec{digest: dgst})
+	if err != nil {
+		return last, err
+	}
+	content, err := br.driver.GetContent(ctx, referencedAtPath)
+	switch err.(type) {
+	case nil:
+		referencedAt, err := time.Parse(time.RFC3339Nano, string(content))
+		if err != nil {
+			return last, err
+		}
+		if referencedAt.After(last) {
+			last = referencedAt
+		}
+	case driver.PathNotFoundError:
+	default:
+		return last, err
+	}
+
+	return last, nil
+}
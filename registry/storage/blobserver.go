@@ -21,6 +21,10 @@ type blobServer struct {
 	statter  distribution.BlobStatter
 	pathFn   func(dgst digest.Digest) (string, error)
 	redirect bool // allows disabling URLFor redirects
+
+	// redirectExpiry is the lifetime of redirect URLs, left to the driver
+	// if zero.
+	redirectExpiry time.Duration
 }
 
 func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -35,7 +39,11 @@ func (bs *blobServer) ServeBlob(ctx context.Context, w http.ResponseWriter, r *h
 	}
 
 	if bs.redirect {
-		redirectURL, err := bs.driver.URLFor(ctx, path, map[string]interface{}{"method": r.Method})
+		options := map[string]interface{}{"method": r.Method}
+		if bs.redirectExpiry > 0 {
+			options["expiry"] = time.Now().Add(bs.redirectExpiry)
+		}
+		redirectURL, err := bs.driver.URLFor(ctx, path, options)
 		switch err.(type) {
 		case nil:
 			// Redirect to storage URL.
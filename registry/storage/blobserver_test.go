@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+// urlForDriver is a driver serving pre-signed URLs which records the expiry
+// requested.
+type urlForDriver struct {
+	storagedriver.StorageDriver
+	expiry interface{}
+}
+
+func (d *urlForDriver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	d.expiry = options["expiry"]
+	return "https://storage.example.com" + path, nil
+}
+
+func TestBlobServerRedirectPolicy(t *testing.T) {
+	ctx := context.Background()
+	driver := &urlForDriver{StorageDriver: inmemory.New()}
+	registry, err := NewRegistry(ctx, driver, EnableRedirect, RedirectPolicy(func(repository string) (bool, time.Duration) {
+		return repository == "public/app", 15 * time.Minute
+	}))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	content := []byte("redirected or not")
+	for _, tc := range []struct {
+		name     string
+		redirect bool
+	}{
+		{"public/app", true},
+		{"private/app", false},
+	} {
+		named, _ := reference.WithName(tc.name)
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repository: %v", err)
+		}
+		blobs := repository.Blobs(ctx)
+		desc, err := blobs.Put(ctx, "application/octet-stream", content)
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+
+		driver.expiry = nil
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if err := blobs.ServeBlob(ctx, w, r, desc.Digest); err != nil {
+			t.Fatalf("%s: unexpected error serving blob: %v", tc.name, err)
+		}
+
+		if !tc.redirect {
+			if w.Code != http.StatusOK || w.Body.String() != string(content) {
+				t.Fatalf("%s: expected the blob to be served: %d %q", tc.name, w.Code, w.Body.String())
+			}
+			continue
+		}
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("%s: expected a redirect: %d", tc.name, w.Code)
+		}
+		expiry, ok := driver.expiry.(time.Time)
+		if !ok || time.Until(expiry) < 14*time.Minute || time.Until(expiry) > 15*time.Minute {
+			t.Fatalf("%s: unexpected expiry of the redirect: %v", tc.name, driver.expiry)
+		}
+	}
+}
@@ -55,6 +55,9 @@ func (bw *blobWriter) StartedAt() time.Time {
 // Commit marks the upload as completed, returning a valid descriptor. The
 // final size and digest are checked against the first descriptor provided.
 func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("blobWriter.Commit(%q, %q)", bw.blobStore.repository.Named().Name(), desc.Digest)
+
 	dcontext.GetLogger(ctx).Debug("(*blobWriter).Commit")
 
 	if err := bw.fileWriter.Commit(); err != nil {
@@ -234,7 +237,7 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 		// the same, we don't need to read the data from the backend. This is
 		// because we've written the entire file in the lifecycle of the
 		// current instance.
-		if bw.written == size && digest.Canonical == desc.Digest.Algorithm() {
+		if bw.written == size && bw.digester.Digest().Algorithm() == desc.Digest.Algorithm() {
 			canonical = bw.digester.Digest()
 			verified = desc.Digest == canonical
 		}
@@ -243,7 +246,7 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 		// paths. We may be able to make the size-based check a stronger
 		// guarantee, so this may be defensive.
 		if !verified {
-			digester := digest.Canonical.Digester()
+			digester := bw.digester.Digest().Algorithm().Digester()
 			verifier := desc.Digest.Verifier()
 
 			// Read the file from the backend driver and validate it.
@@ -326,7 +329,7 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 			// a zero-length blob into a nonzero-length blob location. To
 			// prevent this horrid thing, we employ the hack of only allowing
 			// to this happen for the digest of an empty blob.
-			if desc.Digest == digestSha256Empty {
+			if desc.Digest == desc.Digest.Algorithm().FromBytes(nil) {
 				return bw.blobStore.driver.PutContent(ctx, blobPath, []byte{})
 			}
 
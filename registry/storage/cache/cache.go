@@ -3,6 +3,7 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/docker/distribution"
@@ -16,6 +17,13 @@ type BlobDescriptorCacheProvider interface {
 	RepositoryScoped(repo string) (distribution.BlobDescriptorService, error)
 }
 
+// Purger is implemented by BlobDescriptorCacheProviders which can drop all of
+// their cached descriptors at once.
+type Purger interface {
+	// Purge removes all descriptors from the cache.
+	Purge(ctx context.Context) error
+}
+
 // ValidateDescriptor provides a helper function to ensure that caches have
 // common criteria for admitting descriptors.
 func ValidateDescriptor(desc distribution.Descriptor) error {
@@ -19,6 +19,7 @@ func CheckBlobDescriptorCache(t *testing.T, provider cache.BlobDescriptorCachePr
 	checkBlobDescriptorCacheEmptyRepository(ctx, t, provider)
 	checkBlobDescriptorCacheSetAndRead(ctx, t, provider)
 	checkBlobDescriptorCacheClear(ctx, t, provider)
+	checkBlobDescriptorCachePurge(ctx, t, provider)
 }
 
 func checkBlobDescriptorCacheEmptyRepository(ctx context.Context, t *testing.T, provider cache.BlobDescriptorCacheProvider) {
@@ -185,3 +186,37 @@ func checkBlobDescriptorCacheClear(ctx context.Context, t *testing.T, provider c
 		t.Fatalf("expected error statting deleted blob: %v", err)
 	}
 }
+
+func checkBlobDescriptorCachePurge(ctx context.Context, t *testing.T, provider cache.BlobDescriptorCacheProvider) {
+	purger, ok := provider.(cache.Purger)
+	if !ok {
+		return
+	}
+
+	localDigest := digest.Digest("sha384:fed111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111")
+	expected := distribution.Descriptor{
+		Digest:    "sha256:fed1111111111111111111111111111111111111111111111111111111111111",
+		Size:      10,
+		MediaType: "application/octet-stream",
+	}
+
+	cache, err := provider.RepositoryScoped("foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error getting scoped cache: %v", err)
+	}
+
+	if err := cache.SetDescriptor(ctx, localDigest, expected); err != nil {
+		t.Fatalf("error setting descriptor: %v", err)
+	}
+
+	if err := purger.Purge(ctx); err != nil {
+		t.Fatalf("unexpected error purging cache: %v", err)
+	}
+
+	if _, err := cache.Stat(ctx, localDigest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected unknown blob error after purge: %v", err)
+	}
+	if _, err := provider.Stat(ctx, localDigest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected unknown blob error after purge: %v", err)
+	}
+}
@@ -33,6 +33,9 @@ func NewCachedBlobStatter(cache distribution.BlobDescriptorService, backend dist
 }
 
 func (cbds *cachedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("cachedBlobStatter.Stat(%q)", dgst)
+
 	cacheRequestCount.Inc(1)
 
 	// try getting from cache
@@ -63,6 +66,9 @@ func (cbds *cachedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (di
 }
 
 func (cbds *cachedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("cachedBlobStatter.Clear(%q)", dgst)
+
 	err := cbds.cache.Clear(ctx, dgst)
 	if err != nil {
 		return err
@@ -76,6 +82,9 @@ func (cbds *cachedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) er
 }
 
 func (cbds *cachedBlobStatter) SetDescriptor(ctx context.Context, dgst digest.Digest, desc distribution.Descriptor) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("cachedBlobStatter.SetDescriptor(%q)", dgst)
+
 	if err := cbds.cache.SetDescriptor(ctx, dgst, desc); err != nil {
 		dcontext.GetLoggerWithField(ctx, "blob", dgst).WithError(err).Error("error from cache setting desc")
 	}
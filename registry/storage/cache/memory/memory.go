@@ -111,6 +111,13 @@ func (imbdcp *inMemoryBlobDescriptorCacheProvider) SetDescriptor(ctx context.Con
 	return err
 }
 
+// Purge removes all descriptors, including the repository scoped ones, from the
+// cache.
+func (imbdcp *inMemoryBlobDescriptorCacheProvider) Purge(ctx context.Context) error {
+	imbdcp.lru.Purge()
+	return nil
+}
+
 // repositoryScopedInMemoryBlobDescriptorCache provides the request scoped
 // repository cache. Instances are not thread-safe but the delegated
 // operations are.
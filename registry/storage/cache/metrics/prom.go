@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/docker/distribution"
@@ -38,6 +39,18 @@ func (p *prometheusCacheProvider) SetDescriptor(ctx context.Context, dgst digest
 	return e
 }
 
+// Purge purges the wrapped cache, if it supports purging.
+func (p *prometheusCacheProvider) Purge(ctx context.Context) error {
+	purger, ok := p.BlobDescriptorCacheProvider.(cache.Purger)
+	if !ok {
+		return fmt.Errorf("cache: %T does not support purging", p.BlobDescriptorCacheProvider)
+	}
+	start := time.Now()
+	e := purger.Purge(ctx)
+	p.latencyTimer.WithValues("Purge").UpdateSince(start)
+	return e
+}
+
 type prometheusRepoCacheProvider struct {
 	distribution.BlobDescriptorService
 	latencyTimer metrics.LabeledTimer
@@ -0,0 +1,413 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// clusterSlots is the number of hash slots the keys of a Redis Cluster are
+// distributed over.
+const clusterSlots = 16384
+
+// maxClusterRedirects bounds the redirections followed for a single command,
+// which may happen in a row while slots are migrated or after a failover.
+const maxClusterRedirects = 5
+
+// DialFunc connects to the redis node at the address, authenticating the
+// connection if needed.
+type DialFunc func(addr string) (redis.Conn, error)
+
+// cluster is the topology of a Redis Cluster, shared by the connections
+// dialed to it.
+type cluster struct {
+	addrs []string
+	dial  DialFunc
+
+	mu    sync.RWMutex
+	slots []string // address of the master serving each slot, nil if unknown
+}
+
+// ClusterDialer returns a function dialing connections to the Redis Cluster
+// the nodes at the addresses belong to, for use as the Dial function of a
+// redis.Pool. Commands are routed to the master serving the slot of their
+// key, following the redirections of the cluster when slots move, so that
+// the cache keeps working across failovers and resharding. Commands without a
+// key are sent to any master.
+func ClusterDialer(addrs []string, dial DialFunc) func() (redis.Conn, error) {
+	c := &cluster{
+		addrs: addrs,
+		dial:  dial,
+	}
+	return func() (redis.Conn, error) {
+		conn := &clusterConn{
+			cluster: c,
+			conns:   make(map[string]redis.Conn),
+		}
+		if !c.known() {
+			if err := conn.refresh(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+}
+
+// known returns whether the slots of the cluster were discovered.
+func (c *cluster) known() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.slots != nil
+}
+
+// addr returns the address of the master serving the slot.
+func (c *cluster) addr(slot int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.slots == nil || c.slots[slot] == "" {
+		return c.addrs[0]
+	}
+	return c.slots[slot]
+}
+
+// move records the master the slot was moved to.
+func (c *cluster) move(slot int, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.slots == nil {
+		c.slots = make([]string, clusterSlots)
+	}
+	c.slots[slot] = addr
+}
+
+// masters returns the addresses of the masters, sorted, or the configured
+// addresses if the slots are unknown.
+func (c *cluster) masters() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.slots == nil {
+		return c.addrs
+	}
+
+	seen := make(map[string]struct{})
+	var masters []string
+	for _, addr := range c.slots {
+		if _, ok := seen[addr]; !ok && addr != "" {
+			seen[addr] = struct{}{}
+			masters = append(masters, addr)
+		}
+	}
+	sort.Strings(masters)
+	return masters
+}
+
+// clusterConn is a connection to a Redis Cluster, made of a connection to
+// each of the nodes commands were sent to. It does not support pipelining.
+type clusterConn struct {
+	cluster *cluster
+	conns   map[string]redis.Conn
+}
+
+var _ redis.Conn = &clusterConn{}
+
+// Close closes the connections to the nodes.
+func (cc *clusterConn) Close() error {
+	var err error
+	for addr, conn := range cc.conns {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(cc.conns, addr)
+	}
+	return err
+}
+
+// Err always returns nil, as the connections to the nodes which fail are
+// replaced on the next command.
+func (cc *clusterConn) Err() error {
+	return nil
+}
+
+// Do sends the command to the master serving its key.
+func (cc *clusterConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	switch strings.ToUpper(commandName) {
+	case "":
+		// flushes pending commands, which are never any
+		return nil, nil
+	case "SCAN":
+		return cc.scan(args...)
+	case "DEL":
+		// the keys may be in different slots
+		if len(args) > 1 {
+			var deleted int64
+			for _, key := range args {
+				n, err := redis.Int64(cc.Do(commandName, key))
+				if err != nil {
+					return nil, err
+				}
+				deleted += n
+			}
+			return deleted, nil
+		}
+	case "PING", "ECHO", "INFO", "ROLE", "CLUSTER", "FLUSHDB", "FLUSHALL", "DBSIZE":
+		return cc.do(cc.cluster.masters()[0], commandName, args...)
+	}
+
+	if len(args) == 0 {
+		return cc.do(cc.cluster.masters()[0], commandName, args...)
+	}
+	key, err := redis.String(args[0], nil)
+	if err != nil {
+		key = fmt.Sprint(args[0])
+	}
+	slot := keySlot(key)
+
+	addr := cc.cluster.addr(slot)
+	asking := false
+	for i := 0; ; i++ {
+		conn, err := cc.conn(addr)
+		if err == nil && asking {
+			_, err = conn.Do("ASKING")
+		}
+		var reply interface{}
+		if err == nil {
+			reply, err = conn.Do(commandName, args...)
+		}
+		if i == maxClusterRedirects {
+			return reply, err
+		}
+
+		if redisErr, ok := err.(redis.Error); ok {
+			fields := strings.Fields(string(redisErr))
+			if len(fields) == 3 && (fields[0] == "MOVED" || fields[0] == "ASK") {
+				asking = fields[0] == "ASK"
+				addr = fields[2]
+				if !asking {
+					cc.cluster.move(slot, addr)
+				}
+				continue
+			}
+			return reply, err
+		}
+		if err != nil {
+			// the node may have failed, so the slots are discovered
+			// again to find the replica promoted in its place
+			cc.drop(addr)
+			if rerr := cc.refresh(); rerr != nil {
+				return nil, err
+			}
+			addr = cc.cluster.addr(slot)
+			asking = false
+			continue
+		}
+		return reply, nil
+	}
+}
+
+// Send is not supported.
+func (cc *clusterConn) Send(commandName string, args ...interface{}) error {
+	return errors.New("redis: pipelining is not supported by cluster connections")
+}
+
+// Flush does nothing, as commands are never pending.
+func (cc *clusterConn) Flush() error {
+	return nil
+}
+
+// Receive is not supported.
+func (cc *clusterConn) Receive() (interface{}, error) {
+	return nil, errors.New("redis: pipelining is not supported by cluster connections")
+}
+
+// do sends the command to the node at the address.
+func (cc *clusterConn) do(addr, commandName string, args ...interface{}) (interface{}, error) {
+	conn, err := cc.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.Do(commandName, args...)
+	if conn.Err() != nil {
+		cc.drop(addr)
+	}
+	return reply, err
+}
+
+// conn returns the connection to the node at the address, dialing it if
+// needed.
+func (cc *clusterConn) conn(addr string) (redis.Conn, error) {
+	if conn, ok := cc.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := cc.cluster.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	cc.conns[addr] = conn
+	return conn, nil
+}
+
+// drop closes the connection to the node at the address.
+func (cc *clusterConn) drop(addr string) {
+	if conn, ok := cc.conns[addr]; ok {
+		conn.Close()
+		delete(cc.conns, addr)
+	}
+}
+
+// refresh discovers the masters serving the slots of the cluster, asking
+// the known masters and then the configured nodes.
+func (cc *clusterConn) refresh() error {
+	lastErr := errors.New("redis: no cluster node configured")
+	for _, addr := range append(cc.cluster.masters(), cc.cluster.addrs...) {
+		reply, err := redis.Values(cc.do(addr, "CLUSTER", "SLOTS"))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		slots, err := parseClusterSlots(addr, reply)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		cc.cluster.mu.Lock()
+		cc.cluster.slots = slots
+		cc.cluster.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("redis: unable to discover the cluster slots: %v", lastErr)
+}
+
+// parseClusterSlots returns the address of the master serving each slot from
+// the reply of CLUSTER SLOTS sent to the node at the address.
+func parseClusterSlots(addr string, reply []interface{}) ([]string, error) {
+	slots := make([]string, clusterSlots)
+	for _, entry := range reply {
+		fields, err := redis.Values(entry, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("redis: unexpected CLUSTER SLOTS entry: %v", fields)
+		}
+		start, err := redis.Int(fields[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		end, err := redis.Int(fields[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		node, err := redis.Values(fields[2], nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(node) < 2 || start < 0 || end >= clusterSlots {
+			return nil, fmt.Errorf("redis: unexpected CLUSTER SLOTS entry: %v", fields)
+		}
+		host, err := redis.String(node[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		port, err := redis.Int(node[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			// the node does not know its address, which is the one
+			// it was reached at
+			host, _, _ = net.SplitHostPort(addr)
+		}
+
+		master := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = master
+		}
+	}
+	return slots, nil
+}
+
+// scan iterates over the keys of each master in turn. The cursor is the
+// index of the master followed by the cursor on that master, such as "2.17",
+// and is "0" once every master was scanned.
+func (cc *clusterConn) scan(args ...interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errors.New("redis: SCAN requires a cursor")
+	}
+	cursor, err := redis.String(args[0], nil)
+	if err != nil {
+		cursor = fmt.Sprint(args[0])
+	}
+
+	node, nodeCursor := 0, "0"
+	if cursor != "0" {
+		parts := strings.SplitN(cursor, ".", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("redis: invalid cluster SCAN cursor %q", cursor)
+		}
+		if node, err = strconv.Atoi(parts[0]); err != nil {
+			return nil, fmt.Errorf("redis: invalid cluster SCAN cursor %q", cursor)
+		}
+		nodeCursor = parts[1]
+	}
+
+	masters := cc.cluster.masters()
+	if node >= len(masters) {
+		return []interface{}{[]byte("0"), []interface{}{}}, nil
+	}
+
+	reply, err := redis.Values(cc.do(masters[node], "SCAN", append([]interface{}{nodeCursor}, args[1:]...)...))
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 2 {
+		return nil, fmt.Errorf("unexpected SCAN reply: %v", reply)
+	}
+	next, err := redis.String(reply[0], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case next != "0":
+		next = strconv.Itoa(node) + "." + next
+	case node+1 < len(masters):
+		next = strconv.Itoa(node+1) + ".0"
+	}
+	return []interface{}{[]byte(next), reply[1]}, nil
+}
+
+// keySlot returns the hash slot of the key, which is computed from the part
+// of the key between the first braces if any, so that related keys can be
+// kept in the same slot.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 computes the CRC-16/XMODEM checksum Redis Cluster hashes keys with.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
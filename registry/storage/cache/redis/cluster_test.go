@@ -0,0 +1,319 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeNode is a redis node answering commands with a handler, speaking just
+// enough of the protocol for the tests.
+type fakeNode struct {
+	addr string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newFakeNode(t *testing.T, handle func(args []string) interface{}) *fakeNode {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	node := &fakeNode{addr: l.Addr().String()}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go node.serve(conn, handle)
+		}
+	}()
+	return node
+}
+
+func (n *fakeNode) serve(conn net.Conn, handle func(args []string) interface{}) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, count)
+		for i := range args {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			p := make([]byte, size+2)
+			if _, err := io.ReadFull(r, p); err != nil {
+				return
+			}
+			args[i] = string(p[:size])
+		}
+
+		n.mu.Lock()
+		n.commands = append(n.commands, strings.ToUpper(args[0]))
+		n.mu.Unlock()
+
+		writeReply(conn, handle(args))
+	}
+}
+
+func (n *fakeNode) count(command string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	count := 0
+	for _, c := range n.commands {
+		if c == command {
+			count++
+		}
+	}
+	return count
+}
+
+func writeReply(w io.Writer, reply interface{}) {
+	switch reply := reply.(type) {
+	case nil:
+		fmt.Fprint(w, "$-1\r\n")
+	case redis.Error:
+		fmt.Fprintf(w, "-%s\r\n", reply)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", reply)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(reply), reply)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(reply))
+		for _, item := range reply {
+			writeReply(w, item)
+		}
+	}
+}
+
+func splitHostPort(t *testing.T, addr string) (string, int) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func dialNode(addr string) (redis.Conn, error) {
+	return redis.Dial("tcp", addr)
+}
+
+func TestKeySlot(t *testing.T) {
+	if crc := crc16("123456789"); crc != 0x31c3 {
+		t.Fatalf("unexpected checksum: %#x", crc)
+	}
+	if slot := keySlot("foo"); slot != 12182 {
+		t.Fatalf("unexpected slot of foo: %d", slot)
+	}
+	if keySlot("{repository::foo}::blobs") != keySlot("repository::foo") {
+		t.Fatal("expected keys with the same hash tag to be in the same slot")
+	}
+	if keySlot("{}foo") != keySlot("{}foo") || keySlot("{}foo") == keySlot("") {
+		t.Fatal("expected empty hash tags to be ignored")
+	}
+}
+
+func TestClusterConn(t *testing.T) {
+	// each node serves half of the slots, and stores the keys of its slots
+	var nodes [2]*fakeNode
+	var stores [2]map[string]string
+	var mu sync.Mutex
+	owner := func(key string) int { return keySlot(key) * 2 / clusterSlots }
+
+	for i := range nodes {
+		i := i
+		stores[i] = make(map[string]string)
+		nodes[i] = newFakeNode(t, func(args []string) interface{} {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch strings.ToUpper(args[0]) {
+			case "CLUSTER":
+				var slots []interface{}
+				for j, node := range nodes {
+					host, port := splitHostPort(t, node.addr)
+					slots = append(slots, []interface{}{j * clusterSlots / 2, (j+1)*clusterSlots/2 - 1, []interface{}{host, port}})
+				}
+				return slots
+			case "PING":
+				return "PONG"
+			case "SCAN":
+				var keys []interface{}
+				for key := range stores[i] {
+					keys = append(keys, key)
+				}
+				return []interface{}{"0", keys}
+			}
+
+			if o := owner(args[1]); o != i {
+				return redis.Error(fmt.Sprintf("MOVED %d %s", keySlot(args[1]), nodes[o].addr))
+			}
+			switch strings.ToUpper(args[0]) {
+			case "SET":
+				stores[i][args[1]] = args[2]
+				return "OK"
+			case "GET":
+				if v, ok := stores[i][args[1]]; ok {
+					return v
+				}
+				return nil
+			case "DEL":
+				if _, ok := stores[i][args[1]]; ok {
+					delete(stores[i], args[1])
+					return 1
+				}
+				return 0
+			}
+			return redis.Error("ERR unknown command")
+		})
+	}
+
+	pool := &redis.Pool{Dial: ClusterDialer([]string{nodes[1].addr}, dialNode)}
+	defer pool.Close()
+	conn := pool.Get()
+	defer conn.Close()
+
+	keys := []string{"blobs::a", "blobs::b", "blobs::c", "blobs::d", "blobs::e", "blobs::f"}
+	for _, key := range keys {
+		if _, err := conn.Do("SET", key, "value of "+key); err != nil {
+			t.Fatalf("unexpected error setting %s: %v", key, err)
+		}
+	}
+	for _, key := range keys {
+		v, err := redis.String(conn.Do("GET", key))
+		if err != nil || v != "value of "+key {
+			t.Fatalf("unexpected value of %s: %q, %v", key, v, err)
+		}
+		mu.Lock()
+		_, ok := stores[owner(key)][key]
+		mu.Unlock()
+		if !ok {
+			t.Fatalf("expected %s to be stored on node %d", key, owner(key))
+		}
+	}
+	if _, err := redis.String(conn.Do("PING")); err != nil {
+		t.Fatalf("unexpected error pinging: %v", err)
+	}
+
+	// the slots are known, so no command was redirected
+	if nodes[0].count("SET")+nodes[1].count("SET") != len(keys) {
+		t.Fatalf("unexpected redirected commands: %d", nodes[0].count("SET")+nodes[1].count("SET")-len(keys))
+	}
+
+	// the keys of each master are scanned in turn
+	var scanned []string
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", "blobs::*"))
+		if err != nil {
+			t.Fatalf("unexpected error scanning: %v", err)
+		}
+		cursor, _ = redis.String(reply[0], nil)
+		found, _ := redis.Strings(reply[1], nil)
+		scanned = append(scanned, found...)
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(scanned)
+	if strings.Join(scanned, ",") != strings.Join(keys, ",") {
+		t.Fatalf("unexpected keys scanned: %v", scanned)
+	}
+
+	// keys of different slots are deleted one by one
+	deleted, err := redis.Int(conn.Do("DEL", "blobs::a", "blobs::b", "blobs::unknown"))
+	if err != nil || deleted != 2 {
+		t.Fatalf("unexpected deletion: %d, %v", deleted, err)
+	}
+}
+
+func TestClusterConnFollowsMovedSlots(t *testing.T) {
+	target := newFakeNode(t, func(args []string) interface{} {
+		return "OK"
+	})
+	var source *fakeNode
+	source = newFakeNode(t, func(args []string) interface{} {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			host, port := splitHostPort(t, source.addr)
+			return []interface{}{[]interface{}{0, clusterSlots - 1, []interface{}{host, port}}}
+		}
+		return redis.Error(fmt.Sprintf("MOVED %d %s", keySlot(args[1]), target.addr))
+	})
+
+	conn, err := ClusterDialer([]string{source.addr}, dialNode)()
+	if err != nil {
+		t.Fatalf("unexpected error dialing: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Do("SET", "key", "value"); err != nil {
+			t.Fatalf("unexpected error setting key: %v", err)
+		}
+	}
+	if source.count("SET") != 1 || target.count("SET") != 2 {
+		t.Fatalf("expected the moved slot to be remembered: %d, %d", source.count("SET"), target.count("SET"))
+	}
+}
+
+func TestSentinelDialer(t *testing.T) {
+	var role atomic.Value
+	role.Store("master")
+	master := newFakeNode(t, func(args []string) interface{} {
+		switch strings.ToUpper(args[0]) {
+		case "ROLE":
+			return []interface{}{role.Load()}
+		}
+		return "PONG"
+	})
+	sentinel := newFakeNode(t, func(args []string) interface{} {
+		if len(args) == 3 && args[2] == "registry" {
+			host, port := splitHostPort(t, master.addr)
+			return []interface{}{host, strconv.Itoa(port)}
+		}
+		return nil
+	})
+	unreachable := "127.0.0.1:1"
+
+	conn, err := SentinelDialer("registry", []string{unreachable, sentinel.addr}, dialNode, dialNode)()
+	if err != nil {
+		t.Fatalf("unexpected error dialing: %v", err)
+	}
+	if err := TestMaster(conn, time.Time{}); err != nil {
+		t.Fatalf("unexpected error testing master: %v", err)
+	}
+
+	// connections to a demoted master are not reused
+	role.Store("slave")
+	if err := TestMaster(conn, time.Time{}); err == nil {
+		t.Fatal("expected an error testing a replica")
+	}
+	conn.Close()
+
+	if _, err := SentinelDialer("unknown", []string{sentinel.addr}, dialNode, dialNode)(); err == nil {
+		t.Fatal("expected an error dialing an unknown master")
+	}
+}
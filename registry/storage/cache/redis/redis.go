@@ -150,6 +150,46 @@ func (rbds *redisBlobDescriptorService) blobDescriptorHashKey(dgst digest.Digest
 	return "blobs::" + dgst.String()
 }
 
+// Purge deletes the global and repository scoped blob descriptors and the
+// repository membership sets from redis. Other keys are left alone, as the
+// redis instance may be shared.
+func (rbds *redisBlobDescriptorService) Purge(ctx context.Context) error {
+	conn := rbds.pool.Get()
+	defer conn.Close()
+
+	for _, pattern := range []string{"blobs::*", "repository::*"} {
+		// the cursor is opaque, as cluster connections scan each master
+		cursor := "0"
+		for {
+			reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 1000))
+			if err != nil {
+				return err
+			}
+
+			if len(reply) != 2 {
+				return fmt.Errorf("unexpected SCAN reply: %v", reply)
+			}
+			if cursor, err = redis.String(reply[0], nil); err != nil {
+				return err
+			}
+			keys, err := redis.Values(reply[1], nil)
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				if _, err := conn.Do("DEL", keys...); err != nil {
+					return err
+				}
+			}
+
+			if cursor == "0" {
+				break
+			}
+		}
+	}
+	return nil
+}
+
 type repositoryScopedRedisBlobDescriptorService struct {
 	repo     string
 	upstream *redisBlobDescriptorService
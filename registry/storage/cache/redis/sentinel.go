@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// SentinelDialer returns a function dialing connections to the current
+// master of a Redis deployment monitored by Redis Sentinel, for use as the
+// Dial function of a redis.Pool. The master is looked up from the sentinels
+// at the addresses, in order, each time a connection is dialed, so that new
+// connections go to the replica promoted by a failover.
+func SentinelDialer(master string, sentinels []string, dialSentinel, dial DialFunc) func() (redis.Conn, error) {
+	return func() (redis.Conn, error) {
+		addr, err := sentinelMaster(master, sentinels, dialSentinel)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		// the sentinels may not have noticed a failover yet
+		if err := TestMaster(conn, time.Now()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// sentinelMaster returns the address of the master the first sentinel
+// reachable knows of.
+func sentinelMaster(master string, sentinels []string, dialSentinel DialFunc) (string, error) {
+	lastErr := fmt.Errorf("redis: no sentinel configured")
+	for _, sentinel := range sentinels {
+		conn, err := dialSentinel(sentinel)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reply, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", master))
+		conn.Close()
+		if err == redis.ErrNil {
+			lastErr = fmt.Errorf("redis: sentinel %s does not monitor master %q", sentinel, master)
+			continue
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(reply) != 2 {
+			lastErr = fmt.Errorf("redis: unexpected reply from sentinel %s: %v", sentinel, reply)
+			continue
+		}
+		return net.JoinHostPort(reply[0], reply[1]), nil
+	}
+	return "", fmt.Errorf("redis: unable to look up master %q: %v", master, lastErr)
+}
+
+// TestMaster returns an error if the connection is not to a master, for use
+// as the TestOnBorrow function of a redis.Pool dialing with SentinelDialer:
+// connections to a master demoted by a failover are then closed instead of
+// reused.
+func TestMaster(c redis.Conn, t time.Time) error {
+	reply, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(reply) == 0 {
+		return fmt.Errorf("redis: unexpected ROLE reply: %v", reply)
+	}
+	role, err := redis.String(reply[0], nil)
+	if err != nil {
+		return err
+	}
+	if role != "master" {
+		return fmt.Errorf("redis: node is a %s, not a master", role)
+	}
+	return nil
+}
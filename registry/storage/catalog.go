@@ -21,6 +21,9 @@ func (reg *registry) Repositories(ctx context.Context, repos []string, last stri
 	if len(repos) == 0 {
 		return 0, errors.New("no space in slice")
 	}
+	if reg.metadata.ready() {
+		return reg.metadata.Repositories(repos, last)
+	}
 
 	root, err := pathFor(repositoriesRootPathSpec{})
 	if err != nil {
@@ -78,7 +81,13 @@ func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
 		return err
 	}
 	repoDir := path.Join(root, name.Name())
-	return reg.driver.Delete(ctx, repoDir)
+	if err := reg.driver.Delete(ctx, repoDir); err != nil {
+		return err
+	}
+	if reg.metadata != nil {
+		return reg.metadata.DeleteRepository(name.Name())
+	}
+	return nil
 }
 
 // lessPath returns true if one path a is less than path b.
@@ -286,7 +286,7 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 // Move moves an object stored at sourcePath to destPath, removing the original
 // object.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
-	sourceBlobURL, err := d.URLFor(ctx, sourcePath, nil)
+	sourceBlobURL, err := d.signedURL(ctx, sourcePath, time.Now().UTC().Add(20*time.Minute))
 	if err != nil {
 		return err
 	}
@@ -369,7 +369,13 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 // URLFor returns a publicly accessible URL for the blob stored at given path
 // for specified duration by making use of Azure Storage Shared Access Signatures (SAS).
 // See https://msdn.microsoft.com/en-us/library/azure/ee395415.aspx for more info.
+// URLs are not provided when the driver authenticates with a SAS token, as
+// they would disclose the token.
 func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if _, ok := d.azClient.signer.(*sasTokenSigner); ok {
+		return "", storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+	}
+
 	expiresTime := time.Now().UTC().Add(20 * time.Minute) // default expiration
 	expires, ok := options["expiry"]
 	if ok {
@@ -378,8 +384,13 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 			expiresTime = t
 		}
 	}
-	blobName := d.blobName(path)
-	blobRef := d.client.NewBlobClient(blobName)
+	return d.signedURL(ctx, path, expiresTime)
+}
+
+// signedURL returns the URL of the blob stored at given path, signed until
+// expiresTime.
+func (d *driver) signedURL(ctx context.Context, path string, expiresTime time.Time) (string, error) {
+	blobRef := d.client.NewBlobClient(d.blobName(path))
 	return d.azClient.SignBlobURL(ctx, blobRef.URL(), expiresTime)
 }
 
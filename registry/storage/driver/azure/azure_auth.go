@@ -2,10 +2,16 @@ package azure
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
@@ -17,6 +23,10 @@ import (
 const (
 	UDCGracePeriod = 30.0 * time.Minute
 	UDCExpiryTime  = 48.0 * time.Hour
+
+	// sasTokenFileCheckInterval is the interval at which the SAS token file
+	// is checked for changes.
+	sasTokenFileCheckInterval = time.Minute
 )
 
 // signer abstracts the specifics of a blob SAS and is specialized
@@ -37,6 +47,12 @@ type clientTokenSigner struct {
 	udcExpiry time.Time
 }
 
+// sasTokenSigner signs blob urls with the configured SAS token rather than
+// generating a signature, so the signed urls are only used by the driver.
+type sasTokenSigner struct {
+	source *sasTokenSource
+}
+
 // azureClient abstracts signing blob urls for a container since the
 // azure apis have completely different underlying authentication apis
 type azureClient struct {
@@ -65,17 +81,32 @@ func newAzureClient(params *Parameters) (*azureClient, error) {
 		}, nil
 	}
 
+	if params.Credentials.Type == credentialsTypeSASToken {
+		return newSASTokenClient(params)
+	}
+
 	var cred azcore.TokenCredential
 	var err error
-	if params.Credentials.Type == "client_secret" {
-		creds := &params.Credentials
-		if cred, err = azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.Secret, nil); err != nil {
-			return nil, err
+	creds := &params.Credentials
+	switch creds.Type {
+	case credentialsTypeClientSecret:
+		cred, err = azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.Secret, nil)
+	case credentialsTypeManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if creds.ClientID != "" {
+			options.ID = azidentity.ClientID(creds.ClientID)
+		} else if creds.ResourceID != "" {
+			options.ID = azidentity.ResourceID(creds.ResourceID)
 		}
-	} else if cred, err = azidentity.NewDefaultAzureCredential(nil); err != nil {
+		cred, err = azidentity.NewManagedIdentityCredential(options)
+	default:
+		cred, err = azidentity.NewDefaultAzureCredential(nil)
+	}
+	if err != nil {
 		return nil, err
 	}
 
+	// The client refreshes the tokens of the credential before they expire.
 	client, err := azblob.NewClient(params.ServiceURL, cred, nil)
 	if err != nil {
 		return nil, err
@@ -91,6 +122,33 @@ func newAzureClient(params *Parameters) (*azureClient, error) {
 	}, nil
 }
 
+// newSASTokenClient returns a client authenticating its requests with the
+// configured shared access signature token.
+func newSASTokenClient(params *Parameters) (*azureClient, error) {
+	source := &sasTokenSource{
+		token: params.Credentials.SASToken,
+		path:  params.Credentials.SASTokenFile,
+	}
+	if _, err := source.Token(); err != nil {
+		return nil, err
+	}
+
+	options := &azblob.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			PerRetryPolicies: []policy.Policy{&sasTokenPolicy{source: source}},
+		},
+	}
+	client, err := azblob.NewClientWithNoCredential(params.ServiceURL, options)
+	if err != nil {
+		return nil, err
+	}
+	return &azureClient{
+		container: params.Container,
+		client:    client,
+		signer:    &sasTokenSigner{source: source},
+	}, nil
+}
+
 func (a *azureClient) ContainerClient() *container.Client {
 	return a.client.ServiceClient().NewContainerClient(a.container)
 }
@@ -150,3 +208,93 @@ func (s *clientTokenSigner) Sign(ctx context.Context, signatureValues *sas.BlobS
 	}
 	return signatureValues.SignWithUserDelegation(udc)
 }
+
+func (s *sasTokenSigner) Sign(ctx context.Context, signatureValues *sas.BlobSignatureValues) (sas.QueryParameters, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return sas.QueryParameters{}, err
+	}
+	urlParts, err := sas.ParseURL("https://localhost/?" + token)
+	if err != nil {
+		return sas.QueryParameters{}, err
+	}
+	return urlParts.SAS, nil
+}
+
+// sasTokenSource provides the configured SAS token, reading it again from
+// its file when the file changes so that rotated tokens are picked up
+// without a restart.
+type sasTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	checked time.Time
+}
+
+// Token returns the current SAS token, without a leading "?".
+func (s *sasTokenSource) Token() (string, error) {
+	if s.path == "" {
+		return strings.TrimPrefix(s.token, "?"), nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != "" && now.Sub(s.checked) < sasTokenFileCheckInterval {
+		return s.token, nil
+	}
+	s.checked = now
+
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		if s.token != "" {
+			// keep using the last token until the file is restored
+			return s.token, nil
+		}
+		return "", fmt.Errorf("reading SAS token: %v", err)
+	}
+	if s.token != "" && fi.ModTime().Equal(s.modTime) {
+		return s.token, nil
+	}
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if s.token != "" {
+			return s.token, nil
+		}
+		return "", fmt.Errorf("reading SAS token: %v", err)
+	}
+	token := strings.TrimPrefix(strings.TrimSpace(string(b)), "?")
+	if token == "" {
+		return "", fmt.Errorf("reading SAS token: %s is empty", s.path)
+	}
+	s.token = token
+	s.modTime = fi.ModTime()
+	return s.token, nil
+}
+
+// sasTokenPolicy adds the parameters of the SAS token to the query of each
+// request.
+type sasTokenPolicy struct {
+	source *sasTokenSource
+}
+
+func (p *sasTokenPolicy) Do(req *policy.Request) (*http.Response, error) {
+	token, err := p.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	params, err := url.ParseQuery(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAS token: %v", err)
+	}
+	raw := req.Raw()
+	query := raw.URL.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	raw.URL.RawQuery = query.Encode()
+	return req.Next()
+}
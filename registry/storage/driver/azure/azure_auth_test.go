@@ -0,0 +1,126 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestNewParametersCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		credentials map[string]interface{}
+		valid       bool
+	}{
+		{nil, true},
+		{map[string]interface{}{"type": "default_credentials"}, true},
+		{map[string]interface{}{"type": "client_secret", "clientid": "id", "tenantid": "tenant", "secret": "secret"}, true},
+		{map[string]interface{}{"type": "client_secret", "clientid": "id"}, false},
+		{map[string]interface{}{"type": "managed_identity"}, true},
+		{map[string]interface{}{"type": "managed_identity", "clientid": "id"}, true},
+		{map[string]interface{}{"type": "managed_identity", "clientid": "id", "resourceid": "resource"}, false},
+		{map[string]interface{}{"type": "sas_token", "sastoken": "sv=2022-11-02&sig=abc"}, true},
+		{map[string]interface{}{"type": "sas_token", "sastokenfile": "/run/secrets/sas"}, true},
+		{map[string]interface{}{"type": "sas_token"}, false},
+		{map[string]interface{}{"type": "sas_token", "sastoken": "sig=abc", "sastokenfile": "/run/secrets/sas"}, false},
+		{map[string]interface{}{"type": "password"}, false},
+	} {
+		parameters := map[string]interface{}{
+			"accountname": "account",
+			"container":   "registry",
+		}
+		if tc.credentials != nil {
+			parameters["credentials"] = tc.credentials
+		}
+		_, err := NewParameters(parameters)
+		if tc.valid && err != nil {
+			t.Errorf("unexpected error for %v: %v", tc.credentials, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected error for %v", tc.credentials)
+		}
+	}
+}
+
+func TestSASTokenSourceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sas")
+	source := &sasTokenSource{path: path}
+	if _, err := source.Token(); err == nil {
+		t.Fatal("expected error reading missing token file")
+	}
+
+	if err := os.WriteFile(path, []byte("?sv=1&sig=first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	token, err := source.Token()
+	if err != nil || token != "sv=1&sig=first" {
+		t.Fatalf("unexpected token %q: %v", token, err)
+	}
+
+	if err := os.WriteFile(path, []byte("sv=1&sig=second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// make the change visible regardless of the resolution of file times
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := source.Token(); token != "sv=1&sig=first" {
+		t.Fatalf("expected token file not to be checked again yet, got %q", token)
+	}
+	source.checked = time.Time{}
+	if token, _ := source.Token(); token != "sv=1&sig=second" {
+		t.Fatalf("expected rotated token, got %q", token)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	source.checked = time.Time{}
+	if token, err := source.Token(); err != nil || token != "sv=1&sig=second" {
+		t.Fatalf("expected last token to be kept, got %q: %v", token, err)
+	}
+}
+
+func TestSASTokenDriver(t *testing.T) {
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	params, err := NewParameters(map[string]interface{}{
+		"accountname": "account",
+		"container":   "registry",
+		"serviceurl":  server.URL,
+		"credentials": map[string]interface{}{
+			"type":     "sas_token",
+			"sastoken": "?sv=2022-11-02&sp=rwdl&sig=abc",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.GetContent(context.Background(), "/missing")
+	if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatalf("expected path not found error, got %v", err)
+	}
+	if len(query["sig"]) != 1 || query["sig"][0] != "abc" || query["sv"][0] != "2022-11-02" {
+		t.Fatalf("expected SAS token in request query, got %v", query)
+	}
+
+	if _, err := d.URLFor(context.Background(), "/missing", nil); err == nil {
+		t.Fatal("expected URLFor to be unsupported with a SAS token")
+	}
+}
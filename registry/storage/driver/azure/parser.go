@@ -13,11 +13,31 @@ const (
 	defaultCopyStatusPollDelay    = "100ms"
 )
 
+const (
+	credentialsTypeClientSecret       = "client_secret"
+	credentialsTypeManagedIdentity    = "managed_identity"
+	credentialsTypeSASToken           = "sas_token"
+	credentialsTypeDefaultCredentials = "default_credentials"
+)
+
+// Credentials configures the authentication of the driver when no account
+// key is set. Type is one of "client_secret", "managed_identity",
+// "sas_token" or "default_credentials", the default, which is also accepted
+// as "default".
 type Credentials struct {
 	Type     string `mapstructure:"type"`
 	ClientID string `mapstructure:"clientid"`
 	TenantID string `mapstructure:"tenantid"`
 	Secret   string `mapstructure:"secret"`
+	// ResourceID identifies a user-assigned managed identity by its
+	// resource ID rather than ClientID.
+	ResourceID string `mapstructure:"resourceid"`
+	// SASToken is a shared access signature token of the account or
+	// container.
+	SASToken string `mapstructure:"sastoken"`
+	// SASTokenFile is the path of a file holding the shared access
+	// signature token, which is read again when the file changes.
+	SASTokenFile string `mapstructure:"sastokenfile"`
 }
 
 type Parameters struct {
@@ -46,6 +66,11 @@ func NewParameters(parameters map[string]interface{}) (*Parameters, error) {
 	if params.Container == "" {
 		return nil, errors.New("no container parameter provider")
 	}
+	if params.AccountKey == "" {
+		if err := params.Credentials.validate(); err != nil {
+			return nil, err
+		}
+	}
 	if params.ServiceURL == "" {
 		params.ServiceURL = fmt.Sprintf("https://%s.blob.%s", params.AccountName, params.Realm)
 	}
@@ -57,3 +82,25 @@ func NewParameters(parameters map[string]interface{}) (*Parameters, error) {
 	}
 	return &params, nil
 }
+
+// validate checks the parameters of the credentials type.
+func (c *Credentials) validate() error {
+	switch c.Type {
+	case "", "default", credentialsTypeDefaultCredentials:
+	case credentialsTypeClientSecret:
+		if c.ClientID == "" || c.TenantID == "" || c.Secret == "" {
+			return errors.New("client_secret credentials require clientid, tenantid and secret parameters")
+		}
+	case credentialsTypeManagedIdentity:
+		if c.ClientID != "" && c.ResourceID != "" {
+			return errors.New("managed_identity credentials accept only one of clientid and resourceid parameters")
+		}
+	case credentialsTypeSASToken:
+		if (c.SASToken == "") == (c.SASTokenFile == "") {
+			return errors.New("sas_token credentials require exactly one of sastoken and sastokenfile parameters")
+		}
+	default:
+		return fmt.Errorf("unknown credentials type %q", c.Type)
+	}
+	return nil
+}
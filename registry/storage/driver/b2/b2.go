@@ -0,0 +1,767 @@
+// Package b2 provides a storagedriver.StorageDriver implementation to
+// store blobs in Backblaze B2 cloud storage, using the native B2 API.
+//
+// Because B2 is a key, value store the Stat call does not support last
+// modification time for directories (directories are an abstraction for key,
+// value stores).
+//
+// B2 keeps the previous versions of overwritten files. Deleted files are
+// either removed with all their versions, or hidden so that the lifecycle
+// rules of the bucket decide when their versions are removed.
+package b2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/base"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+)
+
+const driverName = "b2"
+
+// minChunkSize is the minimum size of the parts of large files, except the
+// last one.
+const minChunkSize = 5 << 20
+
+// maxChunkSize is the maximum size of a part, and of a file uploaded or
+// copied at once.
+const maxChunkSize int64 = 5 << 30
+
+const (
+	defaultChunkSize = 2 * minChunkSize
+	defaultAPIURL    = "https://api.backblazeb2.com"
+
+	// maxParts is the maximum number of parts of a large file.
+	maxParts = 10000
+
+	// maxDownloadAuthorization is the longest duration a download
+	// authorization may be valid for.
+	maxDownloadAuthorization = 7 * 24 * time.Hour
+)
+
+const (
+	// deleteModeDelete deletes all the versions of deleted files.
+	deleteModeDelete = "delete"
+	// deleteModeHide hides deleted files, leaving their removal to the
+	// lifecycle rules of the bucket.
+	deleteModeHide = "hide"
+)
+
+// DriverParameters A struct that encapsulates all of the driver parameters after all values have been set
+type DriverParameters struct {
+	KeyID          string
+	ApplicationKey string
+	Bucket         string
+	RootDirectory  string
+	ChunkSize      int64
+	DeleteMode     string
+	APIURL         string
+}
+
+func init() {
+	factory.Register(driverName, &b2DriverFactory{})
+}
+
+// b2DriverFactory implements the factory.StorageDriverFactory interface
+type b2DriverFactory struct{}
+
+func (factory *b2DriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+var _ storagedriver.StorageDriver = &driver{}
+
+type driver struct {
+	client        *client
+	ChunkSize     int64
+	DeleteMode    string
+	RootDirectory string
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation backed by Backblaze
+// B2. Objects are stored at absolute keys in the provided bucket.
+type Driver struct {
+	baseEmbed
+}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - keyid
+// - applicationkey
+// - bucket
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	keyID, ok := parameters["keyid"]
+	if !ok || fmt.Sprint(keyID) == "" {
+		return nil, fmt.Errorf("no keyid parameter provided")
+	}
+	applicationKey, ok := parameters["applicationkey"]
+	if !ok || fmt.Sprint(applicationKey) == "" {
+		return nil, fmt.Errorf("no applicationkey parameter provided")
+	}
+	bucket, ok := parameters["bucket"]
+	if !ok || fmt.Sprint(bucket) == "" {
+		return nil, fmt.Errorf("no bucket parameter provided")
+	}
+
+	chunkSize := int64(defaultChunkSize)
+	if chunkSizeParam, ok := parameters["chunksize"]; ok {
+		switch v := chunkSizeParam.(type) {
+		case string:
+			vv, err := strconv.ParseInt(v, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("chunksize parameter must be an integer, %v invalid", chunkSizeParam)
+			}
+			chunkSize = vv
+		case int64:
+			chunkSize = v
+		case int, uint, int32, uint32, uint64:
+			chunkSize = reflect.ValueOf(v).Convert(reflect.TypeOf(chunkSize)).Int()
+		default:
+			return nil, fmt.Errorf("invalid value for chunksize: %#v", chunkSizeParam)
+		}
+		if chunkSize < minChunkSize || chunkSize > maxChunkSize {
+			return nil, fmt.Errorf("the chunksize %#v parameter should be a number between %d and %d (inclusive)", chunkSize, minChunkSize, maxChunkSize)
+		}
+	}
+
+	deleteMode := deleteModeDelete
+	if deleteModeParam, ok := parameters["deletemode"]; ok && fmt.Sprint(deleteModeParam) != "" {
+		deleteMode = fmt.Sprint(deleteModeParam)
+		if deleteMode != deleteModeDelete && deleteMode != deleteModeHide {
+			return nil, fmt.Errorf("the deletemode parameter should be %q or %q, %q invalid", deleteModeDelete, deleteModeHide, deleteMode)
+		}
+	}
+
+	rootDirectory, ok := parameters["rootdirectory"]
+	if !ok {
+		rootDirectory = ""
+	}
+
+	apiURL, ok := parameters["apiurl"]
+	if !ok || fmt.Sprint(apiURL) == "" {
+		apiURL = defaultAPIURL
+	}
+
+	params := DriverParameters{
+		KeyID:          fmt.Sprint(keyID),
+		ApplicationKey: fmt.Sprint(applicationKey),
+		Bucket:         fmt.Sprint(bucket),
+		RootDirectory:  fmt.Sprint(rootDirectory),
+		ChunkSize:      chunkSize,
+		DeleteMode:     deleteMode,
+		APIURL:         fmt.Sprint(apiURL),
+	}
+
+	return New(params)
+}
+
+// New constructs a new Driver with the given B2 application key and bucket,
+// authorizing the key.
+func New(params DriverParameters) (*Driver, error) {
+	if params.ChunkSize == 0 {
+		params.ChunkSize = defaultChunkSize
+	}
+	if params.DeleteMode == "" {
+		params.DeleteMode = deleteModeDelete
+	}
+	if params.APIURL == "" {
+		params.APIURL = defaultAPIURL
+	}
+
+	client, err := newClient(context.Background(), params)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &driver{
+		client:        client,
+		ChunkSize:     params.ChunkSize,
+		DeleteMode:    params.DeleteMode,
+		RootDirectory: params.RootDirectory,
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+	}, nil
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	reader, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	if int64(len(contents)) > maxChunkSize {
+		return fmt.Errorf("uploading %d bytes with PutContent is not supported; limit: %d bytes", len(contents), maxChunkSize)
+	}
+	_, err := d.client.uploadFile(ctx, d.b2Path(path), contents)
+	return err
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	resp, err := d.client.download(ctx, d.b2Path(path), offset)
+	if err != nil {
+		if e, ok := err.(*apiError); ok && e.Status == http.StatusRequestedRangeNotSatisfiable {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return nil, parseError(path, err)
+	}
+	return resp.Body, nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	key := d.b2Path(path)
+	large, err := d.unfinishedLargeFiles(ctx, key)
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+
+	if !append {
+		for _, f := range large {
+			if err := d.client.cancelLargeFile(ctx, f.FileID); err != nil && !isNotFound(err) {
+				return nil, err
+			}
+		}
+		return d.newWriter(ctx, key), nil
+	}
+
+	w := d.newWriter(ctx, key)
+	latest, err := d.latestFile(ctx, key)
+	if err != nil {
+		return nil, parseError(path, err)
+	}
+	if len(large) > 0 {
+		// resume the most recent upload
+		w.large = &large[len(large)-1]
+		w.parts, err = d.client.listParts(ctx, w.large.FileID)
+		if err != nil {
+			return nil, parseError(path, err)
+		}
+		for _, p := range w.parts {
+			w.size += p.ContentLength
+		}
+		// the tail of the upload is stored as a file at the key, uploaded
+		// after the upload started
+		if latest != nil && latest.UploadTimestamp < w.large.UploadTimestamp {
+			latest = nil
+		}
+	} else if latest == nil || latest.ContentLength > d.ChunkSize {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+
+	if latest != nil {
+		if err := w.loadTail(latest); err != nil {
+			return nil, parseError(path, err)
+		}
+	}
+	return w, nil
+}
+
+// unfinishedLargeFiles returns the large files being uploaded at the key,
+// oldest first.
+func (d *driver) unfinishedLargeFiles(ctx context.Context, key string) ([]file, error) {
+	files, err := d.client.listUnfinishedLargeFiles(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var large []file
+	for _, f := range files {
+		if f.FileName == key {
+			large = append(large, f)
+		}
+	}
+	return large, nil
+}
+
+// latestFile returns the latest version of the file at the key, or nil if
+// there is none.
+func (d *driver) latestFile(ctx context.Context, key string) (*file, error) {
+	files, _, err := d.client.listFileNames(ctx, key, "", key, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 || files[0].FileName != key {
+		return nil, nil
+	}
+	return &files[0], nil
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	key := d.b2Path(path)
+	fi := storagedriver.FileInfoFields{
+		Path: path,
+	}
+
+	f, err := d.latestFile(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		fi.Size = f.ContentLength
+		fi.ModTime = f.modTime()
+		return storagedriver.FileInfoInternal{FileInfoFields: fi}, nil
+	}
+
+	files, _, err := d.client.listFileNames(ctx, strings.TrimLeft(key+"/", "/"), "", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	fi.IsDir = true
+	return storagedriver.FileInfoInternal{FileInfoFields: fi}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given path.
+func (d *driver) List(ctx context.Context, opath string) ([]string, error) {
+	path := opath
+	if path != "/" && path[len(path)-1] != '/' {
+		path = path + "/"
+	}
+	prefix := d.b2Path(path)
+
+	files := []string{}
+	directories := []string{}
+	start := ""
+	for {
+		page, next, err := d.client.listFileNames(ctx, prefix, "/", start, listMax)
+		if err != nil {
+			return nil, parseError(opath, err)
+		}
+		for _, f := range page {
+			if f.Action == "folder" {
+				directories = append(directories, d.keyToPath(strings.TrimSuffix(f.FileName, "/")))
+			} else {
+				files = append(files, d.keyToPath(f.FileName))
+			}
+		}
+		if next == "" {
+			break
+		}
+		start = next
+	}
+
+	if opath != "/" {
+		if len(files) == 0 && len(directories) == 0 {
+			// Treat empty response as missing directory, since we don't actually
+			// have directories in B2.
+			return nil, storagedriver.PathNotFoundError{Path: opath}
+		}
+	}
+
+	return append(files, directories...), nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	source, err := d.latestFile(ctx, d.b2Path(sourcePath))
+	if err != nil {
+		return err
+	}
+	if source == nil {
+		return storagedriver.PathNotFoundError{Path: sourcePath}
+	}
+
+	destKey := d.b2Path(destPath)
+	if source.ContentLength <= maxChunkSize {
+		if _, err := d.client.copyFile(ctx, source.FileID, destKey); err != nil {
+			return parseError(sourcePath, err)
+		}
+	} else if err := d.copyLargeFile(ctx, source, destKey); err != nil {
+		return parseError(sourcePath, err)
+	}
+
+	return d.deleteFiles(ctx, source.FileName, func(name string) bool {
+		return name == source.FileName
+	})
+}
+
+// copyLargeFile copies a file larger than maxChunkSize to the key in parts.
+func (d *driver) copyLargeFile(ctx context.Context, source *file, key string) error {
+	partSize := d.ChunkSize
+	if min := (source.ContentLength + maxParts - 1) / maxParts; partSize < min {
+		partSize = min
+	}
+
+	large, err := d.client.startLargeFile(ctx, key)
+	if err != nil {
+		return err
+	}
+	var parts []part
+	for start := int64(0); start < source.ContentLength; start += partSize {
+		end := start + partSize - 1
+		if end >= source.ContentLength {
+			end = source.ContentLength - 1
+		}
+		p, err := d.client.copyPart(ctx, source.FileID, large.FileID, len(parts)+1, start, end)
+		if err != nil {
+			d.client.cancelLargeFile(ctx, large.FileID)
+			return err
+		}
+		parts = append(parts, *p)
+	}
+	if _, err := d.client.finishLargeFile(ctx, large.FileID, parts); err != nil {
+		d.client.cancelLargeFile(ctx, large.FileID)
+		return err
+	}
+	return nil
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	key := d.b2Path(path)
+	subpath := func(name string) bool {
+		// do not delete "/ab" when deleting "/a"
+		return name == key || strings.HasPrefix(name, key+"/")
+	}
+
+	files, _, err := d.client.listFileNames(ctx, key, "", key, 1)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 || !subpath(files[0].FileName) {
+		if files, _, err = d.client.listFileNames(ctx, strings.TrimLeft(key+"/", "/"), "", "", 1); err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return storagedriver.PathNotFoundError{Path: path}
+		}
+	}
+
+	large, err := d.client.listUnfinishedLargeFiles(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, f := range large {
+		if subpath(f.FileName) {
+			if err := d.client.cancelLargeFile(ctx, f.FileID); err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	return d.deleteFiles(ctx, key, subpath)
+}
+
+// deleteFiles deletes the files whose names start with prefix and match.
+// All their versions are deleted, unless the driver hides deleted files.
+func (d *driver) deleteFiles(ctx context.Context, prefix string, match func(name string) bool) error {
+	if d.DeleteMode == deleteModeHide {
+		start := ""
+		for {
+			files, next, err := d.client.listFileNames(ctx, prefix, "", start, listMax)
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if !match(f.FileName) {
+					continue
+				}
+				if err := d.client.hideFile(ctx, f.FileName); err != nil && !isNotFound(err) {
+					return err
+				}
+			}
+			if next == "" {
+				return nil
+			}
+			start = next
+		}
+	}
+
+	return d.client.listFileVersions(ctx, prefix, func(files []file) error {
+		for _, f := range files {
+			if !match(f.FileName) || f.Action == "start" {
+				continue
+			}
+			if err := d.client.deleteFileVersion(ctx, f.FileName, f.FileID); err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at the given path.
+// May return an UnsupportedMethodErr in certain StorageDriver implementations.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	methodString := http.MethodGet
+	method, ok := options["method"]
+	if ok {
+		methodString, ok = method.(string)
+		if !ok || (methodString != http.MethodGet && methodString != http.MethodHead) {
+			return "", storagedriver.ErrUnsupportedMethod{}
+		}
+	}
+
+	expiresIn := 20 * time.Minute
+	expires, ok := options["expiry"]
+	if ok {
+		et, ok := expires.(time.Time)
+		if ok {
+			expiresIn = time.Until(et)
+		}
+	}
+	if expiresIn < time.Second {
+		expiresIn = time.Second
+	} else if expiresIn > maxDownloadAuthorization {
+		expiresIn = maxDownloadAuthorization
+	}
+
+	key := d.b2Path(path)
+	token, err := d.client.downloadAuthorization(ctx, key, expiresIn)
+	if err != nil {
+		return "", err
+	}
+	auth, err := d.client.authorization(ctx)
+	if err != nil {
+		return "", err
+	}
+	return d.client.fileURL(auth, key) + "?Authorization=" + url.QueryEscape(token), nil
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	return storagedriver.WalkFallback(ctx, d, path, f)
+}
+
+func (d *driver) b2Path(path string) string {
+	return strings.TrimLeft(strings.TrimRight(d.RootDirectory, "/")+path, "/")
+}
+
+// keyToPath returns the path of the file or folder at the key.
+func (d *driver) keyToPath(key string) string {
+	path := strings.TrimPrefix(key, d.b2Path(""))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+func parseError(path string, err error) error {
+	if isNotFound(err) {
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	return err
+}
+
+// writer uploads the content written to it as a large file, in parts of the
+// chunk size. The content which does not fill a part yet, the tail, is
+// buffered, and stored as a file at the key when the writer is closed so
+// that the upload can be resumed. A small file is uploaded at once on
+// commit.
+type writer struct {
+	ctx    context.Context
+	driver *driver
+	key    string
+	// large is the large file being uploaded, if the content exceeded a
+	// chunk.
+	large *file
+	parts []part
+	// tail is the content not uploaded as a part, and tailFile the file
+	// storing the tail of a previous writer, if any.
+	tail      []byte
+	tailFile  *file
+	tailDirty bool
+	size      int64
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (d *driver) newWriter(ctx context.Context, key string) *writer {
+	return &writer{
+		ctx:    ctx,
+		driver: d,
+		key:    key,
+	}
+}
+
+// loadTail reads the tail stored by a previous writer.
+func (w *writer) loadTail(f *file) error {
+	resp, err := w.driver.client.download(w.ctx, w.key, 0)
+	if err != nil {
+		if e, ok := err.(*apiError); !ok || e.Status != http.StatusRequestedRangeNotSatisfiable {
+			return err
+		}
+	} else {
+		defer resp.Body.Close()
+		if w.tail, err = io.ReadAll(resp.Body); err != nil {
+			return err
+		}
+	}
+	w.tailFile = f
+	w.size += int64(len(w.tail))
+	return nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	w.tail = append(w.tail, p...)
+	w.tailDirty = true
+	w.size += int64(len(p))
+
+	// Parts are only uploaded once the tail exceeds a chunk, so that the
+	// last part uploaded on commit is never empty.
+	for int64(len(w.tail)) > w.driver.ChunkSize {
+		if err := w.uploadPart(w.tail[:w.driver.ChunkSize]); err != nil {
+			return len(p), err
+		}
+		w.tail = w.tail[w.driver.ChunkSize:]
+	}
+	return len(p), nil
+}
+
+// uploadPart uploads the next part of the large file, starting it first if
+// needed.
+func (w *writer) uploadPart(data []byte) error {
+	if w.large == nil {
+		large, err := w.driver.client.startLargeFile(w.ctx, w.key)
+		if err != nil {
+			return err
+		}
+		w.large = large
+	}
+	p, err := w.driver.client.uploadPart(w.ctx, w.large.FileID, len(w.parts)+1, data)
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, *p)
+	return nil
+}
+
+func (w *writer) Size() int64 {
+	return w.size
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+	if w.committed || w.cancelled || (!w.tailDirty && (w.tailFile != nil || w.large != nil)) {
+		return nil
+	}
+
+	// store the tail, replacing the tail of the previous writer
+	f, err := w.driver.client.uploadFile(w.ctx, w.key, w.tail)
+	if err != nil {
+		return err
+	}
+	return w.replaceTail(f)
+}
+
+// replaceTail deletes the file storing the tail of the previous writer,
+// replaced by f.
+func (w *writer) replaceTail(f *file) error {
+	previous := w.tailFile
+	w.tailFile = f
+	if previous == nil {
+		return nil
+	}
+	if err := w.driver.client.deleteFileVersion(w.ctx, previous.FileName, previous.FileID); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (w *writer) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+	if w.large != nil {
+		if err := w.driver.client.cancelLargeFile(ctx, w.large.FileID); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	if w.tailFile != nil {
+		if err := w.driver.client.deleteFileVersion(ctx, w.tailFile.FileName, w.tailFile.FileID); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *writer) Commit() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+
+	if w.large == nil {
+		f, err := w.driver.client.uploadFile(w.ctx, w.key, w.tail)
+		if err != nil {
+			return err
+		}
+		w.committed = true
+		return w.replaceTail(f)
+	}
+
+	if len(w.tail) > 0 {
+		if err := w.uploadPart(w.tail); err != nil {
+			return err
+		}
+	}
+	// The stored tail is newer than the large file, and would hide it once
+	// finished.
+	if err := w.replaceTail(nil); err != nil {
+		return err
+	}
+	w.committed = true
+	if _, err := w.driver.client.finishLargeFile(w.ctx, w.large.FileID, w.parts); err != nil {
+		w.driver.client.cancelLargeFile(w.ctx, w.large.FileID)
+		return err
+	}
+	return nil
+}
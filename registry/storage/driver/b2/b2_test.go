@@ -0,0 +1,354 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/testsuites"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { check.TestingT(t) }
+
+func init() {
+	var (
+		keyID          = os.Getenv("B2_KEY_ID")
+		applicationKey = os.Getenv("B2_APPLICATION_KEY")
+		bucket         = os.Getenv("B2_BUCKET")
+		deleteMode     = os.Getenv("B2_DELETE_MODE")
+	)
+
+	root, err := os.MkdirTemp("", "driver-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(root)
+
+	b2DriverConstructor := func() (storagedriver.StorageDriver, error) {
+		return New(DriverParameters{
+			KeyID:          keyID,
+			ApplicationKey: applicationKey,
+			Bucket:         bucket,
+			RootDirectory:  root,
+			ChunkSize:      minChunkSize,
+			DeleteMode:     deleteMode,
+		})
+	}
+
+	// Skip B2 storage driver tests if environment variable parameters are not provided
+	skipCheck := func() string {
+		if keyID == "" || applicationKey == "" || bucket == "" {
+			return "Must set B2_KEY_ID, B2_APPLICATION_KEY and B2_BUCKET to run B2 tests"
+		}
+		return ""
+	}
+
+	testsuites.RegisterSuite(b2DriverConstructor, skipCheck)
+}
+
+func newFakeDriver(t *testing.T, parameters map[string]interface{}) (*fakeServer, storagedriver.StorageDriver) {
+	t.Helper()
+	server := newFakeServer()
+	t.Cleanup(server.Close)
+
+	params := map[string]interface{}{
+		"keyid":          "keyid",
+		"applicationkey": "applicationkey",
+		"bucket":         "bucket",
+		"apiurl":         server.URL,
+		"rootdirectory":  "/registry",
+		"chunksize":      minChunkSize,
+	}
+	for k, v := range parameters {
+		params[k] = v
+	}
+	d, err := FromParameters(params)
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	return server, d
+}
+
+func TestFromParameters(t *testing.T) {
+	server := newFakeServer()
+	defer server.Close()
+
+	valid := map[string]interface{}{
+		"keyid":          "keyid",
+		"applicationkey": "applicationkey",
+		"bucket":         "bucket",
+		"apiurl":         server.URL,
+	}
+	for _, tc := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"keyid", ""},
+		{"applicationkey", ""},
+		{"bucket", ""},
+		{"applicationkey", "wrong"},
+		{"bucket", "missing"},
+		{"chunksize", minChunkSize - 1},
+		{"chunksize", "large"},
+		{"deletemode", "purge"},
+	} {
+		params := make(map[string]interface{})
+		for k, v := range valid {
+			params[k] = v
+		}
+		params[tc.key] = tc.value
+		if _, err := FromParameters(params); err == nil {
+			t.Errorf("expected error with %s %v", tc.key, tc.value)
+		}
+	}
+
+	if _, err := FromParameters(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestContent(t *testing.T) {
+	server, d := newFakeDriver(t, nil)
+	ctx := context.Background()
+
+	if _, err := d.GetContent(ctx, "/a/b"); !isPathNotFound(err) {
+		t.Fatalf("expected path not found error, got %v", err)
+	}
+
+	contents := []byte("0123456789")
+	for _, path := range []string{"/a/b", "/a/c/d", "/ab"} {
+		if err := d.PutContent(ctx, path, contents); err != nil {
+			t.Fatalf("unexpected error putting %s: %v", path, err)
+		}
+	}
+	if versions := server.fileVersions("registry/a/b"); len(versions) != 1 {
+		t.Fatalf("expected content to be stored under the root directory, got %d versions", len(versions))
+	}
+
+	r, err := d.Reader(ctx, "/a/b", 4)
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "456789" {
+		t.Fatalf("unexpected content read from offset: %q", b)
+	}
+	r, err = d.Reader(ctx, "/a/b", 10)
+	if err != nil {
+		t.Fatalf("unexpected error reading past the end: %v", err)
+	}
+	if b, _ := io.ReadAll(r); len(b) != 0 {
+		t.Fatalf("unexpected content read past the end: %q", b)
+	}
+
+	fi, err := d.Stat(ctx, "/a/b")
+	if err != nil || fi.IsDir() || fi.Size() != 10 || fi.ModTime().IsZero() {
+		t.Fatalf("unexpected file info: %+v, %v", fi, err)
+	}
+	fi, err = d.Stat(ctx, "/a")
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("unexpected directory info: %+v, %v", fi, err)
+	}
+	if _, err := d.Stat(ctx, "/a/e"); !isPathNotFound(err) {
+		t.Fatalf("expected path not found error, got %v", err)
+	}
+
+	list, err := d.List(ctx, "/a")
+	if err != nil {
+		t.Fatalf("unexpected error listing: %v", err)
+	}
+	if strings.Join(list, ",") != "/a/b,/a/c" {
+		t.Fatalf("unexpected list: %v", list)
+	}
+	list, err = d.List(ctx, "/")
+	if err != nil {
+		t.Fatalf("unexpected error listing root: %v", err)
+	}
+	if strings.Join(list, ",") != "/ab,/a" {
+		t.Fatalf("unexpected root list: %v", list)
+	}
+
+	if err := d.Move(ctx, "/a/b", "/a/c/e"); err != nil {
+		t.Fatalf("unexpected error moving: %v", err)
+	}
+	if len(server.fileVersions("registry/a/b")) != 0 {
+		t.Fatal("expected moved file to be deleted")
+	}
+	if b, err := d.GetContent(ctx, "/a/c/e"); err != nil || !bytes.Equal(b, contents) {
+		t.Fatalf("unexpected moved content: %q, %v", b, err)
+	}
+	if err := d.Move(ctx, "/a/b", "/a/c/f"); !isPathNotFound(err) {
+		t.Fatalf("expected path not found error, got %v", err)
+	}
+
+	if err := d.Delete(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if _, err := d.Stat(ctx, "/a"); !isPathNotFound(err) {
+		t.Fatalf("expected deleted directory to be missing, got %v", err)
+	}
+	if _, err := d.Stat(ctx, "/ab"); err != nil {
+		t.Fatalf("expected deleting /a not to delete /ab: %v", err)
+	}
+	if err := d.Delete(ctx, "/a"); !isPathNotFound(err) {
+		t.Fatalf("expected path not found error, got %v", err)
+	}
+}
+
+func TestDeleteModeHide(t *testing.T) {
+	server, d := newFakeDriver(t, map[string]interface{}{"deletemode": "hide"})
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/a/b", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if _, err := d.Stat(ctx, "/a/b"); !isPathNotFound(err) {
+		t.Fatalf("expected hidden file to be missing, got %v", err)
+	}
+	versions := server.fileVersions("registry/a/b")
+	if len(versions) != 2 || versions[0].Action != "hide" {
+		t.Fatalf("expected file to be hidden rather than deleted, got %d versions", len(versions))
+	}
+}
+
+func TestWriterAppend(t *testing.T) {
+	for _, chunkSize := range []int{32, 3 * minChunkSize / 2} {
+		server, d := newFakeDriver(t, nil)
+		ctx := context.Background()
+
+		contents := make([]byte, 3*chunkSize)
+		rand.Read(contents)
+
+		w, err := d.Writer(ctx, "/upload/data", false)
+		if err != nil {
+			t.Fatalf("unexpected error creating writer: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if i > 0 {
+				w, err = d.Writer(ctx, "/upload/data", true)
+				if err != nil {
+					t.Fatalf("unexpected error resuming writer: %v", err)
+				}
+				if w.Size() != int64(i*chunkSize) {
+					t.Fatalf("unexpected size of resumed writer: %d", w.Size())
+				}
+			}
+			if _, err := w.Write(contents[i*chunkSize : (i+1)*chunkSize]); err != nil {
+				t.Fatalf("unexpected error writing: %v", err)
+			}
+			if i < 2 {
+				if err := w.Close(); err != nil {
+					t.Fatalf("unexpected error closing: %v", err)
+				}
+			}
+		}
+		if err := w.Commit(); err != nil {
+			t.Fatalf("unexpected error committing: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("unexpected error closing committed writer: %v", err)
+		}
+
+		received, err := d.GetContent(ctx, "/upload/data")
+		if err != nil || !bytes.Equal(received, contents) {
+			t.Fatalf("unexpected content of %d byte chunks: %v", chunkSize, err)
+		}
+		if versions := server.fileVersions("registry/upload/data"); len(versions) != 1 {
+			t.Fatalf("expected a single version of %d byte chunks, got %d", chunkSize, len(versions))
+		}
+		if n := server.unfinishedLargeFiles(); n != 0 {
+			t.Fatalf("expected no unfinished large file, got %d", n)
+		}
+	}
+}
+
+func TestWriterCancel(t *testing.T) {
+	server, d := newFakeDriver(t, nil)
+	ctx := context.Background()
+
+	w, err := d.Writer(ctx, "/upload/data", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 3*minChunkSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = d.Writer(ctx, "/upload/data", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Cancel(ctx); err != nil {
+		t.Fatalf("unexpected error cancelling: %v", err)
+	}
+	if len(server.fileVersions("registry/upload/data")) != 0 || server.unfinishedLargeFiles() != 0 {
+		t.Fatal("expected cancelled upload to be deleted")
+	}
+	if _, err := d.Writer(ctx, "/upload/data", true); !isPathNotFound(err) {
+		t.Fatalf("expected path not found error, got %v", err)
+	}
+}
+
+func TestExpiredAuthorization(t *testing.T) {
+	server, d := newFakeDriver(t, nil)
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	server.expireToken()
+	if _, err := d.GetContent(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error after the token expired: %v", err)
+	}
+	if _, err := d.Stat(ctx, "/a"); err != nil {
+		t.Fatalf("unexpected error after the token expired: %v", err)
+	}
+	if server.authorizations != 2 {
+		t.Fatalf("expected the account to be authorized again once, got %d authorizations", server.authorizations)
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	_, d := newFakeDriver(t, nil)
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	u, err := d.URLFor(ctx, "/a", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(b) != "content" {
+		t.Fatalf("unexpected response to %s: %d %q", u, resp.StatusCode, b)
+	}
+
+	if _, err := d.URLFor(ctx, "/a", map[string]interface{}{"method": http.MethodPost}); err == nil {
+		t.Fatal("expected error for unsupported method")
+	}
+}
+
+func isPathNotFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
@@ -0,0 +1,575 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// apiVersion is the version of the native API the client calls.
+	apiVersion = "b2api/v2"
+
+	// maxRetries is the number of times a request failing with a transient
+	// error is retried.
+	maxRetries = 5
+
+	// listMax is the largest number of files returned by a list call.
+	listMax = 1000
+)
+
+// apiError is an error response of the B2 API.
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("b2: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// isNotFound reports whether err is an error of the B2 API reporting a
+// missing file.
+func isNotFound(err error) bool {
+	if e, ok := err.(*apiError); ok {
+		return e.Status == http.StatusNotFound || e.Code == "file_not_present" || e.Code == "no_such_file"
+	}
+	return false
+}
+
+// retryable reports whether a request failing with err may succeed if
+// retried, possibly after renewing the authorization.
+func retryable(err error) bool {
+	e, ok := err.(*apiError)
+	if !ok {
+		return true
+	}
+	switch e.Status {
+	case http.StatusUnauthorized:
+		return e.Code == "expired_auth_token" || e.Code == "expired_upload_token"
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// authorization is the response of b2_authorize_account.
+type authorization struct {
+	AccountID               string `json:"accountId"`
+	AuthorizationToken      string `json:"authorizationToken"`
+	APIURL                  string `json:"apiUrl"`
+	DownloadURL             string `json:"downloadUrl"`
+	RecommendedPartSize     int64  `json:"recommendedPartSize"`
+	AbsoluteMinimumPartSize int64  `json:"absoluteMinimumPartSize"`
+	Allowed                 struct {
+		BucketID   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+	} `json:"allowed"`
+}
+
+// file describes a version of a file, or a folder when listing file names
+// with a delimiter.
+type file struct {
+	FileID          string `json:"fileId"`
+	FileName        string `json:"fileName"`
+	ContentLength   int64  `json:"contentLength"`
+	UploadTimestamp int64  `json:"uploadTimestamp"`
+	// Action is "upload" for files, "start" for unfinished large files,
+	// "hide" for hide markers and "folder" for folders.
+	Action string `json:"action"`
+}
+
+// modTime returns the upload time of the file.
+func (f *file) modTime() time.Time {
+	return time.Unix(0, f.UploadTimestamp*int64(time.Millisecond))
+}
+
+// part describes an uploaded part of a large file.
+type part struct {
+	PartNumber    int    `json:"partNumber"`
+	ContentLength int64  `json:"contentLength"`
+	ContentSha1   string `json:"contentSha1"`
+}
+
+// uploadURL is the response of b2_get_upload_url and b2_get_upload_part_url.
+type uploadURL struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+// client calls the native B2 API on a bucket, renewing its authorization
+// when it expires and retrying requests failing with transient errors.
+type client struct {
+	httpClient     *http.Client
+	authURL        string
+	keyID          string
+	applicationKey string
+	bucketName     string
+
+	mu       sync.Mutex
+	auth     *authorization
+	bucketID string
+}
+
+func newClient(ctx context.Context, params DriverParameters) (*client, error) {
+	c := &client{
+		httpClient:     http.DefaultClient,
+		authURL:        strings.TrimRight(params.APIURL, "/"),
+		keyID:          params.KeyID,
+		applicationKey: params.ApplicationKey,
+		bucketName:     params.Bucket,
+	}
+	auth, err := c.authorize(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.Allowed.BucketName == c.bucketName && auth.Allowed.BucketID != "" {
+		c.bucketID = auth.Allowed.BucketID
+		return c, nil
+	}
+	var resp struct {
+		Buckets []struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	req := map[string]string{"accountId": auth.AccountID, "bucketName": c.bucketName}
+	if err := c.call(ctx, "b2_list_buckets", req, &resp); err != nil {
+		return nil, err
+	}
+	for _, b := range resp.Buckets {
+		if b.BucketName == c.bucketName {
+			c.bucketID = b.BucketID
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("b2: bucket %q not found", c.bucketName)
+}
+
+// authorization returns the current authorization of the account.
+func (c *client) authorization(ctx context.Context) (*authorization, error) {
+	c.mu.Lock()
+	auth := c.auth
+	c.mu.Unlock()
+	if auth != nil {
+		return auth, nil
+	}
+	return c.authorize(ctx, nil)
+}
+
+// authorize renews the authorization of the account, unless another request
+// already renewed the expired authorization.
+func (c *client) authorize(ctx context.Context, expired *authorization) (*authorization, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.auth != nil && c.auth != expired {
+		return c.auth, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.authURL+"/"+apiVersion+"/b2_authorize_account", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.keyID, c.applicationKey)
+	var auth authorization
+	if err := c.do(req, &auth); err != nil {
+		return nil, err
+	}
+	c.auth = &auth
+	return c.auth, nil
+}
+
+// do sends the request, decoding a successful JSON response into resp.
+func (c *client) do(req *http.Request, resp interface{}) error {
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// responseError returns the error reported by a failed response.
+func responseError(res *http.Response) error {
+	apiErr := &apiError{Status: res.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Code == "" {
+		apiErr.Code = strings.ToLower(strings.ReplaceAll(http.StatusText(res.StatusCode), " ", "_"))
+		apiErr.Message = string(body)
+	}
+	apiErr.Status = res.StatusCode
+	return apiErr
+}
+
+// retry calls f until it succeeds, fails with an error which is not
+// transient, or maxRetries is reached. The authorization is renewed when it
+// expires.
+func (c *client) retry(ctx context.Context, f func(auth *authorization) error) error {
+	var err error
+	for i := 0; i <= maxRetries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(time.Duration(1<<uint(i-1)) * 100 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		var auth *authorization
+		auth, err = c.authorization(ctx)
+		if err != nil {
+			return err
+		}
+		err = f(auth)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if e, ok := err.(*apiError); ok && e.Code == "expired_auth_token" {
+			if _, err := c.authorize(ctx, auth); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// call calls the API operation with a JSON request, decoding its JSON
+// response into resp.
+func (c *client) call(ctx context.Context, operation string, request, resp interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return c.retry(ctx, func(auth *authorization) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.APIURL+"/"+apiVersion+"/"+operation, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", auth.AuthorizationToken)
+		return c.do(req, resp)
+	})
+}
+
+// upload posts data to an upload url returned by getURL, which is called
+// again when the upload fails, as B2 requires.
+func (c *client) upload(ctx context.Context, getURL func() (*uploadURL, error), header http.Header, data []byte, resp interface{}) error {
+	sum := sha1.Sum(data)
+	return c.retry(ctx, func(*authorization) error {
+		u, err := getURL()
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.UploadURL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Authorization", u.AuthorizationToken)
+		req.Header.Set("X-Bz-Content-Sha1", hex.EncodeToString(sum[:]))
+		req.ContentLength = int64(len(data))
+		err = c.do(req, resp)
+		if e, ok := err.(*apiError); ok && e.Status == http.StatusUnauthorized {
+			// upload tokens expire with their upload url, which is
+			// requested again on retry
+			e.Code = "expired_upload_token"
+		}
+		return err
+	})
+}
+
+// uploadFile stores data as a new version of the named file.
+func (c *client) uploadFile(ctx context.Context, name string, data []byte) (*file, error) {
+	getURL := func() (*uploadURL, error) {
+		var u uploadURL
+		err := c.call(ctx, "b2_get_upload_url", map[string]string{"bucketId": c.bucketID}, &u)
+		return &u, err
+	}
+	header := http.Header{}
+	header.Set("X-Bz-File-Name", escapeFileName(name))
+	header.Set("Content-Type", "application/octet-stream")
+	var f file
+	if err := c.upload(ctx, getURL, header, data, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// download returns the response to a download of the named file from the
+// offset.
+func (c *client) download(ctx context.Context, name string, offset int64) (*http.Response, error) {
+	var res *http.Response
+	err := c.retry(ctx, func(auth *authorization) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.fileURL(auth, name), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", auth.AuthorizationToken)
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		res, err = c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+			defer res.Body.Close()
+			return responseError(res)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// fileURL returns the download url of the named file.
+func (c *client) fileURL(auth *authorization, name string) string {
+	return auth.DownloadURL + "/file/" + url.PathEscape(c.bucketName) + "/" + escapeFileName(name)
+}
+
+// listFileNames lists the latest versions of the files whose names start
+// with prefix, from the start file name. Folders are returned rather than
+// the files they contain if delimiter is set. The name to continue the
+// listing from is returned if there are more files.
+func (c *client) listFileNames(ctx context.Context, prefix, delimiter, start string, max int) ([]file, string, error) {
+	req := map[string]interface{}{
+		"bucketId":     c.bucketID,
+		"prefix":       prefix,
+		"maxFileCount": max,
+	}
+	if delimiter != "" {
+		req["delimiter"] = delimiter
+	}
+	if start != "" {
+		req["startFileName"] = start
+	}
+	var resp struct {
+		Files        []file  `json:"files"`
+		NextFileName *string `json:"nextFileName"`
+	}
+	if err := c.call(ctx, "b2_list_file_names", req, &resp); err != nil {
+		return nil, "", err
+	}
+	if resp.NextFileName == nil {
+		return resp.Files, "", nil
+	}
+	return resp.Files, *resp.NextFileName, nil
+}
+
+// listFileVersions lists all the versions of the files whose names start
+// with prefix, calling f with each page of versions.
+func (c *client) listFileVersions(ctx context.Context, prefix string, f func([]file) error) error {
+	req := map[string]interface{}{
+		"bucketId":     c.bucketID,
+		"prefix":       prefix,
+		"maxFileCount": listMax,
+	}
+	for {
+		var resp struct {
+			Files        []file  `json:"files"`
+			NextFileName *string `json:"nextFileName"`
+			NextFileID   *string `json:"nextFileId"`
+		}
+		if err := c.call(ctx, "b2_list_file_versions", req, &resp); err != nil {
+			return err
+		}
+		if err := f(resp.Files); err != nil {
+			return err
+		}
+		if resp.NextFileName == nil {
+			return nil
+		}
+		req["startFileName"] = *resp.NextFileName
+		if resp.NextFileID != nil {
+			req["startFileId"] = *resp.NextFileID
+		}
+	}
+}
+
+// deleteFileVersion deletes a version of the named file.
+func (c *client) deleteFileVersion(ctx context.Context, name, id string) error {
+	return c.call(ctx, "b2_delete_file_version", map[string]string{"fileName": name, "fileId": id}, nil)
+}
+
+// hideFile hides the named file, leaving its versions to the lifecycle rules
+// of the bucket.
+func (c *client) hideFile(ctx context.Context, name string) error {
+	return c.call(ctx, "b2_hide_file", map[string]string{"bucketId": c.bucketID, "fileName": name}, nil)
+}
+
+// copyFile copies the source file, of at most 5GB, to a new version of the
+// named file.
+func (c *client) copyFile(ctx context.Context, sourceID, name string) (*file, error) {
+	req := map[string]string{
+		"sourceFileId":      sourceID,
+		"fileName":          name,
+		"metadataDirective": "COPY",
+	}
+	var f file
+	if err := c.call(ctx, "b2_copy_file", req, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// startLargeFile starts the upload of a new version of the named file in
+// parts.
+func (c *client) startLargeFile(ctx context.Context, name string) (*file, error) {
+	req := map[string]string{
+		"bucketId":    c.bucketID,
+		"fileName":    name,
+		"contentType": "application/octet-stream",
+	}
+	var f file
+	if err := c.call(ctx, "b2_start_large_file", req, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// uploadPart uploads data as the numbered part of the large file.
+func (c *client) uploadPart(ctx context.Context, fileID string, number int, data []byte) (*part, error) {
+	getURL := func() (*uploadURL, error) {
+		var u uploadURL
+		err := c.call(ctx, "b2_get_upload_part_url", map[string]string{"fileId": fileID}, &u)
+		return &u, err
+	}
+	header := http.Header{}
+	header.Set("X-Bz-Part-Number", strconv.Itoa(number))
+	var p part
+	if err := c.upload(ctx, getURL, header, data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// copyPart copies the bytes from start to end, inclusive, of the source
+// file as the numbered part of the large file.
+func (c *client) copyPart(ctx context.Context, sourceID, fileID string, number int, start, end int64) (*part, error) {
+	req := map[string]interface{}{
+		"sourceFileId": sourceID,
+		"largeFileId":  fileID,
+		"partNumber":   number,
+		"range":        fmt.Sprintf("bytes=%d-%d", start, end),
+	}
+	var p part
+	if err := c.call(ctx, "b2_copy_part", req, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// listParts returns the parts uploaded to the large file.
+func (c *client) listParts(ctx context.Context, fileID string) ([]part, error) {
+	req := map[string]interface{}{
+		"fileId":       fileID,
+		"maxPartCount": listMax,
+	}
+	var parts []part
+	for {
+		var resp struct {
+			Parts          []part `json:"parts"`
+			NextPartNumber *int   `json:"nextPartNumber"`
+		}
+		if err := c.call(ctx, "b2_list_parts", req, &resp); err != nil {
+			return nil, err
+		}
+		parts = append(parts, resp.Parts...)
+		if resp.NextPartNumber == nil {
+			return parts, nil
+		}
+		req["startPartNumber"] = *resp.NextPartNumber
+	}
+}
+
+// finishLargeFile assembles the uploaded parts of the large file.
+func (c *client) finishLargeFile(ctx context.Context, fileID string, parts []part) (*file, error) {
+	sha1s := make([]string, len(parts))
+	for i, p := range parts {
+		sha1s[i] = p.ContentSha1
+	}
+	req := map[string]interface{}{
+		"fileId":        fileID,
+		"partSha1Array": sha1s,
+	}
+	var f file
+	if err := c.call(ctx, "b2_finish_large_file", req, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// cancelLargeFile cancels the upload of the large file, deleting its parts.
+func (c *client) cancelLargeFile(ctx context.Context, fileID string) error {
+	return c.call(ctx, "b2_cancel_large_file", map[string]string{"fileId": fileID}, nil)
+}
+
+// listUnfinishedLargeFiles returns the large files whose names start with
+// prefix and which are being uploaded.
+func (c *client) listUnfinishedLargeFiles(ctx context.Context, prefix string) ([]file, error) {
+	req := map[string]interface{}{
+		"bucketId":     c.bucketID,
+		"namePrefix":   prefix,
+		"maxFileCount": 100,
+	}
+	var files []file
+	for {
+		var resp struct {
+			Files      []file  `json:"files"`
+			NextFileID *string `json:"nextFileId"`
+		}
+		if err := c.call(ctx, "b2_list_unfinished_large_files", req, &resp); err != nil {
+			return nil, err
+		}
+		files = append(files, resp.Files...)
+		if resp.NextFileID == nil {
+			return files, nil
+		}
+		req["startFileId"] = *resp.NextFileID
+	}
+}
+
+// downloadAuthorization returns a token authorizing the download of the
+// files whose names start with prefix for the duration.
+func (c *client) downloadAuthorization(ctx context.Context, prefix string, duration time.Duration) (string, error) {
+	req := map[string]interface{}{
+		"bucketId":               c.bucketID,
+		"fileNamePrefix":         prefix,
+		"validDurationInSeconds": int64(duration / time.Second),
+	}
+	var resp struct {
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+	if err := c.call(ctx, "b2_get_download_authorization", req, &resp); err != nil {
+		return "", err
+	}
+	return resp.AuthorizationToken, nil
+}
+
+// escapeFileName percent-encodes a file name for use in a header or url,
+// leaving its slashes.
+func escapeFileName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
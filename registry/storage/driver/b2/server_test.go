@@ -0,0 +1,392 @@
+package b2
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeVersion is a version of a file stored by fakeServer.
+type fakeVersion struct {
+	file
+	data []byte
+}
+
+// fakeLargeFile is a large file being uploaded to fakeServer.
+type fakeLargeFile struct {
+	file
+	parts map[int][]byte
+}
+
+// fakeServer implements the parts of the native B2 API used by the driver,
+// storing files in memory.
+type fakeServer struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	token          int
+	authorizations int
+	clock          int64
+	nextID         int
+	versions       map[string][]*fakeVersion
+	large          map[string]*fakeLargeFile
+}
+
+func newFakeServer() *fakeServer {
+	s := &fakeServer{
+		clock:    time.Now().UnixNano() / int64(time.Millisecond),
+		versions: make(map[string][]*fakeVersion),
+		large:    make(map[string]*fakeLargeFile),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// expireToken expires the authorization token of the account.
+func (s *fakeServer) expireToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token++
+}
+
+// fileVersions returns the versions of the named file, newest first.
+func (s *fakeServer) fileVersions(name string) []*fakeVersion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := append([]*fakeVersion(nil), s.versions[name]...)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].UploadTimestamp > versions[j].UploadTimestamp })
+	return versions
+}
+
+// unfinishedLargeFiles returns the number of large files being uploaded.
+func (s *fakeServer) unfinishedLargeFiles() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.large)
+}
+
+func (s *fakeServer) newFile(name, action string) file {
+	s.clock++
+	s.nextID++
+	return file{
+		FileID:          fmt.Sprintf("id-%d", s.nextID),
+		FileName:        name,
+		UploadTimestamp: s.clock,
+		Action:          action,
+	}
+}
+
+func (s *fakeServer) addVersion(f file, data []byte) *fakeVersion {
+	f.ContentLength = int64(len(data))
+	v := &fakeVersion{file: f, data: data}
+	s.versions[f.FileName] = append(s.versions[f.FileName], v)
+	return v
+}
+
+// latest returns the latest version of the named file, unless it is hidden.
+func (s *fakeServer) latest(name string) *fakeVersion {
+	var latest *fakeVersion
+	for _, v := range s.versions[name] {
+		if latest == nil || v.UploadTimestamp > latest.UploadTimestamp {
+			latest = v
+		}
+	}
+	if latest == nil || latest.Action != "upload" {
+		return nil
+	}
+	return latest
+}
+
+func (s *fakeServer) version(id string) *fakeVersion {
+	for _, versions := range s.versions {
+		for _, v := range versions {
+			if v.FileID == id {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Status: status, Code: code, Message: code})
+}
+
+func (s *fakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/b2api/v2/b2_authorize_account":
+		if id, key, ok := r.BasicAuth(); !ok || id != "keyid" || key != "applicationkey" {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		s.authorizations++
+		writeJSON(w, authorization{
+			AccountID:               "account",
+			AuthorizationToken:      fmt.Sprintf("token-%d", s.token),
+			APIURL:                  s.URL,
+			DownloadURL:             s.URL,
+			RecommendedPartSize:     100 << 20,
+			AbsoluteMinimumPartSize: minChunkSize,
+		})
+	case r.URL.Path == "/upload":
+		name, _ := url.PathUnescape(r.Header.Get("X-Bz-File-Name"))
+		data, ok := s.readUpload(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, s.addVersion(s.newFile(name, "upload"), data).file)
+	case strings.HasPrefix(r.URL.Path, "/upload-part/"):
+		large := s.large[strings.TrimPrefix(r.URL.Path, "/upload-part/")]
+		if large == nil {
+			writeError(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		data, ok := s.readUpload(w, r)
+		if !ok {
+			return
+		}
+		number, _ := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		large.parts[number] = data
+		writeJSON(w, fakePart(number, data))
+	case strings.HasPrefix(r.URL.Path, "/file/bucket/"):
+		s.serveDownload(w, r)
+	case strings.HasPrefix(r.URL.Path, "/b2api/v2/"):
+		if r.Header.Get("Authorization") != fmt.Sprintf("token-%d", s.token) {
+			writeError(w, http.StatusUnauthorized, "expired_auth_token")
+			return
+		}
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		s.serveOperation(w, strings.TrimPrefix(r.URL.Path, "/b2api/v2/"), req)
+	default:
+		writeError(w, http.StatusNotFound, "not_found")
+	}
+}
+
+func (s *fakeServer) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Header.Get("Authorization") != "upload-token" {
+		writeError(w, http.StatusUnauthorized, "bad_auth_token")
+		return nil, false
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request")
+		return nil, false
+	}
+	sum := sha1.Sum(data)
+	if r.Header.Get("X-Bz-Content-Sha1") != hex.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "bad_request")
+		return nil, false
+	}
+	return data, true
+}
+
+func fakePart(number int, data []byte) part {
+	sum := sha1.Sum(data)
+	return part{PartNumber: number, ContentLength: int64(len(data)), ContentSha1: hex.EncodeToString(sum[:])}
+}
+
+func (s *fakeServer) serveDownload(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/file/bucket/")
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		token = r.URL.Query().Get("Authorization")
+	}
+	if token != fmt.Sprintf("token-%d", s.token) && token != "download-"+name {
+		writeError(w, http.StatusUnauthorized, "expired_auth_token")
+		return
+	}
+	v := s.latest(name)
+	if v == nil {
+		writeError(w, http.StatusNotFound, "not_found")
+		return
+	}
+	var offset int64
+	if rng := r.Header.Get("Range"); rng != "" {
+		offset, _ = strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"), 10, 64)
+		if offset >= int64(len(v.data)) {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "range_not_satisfiable")
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	w.Write(v.data[offset:])
+}
+
+func (s *fakeServer) serveOperation(w http.ResponseWriter, operation string, req map[string]interface{}) {
+	str := func(key string) string {
+		v, _ := req[key].(string)
+		return v
+	}
+	switch operation {
+	case "b2_list_buckets":
+		writeJSON(w, map[string]interface{}{
+			"buckets": []map[string]string{{"bucketId": "bucket-id", "bucketName": "bucket"}},
+		})
+	case "b2_get_upload_url":
+		writeJSON(w, uploadURL{UploadURL: s.URL + "/upload", AuthorizationToken: "upload-token"})
+	case "b2_get_upload_part_url":
+		writeJSON(w, uploadURL{UploadURL: s.URL + "/upload-part/" + str("fileId"), AuthorizationToken: "upload-token"})
+	case "b2_list_file_names":
+		s.listFileNames(w, str("prefix"), str("delimiter"), str("startFileName"), int(req["maxFileCount"].(float64)))
+	case "b2_list_file_versions":
+		var files []file
+		for name := range s.versions {
+			if strings.HasPrefix(name, str("prefix")) {
+				for _, v := range s.versions[name] {
+					files = append(files, v.file)
+				}
+			}
+		}
+		for _, large := range s.large {
+			if strings.HasPrefix(large.FileName, str("prefix")) {
+				files = append(files, large.file)
+			}
+		}
+		writeJSON(w, map[string]interface{}{"files": files})
+	case "b2_delete_file_version":
+		versions := s.versions[str("fileName")]
+		for i, v := range versions {
+			if v.FileID == str("fileId") {
+				s.versions[str("fileName")] = append(versions[:i:i], versions[i+1:]...)
+				writeJSON(w, v.file)
+				return
+			}
+		}
+		writeError(w, http.StatusBadRequest, "file_not_present")
+	case "b2_hide_file":
+		if s.latest(str("fileName")) == nil {
+			writeError(w, http.StatusBadRequest, "no_such_file")
+			return
+		}
+		writeJSON(w, s.addVersion(s.newFile(str("fileName"), "hide"), nil).file)
+	case "b2_copy_file":
+		source := s.version(str("sourceFileId"))
+		if source == nil {
+			writeError(w, http.StatusBadRequest, "file_not_present")
+			return
+		}
+		writeJSON(w, s.addVersion(s.newFile(str("fileName"), "upload"), source.data).file)
+	case "b2_start_large_file":
+		large := &fakeLargeFile{file: s.newFile(str("fileName"), "start"), parts: make(map[int][]byte)}
+		s.large[large.FileID] = large
+		writeJSON(w, large.file)
+	case "b2_list_parts":
+		large := s.large[str("fileId")]
+		if large == nil {
+			writeError(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		parts := []part{}
+		for number, data := range large.parts {
+			parts = append(parts, fakePart(number, data))
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+		writeJSON(w, map[string]interface{}{"parts": parts})
+	case "b2_copy_part":
+		source, large := s.version(str("sourceFileId")), s.large[str("largeFileId")]
+		if source == nil || large == nil {
+			writeError(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		var start, end int64
+		fmt.Sscanf(str("range"), "bytes=%d-%d", &start, &end)
+		number := int(req["partNumber"].(float64))
+		large.parts[number] = source.data[start : end+1]
+		writeJSON(w, fakePart(number, large.parts[number]))
+	case "b2_finish_large_file":
+		large := s.large[str("fileId")]
+		sha1s, _ := req["partSha1Array"].([]interface{})
+		if large == nil || len(sha1s) != len(large.parts) || len(sha1s) < 2 {
+			writeError(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		var data []byte
+		for i := range sha1s {
+			p := large.parts[i+1]
+			if p == nil || fakePart(i+1, p).ContentSha1 != sha1s[i] || (i < len(sha1s)-1 && len(p) < minChunkSize) {
+				writeError(w, http.StatusBadRequest, "bad_request")
+				return
+			}
+			data = append(data, p...)
+		}
+		delete(s.large, large.FileID)
+		f := large.file
+		f.Action = "upload"
+		writeJSON(w, s.addVersion(f, data).file)
+	case "b2_cancel_large_file":
+		if s.large[str("fileId")] == nil {
+			writeError(w, http.StatusBadRequest, "bad_request")
+			return
+		}
+		delete(s.large, str("fileId"))
+		writeJSON(w, map[string]string{"fileId": str("fileId")})
+	case "b2_list_unfinished_large_files":
+		files := []file{}
+		for _, large := range s.large {
+			if strings.HasPrefix(large.FileName, str("namePrefix")) {
+				files = append(files, large.file)
+			}
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].UploadTimestamp < files[j].UploadTimestamp })
+		writeJSON(w, map[string]interface{}{"files": files})
+	case "b2_get_download_authorization":
+		writeJSON(w, map[string]string{"authorizationToken": "download-" + str("fileNamePrefix")})
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request")
+	}
+}
+
+func (s *fakeServer) listFileNames(w http.ResponseWriter, prefix, delimiter, start string, max int) {
+	var names []string
+	for name := range s.versions {
+		if strings.HasPrefix(name, prefix) && name >= start && s.latest(name) != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	files := []file{}
+	folders := make(map[string]bool)
+	var next *string
+	for _, name := range names {
+		f := s.latest(name).file
+		if i := strings.Index(name[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			folder := name[:len(prefix)+i+1]
+			if folders[folder] {
+				continue
+			}
+			folders[folder] = true
+			f = file{FileName: folder, Action: "folder"}
+		}
+		if len(files) == max {
+			next = &f.FileName
+			break
+		}
+		files = append(files, f)
+	}
+	writeJSON(w, map[string]interface{}{"files": files, "nextFileName": next})
+}
@@ -62,7 +62,9 @@ type Base struct {
 }
 
 // Format errors received from the storage driver
-func (base *Base) setDriverName(e error) error {
+func (base *Base) setDriverName(ctx context.Context, e error) error {
+	recordError(ctx, e)
+
 	switch actual := e.(type) {
 	case nil:
 		return nil
@@ -95,6 +97,7 @@ func (base *Base) setDriverName(e error) error {
 func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.GetContent(%q)", base.Name(), path)
+	base.annotate(ctx, "GetContent", path)
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
@@ -103,20 +106,21 @@ func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 	start := time.Now()
 	b, e := base.StorageDriver.GetContent(ctx, path)
 	storageAction.WithValues(base.Name(), "GetContent").UpdateSince(start)
-	return b, base.setDriverName(e)
+	return b, base.setDriverName(ctx, e)
 }
 
 // PutContent wraps PutContent of underlying storage driver.
 func (base *Base) PutContent(ctx context.Context, path string, content []byte) error {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.PutContent(%q)", base.Name(), path)
+	base.annotate(ctx, "PutContent", path)
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.PutContent(ctx, path, content))
+	err := base.setDriverName(ctx, base.StorageDriver.PutContent(ctx, path, content))
 	storageAction.WithValues(base.Name(), "PutContent").UpdateSince(start)
 	return err
 }
@@ -125,6 +129,7 @@ func (base *Base) PutContent(ctx context.Context, path string, content []byte) e
 func (base *Base) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.Reader(%q, %d)", base.Name(), path, offset)
+	base.annotate(ctx, "Reader", path)
 
 	if offset < 0 {
 		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: base.StorageDriver.Name()}
@@ -135,26 +140,28 @@ func (base *Base) Reader(ctx context.Context, path string, offset int64) (io.Rea
 	}
 
 	rc, e := base.StorageDriver.Reader(ctx, path, offset)
-	return rc, base.setDriverName(e)
+	return rc, base.setDriverName(ctx, e)
 }
 
 // Writer wraps Writer of underlying storage driver.
 func (base *Base) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.Writer(%q, %v)", base.Name(), path, append)
+	base.annotate(ctx, "Writer", path)
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
 	writer, e := base.StorageDriver.Writer(ctx, path, append)
-	return writer, base.setDriverName(e)
+	return writer, base.setDriverName(ctx, e)
 }
 
 // Stat wraps Stat of underlying storage driver.
 func (base *Base) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.Stat(%q)", base.Name(), path)
+	base.annotate(ctx, "Stat", path)
 
 	if !storagedriver.PathRegexp.MatchString(path) && path != "/" {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
@@ -163,13 +170,14 @@ func (base *Base) Stat(ctx context.Context, path string) (storagedriver.FileInfo
 	start := time.Now()
 	fi, e := base.StorageDriver.Stat(ctx, path)
 	storageAction.WithValues(base.Name(), "Stat").UpdateSince(start)
-	return fi, base.setDriverName(e)
+	return fi, base.setDriverName(ctx, e)
 }
 
 // List wraps List of underlying storage driver.
 func (base *Base) List(ctx context.Context, path string) ([]string, error) {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.List(%q)", base.Name(), path)
+	base.annotate(ctx, "List", path)
 
 	if !storagedriver.PathRegexp.MatchString(path) && path != "/" {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
@@ -178,13 +186,14 @@ func (base *Base) List(ctx context.Context, path string) ([]string, error) {
 	start := time.Now()
 	str, e := base.StorageDriver.List(ctx, path)
 	storageAction.WithValues(base.Name(), "List").UpdateSince(start)
-	return str, base.setDriverName(e)
+	return str, base.setDriverName(ctx, e)
 }
 
 // Move wraps Move of underlying storage driver.
 func (base *Base) Move(ctx context.Context, sourcePath string, destPath string) error {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.Move(%q, %q", base.Name(), sourcePath, destPath)
+	base.annotate(ctx, "Move", sourcePath)
 
 	if !storagedriver.PathRegexp.MatchString(sourcePath) {
 		return storagedriver.InvalidPathError{Path: sourcePath, DriverName: base.StorageDriver.Name()}
@@ -193,7 +202,7 @@ func (base *Base) Move(ctx context.Context, sourcePath string, destPath string)
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Move(ctx, sourcePath, destPath))
+	err := base.setDriverName(ctx, base.StorageDriver.Move(ctx, sourcePath, destPath))
 	storageAction.WithValues(base.Name(), "Move").UpdateSince(start)
 	return err
 }
@@ -202,13 +211,14 @@ func (base *Base) Move(ctx context.Context, sourcePath string, destPath string)
 func (base *Base) Delete(ctx context.Context, path string) error {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.Delete(%q)", base.Name(), path)
+	base.annotate(ctx, "Delete", path)
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
 	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Delete(ctx, path))
+	err := base.setDriverName(ctx, base.StorageDriver.Delete(ctx, path))
 	storageAction.WithValues(base.Name(), "Delete").UpdateSince(start)
 	return err
 }
@@ -217,6 +227,7 @@ func (base *Base) Delete(ctx context.Context, path string) error {
 func (base *Base) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.URLFor(%q)", base.Name(), path)
+	base.annotate(ctx, "URLFor", path)
 
 	if !storagedriver.PathRegexp.MatchString(path) {
 		return "", storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
@@ -225,17 +236,18 @@ func (base *Base) URLFor(ctx context.Context, path string, options map[string]in
 	start := time.Now()
 	str, e := base.StorageDriver.URLFor(ctx, path, options)
 	storageAction.WithValues(base.Name(), "URLFor").UpdateSince(start)
-	return str, base.setDriverName(e)
+	return str, base.setDriverName(ctx, e)
 }
 
 // Walk wraps Walk of underlying storage driver.
 func (base *Base) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
 	ctx, done := dcontext.WithTrace(ctx)
 	defer done("%s.Walk(%q)", base.Name(), path)
+	base.annotate(ctx, "Walk", path)
 
 	if !storagedriver.PathRegexp.MatchString(path) && path != "/" {
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	return base.setDriverName(base.StorageDriver.Walk(ctx, path, f))
+	return base.setDriverName(ctx, base.StorageDriver.Walk(ctx, path, f))
 }
@@ -0,0 +1,42 @@
+package base
+
+import (
+	"context"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// annotate names the span of a storage driver call after the driver and
+// the action, and records the path it operates on, so that slow requests can
+// be correlated with the latency of the storage backend.
+func (base *Base) annotate(ctx context.Context, action, path string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	span.SetName("storagedriver." + action)
+	span.SetAttributes(
+		attribute.String("storage.driver", base.Name()),
+		attribute.String("storage.action", action),
+		attribute.String("storage.path", path),
+	)
+}
+
+// recordError marks the span of a storage driver call as failed. Missing
+// paths are expected by the callers, so they are not recorded as errors.
+func recordError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
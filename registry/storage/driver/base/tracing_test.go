@@ -0,0 +1,66 @@
+package base_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDriverSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ctx := context.Background()
+	d := inmemory.New()
+	if err := d.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+	if _, err := d.Stat(ctx, "/missing"); err == nil {
+		t.Fatal("expected an error stating a missing path")
+	}
+	if err := d.PutContent(ctx, "/a/b", []byte("content")); err == nil {
+		t.Fatal("expected an error putting content under a file")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("unexpected number of spans: %d", len(spans))
+	}
+
+	expected := []struct {
+		name   string
+		path   string
+		status codes.Code
+	}{
+		{name: "storagedriver.PutContent", path: "/a", status: codes.Unset},
+		{name: "storagedriver.Stat", path: "/missing", status: codes.Unset},
+		{name: "storagedriver.PutContent", path: "/a/b", status: codes.Error},
+	}
+	for i, span := range spans {
+		if span.Name() != expected[i].name {
+			t.Errorf("unexpected name of span %d: %q != %q", i, span.Name(), expected[i].name)
+		}
+		attributes := make(map[attribute.Key]string)
+		for _, kv := range span.Attributes() {
+			attributes[kv.Key] = kv.Value.Emit()
+		}
+		if attributes["storage.driver"] != "inmemory" {
+			t.Errorf("unexpected driver of span %d: %q", i, attributes["storage.driver"])
+		}
+		if attributes["storage.path"] != expected[i].path {
+			t.Errorf("unexpected path of span %d: %q != %q", i, attributes["storage.path"], expected[i].path)
+		}
+		if span.Status().Code != expected[i].status {
+			t.Errorf("unexpected status of span %d: %v != %v", i, span.Status().Code, expected[i].status)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package factory
 
 import (
 	"fmt"
+	"sort"
 
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 )
@@ -54,6 +55,16 @@ func Create(name string, parameters map[string]interface{}) (storagedriver.Stora
 	return driverFactory.Create(parameters)
 }
 
+// Drivers returns the sorted names of the registered storage drivers.
+func Drivers() []string {
+	names := make([]string, 0, len(driverFactories))
+	for name := range driverFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // InvalidStorageDriverError records an attempt to construct an unregistered storage driver
 type InvalidStorageDriverError struct {
 	Name string
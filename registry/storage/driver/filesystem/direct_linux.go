@@ -0,0 +1,134 @@
+//go:build linux
+// +build linux
+
+package filesystem
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// readVectorSize is the size of the vectors the buffers of direct reads are
+// split in, so that a single read fills the buffer in large requests.
+const readVectorSize = 1 << 20
+
+// directReader reads a file with vectored reads bypassing the page cache,
+// which avoids copying large blobs through the page cache and the overhead of
+// small buffered reads.
+type directReader struct {
+	d      *driver
+	file   *os.File
+	fd     int
+	size   int64
+	offset int64
+
+	// buf is the aligned buffer the file is read into, and data its part
+	// not yet returned. bufp points to buf, to return it to the pool.
+	bufp *[]byte
+	buf  []byte
+	iovs [][]byte
+	data []byte
+}
+
+// newDirectReader opens the file at name for direct reads from offset. An
+// error is returned if the filesystem does not support direct reads.
+func (d *driver) newDirectReader(name string, size, offset int64) (io.ReadCloser, error) {
+	file, err := os.OpenFile(name, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	bufp, _ := d.readBuffers.Get().(*[]byte)
+	if bufp == nil {
+		buf := alignedBuffer(d.readBufferSize)
+		bufp = &buf
+	}
+	buf := *bufp
+	iovs := make([][]byte, 0, (len(buf)+readVectorSize-1)/readVectorSize)
+	for i := 0; i < len(buf); i += readVectorSize {
+		end := i + readVectorSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		iovs = append(iovs, buf[i:end])
+	}
+
+	return &directReader{
+		d:      d,
+		file:   file,
+		fd:     int(file.Fd()),
+		size:   size,
+		offset: offset,
+		bufp:   bufp,
+		buf:    buf,
+		iovs:   iovs,
+	}, nil
+}
+
+func (r *directReader) Read(p []byte) (int, error) {
+	if r.buf == nil {
+		return 0, os.ErrClosed
+	}
+	if len(r.data) == 0 {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	r.offset += int64(n)
+	return n, nil
+}
+
+// fill reads the buffer from the aligned offset preceding the current one.
+func (r *directReader) fill() error {
+	aligned := r.offset &^ (directReadAlignment - 1)
+	var n int
+	var err error
+	for {
+		n, err = unix.Preadv(r.fd, r.iovs, aligned)
+		if !errors.Is(err, syscall.EINTR) {
+			break
+		}
+	}
+	if err != nil {
+		return &os.PathError{Op: "preadv", Path: r.file.Name(), Err: err}
+	}
+
+	skip := int(r.offset - aligned)
+	if n <= skip {
+		// the file was truncated
+		r.size = r.offset
+		return io.EOF
+	}
+	r.data = r.buf[skip:n]
+	return nil
+}
+
+func (r *directReader) Close() error {
+	if r.buf == nil {
+		return os.ErrClosed
+	}
+	r.d.readBuffers.Put(r.bufp)
+	r.bufp, r.buf, r.iovs, r.data = nil, nil, nil, nil
+	return r.file.Close()
+}
+
+// alignedBuffer returns a buffer of the given size aligned to
+// directReadAlignment.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directReadAlignment)
+	shift := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directReadAlignment - 1)); rem != 0 {
+		shift = directReadAlignment - rem
+	}
+	return buf[shift : shift+size : shift+size]
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package filesystem
+
+import (
+	"errors"
+	"io"
+)
+
+// newDirectReader returns an error, since direct reads are only supported on
+// linux.
+func (d *driver) newDirectReader(name string, size, offset int64) (io.ReadCloser, error) {
+	return nil, errors.New("direct reads are not supported on this platform")
+}
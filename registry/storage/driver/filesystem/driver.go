@@ -8,6 +8,8 @@ import (
 	"io"
 	"os"
 	"path"
+	"strconv"
+	"sync"
 	"time"
 
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
@@ -24,6 +26,14 @@ const (
 	// parameter. If the driver's parameters are less than this we set
 	// the parameters to minThreads
 	minThreads = uint64(25)
+
+	// defaultReadBufferSize is the default size of the buffers of direct
+	// reads, and the size from which files are read directly.
+	defaultReadBufferSize = uint64(4 << 20)
+
+	// directReadAlignment is the alignment of the offsets, sizes and buffers
+	// of direct reads.
+	directReadAlignment = 4096
 )
 
 // DriverParameters represents all configuration options available for the
@@ -31,6 +41,13 @@ const (
 type DriverParameters struct {
 	RootDirectory string
 	MaxThreads    uint64
+
+	// DirectReads enables reading large files with vectored reads
+	// bypassing the page cache, where supported.
+	DirectReads bool
+
+	// ReadBufferSize is the size of the buffers of direct reads.
+	ReadBufferSize uint64
 }
 
 func init() {
@@ -46,6 +63,11 @@ func (factory *filesystemDriverFactory) Create(parameters map[string]interface{}
 
 type driver struct {
 	rootDirectory string
+
+	directReads    bool
+	readBufferSize int
+	// readBuffers pools pointers to the aligned buffers of direct reads.
+	readBuffers sync.Pool
 }
 
 type baseEmbed struct {
@@ -62,6 +84,8 @@ type Driver struct {
 // Optional Parameters:
 // - rootdirectory
 // - maxthreads
+// - directreads
+// - readbuffersize
 func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 	params, err := fromParametersImpl(parameters)
 	if err != nil || params == nil {
@@ -72,9 +96,11 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 
 func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, error) {
 	var (
-		err           error
-		maxThreads    = defaultMaxThreads
-		rootDirectory = defaultRootDirectory
+		err            error
+		maxThreads     = defaultMaxThreads
+		rootDirectory  = defaultRootDirectory
+		directReads    = false
+		readBufferSize = defaultReadBufferSize
 	)
 
 	if parameters != nil {
@@ -86,18 +112,49 @@ func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, e
 		if err != nil {
 			return nil, fmt.Errorf("maxthreads config error: %s", err.Error())
 		}
+
+		switch v := parameters["directreads"].(type) {
+		case string:
+			if directReads, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("the directreads parameter should be a boolean")
+			}
+		case bool:
+			directReads = v
+		case nil:
+			// do nothing
+		default:
+			return nil, fmt.Errorf("the directreads parameter should be a boolean")
+		}
+
+		readBufferSize, err = base.GetLimitFromParameter(parameters["readbuffersize"], directReadAlignment, defaultReadBufferSize)
+		if err != nil {
+			return nil, fmt.Errorf("readbuffersize config error: %s", err.Error())
+		}
+		if readBufferSize%directReadAlignment != 0 {
+			return nil, fmt.Errorf("readbuffersize must be a multiple of %d, %d invalid", directReadAlignment, readBufferSize)
+		}
 	}
 
 	params := &DriverParameters{
-		RootDirectory: rootDirectory,
-		MaxThreads:    maxThreads,
+		RootDirectory:  rootDirectory,
+		MaxThreads:     maxThreads,
+		DirectReads:    directReads,
+		ReadBufferSize: readBufferSize,
 	}
 	return params, nil
 }
 
 // New constructs a new Driver with a given rootDirectory
 func New(params DriverParameters) *Driver {
-	fsDriver := &driver{rootDirectory: params.RootDirectory}
+	readBufferSize := params.ReadBufferSize
+	if readBufferSize == 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	fsDriver := &driver{
+		rootDirectory:  params.RootDirectory,
+		directReads:    params.DirectReads,
+		readBufferSize: int(readBufferSize),
+	}
 
 	return &Driver{
 		baseEmbed: baseEmbed{
@@ -166,6 +223,17 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
 	}
 
+	if d.directReads {
+		if fi, err := file.Stat(); err == nil && fi.Size()-offset >= int64(d.readBufferSize) {
+			// files on filesystems without support for direct reads
+			// are read through the page cache
+			if r, err := d.newDirectReader(file.Name(), fi.Size(), offset); err == nil {
+				file.Close()
+				return r, nil
+			}
+		}
+	}
+
 	return file, nil
 }
 
@@ -1,6 +1,10 @@
 package filesystem
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
 	"os"
 	"reflect"
 	"testing"
@@ -42,8 +46,9 @@ func TestFromParametersImpl(t *testing.T) {
 		{
 			params: map[string]interface{}{},
 			expected: DriverParameters{
-				RootDirectory: defaultRootDirectory,
-				MaxThreads:    defaultMaxThreads,
+				RootDirectory:  defaultRootDirectory,
+				MaxThreads:     defaultMaxThreads,
+				ReadBufferSize: defaultReadBufferSize,
 			},
 			pass: true,
 		},
@@ -60,8 +65,9 @@ func TestFromParametersImpl(t *testing.T) {
 				"maxthreads": "100",
 			},
 			expected: DriverParameters{
-				RootDirectory: defaultRootDirectory,
-				MaxThreads:    uint64(100),
+				RootDirectory:  defaultRootDirectory,
+				MaxThreads:     uint64(100),
+				ReadBufferSize: defaultReadBufferSize,
 			},
 			pass: true,
 		},
@@ -70,8 +76,9 @@ func TestFromParametersImpl(t *testing.T) {
 				"maxthreads": 100,
 			},
 			expected: DriverParameters{
-				RootDirectory: defaultRootDirectory,
-				MaxThreads:    uint64(100),
+				RootDirectory:  defaultRootDirectory,
+				MaxThreads:     uint64(100),
+				ReadBufferSize: defaultReadBufferSize,
 			},
 			pass: true,
 		},
@@ -81,11 +88,40 @@ func TestFromParametersImpl(t *testing.T) {
 				"maxthreads": 1,
 			},
 			expected: DriverParameters{
-				RootDirectory: defaultRootDirectory,
-				MaxThreads:    minThreads,
+				RootDirectory:  defaultRootDirectory,
+				MaxThreads:     minThreads,
+				ReadBufferSize: defaultReadBufferSize,
 			},
 			pass: true,
 		},
+		{
+			params: map[string]interface{}{
+				"directreads":    "true",
+				"readbuffersize": 1 << 20,
+			},
+			expected: DriverParameters{
+				RootDirectory:  defaultRootDirectory,
+				MaxThreads:     defaultMaxThreads,
+				DirectReads:    true,
+				ReadBufferSize: 1 << 20,
+			},
+			pass: true,
+		},
+		{
+			params: map[string]interface{}{
+				"directreads": "fail",
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
+		// the buffer size of direct reads must be aligned
+		{
+			params: map[string]interface{}{
+				"readbuffersize": 5000,
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
 	}
 
 	for _, item := range tests {
@@ -108,3 +144,76 @@ func TestFromParametersImpl(t *testing.T) {
 		}
 	}
 }
+
+func TestDirectReads(t *testing.T) {
+	const bufferSize = 64 << 10
+	d, err := FromParameters(map[string]interface{}{
+		"rootdirectory":  t.TempDir(),
+		"directreads":    true,
+		"readbuffersize": bufferSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	contents := make([]byte, 3*bufferSize+123)
+	rand.Read(contents)
+	if err := d.PutContent(ctx, "/blob", contents); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, offset := range []int64{0, 1, directReadAlignment, bufferSize + 7, 2 * bufferSize, int64(len(contents)) - bufferSize} {
+		rc, err := d.Reader(ctx, "/blob", offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// read in pieces not aligned with the buffer
+		var received bytes.Buffer
+		if _, err := io.CopyBuffer(&received, struct{ io.Reader }{rc}, make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+		rc.Close()
+		if !bytes.Equal(received.Bytes(), contents[offset:]) {
+			t.Fatalf("unexpected content read from offset %d", offset)
+		}
+	}
+}
+
+func benchmarkReader(b *testing.B, parameters map[string]interface{}) {
+	const size = 64 << 20
+	parameters["rootdirectory"] = b.TempDir()
+	d, err := FromParameters(parameters)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+
+	contents := make([]byte, size)
+	rand.Read(contents)
+	if err := d.PutContent(ctx, "/blob", contents); err != nil {
+		b.Fatal(err)
+	}
+
+	buf := make([]byte, 32<<10)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rc, err := d.Reader(ctx, "/blob", 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.CopyBuffer(io.Discard, struct{ io.Reader }{rc}, buf); err != nil {
+			b.Fatal(err)
+		}
+		rc.Close()
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	benchmarkReader(b, map[string]interface{}{})
+}
+
+func BenchmarkDirectReader(b *testing.B) {
+	benchmarkReader(b, map[string]interface{}{"directreads": true})
+}
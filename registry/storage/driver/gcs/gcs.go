@@ -30,6 +30,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/storage"
@@ -56,10 +57,17 @@ const (
 	minConcurrency           = 25
 
 	maxTries = 5
+
+	// iamScope lets credentials without a private key sign URLs through
+	// the IAM Credentials API.
+	iamScope = "https://www.googleapis.com/auth/iam"
 )
 
 var rangeHeader = regexp.MustCompile(`^bytes=([0-9])+-([0-9]+)$`)
 
+// kmsKeyNameRegexp matches the resource names of Cloud KMS keys.
+var kmsKeyNameRegexp = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
 var _ storagedriver.FileWriter = &writer{}
 
 // driverParameters is a struct that encapsulates all of the driver parameters after all values have been set
@@ -72,6 +80,14 @@ type driverParameters struct {
 	chunkSize     int
 	gcs           *storage.Client
 
+	// kmsKeyName is the Cloud KMS key objects are encrypted with, instead
+	// of the default key of the bucket.
+	kmsKeyName string
+
+	// googleAccessID is the service account URLs are signed as through the
+	// IAM Credentials API when there is no private key.
+	googleAccessID string
+
 	// maxConcurrency limits the number of concurrent driver operations
 	// to GCS, which ultimately increases reliability of many simultaneous
 	// pushes by ensuring we aren't DoSing our own server with many
@@ -96,19 +112,29 @@ var _ storagedriver.StorageDriver = &driver{}
 // driver is a storagedriver.StorageDriver implementation backed by GCS
 // Objects are stored at absolute keys in the provided bucket.
 type driver struct {
-	client        *http.Client
-	bucket        string
-	email         string
-	privateKey    []byte
-	rootDirectory string
-	chunkSize     int
-	gcs           *storage.Client
+	client         *http.Client
+	bucket         string
+	email          string
+	privateKey     []byte
+	rootDirectory  string
+	chunkSize      int
+	gcs            *storage.Client
+	kmsKeyName     string
+	googleAccessID string
+
+	// iamSigningDenied is set once signing URLs through the IAM Credentials
+	// API was denied, so that blobs are served without redirects.
+	iamSigningDenied int32
 }
 
 // Wrapper wraps `driver` with a throttler, ensuring that no more than N
 // GCS actions can occur concurrently. The default limit is 75.
 type Wrapper struct {
 	baseEmbed
+
+	// driver is the unthrottled driver, which lists and aborts upload
+	// sessions.
+	driver *driver
 }
 
 type baseEmbed struct {
@@ -118,11 +144,24 @@ type baseEmbed struct {
 // FromParameters constructs a new Driver with a given parameters map
 // Required parameters:
 // - bucket
+// Optional parameters:
+// - keyfile: the path of a service account key or an external account
+// credential configuration, defaulting to the application default credentials
+// - credentials: the content of a keyfile
+// - cmek: the name of the Cloud KMS key objects are encrypted with
+// - googleaccessid: the service account to sign URLs as without a private key
 func FromParameters(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
-	ctx := context.TODO()
+	params, err := fromParameters(context.TODO(), parameters)
+	if err != nil {
+		return nil, err
+	}
+	return New(params)
+}
+
+func fromParameters(ctx context.Context, parameters map[string]interface{}) (driverParameters, error) {
 	bucket, ok := parameters["bucket"]
 	if !ok || fmt.Sprint(bucket) == "" {
-		return nil, fmt.Errorf("No bucket parameter provided")
+		return driverParameters{}, fmt.Errorf("No bucket parameter provided")
 	}
 
 	rootDirectory, ok := parameters["rootdirectory"]
@@ -137,99 +176,112 @@ func FromParameters(parameters map[string]interface{}) (storagedriver.StorageDri
 		case string:
 			vv, err := strconv.Atoi(v)
 			if err != nil {
-				return nil, fmt.Errorf("chunksize parameter must be an integer, %v invalid", chunkSizeParam)
+				return driverParameters{}, fmt.Errorf("chunksize parameter must be an integer, %v invalid", chunkSizeParam)
 			}
 			chunkSize = vv
 		case int, uint, int32, uint32, uint64, int64:
 			chunkSize = int(reflect.ValueOf(v).Convert(reflect.TypeOf(chunkSize)).Int())
 		default:
-			return nil, fmt.Errorf("invalid valud for chunksize: %#v", chunkSizeParam)
+			return driverParameters{}, fmt.Errorf("invalid valud for chunksize: %#v", chunkSizeParam)
 		}
 
 		if chunkSize < minChunkSize {
-			return nil, fmt.Errorf("The chunksize %#v parameter should be a number that is larger than or equal to %d", chunkSize, minChunkSize)
+			return driverParameters{}, fmt.Errorf("The chunksize %#v parameter should be a number that is larger than or equal to %d", chunkSize, minChunkSize)
 		}
 
 		if chunkSize%minChunkSize != 0 {
-			return nil, fmt.Errorf("chunksize should be a multiple of %d", minChunkSize)
+			return driverParameters{}, fmt.Errorf("chunksize should be a multiple of %d", minChunkSize)
 		}
 	}
 
-	var ts oauth2.TokenSource
-	jwtConf := new(jwt.Config)
-	var err error
-	var gcs *storage.Client
+	var jsonKey []byte
 	if keyfile, ok := parameters["keyfile"]; ok {
-		jsonKey, err := os.ReadFile(fmt.Sprint(keyfile))
-		if err != nil {
-			return nil, err
-		}
-		jwtConf, err = google.JWTConfigFromJSON(jsonKey, storage.ScopeFullControl)
-		if err != nil {
-			return nil, err
-		}
-		ts = jwtConf.TokenSource(ctx)
-		gcs, err = storage.NewClient(ctx, option.WithCredentialsFile(fmt.Sprint(keyfile)))
+		var err error
+		jsonKey, err = os.ReadFile(fmt.Sprint(keyfile))
 		if err != nil {
-			return nil, err
+			return driverParameters{}, err
 		}
 	} else if credentials, ok := parameters["credentials"]; ok {
 		credentialMap, ok := credentials.(map[interface{}]interface{})
 		if !ok {
-			return nil, fmt.Errorf("The credentials were not specified in the correct format")
+			return driverParameters{}, fmt.Errorf("The credentials were not specified in the correct format")
 		}
 
 		stringMap := map[string]interface{}{}
 		for k, v := range credentialMap {
 			key, ok := k.(string)
 			if !ok {
-				return nil, fmt.Errorf("One of the credential keys was not a string: %s", fmt.Sprint(k))
+				return driverParameters{}, fmt.Errorf("One of the credential keys was not a string: %s", fmt.Sprint(k))
 			}
 			stringMap[key] = v
 		}
 
-		data, err := json.Marshal(stringMap)
+		var err error
+		jsonKey, err = json.Marshal(stringMap)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to marshal gcs credentials to json")
+			return driverParameters{}, fmt.Errorf("Failed to marshal gcs credentials to json")
 		}
+	}
 
-		jwtConf, err = google.JWTConfigFromJSON(data, storage.ScopeFullControl)
-		if err != nil {
-			return nil, err
-		}
-		ts = jwtConf.TokenSource(ctx)
-		gcs, err = storage.NewClient(ctx, option.WithCredentialsJSON(data))
-		if err != nil {
-			return nil, err
-		}
+	// Service account keys, external account credential configurations for
+	// workload identity federation and, without a keyfile, the application
+	// default credentials such as the ones of GKE workload identity are
+	// supported.
+	var creds *google.Credentials
+	var err error
+	if jsonKey != nil {
+		creds, err = google.CredentialsFromJSON(ctx, jsonKey, storage.ScopeFullControl, iamScope)
 	} else {
-		var err error
-		ts, err = google.DefaultTokenSource(ctx, storage.ScopeFullControl)
-		if err != nil {
-			return nil, err
+		creds, err = google.FindDefaultCredentials(ctx, storage.ScopeFullControl, iamScope)
+	}
+	if err != nil {
+		return driverParameters{}, err
+	}
+	gcs, err := storage.NewClient(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return driverParameters{}, err
+	}
+
+	// service account keys sign URLs locally
+	jwtConf := new(jwt.Config)
+	if len(creds.JSON) > 0 {
+		if conf, err := google.JWTConfigFromJSON(creds.JSON, storage.ScopeFullControl); err == nil {
+			jwtConf = conf
+		}
+	}
+
+	kmsKeyName := ""
+	if cmek, ok := parameters["cmek"]; ok && cmek != nil {
+		kmsKeyName = fmt.Sprint(cmek)
+		if !kmsKeyNameRegexp.MatchString(kmsKeyName) {
+			return driverParameters{}, fmt.Errorf("cmek must be the name of a Cloud KMS key, such as projects/<project>/locations/<location>/keyRings/<keyring>/cryptoKeys/<key>, got %q", kmsKeyName)
 		}
 	}
 
+	googleAccessID := ""
+	if id, ok := parameters["googleaccessid"]; ok && id != nil {
+		googleAccessID = fmt.Sprint(id)
+	}
+
 	maxConcurrency, err := base.GetLimitFromParameter(parameters["maxconcurrency"], minConcurrency, defaultMaxConcurrency)
 	if err != nil {
-		return nil, fmt.Errorf("maxconcurrency config error: %s", err)
+		return driverParameters{}, fmt.Errorf("maxconcurrency config error: %s", err)
 	}
 
-	if gcs == nil {
-		panic("gcs client was nil")
-	}
 	params := driverParameters{
 		bucket:         fmt.Sprint(bucket),
 		rootDirectory:  fmt.Sprint(rootDirectory),
 		email:          jwtConf.Email,
 		privateKey:     jwtConf.PrivateKey,
-		client:         oauth2.NewClient(ctx, ts),
+		client:         oauth2.NewClient(ctx, creds.TokenSource),
 		chunkSize:      chunkSize,
 		maxConcurrency: maxConcurrency,
 		gcs:            gcs,
+		kmsKeyName:     kmsKeyName,
+		googleAccessID: googleAccessID,
 	}
 
-	return New(params)
+	return params, nil
 }
 
 // New constructs a new driver
@@ -241,14 +293,19 @@ func New(params driverParameters) (storagedriver.StorageDriver, error) {
 	if params.chunkSize <= 0 || params.chunkSize%minChunkSize != 0 {
 		return nil, fmt.Errorf("Invalid chunksize: %d is not a positive multiple of %d", params.chunkSize, minChunkSize)
 	}
+	if params.gcs == nil {
+		return nil, fmt.Errorf("No gcs client provided")
+	}
 	d := &driver{
-		bucket:        params.bucket,
-		rootDirectory: rootDirectory,
-		email:         params.email,
-		privateKey:    params.privateKey,
-		client:        params.client,
-		chunkSize:     params.chunkSize,
-		gcs:           params.gcs,
+		bucket:         params.bucket,
+		rootDirectory:  rootDirectory,
+		email:          params.email,
+		privateKey:     params.privateKey,
+		client:         params.client,
+		chunkSize:      params.chunkSize,
+		gcs:            params.gcs,
+		kmsKeyName:     params.kmsKeyName,
+		googleAccessID: params.googleAccessID,
 	}
 
 	return &Wrapper{
@@ -257,6 +314,7 @@ func New(params driverParameters) (storagedriver.StorageDriver, error) {
 				StorageDriver: base.NewRegulator(d, params.maxConcurrency),
 			},
 		},
+		driver: d,
 	}, nil
 }
 
@@ -293,6 +351,7 @@ func (d *driver) PutContent(ctx context.Context, path string, contents []byte) e
 	defer cancel()
 	wc := d.gcs.Bucket(d.bucket).Object(d.pathToKey(path)).NewWriter(ctx)
 	wc.ContentType = "application/octet-stream"
+	wc.KMSKeyName = d.kmsKeyName
 	return putContentsClose(wc, contents)
 }
 
@@ -360,11 +419,12 @@ func getObject(client *http.Client, bucket string, name string, offset int64) (*
 // at the location designated by "path" after the call to Commit.
 func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
 	writer := &writer{
-		client: d.client,
-		bucket: d.bucket,
-		name:   d.pathToKey(path),
-		buffer: make([]byte, d.chunkSize),
-		gcs:    d.gcs,
+		client:     d.client,
+		bucket:     d.bucket,
+		name:       d.pathToKey(path),
+		buffer:     make([]byte, d.chunkSize),
+		gcs:        d.gcs,
+		kmsKeyName: d.kmsKeyName,
 	}
 
 	if append {
@@ -387,6 +447,7 @@ type writer struct {
 	buffer     []byte
 	buffSize   int
 	gcs        *storage.Client
+	kmsKeyName string
 }
 
 // Cancel removes any written content from this FileWriter.
@@ -425,6 +486,7 @@ func (w *writer) Close() error {
 	err = retry(func() error {
 		wc := w.gcs.Bucket(w.bucket).Object(w.name).NewWriter(ctx)
 		wc.ContentType = uploadSessionContentType
+		wc.KMSKeyName = w.kmsKeyName
 		wc.Metadata = map[string]string{
 			"Session-URI": w.sessionURI,
 			"Offset":      strconv.FormatInt(w.offset, 10),
@@ -472,6 +534,7 @@ func (w *writer) Commit() error {
 		err := retry(func() error {
 			wc := w.gcs.Bucket(w.bucket).Object(w.name).NewWriter(ctx)
 			wc.ContentType = "application/octet-stream"
+			wc.KMSKeyName = w.kmsKeyName
 			return putContentsClose(wc, w.buffer[0:w.buffSize])
 		})
 		if err != nil {
@@ -519,7 +582,7 @@ func (w *writer) writeChunk() error {
 	}
 	// if their is no sessionURI yet, obtain one by starting the session
 	if w.sessionURI == "" {
-		w.sessionURI, err = startSession(w.client, w.bucket, w.name)
+		w.sessionURI, err = startSession(w.client, w.bucket, w.name, w.kmsKeyName)
 	}
 	if err != nil {
 		return err
@@ -691,7 +754,7 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 // Move moves an object stored at sourcePath to destPath, removing the
 // original object.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
-	_, err := storageCopyObject(ctx, d.bucket, d.pathToKey(sourcePath), d.bucket, d.pathToKey(destPath), d.gcs)
+	_, err := storageCopyObject(ctx, d.bucket, d.pathToKey(sourcePath), d.bucket, d.pathToKey(destPath), d.kmsKeyName, d.gcs)
 	if err != nil {
 		if status, ok := err.(*googleapi.Error); ok {
 			if status.Code == http.StatusNotFound {
@@ -795,10 +858,12 @@ func storageListObjects(ctx context.Context, bucket string, q *storage.Query, gc
 	return objs, nil
 }
 
-func storageCopyObject(ctx context.Context, srcBucket, srcName string, destBucket, destName string, gcs *storage.Client) (*storage.ObjectAttrs, error) {
+func storageCopyObject(ctx context.Context, srcBucket, srcName string, destBucket, destName string, kmsKeyName string, gcs *storage.Client) (*storage.ObjectAttrs, error) {
 	src := gcs.Bucket(srcBucket).Object(srcName)
 	dst := gcs.Bucket(destBucket).Object(destName)
-	attrs, err := dst.CopierFrom(src).Run(ctx)
+	copier := dst.CopierFrom(src)
+	copier.DestinationKMSKeyName = kmsKeyName
+	attrs, err := copier.Run(ctx)
 	if err != nil {
 		var status *googleapi.Error
 		if errors.As(err, &status) {
@@ -813,9 +878,10 @@ func storageCopyObject(ctx context.Context, srcBucket, srcName string, destBucke
 
 // URLFor returns a URL which may be used to retrieve the content stored at
 // the given path, possibly using the given options.
-// Returns ErrUnsupportedMethod if this driver has no privateKey
+// Without a privateKey, URLs are signed through the IAM Credentials API, and
+// ErrUnsupportedMethod is returned if signing fails.
 func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
-	if d.privateKey == nil {
+	if d.privateKey == nil && atomic.LoadInt32(&d.iamSigningDenied) == 1 {
 		return "", storagedriver.ErrUnsupportedMethod{}
 	}
 
@@ -838,6 +904,10 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 		}
 	}
 
+	if d.privateKey == nil {
+		return d.signURLWithIAM(name, methodString, expiresTime)
+	}
+
 	opts := &storage.SignedURLOptions{
 		GoogleAccessID: d.email,
 		PrivateKey:     d.privateKey,
@@ -847,18 +917,111 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 	return storage.SignedURL(d.bucket, name, opts)
 }
 
+// signURLWithIAM signs a URL as the configured or detected service account
+// through the IAM Credentials API. When the service account is not allowed
+// to sign, signing is not attempted again and blobs are served by the
+// registry instead.
+func (d *driver) signURLWithIAM(name, method string, expires time.Time) (string, error) {
+	u, err := d.gcs.Bucket(d.bucket).SignedURL(name, &storage.SignedURLOptions{
+		GoogleAccessID: d.googleAccessID,
+		Method:         method,
+		Expires:        expires,
+	})
+	if err != nil {
+		var status *googleapi.Error
+		if errors.As(err, &status) && (status.Code == http.StatusForbidden || status.Code == http.StatusNotFound) {
+			if atomic.CompareAndSwapInt32(&d.iamSigningDenied, 0, 1) {
+				logrus.Warnf("gcs: unable to sign URLs through the IAM Credentials API, serving blobs without redirects: %v", err)
+			}
+		} else {
+			logrus.Infof("gcs: error signing URL through the IAM Credentials API: %v", err)
+		}
+		return "", storagedriver.ErrUnsupportedMethod{}
+	}
+	return u, nil
+}
+
 // Walk traverses a filesystem defined within driver, starting
 // from the given path, calling f on each file
 func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
 	return storagedriver.WalkFallback(ctx, d, path, f)
 }
 
-func startSession(client *http.Client, bucket string, name string) (uri string, err error) {
+var _ storagedriver.MultipartUploader = &Wrapper{}
+
+// MultipartUploads lists the resumable upload sessions of the writers closed
+// without being committed under the given path. The start of a session is
+// not recorded, so the time it was last written to is reported instead.
+func (w *Wrapper) MultipartUploads(ctx context.Context, path string) ([]storagedriver.MultipartUpload, error) {
+	d := w.driver
+	objects, err := storageListObjects(ctx, d.bucket, &storage.Query{Prefix: d.pathToDirKey(path)}, d.gcs)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []storagedriver.MultipartUpload
+	for _, obj := range objects {
+		sessionURI := obj.Metadata["Session-URI"]
+		if obj.ContentType != uploadSessionContentType || !obj.Deleted.IsZero() || sessionURI == "" {
+			continue
+		}
+		offset, err := strconv.ParseInt(obj.Metadata["Offset"], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, storagedriver.MultipartUpload{
+			Path:      d.keyToPath(obj.Name),
+			ID:        sessionURI,
+			StartedAt: obj.Updated,
+			Size:      offset + obj.Size,
+		})
+	}
+	return uploads, nil
+}
+
+// AbortMultipartUpload cancels a resumable upload session, then deletes the
+// object recording it.
+func (w *Wrapper) AbortMultipartUpload(ctx context.Context, upload storagedriver.MultipartUpload) error {
+	d := w.driver
+	err := retry(func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, upload.ID, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		// a cancelled session is answered with 499, and an expired or
+		// completed one with 404 or 410
+		case 499, http.StatusNotFound, http.StatusGone:
+			return nil
+		}
+		return googleapi.CheckResponse(resp)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = storageDeleteObject(ctx, d.bucket, d.pathToKey(upload.Path), d.gcs)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
+func startSession(client *http.Client, bucket string, name string, kmsKeyName string) (uri string, err error) {
+	query := url.Values{"uploadType": {"resumable"}, "name": {name}}
+	if kmsKeyName != "" {
+		query.Set("kmsKeyName", kmsKeyName)
+	}
 	u := &url.URL{
 		Scheme:   "https",
 		Host:     "www.googleapis.com",
 		Path:     fmt.Sprintf("/upload/storage/v1/b/%v/o", bucket),
-		RawQuery: fmt.Sprintf("uploadType=resumable&name=%v", name),
+		RawQuery: query.Encode(),
 	}
 	err = retry(func() error {
 		req, err := http.NewRequest(http.MethodPost, u.String(), nil)
@@ -314,3 +314,44 @@ func TestMoveDirectory(t *testing.T) {
 		t.Fatalf("Moving directory /parent/dir /parent/other should have return a non-nil error\n")
 	}
 }
+
+// Test that workload identity federation credentials and cmek are accepted
+// without contacting GCS
+func TestFromParametersCredentialsAndCMEK(t *testing.T) {
+	keyfile := t.TempDir() + "/credentials.json"
+	externalAccount := `{
+		"type": "external_account",
+		"audience": "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/pool/providers/provider",
+		"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+		"token_url": "https://sts.googleapis.com/v1/token",
+		"credential_source": {"file": "/var/run/secrets/token"}
+	}`
+	if err := os.WriteFile(keyfile, []byte(externalAccount), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmek := "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+
+	params, err := fromParameters(dcontext.Background(), map[string]interface{}{
+		"bucket":         "bucket",
+		"keyfile":        keyfile,
+		"cmek":           cmek,
+		"googleaccessid": "registry@p.iam.gserviceaccount.com",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params.kmsKeyName != cmek || params.googleAccessID != "registry@p.iam.gserviceaccount.com" {
+		t.Fatalf("unexpected parameters: %q, %q", params.kmsKeyName, params.googleAccessID)
+	}
+	if params.privateKey != nil {
+		t.Fatal("expected no private key for external account credentials")
+	}
+
+	if _, err := fromParameters(dcontext.Background(), map[string]interface{}{
+		"bucket":  "bucket",
+		"keyfile": keyfile,
+		"cmek":    "projects/p/keyRings/r",
+	}); err == nil {
+		t.Fatal("expected error for an invalid cmek")
+	}
+}
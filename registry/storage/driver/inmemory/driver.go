@@ -293,7 +293,7 @@ func (w *writer) Size() int64 {
 	w.d.mutex.RLock()
 	defer w.d.mutex.RUnlock()
 
-	return int64(len(w.f.data))
+	return int64(len(w.f.data) + w.buffSize)
 }
 
 func (w *writer) Close() error {
@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted files start with a header identifying the master key and holding
+// the salt the key of the file is derived from, followed by the content
+// sealed in chunks of chunkSize bytes. Each chunk is stored as its nonce
+// followed by its ciphertext and tag, and is authenticated with its index and
+// whether it is the final chunk, as in the STREAM construction, so that
+// chunks can neither be reordered nor dropped from the end of the file. A
+// complete file always ends with a final chunk, which is empty if the content
+// is a multiple of chunkSize bytes and was written in full chunks. Only the
+// final chunk may be shorter, so the position of any offset of the content is
+// known without reading the file.
+const (
+	formatVersion = 2
+	keyIDSize     = 8
+	saltSize      = 32
+	headerSize    = 4 + 4 + keyIDSize + saltSize
+	chunkSize     = 64 << 10
+	nonceSize     = 12
+	tagSize       = 16
+	chunkOverhead = nonceSize + tagSize
+	chunkStride   = chunkSize + chunkOverhead
+)
+
+var magic = [4]byte{'D', 'R', 'B', 'E'}
+
+var (
+	errTruncatedChunk = errors.New("truncated chunk")
+	errTruncatedFile  = errors.New("truncated file: missing final chunk")
+	errTrailingData   = errors.New("unexpected data after final chunk")
+)
+
+// header is the header of an encrypted file.
+type header struct {
+	keyID [keyIDSize]byte
+	salt  [saltSize]byte
+}
+
+func newHeader(key *masterKey) (*header, error) {
+	h := &header{keyID: key.id}
+	if _, err := rand.Read(h.salt[:]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *header) marshal() []byte {
+	b := make([]byte, headerSize)
+	copy(b, magic[:])
+	b[4] = formatVersion
+	copy(b[8:], h.keyID[:])
+	copy(b[8+keyIDSize:], h.salt[:])
+	return b
+}
+
+// parseHeader parses the header of a file, returning nil if the file is not
+// encrypted.
+func parseHeader(b []byte) (*header, error) {
+	if len(b) < headerSize || !bytes.Equal(b[:4], magic[:]) {
+		return nil, nil
+	}
+	if b[4] != formatVersion {
+		return nil, fmt.Errorf("unsupported encryption format version %d", b[4])
+	}
+	h := &header{}
+	copy(h.keyID[:], b[8:])
+	copy(h.salt[:], b[8+keyIDSize:])
+	return h, nil
+}
+
+// plaintextSize returns the size of the content of an encrypted file of the
+// given size.
+func plaintextSize(size int64) int64 {
+	body := size - headerSize
+	if body <= 0 {
+		return 0
+	}
+	n := body / chunkStride * chunkSize
+	if r := body % chunkStride; r > chunkOverhead {
+		n += r - chunkOverhead
+	}
+	return n
+}
+
+// sealChunk appends the chunk of the given index sealing plaintext to dst.
+func sealChunk(aead cipher.AEAD, index int64, final bool, plaintext, dst []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, chunkAdditionalData(index, final)), nil
+}
+
+// openChunk appends the plaintext of the chunk of the given index to dst.
+func openChunk(aead cipher.AEAD, index int64, final bool, chunk, dst []byte) ([]byte, error) {
+	if len(chunk) < chunkOverhead {
+		return nil, errTruncatedChunk
+	}
+	return aead.Open(dst, chunk[:nonceSize], chunk[nonceSize:], chunkAdditionalData(index, final))
+}
+
+func chunkAdditionalData(index int64, final bool) []byte {
+	b := make([]byte, 9)
+	binary.BigEndian.PutUint64(b, uint64(index))
+	if final {
+		b[8] = 1
+	}
+	return b
+}
+
+// sealFile encrypts content with the given key.
+func sealFile(key *masterKey, content []byte) ([]byte, error) {
+	h, err := newHeader(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := key.aead(h.salt[:])
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, headerSize+len(content)+(len(content)/chunkSize+1)*chunkOverhead)
+	b = append(b, h.marshal()...)
+	for index := int64(0); ; index++ {
+		n := len(content)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		final := n == len(content)
+		if b, err = sealChunk(aead, index, final, content[:n], b); err != nil {
+			return nil, err
+		}
+		if final {
+			return b, nil
+		}
+		content = content[n:]
+	}
+}
+
+// openFile appends the plaintext of the chunks of a complete file, following
+// its header, to dst.
+func openFile(aead cipher.AEAD, body, dst []byte) ([]byte, error) {
+	for index := int64(0); ; index++ {
+		if len(body) == 0 {
+			return nil, errTruncatedFile
+		}
+		n := len(body)
+		if n > chunkStride {
+			n = chunkStride
+		}
+		final := n == len(body)
+		plaintext, err := openChunk(aead, index, final, body[:n], dst)
+		if err != nil {
+			if final && n == chunkStride {
+				// the last chunk read may not be the final chunk
+				err = errTruncatedFile
+			}
+			return nil, fmt.Errorf("unable to decrypt chunk %d: %v", index, err)
+		}
+		if final {
+			return plaintext, nil
+		}
+		dst = plaintext
+		body = body[n:]
+	}
+}
+
+// chunkReader decrypts the chunks of a file, starting at a chunk boundary.
+type chunkReader struct {
+	rc    io.ReadCloser
+	aead  cipher.AEAD
+	index int64
+	// skip is the number of bytes to skip of the next chunk.
+	skip int
+	// complete is true if the file must end with a final chunk, which is
+	// not the case of an upload whose last chunk is still being written.
+	complete bool
+	// final is true once the final chunk has been read.
+	final bool
+
+	chunk     []byte
+	plaintext []byte
+	buf       []byte
+	err       error
+}
+
+// newChunkReader returns a reader decrypting the chunks read from rc, which
+// starts with the chunk holding offset, as returned by chunkOffset.
+func newChunkReader(rc io.ReadCloser, aead cipher.AEAD, offset int64, complete bool) *chunkReader {
+	index := chunkIndex(offset)
+	return &chunkReader{
+		rc:       rc,
+		aead:     aead,
+		index:    index,
+		skip:     int(offset - index*chunkSize),
+		complete: complete,
+		chunk:    make([]byte, chunkStride),
+	}
+}
+
+// chunkIndex returns the index of the chunk holding offset. An offset at a
+// chunk boundary is held by the end of the previous chunk, so that reading
+// from the end of the content still reads the final chunk and detects
+// whether chunks were dropped.
+func chunkIndex(offset int64) int64 {
+	if offset > 0 && offset%chunkSize == 0 {
+		return offset/chunkSize - 1
+	}
+	return offset / chunkSize
+}
+
+// chunkOffset returns the offset in the file of the chunk holding offset.
+func chunkOffset(offset int64) int64 {
+	return headerSize + chunkIndex(offset)*chunkStride
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill decrypts the next chunk.
+func (r *chunkReader) fill() {
+	n, err := io.ReadFull(r.rc, r.chunk)
+	if n > 0 {
+		if r.final {
+			r.err = errTrailingData
+			return
+		}
+		// only the final chunk is shorter, but the final chunk may be a
+		// full one as well
+		final := r.complete && err == io.ErrUnexpectedEOF
+		plaintext, openErr := openChunk(r.aead, r.index, final, r.chunk[:n], r.plaintext[:0])
+		if openErr != nil && r.complete && !final {
+			final = true
+			plaintext, openErr = openChunk(r.aead, r.index, final, r.chunk[:n], r.plaintext[:0])
+		}
+		if openErr != nil {
+			r.err = fmt.Errorf("unable to decrypt chunk %d: %v", r.index, openErr)
+			return
+		}
+		r.final = final
+		r.plaintext = plaintext
+		r.index++
+		if r.skip > len(plaintext) {
+			r.skip = len(plaintext)
+		}
+		r.buf = plaintext[r.skip:]
+		r.skip = 0
+	}
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		if r.complete && !r.final {
+			r.err = fmt.Errorf("unable to decrypt chunk %d: %v", r.index, errTruncatedFile)
+			return
+		}
+		r.err = io.EOF
+	default:
+		r.err = err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// keySize is the size of the master keys, for AES-256.
+const keySize = 32
+
+// keyLoadTimeout bounds the time spent unwrapping a key with KMS or Vault.
+const keyLoadTimeout = 30 * time.Second
+
+// masterKey is a configured key, from which the keys of the files are
+// derived.
+type masterKey struct {
+	name string
+	id   [keyIDSize]byte
+	key  []byte
+}
+
+func newMasterKey(name string, key []byte) (*masterKey, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key %s must be %d bytes, got %d", name, keySize, len(key))
+	}
+	mk := &masterKey{name: name, key: key}
+	sum := sha256.Sum256(append([]byte("registry blob encryption key\x00"), key...))
+	copy(mk.id[:], sum[:])
+	return mk, nil
+}
+
+// aead returns the cipher of a file, keyed with a key derived from the
+// master key and the salt of the file.
+func (mk *masterKey) aead(salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, mk.key)
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, nonceSize)
+}
+
+// loadKey loads a key configured as a map with its provider as its only key
+// and the options of the provider as its value.
+func loadKey(name string, v interface{}) (*masterKey, error) {
+	config, err := stringMap(v)
+	if err != nil || len(config) != 1 {
+		return nil, fmt.Errorf("key %s must configure exactly one of file, kms or vault", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyLoadTimeout)
+	defer cancel()
+
+	var key []byte
+	for provider, value := range config {
+		switch provider {
+		case "file":
+			key, err = fileKey(value)
+		case "kms":
+			key, err = kmsKey(ctx, value)
+		case "vault":
+			key, err = vaultKey(ctx, value)
+		default:
+			return nil, fmt.Errorf("unknown provider %q for key %s", provider, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key %s from %s: %v", name, provider, err)
+		}
+	}
+	return newMasterKey(name, key)
+}
+
+// fileKey reads a key from a file holding either the raw key or the key
+// encoded in base64.
+func fileKey(v interface{}) ([]byte, error) {
+	path, ok := v.(string)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("file must be the path of the key file")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == keySize {
+		return b, nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+}
+
+// kmsKey decrypts a data key encrypted with AWS KMS. The credentials are
+// taken from the environment unless configured.
+func kmsKey(ctx context.Context, v interface{}) ([]byte, error) {
+	options, err := stringMap(v)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := requiredStringOption(options, "ciphertext")
+	if err != nil {
+		return nil, err
+	}
+	blob, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %v", err)
+	}
+
+	config := aws.NewConfig()
+	if region, err := stringOption(options, "region"); err != nil {
+		return nil, err
+	} else if region != "" {
+		config.WithRegion(region)
+	}
+	if endpoint, err := stringOption(options, "endpoint"); err != nil {
+		return nil, err
+	} else if endpoint != "" {
+		config.WithEndpoint(endpoint)
+	}
+	accessKey, err := stringOption(options, "accesskey")
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := stringOption(options, "secretkey")
+	if err != nil {
+		return nil, err
+	}
+	if accessKey != "" || secretKey != "" {
+		config.WithCredentials(credentials.NewStaticCredentials(accessKey, secretKey, ""))
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	out, err := kms.New(sess).DecryptWithContext(ctx, &kms.DecryptInput{CiphertextBlob: blob})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// vaultKey decrypts a data key encrypted with the transit secrets engine of
+// HashiCorp Vault.
+func vaultKey(ctx context.Context, v interface{}) ([]byte, error) {
+	options, err := stringMap(v)
+	if err != nil {
+		return nil, err
+	}
+	address, err := requiredStringOption(options, "address")
+	if err != nil {
+		return nil, err
+	}
+	name, err := requiredStringOption(options, "key")
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := requiredStringOption(options, "ciphertext")
+	if err != nil {
+		return nil, err
+	}
+	mount, err := stringOption(options, "mount")
+	if err != nil {
+		return nil, err
+	}
+	if mount == "" {
+		mount = "transit"
+	}
+	namespace, err := stringOption(options, "namespace")
+	if err != nil {
+		return nil, err
+	}
+	token, err := stringOption(options, "token")
+	if err != nil {
+		return nil, err
+	}
+	if tokenFile, err := stringOption(options, "tokenfile"); err != nil {
+		return nil, err
+	} else if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no token provided")
+	}
+
+	body, err := json.Marshal(map[string]string{"ciphertext": ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	u := strings.TrimSuffix(address, "/") + "/v1/" + strings.Trim(mount, "/") + "/decrypt/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", token)
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid response from vault: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault responded %s: %s", resp.Status, strings.Join(result.Errors, ", "))
+	}
+	return base64.StdEncoding.DecodeString(result.Data.Plaintext)
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func base64Encode(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestVaultKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, keySize)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Ciphertext string `json:"ciphertext"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/transit/decrypt/registry" || r.Header.Get("X-Vault-Token") != "token" || body.Ciphertext != "vault:v1:wrapped" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"plaintext":"` + base64Encode(key) + `"}}`))
+	}))
+	defer server.Close()
+
+	options := map[string]interface{}{
+		"address":    server.URL,
+		"token":      "token",
+		"key":        "registry",
+		"ciphertext": "vault:v1:wrapped",
+	}
+	mk, err := loadKey("vault", map[string]interface{}{"vault": options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mk.key, key) {
+		t.Fatalf("unexpected key %x", mk.key)
+	}
+
+	options["token"] = "other"
+	if _, err := loadKey("vault", map[string]interface{}{"vault": options}); err == nil {
+		t.Fatal("expected error with an invalid token")
+	}
+}
+
+func TestKMSKey(t *testing.T) {
+	key := bytes.Repeat([]byte{2}, keySize)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			CiphertextBlob []byte
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("X-Amz-Target") != "TrentService.Decrypt" || string(body.CiphertextBlob) != "wrapped" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"InvalidCiphertextException"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(map[string]interface{}{"Plaintext": key, "KeyId": "alias/registry"})
+	}))
+	defer server.Close()
+
+	options := map[string]interface{}{
+		"region":     "us-east-1",
+		"endpoint":   server.URL,
+		"accesskey":  "access",
+		"secretkey":  "secret",
+		"ciphertext": base64Encode([]byte("wrapped")),
+	}
+	mk, err := loadKey("kms", map[string]interface{}{"kms": options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mk.key, key) {
+		t.Fatalf("unexpected key %x", mk.key)
+	}
+
+	options["ciphertext"] = base64Encode([]byte("other"))
+	if _, err := loadKey("kms", map[string]interface{}{"kms": options}); err == nil {
+		t.Fatal("expected error with an invalid ciphertext")
+	}
+}
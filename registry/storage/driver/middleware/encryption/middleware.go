@@ -0,0 +1,570 @@
+// Package middleware - encryption wrapper for storage drivers, encrypting
+// the content of blobs with AES-GCM before it reaches the storage backend
+// and decrypting it on read.
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
+)
+
+const middlewareName = "encryption"
+
+// maxCachedHeaders bounds the number of blob headers kept in memory.
+const maxCachedHeaders = 10000
+
+// stateSuffix is appended to the path of an upload to name the file keeping
+// the state of its writer between appends: the header of the upload and the
+// content of its incomplete last chunk.
+const stateSuffix = ".encryption"
+
+var (
+	// blobDataPathRegexp matches the paths of blob data in storage.
+	blobDataPathRegexp = regexp.MustCompile(`^/docker/registry/v2/blobs/[a-z0-9]+/[0-9a-f]{2}/[0-9a-f]+/data$`)
+	// uploadDataPathRegexp matches the paths of upload data in storage,
+	// capturing the name of the repository.
+	uploadDataPathRegexp = regexp.MustCompile(`^/docker/registry/v2/repositories/(.+)/_uploads/[^/]+/data$`)
+)
+
+// encryptionStorageMiddleware encrypts the data of blobs and uploads, so
+// that the storage backend only holds ciphertext. Uploads are encrypted with
+// the key of their repository, and blobs keep the key of the upload they
+// were moved from. Files are decrypted with the key recorded in their
+// header, so keys may be rotated by configuring a new default key while
+// keeping the previous ones. Files stored before encryption was configured
+// are read as they are.
+type encryptionStorageMiddleware struct {
+	storagedriver.StorageDriver
+
+	keys       map[[keyIDSize]byte]*masterKey
+	defaultKey *masterKey
+	rules      []keyRule
+
+	mu      sync.Mutex
+	headers map[string]*header
+}
+
+// keyRule selects the key of the repositories matching its patterns.
+type keyRule struct {
+	repositories []string
+	key          *masterKey
+}
+
+var _ storagedriver.StorageDriver = &encryptionStorageMiddleware{}
+
+// newEncryptionStorageMiddleware constructs the encryption middleware,
+// loading the configured keys.
+//
+// Required options:
+//
+//   - keys: the keys by name, each configured with one of:
+//     file, the path of a file holding the key, raw or in base64;
+//     kms, the base64 ciphertext of the key encrypted with AWS KMS, along
+//     with the optional region, endpoint, accesskey and secretkey;
+//     vault, the ciphertext of the key encrypted with the transit engine of
+//     HashiCorp Vault, along with its address, the name of the transit key
+//     and the optional mount, namespace, token and tokenfile.
+//
+// Optional options:
+//
+//   - defaultkey: the name of the key used by the repositories matching no
+//     rule, required unless a single key is configured
+//   - rules: the keys of repositories, as a list of repositories patterns
+//     and the name of their key
+func newEncryptionStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	keysOption, err := stringMap(options["keys"])
+	if err != nil || len(keysOption) == 0 {
+		return nil, fmt.Errorf("no keys provided")
+	}
+	keys := make(map[string]*masterKey, len(keysOption))
+	m := &encryptionStorageMiddleware{
+		StorageDriver: sd,
+		keys:          make(map[[keyIDSize]byte]*masterKey, len(keysOption)),
+		headers:       make(map[string]*header),
+	}
+	for name, v := range keysOption {
+		key, err := loadKey(name, v)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := m.keys[key.id]; ok {
+			return nil, fmt.Errorf("keys %s and %s are the same key", other.name, name)
+		}
+		keys[name] = key
+		m.keys[key.id] = key
+	}
+
+	defaultKey, err := stringOption(options, "defaultkey")
+	if err != nil {
+		return nil, err
+	}
+	if defaultKey == "" {
+		if len(keys) != 1 {
+			return nil, fmt.Errorf("no defaultkey provided")
+		}
+		for name := range keys {
+			defaultKey = name
+		}
+	}
+	if m.defaultKey = keys[defaultKey]; m.defaultKey == nil {
+		return nil, fmt.Errorf("unknown defaultkey %s", defaultKey)
+	}
+
+	if m.rules, err = parseRules(options["rules"], keys); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseRules(v interface{}, keys map[string]*masterKey) ([]keyRule, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rules must be a list")
+	}
+	rules := make([]keyRule, 0, len(list))
+	for i, r := range list {
+		options, err := stringMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d: %v", i, err)
+		}
+		name, err := requiredStringOption(options, "key")
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d: %v", i, err)
+		}
+		rule := keyRule{key: keys[name]}
+		if rule.key == nil {
+			return nil, fmt.Errorf("invalid rule %d: unknown key %s", i, name)
+		}
+		repositories, ok := options["repositories"].([]interface{})
+		if !ok || len(repositories) == 0 {
+			return nil, fmt.Errorf("invalid rule %d: repositories must be a list of patterns", i)
+		}
+		for _, p := range repositories {
+			pattern, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid rule %d: repositories must be a list of patterns", i)
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid rule %d: invalid pattern %q: %v", i, pattern, err)
+			}
+			rule.repositories = append(rule.repositories, pattern)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// keyFor returns the key to encrypt the file at path with.
+func (m *encryptionStorageMiddleware) keyFor(p string) *masterKey {
+	match := uploadDataPathRegexp.FindStringSubmatch(p)
+	if match == nil {
+		return m.defaultKey
+	}
+	for _, rule := range m.rules {
+		for _, pattern := range rule.repositories {
+			if ok, _ := path.Match(pattern, match[1]); ok {
+				return rule.key
+			}
+		}
+	}
+	return m.defaultKey
+}
+
+// aead returns the cipher of the file with the given header.
+func (m *encryptionStorageMiddleware) aead(h *header) (cipher.AEAD, error) {
+	key, ok := m.keys[h.keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %x", h.keyID)
+	}
+	return key.aead(h.salt[:])
+}
+
+// header returns the header of the file at path, or nil if it is stored in
+// plaintext. The headers of blobs are cached, since blobs are immutable.
+func (m *encryptionStorageMiddleware) header(ctx context.Context, path string) (*header, error) {
+	blob := isBlobData(path)
+	if blob {
+		m.mu.Lock()
+		h, ok := m.headers[path]
+		m.mu.Unlock()
+		if ok {
+			return h, nil
+		}
+	}
+
+	rc, err := m.StorageDriver.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b := make([]byte, headerSize)
+	n, err := io.ReadFull(rc, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	h, err := parseHeader(b[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	if blob {
+		m.mu.Lock()
+		if len(m.headers) >= maxCachedHeaders {
+			m.headers = make(map[string]*header)
+		}
+		m.headers[path] = h
+		m.mu.Unlock()
+	}
+	return h, nil
+}
+
+// forget removes the cached headers of the files under path.
+func (m *encryptionStorageMiddleware) forget(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for p := range m.headers {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			delete(m.headers, p)
+		}
+	}
+}
+
+// open decrypts the content of a file.
+func (m *encryptionStorageMiddleware) open(content []byte) ([]byte, error) {
+	h, err := parseHeader(content)
+	if err != nil || h == nil {
+		return content, err
+	}
+	aead, err := m.aead(h)
+	if err != nil {
+		return nil, err
+	}
+	return openFile(aead, content[headerSize:], make([]byte, 0, plaintextSize(int64(len(content)))))
+}
+
+// uploadState is the state of the writer of an upload between appends.
+type uploadState struct {
+	header *header
+	tail   []byte
+}
+
+// state returns the state of the writer of the upload at path, or nil if
+// there is none.
+func (m *encryptionStorageMiddleware) state(ctx context.Context, path string) (*uploadState, error) {
+	content, err := m.StorageDriver.GetContent(ctx, path+stateSuffix)
+	if err != nil {
+		if isPathNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	plaintext, err := m.open(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload state of %s: %v", path, err)
+	}
+	h, err := parseHeader(plaintext)
+	if err != nil || h == nil {
+		return nil, fmt.Errorf("invalid upload state of %s", path)
+	}
+	return &uploadState{header: h, tail: plaintext[headerSize:]}, nil
+}
+
+// deleteState deletes the state of the writer of the upload at path.
+func (m *encryptionStorageMiddleware) deleteState(ctx context.Context, path string) error {
+	err := m.StorageDriver.Delete(ctx, path+stateSuffix)
+	if err != nil && !isPathNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// GetContent decrypts the content stored at path.
+func (m *encryptionStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if isUploadData(path) {
+		rc, err := m.Reader(ctx, path, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	content, err := m.StorageDriver.GetContent(ctx, path)
+	if err != nil || !isBlobData(path) {
+		return content, err
+	}
+	plaintext, err := m.open(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: unable to decrypt %s: %v", middlewareName, path, err)
+	}
+	return plaintext, nil
+}
+
+// PutContent encrypts the content stored at path.
+func (m *encryptionStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	if !isEncrypted(path) {
+		return m.StorageDriver.PutContent(ctx, path, content)
+	}
+	m.forget(path)
+	sealed, err := sealFile(m.keyFor(path), content)
+	if err != nil {
+		return err
+	}
+	return m.StorageDriver.PutContent(ctx, path, sealed)
+}
+
+// Reader decrypts the content stored at path from the given offset. The
+// chunks are read from the first one holding the offset, and the incomplete
+// last chunk of an upload from the state of its writer. Other files must end
+// with their final chunk, so that reading a truncated file fails.
+func (m *encryptionStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if !isEncrypted(path) {
+		return m.StorageDriver.Reader(ctx, path, offset)
+	}
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: middlewareName}
+	}
+	h, err := m.header(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return m.StorageDriver.Reader(ctx, path, offset)
+	}
+	aead, err := m.aead(h)
+	if err != nil {
+		return nil, err
+	}
+
+	var tail []byte
+	if isUploadData(path) {
+		state, err := m.state(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			fi, err := m.StorageDriver.Stat(ctx, path)
+			if err != nil {
+				return nil, err
+			}
+			tail = state.tail
+			if size := plaintextSize(fi.Size()); offset >= size {
+				if offset-size > int64(len(tail)) {
+					return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: middlewareName}
+				}
+				return io.NopCloser(bytes.NewReader(tail[offset-size:])), nil
+			}
+		}
+	}
+
+	rc, err := m.StorageDriver.Reader(ctx, path, chunkOffset(offset))
+	if err != nil {
+		if _, ok := err.(storagedriver.InvalidOffsetError); ok {
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: middlewareName}
+		}
+		return nil, err
+	}
+	cr := newChunkReader(rc, aead, offset, tail == nil)
+	if tail == nil {
+		return cr, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(cr, bytes.NewReader(tail)), cr}, nil
+}
+
+// Writer returns a FileWriter encrypting the content written to it. The
+// writer of an upload started before encryption was configured appends
+// plaintext.
+func (m *encryptionStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	if !isEncrypted(path) {
+		return m.StorageDriver.Writer(ctx, path, append)
+	}
+	m.forget(path)
+
+	if !append {
+		key := m.keyFor(path)
+		h, err := newHeader(key)
+		if err != nil {
+			return nil, err
+		}
+		fw, err := m.StorageDriver.Writer(ctx, path, false)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(h.marshal()); err != nil {
+			fw.Cancel(ctx)
+			fw.Close()
+			return nil, err
+		}
+		return newEncryptedFileWriter(ctx, m, path, fw, h, 0, nil)
+	}
+
+	state, err := m.state(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	fw, err := m.StorageDriver.Writer(ctx, path, true)
+	if err != nil || state == nil {
+		return fw, err
+	}
+	body := fw.Size() - headerSize
+	if body < 0 || body%chunkStride != 0 {
+		fw.Close()
+		return nil, fmt.Errorf("%s: unable to append to %s: unexpected size %d", middlewareName, path, fw.Size())
+	}
+	return newEncryptedFileWriter(ctx, m, path, fw, state.header, body/chunkStride, state.tail)
+}
+
+// Stat returns the size of the content stored at path.
+func (m *encryptionStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, err := m.StorageDriver.Stat(ctx, path)
+	if err != nil || fi.IsDir() || !isEncrypted(path) {
+		return fi, err
+	}
+	h, err := m.header(ctx, path)
+	if err != nil || h == nil {
+		return fi, err
+	}
+
+	size := plaintextSize(fi.Size())
+	if isUploadData(path) {
+		state, err := m.StorageDriver.Stat(ctx, path+stateSuffix)
+		if err == nil {
+			size += plaintextSize(state.Size()) - headerSize
+		} else if !isPathNotFound(err) {
+			return nil, err
+		}
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    fi.Path(),
+		Size:    size,
+		ModTime: fi.ModTime(),
+	}}, nil
+}
+
+// List hides the states of the writers of uploads.
+func (m *encryptionStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	list, err := m.StorageDriver.List(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	filtered := list[:0]
+	for _, p := range list {
+		if !strings.HasSuffix(p, stateSuffix) || !isUploadData(strings.TrimSuffix(p, stateSuffix)) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered, nil
+}
+
+// Move moves a file, and deletes the state of the writer of a moved upload.
+func (m *encryptionStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	m.forget(destPath)
+	if err := m.StorageDriver.Move(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	if isUploadData(sourcePath) {
+		return m.deleteState(ctx, sourcePath)
+	}
+	return nil
+}
+
+// Delete deletes the files under path, along with the state of the writer
+// of a deleted upload.
+func (m *encryptionStorageMiddleware) Delete(ctx context.Context, path string) error {
+	m.forget(path)
+	if err := m.StorageDriver.Delete(ctx, path); err != nil {
+		return err
+	}
+	if isUploadData(path) {
+		return m.deleteState(ctx, path)
+	}
+	return nil
+}
+
+// URLFor is not supported for encrypted paths, since the storage backend
+// would serve the ciphertext.
+func (m *encryptionStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if isEncrypted(path) {
+		return "", storagedriver.ErrUnsupportedMethod{DriverName: middlewareName}
+	}
+	return m.StorageDriver.URLFor(ctx, path, options)
+}
+
+func isBlobData(path string) bool {
+	return blobDataPathRegexp.MatchString(path)
+}
+
+func isUploadData(path string) bool {
+	return uploadDataPathRegexp.MatchString(path)
+}
+
+// isEncrypted returns whether the content stored at path is encrypted.
+func isEncrypted(path string) bool {
+	return isBlobData(path) || isUploadData(path)
+}
+
+func isPathNotFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
+
+// stringMap converts a map decoded from the configuration to a map with
+// string keys.
+func stringMap(v interface{}) (map[string]interface{}, error) {
+	switch m := v.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		options := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v", k)
+			}
+			options[key] = v
+		}
+		return options, nil
+	default:
+		return nil, fmt.Errorf("expected a map, got %T", v)
+	}
+}
+
+func stringOption(options map[string]interface{}, name string) (string, error) {
+	switch v := options[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("%s must be a string", name)
+	}
+}
+
+func requiredStringOption(options map[string]interface{}, name string) (string, error) {
+	v, err := stringOption(options, name)
+	if err == nil && v == "" {
+		err = fmt.Errorf("no %s provided", name)
+	}
+	return v, err
+}
+
+func init() {
+	storagemiddleware.Register(middlewareName, newEncryptionStorageMiddleware)
+}
@@ -0,0 +1,394 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+const (
+	blobPath   = "/docker/registry/v2/blobs/sha256/aa/aaaa/data"
+	uploadPath = "/docker/registry/v2/repositories/team-a/app/_uploads/id/data"
+)
+
+// writeKey writes a random key to a file, returning its path.
+func writeKey(t *testing.T) string {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestMiddleware(t *testing.T, backend storagedriver.StorageDriver, options map[string]interface{}) storagedriver.StorageDriver {
+	d, err := newEncryptionStorageMiddleware(backend, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func readAll(t *testing.T, d storagedriver.StorageDriver, path string, offset int64) []byte {
+	rc, err := d.Reader(context.Background(), path, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncryptBlobs(t *testing.T) {
+	backend := inmemory.New()
+	d := newTestMiddleware(t, backend, map[string]interface{}{
+		"keys": map[interface{}]interface{}{
+			"default": map[interface{}]interface{}{"file": writeKey(t)},
+		},
+	})
+	ctx := context.Background()
+
+	contents := make([]byte, 3*chunkSize+chunkSize/2)
+	rand.Read(contents)
+	if err := d.PutContent(ctx, blobPath, contents); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := backend.GetContent(ctx, blobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(stored)) != headerSize+4*chunkOverhead+int64(len(contents)) || bytes.Contains(stored, contents[:64]) {
+		t.Fatalf("expected the backend to store ciphertext, got %d bytes", len(stored))
+	}
+
+	if received, err := d.GetContent(ctx, blobPath); err != nil || !bytes.Equal(received, contents) {
+		t.Fatalf("unexpected content: %v", err)
+	}
+	fi, err := d.Stat(ctx, blobPath)
+	if err != nil || fi.Size() != int64(len(contents)) {
+		t.Fatalf("unexpected file info %+v: %v", fi, err)
+	}
+	for _, offset := range []int64{0, 10, chunkSize, chunkSize + 1, 3 * chunkSize, int64(len(contents)) - 1, int64(len(contents))} {
+		if received := readAll(t, d, blobPath, offset); !bytes.Equal(received, contents[offset:]) {
+			t.Fatalf("unexpected content read from offset %d", offset)
+		}
+	}
+
+	if _, err := d.URLFor(ctx, blobPath, nil); err == nil {
+		t.Fatal("expected URLFor to be unsupported for blobs")
+	}
+
+	// tampering with the ciphertext is detected
+	stored[len(stored)-1] ^= 1
+	if err := backend.PutContent(ctx, blobPath, stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetContent(ctx, blobPath); err == nil {
+		t.Fatal("expected error reading tampered content")
+	}
+}
+
+func TestTruncatedBlob(t *testing.T) {
+	backend := inmemory.New()
+	d := newTestMiddleware(t, backend, map[string]interface{}{
+		"keys": map[string]interface{}{
+			"default": map[string]interface{}{"file": writeKey(t)},
+		},
+	})
+	ctx := context.Background()
+
+	for _, size := range []int{0, chunkSize, 2 * chunkSize, 2*chunkSize + 100} {
+		contents := make([]byte, size)
+		rand.Read(contents)
+
+		// blobs are written by PutContent and by committing an upload, which
+		// ends full chunks with an empty final chunk
+		for _, put := range []func() error{
+			func() error { return d.PutContent(ctx, blobPath, contents) },
+			func() error {
+				w, err := d.Writer(ctx, blobPath, false)
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write(contents); err != nil {
+					return err
+				}
+				if err := w.Commit(); err != nil {
+					return err
+				}
+				return w.Close()
+			},
+		} {
+			if err := put(); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := backend.GetContent(ctx, blobPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if received := readAll(t, d, blobPath, 0); !bytes.Equal(received, contents) {
+				t.Fatalf("unexpected content of %d bytes", size)
+			}
+
+			// dropping the final chunk, or appending data after it, fails
+			// reads instead of returning less or more content
+			body := len(stored) - headerSize
+			last := body % chunkStride
+			if last == 0 {
+				last = chunkStride
+			}
+			for _, tampered := range [][]byte{stored[:len(stored)-last], append(stored[:len(stored):len(stored)], stored[headerSize:headerSize+chunkOverhead]...)} {
+				if err := backend.PutContent(ctx, blobPath, tampered); err != nil {
+					t.Fatal(err)
+				}
+				d.(*encryptionStorageMiddleware).forget(blobPath)
+				if _, err := d.GetContent(ctx, blobPath); err == nil {
+					t.Fatalf("expected error getting tampered content of %d bytes", size)
+				}
+				for _, offset := range []int64{0, int64(size)} {
+					rc, err := d.Reader(ctx, blobPath, offset)
+					if err != nil {
+						continue
+					}
+					_, err = io.ReadAll(rc)
+					rc.Close()
+					if err == nil {
+						t.Fatalf("expected error reading tampered content of %d bytes from offset %d", size, offset)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestResumeUpload(t *testing.T) {
+	backend := inmemory.New()
+	d := newTestMiddleware(t, backend, map[string]interface{}{
+		"keys": map[string]interface{}{
+			"default": map[string]interface{}{"file": writeKey(t)},
+		},
+	})
+	ctx := context.Background()
+
+	contents := make([]byte, 2*chunkSize+100)
+	rand.Read(contents)
+
+	// write the content in parts not aligned with the chunks
+	written := 0
+	for _, n := range []int{1000, chunkSize, chunkSize - 900} {
+		w, err := d.Writer(ctx, uploadPath, written > 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Size() != int64(written) {
+			t.Fatalf("unexpected size of resumed writer: %d, expected %d", w.Size(), written)
+		}
+		if _, err := w.Write(contents[written : written+n]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		written += n
+
+		fi, err := d.Stat(ctx, uploadPath)
+		if err != nil || fi.Size() != int64(written) {
+			t.Fatalf("unexpected file info %+v: %v", fi, err)
+		}
+		for _, offset := range []int64{0, int64(written) - 10, int64(written)} {
+			if received := readAll(t, d, uploadPath, offset); !bytes.Equal(received, contents[offset:written]) {
+				t.Fatalf("unexpected content read from offset %d of %d bytes", offset, written)
+			}
+		}
+	}
+
+	list, err := d.List(ctx, "/docker/registry/v2/repositories/team-a/app/_uploads/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0] != uploadPath {
+		t.Fatalf("expected the state of the writer to be hidden, got %v", list)
+	}
+
+	w, err := d.Writer(ctx, uploadPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(contents[written:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat(ctx, uploadPath+stateSuffix); !isPathNotFound(err) {
+		t.Fatalf("expected the state of the writer to be deleted on commit, got %v", err)
+	}
+
+	if err := d.Move(ctx, uploadPath, blobPath); err != nil {
+		t.Fatal(err)
+	}
+	if received, err := d.GetContent(ctx, blobPath); err != nil || !bytes.Equal(received, contents) {
+		t.Fatalf("unexpected content of the committed blob: %v", err)
+	}
+}
+
+func TestCancelUpload(t *testing.T) {
+	backend := inmemory.New()
+	d := newTestMiddleware(t, backend, map[string]interface{}{
+		"keys": map[string]interface{}{
+			"default": map[string]interface{}{"file": writeKey(t)},
+		},
+	})
+	ctx := context.Background()
+
+	w, err := d.Writer(ctx, uploadPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("partial"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w, err = d.Writer(ctx, uploadPath, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat(ctx, uploadPath+stateSuffix); !isPathNotFound(err) {
+		t.Fatalf("expected the state of the writer to be deleted on cancel, got %v", err)
+	}
+}
+
+func TestRepositoryKeys(t *testing.T) {
+	backend := inmemory.New()
+	defaultKey, teamKey := writeKey(t), writeKey(t)
+	d := newTestMiddleware(t, backend, map[string]interface{}{
+		"keys": map[string]interface{}{
+			"default": map[string]interface{}{"file": defaultKey},
+			"team-a":  map[string]interface{}{"file": teamKey},
+		},
+		"defaultkey": "default",
+		"rules": []interface{}{
+			map[interface{}]interface{}{"repositories": []interface{}{"team-a/*"}, "key": "team-a"},
+		},
+	})
+	ctx := context.Background()
+
+	otherUpload := "/docker/registry/v2/repositories/team-b/app/_uploads/id/data"
+	for _, p := range []string{uploadPath, otherUpload} {
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without the key of team-a, only the other upload can be read
+	withoutTeamKey := newTestMiddleware(t, backend, map[string]interface{}{
+		"keys": map[string]interface{}{
+			"default": map[string]interface{}{"file": defaultKey},
+		},
+	})
+	if _, err := withoutTeamKey.GetContent(ctx, uploadPath); err == nil {
+		t.Fatal("expected error reading the upload of team-a without its key")
+	}
+	if received, err := withoutTeamKey.GetContent(ctx, otherUpload); err != nil || string(received) != otherUpload {
+		t.Fatalf("unexpected content %q: %v", received, err)
+	}
+
+	// blobs keep the key of their upload
+	if err := d.Move(ctx, uploadPath, blobPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := withoutTeamKey.GetContent(ctx, blobPath); err == nil {
+		t.Fatal("expected error reading the blob of team-a without its key")
+	}
+	if received, err := d.GetContent(ctx, blobPath); err != nil || string(received) != uploadPath {
+		t.Fatalf("unexpected content %q: %v", received, err)
+	}
+}
+
+func TestPlaintextFiles(t *testing.T) {
+	backend := inmemory.New()
+	ctx := context.Background()
+	if err := backend.PutContent(ctx, blobPath, []byte("plaintext")); err != nil {
+		t.Fatal(err)
+	}
+	w, err := backend.Writer(ctx, uploadPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("plain"))
+	w.Close()
+
+	d := newTestMiddleware(t, backend, map[string]interface{}{
+		"keys": map[string]interface{}{
+			"default": map[string]interface{}{"file": writeKey(t)},
+		},
+	})
+	if received := readAll(t, d, blobPath, 5); string(received) != "text" {
+		t.Fatalf("unexpected content of plaintext blob: %q", received)
+	}
+	if fi, err := d.Stat(ctx, blobPath); err != nil || fi.Size() != int64(len("plaintext")) {
+		t.Fatalf("unexpected file info %+v: %v", fi, err)
+	}
+
+	// uploads started before encryption was configured stay in plaintext
+	if w, err = d.Writer(ctx, uploadPath, true); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("text"))
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if received, err := backend.GetContent(ctx, uploadPath); err != nil || string(received) != "plaintext" {
+		t.Fatalf("unexpected content of plaintext upload %q: %v", received, err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	key := writeKey(t)
+	encoded := filepath.Join(t.TempDir(), "encoded")
+	raw, _ := os.ReadFile(key)
+	if err := os.WriteFile(encoded, []byte(base64Encode(raw)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newEncryptionStorageMiddleware(inmemory.New(), map[string]interface{}{
+		"keys": map[string]interface{}{"default": map[string]interface{}{"file": encoded}},
+	}); err != nil {
+		t.Fatalf("unexpected error loading a base64 key: %v", err)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{},
+		{"keys": map[string]interface{}{"default": map[string]interface{}{"file": "/does/not/exist"}}},
+		{"keys": map[string]interface{}{"default": map[string]interface{}{"unknown": key}}},
+		{"keys": map[string]interface{}{"a": map[string]interface{}{"file": key}, "b": map[string]interface{}{"file": writeKey(t)}}},
+		{"keys": map[string]interface{}{"a": map[string]interface{}{"file": key}, "b": map[string]interface{}{"file": encoded}}, "defaultkey": "a"},
+		{"keys": map[string]interface{}{"a": map[string]interface{}{"file": key}}, "defaultkey": "b"},
+		{"keys": map[string]interface{}{"a": map[string]interface{}{"file": key}}, "rules": []interface{}{map[string]interface{}{"repositories": []interface{}{"x/*"}, "key": "b"}}},
+		{"keys": map[string]interface{}{"a": map[string]interface{}{"file": key}}, "rules": []interface{}{map[string]interface{}{"repositories": []interface{}{"["}, "key": "a"}}},
+	} {
+		if _, err := newEncryptionStorageMiddleware(inmemory.New(), invalid); err == nil {
+			t.Errorf("expected error for %v", invalid)
+		} else if strings.Contains(err.Error(), "%!") {
+			t.Errorf("malformed error: %v", err)
+		}
+	}
+}
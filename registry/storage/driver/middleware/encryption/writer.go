@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"context"
+	"crypto/cipher"
+	"fmt"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// encryptedFileWriter seals the content written to it in chunks. Since the
+// underlying writer only appends, the incomplete last chunk is kept in the
+// state of the writer when it is closed, and only written to the file on
+// commit, as the final chunk.
+type encryptedFileWriter struct {
+	ctx    context.Context
+	m      *encryptionStorageMiddleware
+	path   string
+	fw     storagedriver.FileWriter
+	header *header
+	key    *masterKey
+	aead   cipher.AEAD
+	// chunks is the number of complete chunks written to the file.
+	chunks int64
+	buf    []byte
+	sealed []byte
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func newEncryptedFileWriter(ctx context.Context, m *encryptionStorageMiddleware, path string, fw storagedriver.FileWriter, h *header, chunks int64, tail []byte) (*encryptedFileWriter, error) {
+	key, ok := m.keys[h.keyID]
+	if !ok {
+		fw.Close()
+		return nil, fmt.Errorf("%s: unknown encryption key %x of %s", middlewareName, h.keyID, path)
+	}
+	aead, err := key.aead(h.salt[:])
+	if err != nil {
+		fw.Close()
+		return nil, err
+	}
+	buf := make([]byte, len(tail), chunkSize)
+	copy(buf, tail)
+	return &encryptedFileWriter{
+		ctx:    ctx,
+		m:      m,
+		path:   path,
+		fw:     fw,
+		header: h,
+		key:    key,
+		aead:   aead,
+		chunks: chunks,
+		buf:    buf,
+	}, nil
+}
+
+func (w *encryptedFileWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		k := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+k]
+		p = p[k:]
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		n += k
+	}
+	return n, nil
+}
+
+// flush writes the buffered content to the file as the next chunk.
+func (w *encryptedFileWriter) flush(final bool) error {
+	sealed, err := sealChunk(w.aead, w.chunks, final, w.buf, w.sealed[:0])
+	if err != nil {
+		return err
+	}
+	w.sealed = sealed
+	if _, err := w.fw.Write(sealed); err != nil {
+		return err
+	}
+	w.chunks++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *encryptedFileWriter) Size() int64 {
+	return w.chunks*chunkSize + int64(len(w.buf))
+}
+
+// Close saves the state of the writer, so that it may be resumed.
+func (w *encryptedFileWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if !w.committed && !w.cancelled {
+		state, err := sealFile(w.key, append(w.header.marshal(), w.buf...))
+		if err == nil {
+			err = w.m.StorageDriver.PutContent(w.ctx, w.path+stateSuffix, state)
+		}
+		if err != nil {
+			w.fw.Close()
+			return fmt.Errorf("%s: unable to save the state of %s: %v", middlewareName, w.path, err)
+		}
+	}
+	return w.fw.Close()
+}
+
+func (w *encryptedFileWriter) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+	if err := w.m.deleteState(ctx, w.path); err != nil {
+		return err
+	}
+	return w.fw.Cancel(ctx)
+}
+
+// Commit writes the incomplete last chunk as the final chunk, even if it is
+// empty, and deletes the state of the writer.
+func (w *encryptedFileWriter) Commit() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	if err := w.fw.Commit(); err != nil {
+		return err
+	}
+	w.committed = true
+	return w.m.deleteState(w.ctx, w.path)
+}
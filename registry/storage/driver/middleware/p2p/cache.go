@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// blobCache is a size-bounded cache of blobs on the local disk. The least
+// recently read blobs are evicted first.
+type blobCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *cacheEntry, most recently read first
+	entries map[digest.Digest]*list.Element
+}
+
+type cacheEntry struct {
+	dgst digest.Digest
+	size int64
+}
+
+// newBlobCache returns the cache of the blobs in dir, indexing the blobs
+// cached by a previous run.
+func newBlobCache(dir string, maxSize int64) (*blobCache, error) {
+	c := &blobCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[digest.Digest]*list.Element),
+	}
+
+	if err := os.RemoveAll(c.tmpDir()); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.tmpDir(), 0o755); err != nil {
+		return nil, err
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == c.tmpDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		dgst := digest.Digest(filepath.Dir(rel) + ":" + filepath.Base(rel))
+		if dgst.Validate() != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		c.add(dgst, info.Size())
+		c.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *blobCache) tmpDir() string {
+	return filepath.Join(c.dir, "tmp")
+}
+
+func (c *blobCache) path(dgst digest.Digest) string {
+	return filepath.Join(c.dir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// fits returns true if a blob of the given size can be cached.
+func (c *blobCache) fits(size int64) bool {
+	return size <= c.maxSize
+}
+
+// open opens the cached blob, positioned at offset.
+func (c *blobCache) open(dgst digest.Digest, offset int64) (*os.File, error) {
+	c.mu.Lock()
+	elem, ok := c.entries[dgst]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	// the blob may be evicted before it is opened, in which case opening
+	// fails and the blob is read from elsewhere
+	f, err := os.Open(c.path(dgst))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// store caches the blob read from r, verifying its size and digest.
+func (c *blobCache) store(dgst digest.Digest, size int64, r io.Reader) error {
+	f, err := os.CreateTemp(c.tmpDir(), "blob-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	verifier := dgst.Verifier()
+	n, err := io.Copy(io.MultiWriter(f, verifier), io.LimitReader(r, size+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("blob %s has size %d, expected %d", dgst, n, size)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s does not match its digest", dgst)
+	}
+
+	target := c.path(dgst)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), target); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(dgst, size)
+	return nil
+}
+
+// add indexes a cached blob and evicts the least recently read blobs if the
+// cache exceeds its size. The lock must be held.
+func (c *blobCache) add(dgst digest.Digest, size int64) {
+	if elem, ok := c.entries[dgst]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[dgst] = c.lru.PushFront(&cacheEntry{dgst: dgst, size: size})
+	c.size += size
+
+	for c.size > c.maxSize {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.entries, entry.dgst)
+		c.size -= entry.size
+		os.Remove(c.path(entry.dgst))
+	}
+}
@@ -0,0 +1,350 @@
+// Package middleware - p2p wrapper for storage drivers, letting the nodes of
+// a registry cluster fetch blobs from each other before falling back to the
+// storage backend.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	prometheus "github.com/docker/distribution/metrics"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	defaultCacheSize = 10 << 30 // 10GiB
+	defaultTimeout   = 5 * time.Second
+	defaultRefresh   = 30 * time.Second
+)
+
+// blobDataPathRegexp matches the paths of blob data in storage, capturing
+// the algorithm and the encoded digest.
+var blobDataPathRegexp = regexp.MustCompile(`^/docker/registry/v2/blobs/([a-z0-9]+)/[0-9a-f]{2}/([0-9a-f]+)/data$`)
+
+// blobReads counts the blob reads by the source they were served from.
+var blobReads = prometheus.StorageNamespace.NewLabeledCounter("p2p_reads", "The number of blob reads by source", "source")
+
+// p2pStorageMiddleware caches the blobs read by the node on its local disk
+// and serves them to the other nodes of the cluster. Blobs missing from the
+// cache are fetched from the other nodes, verified by digest, before falling
+// back to the storage backend, cutting the egress of the backend.
+type p2pStorageMiddleware struct {
+	storagedriver.StorageDriver
+
+	cache  *blobCache
+	peers  *peerSet
+	client *http.Client
+	secret string
+
+	mu       sync.Mutex
+	inflight map[digest.Digest]chan struct{}
+}
+
+var _ storagedriver.StorageDriver = &p2pStorageMiddleware{}
+
+// newP2PStorageMiddleware constructs the p2p middleware, listening for the
+// requests of the other nodes if an address is configured.
+//
+// Required options:
+//
+//   - cachedir: the directory of the local blob cache
+//
+// Optional options:
+//
+//   - cachesize: the maximum size of the local blob cache in bytes,
+//     defaults to 10GiB
+//   - listen: the address to serve the local blob cache to other nodes on,
+//     which requires a secret
+//   - peers: the base URLs of the other nodes, such as http://node-2:5002
+//   - dns: a host:port whose host resolves to the addresses of the nodes
+//   - refresh: the interval between DNS resolutions, defaults to 30s
+//   - timeout: the time to wait for a node to respond, defaults to 5s
+//   - secret: a secret shared by the nodes to authenticate each other,
+//     required to listen
+func newP2PStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	cacheDir, err := stringOption(options, "cachedir")
+	if err != nil {
+		return nil, err
+	}
+	if cacheDir == "" {
+		return nil, fmt.Errorf("no cachedir provided")
+	}
+	cacheSize, err := int64Option(options, "cachesize", defaultCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	listen, err := stringOption(options, "listen")
+	if err != nil {
+		return nil, err
+	}
+	dnsAddr, err := stringOption(options, "dns")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := stringOption(options, "secret")
+	if err != nil {
+		return nil, err
+	}
+	if listen != "" && secret == "" {
+		// the cache holds the blobs of private repositories as well
+		return nil, fmt.Errorf("a secret is required to listen for peers")
+	}
+	refresh, err := durationOption(options, "refresh", defaultRefresh)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := durationOption(options, "timeout", defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	switch p := options["peers"].(type) {
+	case nil:
+	case string:
+		peers = strings.Split(p, ",")
+	case []interface{}:
+		for _, peer := range p {
+			s, ok := peer.(string)
+			if !ok {
+				return nil, fmt.Errorf("peers must be a list of strings")
+			}
+			peers = append(peers, s)
+		}
+	default:
+		return nil, fmt.Errorf("peers must be a list of strings")
+	}
+	for i, peer := range peers {
+		peers[i] = strings.TrimSuffix(strings.TrimSpace(peer), "/")
+	}
+
+	cache, err := newBlobCache(cacheDir, cacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the blob cache: %v", err)
+	}
+	peerSet, err := newPeerSet(peers, dnsAddr, refresh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns address %q: %v", dnsAddr, err)
+	}
+
+	if listen != "" {
+		ln, err := net.Listen("tcp", listen)
+		if err != nil {
+			return nil, fmt.Errorf("unable to listen for peers: %v", err)
+		}
+		server := &http.Server{
+			Handler:           &peerHandler{cache: cache, secret: secret},
+			ReadHeaderTimeout: timeout,
+		}
+		go func() {
+			if err := server.Serve(ln); err != nil {
+				dcontext.GetLogger(context.Background()).Errorf("p2p: error serving peers: %v", err)
+			}
+		}()
+	}
+
+	return &p2pStorageMiddleware{
+		StorageDriver: sd,
+		cache:         cache,
+		peers:         peerSet,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+				ResponseHeaderTimeout: timeout,
+				MaxIdleConnsPerHost:   4,
+			},
+		},
+		secret:   secret,
+		inflight: make(map[digest.Digest]chan struct{}),
+	}, nil
+}
+
+// Reader serves blob data from the local cache, filling it from the other
+// nodes or the storage backend on a miss. Other paths are read from the
+// storage backend.
+func (m *p2pStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	dgst, ok := blobDigest(path)
+	if !ok {
+		return m.StorageDriver.Reader(ctx, path, offset)
+	}
+
+	if f, err := m.cache.open(dgst, offset); err == nil {
+		blobReads.WithValues("local").Inc(1)
+		return f, nil
+	}
+
+	if err := m.fill(ctx, path, dgst); err != nil {
+		var notFound storagedriver.PathNotFoundError
+		if !errors.As(err, &notFound) {
+			dcontext.GetLogger(ctx).Warnf("p2p: error caching blob %s, reading from storage: %v", dgst, err)
+		}
+	} else if f, err := m.cache.open(dgst, offset); err == nil {
+		return f, nil
+	}
+
+	blobReads.WithValues("backend").Inc(1)
+	return m.StorageDriver.Reader(ctx, path, offset)
+}
+
+// URLFor is not supported for blob data, so that blobs are served by the
+// nodes from their caches rather than by the storage backend.
+func (m *p2pStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if _, ok := blobDigest(path); ok {
+		return "", storagedriver.ErrUnsupportedMethod{DriverName: "p2p"}
+	}
+	return m.StorageDriver.URLFor(ctx, path, options)
+}
+
+// fill caches the blob, fetching it from the first node which has it or
+// from the storage backend. Concurrent reads of a missing blob wait for a
+// single fill.
+func (m *p2pStorageMiddleware) fill(ctx context.Context, path string, dgst digest.Digest) error {
+	m.mu.Lock()
+	if done, ok := m.inflight[dgst]; ok {
+		m.mu.Unlock()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	done := make(chan struct{})
+	m.inflight[dgst] = done
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.inflight, dgst)
+		m.mu.Unlock()
+		close(done)
+	}()
+
+	fi, err := m.StorageDriver.Stat(ctx, path)
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if !m.cache.fits(size) {
+		return fmt.Errorf("blob of %d bytes exceeds the cache size", size)
+	}
+
+	for _, peer := range m.peers.list() {
+		err := m.fetch(ctx, peer, dgst, size)
+		if err == nil {
+			blobReads.WithValues("peer").Inc(1)
+			return nil
+		}
+		dcontext.GetLogger(ctx).Debugf("p2p: blob %s not fetched from %s: %v", dgst, peer, err)
+	}
+
+	rc, err := m.StorageDriver.Reader(ctx, path, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := m.cache.store(dgst, size, rc); err != nil {
+		return err
+	}
+	blobReads.WithValues("backend").Inc(1)
+	return nil
+}
+
+// fetch caches the blob served by the peer.
+func (m *p2pStorageMiddleware) fetch(ctx context.Context, peer string, dgst digest.Digest, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+blobsPath+dgst.Algorithm().String()+"/"+dgst.Encoded(), nil)
+	if err != nil {
+		return err
+	}
+	if m.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+m.secret)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return m.cache.store(dgst, size, resp.Body)
+}
+
+// blobDigest returns the digest of the blob whose data is stored at path.
+func blobDigest(path string) (digest.Digest, bool) {
+	match := blobDataPathRegexp.FindStringSubmatch(path)
+	if match == nil {
+		return "", false
+	}
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(match[1]), match[2])
+	return dgst, dgst.Validate() == nil
+}
+
+func stringOption(options map[string]interface{}, name string) (string, error) {
+	switch v := options[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("%s must be a string", name)
+	}
+}
+
+func int64Option(options map[string]interface{}, name string, defaultValue int64) (int64, error) {
+	switch v := options[name].(type) {
+	case nil:
+		return defaultValue, nil
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("%s must be an integer", name)
+	}
+}
+
+func durationOption(options map[string]interface{}, name string, defaultValue time.Duration) (time.Duration, error) {
+	switch v := options[name].(type) {
+	case nil:
+		return defaultValue, nil
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		return d, nil
+	default:
+		return 0, fmt.Errorf("%s must be a duration", name)
+	}
+}
+
+func init() {
+	storagemiddleware.Register("p2p", newP2PStorageMiddleware)
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// countingDriver counts the reads of the storage backend.
+type countingDriver struct {
+	storagedriver.StorageDriver
+	reads int32
+}
+
+func (d *countingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	atomic.AddInt32(&d.reads, 1)
+	return d.StorageDriver.Reader(ctx, path, offset)
+}
+
+func blobPath(dgst digest.Digest) string {
+	return "/docker/registry/v2/blobs/" + dgst.Algorithm().String() + "/" + dgst.Encoded()[:2] + "/" + dgst.Encoded() + "/data"
+}
+
+func newTestNode(t *testing.T, backend storagedriver.StorageDriver, options map[string]interface{}) *p2pStorageMiddleware {
+	t.Helper()
+
+	options["cachedir"] = t.TempDir()
+	d, err := newP2PStorageMiddleware(backend, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	return d.(*p2pStorageMiddleware)
+}
+
+func readAll(t *testing.T, d storagedriver.StorageDriver, path string, offset int64) string {
+	t.Helper()
+
+	rc, err := d.Reader(context.Background(), path, offset)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	defer rc.Close()
+	p, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	return string(p)
+}
+
+func TestP2PReader(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDriver{StorageDriver: inmemory.New()}
+
+	content := []byte("some blob content")
+	dgst := digest.FromBytes(content)
+	path := blobPath(dgst)
+	if err := backend.PutContent(ctx, path, content); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestNode(t, backend, map[string]interface{}{})
+	peerA := httptest.NewServer(&peerHandler{cache: a.cache, secret: "secret"})
+	defer peerA.Close()
+
+	// a corrupted peer is skipped
+	corrupted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "some corrupted data")
+	}))
+	defer corrupted.Close()
+
+	b := newTestNode(t, backend, map[string]interface{}{
+		"peers":  []interface{}{corrupted.URL, peerA.URL},
+		"secret": "secret",
+	})
+
+	for _, tc := range []struct {
+		node     storagedriver.StorageDriver
+		offset   int64
+		expected int32
+	}{
+		{node: a, expected: 1},            // from the backend
+		{node: a, offset: 5, expected: 1}, // from the local cache
+		{node: b, offset: 5, expected: 1}, // from a
+		{node: b, expected: 1},            // from the local cache
+	} {
+		if p := readAll(t, tc.node, path, tc.offset); p != string(content[tc.offset:]) {
+			t.Fatalf("unexpected content: %q", p)
+		}
+		if reads := atomic.LoadInt32(&backend.reads); reads != tc.expected {
+			t.Fatalf("unexpected number of backend reads: %d != %d", reads, tc.expected)
+		}
+	}
+
+	// other paths are read from the backend
+	if err := backend.PutContent(ctx, "/other", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if p := readAll(t, b, "/other", 0); p != "other" {
+		t.Fatalf("unexpected content: %q", p)
+	}
+	if reads := atomic.LoadInt32(&backend.reads); reads != 2 {
+		t.Fatalf("unexpected number of backend reads: %d != 2", reads)
+	}
+
+	if _, err := b.URLFor(ctx, path, nil); err == nil {
+		t.Fatal("expected blob data not to be redirected to storage")
+	}
+}
+
+func TestP2PPeerHandlerSecret(t *testing.T) {
+	a := newTestNode(t, inmemory.New(), map[string]interface{}{})
+	peerA := httptest.NewServer(&peerHandler{cache: a.cache, secret: "secret"})
+	defer peerA.Close()
+
+	resp, err := http.Get(peerA.URL + blobsPath + "sha256/" + digest.FromString("x").Encoded())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d != %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestP2PListenRequiresSecret(t *testing.T) {
+	if _, err := newP2PStorageMiddleware(inmemory.New(), map[string]interface{}{
+		"cachedir": t.TempDir(),
+		"listen":   "127.0.0.1:0",
+	}); err == nil {
+		t.Fatal("expected error listening without a secret")
+	}
+
+	a := newTestNode(t, inmemory.New(), map[string]interface{}{})
+	peerA := httptest.NewServer(&peerHandler{cache: a.cache})
+	defer peerA.Close()
+
+	resp, err := http.Get(peerA.URL + blobsPath + "sha256/" + digest.FromString("x").Encoded())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d != %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestBlobCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newBlobCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dgsts []digest.Digest
+	for _, content := range []string{"first", "second"} {
+		dgst := digest.FromString(content)
+		if err := c.store(dgst, int64(len(content)), strings.NewReader(content)); err != nil {
+			t.Fatalf("unexpected error storing %s: %v", content, err)
+		}
+		dgsts = append(dgsts, dgst)
+	}
+	if err := c.store(digest.FromString("other"), 5, strings.NewReader("wrong")); err == nil {
+		t.Fatal("expected an error storing content not matching its digest")
+	}
+
+	if _, err := c.open(dgsts[0], 0); err == nil {
+		t.Fatal("expected the least recently read blob to be evicted")
+	}
+
+	// cached blobs are indexed again when the cache is reopened
+	c, err = newBlobCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.open(dgsts[1], 0)
+	if err != nil {
+		t.Fatalf("unexpected error opening cached blob: %v", err)
+	}
+	f.Close()
+}
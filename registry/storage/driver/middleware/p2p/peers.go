@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+)
+
+// peerSet is the set of the other nodes of the cluster, listed statically
+// or resolved from a DNS name.
+type peerSet struct {
+	static []string
+
+	mu       sync.RWMutex
+	resolved []string
+}
+
+// newPeerSet returns the set of the static peers, and of the peers resolved
+// from the host of the DNS address, if any, refreshed periodically.
+func newPeerSet(static []string, dnsAddr string, refresh time.Duration) (*peerSet, error) {
+	ps := &peerSet{static: static}
+	if dnsAddr == "" {
+		return ps, nil
+	}
+
+	host, port, err := net.SplitHostPort(dnsAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.resolve(host, port)
+	go func() {
+		for range time.Tick(refresh) {
+			ps.resolve(host, port)
+		}
+	}()
+	return ps, nil
+}
+
+// resolve replaces the resolved peers with the addresses of the host. The
+// previous peers are kept if the host cannot be resolved.
+func (ps *peerSet) resolve(host, port string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("p2p: error resolving peers from %s: %v", host, err)
+		return
+	}
+
+	resolved := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		resolved = append(resolved, "http://"+net.JoinHostPort(addr, port))
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.resolved = resolved
+}
+
+// list returns the peers in a random order, so that requests are spread
+// across them.
+func (ps *peerSet) list() []string {
+	ps.mu.RLock()
+	peers := make([]string, 0, len(ps.static)+len(ps.resolved))
+	peers = append(peers, ps.static...)
+	peers = append(peers, ps.resolved...)
+	ps.mu.RUnlock()
+
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	return peers
+}
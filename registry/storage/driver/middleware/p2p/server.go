@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// blobsPath is the path under which nodes serve their cached blobs to each
+// other, followed by the algorithm and the encoded digest.
+const blobsPath = "/blobs/"
+
+// peerHandler serves the blobs of the local cache to the other nodes. Blobs
+// which are not cached are not read from storage, so that a node missing a
+// blob can move on to the next peer quickly.
+type peerHandler struct {
+	cache  *blobCache
+	secret string
+}
+
+func (h *peerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	algorithm, encoded, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, blobsPath), "/")
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(algorithm), encoded)
+	if !ok || !strings.HasPrefix(r.URL.Path, blobsPath) || dgst.Validate() != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := h.cache.open(dgst, 0)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// authorized returns whether the request carries the secret shared by the
+// nodes. Requests are never authorized without a secret.
+func (h *peerHandler) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return h.secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) == 1
+}
@@ -28,12 +28,41 @@ func Register(name string, initFunc InitFunc) error {
 }
 
 // Get constructs a StorageMiddleware with the given options using the named backend.
+// The multipart uploads of the storage driver, if it keeps any, are passed
+// through the middleware.
 func Get(name string, options map[string]interface{}, storageDriver storagedriver.StorageDriver) (storagedriver.StorageDriver, error) {
 	if storageMiddlewares != nil {
 		if initFunc, exists := storageMiddlewares[name]; exists {
-			return initFunc(storageDriver, options)
+			smw, err := initFunc(storageDriver, options)
+			if err != nil {
+				return nil, err
+			}
+			return passMultipartUploads(smw, storageDriver), nil
 		}
 	}
 
 	return nil, fmt.Errorf("no storage middleware registered with name: %s", name)
 }
+
+// multipartUploadMiddleware is a middleware passing the multipart uploads
+// of the driver it wraps through. Middlewares store files at the same paths
+// as the driver they wrap, so the uploads of the driver are those of the
+// middleware.
+type multipartUploadMiddleware struct {
+	storagedriver.StorageDriver
+	storagedriver.MultipartUploader
+}
+
+// passMultipartUploads returns smw, implementing the
+// storagedriver.MultipartUploader interface if storageDriver does, so that
+// multipart uploads can be aborted through any middleware.
+func passMultipartUploads(smw, storageDriver storagedriver.StorageDriver) storagedriver.StorageDriver {
+	if _, ok := smw.(storagedriver.MultipartUploader); ok {
+		return smw
+	}
+	uploader, ok := storageDriver.(storagedriver.MultipartUploader)
+	if !ok {
+		return smw
+	}
+	return &multipartUploadMiddleware{StorageDriver: smw, MultipartUploader: uploader}
+}
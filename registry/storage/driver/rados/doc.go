@@ -0,0 +1,6 @@
+// Package rados implements the storage layout of a Ceph RADOS storage driver
+// backend over a pool of objects with omaps. The driver is not registered
+// yet: the librados binding it needs, github.com/ceph/go-ceph, is not part of
+// the module's dependencies, so the driver cannot be configured until it is
+// vendored and the binding is added behind the "include_rados" build tag.
+package rados
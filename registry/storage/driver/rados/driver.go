@@ -0,0 +1,653 @@
+package rados
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/base"
+	"github.com/docker/distribution/uuid"
+)
+
+const driverName = "rados"
+
+// listBatchSize is the number of directory entries read from an omap at once.
+const listBatchSize = 1000
+
+// errNotFound is returned by a pool when an object or omap key does not
+// exist.
+var errNotFound = errors.New("object not found")
+
+// pool is the subset of the operations of a librados I/O context used by
+// the driver.
+type pool interface {
+	// Read reads the data of an object at offset into data.
+	Read(oid string, data []byte, offset uint64) (int, error)
+	// Write writes data to an object at offset, creating the object if
+	// needed.
+	Write(oid string, data []byte, offset uint64) error
+	// Delete deletes an object.
+	Delete(oid string) error
+	// GetOmapValue returns the value of key in the omap of an object.
+	GetOmapValue(oid, key string) ([]byte, error)
+	// ListOmapValues calls fn with at most maxReturn keys and values of the
+	// omap of an object, in order, starting after startAfter.
+	ListOmapValues(oid, startAfter string, maxReturn int64, fn func(key string, value []byte)) error
+	// SetOmap sets keys in the omap of an object, creating the object if
+	// needed.
+	SetOmap(oid string, pairs map[string][]byte) error
+	// RmOmapKeys removes keys from the omap of an object.
+	RmOmapKeys(oid string, keys []string) error
+}
+
+// layout describes how the data of a file is striped over RADOS objects, in
+// the same way as the file layouts of CephFS: consecutive stripe units are
+// spread over stripe count objects, until these objects reach object size
+// and the next set of objects is started.
+type layout struct {
+	StripeUnit  int64 `json:"stripeunit"`
+	StripeCount int64 `json:"stripecount"`
+	ObjectSize  int64 `json:"objectsize"`
+}
+
+func (l layout) validate() error {
+	if l.StripeUnit <= 0 || l.StripeCount <= 0 || l.ObjectSize <= 0 {
+		return fmt.Errorf("stripeunit, stripecount and objectsize must be positive")
+	}
+	if l.ObjectSize%l.StripeUnit != 0 {
+		return fmt.Errorf("objectsize %d must be a multiple of stripeunit %d", l.ObjectSize, l.StripeUnit)
+	}
+	return nil
+}
+
+// extent maps the data of a file at offset to the number of the object
+// storing it and the offset within that object. It also returns the number
+// of bytes stored contiguously from there, up to the end of the stripe unit.
+func (l layout) extent(offset int64) (objectNo, objectOffset, n int64) {
+	block := offset / l.StripeUnit
+	stripe, position := block/l.StripeCount, block%l.StripeCount
+	stripesPerObject := l.ObjectSize / l.StripeUnit
+	objectNo = stripe/stripesPerObject*l.StripeCount + position
+	objectOffset = stripe%stripesPerObject*l.StripeUnit + offset%l.StripeUnit
+	return objectNo, objectOffset, l.StripeUnit - offset%l.StripeUnit
+}
+
+// objects returns the number of objects which may store data of a file of
+// the given size.
+func (l layout) objects(size int64) int64 {
+	if size == 0 {
+		return 0
+	}
+	setSize := l.ObjectSize * l.StripeCount
+	return (size + setSize - 1) / setSize * l.StripeCount
+}
+
+// entry is the value of a directory entry, stored in the omap of the
+// object of its parent directory under its base name. The entries of files
+// hold their metadata, so that directories can be walked without reading
+// every file.
+type entry struct {
+	Dir     bool      `json:"dir,omitempty"`
+	OID     string    `json:"oid,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"modtime,omitempty"`
+	Layout  *layout   `json:"layout,omitempty"`
+}
+
+func (e entry) fileInfo(path string) storagedriver.FileInfo {
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    path,
+		Size:    e.Size,
+		ModTime: e.ModTime,
+		IsDir:   e.Dir,
+	}}
+}
+
+var _ storagedriver.StorageDriver = &driver{}
+
+type driver struct {
+	pool          pool
+	layout        layout
+	RootDirectory string
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation backed by a Ceph
+// pool. The data of files is striped over objects named after a unique ID,
+// and directories are objects whose omap lists their children.
+type Driver struct {
+	baseEmbed
+}
+
+// newDriver constructs a new Driver storing files in the given pool.
+func newDriver(pool pool, rootDirectory string, layout layout) *Driver {
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: &driver{
+					pool:          pool,
+					layout:        layout,
+					RootDirectory: rootDirectory,
+				},
+			},
+		},
+	}
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	rc, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	old, err := d.lookupFile(path)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	e := d.newEntry()
+	if _, err := (&dataWriter{driver: d, entry: &e}).Write(contents); err != nil {
+		d.deleteData(e)
+		return err
+	}
+	e.ModTime = time.Now()
+	if err := d.setEntry(path, e); err != nil {
+		d.deleteData(e)
+		return err
+	}
+	if old != nil {
+		return d.deleteData(*old)
+	}
+	return nil
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+	}
+	e, err := d.lookupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	return io.NopCloser(&reader{driver: d, entry: *e, offset: offset}), nil
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	old, err := d.lookupFile(path)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok || append {
+			return nil, err
+		}
+	}
+	if append {
+		if old == nil {
+			return nil, storagedriver.PathNotFoundError{Path: path}
+		}
+		return d.newWriter(path, *old), nil
+	}
+
+	e := d.newEntry()
+	e.ModTime = time.Now()
+	if err := d.setEntry(path, e); err != nil {
+		return nil, err
+	}
+	if old != nil {
+		if err := d.deleteData(*old); err != nil {
+			return nil, err
+		}
+	}
+	return d.newWriter(path, e), nil
+}
+
+// Stat retrieves the FileInfo for the given path, including the current
+// size in bytes and the modification time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	e, err := d.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return e.fileInfo(path), nil
+}
+
+// List returns a list of the objects that are direct descendants of the
+// given path.
+func (d *driver) List(ctx context.Context, opath string) ([]string, error) {
+	e, err := d.lookup(opath)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Dir {
+		return nil, storagedriver.PathNotFoundError{Path: opath}
+	}
+
+	files := []string{}
+	err = d.listDir(opath, func(name string, e entry) error {
+		files = append(files, path.Join(opath, name))
+		return nil
+	})
+	return files, err
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the
+// original object. Only the directory entries change, the data of the
+// object is not copied.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	e, err := d.lookupFile(sourcePath)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return storagedriver.PathNotFoundError{Path: sourcePath}
+	}
+	old, err := d.lookupFile(destPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+
+	if err := d.setEntry(destPath, *e); err != nil {
+		return err
+	}
+	if err := d.removeEntry(sourcePath); err != nil {
+		return err
+	}
+	if old != nil && old.OID != e.OID {
+		return d.deleteData(*old)
+	}
+	return nil
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	e, err := d.lookup(path)
+	if err != nil {
+		return err
+	}
+	if e.Dir {
+		err = d.deleteDir(path)
+	} else {
+		err = d.deleteData(e)
+	}
+	if err != nil || path == "/" {
+		return err
+	}
+	return d.removeEntry(path)
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at the given path.
+// Ceph pools are not accessible over HTTP, so this always returns ErrUnsupportedMethod.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "", storagedriver.ErrUnsupportedMethod{}
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file. The metadata of files is
+// read from the directory entries, without reading the files.
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	e, err := d.lookup(path)
+	if err != nil {
+		return err
+	}
+	if !e.Dir {
+		return storagedriver.PathNotFoundError{Path: path}
+	}
+	_, err = d.walk(ctx, path, f)
+	return err
+}
+
+func (d *driver) walk(ctx context.Context, dir string, f storagedriver.WalkFn) (bool, error) {
+	var children []storagedriver.FileInfo
+	err := d.listDir(dir, func(name string, e entry) error {
+		children = append(children, e.fileInfo(path.Join(dir, name)))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, fileInfo := range children {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		err := f(fileInfo)
+		if err == nil && fileInfo.IsDir() {
+			if ok, err := d.walk(ctx, fileInfo.Path(), f); err != nil || !ok {
+				return ok, err
+			}
+		} else if err == storagedriver.ErrSkipDir {
+			// noop for folders, will just skip
+			if !fileInfo.IsDir() {
+				return false, nil // no error but stop iteration
+			}
+		} else if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// dirObject returns the name of the object holding the entries of a
+// directory.
+func (d *driver) dirObject(dir string) string {
+	return "dir:" + path.Join("/", d.RootDirectory, dir)
+}
+
+// dataObject returns the name of an object holding the data of a file.
+func dataObject(oid string, objectNo int64) string {
+	return fmt.Sprintf("data:%s.%016x", oid, objectNo)
+}
+
+func (d *driver) newEntry() entry {
+	l := d.layout
+	return entry{OID: uuid.Generate().String(), Layout: &l}
+}
+
+// lookup returns the directory entry of a path.
+func (d *driver) lookup(p string) (entry, error) {
+	if p == "/" {
+		return entry{Dir: true}, nil
+	}
+	value, err := d.pool.GetOmapValue(d.dirObject(path.Dir(p)), path.Base(p))
+	if err == errNotFound {
+		return entry{}, storagedriver.PathNotFoundError{Path: p}
+	}
+	if err != nil {
+		return entry{}, err
+	}
+	var e entry
+	if err := json.Unmarshal(value, &e); err != nil {
+		return entry{}, fmt.Errorf("invalid directory entry for %s: %v", p, err)
+	}
+	if !e.Dir && e.Layout == nil {
+		return entry{}, fmt.Errorf("invalid directory entry for %s: missing layout", p)
+	}
+	return e, nil
+}
+
+// lookupFile returns the directory entry of a file, failing if the path is
+// a directory.
+func (d *driver) lookupFile(p string) (*entry, error) {
+	e, err := d.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if e.Dir {
+		return nil, fmt.Errorf("%q is a directory", p)
+	}
+	return &e, nil
+}
+
+// setEntry sets the directory entry of a path, creating its parent
+// directories as needed.
+func (d *driver) setEntry(p string, e entry) error {
+	if err := d.mkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return d.pool.SetOmap(d.dirObject(path.Dir(p)), map[string][]byte{path.Base(p): value})
+}
+
+// mkdirAll creates the entries of a directory and its missing parents,
+// starting with the topmost one so that every directory is reachable.
+func (d *driver) mkdirAll(dir string) error {
+	var missing []string
+	for ; dir != "/"; dir = path.Dir(dir) {
+		e, err := d.lookup(dir)
+		if err == nil {
+			if !e.Dir {
+				return fmt.Errorf("%q is not a directory", dir)
+			}
+			break
+		}
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			return err
+		}
+		missing = append(missing, dir)
+	}
+
+	value, _ := json.Marshal(entry{Dir: true})
+	for i := len(missing) - 1; i >= 0; i-- {
+		err := d.pool.SetOmap(d.dirObject(path.Dir(missing[i])), map[string][]byte{path.Base(missing[i]): value})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *driver) removeEntry(p string) error {
+	return d.pool.RmOmapKeys(d.dirObject(path.Dir(p)), []string{path.Base(p)})
+}
+
+// listDir calls fn with the name and entry of the children of a directory,
+// in order.
+func (d *driver) listDir(dir string, fn func(name string, e entry) error) error {
+	startAfter := ""
+	for {
+		var names []string
+		var values [][]byte
+		err := d.pool.ListOmapValues(d.dirObject(dir), startAfter, listBatchSize, func(key string, value []byte) {
+			names = append(names, key)
+			values = append(values, value)
+		})
+		if err == errNotFound {
+			// directories without children may not have an object
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for i, name := range names {
+			var e entry
+			if err := json.Unmarshal(values[i], &e); err != nil {
+				return fmt.Errorf("invalid directory entry for %s: %v", path.Join(dir, name), err)
+			}
+			if err := fn(name, e); err != nil {
+				return err
+			}
+		}
+		if len(names) < listBatchSize {
+			return nil
+		}
+		startAfter = names[len(names)-1]
+	}
+}
+
+// deleteDir deletes the children of a directory and its object.
+func (d *driver) deleteDir(dir string) error {
+	err := d.listDir(dir, func(name string, e entry) error {
+		if e.Dir {
+			return d.deleteDir(path.Join(dir, name))
+		}
+		return d.deleteData(e)
+	})
+	if err != nil {
+		return err
+	}
+	if err := d.pool.Delete(d.dirObject(dir)); err != nil && err != errNotFound {
+		return err
+	}
+	return nil
+}
+
+// deleteData deletes the objects holding the data of a file.
+func (d *driver) deleteData(e entry) error {
+	for objectNo := int64(0); objectNo < e.Layout.objects(e.Size); objectNo++ {
+		if err := d.pool.Delete(dataObject(e.OID, objectNo)); err != nil && err != errNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// reader reads the data of a file from its objects.
+type reader struct {
+	driver *driver
+	entry  entry
+	offset int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.offset >= r.entry.Size {
+		return 0, io.EOF
+	}
+	objectNo, objectOffset, n := r.entry.Layout.extent(r.offset)
+	if remaining := r.entry.Size - r.offset; n > remaining {
+		n = remaining
+	}
+	if n > int64(len(p)) {
+		n = int64(len(p))
+	}
+
+	read, err := r.driver.pool.Read(dataObject(r.entry.OID, objectNo), p[:n], uint64(objectOffset))
+	r.offset += int64(read)
+	if err == errNotFound || (err == nil && int64(read) < n) {
+		return read, io.ErrUnexpectedEOF
+	}
+	return read, err
+}
+
+// dataWriter writes data at the end of a file to its objects.
+type dataWriter struct {
+	driver *driver
+	entry  *entry
+}
+
+func (w *dataWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		objectNo, objectOffset, n := w.entry.Layout.extent(w.entry.Size)
+		if remaining := int64(len(p) - written); n > remaining {
+			n = remaining
+		}
+		err := w.driver.pool.Write(dataObject(w.entry.OID, objectNo), p[written:written+int(n)], uint64(objectOffset))
+		if err != nil {
+			return written, err
+		}
+		written += int(n)
+		w.entry.Size += n
+	}
+	return written, nil
+}
+
+// writer writes a file, buffering writes to whole stripe units. The size of
+// the file is recorded in its directory entry when it is closed or
+// committed.
+type writer struct {
+	driver    *driver
+	path      string
+	entry     entry
+	bw        *bufio.Writer
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (d *driver) newWriter(path string, e entry) *writer {
+	w := &writer{
+		driver: d,
+		path:   path,
+		entry:  e,
+	}
+	w.bw = bufio.NewWriterSize(&dataWriter{driver: d, entry: &w.entry}, int(e.Layout.StripeUnit))
+	return w
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+	return w.bw.Write(p)
+}
+
+func (w *writer) Size() int64 {
+	return w.entry.Size + int64(w.bw.Buffered())
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+	if w.committed || w.cancelled {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *writer) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+	if err := w.driver.deleteData(w.entry); err != nil {
+		return err
+	}
+	return w.driver.removeEntry(w.path)
+}
+
+func (w *writer) Commit() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.committed = true
+	return nil
+}
+
+// flush writes the buffered data and records the size of the file.
+func (w *writer) flush() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	w.entry.ModTime = time.Now()
+	return w.driver.setEntry(w.path, w.entry)
+}
@@ -0,0 +1,355 @@
+package rados
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// memPool is a pool storing objects in memory.
+type memPool struct {
+	mu      sync.Mutex
+	objects map[string]*memObject
+}
+
+type memObject struct {
+	data []byte
+	omap map[string][]byte
+}
+
+func newMemPool() *memPool {
+	return &memPool{objects: make(map[string]*memObject)}
+}
+
+func (p *memPool) object(oid string, create bool) *memObject {
+	o := p.objects[oid]
+	if o == nil && create {
+		o = &memObject{omap: make(map[string][]byte)}
+		p.objects[oid] = o
+	}
+	return o
+}
+
+func (p *memPool) Read(oid string, data []byte, offset uint64) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o := p.object(oid, false)
+	if o == nil {
+		return 0, errNotFound
+	}
+	if offset >= uint64(len(o.data)) {
+		return 0, nil
+	}
+	return copy(data, o.data[offset:]), nil
+}
+
+func (p *memPool) Write(oid string, data []byte, offset uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o := p.object(oid, true)
+	if end := int(offset) + len(data); end > len(o.data) {
+		o.data = append(o.data, make([]byte, end-len(o.data))...)
+	}
+	copy(o.data[offset:], data)
+	return nil
+}
+
+func (p *memPool) Delete(oid string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.objects[oid] == nil {
+		return errNotFound
+	}
+	delete(p.objects, oid)
+	return nil
+}
+
+func (p *memPool) GetOmapValue(oid, key string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o := p.object(oid, false)
+	if o == nil || o.omap[key] == nil {
+		return nil, errNotFound
+	}
+	return o.omap[key], nil
+}
+
+func (p *memPool) ListOmapValues(oid, startAfter string, maxReturn int64, fn func(key string, value []byte)) error {
+	p.mu.Lock()
+	o := p.object(oid, false)
+	if o == nil {
+		p.mu.Unlock()
+		return errNotFound
+	}
+	var keys []string
+	for key := range o.omap {
+		if key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if int64(len(keys)) > maxReturn {
+		keys = keys[:maxReturn]
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = o.omap[key]
+	}
+	p.mu.Unlock()
+
+	for i, key := range keys {
+		fn(key, values[i])
+	}
+	return nil
+}
+
+func (p *memPool) SetOmap(oid string, pairs map[string][]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o := p.object(oid, true)
+	for key, value := range pairs {
+		o.omap[key] = value
+	}
+	return nil
+}
+
+func (p *memPool) RmOmapKeys(oid string, keys []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o := p.object(oid, false)
+	if o == nil {
+		return errNotFound
+	}
+	for _, key := range keys {
+		delete(o.omap, key)
+	}
+	return nil
+}
+
+// dataObjects returns the number of objects holding the data of files.
+func (p *memPool) dataObjects() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for oid := range p.objects {
+		if strings.HasPrefix(oid, "data:") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLayout(t *testing.T) {
+	l := layout{StripeUnit: 4, StripeCount: 3, ObjectSize: 8}
+	for _, tc := range []struct {
+		offset                    int64
+		objectNo, objectOffset, n int64
+	}{
+		{0, 0, 0, 4},
+		{5, 1, 1, 3},
+		{8, 2, 0, 4},
+		{12, 0, 4, 4},
+		{23, 2, 7, 1},
+		{24, 3, 0, 4},
+		{38, 3, 6, 2},
+	} {
+		objectNo, objectOffset, n := l.extent(tc.offset)
+		if objectNo != tc.objectNo || objectOffset != tc.objectOffset || n != tc.n {
+			t.Errorf("extent(%d) = %d, %d, %d, expected %d, %d, %d", tc.offset, objectNo, objectOffset, n, tc.objectNo, tc.objectOffset, tc.n)
+		}
+	}
+	if n := l.objects(25); n != 6 {
+		t.Errorf("expected 25 bytes to be stored in 6 objects, got %d", n)
+	}
+
+	for _, invalid := range []layout{
+		{StripeUnit: 0, StripeCount: 1, ObjectSize: 4},
+		{StripeUnit: 4, StripeCount: 0, ObjectSize: 4},
+		{StripeUnit: 4, StripeCount: 1, ObjectSize: 6},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("expected layout %+v to be invalid", invalid)
+		}
+	}
+}
+
+func newTestDriver(rootDirectory string) (*memPool, *Driver) {
+	p := newMemPool()
+	return p, newDriver(p, rootDirectory, layout{StripeUnit: 16, StripeCount: 3, ObjectSize: 32})
+}
+
+func TestStriping(t *testing.T) {
+	p, d := newTestDriver("/registry")
+	ctx := context.Background()
+
+	contents := make([]byte, 1000)
+	rand.Read(contents)
+
+	w, err := d.Writer(ctx, "/a/b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(contents[:333]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w, err = d.Writer(ctx, "/a/b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Size() != 333 {
+		t.Fatalf("unexpected size of resumed writer: %d", w.Size())
+	}
+	if _, err := w.Write(contents[333:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1000 bytes fill 10 sets of 3 objects of 32 bytes, and one stripe of
+	// the 11th set
+	if n := p.dataObjects(); n != 33 {
+		t.Fatalf("expected data to be striped over 33 objects, got %d", n)
+	}
+	for offset := int64(0); offset < 1000; offset += 111 {
+		r, err := d.Reader(ctx, "/a/b", offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		b.ReadFrom(r)
+		if !bytes.Equal(b.Bytes(), contents[offset:]) {
+			t.Fatalf("unexpected content read from offset %d", offset)
+		}
+	}
+
+	// the layout of existing files is kept when the layout changes
+	d.StorageDriver.(*driver).layout = layout{StripeUnit: 8, StripeCount: 1, ObjectSize: 8}
+	if received, err := d.GetContent(ctx, "/a/b"); err != nil || !bytes.Equal(received, contents) {
+		t.Fatalf("unexpected content after changing the layout: %v", err)
+	}
+
+	if err := d.PutContent(ctx, "/a/b", contents[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.dataObjects(); n != 2 {
+		t.Fatalf("expected the data of the overwritten file to be deleted, got %d objects", n)
+	}
+	if err := d.Delete(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.dataObjects(); n != 0 {
+		t.Fatalf("expected the data of the deleted file to be deleted, got %d objects", n)
+	}
+}
+
+func TestMoveKeepsData(t *testing.T) {
+	p, d := newTestDriver("")
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, "/a/b", []byte("source")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, "/c/d", []byte("destination")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Move(ctx, "/a/b", "/c/d"); err != nil {
+		t.Fatal(err)
+	}
+	if received, err := d.GetContent(ctx, "/c/d"); err != nil || string(received) != "source" {
+		t.Fatalf("unexpected moved content %q: %v", received, err)
+	}
+	if _, err := d.Stat(ctx, "/a/b"); !isPathNotFound(err) {
+		t.Fatalf("expected the source to be missing, got %v", err)
+	}
+	if n := p.dataObjects(); n != 1 {
+		t.Fatalf("expected the data of the overwritten destination to be deleted, got %d objects", n)
+	}
+
+	if err := d.Move(ctx, "/c", "/e"); err == nil {
+		t.Fatal("expected error moving a directory")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	_, d := newTestDriver("")
+	ctx := context.Background()
+
+	for _, p := range []string{"/a/b/c", "/a/b/d", "/a/e", "/f/g", "/h"} {
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var walked []string
+	err := d.Walk(ctx, "/", func(fileInfo storagedriver.FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		if fileInfo.Path() == "/f" {
+			return storagedriver.ErrSkipDir
+		}
+		if !fileInfo.IsDir() && fileInfo.Size() != int64(len(fileInfo.Path())) {
+			t.Errorf("unexpected size of %s: %d", fileInfo.Path(), fileInfo.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "/a,/a/b,/a/b/c,/a/b/d,/a/e,/f,/h"; strings.Join(walked, ",") != expected {
+		t.Fatalf("unexpected walk %v, expected %s", walked, expected)
+	}
+
+	walked = nil
+	err = d.Walk(ctx, "/a", func(fileInfo storagedriver.FileInfo) error {
+		walked = append(walked, fileInfo.Path())
+		if fileInfo.Path() == "/a/b/c" {
+			return storagedriver.ErrSkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "/a/b,/a/b/c"; strings.Join(walked, ",") != expected {
+		t.Fatalf("expected walk to stop at a skipped file, got %v", walked)
+	}
+
+	if err := d.Walk(ctx, "/h", func(storagedriver.FileInfo) error { return nil }); !isPathNotFound(err) {
+		t.Fatalf("expected path not found error walking a file, got %v", err)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	_, d := newTestDriver("")
+	ctx := context.Background()
+
+	for i := 0; i < listBatchSize+10; i++ {
+		if err := d.PutContent(ctx, "/dir/"+strings.Repeat("x", i+1), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := d.List(ctx, "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != listBatchSize+10 {
+		t.Fatalf("expected %d children, got %d", listBatchSize+10, len(list))
+	}
+}
+
+func isPathNotFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
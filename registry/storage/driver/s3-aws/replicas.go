@@ -0,0 +1,229 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	dcontext "github.com/docker/distribution/context"
+)
+
+// defaultReplicaCheckInterval is the default interval between the health
+// checks of the read replicas.
+const defaultReplicaCheckInterval = 30 * time.Second
+
+// replicaCheckTimeout bounds the duration of a single health check.
+const replicaCheckTimeout = 10 * time.Second
+
+// blobsPrefix is the path under which the registry stores blobs. Blobs are
+// addressed by their content and never overwritten, so they can be read from
+// replicas lagging behind the primary bucket, unlike tags and other metadata.
+const blobsPrefix = "/docker/registry/v2/blobs/"
+
+// ReadReplica is a replica of the bucket, such as the destination of an S3
+// cross-region replication rule, which blobs are read from.
+type ReadReplica struct {
+	Bucket         string
+	Region         string
+	RegionEndpoint string
+}
+
+// replica is a bucket blobs can be read from, with the outcome of its last
+// health check.
+type replica struct {
+	S3     *s3.S3
+	Bucket string
+	Region string
+
+	mu      sync.Mutex
+	healthy bool
+	latency time.Duration
+}
+
+// replicaSet routes the reads of blobs to the nearest healthy bucket, as
+// measured by the latency of its health checks, among the primary bucket and
+// its read replicas.
+type replicaSet struct {
+	primary  *replica
+	replicas []*replica
+}
+
+// parseReadReplicas parses the readreplicas parameter, a list of maps with
+// the bucket, region and optional regionendpoint of each replica.
+func parseReadReplicas(param interface{}, customEndpoint bool) ([]ReadReplica, error) {
+	if param == nil {
+		return nil, nil
+	}
+	list, ok := param.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the readreplicas parameter should be a list")
+	}
+
+	readReplicas := make([]ReadReplica, 0, len(list))
+	for _, item := range list {
+		fields := map[string]string{}
+		switch m := item.(type) {
+		case map[string]interface{}:
+			for k, v := range m {
+				fields[strings.ToLower(k)] = fmt.Sprint(v)
+			}
+		case map[interface{}]interface{}:
+			for k, v := range m {
+				fields[strings.ToLower(fmt.Sprint(k))] = fmt.Sprint(v)
+			}
+		default:
+			return nil, fmt.Errorf("the readreplicas parameter should be a list of maps")
+		}
+
+		r := ReadReplica{
+			Bucket:         fields["bucket"],
+			Region:         fields["region"],
+			RegionEndpoint: fields["regionendpoint"],
+		}
+		if r.Bucket == "" {
+			return nil, fmt.Errorf("no bucket provided for read replica")
+		}
+		if r.Region == "" {
+			return nil, fmt.Errorf("no region provided for read replica %s", r.Bucket)
+		}
+		// Don't check the region value if a custom endpoint is provided.
+		if r.RegionEndpoint == "" && !customEndpoint {
+			if _, ok := validRegions[r.Region]; !ok {
+				return nil, fmt.Errorf("invalid region provided for read replica %s: %v", r.Bucket, r.Region)
+			}
+		}
+		readReplicas = append(readReplicas, r)
+	}
+	return readReplicas, nil
+}
+
+// newReplicaSet returns the set of the read replicas of the driver and its
+// primary bucket, checking their health in the background, or nil if there
+// are no read replicas.
+func newReplicaSet(params DriverParameters, primary *s3.S3) (*replicaSet, error) {
+	if len(params.ReadReplicas) == 0 {
+		return nil, nil
+	}
+
+	rs := &replicaSet{}
+	for _, r := range params.ReadReplicas {
+		endpoint := r.RegionEndpoint
+		if endpoint == "" {
+			endpoint = params.RegionEndpoint
+		}
+		s3obj, err := newS3(params, r.Region, endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("read replica %s: %v", r.Bucket, err)
+		}
+		rs.replicas = append(rs.replicas, &replica{S3: s3obj, Bucket: r.Bucket, Region: r.Region})
+	}
+
+	rs.primary = &replica{S3: primary, Bucket: params.Bucket, Region: params.Region}
+
+	interval := params.ReplicaCheckInterval
+	if interval <= 0 {
+		interval = defaultReplicaCheckInterval
+	}
+	go func() {
+		for {
+			rs.check()
+			time.Sleep(interval)
+		}
+	}()
+	return rs, nil
+}
+
+// check checks the health and latency of the buckets.
+func (rs *replicaSet) check() {
+	var wg sync.WaitGroup
+	for _, r := range append([]*replica{rs.primary}, rs.replicas...) {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			r.check()
+		}(r)
+	}
+	wg.Wait()
+}
+
+// nearest returns the read replica blobs at path should be read from, or nil
+// if the path should be read from the primary bucket.
+func (rs *replicaSet) nearest(path string) *replica {
+	if rs == nil || !strings.HasPrefix(path, blobsPrefix) {
+		return nil
+	}
+
+	var nearest *replica
+	latency := time.Duration(-1)
+	if healthy, l := rs.primary.status(); healthy {
+		latency = l
+	}
+	for _, r := range rs.replicas {
+		healthy, l := r.status()
+		if healthy && (latency < 0 || l < latency) {
+			nearest, latency = r, l
+		}
+	}
+	return nearest
+}
+
+// check marks the bucket as healthy if it can be reached, recording the
+// latency of the request.
+func (r *replica) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := r.S3.HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(r.Bucket),
+	})
+	latency := time.Since(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if r.healthy {
+			dcontext.GetLogger(ctx).Warnf("s3: bucket %s in %s is unhealthy: %v", r.Bucket, r.Region, err)
+		}
+		r.healthy = false
+		return
+	}
+	if !r.healthy {
+		dcontext.GetLogger(ctx).Infof("s3: bucket %s in %s is healthy, latency %v", r.Bucket, r.Region, latency)
+	}
+	r.healthy = true
+	r.latency = latency
+}
+
+// fail marks the replica as unhealthy after a failed read, until its next
+// successful health check.
+func (r *replica) fail(ctx context.Context, err error) {
+	dcontext.GetLogger(ctx).Warnf("s3: error reading from read replica %s in %s, reading from primary bucket: %v", r.Bucket, r.Region, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.healthy = false
+}
+
+func (r *replica) status() (bool, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.healthy, r.latency
+}
+
+// isNotFound returns true if the error reports a missing object.
+func isNotFound(err error) bool {
+	if s3Err, ok := err.(awserr.Error); ok {
+		switch s3Err.Code() {
+		case "NoSuchKey", "NotFound":
+			return true
+		}
+	}
+	return false
+}
@@ -25,6 +25,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -53,6 +54,17 @@ const maxChunkSize = 5 << 30
 
 const defaultChunkSize = 2 * minChunkSize
 
+const (
+	// defaultMaxChunkSize defines the default largest part size of an upload
+	// whose part size is scaled with its expected size.
+	defaultMaxChunkSize = 256 << 20
+
+	// adaptiveChunkParts is the number of parts an upload whose part size is
+	// scaled with its expected size is split into, well below the limit of
+	// 10000 parts of a multipart upload.
+	adaptiveChunkParts = 1000
+)
+
 const (
 	// defaultMultipartCopyChunkSize defines the default chunk size for all
 	// but the last Upload Part - Copy operation of a multipart copy.
@@ -124,6 +136,11 @@ type DriverParameters struct {
 	Accelerate                  bool
 	LogS3APIRequests            bool
 	LogS3APIResponseHeaders     map[string]string
+	ReadReplicas                []ReadReplica
+	ReplicaCheckInterval        time.Duration
+	MultipartUploadConcurrency  int64
+	AdaptiveChunkSize           bool
+	MaxChunkSize                int64
 }
 
 func init() {
@@ -168,11 +185,15 @@ type driver struct {
 	MultipartCopyMaxConcurrency int64
 	MultipartCopyThresholdSize  int64
 	MultipartCombineSmallPart   bool
+	MultipartUploadConcurrency  int64
+	AdaptiveChunkSize           bool
+	MaxChunkSize                int64
 	RootDirectory               string
 	StorageClass                string
 	ObjectACL                   string
 	LogS3APIRequests            bool
 	LogS3APIResponseHeaders     map[string]string
+	Replicas                    *replicaSet
 }
 
 type baseEmbed struct {
@@ -337,6 +358,37 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		return nil, err
 	}
 
+	multipartUploadConcurrency, err := getParameterAsInt64(parameters, "multipartuploadconcurrency", 1, 1, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+
+	adaptiveChunkSizeBool := false
+	adaptiveChunkSize := parameters["adaptivechunksize"]
+	switch adaptiveChunkSize := adaptiveChunkSize.(type) {
+	case string:
+		b, err := strconv.ParseBool(adaptiveChunkSize)
+		if err != nil {
+			return nil, fmt.Errorf("the adaptivechunksize parameter should be a boolean")
+		}
+		adaptiveChunkSizeBool = b
+	case bool:
+		adaptiveChunkSizeBool = adaptiveChunkSize
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the adaptivechunksize parameter should be a boolean")
+	}
+
+	maxAdaptiveChunkSize := int64(defaultMaxChunkSize)
+	if maxAdaptiveChunkSize < chunkSize {
+		maxAdaptiveChunkSize = chunkSize
+	}
+	maxAdaptiveChunkSize, err = getParameterAsInt64(parameters, "maxchunksize", maxAdaptiveChunkSize, chunkSize, maxChunkSize)
+	if err != nil {
+		return nil, err
+	}
+
 	rootDirectory := parameters["rootdirectory"]
 	if rootDirectory == nil {
 		rootDirectory = ""
@@ -472,6 +524,26 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		return nil, fmt.Errorf("the accelerate parameter should be a boolean")
 	}
 
+	readReplicas, err := parseReadReplicas(parameters["readreplicas"], regionEndpoint != "")
+	if err != nil {
+		return nil, err
+	}
+
+	replicaCheckInterval := defaultReplicaCheckInterval
+	switch v := parameters["replicacheckinterval"].(type) {
+	case string:
+		replicaCheckInterval, err = time.ParseDuration(v)
+		if err != nil || replicaCheckInterval <= 0 {
+			return nil, fmt.Errorf("the replicacheckinterval parameter should be a positive duration")
+		}
+	case time.Duration:
+		replicaCheckInterval = v
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the replicacheckinterval parameter should be a duration")
+	}
+
 	params := DriverParameters{
 		nil,
 		fmt.Sprint(accessKey),
@@ -499,6 +571,11 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		accelerateBool,
 		logS3APIRequestsBool,
 		logS3APIResponseHeadersMap,
+		readReplicas,
+		replicaCheckInterval,
+		multipartUploadConcurrency,
+		adaptiveChunkSizeBool,
+		maxAdaptiveChunkSize,
 	}
 
 	return New(params)
@@ -538,59 +615,16 @@ func getParameterAsInt64(parameters map[string]interface{}, name string, default
 func New(params DriverParameters) (*Driver, error) {
 	s3obj := params.S3
 	if s3obj == nil {
-		if !params.V4Auth &&
-			(params.RegionEndpoint == "" ||
-				strings.Contains(params.RegionEndpoint, "s3.amazonaws.com")) {
-			return nil, fmt.Errorf("on Amazon S3 this storage driver can only be used with v4 authentication")
-		}
-
-		awsConfig := aws.NewConfig()
-
-		if params.AccessKey != "" && params.SecretKey != "" {
-			creds := credentials.NewStaticCredentials(
-				params.AccessKey,
-				params.SecretKey,
-				params.SessionToken,
-			)
-			awsConfig.WithCredentials(creds)
-		}
-
-		if params.RegionEndpoint != "" {
-			awsConfig.WithEndpoint(params.RegionEndpoint)
-			awsConfig.WithS3ForcePathStyle(params.ForcePathStyle)
-		}
-
-		awsConfig.WithS3UseAccelerate(params.Accelerate)
-		awsConfig.WithRegion(params.Region)
-		awsConfig.WithDisableSSL(!params.Secure)
-		if params.UseDualStack {
-			awsConfig.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
-		}
-
-		if params.SkipVerify {
-			httpTransport := &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			}
-			awsConfig.WithHTTPClient(&http.Client{
-				Transport: httpTransport,
-			})
-		}
-
-		sess, err := session.NewSession(awsConfig)
+		var err error
+		s3obj, err = newS3(params, params.Region, params.RegionEndpoint)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create new session with aws config: %v", err)
-		}
-
-		if params.UserAgent != "" {
-			sess.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(params.UserAgent))
+			return nil, err
 		}
+	}
 
-		s3obj := s3.New(sess)
-
-		// enable S3 compatible signature v2 signing instead
-		if !params.V4Auth {
-			setv2Handlers(s3obj)
-		}
+	replicas, err := newReplicaSet(params, s3obj)
+	if err != nil {
+		return nil, err
 	}
 
 	// TODO Currently multipart uploads have no timestamps, so this would be unwise
@@ -608,6 +642,13 @@ func New(params DriverParameters) (*Driver, error) {
 	// 	}
 	// }
 
+	if params.MultipartUploadConcurrency < 1 {
+		params.MultipartUploadConcurrency = 1
+	}
+	if params.MaxChunkSize < params.ChunkSize {
+		params.MaxChunkSize = params.ChunkSize
+	}
+
 	d := &driver{
 		S3:                          s3obj,
 		Bucket:                      params.Bucket,
@@ -618,11 +659,15 @@ func New(params DriverParameters) (*Driver, error) {
 		MultipartCopyMaxConcurrency: params.MultipartCopyMaxConcurrency,
 		MultipartCopyThresholdSize:  params.MultipartCopyThresholdSize,
 		MultipartCombineSmallPart:   params.MultipartCombineSmallPart,
+		MultipartUploadConcurrency:  params.MultipartUploadConcurrency,
+		AdaptiveChunkSize:           params.AdaptiveChunkSize,
+		MaxChunkSize:                params.MaxChunkSize,
 		RootDirectory:               params.RootDirectory,
 		StorageClass:                params.StorageClass,
 		ObjectACL:                   params.ObjectACL,
 		LogS3APIRequests:            params.LogS3APIRequests,
 		LogS3APIResponseHeaders:     params.LogS3APIResponseHeaders,
+		Replicas:                    replicas,
 	}
 
 	return &Driver{
@@ -634,13 +679,72 @@ func New(params DriverParameters) (*Driver, error) {
 	}, nil
 }
 
+// newS3 returns an S3 client for the given region and endpoint, configured
+// with the credentials and options of the driver parameters.
+func newS3(params DriverParameters, region, regionEndpoint string) (*s3.S3, error) {
+	if !params.V4Auth &&
+		(regionEndpoint == "" ||
+			strings.Contains(regionEndpoint, "s3.amazonaws.com")) {
+		return nil, fmt.Errorf("on Amazon S3 this storage driver can only be used with v4 authentication")
+	}
+
+	awsConfig := aws.NewConfig()
+
+	if params.AccessKey != "" && params.SecretKey != "" {
+		creds := credentials.NewStaticCredentials(
+			params.AccessKey,
+			params.SecretKey,
+			params.SessionToken,
+		)
+		awsConfig.WithCredentials(creds)
+	}
+
+	if regionEndpoint != "" {
+		awsConfig.WithEndpoint(regionEndpoint)
+		awsConfig.WithS3ForcePathStyle(params.ForcePathStyle)
+	}
+
+	awsConfig.WithS3UseAccelerate(params.Accelerate)
+	awsConfig.WithRegion(region)
+	awsConfig.WithDisableSSL(!params.Secure)
+	if params.UseDualStack {
+		awsConfig.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+
+	if params.SkipVerify {
+		httpTransport := &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+		awsConfig.WithHTTPClient(&http.Client{
+			Transport: httpTransport,
+		})
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new session with aws config: %v", err)
+	}
+
+	if params.UserAgent != "" {
+		sess.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(params.UserAgent))
+	}
+
+	s3obj := s3.New(sess)
+
+	// enable S3 compatible signature v2 signing instead
+	if !params.V4Auth {
+		setv2Handlers(s3obj)
+	}
+	return s3obj, nil
+}
+
 // logS3OperationHandlerName is used to identify the handler used to log S3 API
 // requests
 const logS3OperationHandlerName = "docker.storage-driver.s3.operation-logger"
 
 // logS3Operation logs each S3 operation, including request and response info,
 // as it completes
-func (d *driver) logS3Operation(ctx context.Context) request.NamedHandler {
+func (d *driver) logS3Operation(ctx context.Context, bucket string) request.NamedHandler {
 	return request.NamedHandler{
 		Name: logS3OperationHandlerName,
 		Fn: func(r *request.Request) {
@@ -650,7 +754,7 @@ func (d *driver) logS3Operation(ctx context.Context) request.NamedHandler {
 			op := r.Operation
 			fields := map[interface{}]interface{}{
 				"s3_operation_name":                        op.Name,
-				"s3_bucket_name":                           d.Bucket,
+				"s3_bucket_name":                           bucket,
 				"s3_object_name":                           d.s3Path(req.URL.Query().Get("Key")),
 				"s3_http_request_method":                   req.Method,
 				"s3_http_request_host":                     req.Host,
@@ -706,11 +810,15 @@ var setContentLength = request.NamedHandler{
 }
 
 func (d *driver) s3Client(ctx context.Context) *s3.S3 {
-	s := d.S3
+	return d.client(ctx, d.S3, d.Bucket)
+}
 
+// client returns the given S3 client, logging its operations on the bucket
+// if requested.
+func (d *driver) client(ctx context.Context, s *s3.S3, bucket string) *s3.S3 {
 	if d.LogS3APIRequests {
-		s = &s3.S3{Client: client.New(d.S3.Client.Config, d.S3.Client.ClientInfo, d.S3.Client.Handlers.Copy())}
-		r := d.logS3Operation(ctx)
+		s = &s3.S3{Client: client.New(s.Client.Config, s.Client.ClientInfo, s.Client.Handlers.Copy())}
+		r := d.logS3Operation(ctx, bucket)
 		s.Client.Handlers.Complete.PushBackNamed(r)
 	}
 	s.Client.Handlers.Complete.PushBackNamed(setContentLength)
@@ -751,12 +859,24 @@ func (d *driver) PutContent(ctx context.Context, path string, contents []byte) e
 }
 
 // Reader retrieves an io.ReadCloser for the content stored at "path" with a
-// given byte offset.
+// given byte offset. Blobs are read from the nearest healthy read replica, if
+// any, falling back to the primary bucket.
 func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
-	s := d.s3Client(ctx)
+	if r := d.Replicas.nearest(path); r != nil {
+		rc, err := d.reader(d.client(ctx, r.S3, r.Bucket), r.Bucket, path, offset)
+		if err == nil {
+			return rc, nil
+		}
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			r.fail(ctx, err)
+		}
+	}
+	return d.reader(d.s3Client(ctx), d.Bucket, path, offset)
+}
 
+func (d *driver) reader(s *s3.S3, bucket, path string, offset int64) (io.ReadCloser, error) {
 	resp, err := s.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(d.Bucket),
+		Bucket: aws.String(bucket),
 		Key:    aws.String(d.s3Path(path)),
 		Range:  aws.String("bytes=" + strconv.FormatInt(offset, 10) + "-"),
 	})
@@ -789,7 +909,7 @@ func (d *driver) Writer(ctx context.Context, path string, appendParam bool) (sto
 		if err != nil {
 			return nil, err
 		}
-		return d.newWriter(key, *resp.UploadId, nil), nil
+		return d.newWriter(ctx, key, *resp.UploadId, nil), nil
 	}
 
 	listMultipartUploadsInput := &s3.ListMultipartUploadsInput{
@@ -836,7 +956,7 @@ func (d *driver) Writer(ctx context.Context, path string, appendParam bool) (sto
 				}
 				allParts = append(allParts, partsList.Parts...)
 			}
-			return d.newWriter(key, *multi.UploadId, allParts), nil
+			return d.newWriter(ctx, key, *multi.UploadId, allParts), nil
 		}
 
 		// resp.NextUploadIdMarker must have at least one element or we would have returned not found
@@ -1173,17 +1293,32 @@ func (d *driver) URLFor(ctx context.Context, path string, options map[string]int
 		}
 	}
 
+	bucket := d.Bucket
+	if r := d.Replicas.nearest(path); r != nil && methodString == http.MethodGet {
+		// the blob may not have been replicated yet
+		rs := d.client(ctx, r.S3, r.Bucket)
+		_, err := rs.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(r.Bucket),
+			Key:    aws.String(d.s3Path(path)),
+		})
+		if err == nil {
+			s, bucket = rs, r.Bucket
+		} else if !isNotFound(err) {
+			r.fail(ctx, err)
+		}
+	}
+
 	var req *request.Request
 
 	switch methodString {
 	case http.MethodGet:
 		req, _ = s.GetObjectRequest(&s3.GetObjectInput{
-			Bucket: aws.String(d.Bucket),
+			Bucket: aws.String(bucket),
 			Key:    aws.String(d.s3Path(path)),
 		})
 	case http.MethodHead:
 		req, _ = s.HeadObjectRequest(&s3.HeadObjectInput{
-			Bucket: aws.String(d.Bucket),
+			Bucket: aws.String(bucket),
 			Key:    aws.String(d.s3Path(path)),
 		})
 	default:
@@ -1368,6 +1503,81 @@ func (d *Driver) S3BucketKey(path string) string {
 	return d.StorageDriver.(*driver).s3Path(path)
 }
 
+var _ storagedriver.MultipartUploader = &Driver{}
+
+// MultipartUploads lists the multipart uploads in progress to the files under
+// the given path, with the size of the parts uploaded so far.
+func (d *Driver) MultipartUploads(ctx context.Context, path string) ([]storagedriver.MultipartUpload, error) {
+	dr := d.StorageDriver.(*driver)
+	s := dr.s3Client(ctx)
+
+	prefix := ""
+	if dr.s3Path("") == "" {
+		prefix = "/"
+	}
+
+	var uploads []storagedriver.MultipartUpload
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(dr.Bucket),
+		Prefix: aws.String(dr.s3Path(path)),
+	}
+	for {
+		resp, err := s.ListMultipartUploadsWithContext(ctx, input)
+		if err != nil {
+			return nil, parseError(path, err)
+		}
+
+		for _, multi := range resp.Uploads {
+			upload := storagedriver.MultipartUpload{
+				Path:      strings.Replace(*multi.Key, dr.s3Path(""), prefix, 1),
+				ID:        *multi.UploadId,
+				StartedAt: aws.TimeValue(multi.Initiated),
+			}
+			err := s.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+				Bucket:   aws.String(dr.Bucket),
+				Key:      multi.Key,
+				UploadId: multi.UploadId,
+			}, func(parts *s3.ListPartsOutput, lastPage bool) bool {
+				for _, part := range parts.Parts {
+					upload.Size += aws.Int64Value(part.Size)
+				}
+				return true
+			})
+			if err != nil {
+				if s3Err, ok := err.(awserr.Error); ok && s3Err.Code() == s3.ErrCodeNoSuchUpload {
+					// the upload completed or was aborted since it was listed
+					continue
+				}
+				return nil, parseError(upload.Path, err)
+			}
+			uploads = append(uploads, upload)
+		}
+
+		if !aws.BoolValue(resp.IsTruncated) {
+			return uploads, nil
+		}
+		input.KeyMarker = resp.NextKeyMarker
+		input.UploadIdMarker = resp.NextUploadIdMarker
+	}
+}
+
+// AbortMultipartUpload aborts a multipart upload, deleting its parts.
+func (d *Driver) AbortMultipartUpload(ctx context.Context, upload storagedriver.MultipartUpload) error {
+	dr := d.StorageDriver.(*driver)
+	_, err := dr.s3Client(ctx).AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(dr.Bucket),
+		Key:      aws.String(dr.s3Path(upload.Path)),
+		UploadId: aws.String(upload.ID),
+	})
+	if s3Err, ok := err.(awserr.Error); ok && s3Err.Code() == s3.ErrCodeNoSuchUpload {
+		return nil
+	}
+	if err != nil {
+		return parseError(upload.Path, err)
+	}
+	return nil
+}
+
 func parseError(path string, err error) error {
 	if s3Err, ok := err.(awserr.Error); ok {
 		switch s3Err.Code() {
@@ -1417,10 +1627,16 @@ func (d *driver) getStorageClass() *string {
 // part is at least as large as the chunksize, so the multipart upload could be
 // cleanly resumed in the future. This is violated if Close is called after less
 // than a full chunk is written.
+//
+// With a multipart upload concurrency above one, parts are uploaded in the
+// background while the next ones are buffered, and the uploads are waited
+// for before the writer completes the multipart upload or is closed.
 type writer struct {
 	driver      *driver
 	key         string
 	uploadID    string
+	chunkSize   int64
+	nextPart    int64
 	parts       []*s3.Part
 	size        int64
 	readyPart   []byte
@@ -1428,22 +1644,56 @@ type writer struct {
 	closed      bool
 	committed   bool
 	cancelled   bool
+
+	// uploads limits the number of parts uploaded concurrently.
+	uploads  chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	uploaded []*s3.Part
+	err      error
 }
 
-func (d *driver) newWriter(key, uploadID string, parts []*s3.Part) storagedriver.FileWriter {
+func (d *driver) newWriter(ctx context.Context, key, uploadID string, parts []*s3.Part) storagedriver.FileWriter {
 	var size int64
+	var nextPart int64 = 1
 	for _, part := range parts {
 		size += *part.Size
+		if *part.PartNumber >= nextPart {
+			nextPart = *part.PartNumber + 1
+		}
 	}
 	return &writer{
-		driver:   d,
-		key:      key,
-		uploadID: uploadID,
-		parts:    parts,
-		size:     size,
+		driver:    d,
+		key:       key,
+		uploadID:  uploadID,
+		chunkSize: d.chunkSizeFor(ctx),
+		nextPart:  nextPart,
+		parts:     parts,
+		size:      size,
+		uploads:   make(chan struct{}, d.MultipartUploadConcurrency),
 	}
 }
 
+// chunkSizeFor returns the part size of the uploads opened with the given
+// context. With adaptive chunk sizes, the part size of an upload whose size
+// is hinted is scaled to split it in adaptiveChunkParts parts, between the
+// chunk size and the maximum chunk size.
+func (d *driver) chunkSizeFor(ctx context.Context) int64 {
+	size, ok := storagedriver.SizeHint(ctx)
+	if !d.AdaptiveChunkSize || !ok {
+		return d.ChunkSize
+	}
+	// round up to a multiple of 1MB
+	chunkSize := ((size+adaptiveChunkParts-1)/adaptiveChunkParts + 1<<20 - 1) &^ (1<<20 - 1)
+	if chunkSize < d.ChunkSize {
+		return d.ChunkSize
+	}
+	if chunkSize > d.MaxChunkSize {
+		return d.MaxChunkSize
+	}
+	return chunkSize
+}
+
 type completedParts []*s3.CompletedPart
 
 func (a completedParts) Len() int           { return len(a) }
@@ -1501,6 +1751,7 @@ func (w *writer) Write(p []byte) (int, error) {
 			return 0, parseError(w.key, err)
 		}
 		w.uploadID = *resp.UploadId
+		w.nextPart = 2
 
 		// If the entire written file is smaller than minChunkSize, we need to make
 		// a new part from scratch :double sad face:
@@ -1514,6 +1765,7 @@ func (w *writer) Write(p []byte) (int, error) {
 			}
 			defer resp.Body.Close()
 			w.parts = nil
+			w.nextPart = 1
 			w.readyPart, err = io.ReadAll(resp.Body)
 			if err != nil {
 				return 0, err
@@ -1544,7 +1796,7 @@ func (w *writer) Write(p []byte) (int, error) {
 
 	for len(p) > 0 {
 		// If no parts are ready to write, fill up the first part
-		if neededBytes := int(w.driver.ChunkSize) - len(w.readyPart); neededBytes > 0 {
+		if neededBytes := int(w.chunkSize) - len(w.readyPart); neededBytes > 0 {
 			if len(p) >= neededBytes {
 				w.readyPart = append(w.readyPart, p[:neededBytes]...)
 				n += neededBytes
@@ -1556,7 +1808,7 @@ func (w *writer) Write(p []byte) (int, error) {
 			}
 		}
 
-		if neededBytes := int(w.driver.ChunkSize) - len(w.pendingPart); neededBytes > 0 {
+		if neededBytes := int(w.chunkSize) - len(w.pendingPart); neededBytes > 0 {
 			if len(p) >= neededBytes {
 				w.pendingPart = append(w.pendingPart, p[:neededBytes]...)
 				n += neededBytes
@@ -1586,7 +1838,11 @@ func (w *writer) Close() error {
 		return fmt.Errorf("already closed")
 	}
 	w.closed = true
-	return w.flushPart()
+	if err := w.flushPart(); err != nil {
+		w.wait()
+		return err
+	}
+	return w.wait()
 }
 
 func (w *writer) Cancel(ctx context.Context) error {
@@ -1596,6 +1852,7 @@ func (w *writer) Cancel(ctx context.Context) error {
 		return fmt.Errorf("already committed")
 	}
 	w.cancelled = true
+	w.wait()
 	_, err := w.driver.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
 		Bucket:   aws.String(w.driver.Bucket),
 		Key:      aws.String(w.key),
@@ -1613,7 +1870,11 @@ func (w *writer) Commit() error {
 		return fmt.Errorf("already cancelled")
 	}
 	err := w.flushPart()
+	if err == nil {
+		err = w.wait()
+	}
 	if err != nil {
+		w.wait()
 		return err
 	}
 	w.committed = true
@@ -1678,30 +1939,79 @@ func (w *writer) flushPart() error {
 		// nothing to write
 		return nil
 	}
-	if w.driver.MultipartCombineSmallPart && len(w.pendingPart) < int(w.driver.ChunkSize) {
+	if w.driver.MultipartCombineSmallPart && len(w.pendingPart) < int(w.chunkSize) {
 		// closing with a small pending part
 		// combine ready and pending to avoid writing a small part
 		w.readyPart = append(w.readyPart, w.pendingPart...)
 		w.pendingPart = nil
 	}
 
-	partNumber := aws.Int64(int64(len(w.parts) + 1))
+	partNumber := w.nextPart
+	part := w.readyPart
+	w.nextPart++
+	w.readyPart = w.pendingPart
+	w.pendingPart = nil
+
+	if w.driver.MultipartUploadConcurrency <= 1 {
+		if err := w.uploadPart(partNumber, part); err != nil {
+			return err
+		}
+		return w.wait()
+	}
+
+	// the part is handed over to the upload, which blocks once enough
+	// parts are being uploaded
+	w.uploads <- struct{}{}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() { <-w.uploads }()
+		w.uploadPart(partNumber, part)
+	}()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// uploadPart uploads a part, recording the first error of the uploads.
+func (w *writer) uploadPart(partNumber int64, part []byte) error {
 	resp, err := w.driver.S3.UploadPart(&s3.UploadPartInput{
 		Bucket:     aws.String(w.driver.Bucket),
 		Key:        aws.String(w.key),
-		PartNumber: partNumber,
+		PartNumber: aws.Int64(partNumber),
 		UploadId:   aws.String(w.uploadID),
-		Body:       bytes.NewReader(w.readyPart),
+		Body:       bytes.NewReader(part),
 	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if err != nil {
-		return parseError(w.key, err)
+		err = parseError(w.key, err)
+		if w.err == nil {
+			w.err = err
+		}
+		return err
 	}
-	w.parts = append(w.parts, &s3.Part{
+	w.uploaded = append(w.uploaded, &s3.Part{
 		ETag:       resp.ETag,
-		PartNumber: partNumber,
-		Size:       aws.Int64(int64(len(w.readyPart))),
+		PartNumber: aws.Int64(partNumber),
+		Size:       aws.Int64(int64(len(part))),
 	})
-	w.readyPart = w.pendingPart
-	w.pendingPart = nil
 	return nil
 }
+
+// wait waits for the parts being uploaded, adding them to the parts of the
+// writer in order, and returns the first error of the uploads.
+func (w *writer) wait() error {
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sort.Slice(w.uploaded, func(i, j int) bool {
+		return *w.uploaded[i].PartNumber < *w.uploaded[j].PartNumber
+	})
+	w.parts = append(w.parts, w.uploaded...)
+	w.uploaded = nil
+	return w.err
+}
@@ -2,16 +2,23 @@ package s3
 
 import (
 	"bytes"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"gopkg.in/check.v1"
 
@@ -145,6 +152,11 @@ func init() {
 			accelerateBool,
 			false,
 			map[string]string{},
+			nil,
+			defaultReplicaCheckInterval,
+			1,
+			false,
+			defaultMaxChunkSize,
 		}
 
 		return New(parameters)
@@ -825,6 +837,293 @@ func TestListObjectsV2(t *testing.T) {
 	}
 }
 
+// fakeBucket serves the objects of a bucket over the S3 API, counting the
+// objects read.
+type fakeBucket struct {
+	objects map[string]string
+	latency time.Duration
+	reads   int32
+	down    int32
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&b.down) == 1 {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>down</Message></Error>")
+		return
+	}
+	time.Sleep(b.latency)
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		return
+	}
+	content, ok := b.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>")
+		return
+	}
+	if r.Method == http.MethodGet {
+		atomic.AddInt32(&b.reads, 1)
+	}
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+}
+
+func TestReadReplicas(t *testing.T) {
+	blob := "/docker/registry/v2/blobs/sha256/ab/abcd/data"
+	pending := "/docker/registry/v2/blobs/sha256/cd/cdef/data"
+	tag := "/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link"
+
+	primary := &fakeBucket{
+		objects: map[string]string{
+			blob[1:]:    "blob",
+			pending[1:]: "pending",
+			tag[1:]:     "new",
+		},
+		latency: 50 * time.Millisecond,
+	}
+	replica := &fakeBucket{
+		objects: map[string]string{
+			blob[1:]: "blob",
+			tag[1:]:  "old",
+		},
+	}
+	primaryServer := httptest.NewServer(primary)
+	defer primaryServer.Close()
+	replicaServer := httptest.NewServer(replica)
+	defer replicaServer.Close()
+
+	d, err := FromParameters(map[string]interface{}{
+		"accesskey":      "accesskey",
+		"secretkey":      "secretkey",
+		"region":         "us-east-1",
+		"regionendpoint": primaryServer.URL,
+		"bucket":         "primary",
+		"readreplicas": []interface{}{
+			map[interface{}]interface{}{
+				"bucket":         "replica",
+				"region":         "eu-west-1",
+				"regionendpoint": replicaServer.URL,
+			},
+		},
+		"replicacheckinterval": "1h",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+	replicas := d.baseEmbed.Base.StorageDriver.(*driver).Replicas
+	replicas.check()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		path         string
+		expected     string
+		primaryReads int32
+		replicaReads int32
+	}{
+		{path: blob, expected: "blob", replicaReads: 1},
+		{path: tag, expected: "new", primaryReads: 1, replicaReads: 1},
+		{path: pending, expected: "pending", primaryReads: 2, replicaReads: 1},
+	} {
+		content, err := d.GetContent(ctx, tc.path)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", tc.path, err)
+		}
+		if string(content) != tc.expected {
+			t.Fatalf("unexpected content for %s: %q != %q", tc.path, content, tc.expected)
+		}
+		if reads := atomic.LoadInt32(&primary.reads); reads != tc.primaryReads {
+			t.Fatalf("unexpected number of primary reads: %d != %d", reads, tc.primaryReads)
+		}
+		if reads := atomic.LoadInt32(&replica.reads); reads != tc.replicaReads {
+			t.Fatalf("unexpected number of replica reads: %d != %d", reads, tc.replicaReads)
+		}
+	}
+
+	u, err := d.URLFor(ctx, blob, nil)
+	if err != nil {
+		t.Fatalf("unexpected error getting url: %v", err)
+	}
+	if !strings.HasPrefix(u, replicaServer.URL+"/replica/") {
+		t.Fatalf("expected url of the replica: %s", u)
+	}
+
+	// a failing replica is skipped until it is healthy again
+	atomic.StoreInt32(&replica.down, 1)
+	for i := 0; i < 2; i++ {
+		if _, err := d.GetContent(ctx, blob); err != nil {
+			t.Fatalf("unexpected error reading %s: %v", blob, err)
+		}
+	}
+	if reads := atomic.LoadInt32(&primary.reads); reads != 4 {
+		t.Fatalf("unexpected number of primary reads: %d != 4", reads)
+	}
+	if replicas.nearest(blob) != nil {
+		t.Fatal("expected the failing replica to be unhealthy")
+	}
+
+	atomic.StoreInt32(&replica.down, 0)
+	replicas.check()
+	if replicas.nearest(blob) == nil {
+		t.Fatal("expected the replica to be healthy again")
+	}
+}
+
+// fakeMultipartBucket serves the multipart uploads of a bucket over the S3
+// API, recording the largest number of parts uploaded concurrently.
+type fakeMultipartBucket struct {
+	latency time.Duration
+
+	mu           sync.Mutex
+	parts        map[string][]byte
+	partSizes    []int
+	objects      map[string][]byte
+	uploading    int
+	maxUploading int
+	nextUploadID int
+}
+
+func (b *fakeMultipartBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		b.mu.Lock()
+		b.nextUploadID++
+		id := strconv.Itoa(b.nextUploadID)
+		b.mu.Unlock()
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+
+	case r.Method == http.MethodPut && query.Get("partNumber") != "":
+		b.mu.Lock()
+		b.uploading++
+		if b.uploading > b.maxUploading {
+			b.maxUploading = b.uploading
+		}
+		b.mu.Unlock()
+		time.Sleep(b.latency)
+		content, _ := io.ReadAll(r.Body)
+
+		b.mu.Lock()
+		b.uploading--
+		b.parts[query.Get("uploadId")+"/"+query.Get("partNumber")] = content
+		b.partSizes = append(b.partSizes, len(content))
+		b.mu.Unlock()
+		w.Header().Set("ETag", `"`+query.Get("partNumber")+`"`)
+
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b.mu.Lock()
+		var content []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 {
+				b.mu.Unlock()
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, "<Error><Code>InvalidPartOrder</Code><Message>invalid part order</Message></Error>")
+				return
+			}
+			content = append(content, b.parts[query.Get("uploadId")+"/"+strconv.Itoa(part.PartNumber)]...)
+		}
+		b.objects[key] = content
+		b.mu.Unlock()
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestParallelMultipartUpload(t *testing.T) {
+	bucket := &fakeMultipartBucket{
+		latency: 20 * time.Millisecond,
+		parts:   make(map[string][]byte),
+		objects: make(map[string][]byte),
+	}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+
+	d, err := FromParameters(map[string]interface{}{
+		"accesskey":                  "accesskey",
+		"secretkey":                  "secretkey",
+		"region":                     "us-east-1",
+		"regionendpoint":             server.URL,
+		"bucket":                     "bucket",
+		"chunksize":                  minChunkSize,
+		"multipartuploadconcurrency": 4,
+		"adaptivechunksize":          true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+
+	contents := make([]byte, 6*minChunkSize+100)
+	rand.Read(contents)
+
+	// the part size only grows for uploads hinted to be larger than the
+	// chunk size allows
+	ctx := storagedriver.WithSizeHint(context.Background(), int64(len(contents)))
+	w, err := d.Writer(ctx, "/blob", false)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	for p := contents; len(p) > 0; {
+		n := 1 << 20
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+		p = p[n:]
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	if !bytes.Equal(bucket.objects["blob"], contents) {
+		t.Fatalf("unexpected content of %d bytes, expected %d bytes", len(bucket.objects["blob"]), len(contents))
+	}
+	if bucket.maxUploading < 2 || bucket.maxUploading > 4 {
+		t.Fatalf("expected parts to be uploaded concurrently up to the concurrency, got %d", bucket.maxUploading)
+	}
+	for _, size := range bucket.partSizes {
+		if size != minChunkSize && size != minChunkSize+100 {
+			t.Fatalf("unexpected part sizes %v", bucket.partSizes)
+		}
+	}
+
+	driver := d.baseEmbed.Base.StorageDriver.(*driver)
+	for _, tc := range []struct {
+		hint     int64
+		expected int64
+	}{
+		{0, minChunkSize},
+		{1 << 30, minChunkSize},
+		{20 << 30, 21 << 20},
+		{1 << 40, defaultMaxChunkSize},
+	} {
+		ctx := context.Background()
+		if tc.hint > 0 {
+			ctx = storagedriver.WithSizeHint(ctx, tc.hint)
+		}
+		if chunkSize := driver.chunkSizeFor(ctx); chunkSize != tc.expected {
+			t.Errorf("unexpected chunk size for a hint of %d: %d != %d", tc.hint, chunkSize, tc.expected)
+		}
+	}
+}
+
 func compareWalked(t *testing.T, expected, walked []string) {
 	if len(walked) != len(expected) {
 		t.Fatalf("Mismatch number of fileInfo walked %d expected %d; walked %s; expected %s;", len(walked), len(expected), walked, expected)
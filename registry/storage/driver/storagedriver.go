@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Version is a string representing the storage driver version, of the form
@@ -111,6 +112,47 @@ type FileWriter interface {
 	Commit() error
 }
 
+// MultipartUploader is implemented by the storage drivers whose FileWriters
+// store the content written in parts which the backend keeps until the write
+// is committed or aborted, such as S3 multipart uploads. Parts of writes which
+// are never committed are not removed by Delete.
+type MultipartUploader interface {
+	// MultipartUploads lists the writes in progress to the files under path.
+	MultipartUploads(ctx context.Context, path string) ([]MultipartUpload, error)
+
+	// AbortMultipartUpload aborts a write in progress, discarding its parts.
+	AbortMultipartUpload(ctx context.Context, upload MultipartUpload) error
+}
+
+// MultipartUpload is a write in progress to a file, whose parts are kept by
+// the backend.
+type MultipartUpload struct {
+	// Path is the path of the file written.
+	Path string
+	// ID identifies the write in the backend.
+	ID string
+	// StartedAt is the time the write was started.
+	StartedAt time.Time
+	// Size is the number of bytes of the parts kept by the backend.
+	Size int64
+}
+
+type sizeHintKey struct{}
+
+// WithSizeHint returns a context carrying the number of bytes expected to be
+// written to the FileWriters opened with it. Drivers may use the hint to size
+// their writes, but must not rely on it.
+func WithSizeHint(ctx context.Context, size int64) context.Context {
+	return context.WithValue(ctx, sizeHintKey{}, size)
+}
+
+// SizeHint returns the number of bytes expected to be written to the
+// FileWriters opened with the context, if known.
+func SizeHint(ctx context.Context) (int64, bool) {
+	size, ok := ctx.Value(sizeHintKey{}).(int64)
+	return size, ok && size > 0
+}
+
 // PathRegexp is the regular expression which each file path must match. A
 // file path is absolute, beginning with a slash and containing a positive
 // number of path components separated by slashes, where each component is
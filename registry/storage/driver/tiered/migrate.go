@@ -0,0 +1,298 @@
+package tiered
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	dcontext "github.com/docker/distribution/context"
+	prometheus "github.com/docker/distribution/metrics"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/go-metrics"
+)
+
+// accessPrefix is the path on the hot tier under which the last access time
+// of each blob is recorded in an access marker, at the path of the directory
+// of the blob relative to the blobs.
+const accessPrefix = "/tiered-access"
+
+// accessStateSaveFrequency is how often the accesses recorded since the
+// last save are written to the access markers.
+const accessStateSaveFrequency = time.Minute
+
+var (
+	tieredReads         = prometheus.TieredNamespace.NewLabeledCounter("reads", "The number of blob reads by the tier they were served from", "tier")
+	tieredMigrations    = prometheus.TieredNamespace.NewLabeledCounter("migrations", "The number of blobs migrated to the cold tier by outcome", "outcome")
+	tieredMigratedBytes = prometheus.TieredNamespace.NewCounter("migrated_bytes", "The number of bytes migrated to the cold tier")
+)
+
+func init() {
+	metrics.Register(prometheus.TieredNamespace)
+}
+
+// MigrationResult counts the blobs migrated to the cold tier.
+type MigrationResult struct {
+	Blobs int
+	Bytes int64
+	// Failed is the number of blobs which could not be migrated.
+	Failed int
+}
+
+// accessIndex records the last access times of the blobs on the hot tier.
+// The accesses are kept in memory and periodically written to the access
+// marker of each blob accessed. Registries sharing the hot tier only write
+// the markers of the blobs they accessed, so they do not overwrite each
+// other's accesses to other blobs, and concurrent writes to the marker of a
+// blob all record a recent access.
+type accessIndex struct {
+	driver storagedriver.StorageDriver
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+func newAccessIndex(driver storagedriver.StorageDriver) *accessIndex {
+	return &accessIndex{
+		driver:  driver,
+		pending: make(map[string]time.Time),
+	}
+}
+
+// markerPath returns the path of the access marker of the blob data at p, or
+// of the directory of the markers of the blobs under p.
+func markerPath(p string) string {
+	if isBlobData(p) {
+		p = path.Dir(p)
+	}
+	return accessPrefix + strings.TrimPrefix(p, blobsPrefix)
+}
+
+// accessed records an access to the blob data at path.
+func (ai *accessIndex) accessed(path string) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.pending[path] = time.Now().UTC()
+}
+
+// lastAccess returns the access time recorded by the marker of the blob data
+// at path, or the zero time if there is none.
+func (ai *accessIndex) lastAccess(ctx context.Context, path string) (time.Time, error) {
+	b, err := ai.driver.GetContent(ctx, markerPath(path))
+	if err != nil {
+		if isPathNotFound(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(b))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid access marker of %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// save writes the pending accesses to the markers of their blobs.
+func (ai *accessIndex) save(ctx context.Context) error {
+	ai.mu.Lock()
+	pending := ai.pending
+	ai.pending = make(map[string]time.Time)
+	ai.mu.Unlock()
+
+	var firstErr error
+	for path, t := range pending {
+		err := ai.driver.PutContent(ctx, markerPath(path), []byte(t.Format(time.RFC3339Nano)))
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		// record the access again, unless it was superseded
+		ai.mu.Lock()
+		if t.After(ai.pending[path]) {
+			ai.pending[path] = t
+		}
+		ai.mu.Unlock()
+	}
+	return firstErr
+}
+
+// forget deletes the markers of the blobs under path, which were deleted.
+func (ai *accessIndex) forget(ctx context.Context, path string) error {
+	err := ai.driver.Delete(ctx, markerPath(path))
+	if err != nil && !isPathNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// prune deletes the markers of the blobs missing from the hot tier, which
+// were deleted or migrated without their markers, such as by a registry
+// which recorded an access as they were deleted.
+func (ai *accessIndex) prune(ctx context.Context, hot map[string]bool) error {
+	var stale []string
+	err := ai.driver.Walk(ctx, accessPrefix, func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			return nil
+		}
+		if !hot[path.Join(blobsPrefix, strings.TrimPrefix(fi.Path(), accessPrefix), "data")] {
+			stale = append(stale, fi.Path())
+		}
+		return nil
+	})
+	if err != nil && !isPathNotFound(err) {
+		return err
+	}
+	for _, p := range stale {
+		if err := ai.driver.Delete(ctx, p); err != nil && !isPathNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// start saves the accesses periodically, and migrates blobs every
+// migration interval if it is set.
+func (d *driver) start(ctx context.Context) {
+	go func() {
+		save := time.NewTicker(accessStateSaveFrequency)
+		defer save.Stop()
+
+		var migrate <-chan time.Time
+		if d.migrationInterval > 0 {
+			ticker := time.NewTicker(d.migrationInterval)
+			defer ticker.Stop()
+			migrate = ticker.C
+		}
+
+		for {
+			select {
+			case <-save.C:
+				if err := d.index.save(ctx); err != nil {
+					dcontext.GetLogger(ctx).Errorf("Error saving the access times of blobs: %s", err)
+				}
+			case <-migrate:
+				result, err := d.migrate(ctx, false)
+				if err != nil {
+					dcontext.GetLogger(ctx).Errorf("Error migrating blobs to the cold tier: %s", err)
+					continue
+				}
+				dcontext.GetLogger(ctx).Infof("Migrated %d blobs (%d bytes) to the cold tier, %d failed", result.Blobs, result.Bytes, result.Failed)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// migrate moves the data of the blobs on the hot tier which were neither
+// written nor accessed within the migration age to the cold tier.
+func (d *driver) migrate(ctx context.Context, dryRun bool) (MigrationResult, error) {
+	var result MigrationResult
+	if err := d.index.save(ctx); err != nil {
+		return result, err
+	}
+
+	cutoff := time.Now().Add(-d.migrateAfter)
+	hot := make(map[string]bool)
+	var candidates []storagedriver.FileInfo
+	err := d.hot.Walk(ctx, blobsPrefix, func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() || !isBlobData(fi.Path()) {
+			return nil
+		}
+		hot[fi.Path()] = true
+		if fi.ModTime().Before(cutoff) {
+			candidates = append(candidates, fi)
+		}
+		return nil
+	})
+	if err != nil && !isPathNotFound(err) {
+		return result, err
+	}
+
+	for _, fi := range candidates {
+		// only the markers of blobs written before the cutoff are read
+		lastAccess, err := d.index.lastAccess(ctx, fi.Path())
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error reading the last access time of %s: %s", fi.Path(), err)
+			result.Failed++
+			continue
+		}
+		if !lastAccess.Before(cutoff) {
+			continue
+		}
+
+		if dryRun {
+			dcontext.GetLogger(ctx).Infof("Would migrate %s to the cold tier", fi.Path())
+			result.Blobs++
+			result.Bytes += fi.Size()
+			continue
+		}
+		if err := d.migrateBlob(ctx, fi); err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error migrating %s to the cold tier: %s", fi.Path(), err)
+			tieredMigrations.WithValues("failed").Inc(1)
+			result.Failed++
+			continue
+		}
+		delete(hot, fi.Path())
+		tieredMigrations.WithValues("migrated").Inc(1)
+		tieredMigratedBytes.Inc(float64(fi.Size()))
+		result.Blobs++
+		result.Bytes += fi.Size()
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	// only the blobs remaining on the hot tier need their access markers
+	return result, d.index.prune(ctx, hot)
+}
+
+// migrateBlob copies the data of a blob to the cold tier, then deletes the
+// blob from the hot tier. Reads of the blob fall back to the cold tier from
+// then on.
+func (d *driver) migrateBlob(ctx context.Context, fi storagedriver.FileInfo) error {
+	rc, err := d.hot.Reader(ctx, fi.Path(), 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w, err := d.cold.Writer(ctx, fi.Path(), false)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w, rc)
+	if err == nil && n != fi.Size() {
+		err = fmt.Errorf("copied %d bytes of %d", n, fi.Size())
+	}
+	if err != nil {
+		w.Cancel(ctx)
+		w.Close()
+		return err
+	}
+	if err := w.Commit(); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	// the blob may have been deleted while it was copied
+	if _, err := d.hot.Stat(ctx, fi.Path()); isPathNotFound(err) {
+		return d.cold.Delete(ctx, fi.Path())
+	} else if err != nil {
+		return err
+	}
+
+	// the directory of the blob only holds its data
+	if err := d.hot.Delete(ctx, path.Dir(fi.Path())); err != nil && !isPathNotFound(err) {
+		return err
+	}
+	return d.index.forget(ctx, fi.Path())
+}
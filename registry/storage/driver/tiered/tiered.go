@@ -0,0 +1,414 @@
+// Package tiered provides a storagedriver.StorageDriver implementation
+// composing two storage drivers: a fast hot tier, to which everything is
+// written, and a cold tier, to which blobs that were not accessed for a while
+// are migrated. Reads are served from whichever tier holds the blob.
+//
+// Only the data of blobs is migrated. Everything else, such as the links of
+// repositories and the upload sessions, stays on the hot tier.
+package tiered
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/base"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+)
+
+const driverName = "tiered"
+
+const (
+	defaultMigrateAfter      = 30 * 24 * time.Hour
+	defaultMigrationInterval = 24 * time.Hour
+)
+
+// blobsPrefix is the path under which the blobs are stored.
+const blobsPrefix = "/docker/registry/v2/blobs"
+
+// blobDataPathRegexp matches the paths of blob data in storage.
+var blobDataPathRegexp = regexp.MustCompile(`^/docker/registry/v2/blobs/[a-z0-9]+/[0-9a-f]{2}/[0-9a-f]+/data$`)
+
+// DriverParameters A struct that encapsulates all of the driver parameters after all values have been set
+type DriverParameters struct {
+	Hot               storagedriver.StorageDriver
+	Cold              storagedriver.StorageDriver
+	MigrateAfter      time.Duration
+	MigrationInterval time.Duration
+}
+
+func init() {
+	factory.Register(driverName, &tieredDriverFactory{})
+}
+
+// tieredDriverFactory implements the factory.StorageDriverFactory interface
+type tieredDriverFactory struct{}
+
+func (factory *tieredDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+var _ storagedriver.StorageDriver = &driver{}
+
+type driver struct {
+	hot               storagedriver.StorageDriver
+	cold              storagedriver.StorageDriver
+	migrateAfter      time.Duration
+	migrationInterval time.Duration
+	index             *accessIndex
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation storing recently
+// accessed blobs on a hot tier and the others on a cold tier.
+type Driver struct {
+	baseEmbed
+	tiers *driver
+}
+
+// FromParameters constructs a new Driver with a given parameters map, and
+// starts migrating blobs periodically.
+// Required parameters:
+// - hot: the driver of the hot tier, and its parameters
+// - cold: the driver of the cold tier, and its parameters
+// Optional parameters:
+// - migrateafter: the time after which unaccessed blobs are migrated to the cold tier
+// - migrationinterval: the interval between migrations, 0 to only migrate on demand
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	params, err := NewParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	d := New(params)
+	d.tiers.start(context.Background())
+	return d, nil
+}
+
+// NewParameters constructs the drivers of the tiers from a parameters map.
+func NewParameters(parameters map[string]interface{}) (DriverParameters, error) {
+	var params DriverParameters
+	var err error
+	if params.Hot, err = tierDriver(parameters, "hot"); err != nil {
+		return DriverParameters{}, err
+	}
+	if params.Cold, err = tierDriver(parameters, "cold"); err != nil {
+		return DriverParameters{}, err
+	}
+	if params.MigrateAfter, err = durationParameter(parameters, "migrateafter", defaultMigrateAfter); err != nil {
+		return DriverParameters{}, err
+	}
+	if params.MigrateAfter <= 0 {
+		return DriverParameters{}, fmt.Errorf("migrateafter must be positive")
+	}
+	if params.MigrationInterval, err = durationParameter(parameters, "migrationinterval", defaultMigrationInterval); err != nil {
+		return DriverParameters{}, err
+	}
+	return params, nil
+}
+
+// tierDriver constructs the driver of a tier, configured as a map with the
+// name of the driver as its only key and the parameters of the driver as
+// its value.
+func tierDriver(parameters map[string]interface{}, tier string) (storagedriver.StorageDriver, error) {
+	config, err := stringMap(parameters[tier])
+	if err != nil || len(config) != 1 {
+		return nil, fmt.Errorf("%s must configure exactly one storage driver", tier)
+	}
+	for name, value := range config {
+		if name == driverName {
+			return nil, fmt.Errorf("%s cannot be a %s driver", tier, driverName)
+		}
+		params, err := stringMap(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameters for the %s driver of %s: %v", name, tier, err)
+		}
+		d, err := factory.Create(name, params)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the %s driver of %s: %v", name, tier, err)
+		}
+		return d, nil
+	}
+	return nil, nil
+}
+
+// stringMap converts a map decoded from the configuration to a map with
+// string keys.
+func stringMap(v interface{}) (map[string]interface{}, error) {
+	switch m := v.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		params := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v", k)
+			}
+			params[key] = v
+		}
+		return params, nil
+	default:
+		return nil, fmt.Errorf("expected a map, got %T", v)
+	}
+}
+
+func durationParameter(parameters map[string]interface{}, name string, defaultValue time.Duration) (time.Duration, error) {
+	switch v := parameters[name].(type) {
+	case nil:
+		return defaultValue, nil
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		return d, nil
+	default:
+		return 0, fmt.Errorf("%s must be a duration", name)
+	}
+}
+
+// New constructs a new Driver from the drivers of its tiers. Blobs are only
+// migrated periodically by drivers constructed with FromParameters.
+func New(params DriverParameters) *Driver {
+	if params.MigrateAfter == 0 {
+		params.MigrateAfter = defaultMigrateAfter
+	}
+	d := &driver{
+		hot:               params.Hot,
+		cold:              params.Cold,
+		migrateAfter:      params.MigrateAfter,
+		migrationInterval: params.MigrationInterval,
+		index:             newAccessIndex(params.Hot),
+	}
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+		tiers: d,
+	}
+}
+
+// Migrate migrates the blobs which were not accessed for longer than the
+// configured time to the cold tier. With dryRun, the blobs are only
+// counted.
+func (d *Driver) Migrate(ctx context.Context, dryRun bool) (MigrationResult, error) {
+	return d.tiers.migrate(ctx, dryRun)
+}
+
+var _ storagedriver.MultipartUploader = &Driver{}
+
+// MultipartUploads lists the multipart uploads of the hot tier, which holds
+// the upload sessions, if its driver keeps any.
+func (d *Driver) MultipartUploads(ctx context.Context, path string) ([]storagedriver.MultipartUpload, error) {
+	uploader, ok := d.tiers.hot.(storagedriver.MultipartUploader)
+	if !ok {
+		return nil, nil
+	}
+	return uploader.MultipartUploads(ctx, path)
+}
+
+// AbortMultipartUpload aborts a multipart upload of the hot tier.
+func (d *Driver) AbortMultipartUpload(ctx context.Context, upload storagedriver.MultipartUpload) error {
+	uploader, ok := d.tiers.hot.(storagedriver.MultipartUploader)
+	if !ok {
+		return fmt.Errorf("%s: the hot tier does not keep multipart uploads", driverName)
+	}
+	return uploader.AbortMultipartUpload(ctx, upload)
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	content, err := d.hot.GetContent(ctx, path)
+	if isBlobData(path) {
+		if isPathNotFound(err) {
+			content, err = d.cold.GetContent(ctx, path)
+			if err == nil {
+				tieredReads.WithValues("cold").Inc(1)
+			}
+		} else if err == nil {
+			d.accessed(path)
+		}
+	}
+	return content, err
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	if err := d.hot.PutContent(ctx, path, contents); err != nil {
+		return err
+	}
+	if isBlobData(path) {
+		d.index.accessed(path)
+	}
+	return nil
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, err := d.hot.Reader(ctx, path, offset)
+	if isBlobData(path) {
+		if isPathNotFound(err) {
+			rc, err = d.cold.Reader(ctx, path, offset)
+			if err == nil {
+				tieredReads.WithValues("cold").Inc(1)
+			}
+		} else if err == nil {
+			d.accessed(path)
+		}
+	}
+	return rc, err
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" on the hot tier.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	return d.hot.Writer(ctx, path, append)
+}
+
+// Stat retrieves the FileInfo for the given path from the hot tier, or from
+// the cold tier for the paths of blobs missing from the hot tier.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, err := d.hot.Stat(ctx, path)
+	if isPathNotFound(err) && inColdTier(path) {
+		return d.cold.Stat(ctx, path)
+	}
+	return fi, err
+}
+
+// List returns a list of the objects that are direct descendants of the
+// given path, in either tier.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	hot, err := d.hot.List(ctx, path)
+	if !inColdTier(path) {
+		return hot, err
+	}
+	if err != nil && !isPathNotFound(err) {
+		return nil, err
+	}
+	hotFound := err == nil
+
+	cold, err := d.cold.List(ctx, path)
+	if isPathNotFound(err) && hotFound {
+		return hot, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(hot))
+	for _, p := range hot {
+		seen[p] = true
+	}
+	for _, p := range cold {
+		if !seen[p] {
+			hot = append(hot, p)
+		}
+	}
+	sort.Strings(hot)
+	return hot, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the
+// original object. Objects only move within a tier.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	err := d.hot.Move(ctx, sourcePath, destPath)
+	if isPathNotFound(err) && inColdTier(sourcePath) {
+		return d.cold.Move(ctx, sourcePath, destPath)
+	}
+	if err == nil && isBlobData(destPath) {
+		d.index.accessed(destPath)
+	}
+	return err
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths,
+// in both tiers.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	err := d.hot.Delete(ctx, path)
+	if !inColdTier(path) {
+		return err
+	}
+	if err != nil && !isPathNotFound(err) {
+		return err
+	}
+	if path == blobsPrefix || strings.HasPrefix(path, blobsPrefix+"/") {
+		if err := d.index.forget(ctx, path); err != nil {
+			return err
+		}
+	}
+
+	coldErr := d.cold.Delete(ctx, path)
+	if isPathNotFound(coldErr) && err == nil {
+		return nil
+	}
+	return coldErr
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at
+// the given path, from the tier holding it.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if !isBlobData(path) {
+		return d.hot.URLFor(ctx, path, options)
+	}
+	if _, err := d.hot.Stat(ctx, path); isPathNotFound(err) {
+		return d.cold.URLFor(ctx, path, options)
+	} else if err != nil {
+		return "", err
+	}
+	d.accessed(path)
+	return d.hot.URLFor(ctx, path, options)
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file.
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	if !inColdTier(path) {
+		return d.hot.Walk(ctx, path, f)
+	}
+	return storagedriver.WalkFallback(ctx, d, path, f)
+}
+
+// accessed records a read of blob data from the hot tier.
+func (d *driver) accessed(path string) {
+	tieredReads.WithValues("hot").Inc(1)
+	d.index.accessed(path)
+}
+
+// isBlobData returns whether the path is the data of a blob, which may be
+// migrated to the cold tier.
+func isBlobData(path string) bool {
+	return blobDataPathRegexp.MatchString(path)
+}
+
+// inColdTier returns whether the path may exist on the cold tier, as a
+// path under the blobs or one of their parent directories.
+func inColdTier(path string) bool {
+	return path == blobsPrefix || strings.HasPrefix(path, blobsPrefix+"/") ||
+		path == "/" || strings.HasPrefix(blobsPrefix, path+"/")
+}
+
+func isPathNotFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
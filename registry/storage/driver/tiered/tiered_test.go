@@ -0,0 +1,289 @@
+package tiered
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+const (
+	blobA = "/docker/registry/v2/blobs/sha256/aa/aaaa/data"
+	blobB = "/docker/registry/v2/blobs/sha256/bb/bbbb/data"
+)
+
+func newTestDriver(migrateAfter time.Duration) (hot, cold storagedriver.StorageDriver, d *Driver) {
+	hot, cold = inmemory.New(), inmemory.New()
+	return hot, cold, New(DriverParameters{Hot: hot, Cold: cold, MigrateAfter: migrateAfter})
+}
+
+func TestNewParameters(t *testing.T) {
+	params, err := NewParameters(map[string]interface{}{
+		"hot":          map[interface{}]interface{}{"inmemory": nil},
+		"cold":         map[string]interface{}{"inmemory": map[interface{}]interface{}{}},
+		"migrateafter": "48h",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if params.Hot == nil || params.Cold == nil || params.MigrateAfter != 48*time.Hour || params.MigrationInterval != defaultMigrationInterval {
+		t.Fatalf("unexpected parameters: %+v", params)
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"cold": map[string]interface{}{"inmemory": nil}},
+		{"hot": map[string]interface{}{"inmemory": nil, "filesystem": nil}, "cold": map[string]interface{}{"inmemory": nil}},
+		{"hot": map[string]interface{}{"unknown": nil}, "cold": map[string]interface{}{"inmemory": nil}},
+		{"hot": map[string]interface{}{"tiered": nil}, "cold": map[string]interface{}{"inmemory": nil}},
+		{"hot": map[string]interface{}{"inmemory": nil}, "cold": map[string]interface{}{"inmemory": nil}, "migrateafter": "0s"},
+		{"hot": map[string]interface{}{"inmemory": nil}, "cold": map[string]interface{}{"inmemory": nil}, "migrationinterval": 5},
+	} {
+		if _, err := NewParameters(invalid); err == nil {
+			t.Errorf("expected error for %v", invalid)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	hot, cold, d := newTestDriver(50 * time.Millisecond)
+	ctx := context.Background()
+
+	for _, p := range []string{blobA, blobB} {
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.PutContent(ctx, "/docker/registry/v2/repositories/foo/_layers/sha256/bbbb/link", []byte("sha256:bbbb")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// accessed blobs stay on the hot tier
+	if _, err := d.GetContent(ctx, blobA); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := d.Migrate(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 1 || result.Bytes != int64(len(blobB)) {
+		t.Fatalf("unexpected dry run result: %+v", result)
+	}
+	if _, err := hot.Stat(ctx, blobB); err != nil {
+		t.Fatalf("expected dry run not to migrate: %v", err)
+	}
+
+	result, err = d.Migrate(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blobs != 1 || result.Failed != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if _, err := hot.Stat(ctx, "/docker/registry/v2/blobs/sha256/bb/bbbb"); !isPathNotFound(err) {
+		t.Fatalf("expected migrated blob to be deleted from the hot tier, got %v", err)
+	}
+	if _, err := hot.Stat(ctx, "/docker/registry/v2/repositories/foo/_layers/sha256/bbbb/link"); err != nil {
+		t.Fatalf("expected links to stay on the hot tier: %v", err)
+	}
+	if content, err := cold.GetContent(ctx, blobB); err != nil || string(content) != blobB {
+		t.Fatalf("expected blob on the cold tier, got %q: %v", content, err)
+	}
+	if _, err := hot.Stat(ctx, blobA); err != nil {
+		t.Fatalf("expected accessed blob to stay on the hot tier: %v", err)
+	}
+
+	// reads fall back to the cold tier
+	if content, err := d.GetContent(ctx, blobB); err != nil || string(content) != blobB {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+	rc, err := d.Reader(ctx, blobB, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(content) != blobB[5:] {
+		t.Fatalf("unexpected content read from offset: %q", content)
+	}
+	fi, err := d.Stat(ctx, blobB)
+	if err != nil || fi.Size() != int64(len(blobB)) {
+		t.Fatalf("unexpected file info %+v: %v", fi, err)
+	}
+
+	list, err := d.List(ctx, "/docker/registry/v2/blobs/sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(list, ",") != "/docker/registry/v2/blobs/sha256/aa,/docker/registry/v2/blobs/sha256/bb" {
+		t.Fatalf("unexpected list of both tiers: %v", list)
+	}
+
+	var walked []string
+	err = d.Walk(ctx, blobsPrefix, func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			walked = append(walked, fi.Path())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(walked, ",") != blobA+","+blobB {
+		t.Fatalf("unexpected walk of both tiers: %v", walked)
+	}
+
+	// deleting a blob deletes it from both tiers
+	if err := d.Delete(ctx, "/docker/registry/v2/blobs/sha256/bb/bbbb"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, blobB); !isPathNotFound(err) {
+		t.Fatalf("expected deleted blob to be missing, got %v", err)
+	}
+	if err := d.Delete(ctx, "/docker/registry/v2/blobs/sha256/bb/bbbb"); !isPathNotFound(err) {
+		t.Fatalf("expected path not found error, got %v", err)
+	}
+}
+
+func TestAccessMarkers(t *testing.T) {
+	hot, _, d := newTestDriver(time.Hour)
+	ctx := context.Background()
+
+	if err := d.PutContent(ctx, blobA, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Migrate(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	index := newAccessIndex(hot)
+	if last, err := index.lastAccess(ctx, blobA); err != nil || last.IsZero() {
+		t.Fatalf("expected the access to be persisted, got %v: %v", last, err)
+	}
+
+	// registries sharing the hot tier only write the markers of the blobs
+	// they accessed
+	other := newAccessIndex(hot)
+	other.accessed(blobB)
+	if err := other.save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := index.lastAccess(ctx, blobA)
+	index.accessed(blobA)
+	if err := index.save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := index.lastAccess(ctx, blobA); !last.After(first) {
+		t.Fatalf("expected the access to be updated, got %v after %v", last, first)
+	}
+	if last, _ := index.lastAccess(ctx, blobB); last.IsZero() {
+		t.Fatal("expected the access of another registry to be kept")
+	}
+
+	// the markers of blobs missing from the hot tier are pruned, and those
+	// of deleted blobs are deleted with them
+	if _, err := d.Migrate(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hot.Stat(ctx, markerPath(blobB)); !isPathNotFound(err) {
+		t.Fatalf("expected the marker of a missing blob to be pruned, got %v", err)
+	}
+	if err := d.Delete(ctx, "/docker/registry/v2/blobs/sha256/aa/aaaa"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hot.Stat(ctx, markerPath(blobA)); !isPathNotFound(err) {
+		t.Fatalf("expected the marker of a deleted blob to be deleted, got %v", err)
+	}
+}
+
+// urlDriver returns URLs naming the tier they were asked to.
+type urlDriver struct {
+	storagedriver.StorageDriver
+	tier string
+}
+
+func (d urlDriver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if _, err := d.Stat(ctx, path); err != nil {
+		return "", err
+	}
+	return d.tier + "://" + path, nil
+}
+
+func TestMoveAndURLFor(t *testing.T) {
+	cold := inmemory.New()
+	d := New(DriverParameters{
+		Hot:  urlDriver{StorageDriver: inmemory.New(), tier: "hot"},
+		Cold: urlDriver{StorageDriver: cold, tier: "cold"},
+	})
+	ctx := context.Background()
+
+	upload := "/docker/registry/v2/repositories/foo/_uploads/id/data"
+	if err := d.PutContent(ctx, upload, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Move(ctx, upload, blobA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, upload); !isPathNotFound(err) {
+		t.Fatalf("expected moved upload to be missing, got %v", err)
+	}
+	if _, ok := d.tiers.index.pending[blobA]; !ok {
+		t.Fatal("expected the moved blob to be recorded as accessed")
+	}
+	if err := cold.PutContent(ctx, blobB, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, expected := range map[string]string{
+		blobA: "hot://" + blobA,
+		blobB: "cold://" + blobB,
+	} {
+		if u, err := d.URLFor(ctx, p, nil); err != nil || u != expected {
+			t.Errorf("unexpected URL for %s: %q, %v", p, u, err)
+		}
+	}
+	if _, err := d.URLFor(ctx, "/docker/registry/v2/blobs/sha256/cc/cccc/data", nil); !isPathNotFound(err) {
+		t.Fatalf("expected path not found error for a missing blob, got %v", err)
+	}
+}
+
+// uploaderDriver keeps multipart uploads alongside the files of a driver.
+type uploaderDriver struct {
+	storagedriver.StorageDriver
+	uploads []storagedriver.MultipartUpload
+}
+
+func (d *uploaderDriver) MultipartUploads(ctx context.Context, path string) ([]storagedriver.MultipartUpload, error) {
+	return d.uploads, nil
+}
+
+func (d *uploaderDriver) AbortMultipartUpload(ctx context.Context, upload storagedriver.MultipartUpload) error {
+	d.uploads = nil
+	return nil
+}
+
+func TestMultipartUploads(t *testing.T) {
+	hot := &uploaderDriver{
+		StorageDriver: inmemory.New(),
+		uploads:       []storagedriver.MultipartUpload{{Path: "/docker/registry/v2/repositories/foo/_uploads/id/data", ID: "id"}},
+	}
+	d := New(DriverParameters{Hot: hot, Cold: inmemory.New()})
+	ctx := context.Background()
+
+	uploads, err := d.MultipartUploads(ctx, "/docker/registry/v2/repositories")
+	if err != nil || len(uploads) != 1 {
+		t.Fatalf("unexpected multipart uploads: %v, %v", uploads, err)
+	}
+	if err := d.AbortMultipartUpload(ctx, uploads[0]); err != nil || len(hot.uploads) != 0 {
+		t.Fatalf("expected the upload of the hot tier to be aborted: %v", err)
+	}
+
+	_, _, d = newTestDriver(time.Hour)
+	if uploads, err := d.MultipartUploads(ctx, "/docker/registry/v2/repositories"); err != nil || len(uploads) != 0 {
+		t.Fatalf("unexpected multipart uploads of a hot tier keeping none: %v, %v", uploads, err)
+	}
+}
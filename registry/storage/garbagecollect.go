@@ -3,22 +3,104 @@ package storage
 import (
 	"context"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/distribution"
-	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
+	"golang.org/x/time/rate"
 )
 
-func emit(format string, a ...interface{}) {
-	fmt.Printf(format+"\n", a...)
-}
-
 // GCOpts contains options for garbage collector
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
+	// RemoveUnreferencedPlatforms removes untagged indexes and the platform
+	// manifests which no kept index references, but keeps other untagged
+	// manifests.
+	RemoveUnreferencedPlatforms bool
+	// Online collects garbage while the registry serves requests, which
+	// requires it to track blob references. Manifests and blobs referenced
+	// within the grace period, or since the collection started, are kept, as
+	// a push in flight may rely on them.
+	Online      bool
+	GracePeriod time.Duration
+	// Metadata is the metadata index of the registry, if any. Once built,
+	// the blobs to keep are marked from the index instead of by reading
+	// every manifest, unless untagged manifests are removed. Manifests
+	// removed by the collection are removed from the index.
+	Metadata *MetadataIndex
+	// Report, if set, is filled with the manifests and blobs deleted, or
+	// eligible for deletion in a dry run.
+	Report *GCReport
+	// Quiet disables printing the progress of the collection.
+	Quiet bool
+	// Concurrency is the number of repositories marked, and of blobs
+	// deleted, at once. Defaults to 1.
+	Concurrency int
+	// Rate limits the manifests read and the manifests, links and blobs
+	// deleted per second, across workers, if positive, so as to respect the
+	// request quotas of the storage.
+	Rate float64
+}
+
+// emit prints the progress of the collection, unless it is quiet.
+func (opts GCOpts) emit(format string, a ...interface{}) {
+	if opts.Quiet {
+		return
+	}
+	fmt.Printf(format+"\n", a...)
+}
+
+// GCReport lists the manifests and blobs deleted by a garbage collection,
+// or eligible for deletion in a dry run.
+type GCReport struct {
+	DryRun    bool               `json:"dryRun"`
+	Manifests []GCReportManifest `json:"manifests"`
+	Blobs     []GCReportBlob     `json:"blobs"`
+	// ReclaimableBytes is the total size of the blobs.
+	ReclaimableBytes int64 `json:"reclaimableBytes"`
+}
+
+// GCReportManifest is a manifest deleted from a repository.
+type GCReportManifest struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+}
+
+// GCReportBlob is a deleted blob, along with the repositories which linked
+// it, such as through an upload or a deleted manifest.
+type GCReportBlob struct {
+	Digest       digest.Digest `json:"digest"`
+	Size         int64         `json:"size"`
+	Repositories []string      `json:"repositories,omitempty"`
+}
+
+// recentBlobs returns whether blobs were referenced after a cutoff time,
+// which is the start of an online garbage collection minus its grace
+// period. Blobs are never recent when the collection is offline.
+type recentBlobs struct {
+	references *blobReferences
+	cutoff     time.Time
+}
+
+func (rb *recentBlobs) has(ctx context.Context, dgst digest.Digest) (bool, error) {
+	if rb.references == nil {
+		return false, nil
+	}
+
+	referencedAt, err := rb.references.referencedAt(ctx, dgst)
+	if err != nil {
+		return false, fmt.Errorf("failed to retrieve reference time of %s: %v", dgst, err)
+	}
+	return referencedAt.After(rb.cutoff), nil
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -36,112 +118,115 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
 	}
 
+	recent := &recentBlobs{}
+	if opts.Online {
+		recent.references = &blobReferences{driver: storageDriver}
+		recent.cutoff = time.Now().Add(-opts.GracePeriod)
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.Rate), 1)
+	}
+
 	// mark
 	markSet := make(map[digest.Digest]struct{})
 	manifestArr := make([]ManifestDel, 0)
-	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
-		emit(repoName)
-
-		var err error
-		named, err := reference.WithName(repoName)
-		if err != nil {
-			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
-		}
-		repository, err := registry.Repository(ctx, named)
-		if err != nil {
-			return fmt.Errorf("failed to construct repository: %v", err)
-		}
-
-		manifestService, err := repository.Manifests(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to construct manifest service: %v", err)
-		}
-
-		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
-		if !ok {
-			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
-		}
+	var mu sync.Mutex
+	var err error
+	if opts.Metadata.ready() && !opts.RemoveUntagged && !opts.RemoveUnreferencedPlatforms {
+		opts.emit("marking blobs from the metadata index")
+		err = opts.Metadata.ReferencedBlobs(func(dgst digest.Digest) error {
+			markSet[dgst] = struct{}{}
+			return nil
+		})
+	} else {
+		err = parallelize(ctx, opts.Concurrency, func(send func(string) error) error {
+			return repositoryEnumerator.Enumerate(ctx, send)
+		}, func(repoName string) error {
+			opts.emit(repoName)
 
-		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
-			manifest, err := manifestService.Get(ctx, dgst)
+			repoMarkSet := make(map[digest.Digest]struct{})
+			deletions, err := markRepository(ctx, registry, repoName, opts, recent, limiter, repoMarkSet)
 			if err != nil {
-				return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+				return err
 			}
 
-			if opts.RemoveUntagged {
-				// fetch all tags where this manifest is the latest one
-				tags, err := repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
-				if err != nil {
-					return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
-				}
-				if len(tags) == 0 {
-					emit("manifest eligible for deletion: %s", dgst)
-					// fetch all tags from repository
-					// all of these tags could contain manifest in history
-					// which means that we need check (and delete) those references when deleting manifest
-					allTags, err := repository.Tags(ctx).All(ctx)
-					if err != nil {
-						return fmt.Errorf("failed to retrieve tags %v", err)
-					}
-
-					manifestDel := ManifestDel{
-						Name:   repoName,
-						Digest: dgst,
-						Tags:   allTags,
-						Layers: []digest.Digest{},
-					}
-
-					for _, ref := range manifest.References() {
-						if ref.MediaType == schema2.MediaTypeLayer ||
-							ref.MediaType == schema2.MediaTypeImageConfig {
-							manifestDel.Layers = append(manifestDel.Layers, ref.Digest)
-						}
-					}
-
-					manifestArr = append(manifestArr, manifestDel)
-
-					return nil
-				}
+			mu.Lock()
+			defer mu.Unlock()
+			for dgst := range repoMarkSet {
+				markSet[dgst] = struct{}{}
 			}
-			// Mark the manifest's blob
-			emit("%s: marking manifest %s ", repoName, dgst)
-			markSet[dgst] = struct{}{}
-
-			descriptors := manifest.References()
-			for _, descriptor := range descriptors {
-				markSet[descriptor.Digest] = struct{}{}
-				emit("%s: marking blob %s", repoName, descriptor.Digest)
-			}
-
+			manifestArr = append(manifestArr, deletions...)
 			return nil
 		})
-
-		// In certain situations such as unfinished uploads, deleting all
-		// tags in S3 or removing the _manifests folder manually, this
-		// error may be of type PathNotFound.
-		//
-		// In these cases we can continue marking other manifests safely.
-		if _, ok := err.(driver.PathNotFoundError); ok {
-			return nil
-		}
-
-		return err
-	})
+	}
 	if err != nil {
 		return fmt.Errorf("failed to mark: %v", err)
 	}
 
+	// the links are looked up before the sweep removes them
+	var owners map[digest.Digest]map[string]struct{}
+	if opts.Report != nil {
+		opts.Report.DryRun = opts.DryRun
+		owners, err = blobOwners(ctx, storageDriver, markSet, manifestArr)
+		if err != nil {
+			return fmt.Errorf("failed to look up the repositories of blobs: %v", err)
+		}
+		if opts.DryRun {
+			for _, obj := range manifestArr {
+				opts.Report.Manifests = append(opts.Report.Manifests, GCReportManifest{Repository: obj.Name, Digest: obj.Digest})
+			}
+		}
+	}
+
 	// sweep
 	vacuum := NewVacuum(ctx, storageDriver)
 	if !opts.DryRun {
 		for _, obj := range manifestArr {
+			// a manifest pushed or tagged again since it was marked is
+			// kept along with its blobs
+			isRecent, err := recent.has(ctx, obj.Digest)
+			if err != nil {
+				return err
+			}
+			if isRecent {
+				opts.emit("%s: keeping manifest %s referenced since marking", obj.Name, obj.Digest)
+				markSet[obj.Digest] = struct{}{}
+				for _, layerDgst := range obj.Layers {
+					markSet[layerDgst] = struct{}{}
+				}
+				continue
+			}
+
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
 			err = vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags)
 			if err != nil {
 				return fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
 			}
+			if opts.Report != nil {
+				opts.Report.Manifests = append(opts.Report.Manifests, GCReportManifest{Repository: obj.Name, Digest: obj.Digest})
+			}
+			if opts.Metadata != nil {
+				if err := opts.Metadata.DeleteManifest(obj.Name, obj.Digest); err != nil {
+					return fmt.Errorf("failed to remove manifest %s from the metadata index: %v", obj.Digest, err)
+				}
+			}
 			for _, layerDgst := range obj.Layers {
 				if _, ok := markSet[layerDgst]; !ok {
-					err := vacuum.RemoveLayerLink(obj.Name, layerDgst)
+					isRecent, err := recent.has(ctx, layerDgst)
+					if err != nil {
+						return err
+					}
+					if isRecent {
+						continue
+					}
+					if err := limiter.Wait(ctx); err != nil {
+						return err
+					}
+					err = vacuum.RemoveLayerLink(obj.Name, layerDgst)
 					if err != nil {
 						return fmt.Errorf("failed to delete layer link %s for manifest %s: %v", layerDgst, obj.Name, err)
 					}
@@ -161,17 +246,383 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 	if err != nil {
 		return fmt.Errorf("error enumerating blobs: %v", err)
 	}
-	emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(deleteSet), len(manifestArr))
-	for dgst := range deleteSet {
-		emit("blob eligible for deletion: %s", dgst)
+	opts.emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(deleteSet), len(manifestArr))
+	err = parallelize(ctx, opts.Concurrency, func(send func(string) error) error {
+		for dgst := range deleteSet {
+			if err := send(string(dgst)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(item string) error {
+		dgst := digest.Digest(item)
+		opts.emit("blob eligible for deletion: %s", dgst)
+		if !opts.DryRun {
+			// the reference time is checked last, right before the blob
+			// is removed, to narrow the window in which a push may
+			// reference it
+			isRecent, err := recent.has(ctx, dgst)
+			if err != nil {
+				return err
+			}
+			if isRecent {
+				opts.emit("keeping blob %s referenced within the grace period", dgst)
+				return nil
+			}
+		}
+
+		if opts.Report != nil {
+			blob, err := reportedBlob(ctx, registry, dgst, owners[dgst])
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			opts.Report.Blobs = append(opts.Report.Blobs, blob)
+			opts.Report.ReclaimableBytes += blob.Size
+			mu.Unlock()
+		}
 		if opts.DryRun {
-			continue
+			return nil
 		}
-		err = vacuum.RemoveBlob(string(dgst))
-		if err != nil {
+
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		if err := vacuum.RemoveBlob(string(dgst)); err != nil {
 			return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
 		}
+		return nil
+	})
+	if opts.Report != nil {
+		sort.Slice(opts.Report.Blobs, func(i, j int) bool {
+			return opts.Report.Blobs[i].Digest < opts.Report.Blobs[j].Digest
+		})
+	}
+
+	return err
+}
+
+// reportedBlob returns the blob as listed in a report, along with its size.
+func reportedBlob(ctx context.Context, registry distribution.Namespace, dgst digest.Digest, owners map[string]struct{}) (GCReportBlob, error) {
+	desc, err := registry.BlobStatter().Stat(ctx, dgst)
+	if err != nil {
+		return GCReportBlob{}, fmt.Errorf("failed to retrieve size of blob %s: %v", dgst, err)
+	}
+	blob := GCReportBlob{Digest: dgst, Size: desc.Size}
+	for name := range owners {
+		blob.Repositories = append(blob.Repositories, name)
+	}
+	sort.Strings(blob.Repositories)
+	return blob, nil
+}
+
+// parallelize calls fn from up to n goroutines with each item sent by
+// produce, which is stopped once fn fails. It returns the first error.
+func parallelize(ctx context.Context, n int, produce func(send func(string) error) error, fn func(string) error) error {
+	if n < 1 {
+		n = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	items := make(chan string)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := fn(item); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
 	}
 
+	err := produce(func(item string) error {
+		select {
+		case items <- item:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(items)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
 	return err
 }
+
+// blobOwners returns the repositories linking each blob which is not
+// marked, either as a layer or as a manifest to delete. The layer links are
+// walked directly, as repositories without manifests are not enumerated.
+func blobOwners(ctx context.Context, storageDriver driver.StorageDriver, markSet map[digest.Digest]struct{}, manifestArr []ManifestDel) (map[digest.Digest]map[string]struct{}, error) {
+	owners := make(map[digest.Digest]map[string]struct{})
+	own := func(dgst digest.Digest, name string) {
+		if _, ok := markSet[dgst]; ok {
+			return
+		}
+		if owners[dgst] == nil {
+			owners[dgst] = make(map[string]struct{})
+		}
+		owners[dgst][name] = struct{}{}
+	}
+
+	for _, obj := range manifestArr {
+		own(obj.Digest, obj.Name)
+	}
+
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+	err = storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			if _, dir := path.Split(fileInfo.Path()); dir == "_manifests" || dir == "_uploads" {
+				return driver.ErrSkipDir
+			}
+			return nil
+		}
+		// <name>/_layers/<algorithm>/<hex>/link
+		rel := strings.TrimPrefix(fileInfo.Path(), root+"/")
+		name, link, ok := strings.Cut(rel, "/_layers/")
+		if !ok {
+			return nil
+		}
+		parts := strings.Split(link, "/")
+		if len(parts) != 3 || parts[2] != "link" {
+			return nil
+		}
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(parts[0]), parts[1])
+		if dgst.Validate() == nil {
+			own(dgst, name)
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	return owners, err
+}
+
+// markRepository marks the manifests of a repository which are kept and the
+// blobs they reference, and returns the manifests to delete.
+func markRepository(ctx context.Context, registry distribution.Namespace, repoName string, opts GCOpts, recent *recentBlobs, limiter *rate.Limiter, markSet map[digest.Digest]struct{}) ([]ManifestDel, error) {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct repository: %v", err)
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	manifests := make(map[digest.Digest]distribution.Manifest)
+	var digests []digest.Digest
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+		}
+		manifests[dgst] = manifest
+		digests = append(digests, dgst)
+		return nil
+	})
+
+	// In certain situations such as unfinished uploads, deleting all
+	// tags in S3 or removing the _manifests folder manually, this
+	// error may be of type PathNotFound.
+	//
+	// In these cases we can continue marking other manifests safely.
+	if _, ok := err.(driver.PathNotFoundError); !ok && err != nil {
+		return nil, err
+	}
+
+	// manifests pushed within the grace period may not be tagged yet
+	var pinned []digest.Digest
+	for _, dgst := range digests {
+		isRecent, err := recent.has(ctx, dgst)
+		if err != nil {
+			return nil, err
+		}
+		if isRecent {
+			pinned = append(pinned, dgst)
+		}
+	}
+
+	kept, err := keptManifests(ctx, repository, manifests, pinned, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var deletions []ManifestDel
+	var allTags []string
+	for _, dgst := range digests {
+		manifest := manifests[dgst]
+		if _, ok := kept[dgst]; !ok {
+			opts.emit("manifest eligible for deletion: %s", dgst)
+			// fetch all tags from repository
+			// all of these tags could contain manifest in history
+			// which means that we need check (and delete) those references when deleting manifest
+			if allTags == nil {
+				allTags, err = repository.Tags(ctx).All(ctx)
+				if _, ok := err.(distribution.ErrRepositoryUnknown); !ok && err != nil {
+					return nil, fmt.Errorf("failed to retrieve tags %v", err)
+				}
+			}
+
+			manifestDel := ManifestDel{
+				Name:   repoName,
+				Digest: dgst,
+				Tags:   allTags,
+				Layers: []digest.Digest{},
+			}
+
+			// the links of the blobs are removed, child manifests are
+			// deleted on their own
+			for _, ref := range manifest.References() {
+				if _, ok := manifests[ref.Digest]; !ok {
+					manifestDel.Layers = append(manifestDel.Layers, ref.Digest)
+				}
+			}
+
+			deletions = append(deletions, manifestDel)
+			continue
+		}
+
+		// Mark the manifest's blob
+		opts.emit("%s: marking manifest %s ", repoName, dgst)
+		markSet[dgst] = struct{}{}
+
+		descriptors := manifest.References()
+		for _, descriptor := range descriptors {
+			markSet[descriptor.Digest] = struct{}{}
+			opts.emit("%s: marking blob %s", repoName, descriptor.Digest)
+		}
+	}
+	return deletions, nil
+}
+
+// keptManifests returns the manifests of a repository which garbage
+// collection keeps, which include the pinned ones. Every manifest referenced
+// by a kept index is kept, so that removing untagged manifests never breaks a
+// multi-platform image.
+func keptManifests(ctx context.Context, repository distribution.Repository, manifests map[digest.Digest]distribution.Manifest, pinned []digest.Digest, opts GCOpts) (map[digest.Digest]struct{}, error) {
+	roots := append([]digest.Digest(nil), pinned...)
+	if !opts.RemoveUntagged && !opts.RemoveUnreferencedPlatforms {
+		for dgst := range manifests {
+			roots = append(roots, dgst)
+		}
+		return referencedManifests(manifests, roots), nil
+	}
+
+	tagged, err := taggedManifests(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.RemoveUntagged {
+		for dgst := range manifests {
+			if _, ok := tagged[dgst]; ok {
+				roots = append(roots, dgst)
+			}
+		}
+		return referencedManifests(manifests, roots), nil
+	}
+
+	// untagged indexes and the manifests they reference are removed,
+	// unless a kept index references them too
+	var untaggedIndexes []digest.Digest
+	for dgst, manifest := range manifests {
+		if _, ok := tagged[dgst]; !ok && isIndex(manifest) {
+			untaggedIndexes = append(untaggedIndexes, dgst)
+		}
+	}
+	unreferenced := referencedManifests(manifests, untaggedIndexes)
+	for dgst := range manifests {
+		_, isTagged := tagged[dgst]
+		if _, ok := unreferenced[dgst]; isTagged || !ok {
+			roots = append(roots, dgst)
+		}
+	}
+	return referencedManifests(manifests, roots), nil
+}
+
+// referencedManifests returns the roots and the manifests they reference,
+// directly or through other manifests.
+func referencedManifests(manifests map[digest.Digest]distribution.Manifest, roots []digest.Digest) map[digest.Digest]struct{} {
+	referenced := make(map[digest.Digest]struct{}, len(roots))
+	for len(roots) > 0 {
+		dgst := roots[len(roots)-1]
+		roots = roots[:len(roots)-1]
+		if _, ok := referenced[dgst]; ok {
+			continue
+		}
+		referenced[dgst] = struct{}{}
+
+		for _, ref := range manifests[dgst].References() {
+			if _, ok := manifests[ref.Digest]; ok {
+				roots = append(roots, ref.Digest)
+			}
+		}
+	}
+	return referenced
+}
+
+// taggedManifests returns the manifests the tags of a repository point to.
+func taggedManifests(ctx context.Context, repository distribution.Repository) (map[digest.Digest]struct{}, error) {
+	tagService := repository.Tags(ctx)
+	tags, err := tagService.All(ctx)
+	if _, ok := err.(distribution.ErrRepositoryUnknown); !ok && err != nil {
+		return nil, fmt.Errorf("failed to retrieve tags %v", err)
+	}
+
+	tagged := make(map[digest.Digest]struct{}, len(tags))
+	for _, tag := range tags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, fmt.Errorf("failed to retrieve tag %s: %v", tag, err)
+		}
+		tagged[desc.Digest] = struct{}{}
+	}
+	return tagged, nil
+}
+
+// isIndex returns whether the manifest is a manifest list or an image index,
+// referencing the manifests of several platforms.
+func isIndex(manifest distribution.Manifest) bool {
+	switch manifest.(type) {
+	case *manifestlist.DeserializedManifestList, *ocischema.DeserializedImageIndex:
+		return true
+	}
+	return false
+}
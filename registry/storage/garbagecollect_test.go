@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"path"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/context"
@@ -501,3 +505,383 @@ func TestOrphanBlobDeleted(t *testing.T) {
 		}
 	}
 }
+
+func uploadManifestList(t *testing.T, registry distribution.Namespace, repository distribution.Repository, manifestDigests ...digest.Digest) digest.Digest {
+	ctx := context.Background()
+	manifestList, err := testutil.MakeManifestList(registry.BlobStatter(), manifestDigests)
+	if err != nil {
+		t.Fatalf("Failed to make manifest list: %v", err)
+	}
+
+	dgst, err := makeManifestService(t, repository).Put(ctx, manifestList)
+	if err != nil {
+		t.Fatalf("Failed to add manifest list: %v", err)
+	}
+	return dgst
+}
+
+func checkManifests(t *testing.T, manifestService distribution.ManifestService, kept map[digest.Digest]bool) {
+	manifests := allManifests(t, manifestService)
+	for dgst, expected := range kept {
+		if _, ok := manifests[dgst]; ok != expected {
+			t.Errorf("unexpected presence of manifest %s: %t != %t", dgst, ok, expected)
+		}
+	}
+}
+
+func TestDeleteUntaggedKeepsIndexChildren(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "multiplatform")
+	manifestService := makeManifestService(t, repo)
+
+	amd64 := uploadRandomSchema2Image(t, repo)
+	arm64 := uploadRandomSchema2Image(t, repo)
+	untagged := uploadRandomSchema2Image(t, repo)
+	list := uploadManifestList(t, registry, repo, amd64.manifestDigest, arm64.manifestDigest)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: list}); err != nil {
+		t.Fatalf("Failed to tag manifest list: %v", err)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	checkManifests(t, manifestService, map[digest.Digest]bool{
+		list:                    true,
+		amd64.manifestDigest:    true,
+		arm64.manifestDigest:    true,
+		untagged.manifestDigest: false,
+	})
+
+	blobs := allBlobs(t, registry)
+	for _, im := range []image{amd64, arm64} {
+		for dgst := range im.layers {
+			if _, ok := blobs[dgst]; !ok {
+				t.Errorf("Layer of a platform manifest is missing: %v", dgst)
+			}
+		}
+	}
+	for dgst := range untagged.layers {
+		if _, ok := blobs[dgst]; ok {
+			t.Errorf("Layer of an untagged manifest is present: %v", dgst)
+		}
+	}
+}
+
+func TestDeleteUnreferencedPlatforms(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "multiplatform")
+	manifestService := makeManifestService(t, repo)
+
+	amd64 := uploadRandomSchema2Image(t, repo)
+	arm64 := uploadRandomSchema2Image(t, repo)
+	ppc64le := uploadRandomSchema2Image(t, repo)
+	standalone := uploadRandomSchema2Image(t, repo)
+	oldList := uploadManifestList(t, registry, repo, amd64.manifestDigest, arm64.manifestDigest)
+	list := uploadManifestList(t, registry, repo, arm64.manifestDigest, ppc64le.manifestDigest)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: list}); err != nil {
+		t.Fatalf("Failed to tag manifest list: %v", err)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:                      false,
+		RemoveUnreferencedPlatforms: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	checkManifests(t, manifestService, map[digest.Digest]bool{
+		list:                      true,
+		oldList:                   false,
+		amd64.manifestDigest:      false,
+		arm64.manifestDigest:      true,
+		ppc64le.manifestDigest:    true,
+		standalone.manifestDigest: true,
+	})
+
+	blobs := allBlobs(t, registry)
+	for dgst := range amd64.layers {
+		if _, ok := blobs[dgst]; ok {
+			t.Errorf("Layer of an unreferenced platform manifest is present: %v", dgst)
+		}
+	}
+	for dgst := range standalone.layers {
+		if _, ok := blobs[dgst]; !ok {
+			t.Errorf("Layer of an untagged manifest is missing: %v", dgst)
+		}
+	}
+}
+
+func TestOnlineGCKeepsRecentlyReferenced(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, TrackBlobReferences)
+	repo := makeRepository(t, registry, "online")
+	manifestService := makeManifestService(t, repo)
+
+	orphans, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	if err = testutil.UploadBlobs(repo, orphans); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+	tagged := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: tagged.manifestDigest}); err != nil {
+		t.Fatalf("Failed to tag manifest: %v", err)
+	}
+	untagged := uploadRandomSchema2Image(t, repo)
+
+	// everything was referenced within the grace period
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		RemoveUntagged: true,
+		Online:         true,
+		GracePeriod:    time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	checkManifests(t, manifestService, map[digest.Digest]bool{
+		tagged.manifestDigest:   true,
+		untagged.manifestDigest: true,
+	})
+	blobs := allBlobs(t, registry)
+	for _, layers := range []map[digest.Digest]io.ReadSeeker{orphans, untagged.layers} {
+		for dgst := range layers {
+			if _, ok := blobs[dgst]; !ok {
+				t.Errorf("Recently referenced blob is missing: %v", dgst)
+			}
+		}
+	}
+
+	// once the grace period elapsed, garbage is removed
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		RemoveUntagged: true,
+		Online:         true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	checkManifests(t, manifestService, map[digest.Digest]bool{
+		tagged.manifestDigest:   true,
+		untagged.manifestDigest: false,
+	})
+	blobs = allBlobs(t, registry)
+	for _, layers := range []map[digest.Digest]io.ReadSeeker{orphans, untagged.layers} {
+		for dgst := range layers {
+			if _, ok := blobs[dgst]; ok {
+				t.Errorf("Unreferenced blob is present: %v", dgst)
+			}
+		}
+	}
+	for dgst := range tagged.layers {
+		if _, ok := blobs[dgst]; !ok {
+			t.Errorf("Layer of a tagged manifest is missing: %v", dgst)
+		}
+	}
+}
+
+func TestManifestPutRecordsReferences(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, TrackBlobReferences)
+	repo := makeRepository(t, registry, "references")
+
+	im := uploadRandomSchema2Image(t, repo)
+	referencedAt := time.Now()
+
+	// pushing a manifest again records its blobs as referenced, although
+	// they are already linked into the repository
+	if _, err := makeManifestService(t, repo).Put(ctx, im.manifest); err != nil {
+		t.Fatalf("Failed to put manifest: %v", err)
+	}
+
+	references := &blobReferences{driver: inmemoryDriver}
+	for _, descriptor := range im.manifest.References() {
+		last, err := references.referencedAt(ctx, descriptor.Digest)
+		if err != nil {
+			t.Fatalf("Failed to retrieve reference time of %s: %v", descriptor.Digest, err)
+		}
+		if last.Before(referencedAt) {
+			t.Errorf("Reference time of %s not recorded: %s", descriptor.Digest, last)
+		}
+	}
+}
+
+func TestGCReport(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "reported")
+	tagged := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: tagged.manifestDigest}); err != nil {
+		t.Fatalf("Failed to tag manifest: %v", err)
+	}
+	untagged := uploadRandomSchema2Image(t, repo)
+
+	orphans, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+	orphanRepo := makeRepository(t, registry, "orphaned")
+	if err := testutil.UploadBlobs(orphanRepo, orphans); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+
+	expected := map[digest.Digest][]string{untagged.manifestDigest: {"reported"}}
+	for dgst := range untagged.layers {
+		expected[dgst] = []string{"reported"}
+	}
+	for dgst := range orphans {
+		expected[dgst] = []string{"orphaned"}
+	}
+	checkReport := func(report *GCReport, dryRun bool) {
+		t.Helper()
+
+		if report.DryRun != dryRun {
+			t.Errorf("unexpected dry run in report: %v", report.DryRun)
+		}
+		if len(report.Manifests) != 1 || report.Manifests[0] != (GCReportManifest{Repository: "reported", Digest: untagged.manifestDigest}) {
+			t.Errorf("unexpected manifests in report: %+v", report.Manifests)
+		}
+		var total int64
+		for _, blob := range report.Blobs {
+			owners, ok := expected[blob.Digest]
+			if !ok {
+				// the config of the untagged image is not linked
+				if _, isLayer := tagged.layers[blob.Digest]; isLayer || blob.Digest == tagged.manifestDigest {
+					t.Errorf("blob of the tagged image in report: %s", blob.Digest)
+				}
+			} else if !reflect.DeepEqual(blob.Repositories, owners) {
+				t.Errorf("unexpected repositories of blob %s: %v != %v", blob.Digest, blob.Repositories, owners)
+			}
+			if blob.Size <= 0 {
+				t.Errorf("unexpected size of blob %s: %d", blob.Digest, blob.Size)
+			}
+			total += blob.Size
+		}
+		if len(report.Blobs) < len(expected) {
+			t.Errorf("missing blobs in report: %+v", report.Blobs)
+		}
+		if report.ReclaimableBytes != total {
+			t.Errorf("unexpected reclaimable bytes: %d != %d", report.ReclaimableBytes, total)
+		}
+	}
+
+	dryRunReport := &GCReport{}
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		DryRun:         true,
+		RemoveUntagged: true,
+		Report:         dryRunReport,
+		Quiet:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	checkReport(dryRunReport, true)
+	if _, ok := allBlobs(t, registry)[untagged.manifestDigest]; !ok {
+		t.Fatal("dry run deleted a blob")
+	}
+
+	report := &GCReport{}
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		RemoveUntagged: true,
+		Report:         report,
+		Quiet:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	checkReport(report, false)
+	if !reflect.DeepEqual(report.Blobs, dryRunReport.Blobs) {
+		t.Fatalf("deleted blobs differ from the dry run: %+v != %+v", report.Blobs, dryRunReport.Blobs)
+	}
+}
+
+func TestConcurrentGC(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	registry := createRegistry(t, inmemoryDriver)
+
+	var kept, deleted []image
+	for i := 0; i < 8; i++ {
+		repo := makeRepository(t, registry, fmt.Sprintf("concurrent/repo%d", i))
+		tagged := uploadRandomSchema2Image(t, repo)
+		if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: tagged.manifestDigest}); err != nil {
+			t.Fatalf("Failed to tag manifest: %v", err)
+		}
+		kept = append(kept, tagged)
+		deleted = append(deleted, uploadRandomSchema2Image(t, repo))
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		RemoveUntagged: true,
+		Concurrency:    4,
+		Rate:           1000,
+		Quiet:          true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	for _, im := range kept {
+		if _, ok := blobs[im.manifestDigest]; !ok {
+			t.Errorf("Tagged manifest is missing: %v", im.manifestDigest)
+		}
+		for dgst := range im.layers {
+			if _, ok := blobs[dgst]; !ok {
+				t.Errorf("Layer of a tagged manifest is missing: %v", dgst)
+			}
+		}
+	}
+	for _, im := range deleted {
+		if _, ok := blobs[im.manifestDigest]; ok {
+			t.Errorf("Untagged manifest is present: %v", im.manifestDigest)
+		}
+		for dgst := range im.layers {
+			if _, ok := blobs[dgst]; ok {
+				t.Errorf("Layer of an untagged manifest is present: %v", dgst)
+			}
+		}
+	}
+}
+
+func TestParallelizeStopsOnError(t *testing.T) {
+	errFailed := errors.New("failed")
+	var produced int
+	err := parallelize(context.Background(), 2, func(send func(string) error) error {
+		for i := 0; i < 100; i++ {
+			if err := send(fmt.Sprint(i)); err != nil {
+				return err
+			}
+			produced++
+		}
+		return nil
+	}, func(item string) error {
+		if item == "3" {
+			return errFailed
+		}
+		return nil
+	})
+	if err != errFailed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if produced == 100 {
+		t.Fatal("expected the production of items to stop")
+	}
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+// Types of the files of a layer. Whiteouts and opaque directories are only
+// listed by layers, and are applied when the layers of an image are merged.
+const (
+	LayerFileTypeFile     = "file"
+	LayerFileTypeDir      = "dir"
+	LayerFileTypeSymlink  = "symlink"
+	LayerFileTypeHardlink = "hardlink"
+	LayerFileTypeOther    = "other"
+	LayerFileTypeWhiteout = "whiteout"
+	LayerFileTypeOpaque   = "opaque"
+)
+
+// Prefixes of the names of the whiteout files of a layer.
+const (
+	whiteoutPrefix     = ".wh."
+	whiteoutMetaPrefix = ".wh..wh."
+	whiteoutOpaqueDir  = ".wh..wh..opq"
+)
+
+// LayerFile is a file of a layer.
+type LayerFile struct {
+	// Path is the absolute path of the file. For a whiteout, it is the path
+	// of the file deleted, and for an opaque directory, the path of the
+	// directory whose contents in lower layers are hidden.
+	Path string `json:"path"`
+	// Type is the type of the file.
+	Type string `json:"type"`
+	// Size is the size in bytes of the contents of a regular file.
+	Size int64 `json:"size"`
+	// Mode is the permission and mode bits of the file.
+	Mode int64 `json:"mode"`
+	// Linkname is the target of a link.
+	Linkname string `json:"linkname,omitempty"`
+	// Layer is the digest of the layer containing the file.
+	Layer digest.Digest `json:"layer"`
+}
+
+// ScanLayerFiles returns the files of the layer read from r, a tar archive
+// either uncompressed or compressed with gzip or zstd.
+func ScanLayerFiles(layer digest.Digest, r io.Reader) ([]LayerFile, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var archive io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		archive = gz
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		archive = zr
+	}
+
+	var files []LayerFile
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+		file := LayerFile{
+			Path:  name,
+			Size:  hdr.Size,
+			Mode:  hdr.Mode,
+			Layer: layer,
+		}
+		switch {
+		case base == whiteoutOpaqueDir:
+			file = LayerFile{Path: dir, Type: LayerFileTypeOpaque, Layer: layer}
+		case strings.HasPrefix(base, whiteoutMetaPrefix):
+			// metadata of aufs, such as hard link directories
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			file = LayerFile{Path: path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), Type: LayerFileTypeWhiteout, Layer: layer}
+		default:
+			switch hdr.Typeflag {
+			case tar.TypeReg, tar.TypeRegA:
+				file.Type = LayerFileTypeFile
+			case tar.TypeDir:
+				file.Type = LayerFileTypeDir
+			case tar.TypeSymlink:
+				file.Type = LayerFileTypeSymlink
+				file.Linkname = hdr.Linkname
+			case tar.TypeLink:
+				file.Type = LayerFileTypeHardlink
+				file.Linkname = path.Clean("/" + hdr.Linkname)
+			default:
+				file.Type = LayerFileTypeOther
+			}
+			if file.Type != LayerFileTypeFile {
+				file.Size = 0
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// MergeLayerFiles returns the files of an image from the files of its
+// layers, in order from the lowest, as seen by a container running it. A file
+// replaces the file at the same path in lower layers, and whiteouts delete the
+// files of lower layers. The files are sorted by path.
+func MergeLayerFiles(layers ...[]LayerFile) []LayerFile {
+	files := make(map[string]LayerFile)
+	for _, layer := range layers {
+		// paths deleted with their children, and directories whose
+		// children are hidden
+		deleted := make(map[string]bool)
+		hidden := make(map[string]bool)
+		for _, file := range layer {
+			switch file.Type {
+			case LayerFileTypeWhiteout:
+				deleted[file.Path] = true
+			case LayerFileTypeOpaque:
+				hidden[file.Path] = true
+			case LayerFileTypeDir:
+				// merged with the directory of lower layers
+			default:
+				// a directory replaced by another type of file
+				if lower, ok := files[file.Path]; ok && lower.Type == LayerFileTypeDir {
+					hidden[file.Path] = true
+				}
+			}
+		}
+
+		if len(deleted) > 0 || len(hidden) > 0 {
+			for p := range files {
+				if isDeleted(p, deleted, hidden) {
+					delete(files, p)
+				}
+			}
+		}
+
+		for _, file := range layer {
+			if file.Type == LayerFileTypeWhiteout || file.Type == LayerFileTypeOpaque {
+				continue
+			}
+			files[file.Path] = file
+		}
+	}
+
+	merged := make([]LayerFile, 0, len(files))
+	for _, file := range files {
+		merged = append(merged, file)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Path < merged[j].Path
+	})
+	return merged
+}
+
+// isDeleted returns whether the path is deleted, or has a parent deleted or
+// hidden.
+func isDeleted(p string, deleted, hidden map[string]bool) bool {
+	if deleted[p] {
+		return true
+	}
+	for p != "/" {
+		p = path.Dir(p)
+		if deleted[p] || hidden[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// LayerFileStore caches the files of layers, as scanning a layer reads all
+// of it. The files are stored next to the data of the layer.
+type LayerFileStore struct {
+	driver driver.StorageDriver
+}
+
+// NewLayerFileStore returns the store of the files of layers, stored with
+// the given driver.
+func NewLayerFileStore(storageDriver driver.StorageDriver) *LayerFileStore {
+	return &LayerFileStore{
+		driver: storageDriver,
+	}
+}
+
+// Put stores the files of the layer.
+func (ls *LayerFileStore) Put(ctx context.Context, layer digest.Digest, files []LayerFile) error {
+	filesPath, err := pathFor(blobFilesPathSpec{digest: layer})
+	if err != nil {
+		return err
+	}
+
+	p, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	return ls.driver.PutContent(ctx, filesPath, p)
+}
+
+// Get returns the files of the layer, and false if they are not stored.
+func (ls *LayerFileStore) Get(ctx context.Context, layer digest.Digest) ([]LayerFile, bool, error) {
+	filesPath, err := pathFor(blobFilesPathSpec{digest: layer})
+	if err != nil {
+		return nil, false, err
+	}
+
+	p, err := ls.driver.GetContent(ctx, filesPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	var files []LayerFile
+	if err := json.Unmarshal(p, &files); err != nil {
+		return nil, false, err
+	}
+	return files, true, nil
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// buildLayer returns a gzipped tar archive of the headers, with regular files
+// filled with their size in bytes.
+func buildLayer(t *testing.T, headers ...tar.Header) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range headers {
+		hdr := hdr
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("unexpected error writing header: %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(bytes.Repeat([]byte("a"), int(hdr.Size))); err != nil {
+				t.Fatalf("unexpected error writing file: %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unexpected error closing tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("unexpected error closing gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestLayerFiles(t *testing.T) {
+	base := digest.FromString("base")
+	files, err := ScanLayerFiles(base, bytes.NewReader(buildLayer(t,
+		tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		tar.Header{Name: "./etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 10},
+		tar.Header{Name: "./etc/shadow", Typeflag: tar.TypeReg, Mode: 0600, Size: 5},
+		tar.Header{Name: "./bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		tar.Header{Name: "./bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+		tar.Header{Name: "./opt/app/", Typeflag: tar.TypeDir, Mode: 0755},
+		tar.Header{Name: "./opt/app/old", Typeflag: tar.TypeReg, Mode: 0644, Size: 1},
+		tar.Header{Name: "./var/", Typeflag: tar.TypeDir, Mode: 0755},
+		tar.Header{Name: "./var/cache", Typeflag: tar.TypeReg, Mode: 0644, Size: 2},
+	)))
+	if err != nil {
+		t.Fatalf("unexpected error scanning layer: %v", err)
+	}
+	if len(files) != 9 {
+		t.Fatalf("unexpected files scanned: %v", files)
+	}
+	if files[4] != (LayerFile{Path: "/bin/sh", Type: LayerFileTypeSymlink, Linkname: "busybox", Layer: base}) {
+		t.Fatalf("unexpected symlink scanned: %v", files[4])
+	}
+
+	top := digest.FromString("top")
+	topFiles, err := ScanLayerFiles(top, bytes.NewReader(buildLayer(t,
+		tar.Header{Name: "etc/.wh.shadow", Typeflag: tar.TypeReg},
+		tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+		tar.Header{Name: "opt/app/.wh..wh..opq", Typeflag: tar.TypeReg},
+		tar.Header{Name: "opt/app/new", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+		tar.Header{Name: "var", Typeflag: tar.TypeSymlink, Linkname: "/tmp"},
+		tar.Header{Name: ".wh..wh.plnk/", Typeflag: tar.TypeDir},
+	)))
+	if err != nil {
+		t.Fatalf("unexpected error scanning layer: %v", err)
+	}
+
+	store := NewLayerFileStore(inmemory.New())
+	if _, ok, err := store.Get(context.Background(), top); err != nil || ok {
+		t.Fatalf("unexpected result getting files not stored: %v, %v", ok, err)
+	}
+	if err := store.Put(context.Background(), top, topFiles); err != nil {
+		t.Fatalf("unexpected error storing files: %v", err)
+	}
+	stored, ok, err := store.Get(context.Background(), top)
+	if err != nil || !ok {
+		t.Fatalf("unexpected result getting files: %v, %v", ok, err)
+	}
+	if !reflect.DeepEqual(stored, topFiles) {
+		t.Fatalf("unexpected files stored: %v != %v", stored, topFiles)
+	}
+
+	expected := []LayerFile{
+		{Path: "/bin", Type: LayerFileTypeDir, Mode: 0755, Layer: base},
+		{Path: "/bin/sh", Type: LayerFileTypeSymlink, Linkname: "busybox", Layer: base},
+		{Path: "/etc", Type: LayerFileTypeDir, Mode: 0755, Layer: base},
+		{Path: "/etc/hosts", Type: LayerFileTypeFile, Mode: 0644, Size: 3, Layer: top},
+		{Path: "/etc/passwd", Type: LayerFileTypeFile, Mode: 0644, Size: 10, Layer: base},
+		{Path: "/opt/app", Type: LayerFileTypeDir, Mode: 0755, Layer: base},
+		{Path: "/opt/app/new", Type: LayerFileTypeFile, Mode: 0644, Size: 4, Layer: top},
+		{Path: "/var", Type: LayerFileTypeSymlink, Linkname: "/tmp", Layer: top},
+	}
+	if merged := MergeLayerFiles(files, stored); !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("unexpected merged files: %v != %v", merged, expected)
+	}
+}
@@ -41,6 +41,9 @@ type linkedBlobStore struct {
 
 	// linkDirectoryPathSpec locates the root directories in which one might find links
 	linkDirectoryPathSpec pathSpec
+
+	// references records when blobs are linked, if enabled.
+	references *blobReferences
 }
 
 var _ distribution.BlobStore = &linkedBlobStore{}
@@ -50,6 +53,9 @@ func (lbs *linkedBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distr
 }
 
 func (lbs *linkedBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("linkedBlobStore.Get(%q, %q)", lbs.repository.Named().Name(), dgst)
+
 	canonical, err := lbs.Stat(ctx, dgst) // access check
 	if err != nil {
 		return nil, err
@@ -59,6 +65,9 @@ func (lbs *linkedBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte
 }
 
 func (lbs *linkedBlobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("linkedBlobStore.Open(%q, %q)", lbs.repository.Named().Name(), dgst)
+
 	canonical, err := lbs.Stat(ctx, dgst) // access check
 	if err != nil {
 		return nil, err
@@ -68,6 +77,9 @@ func (lbs *linkedBlobStore) Open(ctx context.Context, dgst digest.Digest) (io.Re
 }
 
 func (lbs *linkedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("linkedBlobStore.ServeBlob(%q, %q)", lbs.repository.Named().Name(), dgst)
+
 	canonical, err := lbs.Stat(ctx, dgst) // access check
 	if err != nil {
 		return err
@@ -82,6 +94,9 @@ func (lbs *linkedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter
 }
 
 func (lbs *linkedBlobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("linkedBlobStore.Put(%q, %d bytes)", lbs.repository.Named().Name(), len(p))
+
 	dgst := digest.FromBytes(p)
 	// Place the data in the blob store first.
 	desc, err := lbs.blobStore.Put(ctx, mediaType, p)
@@ -123,8 +138,30 @@ func WithMountFrom(ref reference.Canonical) distribution.BlobCreateOption {
 	})
 }
 
+// WithDigestAlgorithm returns a BlobCreateOption which hashes the blob with
+// the algorithm as it is written, instead of the canonical algorithm, and
+// stores the blob under its digest of the algorithm.
+func WithDigestAlgorithm(alg digest.Algorithm) distribution.BlobCreateOption {
+	return optionFunc(func(v interface{}) error {
+		opts, ok := v.(*distribution.CreateOptions)
+		if !ok {
+			return fmt.Errorf("unexpected options type: %T", v)
+		}
+		if !alg.Available() {
+			return digest.ErrDigestUnsupported
+		}
+
+		opts.Algorithm = alg
+
+		return nil
+	})
+}
+
 // Writer begins a blob write session, returning a handle.
 func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("linkedBlobStore.Create(%q)", lbs.repository.Named().Name())
+
 	dcontext.GetLogger(ctx).Debug("(*linkedBlobStore).Writer")
 
 	var opts distribution.CreateOptions
@@ -168,10 +205,28 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 		return nil, err
 	}
 
-	return lbs.newBlobUpload(ctx, uuid, path, startedAt, false)
+	alg := digest.Canonical
+	if opts.Algorithm != "" && opts.Algorithm != alg {
+		alg = opts.Algorithm
+		algorithmPath, err := pathFor(uploadAlgorithmPathSpec{
+			name: lbs.repository.Named().Name(),
+			id:   uuid,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := lbs.blobStore.driver.PutContent(ctx, algorithmPath, []byte(alg)); err != nil {
+			return nil, err
+		}
+	}
+
+	return lbs.newBlobUpload(ctx, uuid, path, startedAt, alg, false)
 }
 
 func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("linkedBlobStore.Resume(%q, %q)", lbs.repository.Named().Name(), id)
+
 	dcontext.GetLogger(ctx).Debug("(*linkedBlobStore).Resume")
 
 	startedAtPath, err := pathFor(uploadStartedAtPathSpec{
@@ -197,6 +252,11 @@ func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution
 		return nil, err
 	}
 
+	alg, err := lbs.uploadAlgorithm(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
 	path, err := pathFor(uploadDataPathSpec{
 		name: lbs.repository.Named().Name(),
 		id:   id,
@@ -205,7 +265,7 @@ func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution
 		return nil, err
 	}
 
-	bw, err := lbs.newBlobUpload(ctx, id, path, startedAt, true)
+	bw, err := lbs.newBlobUpload(ctx, id, path, startedAt, alg, true)
 	if err != nil {
 		switch err := err.(type) {
 		case driver.PathNotFoundError:
@@ -219,6 +279,9 @@ func (lbs *linkedBlobStore) Resume(ctx context.Context, id string) (distribution
 }
 
 func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("linkedBlobStore.Delete(%q, %q)", lbs.repository.Named().Name(), dgst)
+
 	if !lbs.deleteEnabled {
 		return distribution.ErrUnsupported
 	}
@@ -281,6 +344,9 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 }
 
 func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Named, dgst digest.Digest, sourceStat *distribution.Descriptor) (distribution.Descriptor, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("linkedBlobStore.mount(%q, %q, %q)", sourceRepo.Name(), lbs.repository.Named().Name(), dgst)
+
 	var stat distribution.Descriptor
 	if sourceStat == nil {
 		// look up the blob info from the sourceRepo if not already provided
@@ -310,7 +376,32 @@ func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Name
 }
 
 // newBlobUpload allocates a new upload controller with the given state.
-func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string, startedAt time.Time, append bool) (distribution.BlobWriter, error) {
+// uploadAlgorithm returns the digest algorithm an upload is hashed with.
+func (lbs *linkedBlobStore) uploadAlgorithm(ctx context.Context, id string) (digest.Algorithm, error) {
+	algorithmPath, err := pathFor(uploadAlgorithmPathSpec{
+		name: lbs.repository.Named().Name(),
+		id:   id,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	p, err := lbs.blobStore.driver.GetContent(ctx, algorithmPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return digest.Canonical, nil
+		}
+		return "", err
+	}
+
+	alg := digest.Algorithm(p)
+	if !alg.Available() {
+		return "", digest.ErrDigestUnsupported
+	}
+	return alg, nil
+}
+
+func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string, startedAt time.Time, alg digest.Algorithm, append bool) (distribution.BlobWriter, error) {
 	fw, err := lbs.driver.Writer(ctx, path, append)
 	if err != nil {
 		return nil, err
@@ -321,7 +412,7 @@ func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string
 		blobStore:              lbs,
 		id:                     uuid,
 		startedAt:              startedAt,
-		digester:               digest.Canonical.Digester(),
+		digester:               alg.Digester(),
 		fileWriter:             fw,
 		driver:                 lbs.driver,
 		path:                   path,
@@ -340,6 +431,14 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 	// since we don't care about the aliases. They are generally unused except
 	// for tarsum but those versions don't care about mediatype.
 
+	// The reference is recorded before the link is made, so that an online
+	// garbage collection does not remove the blob in between.
+	if lbs.references != nil {
+		if err := lbs.references.touch(ctx, canonical.Digest); err != nil {
+			return err
+		}
+	}
+
 	// Don't make duplicate links.
 	seenDigests := make(map[digest.Digest]struct{}, len(dgsts))
 
@@ -84,7 +84,7 @@ func (ms *manifestListHandler) verifyManifest(ctx context.Context, mnfst distrib
 			return err
 		}
 
-		for _, manifestDescriptor := range mnfst.References() {
+		for _, manifestDescriptor := range uniqueReferences(mnfst.References()) {
 			exists, err := manifestService.Exists(ctx, manifestDescriptor.Digest)
 			if err != nil && err != distribution.ErrBlobUnknown {
 				errs = append(errs, err)
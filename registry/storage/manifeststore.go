@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/docker/distribution"
 	dcontext "github.com/docker/distribution/context"
@@ -48,6 +49,16 @@ type manifestStore struct {
 
 	skipDependencyVerification bool
 
+	// referrers indexes the manifests by the subject they refer to.
+	referrers *ReferrerIndex
+
+	// references records when blobs are referenced, if enabled.
+	references *blobReferences
+
+	// metadata indexes the manifests and the blobs they reference, if
+	// enabled.
+	metadata *MetadataIndex
+
 	schema1Handler        ManifestHandler
 	schema2Handler        ManifestHandler
 	manifestListHandler   ManifestHandler
@@ -73,6 +84,9 @@ func (ms *manifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool,
 }
 
 func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("manifestStore.Get(%q, %q)", ms.repository.Named().Name(), dgst)
+
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Get")
 
 	// TODO(stevvooe): Need to check descriptor from above to ensure that the
@@ -130,28 +144,77 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 }
 
 func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("manifestStore.Put(%q, %T)", ms.repository.Named().Name(), manifest)
+
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Put")
 
+	// The references are recorded before they are verified, so that an
+	// online garbage collection does not remove them while the manifest is
+	// pushed.
+	if ms.references != nil {
+		for _, descriptor := range manifest.References() {
+			if err := ms.references.touch(ctx, descriptor.Digest); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	var handler ManifestHandler
 	switch manifest.(type) {
 	case *schema1.SignedManifest: //nolint:staticcheck // Ignore SA1019: "github.com/docker/distribution/manifest/schema1" is deprecated, as it's used for backward compatibility.
-		return ms.schema1Handler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.schema1Handler
 	case *schema2.DeserializedManifest:
-		return ms.schema2Handler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.schema2Handler
 	case *ocischema.DeserializedManifest:
-		return ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.ocischemaHandler
 	case *manifestlist.DeserializedManifestList:
-		return ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.manifestListHandler
 	case *ocischema.DeserializedImageIndex:
-		return ms.ocischemaIndexHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.ocischemaIndexHandler
+	default:
+		return "", fmt.Errorf("unrecognized manifest type %T", manifest)
 	}
 
-	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
+	dgst, err := handler.Put(ctx, manifest, ms.skipDependencyVerification)
+	if err != nil {
+		return "", err
+	}
+	if ms.metadata != nil {
+		if err := ms.metadata.PutManifest(ms.repository.Named().Name(), dgst, manifest); err != nil {
+			return "", err
+		}
+	}
+	return dgst, nil
 }
 
 // Delete removes the revision of the specified manifest.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("manifestStore.Delete(%q, %q)", ms.repository.Named().Name(), dgst)
+
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
-	return ms.blobStore.Delete(ctx, dgst)
+
+	// the subject of a referrer is only known from its content, which is
+	// read before it is deleted
+	m, err := ms.Get(ctx, dgst)
+	if err != nil {
+		m = nil
+	}
+
+	if err := ms.blobStore.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	if ms.metadata != nil {
+		if err := ms.metadata.DeleteManifest(ms.repository.Named().Name(), dgst); err != nil {
+			return err
+		}
+	}
+
+	if oci, ok := m.(*ocischema.DeserializedManifest); ok && oci.Subject != nil {
+		return ms.referrers.Remove(ctx, oci.Subject.Digest, dgst)
+	}
+	return nil
 }
 
 func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
@@ -164,3 +227,24 @@ func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Dig
 	})
 	return err
 }
+
+// uniqueReferences returns the references of a manifest without repeated
+// descriptors, so that each is verified once however often it is repeated.
+func uniqueReferences(references []distribution.Descriptor) []distribution.Descriptor {
+	type key struct {
+		digest    digest.Digest
+		mediaType string
+		urls      string
+	}
+	seen := make(map[key]struct{}, len(references))
+	unique := make([]distribution.Descriptor, 0, len(references))
+	for _, desc := range references {
+		k := key{digest: desc.Digest, mediaType: desc.MediaType, urls: strings.Join(desc.URLs, "\n")}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		unique = append(unique, desc)
+	}
+	return unique
+}
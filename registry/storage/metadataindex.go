@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metadataInfoBucket         = []byte("info")
+	metadataRepositoriesBucket = []byte("repositories")
+	metadataTagsBucket         = []byte("tags")
+	metadataManifestsBucket    = []byte("manifests")
+	metadataReferrersBucket    = []byte("referrers")
+
+	metadataBuiltAtKey = []byte("builtAt")
+)
+
+// MetadataIndex indexes the repositories of the storage, their tags, the
+// blobs referenced by their manifests and the referrers of their manifests in
+// an embedded database. The index is updated as manifests and tags are
+// written, so that they can be listed without walking the storage.
+//
+// The database is locked by the process which opened it, so the index of a
+// storage is only kept by a single registry instance.
+type MetadataIndex struct {
+	db *bolt.DB
+}
+
+// metadataManifest is the record of a manifest in the index.
+type metadataManifest struct {
+	References []digest.Digest `json:"references,omitempty"`
+	Subject    digest.Digest   `json:"subject,omitempty"`
+}
+
+// OpenMetadataIndex opens the index stored in the database file at path,
+// creating it if needed.
+func OpenMetadataIndex(path string) (*MetadataIndex, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata index %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metadataInfoBucket, metadataRepositoriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &MetadataIndex{db: db}, nil
+}
+
+// Close closes the database of the index.
+func (mi *MetadataIndex) Close() error {
+	return mi.db.Close()
+}
+
+// BuiltAt returns when the index was last rebuilt from the storage, or the
+// zero time if it never was. An index which was never built only knows of
+// the writes made since it was created.
+func (mi *MetadataIndex) BuiltAt() (time.Time, error) {
+	var builtAt time.Time
+	err := mi.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(metadataInfoBucket).Get(metadataBuiltAtKey)
+		if value == nil {
+			return nil
+		}
+		return builtAt.UnmarshalText(value)
+	})
+	return builtAt, err
+}
+
+// ready returns whether the index can be read in place of the storage. A nil
+// index is never ready.
+func (mi *MetadataIndex) ready() bool {
+	if mi == nil {
+		return false
+	}
+	builtAt, err := mi.BuiltAt()
+	return err == nil && !builtAt.IsZero()
+}
+
+// repositoryKey returns the key of a repository, in which the separators of
+// the path components sort before any other character, so that repositories
+// are listed in the order of the storage walk.
+func repositoryKey(name string) []byte {
+	return []byte(strings.ReplaceAll(name, "/", "\x00"))
+}
+
+func repositoryName(key []byte) string {
+	return string(bytes.ReplaceAll(key, []byte{0}, []byte("/")))
+}
+
+// repositoryBucket returns the bucket of the named repository, creating it
+// along with its nested buckets if create is true. It returns nil if the
+// repository is not indexed and create is false.
+func repositoryBucket(tx *bolt.Tx, name string, create bool) (*bolt.Bucket, error) {
+	repositories := tx.Bucket(metadataRepositoriesBucket)
+	if !create {
+		return repositories.Bucket(repositoryKey(name)), nil
+	}
+
+	bucket, err := repositories.CreateBucketIfNotExists(repositoryKey(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, nested := range [][]byte{metadataTagsBucket, metadataManifestsBucket, metadataReferrersBucket} {
+		if _, err := bucket.CreateBucketIfNotExists(nested); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+// referrerKey returns the key of a referrer of a subject, prefixed by the
+// subject so that its referrers are listed with a single seek.
+func referrerKey(subject, referrer digest.Digest) []byte {
+	return []byte(subject.String() + "/" + referrer.String())
+}
+
+// PutManifest indexes the manifest of the named repository, stored under the
+// digest, and the blobs it references. OCI manifests with a subject are
+// indexed as referrers of the subject.
+func (mi *MetadataIndex) PutManifest(name string, dgst digest.Digest, manifest distribution.Manifest) error {
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return err
+	}
+	desc := distribution.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(payload)),
+	}
+
+	var record metadataManifest
+	for _, ref := range manifest.References() {
+		record.References = append(record.References, ref.Digest)
+	}
+	var referrer []byte
+	if m, ok := manifest.(*ocischema.DeserializedManifest); ok && m.Subject != nil {
+		record.Subject = m.Subject.Digest
+		referrer, err = json.Marshal(referrerOf(*m, mediaType, desc))
+		if err != nil {
+			return err
+		}
+	}
+	p, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return mi.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := repositoryBucket(tx, name, true)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Bucket(metadataManifestsBucket).Put([]byte(desc.Digest), p); err != nil {
+			return err
+		}
+		if referrer != nil {
+			return bucket.Bucket(metadataReferrersBucket).Put(referrerKey(record.Subject, desc.Digest), referrer)
+		}
+		return nil
+	})
+}
+
+// DeleteManifest removes the manifest of the named repository from the index,
+// along with its entry in the referrers of its subject.
+func (mi *MetadataIndex) DeleteManifest(name string, dgst digest.Digest) error {
+	return mi.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := repositoryBucket(tx, name, false)
+		if err != nil || bucket == nil {
+			return err
+		}
+
+		manifests := bucket.Bucket(metadataManifestsBucket)
+		value := manifests.Get([]byte(dgst))
+		if value == nil {
+			return nil
+		}
+		var record metadataManifest
+		if err := json.Unmarshal(value, &record); err != nil {
+			return err
+		}
+		if record.Subject != "" {
+			if err := bucket.Bucket(metadataReferrersBucket).Delete(referrerKey(record.Subject, dgst)); err != nil {
+				return err
+			}
+		}
+		return manifests.Delete([]byte(dgst))
+	})
+}
+
+// Tag indexes the tag of the named repository as pointing to the digest.
+func (mi *MetadataIndex) Tag(name, tag string, dgst digest.Digest) error {
+	return mi.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := repositoryBucket(tx, name, true)
+		if err != nil {
+			return err
+		}
+		return bucket.Bucket(metadataTagsBucket).Put([]byte(tag), []byte(dgst))
+	})
+}
+
+// Untag removes the tag of the named repository from the index.
+func (mi *MetadataIndex) Untag(name, tag string) error {
+	return mi.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := repositoryBucket(tx, name, false)
+		if err != nil || bucket == nil {
+			return err
+		}
+		return bucket.Bucket(metadataTagsBucket).Delete([]byte(tag))
+	})
+}
+
+// DeleteRepository removes the named repository from the index.
+func (mi *MetadataIndex) DeleteRepository(name string) error {
+	return mi.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(metadataRepositoriesBucket).DeleteBucket(repositoryKey(name))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Repositories fills repos with the names of the indexed repositories
+// following last, in the order of the storage walk, and returns io.EOF when
+// there are no more.
+func (mi *MetadataIndex) Repositories(repos []string, last string) (int, error) {
+	if len(repos) == 0 {
+		return 0, errors.New("no space in slice")
+	}
+
+	n := 0
+	more := false
+	err := mi.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(metadataRepositoriesBucket).Cursor()
+		k, _ := c.First()
+		if last != "" {
+			lastKey := repositoryKey(last)
+			k, _ = c.Seek(lastKey)
+			if k != nil && bytes.Equal(k, lastKey) {
+				k, _ = c.Next()
+			}
+		}
+		for ; k != nil; k, _ = c.Next() {
+			if n == len(repos) {
+				more = true
+				break
+			}
+			repos[n] = repositoryName(k)
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if !more {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Tags returns the sorted tags of the named repository.
+func (mi *MetadataIndex) Tags(name string) ([]string, error) {
+	var tags []string
+	err := mi.db.View(func(tx *bolt.Tx) error {
+		bucket, err := repositoryBucket(tx, name, false)
+		if err != nil {
+			return err
+		}
+		if bucket == nil {
+			return distribution.ErrRepositoryUnknown{Name: name}
+		}
+		return bucket.Bucket(metadataTagsBucket).ForEach(func(k, _ []byte) error {
+			tags = append(tags, string(k))
+			return nil
+		})
+	})
+	return tags, err
+}
+
+// Referrers returns the referrers of the subject in the named repository,
+// sorted by digest.
+func (mi *MetadataIndex) Referrers(name string, subject digest.Digest) ([]Referrer, error) {
+	var referrers []Referrer
+	err := mi.db.View(func(tx *bolt.Tx) error {
+		bucket, err := repositoryBucket(tx, name, false)
+		if err != nil || bucket == nil {
+			return err
+		}
+
+		prefix := []byte(subject.String() + "/")
+		c := bucket.Bucket(metadataReferrersBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var referrer Referrer
+			if err := json.Unmarshal(v, &referrer); err != nil {
+				return err
+			}
+			referrers = append(referrers, referrer)
+		}
+		return nil
+	})
+	return referrers, err
+}
+
+// ReferencedBlobs calls fn with each indexed manifest and each blob an
+// indexed manifest references. Blobs referenced by several manifests are
+// passed several times.
+func (mi *MetadataIndex) ReferencedBlobs(fn func(digest.Digest) error) error {
+	return mi.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataRepositoriesBucket).ForEach(func(k, _ []byte) error {
+			manifests := tx.Bucket(metadataRepositoriesBucket).Bucket(k).Bucket(metadataManifestsBucket)
+			return manifests.ForEach(func(dgst, value []byte) error {
+				if err := fn(digest.Digest(dgst)); err != nil {
+					return err
+				}
+				var record metadataManifest
+				if err := json.Unmarshal(value, &record); err != nil {
+					return err
+				}
+				for _, ref := range record.References {
+					if err := fn(ref); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	})
+}
+
+// Rebuild replaces the content of the index with the repositories, tags and
+// manifests read from the storage. Writes made to the storage while the index
+// is rebuilt may be missed, so it should be rebuilt while the registry is
+// stopped or does not accept writes.
+func (mi *MetadataIndex) Rebuild(ctx context.Context, storageDriver driver.StorageDriver) error {
+	// the registry reading the storage does not update the index itself
+	reg, err := NewRegistry(ctx, storageDriver)
+	if err != nil {
+		return err
+	}
+
+	err = mi.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(metadataRepositoriesBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(metadataRepositoriesBucket); err != nil {
+			return err
+		}
+		return tx.Bucket(metadataInfoBucket).Delete(metadataBuiltAtKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = reg.(distribution.RepositoryEnumerator).Enumerate(ctx, func(name string) error {
+		dcontext.GetLogger(ctx).Debugf("indexing repository %s", name)
+		return mi.indexRepository(ctx, reg, name)
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return err
+	}
+
+	builtAt, err := time.Now().UTC().MarshalText()
+	if err != nil {
+		return err
+	}
+	return mi.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metadataInfoBucket).Put(metadataBuiltAtKey, builtAt)
+	})
+}
+
+// indexRepository indexes the manifests and tags of the named repository.
+func (mi *MetadataIndex) indexRepository(ctx context.Context, reg distribution.Namespace, name string) error {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return fmt.Errorf("failed to parse repo name %s: %v", name, err)
+	}
+	repository, err := reg.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+
+	err = mi.db.Update(func(tx *bolt.Tx) error {
+		_, err := repositoryBucket(tx, name, true)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	err = manifests.(distribution.ManifestEnumerator).Enumerate(ctx, func(dgst digest.Digest) error {
+		manifest, err := manifests.Get(ctx, dgst)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve manifest %s of %s: %v", dgst, name, err)
+		}
+		return mi.PutManifest(name, dgst, manifest)
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return err
+	}
+
+	tagService := repository.Tags(ctx)
+	tags, err := tagService.All(ctx)
+	if _, ok := err.(distribution.ErrRepositoryUnknown); err != nil && !ok {
+		return err
+	}
+	for _, tag := range tags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return err
+		}
+		if err := mi.Tag(name, tag, desc.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
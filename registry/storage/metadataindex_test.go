@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func openTestMetadataIndex(t *testing.T) *MetadataIndex {
+	index, err := OpenMetadataIndex(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	return index
+}
+
+func TestMetadataIndex(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	index := openTestMetadataIndex(t)
+	registry := createRegistry(t, inmemoryDriver, UseMetadataIndex(index))
+
+	var subject, referrer digest.Digest
+	var blobs []digest.Digest
+	for _, name := range []string{"foo/bar", "foo", "foo-bar"} {
+		repo := makeRepository(t, registry, name)
+		manifestService := makeManifestService(t, repo)
+
+		config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		layer, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageLayer, []byte(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		versioned := manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest}
+		image, err := ocischema.FromStruct(ocischema.Manifest{Versioned: versioned, Config: config, Layers: []distribution.Descriptor{layer}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgst, err := manifestService.Put(ctx, image)
+		if err != nil {
+			t.Fatalf("unexpected error putting image: %v", err)
+		}
+		if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatalf("unexpected error tagging image: %v", err)
+		}
+		blobs = append(blobs, config.Digest, layer.Digest, dgst)
+
+		if name != "foo/bar" {
+			continue
+		}
+		subject = dgst
+		artifact, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned:    versioned,
+			ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+			Config:       config,
+			Layers:       []distribution.Descriptor{layer},
+			Subject:      &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject, Size: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		referrer, err = manifestService.Put(ctx, artifact)
+		if err != nil {
+			t.Fatalf("unexpected error putting artifact: %v", err)
+		}
+		if err := repo.Tags(ctx).Tag(ctx, "signature", distribution.Descriptor{Digest: referrer}); err != nil {
+			t.Fatalf("unexpected error tagging artifact: %v", err)
+		}
+		blobs = append(blobs, referrer)
+	}
+
+	repos := make([]string, 10)
+	n, err := index.Repositories(repos, "")
+	if err != io.EOF {
+		t.Fatalf("unexpected error listing repositories: %v", err)
+	}
+	// repositories are listed in the order of the storage walk
+	if expected := []string{"foo", "foo/bar", "foo-bar"}; !reflect.DeepEqual(repos[:n], expected) {
+		t.Fatalf("unexpected repositories: %v != %v", repos[:n], expected)
+	}
+	n, err = index.Repositories(repos[:1], "foo")
+	if err != nil || n != 1 || repos[0] != "foo/bar" {
+		t.Fatalf("unexpected repositories after foo: %v, %v", repos[:n], err)
+	}
+
+	tags, err := index.Tags("foo/bar")
+	if err != nil || !reflect.DeepEqual(tags, []string{"latest", "signature"}) {
+		t.Fatalf("unexpected tags: %v, %v", tags, err)
+	}
+	if _, err := index.Tags("unknown"); err == nil {
+		t.Fatal("expected error listing the tags of an unknown repository")
+	} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("unexpected error listing the tags of an unknown repository: %v", err)
+	}
+
+	referrers, err := index.Referrers("foo/bar", subject)
+	if err != nil || len(referrers) != 1 || referrers[0].Digest != referrer || referrers[0].ArtifactType != "application/vnd.dev.cosign.artifact.sig.v1+json" {
+		t.Fatalf("unexpected referrers: %+v, %v", referrers, err)
+	}
+
+	referenced := make(map[digest.Digest]struct{})
+	if err := index.ReferencedBlobs(func(dgst digest.Digest) error {
+		referenced[dgst] = struct{}{}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dgst := range blobs {
+		if _, ok := referenced[dgst]; !ok {
+			t.Fatalf("blob %s is not referenced", dgst)
+		}
+	}
+
+	// a rebuilt index has the same content
+	rebuilt := openTestMetadataIndex(t)
+	if err := rebuilt.Rebuild(ctx, inmemoryDriver); err != nil {
+		t.Fatalf("unexpected error rebuilding index: %v", err)
+	}
+	for _, name := range []string{"foo", "foo/bar", "foo-bar"} {
+		tags, _ := index.Tags(name)
+		rebuiltTags, err := rebuilt.Tags(name)
+		if err != nil || !reflect.DeepEqual(tags, rebuiltTags) {
+			t.Fatalf("unexpected tags of %s in rebuilt index: %v, %v", name, rebuiltTags, err)
+		}
+	}
+	if referrers, err := rebuilt.Referrers("foo/bar", subject); err != nil || len(referrers) != 1 {
+		t.Fatalf("unexpected referrers in rebuilt index: %+v, %v", referrers, err)
+	}
+
+	// deletions are applied to the index
+	repo := makeRepository(t, registry, "foo/bar")
+	if err := repo.Tags(ctx).Untag(ctx, "signature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := makeManifestService(t, repo).Delete(ctx, referrer); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := index.Tags("foo/bar"); err != nil || !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags after untagging: %v, %v", tags, err)
+	}
+	if referrers, err := index.Referrers("foo/bar", subject); err != nil || len(referrers) != 0 {
+		t.Fatalf("unexpected referrers after deleting artifact: %+v, %v", referrers, err)
+	}
+	named, _ := reference.WithName("foo-bar")
+	if err := registry.(distribution.RepositoryRemover).Remove(ctx, named); err != nil {
+		t.Fatal(err)
+	}
+	n, _ = index.Repositories(repos, "")
+	if expected := []string{"foo", "foo/bar"}; !reflect.DeepEqual(repos[:n], expected) {
+		t.Fatalf("unexpected repositories after removing foo-bar: %v", repos[:n])
+	}
+}
+
+func TestMetadataIndexServesTagsOnceBuilt(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	index := openTestMetadataIndex(t)
+	registry := createRegistry(t, inmemoryDriver, UseMetadataIndex(index))
+
+	repo := makeRepository(t, registry, "foo")
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: digest.FromString("manifest")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a tag written to the storage only is listed until the index is built
+	otherRegistry := createRegistry(t, inmemoryDriver)
+	if err := makeRepository(t, otherRegistry, "foo").Tags(ctx).Tag(ctx, "other", distribution.Descriptor{Digest: digest.FromString("manifest")}); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := repo.Tags(ctx).All(ctx); err != nil || !reflect.DeepEqual(tags, []string{"latest", "other"}) {
+		t.Fatalf("unexpected tags read from the storage: %v, %v", tags, err)
+	}
+
+	if err := index.Rebuild(ctx, inmemoryDriver); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Untag("foo", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := repo.Tags(ctx).All(ctx); err != nil || !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags read from the index: %v, %v", tags, err)
+	}
+}
+
+func TestMetadataIndexGarbageCollection(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	index := openTestMetadataIndex(t)
+	registry := createRegistry(t, inmemoryDriver, UseMetadataIndex(index))
+	repo := makeRepository(t, registry, "foo")
+
+	kept, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreferenced, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageLayer, []byte("unreferenced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	versioned := manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest}
+	image, err := ocischema.FromStruct(ocischema.Manifest{Versioned: versioned, Config: kept, Layers: []distribution.Descriptor{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := makeManifestService(t, repo).Put(ctx, image); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Rebuild(ctx, inmemoryDriver); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{Metadata: index}); err != nil {
+		t.Fatalf("unexpected error collecting garbage: %v", err)
+	}
+	if _, err := registry.BlobStatter().Stat(ctx, kept.Digest); err != nil {
+		t.Fatalf("referenced blob was collected: %v", err)
+	}
+	if _, err := registry.BlobStatter().Stat(ctx, unreferenced.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("unreferenced blob was not collected: %v", err)
+	}
+}
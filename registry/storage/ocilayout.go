@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ociLayoutImageNameAnnotation annotates the manifests of the index of an OCI
+// image layout with their repository and tag, as written by containerd.
+const ociLayoutImageNameAnnotation = "io.containerd.image.name"
+
+// ExportOCILayout writes the tagged manifests of the repository, along with
+// the manifests and blobs they reference, to w as a tar archive of an OCI
+// image layout. All the tags of the repository are exported if none are
+// given. The index of the layout is written first, so that the archive can
+// be read as a stream.
+func ExportOCILayout(ctx context.Context, registry distribution.Namespace, name reference.Named, tags []string, w io.Writer) error {
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		tags, err = repo.Tags(ctx).All(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+
+	index := v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+	}
+	exported := make([]distribution.Manifest, len(tags))
+	for i, tag := range tags {
+		desc, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("%s:%s: %w", name.Name(), tag, err)
+		}
+		m, err := manifests.Get(ctx, desc.Digest)
+		if err != nil {
+			return fmt.Errorf("%s:%s: %w", name.Name(), tag, err)
+		}
+		mediaType, payload, err := m.Payload()
+		if err != nil {
+			return err
+		}
+		exported[i] = m
+		index.Manifests = append(index.Manifests, v1.Descriptor{
+			MediaType: mediaType,
+			Digest:    desc.Digest,
+			Size:      int64(len(payload)),
+			Annotations: map[string]string{
+				v1.AnnotationRefName:         tag,
+				ociLayoutImageNameAnnotation: name.Name() + ":" + tag,
+			},
+		})
+	}
+
+	tw := tar.NewWriter(w)
+	layout, err := json.Marshal(v1.ImageLayout{Version: v1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, v1.ImageLayoutFile, int64(len(layout)), bytes.NewReader(layout)); err != nil {
+		return err
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "index.json", int64(len(indexJSON)), bytes.NewReader(indexJSON)); err != nil {
+		return err
+	}
+
+	lw := &ociLayoutWriter{
+		tw:        tw,
+		manifests: manifests,
+		blobs:     repo.Blobs(ctx),
+		written:   make(map[digest.Digest]struct{}),
+	}
+	for i, m := range exported {
+		if err := lw.writeManifest(ctx, index.Manifests[i].Digest, m); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ociLayoutWriter writes the blobs of an OCI image layout, each once.
+type ociLayoutWriter struct {
+	tw        *tar.Writer
+	manifests distribution.ManifestService
+	blobs     distribution.BlobStore
+	written   map[digest.Digest]struct{}
+}
+
+// writeManifest writes the manifest after the manifests and blobs it
+// references.
+func (lw *ociLayoutWriter) writeManifest(ctx context.Context, dgst digest.Digest, m distribution.Manifest) error {
+	if _, ok := lw.written[dgst]; ok {
+		return nil
+	}
+
+	for _, desc := range m.References() {
+		if isIndex(m) {
+			child, err := lw.manifests.Get(ctx, desc.Digest)
+			if err != nil {
+				return fmt.Errorf("manifest %s: %w", desc.Digest, err)
+			}
+			if err := lw.writeManifest(ctx, desc.Digest, child); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(desc.URLs) > 0 {
+			// foreign layers are not stored by the registry
+			continue
+		}
+		if err := lw.writeBlob(ctx, desc.Digest); err != nil {
+			return err
+		}
+	}
+
+	_, payload, err := m.Payload()
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(lw.tw, ociLayoutBlobPath(dgst), int64(len(payload)), bytes.NewReader(payload)); err != nil {
+		return err
+	}
+	lw.written[dgst] = struct{}{}
+	return nil
+}
+
+func (lw *ociLayoutWriter) writeBlob(ctx context.Context, dgst digest.Digest) error {
+	if _, ok := lw.written[dgst]; ok {
+		return nil
+	}
+
+	desc, err := lw.blobs.Stat(ctx, dgst)
+	if err != nil {
+		return fmt.Errorf("blob %s: %w", dgst, err)
+	}
+	rc, err := lw.blobs.Open(ctx, dgst)
+	if err != nil {
+		return fmt.Errorf("blob %s: %w", dgst, err)
+	}
+	defer rc.Close()
+
+	if err := writeTarFile(lw.tw, ociLayoutBlobPath(dgst), desc.Size, rc); err != nil {
+		return err
+	}
+	lw.written[dgst] = struct{}{}
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+func ociLayoutBlobPath(dgst digest.Digest) string {
+	return path.Join("blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// ImportOCILayout stores the blobs and manifests of the tar archive of an OCI
+// image layout read from r in the registry, then tags the manifests of its
+// index. Manifests are imported into the repository named by their
+// io.containerd.image.name annotation, and tagged with their
+// org.opencontainers.image.ref.name annotation, or the tag of the former.
+// If name is not nil, all manifests are imported into the named repository
+// instead. The archive is read twice, first for its index. It returns the
+// references of the imported manifests.
+func ImportOCILayout(ctx context.Context, registry distribution.Namespace, r io.ReadSeeker, name reference.Named) ([]reference.Named, error) {
+	index, err := readOCILayoutIndex(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type target struct {
+		repo distribution.Repository
+		desc v1.Descriptor
+		tag  string
+	}
+	var targets []target
+	repos := make(map[string]distribution.Repository)
+	var repoNames []string
+	for _, desc := range index.Manifests {
+		repoName, tag := name, desc.Annotations[v1.AnnotationRefName]
+		if repoName == nil {
+			ref, err := reference.Parse(desc.Annotations[ociLayoutImageNameAnnotation])
+			if err != nil {
+				return nil, fmt.Errorf("no repository to import manifest %s into: %v", desc.Digest, err)
+			}
+			named, ok := ref.(reference.Named)
+			if !ok {
+				return nil, fmt.Errorf("no repository to import manifest %s into", desc.Digest)
+			}
+			repoName = reference.TrimNamed(named)
+			if tagged, ok := ref.(reference.Tagged); ok {
+				tag = tagged.Tag()
+			}
+		}
+		if tag != "" {
+			if _, err := reference.WithTag(repoName, tag); err != nil {
+				return nil, fmt.Errorf("invalid tag %q of manifest %s", tag, desc.Digest)
+			}
+		}
+
+		repo, ok := repos[repoName.Name()]
+		if !ok {
+			repo, err = registry.Repository(ctx, repoName)
+			if err != nil {
+				return nil, err
+			}
+			repos[repoName.Name()] = repo
+			repoNames = append(repoNames, repoName.Name())
+		}
+		targets = append(targets, target{repo: repo, desc: desc, tag: tag})
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		dgst, ok := ociLayoutBlobDigest(hdr)
+		if !ok {
+			continue
+		}
+		for i, repoName := range repoNames {
+			if err := importBlob(ctx, repos[repoName], repos[repoNames[0]], i > 0, dgst, hdr.Size, tr); err != nil {
+				return nil, fmt.Errorf("blob %s: %w", dgst, err)
+			}
+		}
+	}
+
+	var imported []reference.Named
+	for _, t := range targets {
+		if err := importManifest(ctx, t.repo, t.desc.MediaType, t.desc.Digest); err != nil {
+			return nil, err
+		}
+		if t.tag == "" {
+			ref, err := reference.WithDigest(t.repo.Named(), t.desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			imported = append(imported, ref)
+			continue
+		}
+
+		if err := t.repo.Tags(ctx).Tag(ctx, t.tag, distribution.Descriptor{MediaType: t.desc.MediaType, Digest: t.desc.Digest}); err != nil {
+			return nil, err
+		}
+		ref, err := reference.WithTag(t.repo.Named(), t.tag)
+		if err != nil {
+			return nil, err
+		}
+		imported = append(imported, ref)
+	}
+	return imported, nil
+}
+
+// readOCILayoutIndex reads the index of the tar archive of an OCI image
+// layout, checking the version of the layout.
+func readOCILayoutIndex(r io.Reader) (*v1.Index, error) {
+	var layout *v1.ImageLayout
+	var index *v1.Index
+	tr := tar.NewReader(r)
+	for layout == nil || index == nil {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch path.Clean(hdr.Name) {
+		case v1.ImageLayoutFile:
+			layout = &v1.ImageLayout{}
+			if err := json.NewDecoder(tr).Decode(layout); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", v1.ImageLayoutFile, err)
+			}
+		case "index.json":
+			index = &v1.Index{}
+			if err := json.NewDecoder(tr).Decode(index); err != nil {
+				return nil, fmt.Errorf("invalid index.json: %v", err)
+			}
+		}
+	}
+
+	switch {
+	case layout == nil:
+		return nil, errors.New("not an OCI image layout: no " + v1.ImageLayoutFile + " file")
+	case layout.Version != v1.ImageLayoutVersion:
+		return nil, fmt.Errorf("unsupported OCI image layout version %q", layout.Version)
+	case index == nil:
+		return nil, errors.New("not an OCI image layout: no index.json file")
+	}
+	return index, nil
+}
+
+// ociLayoutBlobDigest returns the digest of the blob of an OCI image layout
+// stored in the file of the header, if any.
+func ociLayoutBlobDigest(hdr *tar.Header) (digest.Digest, bool) {
+	if hdr.Typeflag != tar.TypeReg {
+		return "", false
+	}
+	parts := strings.Split(path.Clean(hdr.Name), "/")
+	if len(parts) != 3 || parts[0] != "blobs" {
+		return "", false
+	}
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(parts[1]), parts[2])
+	return dgst, dgst.Validate() == nil
+}
+
+// importBlob stores the blob in the repository, unless it is already stored,
+// reading it from r or mounting it from the repository it was first stored
+// in.
+func importBlob(ctx context.Context, repo, first distribution.Repository, mount bool, dgst digest.Digest, size int64, r io.Reader) error {
+	blobs := repo.Blobs(ctx)
+	if _, err := blobs.Stat(ctx, dgst); err == nil {
+		return nil
+	} else if err != distribution.ErrBlobUnknown {
+		return err
+	}
+
+	var options []distribution.BlobCreateOption
+	if mount {
+		canonical, err := reference.WithDigest(first.Named(), dgst)
+		if err != nil {
+			return err
+		}
+		options = append(options, WithMountFrom(canonical))
+	}
+	bw, err := blobs.Create(ctx, options...)
+	if err != nil {
+		if ebm, ok := err.(distribution.ErrBlobMounted); ok && ebm.Descriptor.Digest == dgst {
+			return nil
+		}
+		return err
+	}
+	if _, err := io.Copy(bw, r); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	if _, err := bw.Commit(ctx, distribution.Descriptor{Digest: dgst, Size: size}); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	return nil
+}
+
+// importManifest stores the manifest imported as a blob of the repository,
+// after the manifests it references.
+func importManifest(ctx context.Context, repo distribution.Repository, mediaType string, dgst digest.Digest) error {
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	if exists, err := manifests.Exists(ctx, dgst); err != nil || exists {
+		return err
+	}
+
+	payload, err := repo.Blobs(ctx).Get(ctx, dgst)
+	if err != nil {
+		return fmt.Errorf("manifest %s: %w", dgst, err)
+	}
+	m, _, err := distribution.UnmarshalManifest(mediaType, payload)
+	if err != nil {
+		return fmt.Errorf("manifest %s: %w", dgst, err)
+	}
+	if isIndex(m) {
+		for _, desc := range m.References() {
+			if err := importManifest(ctx, repo, desc.MediaType, desc.Digest); err != nil {
+				return err
+			}
+		}
+	}
+
+	stored, err := manifests.Put(ctx, m)
+	if err != nil {
+		return fmt.Errorf("manifest %s: %w", dgst, err)
+	}
+	if stored != dgst {
+		return fmt.Errorf("manifest %s was stored as %s", dgst, stored)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestOCILayoutExportImport(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "foo/bar")
+	manifestService := makeManifestService(t, repo)
+
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageLayer, []byte("layer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest},
+		Config:    config,
+		Layers:    []distribution.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageDigest, err := manifestService.Put(ctx, image)
+	if err != nil {
+		t.Fatalf("unexpected error putting image: %v", err)
+	}
+	_, payload, _ := image.Payload()
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{
+		{MediaType: v1.MediaTypeImageManifest, Digest: imageDigest, Size: int64(len(payload))},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := manifestService.Put(ctx, index)
+	if err != nil {
+		t.Fatalf("unexpected error putting index: %v", err)
+	}
+	for tag, dgst := range map[string]digest.Digest{"image": imageDigest, "index": indexDigest} {
+		if err := repo.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var bundle bytes.Buffer
+	if err := ExportOCILayout(ctx, registry, repo.Named(), nil, &bundle); err != nil {
+		t.Fatalf("unexpected error exporting: %v", err)
+	}
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(bundle.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	// the index comes first and each blob is written once
+	expected := []string{
+		v1.ImageLayoutFile,
+		"index.json",
+		ociLayoutBlobPath(config.Digest),
+		ociLayoutBlobPath(layer.Digest),
+		ociLayoutBlobPath(imageDigest),
+		ociLayoutBlobPath(indexDigest),
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected files in bundle: %v != %v", names, expected)
+	}
+
+	imported := createRegistry(t, inmemory.New())
+	refs, err := ImportOCILayout(ctx, imported, bytes.NewReader(bundle.Bytes()), nil)
+	if err != nil {
+		t.Fatalf("unexpected error importing: %v", err)
+	}
+	var refNames []string
+	for _, ref := range refs {
+		refNames = append(refNames, ref.String())
+	}
+	sort.Strings(refNames)
+	if !reflect.DeepEqual(refNames, []string{"foo/bar:image", "foo/bar:index"}) {
+		t.Fatalf("unexpected imported references: %v", refNames)
+	}
+	importedRepo := makeRepository(t, imported, "foo/bar")
+	for tag, dgst := range map[string]digest.Digest{"image": imageDigest, "index": indexDigest} {
+		desc, err := importedRepo.Tags(ctx).Get(ctx, tag)
+		if err != nil || desc.Digest != dgst {
+			t.Fatalf("unexpected imported tag %s: %v, %v", tag, desc.Digest, err)
+		}
+	}
+	if _, err := importedRepo.Blobs(ctx).Stat(ctx, layer.Digest); err != nil {
+		t.Fatalf("layer was not imported: %v", err)
+	}
+
+	// the repository can be overridden
+	named, _ := reference.WithName("other")
+	if _, err := ImportOCILayout(ctx, imported, bytes.NewReader(bundle.Bytes()), named); err != nil {
+		t.Fatalf("unexpected error importing into another repository: %v", err)
+	}
+	if desc, err := makeRepository(t, imported, "other").Tags(ctx).Get(ctx, "index"); err != nil || desc.Digest != indexDigest {
+		t.Fatalf("unexpected tag imported into another repository: %v, %v", desc.Digest, err)
+	}
+
+	var notLayout bytes.Buffer
+	tw := tar.NewWriter(&notLayout)
+	if err := writeTarFile(tw, "index.json", 2, bytes.NewReader([]byte("{}"))); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	if _, err := ImportOCILayout(ctx, imported, bytes.NewReader(notLayout.Bytes()), nil); err == nil {
+		t.Fatal("expected an error importing an archive without an oci-layout file")
+	}
+}
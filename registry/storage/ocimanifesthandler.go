@@ -18,6 +18,7 @@ type ocischemaManifestHandler struct {
 	blobStore    distribution.BlobStore
 	ctx          context.Context
 	manifestURLs manifestURLs
+	referrers    *ReferrerIndex
 }
 
 var _ ManifestHandler = &ocischemaManifestHandler{}
@@ -56,9 +57,37 @@ func (ms *ocischemaManifestHandler) Put(ctx context.Context, manifest distributi
 		return "", err
 	}
 
+	if m.Subject != nil {
+		if err := ms.indexReferrer(ctx, *m, mt, revision); err != nil {
+			return "", err
+		}
+	}
+
 	return revision.Digest, nil
 }
 
+// indexReferrer indexes the manifest under the subject it refers to.
+func (ms *ocischemaManifestHandler) indexReferrer(ctx context.Context, m ocischema.DeserializedManifest, mediaType string, desc distribution.Descriptor) error {
+	return ms.referrers.Add(ctx, m.Subject.Digest, referrerOf(m, mediaType, desc))
+}
+
+// referrerOf returns the descriptor of the manifest as a referrer of its
+// subject.
+func referrerOf(m ocischema.DeserializedManifest, mediaType string, desc distribution.Descriptor) Referrer {
+	artifactType := m.ArtifactType
+	if artifactType == "" {
+		artifactType = m.Config.MediaType
+	}
+
+	return Referrer{
+		MediaType:    mediaType,
+		Digest:       desc.Digest,
+		Size:         desc.Size,
+		ArtifactType: artifactType,
+		Annotations:  m.Annotations,
+	}
+}
+
 // verifyManifest ensures that the manifest content is valid from the
 // perspective of the registry. As a policy, the registry only tries to store
 // valid content, leaving trust policies of that content up to consumers.
@@ -80,7 +109,7 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 
 	blobsService := ms.repository.Blobs(ctx)
 
-	for _, descriptor := range mnfst.References() {
+	for _, descriptor := range uniqueReferences(mnfst.References()) {
 		err := descriptor.Digest.Validate()
 		if err != nil {
 			errs = append(errs, err, distribution.ErrManifestBlobUnknown{Digest: descriptor.Digest})
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/opencontainers/go-digest"
+	// blobs may be stored under their blake3 digest
+	_ "github.com/opencontainers/go-digest/blake3"
 )
 
 const (
@@ -92,6 +94,25 @@ const (
 //	manifestTagIndexEntryPathSpec:         <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/
 //	manifestTagIndexEntryLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/link
 //
+//	Annotations:
+//
+//	annotationIndexPathSpec:               <root>/v2/repositories/<name>/_annotations/<hex digest of key>/<hex digest of value>
+//	annotationIndexEntryLinkPathSpec:      <root>/v2/repositories/<name>/_annotations/<hex digest of key>/<hex digest of value>/<algorithm>/<hex digest>/link
+//
+//	SBOMs:
+//
+//	sbomIndexPathSpec:                     <root>/v2/repositories/<name>/_sboms/<hex digest of package>
+//	sbomIndexEntryPathSpec:                <root>/v2/repositories/<name>/_sboms/<hex digest of package>/<algorithm>/<hex digest of sbom>/entry
+//
+//	Referrers:
+//
+//	referrersPathSpec:                     <root>/v2/repositories/<name>/_referrers/<algorithm>/<hex digest of subject>
+//	referrerEntryPathSpec:                 <root>/v2/repositories/<name>/_referrers/<algorithm>/<hex digest of subject>/<algorithm>/<hex digest>/entry
+//
+//	Vulnerabilities:
+//
+//	vulnerabilitySummaryPathSpec:          <root>/v2/repositories/<name>/_vulnerabilities/<algorithm>/<hex digest>/summary
+//
 //	Blobs:
 //
 //	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
@@ -102,6 +123,7 @@ const (
 //	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
 //	uploadStartedAtPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/startedat
 //	uploadHashStatePathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/hashstates/<algorithm>/<offset>
+//	uploadAlgorithmPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/algorithm
 //
 //	Blob Store:
 //
@@ -109,6 +131,12 @@ const (
 //	blobPathSpec:                   <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>
 //	blobDataPathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 //	blobMediaTypePathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
+//	blobFilesPathSpec:              <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/files
+//	blobReferencedAtPathSpec:       <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/referencedat
+//
+//	Quarantine:
+//
+//	blobQuarantinePathSpec:         <root>/v2/quarantine/<algorithm>/<first two hex bytes of digest>/<hex digest>
 //
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
@@ -194,6 +222,73 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, path.Join(components...)), nil
+	case annotationIndexPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_annotations",
+			digest.FromString(v.key).Hex(), digest.FromString(v.value).Hex())...), nil
+	case annotationIndexEntryLinkPathSpec:
+		root, err := pathFor(annotationIndexPathSpec{
+			name:  v.name,
+			key:   v.key,
+			value: v.value,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		components, err := digestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...), "link"), nil
+	case sbomIndexPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_sboms", digest.FromString(v.pkg).Hex())...), nil
+	case sbomIndexEntryPathSpec:
+		root, err := pathFor(sbomIndexPathSpec{
+			name: v.name,
+			pkg:  v.pkg,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		components, err := digestPathComponents(v.sbom, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...), "entry"), nil
+	case referrersPathSpec:
+		components, err := digestPathComponents(v.subject, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_referrers"), components...)...), nil
+	case referrerEntryPathSpec:
+		root, err := pathFor(referrersPathSpec{
+			name:    v.name,
+			subject: v.subject,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		components, err := digestPathComponents(v.referrer, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...), "entry"), nil
+	case vulnerabilitySummaryPathSpec:
+		components, err := digestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		summaryPathComponents := append(repoPrefix, v.name, "_vulnerabilities")
+
+		return path.Join(path.Join(append(summaryPathComponents, components...)...), "summary"), nil
 	case layerLinkPathSpec:
 		components, err := digestPathComponents(v.digest, false)
 		if err != nil {
@@ -230,11 +325,40 @@ func pathFor(spec pathSpec) (string, error) {
 		components = append(components, "data")
 		blobPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobFilesPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "files")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+	case blobReferencedAtPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "referencedat")
+		blobPathPrefix := append(rootPrefix, "blobs")
+		return path.Join(append(blobPathPrefix, components...)...), nil
+
+	case blobQuarantinePathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		quarantinePathPrefix := append(rootPrefix, "quarantine")
+		return path.Join(append(quarantinePathPrefix, components...)...), nil
 
 	case uploadDataPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "data")...), nil
 	case uploadStartedAtPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "startedat")...), nil
+	case uploadAlgorithmPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "algorithm")...), nil
 	case uploadHashStatePathSpec:
 		offset := fmt.Sprintf("%d", v.offset)
 		if v.list {
@@ -339,6 +463,76 @@ type manifestTagIndexEntryLinkPathSpec struct {
 
 func (manifestTagIndexEntryLinkPathSpec) pathSpec() {}
 
+// annotationIndexPathSpec describes the directory of the manifest revisions
+// indexed under an annotation key and value. The key and value are hashed, as
+// they may contain characters which are not valid in paths.
+type annotationIndexPathSpec struct {
+	name  string
+	key   string
+	value string
+}
+
+func (annotationIndexPathSpec) pathSpec() {}
+
+// annotationIndexEntryLinkPathSpec describes the link to a manifest revision
+// indexed under an annotation key and value.
+type annotationIndexEntryLinkPathSpec struct {
+	name     string
+	key      string
+	value    string
+	revision digest.Digest
+}
+
+func (annotationIndexEntryLinkPathSpec) pathSpec() {}
+
+// sbomIndexPathSpec describes the directory of the SBOMs listing a package.
+// The package name is hashed, as it may contain characters which are not
+// valid in paths.
+type sbomIndexPathSpec struct {
+	name string
+	pkg  string
+}
+
+func (sbomIndexPathSpec) pathSpec() {}
+
+// sbomIndexEntryPathSpec describes the entry of an SBOM listing a package,
+// holding the versions of the package it lists and the image it describes.
+type sbomIndexEntryPathSpec struct {
+	name string
+	pkg  string
+	sbom digest.Digest
+}
+
+func (sbomIndexEntryPathSpec) pathSpec() {}
+
+// referrersPathSpec describes the directory of the manifests referring to a
+// subject manifest.
+type referrersPathSpec struct {
+	name    string
+	subject digest.Digest
+}
+
+func (referrersPathSpec) pathSpec() {}
+
+// referrerEntryPathSpec describes the entry of a manifest referring to a
+// subject manifest, holding the descriptor of the referrer.
+type referrerEntryPathSpec struct {
+	name     string
+	subject  digest.Digest
+	referrer digest.Digest
+}
+
+func (referrerEntryPathSpec) pathSpec() {}
+
+// vulnerabilitySummaryPathSpec describes the summary of the latest
+// vulnerability report of a manifest revision.
+type vulnerabilitySummaryPathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (vulnerabilitySummaryPathSpec) pathSpec() {}
+
 // layersPathSpec contains the path for the layers inside a repo
 type layersPathSpec struct {
 	name string
@@ -394,6 +588,31 @@ type blobDataPathSpec struct {
 
 func (blobDataPathSpec) pathSpec() {}
 
+// blobFilesPathSpec contains the path for the cached listing of the files of
+// a layer blob. It is stored next to the data, so that it is deleted with it.
+type blobFilesPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobFilesPathSpec) pathSpec() {}
+
+// blobReferencedAtPathSpec contains the path for the time a blob was last
+// linked or referenced by a pushed manifest, which online garbage collection
+// relies on. It is stored next to the data, so that it is deleted with it.
+type blobReferencedAtPathSpec struct {
+	digest digest.Digest
+}
+
+func (blobReferencedAtPathSpec) pathSpec() {}
+
+// blobQuarantinePathSpec contains the path corrupted blobs are moved to,
+// out of the blob store, when found by a scrub.
+type blobQuarantinePathSpec struct {
+	digest digest.Digest
+}
+
+func (blobQuarantinePathSpec) pathSpec() {}
+
 // uploadDataPathSpec defines the path parameters of the data file for
 // uploads.
 type uploadDataPathSpec struct {
@@ -417,6 +636,15 @@ type uploadStartedAtPathSpec struct {
 
 func (uploadStartedAtPathSpec) pathSpec() {}
 
+// uploadAlgorithmPathSpec defines the path parameters for the file storing
+// the digest algorithm of an upload, if not the canonical one.
+type uploadAlgorithmPathSpec struct {
+	name string
+	id   string
+}
+
+func (uploadAlgorithmPathSpec) pathSpec() {}
+
 // uploadHashStatePathSpec defines the path parameters for the file that stores
 // the hash function state of an upload at a specific byte offset. If `list` is
 // set, then the path mapper will generate a list prefix for all hash state
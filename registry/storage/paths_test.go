@@ -87,6 +87,33 @@ func TestPathMapper(t *testing.T) {
 			spec:     layersPathSpec{name: "foo/bar"},
 			expected: "/docker/registry/v2/repositories/foo/bar/_layers",
 		},
+		{
+			spec:     blobQuarantinePathSpec{digest: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"},
+			expected: "/docker/registry/v2/quarantine/sha256/ab/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+		},
+		{
+			spec:     blobFilesPathSpec{digest: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"},
+			expected: "/docker/registry/v2/blobs/sha256/ab/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/files",
+		},
+		{
+			spec:     blobReferencedAtPathSpec{digest: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"},
+			expected: "/docker/registry/v2/blobs/sha256/ab/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/referencedat",
+		},
+		{
+			spec: referrerEntryPathSpec{
+				name:     "foo/bar",
+				subject:  "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				referrer: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/sha256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/entry",
+		},
+		{
+			spec: vulnerabilitySummaryPathSpec{
+				name:     "foo/bar",
+				revision: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_vulnerabilities/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/summary",
+		},
 	} {
 		p, err := pathFor(testcase.spec)
 		if err != nil {
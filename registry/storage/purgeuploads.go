@@ -6,11 +6,26 @@ import (
 	"strings"
 	"time"
 
+	prometheus "github.com/docker/distribution/metrics"
 	storageDriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/uuid"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// purgedSession is the kind of the upload sessions whose directory was
+	// purged.
+	purgedSession = "session"
+	// purgedMultipart is the kind of the multipart uploads of the backend
+	// which were aborted.
+	purgedMultipart = "multipart"
+)
+
+var (
+	purgedUploads     = prometheus.StorageNamespace.NewLabeledCounter("purged_uploads", "The number of stale uploads purged by kind", "kind")
+	purgedUploadBytes = prometheus.StorageNamespace.NewCounter("purged_upload_bytes", "The number of bytes of the stale uploads purged")
+)
+
 // uploadData stored the location of temporary files created during a layer upload
 // along with the date the upload was started
 type uploadData struct {
@@ -28,21 +43,31 @@ func newUploadData() uploadData {
 
 // PurgeUploads deletes files from the upload directory
 // created before olderThan.  The list of files deleted and errors
-// encountered are returned
+// encountered are returned.  The multipart uploads to the upload directory
+// started before olderThan are aborted first, if the driver keeps their
+// parts, so that half-committed uploads do not leak storage.
 func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) ([]string, []error) {
 	logrus.Infof("PurgeUploads starting: olderThan=%s, actuallyDelete=%t", olderThan, actuallyDelete)
-	uploadData, errors := getOutstandingUploads(ctx, driver)
+	errors := abortMultipartUploads(ctx, driver, olderThan, actuallyDelete)
+	uploadData, errs := getOutstandingUploads(ctx, driver)
+	errors = append(errors, errs...)
 	var deleted []string
 	for _, uploadData := range uploadData {
 		if uploadData.startedAt.Before(olderThan) {
 			var err error
+			var size int64
 			logrus.Infof("Upload files in %s have older date (%s) than purge date (%s).  Removing upload directory.",
 				uploadData.containingDir, uploadData.startedAt, olderThan)
 			if actuallyDelete {
+				size = uploadSize(ctx, driver, uploadData.containingDir)
 				err = driver.Delete(ctx, uploadData.containingDir)
 			}
 			if err == nil {
 				deleted = append(deleted, uploadData.containingDir)
+				if actuallyDelete {
+					purgedUploads.WithValues(purgedSession).Inc(1)
+					purgedUploadBytes.Inc(float64(size))
+				}
 			} else {
 				errors = append(errors, err)
 			}
@@ -53,6 +78,54 @@ func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, older
 	return deleted, errors
 }
 
+// abortMultipartUploads aborts the multipart uploads to the upload
+// directories started before olderThan, if the driver keeps their parts.
+func abortMultipartUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) []error {
+	uploader, ok := driver.(storageDriver.MultipartUploader)
+	if !ok {
+		logrus.Warnf("Storage driver %s does not list multipart uploads, so the parts of stale uploads it may keep are not aborted.", driver.Name())
+		return nil
+	}
+
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return []error{err}
+	}
+	uploads, err := uploader.MultipartUploads(ctx, root)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errors []error
+	for _, upload := range uploads {
+		if !strings.Contains(upload.Path, "/_uploads/") || !upload.StartedAt.Before(olderThan) {
+			continue
+		}
+		logrus.Infof("Multipart upload to %s has older date (%s) than purge date (%s).  Aborting it.",
+			upload.Path, upload.StartedAt, olderThan)
+		if !actuallyDelete {
+			continue
+		}
+		if err := uploader.AbortMultipartUpload(ctx, upload); err != nil {
+			errors = pushError(errors, upload.Path, err)
+			continue
+		}
+		purgedUploads.WithValues(purgedMultipart).Inc(1)
+		purgedUploadBytes.Inc(float64(upload.Size))
+	}
+	return errors
+}
+
+// uploadSize returns the size of the data of the upload in the directory,
+// or zero if it cannot be read.
+func uploadSize(ctx context.Context, driver storageDriver.StorageDriver, dir string) int64 {
+	fi, err := driver.Stat(ctx, path.Join(dir, "data"))
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
 // getOutstandingUploads walks the upload directory, collecting files
 // which could be eligible for deletion.  The only reliable way to
 // classify the age of a file is with the date stored in the startedAt
@@ -9,6 +9,7 @@ import (
 
 	"github.com/docker/distribution/registry/storage/driver"
 	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	storagemiddleware "github.com/docker/distribution/registry/storage/driver/middleware"
 	"github.com/docker/distribution/uuid"
 )
 
@@ -163,3 +164,94 @@ func TestPurgeMissingStartedAt(t *testing.T) {
 		t.Errorf("Files unexpectedly deleted: %s", deleted)
 	}
 }
+
+// multipartDriver keeps multipart uploads alongside the files of a driver.
+type multipartDriver struct {
+	driver.StorageDriver
+	uploads []driver.MultipartUpload
+	aborted []string
+}
+
+func (d *multipartDriver) MultipartUploads(ctx context.Context, p string) ([]driver.MultipartUpload, error) {
+	var uploads []driver.MultipartUpload
+	for _, upload := range d.uploads {
+		if strings.HasPrefix(upload.Path, p) {
+			uploads = append(uploads, upload)
+		}
+	}
+	return uploads, nil
+}
+
+func (d *multipartDriver) AbortMultipartUpload(ctx context.Context, upload driver.MultipartUpload) error {
+	d.aborted = append(d.aborted, upload.Path)
+	return nil
+}
+
+func TestPurgeMultipartUploads(t *testing.T) {
+	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	fs, ctx := testUploadFS(t, 1, "test-repo", oneHourAgo)
+
+	// an upload whose directory was already purged, a recent one, and a
+	// write outside of the upload directories
+	orphanPath, _ := pathFor(uploadDataPathSpec{name: "test-repo", id: uuid.Generate().String()})
+	recentPath, _ := pathFor(uploadDataPathSpec{name: "test-repo", id: uuid.Generate().String()})
+	otherPath, _ := pathFor(manifestTagsPathSpec{name: "test-repo"})
+	d := &multipartDriver{
+		StorageDriver: fs,
+		uploads: []driver.MultipartUpload{
+			{Path: orphanPath, ID: "orphan", StartedAt: oneHourAgo, Size: 10},
+			{Path: recentPath, ID: "recent", StartedAt: time.Now(), Size: 10},
+			{Path: otherPath, ID: "other", StartedAt: oneHourAgo, Size: 10},
+		},
+	}
+
+	deleted, errs := PurgeUploads(ctx, d, time.Now().Add(-time.Minute), false)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(deleted) != 1 || len(d.aborted) != 0 {
+		t.Fatalf("Unexpected dry run: deleted %v, aborted %v", deleted, d.aborted)
+	}
+
+	deleted, errs = PurgeUploads(ctx, d, time.Now().Add(-time.Minute), true)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("Unexpected deleted upload directories: %v", deleted)
+	}
+	if len(d.aborted) != 1 || d.aborted[0] != orphanPath {
+		t.Errorf("Unexpected aborted multipart uploads: %v", d.aborted)
+	}
+}
+
+// passthroughMiddleware wraps a driver without implementing the
+// MultipartUploader interface itself.
+type passthroughMiddleware struct {
+	driver.StorageDriver
+}
+
+func TestPurgeMultipartUploadsThroughMiddleware(t *testing.T) {
+	storagemiddleware.Register("purgeuploadstest", func(sd driver.StorageDriver, options map[string]interface{}) (driver.StorageDriver, error) {
+		return passthroughMiddleware{StorageDriver: sd}, nil
+	})
+
+	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	fs, ctx := testUploadFS(t, 1, "test-repo", oneHourAgo)
+	uploadPath, _ := pathFor(uploadDataPathSpec{name: "test-repo", id: uuid.Generate().String()})
+	d := &multipartDriver{
+		StorageDriver: fs,
+		uploads:       []driver.MultipartUpload{{Path: uploadPath, ID: "stale", StartedAt: oneHourAgo, Size: 10}},
+	}
+	wrapped, err := storagemiddleware.Get("purgeuploadstest", nil, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, errs := PurgeUploads(ctx, wrapped, time.Now().Add(-time.Minute), true); len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(d.aborted) != 1 || d.aborted[0] != uploadPath {
+		t.Errorf("Unexpected aborted multipart uploads: %v", d.aborted)
+	}
+}
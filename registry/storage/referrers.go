@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// Referrer is the descriptor of a manifest referring to a subject manifest,
+// such as a signature, an SBOM or an attestation of an image.
+type Referrer struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       digest.Digest     `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ReferrerIndex indexes the manifests of a repository by the subject they
+// refer to, so that the referrers of a manifest can be listed without reading
+// every manifest of the repository.
+type ReferrerIndex struct {
+	driver driver.StorageDriver
+	name   string
+}
+
+// NewReferrerIndex returns the referrer index of the named repository,
+// stored with the given driver.
+func NewReferrerIndex(storageDriver driver.StorageDriver, name reference.Named) *ReferrerIndex {
+	return &ReferrerIndex{
+		driver: storageDriver,
+		name:   name.Name(),
+	}
+}
+
+// Add indexes the referrer under its subject. The subject does not need to
+// exist, as referrers may be pushed before the manifest they refer to.
+func (ri *ReferrerIndex) Add(ctx context.Context, subject digest.Digest, referrer Referrer) error {
+	entryPath, err := pathFor(referrerEntryPathSpec{
+		name:     ri.name,
+		subject:  subject,
+		referrer: referrer.Digest,
+	})
+	if err != nil {
+		return err
+	}
+
+	p, err := json.Marshal(referrer)
+	if err != nil {
+		return err
+	}
+	return ri.driver.PutContent(ctx, entryPath, p)
+}
+
+// Remove removes the referrer from the index of its subject.
+func (ri *ReferrerIndex) Remove(ctx context.Context, subject, referrer digest.Digest) error {
+	entryPath, err := pathFor(referrerEntryPathSpec{
+		name:     ri.name,
+		subject:  subject,
+		referrer: referrer,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ri.driver.Delete(ctx, path.Dir(entryPath)); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup returns the referrers of the subject, sorted by digest. The
+// referrers may include manifests which were removed by a garbage collection
+// after they were indexed.
+func (ri *ReferrerIndex) Lookup(ctx context.Context, subject digest.Digest) ([]Referrer, error) {
+	root, err := pathFor(referrersPathSpec{
+		name:    ri.name,
+		subject: subject,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var referrers []Referrer
+	err = ri.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "entry" {
+			return nil
+		}
+
+		content, err := ri.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		var referrer Referrer
+		if err := json.Unmarshal(content, &referrer); err != nil {
+			return err
+		}
+		referrers = append(referrers, referrer)
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(referrers, func(i, j int) bool { return referrers[i].Digest < referrers[j].Digest })
+	return referrers, nil
+}
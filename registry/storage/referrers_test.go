@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestReferrerIndex(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "test")
+	manifestService := makeManifestService(t, repo)
+
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := repo.Blobs(ctx).Put(ctx, "application/vnd.dev.cosign.simplesigning.v1+json", []byte("signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versioned := manifest.Versioned{SchemaVersion: 2, MediaType: v1.MediaTypeImageManifest}
+	image, err := ocischema.FromStruct(ocischema.Manifest{Versioned: versioned, Config: config, Layers: []distribution.Descriptor{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := manifestService.Put(ctx, image)
+	if err != nil {
+		t.Fatalf("unexpected error putting image: %v", err)
+	}
+
+	index := NewReferrerIndex(inmemoryDriver, repo.Named())
+	referrers, err := index.Lookup(ctx, subject)
+	if err != nil || len(referrers) != 0 {
+		t.Fatalf("unexpected referrers of image without referrers: %v, %v", referrers, err)
+	}
+
+	artifact, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned:    versioned,
+		ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+		Config:       config,
+		Layers:       []distribution.Descriptor{signature},
+		Subject:      &distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject, Size: 1},
+		Annotations:  map[string]string{"org.opencontainers.image.created": "2023-01-01T00:00:00Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrer, err := manifestService.Put(ctx, artifact)
+	if err != nil {
+		t.Fatalf("unexpected error putting artifact: %v", err)
+	}
+	_, payload, _ := artifact.Payload()
+
+	referrers, err = index.Lookup(ctx, subject)
+	if err != nil {
+		t.Fatalf("unexpected error looking up referrers: %v", err)
+	}
+	if len(referrers) != 1 {
+		t.Fatalf("unexpected referrers: %v", referrers)
+	}
+	if r := referrers[0]; r.Digest != referrer || r.MediaType != v1.MediaTypeImageManifest || r.Size != int64(len(payload)) ||
+		r.ArtifactType != "application/vnd.dev.cosign.artifact.sig.v1+json" || r.Annotations["org.opencontainers.image.created"] != "2023-01-01T00:00:00Z" {
+		t.Fatalf("unexpected referrer: %+v", r)
+	}
+
+	if referrers, err := index.Lookup(ctx, digest.FromString("unknown")); err != nil || len(referrers) != 0 {
+		t.Fatalf("unexpected referrers of unknown subject: %v, %v", referrers, err)
+	}
+
+	// deleted referrers are removed from the index
+	if err := manifestService.Delete(ctx, referrer); err != nil {
+		t.Fatalf("unexpected error deleting artifact: %v", err)
+	}
+	if referrers, err := index.Lookup(ctx, subject); err != nil || len(referrers) != 0 {
+		t.Fatalf("unexpected referrers after deleting artifact: %v, %v", referrers, err)
+	}
+}
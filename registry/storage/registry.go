@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"regexp"
+	"time"
 
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/reference"
@@ -25,6 +26,9 @@ type registry struct {
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	manifestURLs                 manifestURLs
 	driver                       storagedriver.StorageDriver
+	redirectPolicy               RedirectFunc
+	references                   *blobReferences
+	metadata                     *MetadataIndex
 }
 
 // manifestURLs holds regular expressions for controlling manifest URL whitelisting
@@ -43,6 +47,21 @@ func EnableRedirect(registry *registry) error {
 	return nil
 }
 
+// RedirectFunc returns whether the blobs of a repository are served by
+// redirecting clients to the storage, and the expiry of the redirect URLs. A
+// zero expiry leaves the expiry to the storage driver.
+type RedirectFunc func(repository string) (redirect bool, expiry time.Duration)
+
+// RedirectPolicy is a functional option for NewRegistry. It decides for each
+// repository whether blobs are served with redirects, overriding
+// EnableRedirect.
+func RedirectPolicy(policy RedirectFunc) RegistryOption {
+	return func(registry *registry) error {
+		registry.redirectPolicy = policy
+		return nil
+	}
+}
+
 // EnableDelete is a functional option for NewRegistry. It enables deletion on
 // the registry.
 func EnableDelete(registry *registry) error {
@@ -57,6 +76,25 @@ func EnableSchema1(registry *registry) error {
 	return nil
 }
 
+// TrackBlobReferences is a functional option for NewRegistry. It records
+// when blobs are linked into repositories and referenced by pushed manifests,
+// so that garbage collection can run online, while the registry serves
+// requests.
+func TrackBlobReferences(registry *registry) error {
+	registry.references = &blobReferences{driver: registry.driver}
+	return nil
+}
+
+// UseMetadataIndex is a functional option for NewRegistry. It updates the
+// metadata index as manifests, tags and repositories are written and
+// deleted, and reads the catalog and the tags from it once it was built.
+func UseMetadataIndex(index *MetadataIndex) RegistryOption {
+	return func(registry *registry) error {
+		registry.metadata = index
+		return nil
+	}
+}
+
 // DisableDigestResumption is a functional option for NewRegistry. It should be
 // used if the registry is acting as a caching proxy.
 func DisableDigestResumption(registry *registry) error {
@@ -233,6 +271,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		repository:           repo,
 		deleteEnabled:        repo.registry.deleteEnabled,
 		blobAccessController: statter,
+		references:           repo.references,
 
 		// TODO(stevvooe): linkPath limits this blob store to only
 		// manifests. This instance cannot be used for blob checks.
@@ -265,10 +304,15 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		blobStore:  blobStore,
 	}
 
+	referrers := NewReferrerIndex(repo.driver, repo.name)
+
 	ms := &manifestStore{
 		ctx:            ctx,
 		repository:     repo,
 		blobStore:      blobStore,
+		referrers:      referrers,
+		references:     repo.references,
+		metadata:       repo.metadata,
 		schema1Handler: v1Handler,
 		schema2Handler: &schema2ManifestHandler{
 			ctx:          ctx,
@@ -282,6 +326,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 			repository:   repo,
 			blobStore:    blobStore,
 			manifestURLs: repo.registry.manifestURLs,
+			referrers:    referrers,
 		},
 		ocischemaIndexHandler: &ocischemaIndexHandler{
 			manifestListHandler: manifestListHandler,
@@ -299,6 +344,18 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 	return ms, nil
 }
 
+// blobServerFor returns the blob server of the repository, redirecting
+// clients as decided by the redirect policy of the registry.
+func (repo *repository) blobServerFor() *blobServer {
+	if repo.redirectPolicy == nil {
+		return repo.blobServer
+	}
+
+	server := *repo.blobServer
+	server.redirect, server.redirectExpiry = repo.redirectPolicy(repo.name.Name())
+	return &server
+}
+
 // Blobs returns an instance of the BlobStore. Instantiation is cheap and
 // may be context sensitive in the future. The instance should be used similar
 // to a request local.
@@ -320,9 +377,10 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 	return &linkedBlobStore{
 		registry:             repo.registry,
 		blobStore:            repo.blobStore,
-		blobServer:           repo.blobServer,
+		blobServer:           repo.blobServerFor(),
 		blobAccessController: statter,
 		repository:           repo,
+		references:           repo.references,
 		ctx:                  ctx,
 
 		// TODO(stevvooe): linkPath limits this blob store to only layers.
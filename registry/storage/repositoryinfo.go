@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	storageDriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// RepositoryInfo describes a repository.
+type RepositoryInfo struct {
+	// Name is the name of the repository.
+	Name string `json:"name"`
+	// Tags is the number of tags of the repository.
+	Tags int `json:"tags"`
+	// LastTagged is the last time a tag of the repository was pushed, if
+	// the repository has tags.
+	LastTagged *time.Time `json:"lastTagged,omitempty"`
+}
+
+// GetRepositoryInfo returns the description of the named repository.
+func GetRepositoryInfo(ctx context.Context, driver storageDriver.StorageDriver, name reference.Named) (RepositoryInfo, error) {
+	info := RepositoryInfo{Name: name.Name()}
+
+	tags, err := ListTags(ctx, driver, name)
+	if err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); ok {
+			// repositories without tags may still have manifests
+			return info, repositoryExists(ctx, driver, name)
+		}
+		return info, err
+	}
+
+	info.Tags = len(tags)
+	if len(tags) > 0 {
+		info.LastTagged = &tags[0].TaggedAt
+	}
+	return info, nil
+}
+
+// TagInfo describes a tag of a repository.
+type TagInfo struct {
+	// Name is the name of the tag.
+	Name string `json:"name"`
+	// Digest is the digest of the manifest the tag refers to.
+	Digest digest.Digest `json:"digest"`
+	// TaggedAt is the last time the tag was pushed.
+	TaggedAt time.Time `json:"taggedAt"`
+}
+
+// ListTags returns the tags of the named repository, most recently pushed
+// first. It returns a storageDriver.PathNotFoundError if the repository has
+// no tags.
+func ListTags(ctx context.Context, driver storageDriver.StorageDriver, name reference.Named) ([]TagInfo, error) {
+	tagsPath, err := pathFor(manifestTagsPathSpec{name: name.Name()})
+	if err != nil {
+		return nil, err
+	}
+	tags, err := driver.List(ctx, tagsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []TagInfo
+	for _, tag := range tags {
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{name: name.Name(), tag: path.Base(tag)})
+		if err != nil {
+			return nil, err
+		}
+		fi, err := driver.Stat(ctx, currentPath)
+		switch err.(type) {
+		case nil:
+		case storageDriver.PathNotFoundError:
+			// the tag was deleted
+			continue
+		default:
+			return nil, err
+		}
+		content, err := driver.GetContent(ctx, currentPath)
+		switch err.(type) {
+		case nil:
+		case storageDriver.PathNotFoundError:
+			continue
+		default:
+			return nil, err
+		}
+
+		infos = append(infos, TagInfo{
+			Name:     path.Base(tag),
+			Digest:   digest.Digest(strings.TrimSpace(string(content))),
+			TaggedAt: fi.ModTime(),
+		})
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		if !infos[i].TaggedAt.Equal(infos[j].TaggedAt) {
+			return infos[i].TaggedAt.After(infos[j].TaggedAt)
+		}
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+// repositoryExists returns distribution.ErrRepositoryUnknown if the named
+// repository does not exist.
+func repositoryExists(ctx context.Context, driver storageDriver.StorageDriver, name reference.Named) error {
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+	if _, err := driver.Stat(ctx, path.Join(root, name.Name())); err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); ok {
+			return distribution.ErrRepositoryUnknown{Name: name.Name()}
+		}
+		return err
+	}
+	return nil
+}
+
+// TagHistoryEntry describes a manifest a tag refers or referred to.
+type TagHistoryEntry struct {
+	// Digest is the digest of the manifest.
+	Digest digest.Digest `json:"digest"`
+	// TaggedAt is the last time the tag was pushed with the manifest.
+	TaggedAt time.Time `json:"taggedAt"`
+	// Current is true if the tag refers to the manifest.
+	Current bool `json:"current"`
+}
+
+// TagHistory returns the manifests the tag of the named repository referred
+// to, most recently tagged first. The history is read from the index of the
+// tag, and only records the last time the tag was pushed with each manifest.
+func TagHistory(ctx context.Context, driver storageDriver.StorageDriver, name reference.Named, tag string) ([]TagHistoryEntry, error) {
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{name: name.Name(), tag: tag})
+	if err != nil {
+		return nil, err
+	}
+	var current digest.Digest
+	content, err := driver.GetContent(ctx, currentPath)
+	switch err.(type) {
+	case nil:
+		current = digest.Digest(strings.TrimSpace(string(content)))
+	case storageDriver.PathNotFoundError:
+		// untagging deletes the index of the tag along with it
+		return nil, distribution.ErrTagUnknown{Tag: tag}
+	default:
+		return nil, err
+	}
+
+	indexPath, err := pathFor(manifestTagIndexPathSpec{name: name.Name(), tag: tag})
+	if err != nil {
+		return nil, err
+	}
+	algorithms, err := driver.List(ctx, indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []TagHistoryEntry
+	for _, algorithm := range algorithms {
+		revisions, err := driver.List(ctx, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		for _, revision := range revisions {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(algorithm)), path.Base(revision))
+			if err := dgst.Validate(); err != nil {
+				continue
+			}
+			linkPath, err := pathFor(manifestTagIndexEntryLinkPathSpec{name: name.Name(), tag: tag, revision: dgst})
+			if err != nil {
+				return nil, err
+			}
+			fi, err := driver.Stat(ctx, linkPath)
+			if err != nil {
+				if _, ok := err.(storageDriver.PathNotFoundError); ok {
+					continue
+				}
+				return nil, err
+			}
+			history = append(history, TagHistoryEntry{
+				Digest:   dgst,
+				TaggedAt: fi.ModTime(),
+				Current:  dgst == current,
+			})
+		}
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		if !history[i].TaggedAt.Equal(history[j].TaggedAt) {
+			return history[i].TaggedAt.After(history[j].TaggedAt)
+		}
+		return history[i].Current
+	})
+	return history, nil
+}
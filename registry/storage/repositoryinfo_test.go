@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestRepositoryInfoAndTagHistory(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry, err := NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	named, _ := reference.WithName("foo/bar")
+	if _, err := GetRepositoryInfo(ctx, driver, named); err == nil {
+		t.Fatal("expected error describing an unknown repository")
+	} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("unexpected error describing an unknown repository: %v", err)
+	}
+
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	first, second := digest.FromString("first"), digest.FromString("second")
+	for _, tagged := range []struct {
+		tag  string
+		dgst digest.Digest
+	}{
+		{"latest", first},
+		{"v1", first},
+		{"latest", second},
+	} {
+		if err := repo.Tags(ctx).Tag(ctx, tagged.tag, distribution.Descriptor{Digest: tagged.dgst}); err != nil {
+			t.Fatalf("error tagging %s: %v", tagged.tag, err)
+		}
+	}
+
+	info, err := GetRepositoryInfo(ctx, driver, named)
+	if err != nil {
+		t.Fatalf("unexpected error describing repository: %v", err)
+	}
+	if info.Name != "foo/bar" || info.Tags != 2 || info.LastTagged == nil {
+		t.Fatalf("unexpected repository info: %+v", info)
+	}
+
+	tags, err := ListTags(ctx, driver, named)
+	if err != nil {
+		t.Fatalf("unexpected error listing tags: %v", err)
+	}
+	if len(tags) != 2 || tags[0].Name != "latest" || tags[0].Digest != second || tags[1].Name != "v1" || tags[1].Digest != first {
+		t.Fatalf("unexpected tags: %+v", tags)
+	}
+	if !tags[0].TaggedAt.Equal(*info.LastTagged) {
+		t.Fatalf("unexpected last tagged time: %s != %s", *info.LastTagged, tags[0].TaggedAt)
+	}
+
+	history, err := TagHistory(ctx, driver, named, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error reading tag history: %v", err)
+	}
+	if len(history) != 2 || history[0].Digest != second || !history[0].Current || history[1].Digest != first || history[1].Current {
+		t.Fatalf("unexpected tag history: %+v", history)
+	}
+
+	if err := repo.Tags(ctx).Untag(ctx, "v1"); err != nil {
+		t.Fatalf("error untagging: %v", err)
+	}
+	if _, err := TagHistory(ctx, driver, named, "v1"); err == nil {
+		t.Fatal("expected error reading the history of a deleted tag")
+	} else if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		t.Fatalf("unexpected error reading the history of a deleted tag: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// SBOMPackage is a package listed by an SBOM.
+type SBOMPackage struct {
+	Name    string
+	Version string
+}
+
+// SBOMEntry records that an SBOM attached to an image lists a package.
+type SBOMEntry struct {
+	// Subject is the digest of the image described by the SBOM.
+	Subject digest.Digest `json:"subject"`
+	// SBOM is the digest of the manifest of the SBOM artifact.
+	SBOM digest.Digest `json:"sbom"`
+	// Format is the format of the SBOM, such as spdx or cyclonedx.
+	Format string `json:"format"`
+	// Package is the name of the package, as listed by the SBOM.
+	Package string `json:"package"`
+	// Versions are the versions of the package listed by the SBOM.
+	Versions []string `json:"versions"`
+}
+
+// SBOMIndex indexes the images of a repository by the packages listed by the
+// SBOMs attached to them, so that images containing a package can be looked
+// up, such as those affected by a vulnerability.
+type SBOMIndex struct {
+	driver driver.StorageDriver
+	name   string
+}
+
+// NewSBOMIndex returns the SBOM index of the named repository, stored with
+// the given driver.
+func NewSBOMIndex(storageDriver driver.StorageDriver, name reference.Named) *SBOMIndex {
+	return &SBOMIndex{
+		driver: storageDriver,
+		name:   name.Name(),
+	}
+}
+
+// Add indexes the packages listed by the SBOM of the given format, which
+// describes the subject image. Package names are matched case-insensitively.
+func (si *SBOMIndex) Add(ctx context.Context, subject, sbom digest.Digest, format string, packages []SBOMPackage) error {
+	entries := make(map[string]*SBOMEntry)
+	var names []string
+	for _, pkg := range packages {
+		if pkg.Name == "" {
+			continue
+		}
+
+		key := strings.ToLower(pkg.Name)
+		entry, ok := entries[key]
+		if !ok {
+			entry = &SBOMEntry{
+				Subject:  subject,
+				SBOM:     sbom,
+				Format:   format,
+				Package:  pkg.Name,
+				Versions: []string{},
+			}
+			entries[key] = entry
+			names = append(names, key)
+		}
+		if pkg.Version != "" && !containsString(entry.Versions, pkg.Version) {
+			entry.Versions = append(entry.Versions, pkg.Version)
+		}
+	}
+
+	for _, key := range names {
+		entryPath, err := pathFor(sbomIndexEntryPathSpec{
+			name: si.name,
+			pkg:  key,
+			sbom: sbom,
+		})
+		if err != nil {
+			return err
+		}
+
+		entry := entries[key]
+		sort.Strings(entry.Versions)
+		p, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := si.driver.PutContent(ctx, entryPath, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup returns the entries of the SBOMs listing the package, sorted by
+// subject. If version is set, only the entries listing a version of the
+// package equal to it, or starting with it followed by a dot or a dash, are
+// returned, with the matching versions: version 2.14 matches 2.14.1 but not
+// 2.140. The entries may include images or SBOMs which were deleted after
+// they were indexed.
+func (si *SBOMIndex) Lookup(ctx context.Context, pkg, version string) ([]SBOMEntry, error) {
+	root, err := pathFor(sbomIndexPathSpec{
+		name: si.name,
+		pkg:  strings.ToLower(pkg),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []SBOMEntry
+	err = si.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "entry" {
+			return nil
+		}
+
+		content, err := si.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		var entry SBOMEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return err
+		}
+
+		if version != "" {
+			var versions []string
+			for _, v := range entry.Versions {
+				if matchesVersion(v, version) {
+					versions = append(versions, v)
+				}
+			}
+			if len(versions) == 0 {
+				return nil
+			}
+			entry.Versions = versions
+		}
+
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Subject != entries[j].Subject {
+			return entries[i].Subject < entries[j].Subject
+		}
+		return entries[i].SBOM < entries[j].SBOM
+	})
+	return entries, nil
+}
+
+// matchesVersion returns true if the version is the queried version, or one
+// of its patch or pre-release versions.
+func matchesVersion(version, query string) bool {
+	if !strings.HasPrefix(version, query) {
+		return false
+	}
+	rest := version[len(query):]
+	return rest == "" || rest[0] == '.' || rest[0] == '-'
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestSBOMIndex(t *testing.T) {
+	ctx := context.Background()
+	name, _ := reference.WithName("a/b")
+	index := NewSBOMIndex(inmemory.New(), name)
+
+	entries, err := index.Lookup(ctx, "log4j-core", "")
+	if err != nil {
+		t.Fatalf("unexpected error looking up empty index: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("unexpected entries in empty index: %v", entries)
+	}
+
+	first, firstSBOM := digest.FromString("first"), digest.FromString("first sbom")
+	second, secondSBOM := digest.FromString("second"), digest.FromString("second sbom")
+	if err := index.Add(ctx, first, firstSBOM, "spdx", []SBOMPackage{
+		{Name: "log4j-core", Version: "2.14.1"},
+		{Name: "log4j-core", Version: "2.14.1"},
+		{Name: "zlib", Version: "1.2.11"},
+	}); err != nil {
+		t.Fatalf("unexpected error indexing: %v", err)
+	}
+	if err := index.Add(ctx, second, secondSBOM, "cyclonedx", []SBOMPackage{
+		{Name: "Log4j-Core", Version: "2.17.0"},
+		{Name: "log4j-core", Version: "2.140"},
+	}); err != nil {
+		t.Fatalf("unexpected error indexing: %v", err)
+	}
+
+	firstEntry := SBOMEntry{Subject: first, SBOM: firstSBOM, Format: "spdx", Package: "log4j-core", Versions: []string{"2.14.1"}}
+	secondEntry := SBOMEntry{Subject: second, SBOM: secondSBOM, Format: "cyclonedx", Package: "Log4j-Core", Versions: []string{"2.140", "2.17.0"}}
+	for _, tc := range []struct {
+		pkg, version string
+		expected     []SBOMEntry
+	}{
+		{pkg: "log4j-core", expected: sortedEntries(firstEntry, secondEntry)},
+		{pkg: "LOG4J-CORE", version: "2.14", expected: []SBOMEntry{firstEntry}},
+		{pkg: "log4j-core", version: "2.140", expected: []SBOMEntry{{Subject: second, SBOM: secondSBOM, Format: "cyclonedx", Package: "Log4j-Core", Versions: []string{"2.140"}}}},
+		{pkg: "log4j-core", version: "2.15"},
+		{pkg: "zlib", version: "1", expected: []SBOMEntry{{Subject: first, SBOM: firstSBOM, Format: "spdx", Package: "zlib", Versions: []string{"1.2.11"}}}},
+		{pkg: "openssl"},
+	} {
+		entries, err := index.Lookup(ctx, tc.pkg, tc.version)
+		if err != nil {
+			t.Fatalf("unexpected error looking up %s %s: %v", tc.pkg, tc.version, err)
+		}
+		if !reflect.DeepEqual(entries, tc.expected) {
+			t.Errorf("unexpected entries for %s %s: %v != %v", tc.pkg, tc.version, entries, tc.expected)
+		}
+	}
+}
+
+func sortedEntries(a, b SBOMEntry) []SBOMEntry {
+	if a.Subject < b.Subject {
+		return []SBOMEntry{a, b}
+	}
+	return []SBOMEntry{b, a}
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+
+	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
+	prometheus "github.com/docker/distribution/metrics"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/time/rate"
+)
+
+// Outcomes of the verification of a blob by a scrub.
+const (
+	// ScrubVerified is the outcome of a blob matching its digest.
+	ScrubVerified = "verified"
+	// ScrubCorrupted is the outcome of a corrupted blob left in place.
+	ScrubCorrupted = "corrupted"
+	// ScrubRepaired is the outcome of a corrupted blob replaced with the
+	// copy of a replica.
+	ScrubRepaired = "repaired"
+	// ScrubQuarantined is the outcome of a corrupted blob moved out of the
+	// blob store.
+	ScrubQuarantined = "quarantined"
+	// ScrubFailed is the outcome of a blob which could not be read.
+	ScrubFailed = "failed"
+)
+
+// scrubChunkSize is the largest read of a blob, so that reads can be paced
+// by the rate limit.
+const scrubChunkSize = 32 << 10
+
+var (
+	scrubBlobs = prometheus.StorageNamespace.NewLabeledCounter("scrub_blobs", "The number of blobs verified by the scrubber by outcome", "outcome")
+	scrubBytes = prometheus.StorageNamespace.NewCounter("scrub_bytes", "The number of bytes read by the scrubber")
+)
+
+// ScrubOpts contains options for a scrub of the blob store.
+type ScrubOpts struct {
+	// Sample is the fraction of the blobs verified, picked at random. All
+	// blobs are verified if it is not between 0 and 1.
+	Sample float64
+	// Rate limits the bytes read per second, if positive.
+	Rate int64
+	// Quarantine moves the corrupted blobs which are not repaired out of the
+	// blob store, so that they are no longer served.
+	Quarantine bool
+	// Repair, if set, opens a copy of a corrupted blob from a replica, which
+	// replaces the corrupted blob if it matches its digest.
+	Repair func(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error)
+}
+
+// ScrubResult reports the outcome of a scrub.
+type ScrubResult struct {
+	// Blobs counts the blobs verified by outcome.
+	Blobs map[string]int
+	// Bytes is the number of bytes read.
+	Bytes int64
+	// Corrupted lists the corrupted blobs, by outcome.
+	Corrupted map[digest.Digest]string
+}
+
+// Scrub reads a sample of the blobs in storage, verifying that they match
+// their digest, and repairs or quarantines the corrupted blobs.
+func Scrub(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts ScrubOpts) (ScrubResult, error) {
+	result := ScrubResult{
+		Blobs:     make(map[string]int),
+		Corrupted: make(map[digest.Digest]string),
+	}
+
+	// sample the blobs before reading them, as corrupted blobs are moved
+	var sample []digest.Digest
+	err := registry.Blobs().Enumerate(ctx, func(dgst digest.Digest) error {
+		if opts.Sample <= 0 || opts.Sample >= 1 || rand.Float64() < opts.Sample {
+			sample = append(sample, dgst)
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// no blobs stored yet
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("error enumerating blobs: %v", err)
+	}
+
+	limiter := rate.NewLimiter(rate.Inf, scrubChunkSize)
+	if opts.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.Rate), scrubChunkSize)
+	}
+
+	for _, dgst := range sample {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		n, err := verifyBlob(ctx, storageDriver, dgst, limiter)
+		result.Bytes += n
+		scrubBytes.Inc(float64(n))
+
+		outcome := ScrubVerified
+		switch err.(type) {
+		case nil:
+		case driver.PathNotFoundError:
+			// deleted since it was sampled
+			continue
+		case errBlobCorrupted:
+			outcome = scrubCorruptedBlob(ctx, storageDriver, dgst, opts)
+			result.Corrupted[dgst] = outcome
+		default:
+			dcontext.GetLogger(ctx).Errorf("scrub: error reading blob %s: %v", dgst, err)
+			outcome = ScrubFailed
+		}
+
+		result.Blobs[outcome]++
+		scrubBlobs.WithValues(outcome).Inc(1)
+	}
+	return result, nil
+}
+
+// errBlobCorrupted is returned for blobs which do not match their digest.
+type errBlobCorrupted struct {
+	digest digest.Digest
+}
+
+func (err errBlobCorrupted) Error() string {
+	return fmt.Sprintf("blob %s does not match its digest", err.digest)
+}
+
+// verifyBlob reads the blob at the pace of the limiter, returning the number
+// of bytes read and errBlobCorrupted if it does not match its digest.
+func verifyBlob(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest, limiter *rate.Limiter) (int64, error) {
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return 0, err
+	}
+
+	rc, err := storageDriver.Reader(ctx, blobPath, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	verifier := dgst.Verifier()
+	n, err := io.Copy(verifier, &pacedReader{ctx: ctx, reader: rc, limiter: limiter})
+	if err != nil {
+		return n, err
+	}
+	if !verifier.Verified() {
+		return n, errBlobCorrupted{digest: dgst}
+	}
+	return n, nil
+}
+
+// scrubCorruptedBlob repairs the corrupted blob from a replica if possible,
+// quarantining it otherwise if configured, and returns the outcome.
+func scrubCorruptedBlob(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest, opts ScrubOpts) string {
+	logger := dcontext.GetLoggerWithField(ctx, "digest", dgst)
+	logger.Errorf("scrub: blob %s does not match its digest", dgst)
+
+	if opts.Repair != nil {
+		err := repairBlob(ctx, storageDriver, dgst, opts)
+		if err == nil {
+			logger.Infof("scrub: repaired blob %s from a replica", dgst)
+			return ScrubRepaired
+		}
+		logger.Errorf("scrub: error repairing blob %s: %v", dgst, err)
+	}
+
+	if opts.Quarantine {
+		err := quarantineBlob(ctx, storageDriver, dgst)
+		if err == nil {
+			logger.Warnf("scrub: quarantined blob %s", dgst)
+			return ScrubQuarantined
+		}
+		logger.Errorf("scrub: error quarantining blob %s: %v", dgst, err)
+	}
+	return ScrubCorrupted
+}
+
+// repairBlob replaces the corrupted blob with the copy of a replica, once the
+// copy is stored and verified. The corrupted blob is quarantined if
+// configured.
+func repairBlob(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest, opts ScrubOpts) error {
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	quarantinePath, err := pathFor(blobQuarantinePathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	repairPath := path.Join(quarantinePath, "repair")
+
+	rc, err := opts.Repair(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := storageDriver.Writer(ctx, repairPath, false)
+	if err != nil {
+		return err
+	}
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(io.MultiWriter(fw, verifier), rc); err != nil {
+		fw.Cancel(ctx)
+		return err
+	}
+	if !verifier.Verified() {
+		fw.Cancel(ctx)
+		return fmt.Errorf("the copy of the replica does not match its digest")
+	}
+	if err := fw.Commit(); err != nil {
+		fw.Cancel(ctx)
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	if opts.Quarantine {
+		if err := storageDriver.Move(ctx, blobPath, path.Join(quarantinePath, "data")); err != nil {
+			storageDriver.Delete(ctx, repairPath)
+			return err
+		}
+	}
+	return storageDriver.Move(ctx, repairPath, blobPath)
+}
+
+// quarantineBlob moves the corrupted blob out of the blob store.
+func quarantineBlob(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest) error {
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	quarantinePath, err := pathFor(blobQuarantinePathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	return storageDriver.Move(ctx, blobPath, path.Join(quarantinePath, "data"))
+}
+
+// pacedReader paces the reads of a reader with a rate limiter.
+type pacedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path"
+	"testing"
+
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "scrub")
+
+	contents := map[string][]byte{
+		"intact":      []byte("intact content"),
+		"repaired":    []byte("repaired content"),
+		"quarantined": []byte("quarantined content"),
+	}
+	dgsts := make(map[string]digest.Digest)
+	for name, content := range contents {
+		desc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", content)
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+		dgsts[name] = desc.Digest
+	}
+
+	dataPath := func(dgst digest.Digest) string {
+		p, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	for _, name := range []string{"repaired", "quarantined"} {
+		if err := d.PutContent(ctx, dataPath(dgsts[name]), []byte("corrupted")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := Scrub(ctx, d, registry, ScrubOpts{
+		Quarantine: true,
+		Repair: func(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+			if dgst != dgsts["repaired"] {
+				return nil, errors.New("no replica")
+			}
+			return io.NopCloser(bytes.NewReader(contents["repaired"])), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error scrubbing: %v", err)
+	}
+
+	for outcome, count := range map[string]int{
+		ScrubVerified:    1,
+		ScrubRepaired:    1,
+		ScrubQuarantined: 1,
+	} {
+		if result.Blobs[outcome] != count {
+			t.Fatalf("unexpected number of %s blobs: %d != %d", outcome, result.Blobs[outcome], count)
+		}
+	}
+	if outcome := result.Corrupted[dgsts["quarantined"]]; outcome != ScrubQuarantined {
+		t.Fatalf("unexpected outcome for the quarantined blob: %q", outcome)
+	}
+
+	content, err := d.GetContent(ctx, dataPath(dgsts["repaired"]))
+	if err != nil || !bytes.Equal(content, contents["repaired"]) {
+		t.Fatalf("expected the blob to be repaired: %q, %v", content, err)
+	}
+
+	if _, err := d.Stat(ctx, dataPath(dgsts["quarantined"])); !errors.As(err, &driver.PathNotFoundError{}) {
+		t.Fatalf("expected the blob to be moved out of the blob store: %v", err)
+	}
+	quarantinePath, err := pathFor(blobQuarantinePathSpec{digest: dgsts["quarantined"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err = d.GetContent(ctx, path.Join(quarantinePath, "data"))
+	if err != nil || string(content) != "corrupted" {
+		t.Fatalf("expected the blob to be quarantined: %q, %v", content, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sort"
 
 	"github.com/docker/distribution"
+	dcontext "github.com/docker/distribution/context"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
 )
@@ -24,6 +25,10 @@ type tagStore struct {
 
 // All returns all tags
 func (ts *tagStore) All(ctx context.Context) ([]string, error) {
+	if ts.repository.metadata.ready() {
+		return ts.repository.metadata.Tags(ts.repository.Named().Name())
+	}
+
 	var tags []string
 
 	pathSpec, err := pathFor(manifestTagPathSpec{
@@ -58,6 +63,9 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 // Tag tags the digest with the given tag, updating the the store to point at
 // the current tag. The digest must point to a manifest.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("tagStore.Tag(%q, %q, %q)", ts.repository.Named().Name(), tag, desc.Digest)
+
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
 		name: ts.repository.Named().Name(),
 		tag:  tag,
@@ -74,11 +82,20 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 	}
 
 	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	if err := ts.blobStore.link(ctx, currentPath, desc.Digest); err != nil {
+		return err
+	}
+	if ts.repository.metadata != nil {
+		return ts.repository.metadata.Tag(ts.repository.Named().Name(), tag, desc.Digest)
+	}
+	return nil
 }
 
 // resolve the current revision for name and tag.
 func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("tagStore.Get(%q, %q)", ts.repository.Named().Name(), tag)
+
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
 		name: ts.repository.Named().Name(),
 		tag:  tag,
@@ -102,6 +119,9 @@ func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descripto
 
 // Untag removes the tag association
 func (ts *tagStore) Untag(ctx context.Context, tag string) error {
+	ctx, done := dcontext.WithTrace(ctx)
+	defer done("tagStore.Untag(%q, %q)", ts.repository.Named().Name(), tag)
+
 	tagPath, err := pathFor(manifestTagPathSpec{
 		name: ts.repository.Named().Name(),
 		tag:  tag,
@@ -110,7 +130,13 @@ func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 		return err
 	}
 
-	return ts.blobStore.driver.Delete(ctx, tagPath)
+	if err := ts.blobStore.driver.Delete(ctx, tagPath); err != nil {
+		return err
+	}
+	if ts.repository.metadata != nil {
+		return ts.repository.metadata.Untag(ts.repository.Named().Name(), tag)
+	}
+	return nil
 }
 
 // linkedBlobStore returns the linkedBlobStore for the named tag, allowing one
@@ -121,6 +147,7 @@ func (ts *tagStore) linkedBlobStore(ctx context.Context, tag string) *linkedBlob
 	return &linkedBlobStore{
 		blobStore:  ts.blobStore,
 		repository: ts.repository,
+		references: ts.repository.references,
 		ctx:        ctx,
 		linkPath: func(name string, dgst digest.Digest) (string, error) {
 			return pathFor(manifestTagIndexEntryLinkPathSpec{
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	storageDriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// UploadSession describes the state of an in-progress blob upload.
+type UploadSession struct {
+	// Repository is the name of the repository the blob is uploaded to.
+	Repository string `json:"repository"`
+	// ID is the UUID of the upload.
+	ID string `json:"id"`
+	// Size is the number of bytes received so far.
+	Size int64 `json:"size"`
+	// StartedAt is the time the upload was started.
+	StartedAt time.Time `json:"startedAt"`
+	// LastActivity is the last time data was received.
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+// GetUploadSession returns the state of the upload with the given id in the
+// named repository.
+func GetUploadSession(ctx context.Context, driver storageDriver.StorageDriver, name, id string) (UploadSession, error) {
+	session := UploadSession{
+		Repository: name,
+		ID:         id,
+	}
+
+	startedAtPath, err := pathFor(uploadStartedAtPathSpec{name: name, id: id})
+	if err != nil {
+		return session, err
+	}
+	session.StartedAt, err = readStartedAtFile(driver, startedAtPath)
+	if err != nil {
+		return session, err
+	}
+
+	dataPath, err := pathFor(uploadDataPathSpec{name: name, id: id})
+	if err != nil {
+		return session, err
+	}
+	fi, err := driver.Stat(ctx, dataPath)
+	switch err.(type) {
+	case nil:
+		session.Size = fi.Size()
+		session.LastActivity = fi.ModTime()
+	case storageDriver.PathNotFoundError:
+		// no data was received yet
+		session.LastActivity = session.StartedAt
+	default:
+		return session, err
+	}
+
+	return session, nil
+}
+
+// UploadSessions returns the state of all in-progress blob uploads, sorted by
+// repository and id. Errors reading individual uploads are returned along
+// with the uploads which could be read.
+func UploadSessions(ctx context.Context, driver storageDriver.StorageDriver) ([]UploadSession, []error) {
+	var (
+		sessions []UploadSession
+		errors   []error
+	)
+
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return nil, append(errors, err)
+	}
+
+	err = driver.Walk(ctx, root, func(fileInfo storageDriver.FileInfo) error {
+		if !fileInfo.IsDir() {
+			return nil
+		}
+
+		dir, file := path.Split(fileInfo.Path())
+		if file[0] != '_' {
+			return nil
+		}
+		if file != "_uploads" {
+			return storageDriver.ErrSkipDir
+		}
+
+		name := strings.TrimPrefix(strings.TrimSuffix(dir, "/"), root+"/")
+		ids, err := driver.List(ctx, fileInfo.Path())
+		if err != nil {
+			errors = pushError(errors, fileInfo.Path(), err)
+			return storageDriver.ErrSkipDir
+		}
+		for _, id := range ids {
+			session, err := GetUploadSession(ctx, driver, name, path.Base(id))
+			if err != nil {
+				errors = pushError(errors, id, err)
+				continue
+			}
+			sessions = append(sessions, session)
+		}
+		return storageDriver.ErrSkipDir
+	})
+	if err != nil {
+		if _, ok := err.(storageDriver.PathNotFoundError); !ok {
+			errors = pushError(errors, root, err)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Repository != sessions[j].Repository {
+			return sessions[i].Repository < sessions[j].Repository
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, errors
+}
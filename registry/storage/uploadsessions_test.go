@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestUploadSessions(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	registry, err := NewRegistry(ctx, driver)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	sessions, errs := UploadSessions(ctx, driver)
+	if len(errs) != 0 || len(sessions) != 0 {
+		t.Fatalf("unexpected sessions in empty registry: %v, %v", sessions, errs)
+	}
+
+	var ids []string
+	for _, name := range []string{"foo/bar", "foo"} {
+		named, _ := reference.WithName(name)
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+
+		bw, err := repo.Blobs(ctx).Create(ctx)
+		if err != nil {
+			t.Fatalf("error starting upload: %v", err)
+		}
+		if _, err := bw.Write([]byte("some data")); err != nil {
+			t.Fatalf("error writing upload: %v", err)
+		}
+		if err := bw.Close(); err != nil {
+			t.Fatalf("error closing upload: %v", err)
+		}
+		ids = append(ids, bw.ID())
+	}
+
+	sessions, errs = UploadSessions(ctx, driver)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors listing sessions: %v", errs)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("unexpected number of sessions: %v", sessions)
+	}
+
+	// sessions are sorted by repository
+	for i, expected := range []struct {
+		repository, id string
+	}{
+		{"foo", ids[1]},
+		{"foo/bar", ids[0]},
+	} {
+		session := sessions[i]
+		if session.Repository != expected.repository || session.ID != expected.id {
+			t.Errorf("unexpected session %d: %+v", i, session)
+		}
+		if session.Size != int64(len("some data")) {
+			t.Errorf("unexpected size of session %d: %d", i, session.Size)
+		}
+		if session.StartedAt.IsZero() || session.LastActivity.Before(session.StartedAt.Add(-time.Second)) {
+			t.Errorf("unexpected times of session %d: %+v", i, session)
+		}
+	}
+}
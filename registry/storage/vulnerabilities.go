@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// Severities of the vulnerabilities reported by scanners, from the highest.
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+	SeverityUnknown  = "unknown"
+)
+
+// severityRanks ranks the severities, from the highest.
+var severityRanks = map[string]int{
+	SeverityCritical: 4,
+	SeverityHigh:     3,
+	SeverityMedium:   2,
+	SeverityLow:      1,
+	SeverityUnknown:  0,
+}
+
+// NormalizeSeverity returns the severity reported by a scanner as one of the
+// known severities, ignoring case. Negligible vulnerabilities are low ones,
+// and other severities are unknown.
+func NormalizeSeverity(severity string) string {
+	severity = strings.ToLower(severity)
+	if severity == "negligible" {
+		return SeverityLow
+	}
+	if _, ok := severityRanks[severity]; ok {
+		return severity
+	}
+	return SeverityUnknown
+}
+
+// SeverityAtLeast returns true if the severity is at least the threshold.
+// Unknown severities never reach a known threshold.
+func SeverityAtLeast(severity, threshold string) bool {
+	if severity == SeverityUnknown {
+		return false
+	}
+	return severityRanks[severity] >= severityRanks[threshold]
+}
+
+// VulnerabilitySummary summarizes the vulnerability report of an image.
+type VulnerabilitySummary struct {
+	// Subject is the digest of the image scanned.
+	Subject digest.Digest `json:"subject"`
+	// Report is the digest of the manifest of the report artifact.
+	Report digest.Digest `json:"report"`
+	// Scanner is the scanner which produced the report, such as trivy.
+	Scanner string `json:"scanner"`
+	// Created is the time the report was pushed.
+	Created time.Time `json:"created"`
+	// Severities counts the vulnerabilities by severity.
+	Severities map[string]int `json:"severities"`
+}
+
+// Highest returns the highest severity of the vulnerabilities reported, or
+// an empty string if none were.
+func (s VulnerabilitySummary) Highest() string {
+	var highest string
+	for severity, count := range s.Severities {
+		if count == 0 {
+			continue
+		}
+		if highest == "" || severityRanks[severity] > severityRanks[highest] {
+			highest = severity
+		}
+	}
+	return highest
+}
+
+// VulnerabilityStore stores the summaries of the vulnerability reports of
+// the images of a repository. Only the latest report of an image is kept.
+type VulnerabilityStore struct {
+	driver driver.StorageDriver
+	name   string
+}
+
+// NewVulnerabilityStore returns the vulnerability store of the named
+// repository, stored with the given driver.
+func NewVulnerabilityStore(storageDriver driver.StorageDriver, name reference.Named) *VulnerabilityStore {
+	return &VulnerabilityStore{
+		driver: storageDriver,
+		name:   name.Name(),
+	}
+}
+
+// Put stores the summary of the latest report of its subject.
+func (vs *VulnerabilityStore) Put(ctx context.Context, summary VulnerabilitySummary) error {
+	summaryPath, err := pathFor(vulnerabilitySummaryPathSpec{
+		name:     vs.name,
+		revision: summary.Subject,
+	})
+	if err != nil {
+		return err
+	}
+
+	p, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return vs.driver.PutContent(ctx, summaryPath, p)
+}
+
+// Get returns the summary of the latest report of the image, and false if
+// the image has no report.
+func (vs *VulnerabilityStore) Get(ctx context.Context, revision digest.Digest) (VulnerabilitySummary, bool, error) {
+	var summary VulnerabilitySummary
+	summaryPath, err := pathFor(vulnerabilitySummaryPathSpec{
+		name:     vs.name,
+		revision: revision,
+	})
+	if err != nil {
+		return summary, false, err
+	}
+
+	p, err := vs.driver.GetContent(ctx, summaryPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return summary, false, nil
+		}
+		return summary, false, err
+	}
+	if err := json.Unmarshal(p, &summary); err != nil {
+		return summary, false, err
+	}
+	return summary, true, nil
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/docker/distribution/registry/replication"
+	"github.com/spf13/cobra"
+)
+
+var (
+	syncOptions replication.SyncOptions
+	syncTags    string
+)
+
+// SyncCmd is the cobra command that corresponds to the sync subcommand
+var SyncCmd = &cobra.Command{
+	Use:   "sync <config>",
+	Short: "`sync` mirrors repositories of a remote registry into the storage",
+	Long: "`sync` copies the selected repositories and tags of the registry given with " +
+		"--from into the storage of the configured registry. Tags already pointing to the " +
+		"remote manifest and blobs already stored are skipped, so that repeated runs only " +
+		"copy what changed",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if syncOptions.URL == "" {
+			fmt.Fprintln(os.Stderr, "the registry to synchronize from is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		opts := syncOptions
+		if syncTags != "" {
+			opts.Tags, err = regexp.Compile(syncTags)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid tag expression: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		ctx, registry, closeRegistry := openStorageRegistry(config, true)
+		defer closeRegistry()
+
+		result, err := replication.Sync(ctx, registry, opts)
+		verb := "synchronized"
+		if opts.DryRun {
+			verb = "would synchronize"
+		}
+		fmt.Printf("%s %d tags of %d repositories (%d unchanged), copying %d blobs\n",
+			verb, result.Synced, result.Repositories, result.Unchanged, result.Blobs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to synchronize: %v\n", err)
+			os.Exit(1)
+		}
+		if result.Failed > 0 {
+			fmt.Fprintf(os.Stderr, "failed to synchronize %d tags\n", result.Failed)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/tiered"
+	"github.com/spf13/cobra"
+)
+
+var tierDryRun bool
+
+// TierCmd is the cobra command grouping the tiered storage subcommands
+var TierCmd = &cobra.Command{
+	Use:   "tier",
+	Short: "`tier` manages the tiers of the tiered storage driver",
+	Long:  "`tier` manages the tiers of the tiered storage driver",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Usage()
+	},
+}
+
+// TierMigrateCmd is the cobra command that corresponds to the tier migrate
+// subcommand
+var TierMigrateCmd = &cobra.Command{
+	Use:   "migrate <config>",
+	Short: "`migrate` moves unaccessed blobs to the cold tier",
+	Long: "`migrate` moves the blobs which were not accessed for longer than the " +
+		"migrateafter parameter of the tiered storage driver from the hot tier to the cold tier",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if config.Storage.Type() != "tiered" {
+			fmt.Fprintf(os.Stderr, "the registry is configured with the %s storage driver, not tiered\n", config.Storage.Type())
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		// the driver is constructed without migrating periodically
+		params, err := tiered.NewParameters(config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct tiered driver: %v\n", err)
+			os.Exit(1)
+		}
+		result, err := tiered.New(params).Migrate(ctx, tierDryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate blobs: %v\n", err)
+			os.Exit(1)
+		}
+
+		verb := "migrated"
+		if tierDryRun {
+			verb = "would migrate"
+		}
+		fmt.Printf("%s %d blobs (%d bytes) to the cold tier\n", verb, result.Blobs, result.Bytes)
+		if result.Failed > 0 {
+			fmt.Fprintf(os.Stderr, "failed to migrate %d blobs\n", result.Failed)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/gorilla/mux"
+)
+
+// connContextKey is the context key under which the connection of a request
+// is stored.
+type connContextKey struct{}
+
+// withConn stores the connection in the context of the requests served on
+// it, allowing deadlines to be set per request.
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// routeTimeouts applies the configured read and write timeouts of the
+// route class a request belongs to.
+type routeTimeouts struct {
+	handler  http.Handler
+	router   *mux.Router
+	defaults configuration.RouteTimeouts
+	blobs    configuration.RouteTimeouts
+}
+
+// hasRouteTimeouts returns true if any per-route timeout is configured.
+func hasRouteTimeouts(config *configuration.Configuration) bool {
+	timeouts := config.HTTP.Timeouts
+	return timeouts.Default != (configuration.RouteTimeouts{}) || timeouts.Blobs != (configuration.RouteTimeouts{})
+}
+
+// applyRouteTimeouts wraps the handler to set per-route deadlines on the
+// connection of each request. The server must be configured to store the
+// connection in the request context with withConn.
+func applyRouteTimeouts(config *configuration.Configuration, handler http.Handler) http.Handler {
+	if !hasRouteTimeouts(config) {
+		return handler
+	}
+
+	return &routeTimeouts{
+		handler:  handler,
+		router:   v2.RouterWithPrefix(config.HTTP.Prefix),
+		defaults: config.HTTP.Timeouts.Default,
+		blobs:    config.HTTP.Timeouts.Blobs,
+	}
+}
+
+func (rt *routeTimeouts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Deadlines are set on the connection, which is shared by all streams
+	// of an HTTP/2 connection, so they are only applied to HTTP/1.x.
+	conn, ok := r.Context().Value(connContextKey{}).(net.Conn)
+	if ok && r.ProtoMajor == 1 {
+		timeouts := rt.timeouts(r)
+		now := time.Now()
+		conn.SetReadDeadline(deadline(now, timeouts.Read))
+		conn.SetWriteDeadline(deadline(now, timeouts.Write))
+	}
+
+	rt.handler.ServeHTTP(w, r)
+}
+
+// timeouts returns the timeouts of the route class of the request.
+func (rt *routeTimeouts) timeouts(r *http.Request) configuration.RouteTimeouts {
+	var match mux.RouteMatch
+	if rt.router.Match(r, &match) && match.Route != nil {
+		switch match.Route.GetName() {
+		case v2.RouteNameBlob, v2.RouteNameBlobUpload, v2.RouteNameBlobUploadChunk:
+			return rt.blobs
+		}
+	}
+
+	return rt.defaults
+}
+
+// deadline returns the deadline for a timeout starting now, or the zero time
+// if the timeout is disabled.
+func deadline(now time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/configuration"
+)
+
+// deadlineConn records the deadlines set on it.
+type deadlineConn struct {
+	net.Conn
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline = t
+	return nil
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return nil
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Timeouts.Default.Read = 10 * time.Second
+	config.HTTP.Timeouts.Default.Write = 20 * time.Second
+	config.HTTP.Timeouts.Blobs.Write = time.Hour
+
+	handler := applyRouteTimeouts(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		path  string
+		read  time.Duration
+		write time.Duration
+	}{
+		{path: "/v2/", read: 10 * time.Second, write: 20 * time.Second},
+		{path: "/v2/foo/bar/manifests/latest", read: 10 * time.Second, write: 20 * time.Second},
+		{path: "/v2/foo/bar/blobs/sha256:abc", write: time.Hour},
+		{path: "/v2/foo/bar/blobs/uploads/", write: time.Hour},
+		{path: "/v2/foo/bar/blobs/uploads/some-uuid", write: time.Hour},
+	} {
+		conn := &deadlineConn{}
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req = req.WithContext(withConn(req.Context(), conn))
+
+		start := time.Now()
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		checkDeadline(t, tc.path, "read", start, conn.readDeadline, tc.read)
+		checkDeadline(t, tc.path, "write", start, conn.writeDeadline, tc.write)
+	}
+}
+
+func checkDeadline(t *testing.T, path, kind string, start, deadline time.Time, timeout time.Duration) {
+	t.Helper()
+
+	if timeout == 0 {
+		if !deadline.IsZero() {
+			t.Errorf("%s: expected no %s deadline, got %v", path, kind, deadline)
+		}
+		return
+	}
+
+	if deadline.Before(start.Add(timeout)) || deadline.After(time.Now().Add(timeout)) {
+		t.Errorf("%s: unexpected %s deadline %v for timeout %v", path, kind, deadline, timeout)
+	}
+}
@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// defaultTracingServiceName is the service name reported in traces if none
+// is configured.
+const defaultTracingServiceName = "registry"
+
+// configureTracing installs a global OpenTelemetry tracer provider exporting
+// spans to the configured OTLP collector, and the W3C trace context
+// propagator. The returned function flushes and stops the exporter. If
+// tracing is disabled, spans are not recorded, but the trace context of
+// incoming requests is still propagated.
+func configureTracing(ctx context.Context, config *configuration.Configuration) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !config.Tracing.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var options []otlptracehttp.Option
+	if config.Tracing.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(config.Tracing.Endpoint))
+	}
+	if config.Tracing.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if len(config.Tracing.Headers) != 0 {
+		options = append(options, otlptracehttp.WithHeaders(config.Tracing.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP trace exporter: %v", err)
+	}
+
+	res, err := telemetryResource(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating tracing resource: %v", err)
+	}
+
+	samplingRatio := 1.0
+	if config.Tracing.SamplingRatio != nil {
+		samplingRatio = *config.Tracing.SamplingRatio
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplingRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// telemetryResource describes the registry in exported traces and metrics.
+func telemetryResource(config *configuration.Configuration) (*resource.Resource, error) {
+	serviceName := config.Tracing.ServiceName
+	if serviceName == "" {
+		serviceName = defaultTracingServiceName
+	}
+	return resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(version.Version),
+	))
+}
@@ -0,0 +1,14 @@
+package transparency
+
+import (
+	prometheus "github.com/docker/distribution/metrics"
+	"github.com/docker/go-metrics"
+)
+
+// transparencyEntries counts the pushes logged to the transparency log by
+// status.
+var transparencyEntries = prometheus.TransparencyNamespace.NewLabeledCounter("entries", "The number of pushes logged to the transparency log", "status")
+
+func init() {
+	metrics.Register(prometheus.TransparencyNamespace)
+}
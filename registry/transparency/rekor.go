@@ -0,0 +1,173 @@
+package transparency
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/version"
+)
+
+// entriesPath is the path of the log entries in the Rekor API.
+const entriesPath = "/api/v1/log/entries"
+
+// rekorClient submits entries to a Rekor transparency log.
+type rekorClient struct {
+	url    string
+	client *http.Client
+}
+
+func newRekorClient(rawURL string) *rekorClient {
+	return &rekorClient{
+		url:    strings.TrimSuffix(rawURL, "/"),
+		client: &http.Client{},
+	}
+}
+
+// logEntry is an entry of the transparency log, with its inclusion proof.
+type logEntry struct {
+	LogIndex       int64 `json:"logIndex"`
+	IntegratedTime int64 `json:"integratedTime"`
+
+	uuid string
+	raw  []byte
+}
+
+// rekorError is an unexpected response of the transparency log.
+type rekorError struct {
+	statusCode int
+	message    string
+}
+
+func (err rekorError) Error() string {
+	return fmt.Sprintf("rekor: unexpected status %d: %s", err.statusCode, err.message)
+}
+
+// submit logs a hashedrekord entry of the signed hash, returning the logged
+// entry. An entry already logged is returned as is.
+func (c *rekorClient) submit(ctx context.Context, hash, signature, publicKey []byte) (*logEntry, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"data": map[string]interface{}{
+				"hash": map[string]string{
+					"algorithm": "sha256",
+					"value":     hex.EncodeToString(hash),
+				},
+			},
+			"signature": map[string]interface{}{
+				"content": base64.StdEncoding.EncodeToString(signature),
+				"publicKey": map[string]string{
+					"content": base64.StdEncoding.EncodeToString(publicKey),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+entriesPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusOK:
+		return readEntry(resp.Body)
+	case http.StatusConflict:
+		// the entry was logged by a previous attempt
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return nil, rekorError{statusCode: resp.StatusCode, message: "conflicting entry without location"}
+		}
+		return c.get(ctx, location)
+	default:
+		return nil, newRekorError(resp)
+	}
+}
+
+// get fetches the logged entry at the location.
+func (c *rekorClient) get(ctx context.Context, location string) (*logEntry, error) {
+	if strings.HasPrefix(location, "/") {
+		location = c.url + location
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newRekorError(resp)
+	}
+	return readEntry(resp.Body)
+}
+
+func (c *rekorClient) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "distribution/"+version.Version)
+	return c.client.Do(req)
+}
+
+// readEntry reads the entries returned by Rekor, keyed by UUID, which hold
+// the single entry submitted or fetched.
+func readEntry(r io.Reader) (*logEntry, error) {
+	var entries map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("rekor: invalid response: %v", err)
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("rekor: expected a single entry, got %d", len(entries))
+	}
+
+	for uuid, raw := range entries {
+		entry := &logEntry{uuid: uuid, raw: raw}
+		if err := json.Unmarshal(raw, entry); err != nil {
+			return nil, fmt.Errorf("rekor: invalid entry: %v", err)
+		}
+		return entry, nil
+	}
+	panic("unreachable")
+}
+
+func newRekorError(resp *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err := json.Unmarshal(b, &body); err != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(b))
+	}
+	return rekorError{statusCode: resp.StatusCode, message: body.Message}
+}
+
+// retryable returns true if the error may be transient, such as network
+// errors and server errors of the transparency log.
+func retryable(err error) bool {
+	var rerr rekorError
+	if errors.As(err, &rerr) {
+		return rerr.statusCode >= http.StatusInternalServerError || rerr.statusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
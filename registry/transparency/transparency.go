@@ -0,0 +1,348 @@
+// Package transparency submits an entry to a Rekor transparency log for each
+// manifest pushed to the registry, giving tamper-evident provenance for its
+// content. Pushes are read from the notification events of the registry, and
+// the logged entries and their inclusion proofs are stored in the repository
+// as artifacts referring to the pushed manifests.
+package transparency
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	dcontext "github.com/docker/distribution/context"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/notifications"
+	"github.com/docker/distribution/reference"
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// MediaTypeStatement is the media type of the statement logged for a
+	// push, stored as the config of the artifact holding its proof.
+	MediaTypeStatement = "application/vnd.distribution.transparency.statement.v1+json"
+
+	// MediaTypeRekorEntry is the media type of a Rekor log entry, including
+	// its inclusion proof, stored as the layer of the artifact holding the
+	// proof of a push.
+	MediaTypeRekorEntry = "application/vnd.dev.sigstore.rekor.entry.v1+json"
+
+	// TagSuffix suffixes the tags of the artifacts holding the proofs, named
+	// after the digest of the manifest they refer to.
+	TagSuffix = ".rekor"
+)
+
+// Annotations of the artifacts holding the proofs.
+const (
+	AnnotationRekorURL      = "dev.sigstore.rekor.url"
+	AnnotationRekorUUID     = "dev.sigstore.rekor.uuid"
+	AnnotationRekorLogIndex = "dev.sigstore.rekor.logIndex"
+)
+
+const (
+	defaultTimeout   = time.Minute
+	defaultThreshold = 10
+	defaultBackoff   = time.Second
+)
+
+// Statement is the statement signed by the registry and logged for a push.
+type Statement struct {
+	// Digest is the digest of the pushed manifest.
+	Digest digest.Digest `json:"digest"`
+	// Repository is the repository the manifest was pushed to.
+	Repository string `json:"repository"`
+	// Identity is the name of the authenticated user who pushed the
+	// manifest, if any.
+	Identity string `json:"identity,omitempty"`
+	// Timestamp is the time of the push.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ProofTag returns the tag of the artifact holding the proof of the push of
+// the manifest.
+func ProofTag(dgst digest.Digest) string {
+	return dgst.Algorithm().String() + "-" + dgst.Encoded() + TagSuffix
+}
+
+// logger logs pushes to the transparency log. Pushes which fail with a
+// transient error are retried by the retrying sink wrapping the logger.
+type logger struct {
+	rekor        *rekorClient
+	key          *ecdsa.PrivateKey
+	publicKey    []byte
+	repositories []string
+	timeout      time.Duration
+
+	ctx   context.Context
+	local distribution.Namespace
+}
+
+// Sink logs the pushes it receives to the transparency log. Pushes are
+// queued and logged in the background, retrying on failure.
+type Sink struct {
+	logger *logger
+	queue  events.Sink
+}
+
+// NewSink returns a sink logging pushes to the configured transparency log,
+// storing the proofs in the local namespace.
+func NewSink(ctx context.Context, local distribution.Namespace, config configuration.Transparency) (*Sink, error) {
+	if _, err := url.Parse(config.Rekor); err != nil || config.Rekor == "" {
+		return nil, fmt.Errorf("transparency: invalid rekor url %q", config.Rekor)
+	}
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("transparency: invalid repository pattern %q", pattern)
+		}
+	}
+
+	key, err := loadSigningKey(config.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("transparency: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("transparency: %v", err)
+	}
+
+	l := &logger{
+		rekor:        newRekorClient(config.Rekor),
+		key:          key,
+		publicKey:    pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}),
+		repositories: config.Repositories,
+		timeout:      config.Timeout,
+		ctx:          ctx,
+		local:        local,
+	}
+	if l.timeout <= 0 {
+		l.timeout = defaultTimeout
+	}
+
+	threshold := config.Threshold
+	if threshold <= 0 {
+		threshold = defaultThreshold
+	}
+	backoff := config.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	return &Sink{
+		logger: l,
+		queue:  events.NewQueue(events.NewRetryingSink(l, events.NewBreaker(threshold, backoff))),
+	}, nil
+}
+
+// Write queues the event for logging if it describes the push of a logged
+// manifest.
+func (s *Sink) Write(event events.Event) error {
+	e, ok := event.(notifications.Event)
+	if !ok || !s.logger.logs(e) {
+		return nil
+	}
+	return s.queue.Write(e)
+}
+
+// Close stops logging, once the queued pushes are logged.
+func (s *Sink) Close() error {
+	return s.queue.Close()
+}
+
+// logs returns true if the push described by the event is logged.
+func (l *logger) logs(e notifications.Event) bool {
+	target := e.Target
+	if e.Action != notifications.EventActionPush || !isManifest(target.MediaType) {
+		return false
+	}
+	if strings.HasSuffix(target.Tag, TagSuffix) {
+		// the proof of another push
+		return false
+	}
+	if len(l.repositories) == 0 {
+		return true
+	}
+	for _, pattern := range l.repositories {
+		if ok, _ := path.Match(pattern, target.Repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Write logs the push described by the event. Only transient errors are
+// returned, so that the push is retried.
+func (l *logger) Write(event events.Event) error {
+	e := event.(notifications.Event)
+	target := e.Target
+
+	log := dcontext.GetLoggerWithFields(l.ctx, map[interface{}]interface{}{
+		"transparency.repository": target.Repository,
+		"transparency.digest":     target.Digest,
+	})
+
+	ctx, cancel := context.WithTimeout(l.ctx, l.timeout)
+	defer cancel()
+
+	err := l.log(ctx, e)
+	switch {
+	case err == nil:
+		transparencyEntries.WithValues("success").Inc(1)
+		log.Debug("logged push to transparency log")
+		return nil
+	case retryable(err):
+		transparencyEntries.WithValues("retry").Inc(1)
+		log.Warnf("error logging push to transparency log, retrying: %v", err)
+		return err
+	default:
+		transparencyEntries.WithValues("failure").Inc(1)
+		log.Errorf("error logging push to transparency log: %v", err)
+		return nil
+	}
+}
+
+// Close implements events.Sink.
+func (l *logger) Close() error {
+	return nil
+}
+
+// log signs the statement of the push, submits it to the transparency log
+// and stores the logged entry as an artifact referring to the manifest.
+func (l *logger) log(ctx context.Context, e notifications.Event) error {
+	target := e.Target
+	payload, err := json.Marshal(Statement{
+		Digest:     target.Digest,
+		Repository: target.Repository,
+		Identity:   e.Actor.Name,
+		Timestamp:  e.Timestamp.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, l.key, hash[:])
+	if err != nil {
+		return err
+	}
+
+	entry, err := l.rekor.submit(ctx, hash[:], signature, l.publicKey)
+	if err != nil {
+		return err
+	}
+
+	return l.store(ctx, target.Repository, distribution.Descriptor{
+		MediaType: target.MediaType,
+		Size:      target.Size,
+		Digest:    target.Digest,
+	}, payload, entry)
+}
+
+// store stores the statement and the logged entry in the repository, as an
+// artifact referring to the manifest, tagged after its digest.
+func (l *logger) store(ctx context.Context, repository string, subject distribution.Descriptor, payload []byte, entry *logEntry) error {
+	named, err := reference.WithName(repository)
+	if err != nil {
+		return err
+	}
+	repo, err := l.local.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+
+	blobs := repo.Blobs(ctx)
+	config, err := blobs.Put(ctx, MediaTypeStatement, payload)
+	if err != nil {
+		return err
+	}
+	layer, err := blobs.Put(ctx, MediaTypeRekorEntry, entry.raw)
+	if err != nil {
+		return err
+	}
+
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config:    config,
+		Layers:    []distribution.Descriptor{layer},
+		Subject:   &subject,
+		Annotations: map[string]string{
+			AnnotationRekorURL:      l.rekor.url,
+			AnnotationRekorUUID:     entry.uuid,
+			AnnotationRekorLogIndex: strconv.FormatInt(entry.LogIndex, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	dgst, err := manifests.Put(ctx, m)
+	if err != nil {
+		return err
+	}
+	mediaType, canonical, err := m.Payload()
+	if err != nil {
+		return err
+	}
+	return repo.Tags(ctx).Tag(ctx, ProofTag(subject.Digest), distribution.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(canonical)),
+		Digest:    dgst,
+	})
+}
+
+// loadSigningKey loads the PEM encoded ECDSA private key, in either PKCS #8
+// or SEC 1 form.
+func loadSigningKey(filename string) (*ecdsa.PrivateKey, error) {
+	if filename == "" {
+		return nil, errors.New("no signing key configured")
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in signing key %s", filename)
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s is not an ECDSA key", filename)
+		}
+		return ecKey, nil
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse signing key %s: %v", filename, err)
+	}
+	return key, nil
+}
+
+// isManifest returns true if the media type is that of a manifest.
+func isManifest(mediaType string) bool {
+	for _, mt := range distribution.ManifestMediaTypes() {
+		if mt == mediaType {
+			return true
+		}
+	}
+	return false
+}
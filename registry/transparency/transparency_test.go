@@ -0,0 +1,273 @@
+package transparency_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/handlers"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+	"github.com/docker/distribution/registry/transparency"
+	"github.com/opencontainers/go-digest"
+)
+
+// rekorEntry is a hashedrekord entry, as submitted to Rekor.
+type rekorEntry struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// fakeRekor verifies the signatures of the submitted entries and logs them,
+// failing the first submission with a server error.
+type fakeRekor struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	entries []rekorEntry
+	failed  bool
+}
+
+func (f *fakeRekor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v1/log/entries" {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.failed {
+		f.failed = true
+		http.Error(w, `{"message":"unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var entry rekorEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verifyEntry(entry); err != nil {
+		f.t.Errorf("invalid entry: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.entries = append(f.entries, entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"24296fb24b8ad77a%048d": {"logIndex": %d, "integratedTime": %d, "verification": {"inclusionProof": {"logIndex": %d, "treeSize": %d, "hashes": []}}}}`,
+		len(f.entries), len(f.entries)-1, time.Now().Unix(), len(f.entries)-1, len(f.entries))
+}
+
+func verifyEntry(entry rekorEntry) error {
+	if entry.Kind != "hashedrekord" || entry.Spec.Data.Hash.Algorithm != "sha256" {
+		return fmt.Errorf("unexpected entry %s", entry.Kind)
+	}
+	hash, err := hex.DecodeString(entry.Spec.Data.Hash.Value)
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(entry.Spec.Signature.Content)
+	if err != nil {
+		return err
+	}
+	publicKeyPEM, err := base64.StdEncoding.DecodeString(entry.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return fmt.Errorf("no PEM public key")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	if !ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), hash, signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func writeSigningKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// pushImage pushes an image with a single layer under the tag, returning
+// its digest.
+func pushImage(t *testing.T, repo distribution.Repository, tag string) digest.Digest {
+	t.Helper()
+	ctx := context.Background()
+
+	blobs := repo.Blobs(ctx)
+	config, err := blobs.Put(ctx, schema2.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		t.Fatalf("unexpected error pushing config: %v", err)
+	}
+	layer, err := blobs.Put(ctx, schema2.MediaTypeLayer, []byte("layer"))
+	if err != nil {
+		t.Fatalf("unexpected error pushing layer: %v", err)
+	}
+
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    config,
+		Layers:    []distribution.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, m, distribution.WithTag(tag))
+	if err != nil {
+		t.Fatalf("unexpected error pushing manifest: %v", err)
+	}
+	return dgst
+}
+
+func TestTransparency(t *testing.T) {
+	rekor := &fakeRekor{t: t}
+	rekorServer := httptest.NewServer(rekor)
+	defer rekorServer.Close()
+
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{
+		"inmemory": map[string]interface{}{},
+	}
+	config.Transparency = configuration.Transparency{
+		Rekor:        rekorServer.URL,
+		SigningKey:   writeSigningKey(t),
+		Repositories: []string{"foo/*"},
+	}
+	server := httptest.NewServer(handlers.NewApp(context.Background(), config))
+	defer server.Close()
+
+	ctx := context.Background()
+	newRepository := func(name string) distribution.Repository {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := client.NewRepository(named, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+
+	// pushes to other repositories are not logged
+	pushImage(t, newRepository("other"), "latest")
+
+	repo := newRepository("foo/bar")
+	dgst := pushImage(t, repo, "latest")
+
+	var proof distribution.Descriptor
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		desc, err := repo.Tags(ctx).Get(ctx, transparency.ProofTag(dgst))
+		if err == nil {
+			proof = desc
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the proof: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifests.Get(ctx, proof.Digest)
+	if err != nil {
+		t.Fatalf("unexpected error fetching proof: %v", err)
+	}
+	artifact, ok := m.(*ocischema.DeserializedManifest)
+	if !ok {
+		t.Fatalf("unexpected proof manifest type: %T", m)
+	}
+	if artifact.Subject == nil || artifact.Subject.Digest != dgst {
+		t.Fatalf("unexpected proof subject: %v", artifact.Subject)
+	}
+	if artifact.Annotations[transparency.AnnotationRekorLogIndex] != "0" {
+		t.Fatalf("unexpected proof annotations: %v", artifact.Annotations)
+	}
+
+	statement, err := repo.Blobs(ctx).Get(ctx, artifact.Config.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s transparency.Statement
+	if err := json.Unmarshal(statement, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Digest != dgst || s.Repository != "foo/bar" {
+		t.Fatalf("unexpected statement: %+v", s)
+	}
+
+	entry, err := repo.Blobs(ctx).Get(ctx, artifact.Layers[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(entry), "inclusionProof") {
+		t.Fatalf("expected the entry to hold its inclusion proof: %s", entry)
+	}
+
+	rekor.mu.Lock()
+	defer rekor.mu.Unlock()
+	if len(rekor.entries) != 1 {
+		t.Fatalf("unexpected number of logged entries: %d", len(rekor.entries))
+	}
+}
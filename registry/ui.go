@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/handlers"
+)
+
+// defaultUIPath is the path prefix of the web UI if none is configured.
+const defaultUIPath = "/ui/"
+
+// serveUI serves the read-only web UI of app under the configured path on
+// the main listener, passing all other requests to the handler.
+func serveUI(config *configuration.Configuration, app *handlers.App, handler http.Handler) (http.Handler, error) {
+	if !config.UI.Enabled {
+		return handler, nil
+	}
+
+	prefix := config.UI.Path
+	if prefix == "" {
+		prefix = defaultUIPath
+	}
+	if !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") {
+		return nil, fmt.Errorf("ui: path %q must begin and end with /", prefix)
+	}
+	if prefix == "/" || strings.HasPrefix(prefix, "/v2/") || strings.HasPrefix(prefix, adminPathPrefix) {
+		return nil, fmt.Errorf("ui: path %q conflicts with the registry API", prefix)
+	}
+	uiHandler := app.UIHandler(prefix)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == strings.TrimSuffix(prefix, "/"):
+			http.Redirect(w, r, prefix, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, prefix):
+			uiHandler.ServeHTTP(w, r)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}), nil
+}
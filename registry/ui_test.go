@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/handlers"
+)
+
+func TestServeUI(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+	config.UI.Enabled = true
+	config.UI.Path = "/browse/"
+
+	app := handlers.NewApp(context.Background(), config)
+	handler, err := serveUI(config, app, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error configuring web UI: %v", err)
+	}
+
+	for _, tc := range []struct {
+		path     string
+		expected int
+	}{
+		{path: "/v2/", expected: http.StatusTeapot},
+		{path: "/ui/", expected: http.StatusTeapot},
+		{path: "/browse", expected: http.StatusMovedPermanently},
+		{path: "/browse/", expected: http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		if rec.Code != tc.expected {
+			t.Errorf("unexpected status for %s: %d != %d", tc.path, rec.Code, tc.expected)
+		}
+	}
+}
+
+func TestServeUIInvalidPath(t *testing.T) {
+	for _, path := range []string{"ui/", "/ui", "/", "/v2/ui/", "/admin/ui/"} {
+		config := &configuration.Configuration{}
+		config.Storage = map[string]configuration.Parameters{"inmemory": map[string]interface{}{}}
+		config.UI.Enabled = true
+		config.UI.Path = path
+
+		app := handlers.NewApp(context.Background(), config)
+		if _, err := serveUI(config, app, http.NotFoundHandler()); err == nil {
+			t.Errorf("expected an error serving the web UI under %q", path)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Info describes the build of the running binary.
+type Info struct {
+	// Package is the canonical import path of the project.
+	Package string `json:"package"`
+	// Version is the version of the binary.
+	Version string `json:"version"`
+	// Revision is the VCS revision the binary was built from, if known.
+	Revision string `json:"revision,omitempty"`
+	// GoVersion is the version of Go the binary was built with.
+	GoVersion string `json:"goVersion"`
+	// Platform is the operating system and architecture of the binary.
+	Platform string `json:"platform"`
+	// BuildTags lists the build tags the binary was built with.
+	BuildTags []string `json:"buildTags,omitempty"`
+	// StorageDrivers lists the storage drivers available in the binary. It
+	// is left for the caller to fill.
+	StorageDrivers []string `json:"storageDrivers,omitempty"`
+}
+
+// GetInfo returns the build information of the running binary. The revision
+// falls back to the VCS revision recorded by the Go toolchain if it was not
+// set at link time.
+func GetInfo() Info {
+	info := Info{
+		Package:   Package,
+		Version:   Version,
+		Revision:  Revision,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "-tags":
+				if setting.Value != "" {
+					info.BuildTags = strings.Split(setting.Value, ",")
+				}
+			case "vcs.revision":
+				if info.Revision == "" {
+					info.Revision = setting.Value
+				}
+			}
+		}
+	}
+
+	return info
+}